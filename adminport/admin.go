@@ -25,6 +25,8 @@ var ErrorInvalidRequest = errors.New("Invalid http request")
 
 var ErrorInvalidServerType = errors.New("Invalid http server type for handler")
 
+var ErrorInvalidClientCA = errors.New("No valid certificate found in client CA file")
+
 // Server API for adminport
 type Server interface {
 
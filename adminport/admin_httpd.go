@@ -37,9 +37,12 @@
 package adminport
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	base "github.com/couchbase/goxdcr/base"
 	"github.com/couchbase/goxdcr/log"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"sync"
@@ -80,12 +83,47 @@ func NewHTTPServer(name, connAddr, urlPrefix string, reqch chan<- Request, handl
 	return s
 }
 
+// NewHTTPSServer creates an instance of admin-server that serves https with
+// tlsConfig. Start() will actually start the server.
+func NewHTTPSServer(name, connAddr, urlPrefix string, reqch chan<- Request, handler RequestHandler, tlsConfig *tls.Config) Server {
+	s := NewHTTPServer(name, connAddr, urlPrefix, reqch, handler).(*httpServer)
+	s.srv.TLSConfig = tlsConfig
+	return s
+}
+
+// NewTLSConfig loads the certificate and key of admin-server from files. When
+// clientCAFile is specified, clients are required to present certificates
+// signed by one of the CAs in it.
+func NewTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if clientCAFile != "" {
+		clientCAs, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(clientCAs) {
+			return nil, ErrorInvalidClientCA
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
 // Start is part of Server interface.
 func (s *httpServer) Start() (err error) {
 
 	if s.lis, err = net.Listen("tcp", s.srv.Addr); err != nil {
 		return err
 	}
+	if s.srv.TLSConfig != nil {
+		s.lis = tls.NewListener(s.lis, s.srv.TLSConfig)
+	}
 
 	// Server routine
 	go func() {
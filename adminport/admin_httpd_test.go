@@ -0,0 +1,182 @@
+package adminport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// generates a certificate signed by parent, or a self signed CA certificate when parent is nil,
+// and writes it and its key to files in dir
+func newTestCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key. err=%v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent = template
+		parentKey = key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate. err=%v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate. err=%v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key. err=%v", err)
+	}
+
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+".key")
+	if err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to write certificate. err=%v", err)
+	}
+	if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatalf("Failed to write key. err=%v", err)
+	}
+	return cert, key, certFile, keyFile
+}
+
+// starts an https server that responds to all requests with StatusOK, and returns its address
+func startTestHTTPSServer(t *testing.T, urlPrefix string, tlsConfig *tls.Config) (*httpServer, string) {
+	reqch := make(chan Request)
+	server := NewHTTPSServer("test", "127.0.0.1:0", urlPrefix, reqch, new(Handler), tlsConfig).(*httpServer)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start https server. err=%v", err)
+	}
+	addr := server.lis.Addr().String()
+
+	go func() {
+		for req := range reqch {
+			req.Send(&Response{StatusCode: http.StatusOK, Body: []byte("{}")})
+		}
+	}()
+	return server, addr
+}
+
+func newTestHTTPSClient(caCert *x509.Certificate, clientCerts []tls.Certificate) *http.Client {
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(caCert)
+	return &http.Client{Timeout: 5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: rootCAs, Certificates: clientCerts}}}
+}
+
+func TestHTTPSServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "adminport_tls")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir. err=%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	caCert, caKey, _, _ := newTestCert(t, dir, "ca", nil, nil)
+	_, _, certFile, keyFile := newTestCert(t, dir, "server", caCert, caKey)
+
+	tlsConfig, err := NewTLSConfig(certFile, keyFile, "")
+	if err != nil {
+		t.Fatalf("Failed to create tls config. err=%v", err)
+	}
+	server, addr := startTestHTTPSServer(t, "/httpsTest/", tlsConfig)
+	defer server.Stop()
+
+	resp, err := newTestHTTPSClient(caCert, nil).Get("https://" + addr + "/httpsTest/")
+	if err != nil {
+		t.Fatalf("Failed to send https request. err=%v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Response status is %v, expected %v", resp.StatusCode, http.StatusOK)
+	}
+
+	// plain http requests are not served
+	resp, err = (&http.Client{Timeout: 5 * time.Second}).Get("http://" + addr + "/httpsTest/")
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			t.Errorf("Plain http request was served by https server")
+		}
+	}
+}
+
+func TestHTTPSServerWithClientCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "adminport_mtls")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir. err=%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	caCert, caKey, caFile, _ := newTestCert(t, dir, "ca", nil, nil)
+	_, _, certFile, keyFile := newTestCert(t, dir, "server", caCert, caKey)
+	_, _, clientCertFile, clientKeyFile := newTestCert(t, dir, "client", caCert, caKey)
+
+	tlsConfig, err := NewTLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("Failed to create tls config. err=%v", err)
+	}
+	server, addr := startTestHTTPSServer(t, "/mtlsTest/", tlsConfig)
+	defer server.Stop()
+
+	// client without certificate is rejected
+	resp, err := newTestHTTPSClient(caCert, nil).Get("https://" + addr + "/mtlsTest/")
+	if err == nil {
+		resp.Body.Close()
+		t.Errorf("Request without client certificate was served")
+	}
+
+	clientCert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	if err != nil {
+		t.Fatalf("Failed to load client certificate. err=%v", err)
+	}
+	resp, err = newTestHTTPSClient(caCert, []tls.Certificate{clientCert}).Get("https://" + addr + "/mtlsTest/")
+	if err != nil {
+		t.Fatalf("Failed to send request with client certificate. err=%v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Response status is %v, expected %v", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestNewTLSConfigWithInvalidClientCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "adminport_tls")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir. err=%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, _, certFile, keyFile := newTestCert(t, dir, "server", nil, nil)
+	caFile := filepath.Join(dir, "invalid_ca.pem")
+	if err = ioutil.WriteFile(caFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatalf("Failed to write client CA file. err=%v", err)
+	}
+
+	if _, err = NewTLSConfig(certFile, keyFile, caFile); err != ErrorInvalidClientCA {
+		t.Errorf("Error is %v, expected %v", err, ErrorInvalidClientCA)
+	}
+}
@@ -23,6 +23,7 @@ var DefaultPoolName = "default"
 var AuditServicePoolName = "auditService"
 
 var LocalHostName = "127.0.0.1"
+var LocalHostNameStr = "localhost"
 
 // URL Paths for retrieving cluster info
 var PoolsPath = "/pools"
@@ -68,6 +69,10 @@ var BucketTypeKey = "bucketType"
 // URL related constants
 var UrlDelimiter = "/"
 var UrlPortNumberDelimiter = ":"
+var UrlSchemeDelimiter = "://"
+
+// default port of the rest api of couchbase server, which is used when hostname of remote cluster does not contain a port
+var DefaultAdminPort uint16 = 8091
 
 // http request method types
 const (
@@ -100,6 +105,10 @@ var DefaultHttpTimeout = 180 * time.Second
 // to be shorter than that of the first one, which is currently 30 seconds.
 var ShortHttpTimeout = 20 * time.Second
 
+// when the clocks of source and target clusters of a LWW replication differ by more than this,
+// a warning is raised when the replication is created, since conflict resolution outcomes may be wrong
+var MaxClockSkewForLWW = 5 * time.Second
+
 //outgoing nozzle type
 type XDCROutgoingNozzleType int
 
@@ -156,13 +165,26 @@ const (
 	RemoteClusterDeleted          = "deleted"
 	IsEnterprise                  = "isEnterprise"
 	Pools                         = "pools"
+
+	// when set, remote cluster references are created or changed without checking that the remote cluster is reachable
+	RemoteClusterSkipConnectivityCheck = "skipConnectivityCheck"
+	// when set, remote cluster references are deleted even when there are replications to the remote clusters
+	RemoteClusterForceDelete = "force"
+	// read-only remote clusters can be used for validation and monitoring, but cannot be the targets of replications
+	RemoteClusterReadOnly = "readOnly"
 )
 
 // constants used for create replication request
 const (
-	FromBucket = "fromBucket"
-	ToCluster  = "toCluster"
-	ToBucket   = "toBucket"
+	FromBucket       = "fromBucket"
+	ToCluster        = "toCluster"
+	ToBucket         = "toBucket"
+	FilterExpression = "filterExpression"
+	FilterKeyPrefix  = "filterKeyPrefix"
+	// when set, creating a replication that already exists returns the id of the existing replication instead of an error
+	CreateOrGet = "create_or_get"
+	// name of a registered filter, whose expression is used when filter expression is not specified
+	FilterName = "filterName"
 )
 
 // constant used by more than one rest apis
@@ -185,6 +207,7 @@ const (
 	DefaultContentType = "application/x-www-form-urlencoded"
 	JsonContentType    = "application/json"
 	ContentLength      = "Content-Length"
+	DateHeaderKey      = "Date"
 )
 
 //constant for replication tasklist status
@@ -220,6 +243,10 @@ var CouchApiBaseUriDelimiter = "%2f"
 
 var XDCR_EXPVAR_ROOT = "XDCR_Replications"
 
+// prefix of the keys of canary documents, which are written to source buckets to measure replication latency.
+// a canary document is replicated only by the replication that writes it, which can exclude it with filter expressions
+var CanaryKeyPrefix = "_xdcr_canary_"
+
 //constants for replication docs
 const (
 	RemoteClustersForReplicationDoc = "remoteClusters"
@@ -247,6 +274,51 @@ const (
 	SET_WITH_META    = mc.CommandCode(0xa2)
 	DELETE_WITH_META = mc.CommandCode(0xa8)
 	SET_TIME_SYNC    = mc.CommandCode(0xc1)
+	HELLO            = mc.CommandCode(0x1f)
+)
+
+// features negotiated with memcached through HELLO
+const (
+	HELLO_FEATURE_XATTR            = uint16(0x06)
+	HELLO_FEATURE_SNAPPY           = uint16(0x0a)
+	HELLO_FEATURE_JSON             = uint16(0x0b)
+	HELLO_FEATURE_ALT_REQUEST      = uint16(0x10)
+	HELLO_FEATURE_SYNC_REPLICATION = uint16(0x11)
+)
+
+// names of HELLO features, which are used in settings and error messages
+var HelloFeatureNames = map[uint16]string{
+	HELLO_FEATURE_XATTR:            "xattr",
+	HELLO_FEATURE_SNAPPY:           "snappy",
+	HELLO_FEATURE_JSON:             "json",
+	HELLO_FEATURE_ALT_REQUEST:      "alt_request",
+	HELLO_FEATURE_SYNC_REPLICATION: "sync_replication",
+}
+
+// datatype bit of requests whose bodies are compressed with snappy
+const SnappyDataType = uint8(0x02)
+
+// magic of requests with framing extras, e.g., durability requirements
+const ALT_REQ_MAGIC = 0x08
+
+// id of the framing extras that carry the durability requirement of a request
+const DURABILITY_FRAME_ID = 0x01
+
+// durability levels in durability requirements of requests
+const (
+	DurabilityLevelNone                     = byte(0x00)
+	DurabilityLevelMajority                 = byte(0x01)
+	DurabilityLevelMajorityAndPersistActive = byte(0x02)
+	DurabilityLevelPersistToMajority        = byte(0x03)
+)
+
+// memcached response statuses specific to durable writes
+const (
+	DURABILITY_INVALID_LEVEL        = mc.Status(0xa0)
+	DURABILITY_IMPOSSIBLE           = mc.Status(0xa1)
+	SYNC_WRITE_IN_PROGRESS          = mc.Status(0xa2)
+	SYNC_WRITE_AMBIGUOUS            = mc.Status(0xa3)
+	SYNC_WRITE_RECOMMIT_IN_PROGRESS = mc.Status(0xa4)
 )
 
 const (
@@ -270,6 +342,8 @@ const (
 	DataSentEventListener        = "DataSentEventListener"
 	DataFailedCREventListener    = "DataFailedCREventListener"
 	GetMetaReceivedEventListener = "GetMetaReceivedEventListener"
+	// for the documents dropped by the transformers of target nozzles
+	DataDroppedEventListener = "DataDroppedEventListener"
 )
 
 const (
@@ -287,6 +361,8 @@ const (
 	ProblematicVBSource = "ProblematicVBSource"
 	ProblematicVBTarget = "ProblematicVBTarget"
 	VBTimestamps        = "VBTimestamps"
+	// vbs that keep failing on target and are no longer replicated until the pipeline is restarted
+	IsolatedVBs = "IsolatedVBs"
 )
 
 // version of extended metadata to look for
@@ -349,13 +425,45 @@ var MaxWorkersForCheckpointing = 5
 // capi nozzle data chan size is defined as batchCount*CapiDataChanSizeMultiplier
 var CapiDataChanSizeMultiplier = 1
 
+// the number of samples of key statistics to retain per replication. 0 disables statistics history
+var StatsHistorySize = 0
+
+// whether bucket uuids are always retrieved through direct rest calls, bypassing the bucket uuids cached by cluster info service.
+// for debugging only
+var ForceDirectBucketUUIDLookup = false
+
+// the number of settings changes to retain per replication. 0 disables settings history
+var SettingsHistoryDepth = 20
+
+// the max time to wait for the vbuckets of source bucket to become available when pipeline is started
+var SourceBucketWarmupTimeout = 120 * time.Second
+
+// the max number of attempts to look up a bucket on a remote cluster when validating replications
+var RemoteBucketLookupMaxAttempts = 5
+
+// the delay before the first retry of a remote bucket lookup. it doubles with each retry
+var RemoteBucketLookupBaseBackoff = 200 * time.Millisecond
+
+// whether replications between buckets with different conflict resolution types are allowed, with a warning
+var AllowConflictResolutionTypeMismatch = false
+
 func InitConstants(topologyChangeCheckInterval time.Duration, maxTopologyChangeCountBeforeRestart,
 	maxTopologyStableCountBeforeRestart, maxWorkersForCheckpointing int,
-	timeoutCheckpointBeforeStop time.Duration, capiDataChanSizeMultiplier int) {
+	timeoutCheckpointBeforeStop time.Duration, capiDataChanSizeMultiplier int, statsHistorySize int,
+	forceDirectBucketUUIDLookup bool, settingsHistoryDepth int, sourceBucketWarmupTimeout time.Duration,
+	remoteBucketLookupMaxAttempts int, remoteBucketLookupBaseBackoff time.Duration,
+	allowConflictResolutionTypeMismatch bool) {
 	TopologyChangeCheckInterval = topologyChangeCheckInterval
 	MaxTopologyChangeCountBeforeRestart = maxTopologyChangeCountBeforeRestart
 	MaxTopologyStableCountBeforeRestart = maxTopologyStableCountBeforeRestart
 	MaxWorkersForCheckpointing = maxWorkersForCheckpointing
 	TimeoutCheckpointBeforeStop = timeoutCheckpointBeforeStop
 	CapiDataChanSizeMultiplier = capiDataChanSizeMultiplier
+	StatsHistorySize = statsHistorySize
+	ForceDirectBucketUUIDLookup = forceDirectBucketUUIDLookup
+	SettingsHistoryDepth = settingsHistoryDepth
+	SourceBucketWarmupTimeout = sourceBucketWarmupTimeout
+	RemoteBucketLookupMaxAttempts = remoteBucketLookupMaxAttempts
+	RemoteBucketLookupBaseBackoff = remoteBucketLookupBaseBackoff
+	AllowConflictResolutionTypeMismatch = allowConflictResolutionTypeMismatch
 }
@@ -14,6 +14,7 @@ import (
 	"fmt"
 	"github.com/couchbase/gomemcached"
 	"reflect"
+	"sort"
 	"sync"
 	"time"
 )
@@ -29,6 +30,39 @@ func NewSettingDef(data_type reflect.Type, bReq bool) *SettingDef {
 
 type SettingDefinitions map[string]*SettingDef
 
+// serializable description of a setting, for clients that introspect the settings that a component accepts
+type SettingSchema struct {
+	Name string `json:"name"`
+	// go type of the value of the setting
+	Type     string      `json:"type"`
+	Default  interface{} `json:"default"`
+	Required bool        `json:"required"`
+}
+
+// describes the settings in defs, sorted by name. defaults contains the default values of the settings that have one
+func (defs SettingDefinitions) Schema(defaults map[string]interface{}) []*SettingSchema {
+	names := make([]string, 0, len(defs))
+	for name, _ := range defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	schema := make([]*SettingSchema, 0, len(defs))
+	for _, name := range names {
+		def := defs[name]
+		// types in setting definitions are pointers to the types of setting values
+		data_type := def.Data_type
+		if data_type.Kind() == reflect.Ptr {
+			data_type = data_type.Elem()
+		}
+		schema = append(schema, &SettingSchema{Name: name,
+			Type:     data_type.String(),
+			Default:  defaults[name],
+			Required: def.Required})
+	}
+	return schema
+}
+
 type SettingsError struct {
 	err_map map[string]error
 }
@@ -73,6 +107,15 @@ type ReplicationInfo struct {
 	Id        string
 	StatsMap  map[string]interface{}
 	ErrorList []ErrorInfo
+	// lifecycle state of the replication, e.g., Created, Running, Paused
+	State string
+}
+
+// a sample of a statistic taken at a point in time
+type TimedSample struct {
+	// Time is the number of nano seconds elapsed since 1/1/1970 UTC
+	Time  int64
+	Value float64
 }
 
 type ErrorInfo struct {
@@ -172,6 +215,8 @@ const (
 	VBErrorType_Source VBErrorType = iota
 	// vb error caused by target topology change
 	VBErrorType_Target VBErrorType = iota
+	// vb that keeps failing on target and has been isolated so that other vbs can make progress
+	VBErrorType_Isolated VBErrorType = iota
 )
 
 type VBErrorEventAdditional struct {
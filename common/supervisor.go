@@ -31,9 +31,21 @@ type Supervisable interface {
 	IsReadyForHeartBeat() bool
 	HeartBeat_sync() bool
 	HeartBeat_async(respchan chan []interface{}, timestamp time.Time) error
+	// finishes in-flight work before the supervisor stops. it blocks until the work is done.
+	// components without in-flight work can use the no-op implementation in GenServer
+	Drain() error
 }
 
-// Handler for failures reported by Supervisor
+// optional for Supervisables that have to be stopped after some other children of the same supervisor,
+// e.g., the downstream nozzles of a router, which are stopped after the router
+type StopDependent interface {
+	// ids of the children that are to be stopped before this one
+	StopDependencies() []string
+}
+
+// Handler for failures reported by Supervisor.
+// children that have failed heart beats are still children of the supervisor when OnError is called,
+// and are removed after it returns
 type SupervisorFailureHandler interface {
 	OnError(supervisor Supervisor, errors map[string]error)
 }
@@ -105,6 +105,14 @@ func (xdcrf *XDCRFactory) NewPipeline(topic string, progress_recorder common.Pip
 	sourceBucketPassword := sourceBucket.Password
 	sourceBucket.Close()
 
+	// source bucket may still be warming up, e.g., right after it is created or after a node restart
+	err = pipeline_utils.WaitForSourceBucketReady(spec.SourceBucketName, func() (map[string][]uint16, error) {
+		return xdcrf.cluster_info_svc.GetServerVBucketsMap(xdcrf.xdcr_topology_svc, spec.SourceBucketName)
+	}, base.SourceBucketWarmupTimeout, xdcrf.logger)
+	if err != nil {
+		return nil, err
+	}
+
 	targetClusterRef, err := xdcrf.remote_cluster_svc.RemoteClusterByUuid(spec.TargetClusterUUID, true)
 	if err != nil {
 		xdcrf.logger.Errorf("Error getting remote cluster with uuid=%v for pipeline %v, err=%v\n", spec.TargetClusterUUID, spec.Id, err)
@@ -174,6 +182,10 @@ func (xdcrf *XDCRFactory) NewPipeline(topic string, progress_recorder common.Pip
 			}
 			downStreamParts[targetNozzleId] = outNozzle
 		}
+		if spec.Settings.BalanceMode == metadata.BalanceModeLeastLoaded {
+			// vbuckets may be routed to any xmem nozzle to the same target node
+			addSiblingXmemNozzles(downStreamParts, outNozzles)
+		}
 
 		router, err := xdcrf.constructRouter(sourceNozzle.Id(), spec, downStreamParts, vbNozzleMap, sourceCRMode, logger_ctx)
 		if err != nil {
@@ -273,16 +285,33 @@ func (xdcrf *XDCRFactory) registerAsyncListenersOnTargets(pipeline common.Pipeli
 		get_meta_received_event_listener := component.NewDefaultAsyncComponentEventListenerImpl(
 			pipeline_utils.GetElementIdFromNameAndIndex(pipeline, base.GetMetaReceivedEventListener, i),
 			pipeline.Topic(), logger_ctx)
+		data_dropped_event_listener := component.NewDefaultAsyncComponentEventListenerImpl(
+			pipeline_utils.GetElementIdFromNameAndIndex(pipeline, base.DataDroppedEventListener, i),
+			pipeline.Topic(), logger_ctx)
 
 		for index := load_distribution[i][0]; index < load_distribution[i][1]; index++ {
 			out_nozzle := targets[index]
 			out_nozzle.RegisterComponentEventListener(common.DataSent, data_sent_event_listener)
 			out_nozzle.RegisterComponentEventListener(common.DataFailedCRSource, data_failed_cr_event_listener)
 			out_nozzle.RegisterComponentEventListener(common.GetMetaReceived, get_meta_received_event_listener)
+			out_nozzle.RegisterComponentEventListener(common.DataFiltered, data_dropped_event_listener)
 		}
 	}
 }
 
+// settings that nozzle counts are taken from, which differ from replication settings when the replication is boosted
+func (xdcrf *XDCRFactory) effectiveSettings(spec *metadata.ReplicationSpecification) *metadata.ReplicationSettings {
+	settings, boost, err := pipeline_manager.EffectiveSettings(spec.Id)
+	if err != nil {
+		return spec.Settings
+	}
+	if boost != nil {
+		xdcrf.logger.Infof("%v is boosted by %v until %v. source nozzle per node=%v, target nozzle per node=%v\n",
+			spec.Id, boost.Multiplier, boost.Expiry, settings.SourceNozzlePerNode, settings.TargetNozzlePerNode)
+	}
+	return settings
+}
+
 // construct source nozzles for the requested/current kv node
 func (xdcrf *XDCRFactory) constructSourceNozzles(spec *metadata.ReplicationSpecification,
 	topic string,
@@ -292,7 +321,7 @@ func (xdcrf *XDCRFactory) constructSourceNozzles(spec *metadata.ReplicationSpeci
 
 	bucketName := spec.SourceBucketName
 
-	maxNozzlesPerNode := spec.Settings.SourceNozzlePerNode
+	maxNozzlesPerNode := xdcrf.effectiveSettings(spec).SourceNozzlePerNode
 
 	kv_vb_map, err := pipeline_utils.GetSourceVBMap(xdcrf.cluster_info_svc, xdcrf.xdcr_topology_svc, bucketName, xdcrf.logger)
 	if err != nil {
@@ -376,12 +405,21 @@ func (xdcrf *XDCRFactory) constructOutgoingNozzles(spec *metadata.ReplicationSpe
 	}
 	bucketPwd, ok := bucketPwdObj.(string)
 	if !ok {
-		return nil, nil, fmt.Errorf("%v sasl password on target bucket is of wrong type.", spec.Id, bucketPwdObj)
+		return nil, nil, fmt.Errorf("%v sasl password on target bucket is of wrong type, %T.", spec.Id, bucketPwdObj)
 	}
 
-	maxTargetNozzlePerNode := spec.Settings.TargetNozzlePerNode
+	maxTargetNozzlePerNode := xdcrf.effectiveSettings(spec).TargetNozzlePerNode
 	xdcrf.logger.Infof("Target topology retrieved. kvVBMap = %v\n", kvVBMap)
 
+	targetNodeAllowlist := spec.Settings.TargetNodeAllowlist
+	if len(targetNodeAllowlist) > 0 {
+		err = xdcrf.validateTargetNodeAllowlist(spec, targetNodeAllowlist, kvVBMap, kv_vb_map)
+		if err != nil {
+			xdcrf.logger.Errorf("%v\n", err)
+			return nil, nil, err
+		}
+	}
+
 	var vbCouchApiBaseMap map[uint16]string
 
 	nozzleType, err := xdcrf.getOutNozzleType(targetClusterRef, spec)
@@ -391,6 +429,12 @@ func (xdcrf *XDCRFactory) constructOutgoingNozzles(spec *metadata.ReplicationSpe
 	}
 
 	for kvaddr, kvVBList := range kvVBMap {
+		if len(targetNodeAllowlist) > 0 && !isTargetNodeAllowed(kvaddr, targetNodeAllowlist) {
+			// validateTargetNodeAllowlist has ensured that the node does not own any vbuckets relevant to the replication
+			xdcrf.logger.Infof("%v skipped target node %v since it is not in target node allowlist\n", spec.Id, kvaddr)
+			continue
+		}
+
 		isCapiNozzle := (nozzleType == base.Capi)
 		if isCapiNozzle && len(vbCouchApiBaseMap) == 0 {
 			// construct vbCouchApiBaseMap only when nessary and only once
@@ -447,13 +491,69 @@ func (xdcrf *XDCRFactory) constructOutgoingNozzles(spec *metadata.ReplicationSpe
 	return outNozzles, vbNozzleMap, nil
 }
 
+// checks that all nodes in target node allowlist are target kv nodes, and that all target vbuckets
+// that the replication needs to write to live on allowed nodes
+func (xdcrf *XDCRFactory) validateTargetNodeAllowlist(spec *metadata.ReplicationSpecification, targetNodeAllowlist []string,
+	kvVBMap map[string][]uint16, kv_vb_map map[string][]uint16) error {
+	for _, allowedNode := range targetNodeAllowlist {
+		found := false
+		for kvaddr, _ := range kvVBMap {
+			if isTargetNodeAllowed(kvaddr, []string{allowedNode}) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("Invalid configuration for %v. Node %v in target node allowlist is not a kv node of target bucket %v", spec.Id, allowedNode, spec.TargetBucketName)
+		}
+	}
+
+	for kvaddr, kvVBList := range kvVBMap {
+		if isTargetNodeAllowed(kvaddr, targetNodeAllowlist) {
+			continue
+		}
+		relevantVBs := xdcrf.filterVBList(kvVBList, kv_vb_map)
+		if len(relevantVBs) > 0 {
+			return fmt.Errorf("Invalid configuration for %v. Target vbuckets %v live on node %v, which is not in target node allowlist %v", spec.Id, relevantVBs, kvaddr, targetNodeAllowlist)
+		}
+	}
+	return nil
+}
+
+// whether target kv node, in the form of host:port, matches any entry in target node allowlist.
+// an entry matches when it is either the same host:port or the same host
+func isTargetNodeAllowed(kvaddr string, targetNodeAllowlist []string) bool {
+	hostName := utils.GetHostName(kvaddr)
+	for _, allowedNode := range targetNodeAllowlist {
+		if allowedNode == kvaddr || allowedNode == hostName {
+			return true
+		}
+	}
+	return false
+}
+
+// adds to downStreamParts the xmem nozzles that write to the same target nodes as the xmem nozzles already in downStreamParts
+func addSiblingXmemNozzles(downStreamParts map[string]common.Part, outNozzles map[string]common.Nozzle) {
+	connStrs := make(map[string]bool)
+	for _, part := range downStreamParts {
+		if xmem, ok := part.(*parts.XmemNozzle); ok {
+			connStrs[xmem.ConnStr()] = true
+		}
+	}
+	for outNozzleId, outNozzle := range outNozzles {
+		if xmem, ok := outNozzle.(*parts.XmemNozzle); ok && connStrs[xmem.ConnStr()] {
+			downStreamParts[outNozzleId] = outNozzle
+		}
+	}
+}
+
 func (xdcrf *XDCRFactory) constructRouter(id string, spec *metadata.ReplicationSpecification,
 	downStreamParts map[string]common.Part,
 	vbNozzleMap map[uint16]string,
 	sourceCRMode base.ConflictResolutionMode,
 	logger_ctx *log.LoggerContext) (*parts.Router, error) {
 	routerId := "Router" + PART_NAME_DELIMITER + id
-	router, err := parts.NewRouter(routerId, spec.Id, spec.Settings.FilterExpression, downStreamParts, vbNozzleMap, sourceCRMode, logger_ctx, pipeline_manager.NewMCRequestObj)
+	router, err := parts.NewRouter(routerId, spec.Id, spec.Settings.FilterExpression, spec.Settings.FilterKeyPrefix, spec.Settings.ReplicateOps, spec.Settings.BalanceMode, downStreamParts, vbNozzleMap, sourceCRMode, logger_ctx, pipeline_manager.NewMCRequestObj)
 	xdcrf.logger.Infof("Constructed router %v", routerId)
 	return router, err
 }
@@ -608,6 +708,11 @@ func (xdcrf *XDCRFactory) constructSettingsForXmemNozzle(pipeline common.Pipelin
 	xmemSettings[parts.SETTING_BATCH_EXPIRATION_TIME] = time.Duration(float64(repSettings.MaxExpectedReplicationLag)*0.7) * time.Millisecond
 	xmemSettings[parts.SETTING_OPTI_REP_THRESHOLD] = getSettingFromSettingsMap(settings, metadata.OptimisticReplicationThreshold, repSettings.OptimisticReplicationThreshold)
 	xmemSettings[parts.SETTING_STATS_INTERVAL] = getSettingFromSettingsMap(settings, metadata.PipelineStatsInterval, repSettings.StatsInterval)
+	xmemSettings[parts.XMEM_SETTING_KEY_PREFIX] = repSettings.AddKeyPrefix
+	xmemSettings[parts.XMEM_SETTING_KEY_SUFFIX] = repSettings.AddKeySuffix
+	xmemSettings[parts.XMEM_SETTING_TARGET_DURABILITY] = repSettings.TargetDurability
+	xmemSettings[parts.XMEM_SETTING_COMPRESSION] = repSettings.CompressionType
+	xmemSettings[parts.XMEM_SETTING_BALANCE_MODE] = repSettings.BalanceMode
 
 	demandEncryption := targetClusterRef.DemandEncryption
 	certificate := targetClusterRef.Certificate
@@ -193,6 +193,11 @@ func (s *GenServer) HeartBeat_async(respchan chan []interface{}, timestamp time.
 	}
 }
 
+// no-op implementation of Supervisable.Drain, for servers that have no in-flight work to finish
+func (s *GenServer) Drain() error {
+	return nil
+}
+
 func (s *GenServer) Logger() *log.CommonLogger {
 	return s.logger
 }
@@ -10,14 +10,18 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
+	ap "github.com/couchbase/goxdcr/adminport"
 	base "github.com/couchbase/goxdcr/base"
 	log "github.com/couchbase/goxdcr/log"
 	"github.com/couchbase/goxdcr/metadata_svc"
 	rm "github.com/couchbase/goxdcr/replication_manager"
 	"github.com/couchbase/goxdcr/service_def"
 	"github.com/couchbase/goxdcr/service_impl"
+	"github.com/couchbase/goxdcr/utils"
 	"os"
 	"runtime"
 	"time"
@@ -28,6 +32,12 @@ var done = make(chan bool)
 var options struct {
 	sourceKVAdminPort uint64 //source kv admin port
 	xdcrRestPort      uint64 // port number of XDCR rest server
+	xdcrRestBindAddr  string // address that XDCR rest server binds to
+
+	// tls related parameters of XDCR rest server. it serves http when cert and key are not specified
+	xdcrRestCertFile     string
+	xdcrRestKeyFile      string
+	xdcrRestClientCAFile string // when specified, clients are required to present certificates signed by CA in it
 
 	sslProxyUpstreamPort uint64 // gometa request port
 	isEnterprise         bool   // whether couchbase is of enterprise edition
@@ -37,6 +47,9 @@ var options struct {
 	logFileDir          string
 	maxLogFileSize      uint64
 	maxNumberOfLogFiles uint64
+
+	// the number of workers that construct replication specs at startup. 0 means the number of cpus
+	specCacheLoadConcurrency uint64
 }
 
 var max_retry_wait_for_metadata_service = 30
@@ -47,6 +60,14 @@ func argParse() {
 		"admin port number for source kv")
 	flag.Uint64Var(&options.xdcrRestPort, "xdcrRestPort", uint64(base.AdminportNumber),
 		"port number of XDCR rest server")
+	flag.StringVar(&options.xdcrRestBindAddr, "xdcrRestBindAddr", base.LocalHostName,
+		"address that XDCR rest server binds to. needs to be an IP address or localhost")
+	flag.StringVar(&options.xdcrRestCertFile, "xdcrRestCertFile", "",
+		"certificate file for XDCR rest server to serve https")
+	flag.StringVar(&options.xdcrRestKeyFile, "xdcrRestKeyFile", "",
+		"key file for XDCR rest server to serve https")
+	flag.StringVar(&options.xdcrRestClientCAFile, "xdcrRestClientCAFile", "",
+		"CA file for XDCR rest server to verify client certificates with")
 	flag.Uint64Var(&options.sslProxyUpstreamPort, "localProxyPort", 0,
 		"port number for ssl proxy upstream port")
 	flag.BoolVar(&options.isEnterprise, "isEnterprise", true,
@@ -60,6 +81,8 @@ func argParse() {
 		"maximum log file size")
 	flag.Uint64Var(&options.maxNumberOfLogFiles, "maxNumberOfLogFiles", 5,
 		"maximum number of log files")
+	flag.Uint64Var(&options.specCacheLoadConcurrency, "specCacheLoadConcurrency", 0,
+		"number of workers that load replication specs at startup. 0 means the number of cpus")
 
 	flag.Parse()
 }
@@ -90,7 +113,20 @@ func main() {
 		os.Exit(1)
 	}
 
-	host := base.LocalHostName
+	host := options.xdcrRestBindAddr
+	err = utils.ValidateBindAddress(host)
+	if err != nil {
+		fmt.Printf("Error validating xdcr rest bind address. err=%v\n", err)
+		os.Exit(1)
+	}
+
+	adminportTLSConfig, err := getAdminportTLSConfig()
+	if err != nil {
+		fmt.Printf("Error loading xdcr rest server tls config. err=%v\n", err)
+		os.Exit(1)
+	}
+
+	metadata_svc.SpecCacheLoadConcurrency = int(options.specCacheLoadConcurrency)
 
 	metakv_svc, err := metadata_svc.NewMetaKVMetadataSvc(nil)
 	if err != nil {
@@ -155,6 +191,7 @@ func main() {
 		// start replication manager in normal mode
 		rm.StartReplicationManager(host,
 			uint16(options.xdcrRestPort),
+			adminportTLSConfig,
 			replication_spec_svc,
 			remote_cluster_svc,
 			cluster_info_svc,
@@ -166,13 +203,28 @@ func main() {
 			uilog_svc,
 			processSetting_svc,
 			bucketSettings_svc,
-			internalSettings_svc)
+			internalSettings_svc,
+			metakv_svc)
 
 		// keep main alive in normal mode
 		<-done
 	}
 }
 
+// returns nil when xdcr rest server is to serve http
+func getAdminportTLSConfig() (*tls.Config, error) {
+	if options.xdcrRestCertFile == "" && options.xdcrRestKeyFile == "" {
+		if options.xdcrRestClientCAFile != "" {
+			return nil, errors.New("xdcrRestClientCAFile cannot be specified without xdcrRestCertFile and xdcrRestKeyFile")
+		}
+		return nil, nil
+	}
+	if options.xdcrRestCertFile == "" || options.xdcrRestKeyFile == "" {
+		return nil, errors.New("xdcrRestCertFile and xdcrRestKeyFile need to be specified together")
+	}
+	return ap.NewTLSConfig(options.xdcrRestCertFile, options.xdcrRestKeyFile, options.xdcrRestClientCAFile)
+}
+
 // wait [for an upward of 30 seconds] for metadata service to become available
 func waitForMetadataService(metakv_svc service_def.MetadataSvc) error {
 	num_retry := 0
@@ -41,6 +41,9 @@ type GlobalSettings struct {
 	//a collection is triggered when the ratio of freshly allocated data to
 	//live data remaining after the previous collection reaches this percentage.
 	GoGC int `json:"goGC"`
+	// when set, all replications have been paused through the global switch and cannot be resumed individually.
+	// it is a negative flag so that settings persisted before its introduction are read as enabled
+	ReplicationsDisabled bool `json:"replicationsDisabled"`
 	// revision number to be used by metadata service. not included in json
 	Revision interface{}
 }
@@ -135,6 +138,8 @@ func (s *GlobalSettings) Clone() *GlobalSettings {
 
 	clone := &GlobalSettings{}
 	clone.UpdateSettingsFromMap(s.ToMap())
+	// not a user settable key, hence not included in ToMap()
+	clone.ReplicationsDisabled = s.ReplicationsDisabled
 	return clone
 }
 
@@ -142,5 +147,5 @@ func (s *GlobalSettings) String() string {
 	if s == nil {
 		return "nil"
 	}
-	return fmt.Sprintf("GoMaxProcs:%v, GoGC:%v", s.GoMaxProcs, s.GoGC)
+	return fmt.Sprintf("GoMaxProcs:%v, GoGC:%v, ReplicationsDisabled:%v", s.GoMaxProcs, s.GoGC, s.ReplicationsDisabled)
 }
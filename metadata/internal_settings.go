@@ -19,6 +19,13 @@ const (
 	MaxWorkersForCheckpointingKey          = "MaxWorkersForCheckpointing"
 	TimeoutCheckpointBeforeStopKey         = "TimeoutCheckpointBeforeStop"
 	CapiDataChanSizeMultiplierKey          = "CapiDataChanSizeMultiplier"
+	StatsHistorySizeKey                    = "StatsHistorySize"
+	ForceDirectBucketUUIDLookupKey         = "ForceDirectBucketUUIDLookup"
+	SettingsHistoryDepthKey                = "SettingsHistoryDepth"
+	SourceBucketWarmupTimeoutKey           = "SourceBucketWarmupTimeout"
+	RemoteBucketLookupMaxAttemptsKey       = "RemoteBucketLookupMaxAttempts"
+	RemoteBucketLookupBaseBackoffKey       = "RemoteBucketLookupBaseBackoff"
+	AllowConflictResolutionTypeMismatchKey = "AllowConflictResolutionTypeMismatch"
 )
 
 var TopologyChangeCheckIntervalConfig = &SettingsConfig{10, &Range{1, 100}}
@@ -27,6 +34,13 @@ var MaxTopologyStableCountBeforeRestartConfig = &SettingsConfig{30, &Range{1, 30
 var MaxWorkersForCheckpointingConfig = &SettingsConfig{5, &Range{1, 1000}}
 var TimeoutCheckpointBeforeStopConfig = &SettingsConfig{180, &Range{10, 1800}}
 var CapiDataChanSizeMultiplierConfig = &SettingsConfig{1, &Range{1, 100}}
+var StatsHistorySizeConfig = &SettingsConfig{0, &Range{0, 86400}}
+var ForceDirectBucketUUIDLookupConfig = &SettingsConfig{0, &Range{0, 1}}
+var SettingsHistoryDepthConfig = &SettingsConfig{20, &Range{0, 1000}}
+var SourceBucketWarmupTimeoutConfig = &SettingsConfig{120, &Range{0, 3600}}
+var RemoteBucketLookupMaxAttemptsConfig = &SettingsConfig{5, &Range{1, 20}}
+var RemoteBucketLookupBaseBackoffConfig = &SettingsConfig{200, &Range{0, 10000}}
+var AllowConflictResolutionTypeMismatchConfig = &SettingsConfig{0, &Range{0, 1}}
 
 var XDCRInternalSettingsConfigMap = map[string]*SettingsConfig{
 	TopologyChangeCheckIntervalKey:         TopologyChangeCheckIntervalConfig,
@@ -35,6 +49,13 @@ var XDCRInternalSettingsConfigMap = map[string]*SettingsConfig{
 	MaxWorkersForCheckpointingKey:          MaxWorkersForCheckpointingConfig,
 	TimeoutCheckpointBeforeStopKey:         TimeoutCheckpointBeforeStopConfig,
 	CapiDataChanSizeMultiplierKey:          CapiDataChanSizeMultiplierConfig,
+	StatsHistorySizeKey:                    StatsHistorySizeConfig,
+	ForceDirectBucketUUIDLookupKey:         ForceDirectBucketUUIDLookupConfig,
+	SettingsHistoryDepthKey:                SettingsHistoryDepthConfig,
+	SourceBucketWarmupTimeoutKey:           SourceBucketWarmupTimeoutConfig,
+	RemoteBucketLookupMaxAttemptsKey:       RemoteBucketLookupMaxAttemptsConfig,
+	RemoteBucketLookupBaseBackoffKey:       RemoteBucketLookupBaseBackoffConfig,
+	AllowConflictResolutionTypeMismatchKey: AllowConflictResolutionTypeMismatchConfig,
 }
 
 type InternalSettings struct {
@@ -54,6 +75,31 @@ type InternalSettings struct {
 	// capi nozzle data chan size is defined as batchCount*CapiDataChanSizeMultiplier
 	CapiDataChanSizeMultiplier int
 
+	// the number of samples of key statistics to retain per replication for post-incident analysis.
+	// one sample is taken each time statistics are updated. 0 disables statistics history
+	StatsHistorySize int
+
+	// 1 if bucket uuids are always retrieved through direct rest calls instead of from cluster info service, 0 otherwise.
+	// for debugging only
+	ForceDirectBucketUUIDLookup int
+
+	// the number of settings changes to retain per replication. 0 disables settings history
+	SettingsHistoryDepth int
+
+	// the max time (in seconds) to wait for the vbuckets of source bucket to become available when pipeline is started,
+	// e.g., when source bucket is still warming up. 0 means no wait
+	SourceBucketWarmupTimeout int
+
+	// the max number of attempts to look up a bucket on a remote cluster when validating replications.
+	// lookups that fail with errors other than the bucket not existing are retried with exponential backoff
+	RemoteBucketLookupMaxAttempts int
+	// the delay (in milliseconds) before the first retry of a remote bucket lookup. it doubles with each retry
+	RemoteBucketLookupBaseBackoff int
+
+	// 1 if replications between buckets with different conflict resolution types are allowed, with a warning, 0 otherwise.
+	// for migration scenarios only, since conflicts may be resolved differently on source and target
+	AllowConflictResolutionTypeMismatch int
+
 	// revision number to be used by metadata service. not included in json
 	Revision interface{}
 }
@@ -65,7 +111,14 @@ func DefaultInternalSettings() *InternalSettings {
 		MaxTopologyStableCountBeforeRestart: MaxTopologyStableCountBeforeRestartConfig.defaultValue.(int),
 		MaxWorkersForCheckpointing:          MaxWorkersForCheckpointingConfig.defaultValue.(int),
 		TimeoutCheckpointBeforeStop:         TimeoutCheckpointBeforeStopConfig.defaultValue.(int),
-		CapiDataChanSizeMultiplier:          CapiDataChanSizeMultiplierConfig.defaultValue.(int)}
+		CapiDataChanSizeMultiplier:          CapiDataChanSizeMultiplierConfig.defaultValue.(int),
+		StatsHistorySize:                    StatsHistorySizeConfig.defaultValue.(int),
+		ForceDirectBucketUUIDLookup:         ForceDirectBucketUUIDLookupConfig.defaultValue.(int),
+		SettingsHistoryDepth:                SettingsHistoryDepthConfig.defaultValue.(int),
+		SourceBucketWarmupTimeout:           SourceBucketWarmupTimeoutConfig.defaultValue.(int),
+		RemoteBucketLookupMaxAttempts:       RemoteBucketLookupMaxAttemptsConfig.defaultValue.(int),
+		RemoteBucketLookupBaseBackoff:       RemoteBucketLookupBaseBackoffConfig.defaultValue.(int),
+		AllowConflictResolutionTypeMismatch: AllowConflictResolutionTypeMismatchConfig.defaultValue.(int)}
 }
 
 func (s *InternalSettings) Equals(s2 *InternalSettings) bool {
@@ -82,7 +135,14 @@ func (s *InternalSettings) Equals(s2 *InternalSettings) bool {
 		s.MaxTopologyStableCountBeforeRestart == s2.MaxTopologyStableCountBeforeRestart &&
 		s.MaxWorkersForCheckpointing == s2.MaxWorkersForCheckpointing &&
 		s.TimeoutCheckpointBeforeStop == s2.TimeoutCheckpointBeforeStop &&
-		s.CapiDataChanSizeMultiplier == s2.CapiDataChanSizeMultiplier
+		s.CapiDataChanSizeMultiplier == s2.CapiDataChanSizeMultiplier &&
+		s.StatsHistorySize == s2.StatsHistorySize &&
+		s.ForceDirectBucketUUIDLookup == s2.ForceDirectBucketUUIDLookup &&
+		s.SettingsHistoryDepth == s2.SettingsHistoryDepth &&
+		s.SourceBucketWarmupTimeout == s2.SourceBucketWarmupTimeout &&
+		s.RemoteBucketLookupMaxAttempts == s2.RemoteBucketLookupMaxAttempts &&
+		s.RemoteBucketLookupBaseBackoff == s2.RemoteBucketLookupBaseBackoff &&
+		s.AllowConflictResolutionTypeMismatch == s2.AllowConflictResolutionTypeMismatch
 }
 
 func (s *InternalSettings) UpdateSettingsFromMap(settingsMap map[string]interface{}) (changed bool, errorMap map[string]error) {
@@ -151,6 +211,76 @@ func (s *InternalSettings) UpdateSettingsFromMap(settingsMap map[string]interfac
 				s.CapiDataChanSizeMultiplier = mutiplier
 				changed = true
 			}
+		case StatsHistorySizeKey:
+			historySize, ok := val.(int)
+			if !ok {
+				errorMap[key] = simple_utils.IncorrectValueTypeInMapError(key, val, "int")
+				continue
+			}
+			if s.StatsHistorySize != historySize {
+				s.StatsHistorySize = historySize
+				changed = true
+			}
+		case ForceDirectBucketUUIDLookupKey:
+			forceDirectLookup, ok := val.(int)
+			if !ok {
+				errorMap[key] = simple_utils.IncorrectValueTypeInMapError(key, val, "int")
+				continue
+			}
+			if s.ForceDirectBucketUUIDLookup != forceDirectLookup {
+				s.ForceDirectBucketUUIDLookup = forceDirectLookup
+				changed = true
+			}
+		case SettingsHistoryDepthKey:
+			historyDepth, ok := val.(int)
+			if !ok {
+				errorMap[key] = simple_utils.IncorrectValueTypeInMapError(key, val, "int")
+				continue
+			}
+			if s.SettingsHistoryDepth != historyDepth {
+				s.SettingsHistoryDepth = historyDepth
+				changed = true
+			}
+		case SourceBucketWarmupTimeoutKey:
+			warmupTimeout, ok := val.(int)
+			if !ok {
+				errorMap[key] = simple_utils.IncorrectValueTypeInMapError(key, val, "int")
+				continue
+			}
+			if s.SourceBucketWarmupTimeout != warmupTimeout {
+				s.SourceBucketWarmupTimeout = warmupTimeout
+				changed = true
+			}
+		case RemoteBucketLookupMaxAttemptsKey:
+			maxAttempts, ok := val.(int)
+			if !ok {
+				errorMap[key] = simple_utils.IncorrectValueTypeInMapError(key, val, "int")
+				continue
+			}
+			if s.RemoteBucketLookupMaxAttempts != maxAttempts {
+				s.RemoteBucketLookupMaxAttempts = maxAttempts
+				changed = true
+			}
+		case RemoteBucketLookupBaseBackoffKey:
+			baseBackoff, ok := val.(int)
+			if !ok {
+				errorMap[key] = simple_utils.IncorrectValueTypeInMapError(key, val, "int")
+				continue
+			}
+			if s.RemoteBucketLookupBaseBackoff != baseBackoff {
+				s.RemoteBucketLookupBaseBackoff = baseBackoff
+				changed = true
+			}
+		case AllowConflictResolutionTypeMismatchKey:
+			allowMismatch, ok := val.(int)
+			if !ok {
+				errorMap[key] = simple_utils.IncorrectValueTypeInMapError(key, val, "int")
+				continue
+			}
+			if s.AllowConflictResolutionTypeMismatch != allowMismatch {
+				s.AllowConflictResolutionTypeMismatch = allowMismatch
+				changed = true
+			}
 		default:
 			errorMap[key] = fmt.Errorf("Invalid key in map, %v", key)
 		}
@@ -162,7 +292,9 @@ func (s *InternalSettings) UpdateSettingsFromMap(settingsMap map[string]interfac
 func ValidateAndConvertXDCRInternalSettingsValue(key, value string) (convertedValue interface{}, err error) {
 	switch key {
 	case TopologyChangeCheckIntervalKey, MaxTopologyChangeCountBeforeRestartKey, MaxTopologyStableCountBeforeRestartKey,
-		MaxWorkersForCheckpointingKey, TimeoutCheckpointBeforeStopKey, CapiDataChanSizeMultiplierKey:
+		MaxWorkersForCheckpointingKey, TimeoutCheckpointBeforeStopKey, CapiDataChanSizeMultiplierKey, StatsHistorySizeKey,
+		ForceDirectBucketUUIDLookupKey, SettingsHistoryDepthKey, SourceBucketWarmupTimeoutKey,
+		RemoteBucketLookupMaxAttemptsKey, RemoteBucketLookupBaseBackoffKey, AllowConflictResolutionTypeMismatchKey:
 		convertedValue, err = strconv.ParseInt(value, base.ParseIntBase, base.ParseIntBitSize)
 		if err != nil {
 			err = simple_utils.IncorrectValueTypeError("an integer")
@@ -189,5 +321,12 @@ func (s *InternalSettings) ToMap() map[string]interface{} {
 	settings_map[MaxWorkersForCheckpointingKey] = s.MaxWorkersForCheckpointing
 	settings_map[TimeoutCheckpointBeforeStopKey] = s.TimeoutCheckpointBeforeStop
 	settings_map[CapiDataChanSizeMultiplierKey] = s.CapiDataChanSizeMultiplier
+	settings_map[StatsHistorySizeKey] = s.StatsHistorySize
+	settings_map[ForceDirectBucketUUIDLookupKey] = s.ForceDirectBucketUUIDLookup
+	settings_map[SettingsHistoryDepthKey] = s.SettingsHistoryDepth
+	settings_map[SourceBucketWarmupTimeoutKey] = s.SourceBucketWarmupTimeout
+	settings_map[RemoteBucketLookupMaxAttemptsKey] = s.RemoteBucketLookupMaxAttempts
+	settings_map[RemoteBucketLookupBaseBackoffKey] = s.RemoteBucketLookupBaseBackoff
+	settings_map[AllowConflictResolutionTypeMismatchKey] = s.AllowConflictResolutionTypeMismatch
 	return settings_map
 }
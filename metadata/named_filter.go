@@ -0,0 +1,44 @@
+// Copyright (c) 2013 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"github.com/couchbase/goxdcr/base"
+	"regexp"
+	"strings"
+)
+
+// filter expression registered under a name, which new replications can reference through their filter name setting
+type NamedFilter struct {
+	Name       string `json:"name"`
+	Expression string `json:"expression"`
+
+	// revision number to be used by metadata service. not included in json
+	Revision interface{} `json:"-"`
+}
+
+// checks that the name can be used as part of metadata keys, and that the expression is a non-empty regular expression
+func ValidateNamedFilter(name, expression string) error {
+	if len(name) == 0 {
+		return errors.New("Filter name cannot be empty")
+	}
+	if strings.Contains(name, base.KeyPartsDelimiter) {
+		return fmt.Errorf("Filter name cannot contain %v", base.KeyPartsDelimiter)
+	}
+	if len(expression) == 0 {
+		return fmt.Errorf("Expression of filter %v cannot be empty", name)
+	}
+	if _, err := regexp.Compile(expression); err != nil {
+		return fmt.Errorf("Invalid expression for filter %v. err=%v", name, err)
+	}
+	return nil
+}
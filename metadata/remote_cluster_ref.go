@@ -44,6 +44,8 @@ type RemoteClusterReference struct {
 	// hostname to use when making https connection
 	HttpsHostName    string `json:"httpsHostName"`
 	SANInCertificate bool   `json:"SANInCertificate"`
+	// read-only remote clusters are for observation only. replications cannot be created to them
+	ReadOnly bool `json:"readOnly"`
 
 	// revision number to be used by metadata service. not included in json
 	Revision interface{}
@@ -100,6 +102,7 @@ func (ref *RemoteClusterReference) ToMap() map[string]interface{} {
 	outputMap[base.RemoteClusterHostName] = ref.HostName
 	outputMap[base.RemoteClusterUserName] = ref.UserName
 	outputMap[base.RemoteClusterDeleted] = false
+	outputMap[base.RemoteClusterReadOnly] = ref.ReadOnly
 	if ref.DemandEncryption {
 		outputMap[base.RemoteClusterDemandEncryption] = ref.DemandEncryption
 		outputMap[base.RemoteClusterCertificate] = string(ref.Certificate)
@@ -118,14 +121,28 @@ func (ref *RemoteClusterReference) SameRef(ref2 *RemoteClusterReference) bool {
 	return ref.Id == ref2.Id && ref.Uuid == ref2.Uuid && ref.Name == ref2.Name &&
 		ref.HostName == ref2.HostName && ref.UserName == ref2.UserName &&
 		ref.Password == ref2.Password && reflect.DeepEqual(ref.Revision, ref2.Revision) &&
-		ref.DemandEncryption == ref2.DemandEncryption && bytes.Equal(ref.Certificate, ref2.Certificate)
+		ref.DemandEncryption == ref2.DemandEncryption && bytes.Equal(ref.Certificate, ref2.Certificate) &&
+		ref.ReadOnly == ref2.ReadOnly
 }
 
 func (ref *RemoteClusterReference) String() string {
 	if ref == nil {
 		return "nil"
 	}
-	return fmt.Sprintf("id:%v; uuid:%v; name:%v; hostName:%v; userName:%v; password:xxxx; demandEncryption:%v;certificate:%v;revision:%v", ref.Id, ref.Uuid, ref.Name, ref.HostName, ref.UserName, ref.DemandEncryption, ref.Certificate, ref.Revision)
+	return fmt.Sprintf("id:%v; uuid:%v; name:%v; hostName:%v; userName:%v; password:%v; demandEncryption:%v;certificate:%v;readOnly:%v;revision:%v", ref.Id, ref.Uuid, ref.Name, ref.HostName, ref.UserName, simple_utils.RedactedValue, ref.DemandEncryption, ref.Certificate, ref.ReadOnly, ref.Revision)
+}
+
+// returns a copy of the ref with password replaced by asterisks, to be used in log and error messages
+func (ref *RemoteClusterReference) Redacted() *RemoteClusterReference {
+	if ref == nil {
+		return nil
+	}
+	redactedRef := ref.Clone()
+	if len(redactedRef.Password) > 0 {
+		redactedRef.Password = simple_utils.RedactedValue
+	}
+	redactedRef.Revision = ref.Revision
+	return redactedRef
 }
 
 func (ref *RemoteClusterReference) Clone() *RemoteClusterReference {
@@ -142,5 +159,6 @@ func (ref *RemoteClusterReference) Clone() *RemoteClusterReference {
 		Certificate:      ref.Certificate,
 		HttpsHostName:    ref.HttpsHostName,
 		SANInCertificate: ref.SANInCertificate,
+		ReadOnly:         ref.ReadOnly,
 	}
 }
@@ -0,0 +1,97 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/simple_utils"
+	"strings"
+	"testing"
+)
+
+func TestRedactedRemoteClusterReference(t *testing.T) {
+	password := "plaintextSecret"
+	ref, err := NewRemoteClusterReference("uuid", "name", "host:8091", "username", password, false, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error creating remote cluster reference. err=%v", err)
+	}
+
+	redactedRef := ref.Redacted()
+	if redactedRef.Password != simple_utils.RedactedValue {
+		t.Errorf("Expected redacted password %q, got %q", simple_utils.RedactedValue, redactedRef.Password)
+	}
+	if ref.Password != password {
+		t.Errorf("Redacted() modified the password of the original reference")
+	}
+
+	redactedJson, err := json.Marshal(redactedRef)
+	if err != nil {
+		t.Fatalf("Unexpected error marshalling redacted reference. err=%v", err)
+	}
+	outputs := []string{
+		fmt.Sprintf("%v", redactedRef),
+		fmt.Sprintf("%+v", *redactedRef),
+		fmt.Sprintf("%#v", *redactedRef),
+		fmt.Sprintf("%v", ref),
+		string(redactedJson),
+	}
+	for _, output := range outputs {
+		if strings.Contains(output, password) {
+			t.Errorf("Plaintext password found in redacted output %q", output)
+		}
+	}
+}
+
+func TestRedactConnectionStr(t *testing.T) {
+	password := "plaintextSecret"
+	inputs := map[string]string{
+		"http://username:" + password + "@host:8091":         "http://username:" + simple_utils.RedactedValue + "@host:8091",
+		"https://username:" + password + "@host:18091/pools": "https://username:" + simple_utils.RedactedValue + "@host:18091/pools",
+		"username:" + password + "@host:8091":                "username:" + simple_utils.RedactedValue + "@host:8091",
+		"http://username:p@ss:" + password + "@host:8091":    "http://username:" + simple_utils.RedactedValue + "@host:8091",
+		"http://username@host:8091":                          "http://username@host:8091",
+		"http://host:8091":                                   "http://host:8091",
+		"host:8091":                                          "host:8091",
+	}
+	for input, expected := range inputs {
+		redacted := simple_utils.RedactConnectionStr(input)
+		if redacted != expected {
+			t.Errorf("Connection string %q was redacted to %q, expected %q", input, redacted, expected)
+		}
+		if strings.Contains(redacted, password) {
+			t.Errorf("Plaintext password found in redacted connection string %q", redacted)
+		}
+	}
+}
+
+func TestReadOnlyRemoteClusterReference(t *testing.T) {
+	ref, err := NewRemoteClusterReference("uuid", "name", "host:8091", "username", "password", false, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error creating remote cluster reference. err=%v", err)
+	}
+	if ref.ReadOnly || ref.ToMap()[base.RemoteClusterReadOnly] != false {
+		t.Errorf("Remote cluster reference is read-only by default")
+	}
+
+	ref.ReadOnly = true
+	if clone := ref.Clone(); !clone.ReadOnly || !clone.SameRef(ref) {
+		t.Errorf("Clone of read-only reference is %v", clone)
+	}
+	writableRef := ref.Clone()
+	writableRef.ReadOnly = false
+	if writableRef.SameRef(ref) {
+		t.Errorf("References that differ in read-only flag are the same")
+	}
+	if ref.ToMap()[base.RemoteClusterReadOnly] != true {
+		t.Errorf("Output of read-only reference is %v", ref.ToMap())
+	}
+
+	value, err := json.Marshal(ref)
+	if err != nil {
+		t.Fatalf("Unexpected error marshalling reference. err=%v", err)
+	}
+	unmarshalledRef := &RemoteClusterReference{}
+	if err = json.Unmarshal(value, unmarshalledRef); err != nil || !unmarshalledRef.ReadOnly {
+		t.Errorf("Unmarshalled reference is %v, err=%v, expected it to be read-only", unmarshalledRef, err)
+	}
+}
@@ -15,13 +15,17 @@ import (
 	"github.com/couchbase/goxdcr/base"
 	"github.com/couchbase/goxdcr/log"
 	"github.com/couchbase/goxdcr/simple_utils"
+	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const (
 	ReplicationType                = "replication_type"
 	FilterExpression               = "filter_expression"
+	FilterKeyPrefix                = "filter_key_prefix"
 	Active                         = "active"
 	CheckpointInterval             = "checkpoint_interval"
 	BatchCount                     = "worker_batch_size"
@@ -34,19 +38,65 @@ const (
 	TimeoutPercentageCap           = "timeout_percentage_cap"
 	PipelineLogLevel               = "log_level"
 	PipelineStatsInterval          = "stats_interval"
+	AddKeyPrefix                   = "add_key_prefix"
+	AddKeySuffix                   = "add_key_suffix"
+	TargetNodeAllowlist            = "target_node_allowlist"
+	ReplicateOps                   = "replicate_ops"
+	CanaryInterval                 = "canary_interval"
+	TargetDurability               = "target_durability"
+	CompressionType                = "compression_type"
+	BalanceMode                    = "balance_mode"
+	RebindOnRecreate               = "rebind_on_recreate"
+	FilterName                     = "filter_name"
 )
 
 // settings whose default values cannot be viewed or changed through rest apis
-var ImmutableDefaultSettings = [3]string{ReplicationType, FilterExpression, Active}
+var ImmutableDefaultSettings = [9]string{ReplicationType, FilterExpression, FilterKeyPrefix, Active, AddKeyPrefix, AddKeySuffix, TargetNodeAllowlist, ReplicateOps, FilterName}
 
 // settings whose values cannot be changed after replication is created
-var ImmutableSettings = [1]string{FilterExpression}
+var ImmutableSettings = [5]string{FilterExpression, FilterKeyPrefix, AddKeyPrefix, AddKeySuffix, FilterName}
 
 const (
 	ReplicationTypeXmem = "xmem"
 	ReplicationTypeCapi = "capi"
 )
 
+// values of replicate_ops, which selects the types of source operations that are replicated
+const (
+	ReplicateOpsAll           = "all"
+	ReplicateOpsMutationsOnly = "mutations_only"
+	ReplicateOpsDeletionsOnly = "deletions_only"
+)
+
+// values of target_durability, which selects the durability requirement of writes to target
+const (
+	TargetDurabilityNone                     = "none"
+	TargetDurabilityMajority                 = "majority"
+	TargetDurabilityMajorityAndPersistActive = "majorityAndPersistActive"
+	TargetDurabilityPersistToMajority        = "persistToMajority"
+)
+
+// values of compression_type, which selects how document bodies are compressed before they are sent to target
+const (
+	CompressionTypeNone   = "none"
+	CompressionTypeSnappy = "snappy"
+)
+
+// values of balance_mode, which selects how documents are distributed among the xmem nozzles to the same target node
+const (
+	BalanceModeVbucket     = "vbucket"
+	BalanceModeLeastLoaded = "least-loaded"
+)
+
+// max length of key prefix and key suffix
+const MaxKeyAffixLength = 64
+
+// characters allowed in key prefix and key suffix
+var keyAffixRegexp = regexp.MustCompile("^[a-zA-Z0-9_.:%-]*$")
+
+// delimiter between nodes in target node allowlist in rest api
+const TargetNodeAllowlistDelimiter = ","
+
 type SettingsConfig struct {
 	defaultValue interface{}
 	*Range
@@ -60,6 +110,7 @@ type Range struct {
 // TODO change to "capi"?
 var ReplicationTypeConfig = &SettingsConfig{ReplicationTypeXmem, nil}
 var FilterExpressionConfig = &SettingsConfig{"", nil}
+var FilterKeyPrefixConfig = &SettingsConfig{"", nil}
 var ActiveConfig = &SettingsConfig{true, nil}
 var CheckpointIntervalConfig = &SettingsConfig{1800, &Range{60, 14400}}
 var BatchCountConfig = &SettingsConfig{500, &Range{10, 10000}}
@@ -72,10 +123,21 @@ var MaxExpectedReplicationLagConfig = &SettingsConfig{1000, &Range{100, 60000}}
 var TimeoutPercentageCapConfig = &SettingsConfig{50, &Range{0, 100}}
 var PipelineLogLevelConfig = &SettingsConfig{log.LogLevelInfo, nil}
 var PipelineStatsIntervalConfig = &SettingsConfig{1000, &Range{200, 600000}}
+var AddKeyPrefixConfig = &SettingsConfig{"", nil}
+var AddKeySuffixConfig = &SettingsConfig{"", nil}
+var TargetNodeAllowlistConfig = &SettingsConfig{[]string{}, nil}
+var ReplicateOpsConfig = &SettingsConfig{ReplicateOpsAll, nil}
+var CanaryIntervalConfig = &SettingsConfig{0, &Range{0, 3600}}
+var TargetDurabilityConfig = &SettingsConfig{TargetDurabilityNone, nil}
+var CompressionTypeConfig = &SettingsConfig{CompressionTypeNone, nil}
+var BalanceModeConfig = &SettingsConfig{BalanceModeVbucket, nil}
+var RebindOnRecreateConfig = &SettingsConfig{false, nil}
+var FilterNameConfig = &SettingsConfig{"", nil}
 
 var SettingsConfigMap = map[string]*SettingsConfig{
 	ReplicationType:                ReplicationTypeConfig,
 	FilterExpression:               FilterExpressionConfig,
+	FilterKeyPrefix:                FilterKeyPrefixConfig,
 	Active:                         ActiveConfig,
 	CheckpointInterval:             CheckpointIntervalConfig,
 	BatchCount:                     BatchCountConfig,
@@ -88,6 +150,16 @@ var SettingsConfigMap = map[string]*SettingsConfig{
 	TimeoutPercentageCap:           TimeoutPercentageCapConfig,
 	PipelineLogLevel:               PipelineLogLevelConfig,
 	PipelineStatsInterval:          PipelineStatsIntervalConfig,
+	AddKeyPrefix:                   AddKeyPrefixConfig,
+	AddKeySuffix:                   AddKeySuffixConfig,
+	TargetNodeAllowlist:            TargetNodeAllowlistConfig,
+	ReplicateOps:                   ReplicateOpsConfig,
+	CanaryInterval:                 CanaryIntervalConfig,
+	TargetDurability:               TargetDurabilityConfig,
+	CompressionType:                CompressionTypeConfig,
+	BalanceMode:                    BalanceModeConfig,
+	RebindOnRecreate:               RebindOnRecreateConfig,
+	FilterName:                     FilterNameConfig,
 }
 
 /***********************************
@@ -101,6 +173,16 @@ type ReplicationSettings struct {
 	//the filter expression
 	FilterExpression string `json:"filter_exp"`
 
+	//only documents whose keys start with the prefix are replicated.
+	//a cheaper alternative to filter expression, which cannot be set together with it
+	//default: "", i.e., documents are not filtered by key prefix
+	FilterKeyPrefix string `json:"filter_key_prefix"`
+
+	//the name of the registered filter that the filter expression has been taken from when the replication was created.
+	//the expression is copied, so that later changes to the registered filter do not affect the replication
+	//default: "", i.e., the filter expression, if any, has been specified directly
+	FilterName string `json:"filter_name"`
+
 	//if the replication is active
 	//default is true
 	Active bool `json:"active"`
@@ -160,6 +242,57 @@ type ReplicationSettings struct {
 	//default:5 second
 	StatsInterval int `json:"stats_interval"`
 
+	//prefix and suffix added to the key of each document written to target bucket,
+	//e.g., to namespace documents by source when replicating into a shared target bucket.
+	//only supported by xmem replication.
+	//the transformation is applied on the write side only. checkpoints, through seqnos
+	//and other replication metadata keep tracking source document keys
+	//default: ""
+	AddKeyPrefix string `json:"add_key_prefix"`
+	AddKeySuffix string `json:"add_key_suffix"`
+
+	//target nodes that the replication is allowed to connect to, in the form of host or host:port.
+	//for network-segmented deployments where only some target nodes are reachable from source.
+	//the replication fails to start when target vbuckets that it needs to write to live on other nodes.
+	//only supported by xmem replication.
+	//default: empty, i.e., all target nodes are allowed
+	TargetNodeAllowlist []string `json:"target_node_allowlist"`
+
+	//types of source operations that are replicated. other operations are skipped.
+	//"mutations_only" replicates creations and updates, "deletions_only" replicates deletions and expirations
+	//default: "all"
+	ReplicateOps string `json:"replicate_ops"`
+
+	//the interval (in seconds) between two canary documents, which are written to source bucket
+	//and read back from target bucket to measure end to end replication latency
+	//default: 0, i.e., canary is disabled
+	//range: 0-3600s
+	CanaryInterval int `json:"canary_interval"`
+
+	//the durability requirement of writes to target. with a requirement other than "none", a write is
+	//acknowledged by target only after it has been replicated, and/or persisted, as required.
+	//only supported by xmem replication to target clusters that support durable writes.
+	//default: "none"
+	TargetDurability string `json:"target_durability"`
+
+	//how document bodies are compressed before they are sent to target, which saves bandwidth at the cost of cpu.
+	//only supported by xmem replication to target clusters that support snappy compression.
+	//default: "none"
+	CompressionType string `json:"compression_type"`
+
+	//how documents are distributed among the xmem nozzles to the same target node.
+	//"vbucket" routes each vbucket to a fixed nozzle. "least-loaded" routes each vbucket to the nozzle
+	//with the shortest queue when the vbucket is first seen, and keeps routing it there so as to preserve
+	//the order of mutations in the vbucket.
+	//only supported by xmem replication.
+	//default: "vbucket"
+	BalanceMode string `json:"balance_mode"`
+
+	//whether the replication is bound to the new bucket when its source or target bucket is deleted and recreated.
+	//by default, the replication is garbage collected, since it refers to a bucket that no longer exists
+	//default: false
+	RebindOnRecreate bool `json:"rebind_on_recreate"`
+
 	// revision number to be used by metadata service. not included in json
 	Revision interface{}
 }
@@ -168,6 +301,7 @@ func DefaultSettings() *ReplicationSettings {
 	return &ReplicationSettings{
 		RepType:                        ReplicationTypeConfig.defaultValue.(string),
 		FilterExpression:               FilterExpressionConfig.defaultValue.(string),
+		FilterKeyPrefix:                FilterKeyPrefixConfig.defaultValue.(string),
 		Active:                         ActiveConfig.defaultValue.(bool),
 		CheckpointInterval:             CheckpointIntervalConfig.defaultValue.(int),
 		BatchCount:                     BatchCountConfig.defaultValue.(int),
@@ -180,6 +314,16 @@ func DefaultSettings() *ReplicationSettings {
 		TimeoutPercentageCap:           TimeoutPercentageCapConfig.defaultValue.(int),
 		LogLevel:                       PipelineLogLevelConfig.defaultValue.(log.LogLevel),
 		StatsInterval:                  PipelineStatsIntervalConfig.defaultValue.(int),
+		AddKeyPrefix:                   AddKeyPrefixConfig.defaultValue.(string),
+		AddKeySuffix:                   AddKeySuffixConfig.defaultValue.(string),
+		TargetNodeAllowlist:            TargetNodeAllowlistConfig.defaultValue.([]string),
+		ReplicateOps:                   ReplicateOpsConfig.defaultValue.(string),
+		CanaryInterval:                 CanaryIntervalConfig.defaultValue.(int),
+		TargetDurability:               TargetDurabilityConfig.defaultValue.(string),
+		CompressionType:                CompressionTypeConfig.defaultValue.(string),
+		BalanceMode:                    BalanceModeConfig.defaultValue.(string),
+		RebindOnRecreate:               RebindOnRecreateConfig.defaultValue.(bool),
+		FilterName:                     FilterNameConfig.defaultValue.(string),
 	}
 }
 
@@ -221,6 +365,26 @@ func (s *ReplicationSettings) UpdateSettingsFromMap(settingsMap map[string]inter
 				s.FilterExpression = filterExpression
 				changedSettingsMap[key] = filterExpression
 			}
+		case FilterKeyPrefix:
+			filterKeyPrefix, ok := val.(string)
+			if !ok {
+				errorMap[key] = simple_utils.IncorrectValueTypeInMapError(key, val, "string")
+				continue
+			}
+			if s.FilterKeyPrefix != filterKeyPrefix {
+				s.FilterKeyPrefix = filterKeyPrefix
+				changedSettingsMap[key] = filterKeyPrefix
+			}
+		case FilterName:
+			filterName, ok := val.(string)
+			if !ok {
+				errorMap[key] = simple_utils.IncorrectValueTypeInMapError(key, val, "string")
+				continue
+			}
+			if s.FilterName != filterName {
+				s.FilterName = filterName
+				changedSettingsMap[key] = filterName
+			}
 		case Active:
 			active, ok := val.(bool)
 			if !ok {
@@ -342,6 +506,96 @@ func (s *ReplicationSettings) UpdateSettingsFromMap(settingsMap map[string]inter
 				s.StatsInterval = interval
 				changedSettingsMap[key] = interval
 			}
+		case AddKeyPrefix:
+			keyPrefix, ok := val.(string)
+			if !ok {
+				errorMap[key] = simple_utils.IncorrectValueTypeInMapError(key, val, "string")
+				continue
+			}
+			if s.AddKeyPrefix != keyPrefix {
+				s.AddKeyPrefix = keyPrefix
+				changedSettingsMap[key] = keyPrefix
+			}
+		case AddKeySuffix:
+			keySuffix, ok := val.(string)
+			if !ok {
+				errorMap[key] = simple_utils.IncorrectValueTypeInMapError(key, val, "string")
+				continue
+			}
+			if s.AddKeySuffix != keySuffix {
+				s.AddKeySuffix = keySuffix
+				changedSettingsMap[key] = keySuffix
+			}
+		case TargetNodeAllowlist:
+			allowlist, ok := val.([]string)
+			if !ok {
+				errorMap[key] = simple_utils.IncorrectValueTypeInMapError(key, val, "[]string")
+				continue
+			}
+			if !SameTargetNodeAllowlist(s.TargetNodeAllowlist, allowlist) {
+				s.TargetNodeAllowlist = allowlist
+				changedSettingsMap[key] = allowlist
+			}
+		case ReplicateOps:
+			replicateOps, ok := val.(string)
+			if !ok {
+				errorMap[key] = simple_utils.IncorrectValueTypeInMapError(key, val, "string")
+				continue
+			}
+			if s.ReplicateOps != replicateOps {
+				s.ReplicateOps = replicateOps
+				changedSettingsMap[key] = replicateOps
+			}
+		case CanaryInterval:
+			canaryInterval, ok := val.(int)
+			if !ok {
+				errorMap[key] = simple_utils.IncorrectValueTypeInMapError(key, val, "int")
+				continue
+			}
+			if s.CanaryInterval != canaryInterval {
+				s.CanaryInterval = canaryInterval
+				changedSettingsMap[key] = canaryInterval
+			}
+		case TargetDurability:
+			targetDurability, ok := val.(string)
+			if !ok {
+				errorMap[key] = simple_utils.IncorrectValueTypeInMapError(key, val, "string")
+				continue
+			}
+			if s.TargetDurability != targetDurability {
+				s.TargetDurability = targetDurability
+				changedSettingsMap[key] = targetDurability
+			}
+		case CompressionType:
+			compressionType, ok := val.(string)
+			if !ok {
+				errorMap[key] = simple_utils.IncorrectValueTypeInMapError(key, val, "string")
+				continue
+			}
+			if s.CompressionType != compressionType {
+				s.CompressionType = compressionType
+				changedSettingsMap[key] = compressionType
+			}
+		case BalanceMode:
+			balanceMode, ok := val.(string)
+			if !ok {
+				errorMap[key] = simple_utils.IncorrectValueTypeInMapError(key, val, "string")
+				continue
+			}
+			if s.BalanceMode != balanceMode {
+				s.BalanceMode = balanceMode
+				changedSettingsMap[key] = balanceMode
+			}
+		case RebindOnRecreate:
+			rebindOnRecreate, ok := val.(bool)
+			if !ok {
+				errorMap[key] = simple_utils.IncorrectValueTypeInMapError(key, val, "bool")
+				continue
+			}
+			if s.RebindOnRecreate != rebindOnRecreate {
+				s.RebindOnRecreate = rebindOnRecreate
+				changedSettingsMap[key] = rebindOnRecreate
+			}
 		default:
 			errorMap[key] = errors.New(fmt.Sprintf("Invalid key in map, %v", key))
 		}
@@ -373,7 +627,13 @@ func (s *ReplicationSettings) toMap(isDefaultSettings bool) map[string]interface
 	if !isDefaultSettings {
 		settings_map[ReplicationType] = s.RepType
 		settings_map[FilterExpression] = s.FilterExpression
+		settings_map[FilterKeyPrefix] = s.FilterKeyPrefix
+		settings_map[FilterName] = s.FilterName
 		settings_map[Active] = s.Active
+		settings_map[AddKeyPrefix] = s.AddKeyPrefix
+		settings_map[AddKeySuffix] = s.AddKeySuffix
+		settings_map[TargetNodeAllowlist] = s.TargetNodeAllowlist
+		settings_map[ReplicateOps] = s.ReplicateOps
 	}
 	settings_map[CheckpointInterval] = s.CheckpointInterval
 	settings_map[BatchCount] = s.BatchCount
@@ -387,9 +647,41 @@ func (s *ReplicationSettings) toMap(isDefaultSettings bool) map[string]interface
 	settings_map[TimeoutPercentageCap] = s.TimeoutPercentageCap*/
 	settings_map[PipelineLogLevel] = s.LogLevel.String()
 	settings_map[PipelineStatsInterval] = s.StatsInterval
+	settings_map[CanaryInterval] = s.CanaryInterval
+	settings_map[TargetDurability] = s.TargetDurability
+	settings_map[CompressionType] = s.CompressionType
+	settings_map[BalanceMode] = s.BalanceMode
+	settings_map[RebindOnRecreate] = s.RebindOnRecreate
 	return settings_map
 }
 
+// definitions of replication settings, as accepted by UpdateSettingsFromMap, derived from SettingsConfigMap.
+// none of the settings is required, since all of them have default values
+func ReplicationSettingDefinitions() base.SettingDefinitions {
+	defs := make(base.SettingDefinitions, len(SettingsConfigMap))
+	for key, value := range replicationSettingDefaults() {
+		defs[key] = base.NewSettingDef(reflect.PtrTo(reflect.TypeOf(value)), false)
+	}
+	return defs
+}
+
+// describes the replication settings, including their types and default values, sorted by name
+func ReplicationSettingsSchema() []*base.SettingSchema {
+	return ReplicationSettingDefinitions().Schema(replicationSettingDefaults())
+}
+
+// default values of replication settings, in the form that UpdateSettingsFromMap accepts, e.g., log level as string
+func replicationSettingDefaults() map[string]interface{} {
+	defaults := DefaultSettings().ToMap()
+	// settings that are not yet supported are left out of ToMap
+	for key, config := range SettingsConfigMap {
+		if _, ok := defaults[key]; !ok {
+			defaults[key] = config.defaultValue
+		}
+	}
+	return defaults
+}
+
 func ValidateAndConvertSettingsValue(key, value, errorKey string) (convertedValue interface{}, err error) {
 	switch key {
 	case ReplicationType:
@@ -411,6 +703,8 @@ func ValidateAndConvertSettingsValue(key, value, errorKey string) (convertedValu
 			return
 		}
 		convertedValue = value
+	case FilterKeyPrefix, FilterName:
+		convertedValue = value
 	case Active:
 		var paused bool
 		paused, err = strconv.ParseBool(value)
@@ -419,11 +713,50 @@ func ValidateAndConvertSettingsValue(key, value, errorKey string) (convertedValu
 			return
 		}
 		convertedValue = !paused
+	case RebindOnRecreate:
+		convertedValue, err = strconv.ParseBool(value)
+		if err != nil {
+			err = simple_utils.IncorrectValueTypeError("a boolean")
+			return
+		}
+	case AddKeyPrefix, AddKeySuffix:
+		err = validateKeyAffix(value)
+		if err != nil {
+			return
+		}
+		convertedValue = value
+	case TargetNodeAllowlist:
+		convertedValue, err = parseTargetNodeAllowlist(value)
+	case ReplicateOps:
+		if value != ReplicateOpsAll && value != ReplicateOpsMutationsOnly && value != ReplicateOpsDeletionsOnly {
+			err = simple_utils.GenericInvalidValueError(errorKey)
+		} else {
+			convertedValue = value
+		}
+	case TargetDurability:
+		if value != TargetDurabilityNone && value != TargetDurabilityMajority &&
+			value != TargetDurabilityMajorityAndPersistActive && value != TargetDurabilityPersistToMajority {
+			err = simple_utils.GenericInvalidValueError(errorKey)
+		} else {
+			convertedValue = value
+		}
+	case CompressionType:
+		if value != CompressionTypeNone && value != CompressionTypeSnappy {
+			err = simple_utils.GenericInvalidValueError(errorKey)
+		} else {
+			convertedValue = value
+		}
+	case BalanceMode:
+		if value != BalanceModeVbucket && value != BalanceModeLeastLoaded {
+			err = simple_utils.GenericInvalidValueError(errorKey)
+		} else {
+			convertedValue = value
+		}
 
 	case CheckpointInterval, BatchCount, BatchSize, FailureRestartInterval,
 		OptimisticReplicationThreshold, SourceNozzlePerNode,
 		TargetNozzlePerNode, MaxExpectedReplicationLag, TimeoutPercentageCap,
-		PipelineStatsInterval:
+		PipelineStatsInterval, CanaryInterval:
 		convertedValue, err = strconv.ParseInt(value, base.ParseIntBase, base.ParseIntBitSize)
 		if err != nil {
 			err = simple_utils.IncorrectValueTypeError("an integer")
@@ -476,6 +809,7 @@ func ValidateSettingsKey(settingsMap map[string]interface{}) (returnedSettingsMa
 		switch key {
 
 		case ReplicationType, FilterExpression,
+			FilterKeyPrefix,
 			Active,
 			CheckpointInterval,
 			BatchCount,
@@ -487,13 +821,63 @@ func ValidateSettingsKey(settingsMap map[string]interface{}) (returnedSettingsMa
 			MaxExpectedReplicationLag,
 			TimeoutPercentageCap,
 			PipelineLogLevel,
-			PipelineStatsInterval:
+			PipelineStatsInterval,
+			AddKeyPrefix,
+			AddKeySuffix,
+			TargetNodeAllowlist,
+			ReplicateOps,
+			CanaryInterval,
+			TargetDurability,
+			CompressionType,
+			BalanceMode,
+			RebindOnRecreate,
+			FilterName:
 			returnedSettingsMap[key] = val
 		}
 	}
 	return
 }
 
+// check that key prefix or key suffix is short enough and contains only allowed characters
+func validateKeyAffix(value string) error {
+	if len(value) > MaxKeyAffixLength {
+		return errors.New(fmt.Sprintf("The value cannot be longer than %v characters", MaxKeyAffixLength))
+	}
+	if !keyAffixRegexp.MatchString(value) {
+		return errors.New("The value can contain only letters, digits, and the characters _ . : % -")
+	}
+	return nil
+}
+
+// parses comma separated target node allowlist. an empty value clears the allowlist
+func parseTargetNodeAllowlist(value string) ([]string, error) {
+	allowlist := make([]string, 0)
+	for _, node := range strings.Split(value, TargetNodeAllowlistDelimiter) {
+		node = strings.TrimSpace(node)
+		if len(node) == 0 {
+			continue
+		}
+		if strings.Contains(node, "/") {
+			return nil, errors.New(fmt.Sprintf("Invalid target node %v. Nodes need to be in the form of host or host:port", node))
+		}
+		allowlist = append(allowlist, node)
+	}
+	return allowlist, nil
+}
+
+// whether two target node allowlists contain the same nodes in the same order
+func SameTargetNodeAllowlist(allowlist1, allowlist2 []string) bool {
+	if len(allowlist1) != len(allowlist2) {
+		return false
+	}
+	for index, node := range allowlist1 {
+		if allowlist2[index] != node {
+			return false
+		}
+	}
+	return true
+}
+
 // range check for int parameters
 func RangeCheck(intValue int, settingsConfig *SettingsConfig) error {
 	if settingsConfig.Range != nil {
@@ -503,3 +887,70 @@ func RangeCheck(intValue int, settingsConfig *SettingsConfig) error {
 	}
 	return nil
 }
+
+// a change made to the settings of a replication
+type SettingsChange struct {
+	Time time.Time `json:"time"`
+	// changed settings keyed by setting name. each entry contains the old value followed by the new value
+	Changes map[string][2]interface{} `json:"changes"`
+}
+
+// typed view over a map of replication settings keyed by settings keys, e.g., one decoded from a rest request
+// or one returned by ToMap, so that key names and type assertions are kept in one place.
+// the getters return the default value of a setting, and false, when the setting is absent or is of a wrong type
+type SettingsMap map[string]interface{}
+
+func (settings SettingsMap) getString(key string) (string, bool) {
+	if value, ok := settings[key].(string); ok {
+		return value, true
+	}
+	return SettingsConfigMap[key].defaultValue.(string), false
+}
+
+func (settings SettingsMap) getInt(key string) (int, bool) {
+	if value, ok := settings[key].(int); ok {
+		return value, true
+	}
+	return SettingsConfigMap[key].defaultValue.(int), false
+}
+
+func (settings SettingsMap) getBool(key string) (bool, bool) {
+	if value, ok := settings[key].(bool); ok {
+		return value, true
+	}
+	return SettingsConfigMap[key].defaultValue.(bool), false
+}
+
+func (settings SettingsMap) GetReplicationType() (string, bool) {
+	return settings.getString(ReplicationType)
+}
+
+func (settings SettingsMap) GetFilterExpression() (string, bool) {
+	return settings.getString(FilterExpression)
+}
+
+func (settings SettingsMap) GetFilterKeyPrefix() (string, bool) {
+	return settings.getString(FilterKeyPrefix)
+}
+
+func (settings SettingsMap) GetFilterName() (string, bool) {
+	return settings.getString(FilterName)
+}
+
+func (settings SettingsMap) GetBalanceMode() (string, bool) {
+	return settings.getString(BalanceMode)
+}
+
+func (settings SettingsMap) GetBatchCount() (int, bool) {
+	return settings.getInt(BatchCount)
+}
+
+func (settings SettingsMap) GetFailureRestartInterval() (int, bool) {
+	return settings.getInt(FailureRestartInterval)
+}
+
+// replications are active unless they have been paused
+func (settings SettingsMap) IsActive() bool {
+	active, _ := settings.getBool(Active)
+	return active
+}
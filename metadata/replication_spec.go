@@ -12,6 +12,7 @@ package metadata
 import (
 	"fmt"
 	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/utils"
 	"reflect"
 	"strings"
 )
@@ -39,16 +40,53 @@ type ReplicationSpecification struct {
 
 	Settings *ReplicationSettings `json:"replicationSettings"`
 
+	// version of the schema of Settings. specs persisted before versioning was introduced have version 0.
+	// see UpgradeSpecSettings
+	SettingsVersion int `json:"settingsVersion"`
+
 	// revision number to be used by metadata service. not included in json
 	Revision interface{}
 }
 
+// what adding a replication spec would do, as computed by a dry run which does not persist the spec
+type ReplicationSpecDryRunResult struct {
+	ReplicationId    string `json:"replicationId"`
+	SourceBucketUUID string `json:"sourceBucketUUID"`
+	TargetBucketUUID string `json:"targetBucketUUID"`
+	// the ui log message that would be written when the spec is added
+	UiLogMessage string `json:"uiLogMessage"`
+	// whether a spec with the same replication id already exists, in which case adding the spec would fail
+	AlreadyExists bool `json:"alreadyExists"`
+}
+
+// result of validating a batch of replication specs and garbage collecting the invalid ones, keyed by replication id
+type ReplicationSpecGCSummary struct {
+	// specs that have been garbage collected, and why they were invalid
+	GarbageCollected map[string]error
+	// specs that were invalid but could not be garbage collected, and the errors deleting them
+	GCFailed map[string]error
+	// specs that could not be validated, e.g., because remote cluster could not be reached, and why
+	Skipped map[string]error
+	// specs that were found invalid in a dry run, and why. they are not garbage collected
+	Invalid map[string]error
+}
+
+func NewReplicationSpecGCSummary() *ReplicationSpecGCSummary {
+	return &ReplicationSpecGCSummary{
+		GarbageCollected: make(map[string]error),
+		GCFailed:         make(map[string]error),
+		Skipped:          make(map[string]error),
+		Invalid:          make(map[string]error),
+	}
+}
+
 func NewReplicationSpecification(sourceBucketName string, sourceBucketUUID string, targetClusterUUID string, targetBucketName string, targetBucketUUID string) *ReplicationSpecification {
 	return &ReplicationSpecification{Id: ReplicationId(sourceBucketName, targetClusterUUID, targetBucketName),
 		SourceBucketName:  sourceBucketName,
 		TargetClusterUUID: targetClusterUUID,
 		TargetBucketName:  targetBucketName,
-		Settings:          DefaultSettings()}
+		Settings:          DefaultSettings(),
+		SettingsVersion:   CurrentSpecSettingsVersion}
 }
 
 // checks if the passed in spec is the same as the current spec
@@ -74,28 +112,175 @@ func (spec *ReplicationSpecification) Clone() *ReplicationSpecification {
 		SourceBucketName:  spec.SourceBucketName,
 		TargetClusterUUID: spec.TargetClusterUUID,
 		TargetBucketName:  spec.TargetBucketName,
-		Settings:          spec.Settings.Clone()}
+		Settings:          spec.Settings.Clone(),
+		SettingsVersion:   spec.SettingsVersion}
+}
+
+// differences between two versions of a replication spec, e.g., the ones before and after SetReplicationSpec.
+// changes to settings can usually be applied to a running pipeline, while changes to the identity of the spec,
+// i.e., to its buckets or target cluster, require the pipeline to be rebuilt
+type ReplicationSpecDiff struct {
+	// changed settings, keyed by settings key, with [old value, new value] as values, as in ReplicationSettings.ToMap
+	ChangedSettings map[string][2]interface{}
+	// name or uuid of the source bucket has changed
+	SourceBucketChanged bool
+	// target cluster uuid has changed
+	TargetClusterChanged bool
+	// name or uuid of the target bucket has changed
+	TargetBucketChanged bool
+}
+
+func (diff *ReplicationSpecDiff) IdentityChanged() bool {
+	return diff.SourceBucketChanged || diff.TargetClusterChanged || diff.TargetBucketChanged
+}
+
+func (diff *ReplicationSpecDiff) IsEmpty() bool {
+	return len(diff.ChangedSettings) == 0 && !diff.IdentityChanged()
+}
+
+// returns the differences between oldSpec and newSpec. a nil spec, e.g., before a spec is created or after it is
+// deleted, differs from any other spec in identity, and has no settings
+func DiffReplicationSpecs(oldSpec, newSpec *ReplicationSpecification) *ReplicationSpecDiff {
+	var oldSettings, newSettings map[string]interface{}
+	if oldSpec != nil && oldSpec.Settings != nil {
+		oldSettings = oldSpec.Settings.ToMap()
+	}
+	if newSpec != nil && newSpec.Settings != nil {
+		newSettings = newSpec.Settings.ToMap()
+	}
+	diff := &ReplicationSpecDiff{ChangedSettings: utils.DiffSettings(oldSettings, newSettings)}
+
+	if oldSpec == nil || newSpec == nil {
+		diff.SourceBucketChanged = oldSpec != newSpec
+		diff.TargetClusterChanged = oldSpec != newSpec
+		diff.TargetBucketChanged = oldSpec != newSpec
+		return diff
+	}
+	diff.SourceBucketChanged = oldSpec.SourceBucketName != newSpec.SourceBucketName || oldSpec.SourceBucketUUID != newSpec.SourceBucketUUID
+	diff.TargetClusterChanged = oldSpec.TargetClusterUUID != newSpec.TargetClusterUUID
+	diff.TargetBucketChanged = oldSpec.TargetBucketName != newSpec.TargetBucketName || oldSpec.TargetBucketUUID != newSpec.TargetBucketUUID
+	return diff
+}
+
+// the getters below return the default value of a setting, and false, when the spec has no settings
+func (spec *ReplicationSpecification) GetReplicationType() (string, bool) {
+	if spec.Settings == nil {
+		return ReplicationTypeConfig.defaultValue.(string), false
+	}
+	return spec.Settings.RepType, true
+}
+
+func (spec *ReplicationSpecification) GetBatchCount() (int, bool) {
+	if spec.Settings == nil {
+		return BatchCountConfig.defaultValue.(int), false
+	}
+	return spec.Settings.BatchCount, true
+}
+
+func (spec *ReplicationSpecification) IsActive() bool {
+	if spec.Settings == nil {
+		return ActiveConfig.defaultValue.(bool)
+	}
+	return spec.Settings.Active
+}
+
+// the version of the schema of replication settings that this node writes.
+// it needs to be bumped, with an upgrade added to specSettingsUpgrades, whenever a setting whose default value is
+// not the zero value of its type is introduced, since specs persisted before that do not have the setting
+const CurrentSpecSettingsVersion = 1
+
+// upgrades keyed by the version that they upgrade from. each one fills in the default values of the settings
+// introduced in the next version
+var specSettingsUpgrades = map[int]func(settings *ReplicationSettings){
+	0: upgradeSpecSettingsFromV0,
+}
+
+// settings introduced before versioning, which specs persisted by older releases may not have
+func upgradeSpecSettingsFromV0(settings *ReplicationSettings) {
+	if settings.TargetNodeAllowlist == nil {
+		settings.TargetNodeAllowlist = TargetNodeAllowlistConfig.defaultValue.([]string)
+	}
+	if settings.ReplicateOps == "" {
+		settings.ReplicateOps = ReplicateOpsConfig.defaultValue.(string)
+	}
+	if settings.TargetDurability == "" {
+		settings.TargetDurability = TargetDurabilityConfig.defaultValue.(string)
+	}
+	if settings.CompressionType == "" {
+		settings.CompressionType = CompressionTypeConfig.defaultValue.(string)
+	}
+	if settings.BalanceMode == "" {
+		settings.BalanceMode = BalanceModeConfig.defaultValue.(string)
+	}
+}
+
+// brings the settings of the spec up to CurrentSpecSettingsVersion, by filling in the default values of the settings
+// that have been introduced since the version of the spec. settings that are present are left untouched.
+// specs with a newer version, e.g., ones written by newer nodes in a mixed cluster, are left as they are.
+// returns whether the spec has been upgraded
+func UpgradeSpecSettings(spec *ReplicationSpecification) bool {
+	if spec == nil || spec.SettingsVersion >= CurrentSpecSettingsVersion {
+		return false
+	}
+	if spec.Settings == nil {
+		spec.Settings = DefaultSettings()
+	} else {
+		for version := spec.SettingsVersion; version < CurrentSpecSettingsVersion; version++ {
+			specSettingsUpgrades[version](spec.Settings)
+		}
+	}
+	spec.SettingsVersion = CurrentSpecSettingsVersion
+	return true
 }
 
+// parts are not escaped, since neither cluster uuids nor bucket names can contain the delimiter, "/".
+// characters that bucket names can contain, e.g., "_" and "%", have no special meaning in replication ids
 func ReplicationId(sourceBucketName string, targetClusterUUID string, targetBucketName string) string {
 	parts := []string{targetClusterUUID, sourceBucketName, targetBucketName}
 	return strings.Join(parts, base.KeyPartsDelimiter)
 }
 
+// the inverse of ReplicationId. an error is returned when the id is malformed, e.g., when it does not consist of
+// exactly three non-empty parts, which is also the case when the bucket names cannot be told apart because they contain the delimiter
+func ParseReplicationId(replicationId string) (sourceBucketName, targetClusterUUID, targetBucketName string, err error) {
+	targetClusterUUID, bucketNames, err := splitReplicationId(replicationId)
+	if err != nil {
+		return
+	}
+	parts := strings.Split(bucketNames, base.KeyPartsDelimiter)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		err = fmt.Errorf("Invalid replication id: %v", replicationId)
+		return
+	}
+	return parts[0], targetClusterUUID, parts[1], nil
+}
+
+func IsValidReplicationId(replicationId string) bool {
+	_, _, _, err := ParseReplicationId(replicationId)
+	return err == nil
+}
+
+// splits replication id into target cluster uuid and the part with source and target bucket names
+func splitReplicationId(replicationId string) (string, string, error) {
+	index := strings.Index(replicationId, base.KeyPartsDelimiter)
+	if index <= 0 || index == len(replicationId)-len(base.KeyPartsDelimiter) {
+		return "", "", fmt.Errorf("Invalid replication id: %v", replicationId)
+	}
+	return replicationId[:index], replicationId[index+len(base.KeyPartsDelimiter):], nil
+}
+
+// source bucket name is matched against the beginning of the bucket names in replication id,
+// so that the check works even when bucket names contain the delimiter
 func IsReplicationIdForSourceBucket(replicationId string, sourceBucketName string) (bool, error) {
-	replBucketName, err := GetSourceBucketNameFromReplicationId(replicationId)
+	_, bucketNames, err := splitReplicationId(replicationId)
 	if err != nil {
 		return false, err
-	} else {
-		return replBucketName == sourceBucketName, nil
 	}
+	prefix := sourceBucketName + base.KeyPartsDelimiter
+	return len(sourceBucketName) > 0 && len(bucketNames) > len(prefix) && strings.HasPrefix(bucketNames, prefix), nil
 }
 
 func GetSourceBucketNameFromReplicationId(replicationId string) (string, error) {
-	parts := strings.Split(replicationId, base.KeyPartsDelimiter)
-	if len(parts) == 3 {
-		return parts[1], nil
-	} else {
-		return "", fmt.Errorf("Invalid replication id: %v", replicationId)
-	}
+	sourceBucketName, _, _, err := ParseReplicationId(replicationId)
+	return sourceBucketName, err
 }
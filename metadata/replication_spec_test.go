@@ -0,0 +1,200 @@
+// Copyright (c) 2013 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package metadata
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseReplicationId(t *testing.T) {
+	replicationId := ReplicationId("source", "uuid", "target")
+	sourceBucketName, targetClusterUUID, targetBucketName, err := ParseReplicationId(replicationId)
+	if err != nil {
+		t.Fatalf("Failed to parse replication id %v. err=%v", replicationId, err)
+	}
+	if sourceBucketName != "source" || targetClusterUUID != "uuid" || targetBucketName != "target" {
+		t.Errorf("Replication id %v is parsed into %v, %v, %v", replicationId, sourceBucketName, targetClusterUUID, targetBucketName)
+	}
+
+	for _, invalidId := range []string{"", "uuid", "uuid/source", "uuid/source/", "/source/target", "uuid//target", "uuid/a/b/target"} {
+		if _, _, _, err := ParseReplicationId(invalidId); err == nil {
+			t.Errorf("Expected error parsing invalid replication id %q", invalidId)
+		}
+		if IsValidReplicationId(invalidId) {
+			t.Errorf("Replication id %q is considered valid", invalidId)
+		}
+	}
+}
+
+func TestIsReplicationIdForSourceBucket(t *testing.T) {
+	replicationId := ReplicationId("source/a", "uuid", "target")
+	expected := map[string]bool{"source/a": true, "source/a/target": false, "sourc": false, "target": false, "uuid": false, "": false}
+	for sourceBucketName, expectedResult := range expected {
+		result, err := IsReplicationIdForSourceBucket(replicationId, sourceBucketName)
+		if err != nil {
+			t.Fatalf("Unexpected error checking replication id %v. err=%v", replicationId, err)
+		}
+		if result != expectedResult {
+			t.Errorf("Replication id %v is for source bucket %q=%v, expected %v", replicationId, sourceBucketName, result, expectedResult)
+		}
+	}
+
+	if _, err := IsReplicationIdForSourceBucket("uuid", "source"); err == nil {
+		t.Errorf("Expected error for invalid replication id")
+	}
+}
+
+func TestReplicationIdWithUnderscores(t *testing.T) {
+	bucketPairs := [][2]string{
+		{"my_bucket", "other_bucket"},
+		{"a_b_c", "a_b"},
+		{"bucket", "bucket_1"},
+		{"_", "__"},
+	}
+	for _, bucketPair := range bucketPairs {
+		replicationId := ReplicationId(bucketPair[0], "uuid", bucketPair[1])
+		sourceBucketName, targetClusterUUID, targetBucketName, err := ParseReplicationId(replicationId)
+		if err != nil {
+			t.Fatalf("Failed to parse replication id %v. err=%v", replicationId, err)
+		}
+		if sourceBucketName != bucketPair[0] || targetClusterUUID != "uuid" || targetBucketName != bucketPair[1] {
+			t.Errorf("Replication id %v is parsed into %v, %v, %v", replicationId, sourceBucketName, targetClusterUUID, targetBucketName)
+		}
+	}
+
+	// source bucket names that are substrings of each other, or of target bucket names, are told apart
+	replicationId := ReplicationId("my_bucket", "uuid", "bucket")
+	expected := map[string]bool{"my_bucket": true, "my": false, "bucket": false, "my_bucket_": false, "y_bucket": false}
+	for sourceBucketName, expectedResult := range expected {
+		result, err := IsReplicationIdForSourceBucket(replicationId, sourceBucketName)
+		if err != nil {
+			t.Fatalf("Unexpected error checking replication id %v. err=%v", replicationId, err)
+		}
+		if result != expectedResult {
+			t.Errorf("Replication id %v is for source bucket %q=%v, expected %v", replicationId, sourceBucketName, result, expectedResult)
+		}
+	}
+}
+
+func TestSpecSettingsGetters(t *testing.T) {
+	spec := NewReplicationSpecification("source", "sourceUUID", "targetUUID", "target", "targetBucketUUID")
+	spec.Settings.RepType = ReplicationTypeCapi
+	spec.Settings.BatchCount = 1000
+	spec.Settings.Active = false
+	if repType, ok := spec.GetReplicationType(); !ok || repType != ReplicationTypeCapi {
+		t.Errorf("Replication type is %v, %v, expected %v, true", repType, ok, ReplicationTypeCapi)
+	}
+	if batchCount, ok := spec.GetBatchCount(); !ok || batchCount != 1000 {
+		t.Errorf("Batch count is %v, %v, expected 1000, true", batchCount, ok)
+	}
+	if spec.IsActive() {
+		t.Errorf("Paused spec is active")
+	}
+
+	// defaults are returned when spec has no settings
+	spec.Settings = nil
+	if repType, ok := spec.GetReplicationType(); ok || repType != ReplicationTypeXmem {
+		t.Errorf("Replication type is %v, %v, expected %v, false", repType, ok, ReplicationTypeXmem)
+	}
+	if batchCount, ok := spec.GetBatchCount(); ok || batchCount != BatchCountConfig.defaultValue.(int) {
+		t.Errorf("Batch count is %v, %v, expected %v, false", batchCount, ok, BatchCountConfig.defaultValue)
+	}
+	if !spec.IsActive() {
+		t.Errorf("Spec without settings is not active")
+	}
+}
+
+func TestSettingsMap(t *testing.T) {
+	settings := SettingsMap(DefaultSettings().ToMap())
+	settings[ReplicationType] = ReplicationTypeCapi
+	settings[BatchCount] = 1000
+	settings[Active] = false
+	if repType, ok := settings.GetReplicationType(); !ok || repType != ReplicationTypeCapi {
+		t.Errorf("Replication type is %v, %v, expected %v, true", repType, ok, ReplicationTypeCapi)
+	}
+	if batchCount, ok := settings.GetBatchCount(); !ok || batchCount != 1000 {
+		t.Errorf("Batch count is %v, %v, expected 1000, true", batchCount, ok)
+	}
+	if settings.IsActive() {
+		t.Errorf("Paused settings are active")
+	}
+
+	// defaults are returned for absent settings, and for settings of wrong types instead of panicking
+	settings = SettingsMap{BatchCount: "1000", FilterExpression: 1}
+	if repType, ok := settings.GetReplicationType(); ok || repType != ReplicationTypeXmem {
+		t.Errorf("Replication type is %v, %v, expected %v, false", repType, ok, ReplicationTypeXmem)
+	}
+	if batchCount, ok := settings.GetBatchCount(); ok || batchCount != BatchCountConfig.defaultValue.(int) {
+		t.Errorf("Batch count is %v, %v, expected %v, false", batchCount, ok, BatchCountConfig.defaultValue)
+	}
+	if filterExpression, ok := settings.GetFilterExpression(); ok || filterExpression != "" {
+		t.Errorf("Filter expression is %q, %v, expected empty, false", filterExpression, ok)
+	}
+	if !settings.IsActive() {
+		t.Errorf("Settings without %v are not active", Active)
+	}
+}
+
+func TestDiffReplicationSpecs(t *testing.T) {
+	newTestSpec := func() *ReplicationSpecification {
+		return NewReplicationSpecification("source", "sourceUUID", "targetClusterUUID", "target", "targetUUID")
+	}
+	oldSpec := newTestSpec()
+
+	// no change
+	diff := DiffReplicationSpecs(oldSpec, newTestSpec())
+	if !diff.IsEmpty() {
+		t.Errorf("Diff of identical specs is %+v, expected empty", diff)
+	}
+
+	// settings-only change
+	newSpec := newTestSpec()
+	newSpec.Settings.BatchCount = 1000
+	newSpec.Settings.Active = false
+	diff = DiffReplicationSpecs(oldSpec, newSpec)
+	if diff.IdentityChanged() {
+		t.Errorf("Identity changed for settings-only change. diff=%+v", diff)
+	}
+	expected := map[string][2]interface{}{BatchCount: {oldSpec.Settings.BatchCount, 1000}, Active: {true, false}}
+	if !reflect.DeepEqual(diff.ChangedSettings, expected) {
+		t.Errorf("Changed settings are %v, expected %v", diff.ChangedSettings, expected)
+	}
+
+	// identity change
+	newSpec = newTestSpec()
+	newSpec.SourceBucketUUID = "newSourceUUID"
+	newSpec.TargetBucketName = "newTarget"
+	diff = DiffReplicationSpecs(oldSpec, newSpec)
+	if !diff.IdentityChanged() || !diff.SourceBucketChanged || diff.TargetClusterChanged || !diff.TargetBucketChanged {
+		t.Errorf("Diff is %+v, expected source and target buckets to have changed", diff)
+	}
+	if len(diff.ChangedSettings) != 0 {
+		t.Errorf("Changed settings are %v, expected none", diff.ChangedSettings)
+	}
+	newSpec = newTestSpec()
+	newSpec.TargetClusterUUID = "newTargetClusterUUID"
+	if diff = DiffReplicationSpecs(oldSpec, newSpec); !diff.TargetClusterChanged || diff.SourceBucketChanged || diff.TargetBucketChanged {
+		t.Errorf("Diff is %+v, expected target cluster to have changed", diff)
+	}
+
+	// creation and deletion
+	for _, diff := range []*ReplicationSpecDiff{DiffReplicationSpecs(nil, oldSpec), DiffReplicationSpecs(oldSpec, nil)} {
+		if !diff.SourceBucketChanged || !diff.TargetClusterChanged || !diff.TargetBucketChanged {
+			t.Errorf("Diff is %+v, expected identity to have changed", diff)
+		}
+		if len(diff.ChangedSettings) != len(oldSpec.Settings.ToMap()) {
+			t.Errorf("%v settings changed, expected all %v settings", len(diff.ChangedSettings), len(oldSpec.Settings.ToMap()))
+		}
+	}
+	if diff = DiffReplicationSpecs(nil, nil); !diff.IsEmpty() {
+		t.Errorf("Diff of nil specs is %+v, expected empty", diff)
+	}
+}
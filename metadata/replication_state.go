@@ -0,0 +1,80 @@
+// Copyright (c) 2013 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package metadata
+
+import (
+	"fmt"
+)
+
+// lifecycle state of a replication. It is runtime information tracked alongside the replication spec
+// and is not persisted in metakv
+type ReplicationState int
+
+const (
+	// replication has been created but has never been started
+	ReplicationStateCreated ReplicationState = iota
+	ReplicationStateStarting
+	ReplicationStateRunning
+	ReplicationStatePausing
+	ReplicationStatePaused
+	ReplicationStateError
+	ReplicationStateDeleting
+)
+
+var replicationStateNames = map[ReplicationState]string{
+	ReplicationStateCreated:  "Created",
+	ReplicationStateStarting: "Starting",
+	ReplicationStateRunning:  "Running",
+	ReplicationStatePausing:  "Pausing",
+	ReplicationStatePaused:   "Paused",
+	ReplicationStateError:    "Error",
+	ReplicationStateDeleting: "Deleting",
+}
+
+// the states that each state is allowed to move to
+var validReplicationStateTransitions = map[ReplicationState][]ReplicationState{
+	ReplicationStateCreated:  []ReplicationState{ReplicationStateStarting, ReplicationStatePaused, ReplicationStateError, ReplicationStateDeleting},
+	ReplicationStateStarting: []ReplicationState{ReplicationStateRunning, ReplicationStatePausing, ReplicationStateError, ReplicationStateDeleting},
+	ReplicationStateRunning:  []ReplicationState{ReplicationStateStarting, ReplicationStatePausing, ReplicationStateError, ReplicationStateDeleting},
+	ReplicationStatePausing:  []ReplicationState{ReplicationStatePaused, ReplicationStateError, ReplicationStateDeleting},
+	ReplicationStatePaused:   []ReplicationState{ReplicationStateStarting, ReplicationStateDeleting},
+	ReplicationStateError:    []ReplicationState{ReplicationStateStarting, ReplicationStatePausing, ReplicationStatePaused, ReplicationStateDeleting},
+	// deleting is terminal
+	ReplicationStateDeleting: []ReplicationState{},
+}
+
+func (state ReplicationState) String() string {
+	if name, ok := replicationStateNames[state]; ok {
+		return name
+	}
+	return fmt.Sprintf("Unknown(%v)", int(state))
+}
+
+func (state ReplicationState) IsValid() bool {
+	_, ok := replicationStateNames[state]
+	return ok
+}
+
+// returns nil if a replication is allowed to move from the current state to the new state.
+// staying in the same state is always allowed
+func ValidateReplicationStateTransition(curState, newState ReplicationState) error {
+	if !newState.IsValid() {
+		return fmt.Errorf("Invalid replication state %v", newState)
+	}
+	if curState == newState {
+		return nil
+	}
+	for _, state := range validReplicationStateTransitions[curState] {
+		if state == newState {
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid replication state transition from %v to %v", curState, newState)
+}
@@ -3,6 +3,7 @@ package metadata_svc
 import (
 	"errors"
 	"github.com/couchbase/goxdcr/log"
+	"github.com/couchbase/goxdcr/service_def"
 	"sync"
 	"sync/atomic"
 )
@@ -16,6 +17,15 @@ type CacheableMetadataObj interface {
 	CAS(obj CacheableMetadataObj) bool
 }
 
+// cached objects that implement this interface are accounted for in the deleted entry count and marshaled size of CacheStats.
+// other objects are counted as live entries only
+type SizedMetadataObj interface {
+	// whether the metadata in the object has been deleted, while the object is still kept in the cache
+	IsDeleted() bool
+	// size (in bytes) of the metadata in the object when marshaled
+	MarshaledSize() (int, error)
+}
+
 type MetadataCache struct {
 	cache      *atomic.Value
 	cache_lock *sync.Mutex
@@ -62,6 +72,30 @@ func (cache *MetadataCache) Upsert(key string, val CacheableMetadataObj) error {
 	return nil
 }
 
+// returns statistics of the objects in the cache. objects whose size cannot be determined are logged and left out of MarshaledSize
+func (cache *MetadataCache) CacheStats() *service_def.MetadataCacheStats {
+	stats := &service_def.MetadataCacheStats{}
+	for key, val := range cache.GetMap() {
+		sizedVal, ok := val.(SizedMetadataObj)
+		if !ok {
+			stats.NumOfLiveEntries++
+			continue
+		}
+		if sizedVal.IsDeleted() {
+			stats.NumOfDeletedEntries++
+			continue
+		}
+		stats.NumOfLiveEntries++
+		size, err := sizedVal.MarshaledSize()
+		if err != nil {
+			cache.logger.Errorf("Failed to get marshaled size of %v in cache. err=%v\n", key, err)
+			continue
+		}
+		stats.MarshaledSize += size
+	}
+	return stats
+}
+
 func (cache *MetadataCache) Delete(key string) {
 	cache.cache_lock.Lock()
 	defer cache.cache_lock.Unlock()
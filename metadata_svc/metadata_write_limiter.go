@@ -0,0 +1,90 @@
+// Copyright (c) 2013 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package metadata_svc
+
+import (
+	"sync"
+	"time"
+)
+
+// token bucket that paces writes to metadata store, so that bulk operations do not overwhelm it.
+// the bucket holds at most one token, i.e., writes are evenly spaced at 1/rate seconds apart.
+// a rate of 0 means that writes are not limited
+type metadataWriteLimiter struct {
+	// number of writes allowed per second
+	rate        int
+	tokens      float64
+	last_refill time.Time
+	lock        sync.Mutex
+	// clock of the limiter, which tests replace so that pacing can be checked without waiting
+	now   func() time.Time
+	sleep func(d time.Duration)
+}
+
+func newMetadataWriteLimiter(rate int) *metadataWriteLimiter {
+	return newMetadataWriteLimiterWithClock(rate, time.Now, time.Sleep)
+}
+
+func newMetadataWriteLimiterWithClock(rate int, now func() time.Time, sleep func(d time.Duration)) *metadataWriteLimiter {
+	return &metadataWriteLimiter{rate: rate,
+		tokens:      1,
+		last_refill: now(),
+		now:         now,
+		sleep:       sleep}
+}
+
+func (limiter *metadataWriteLimiter) setRate(rate int) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	limiter.refill()
+	limiter.rate = rate
+}
+
+// blocks until the next write is allowed
+func (limiter *metadataWriteLimiter) wait() {
+	for {
+		wait_time := limiter.take()
+		if wait_time == 0 {
+			return
+		}
+		limiter.sleep(wait_time)
+	}
+}
+
+// takes a token if one is available and returns 0. otherwise returns the time to wait for the next token
+func (limiter *metadataWriteLimiter) take() time.Duration {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	if limiter.rate <= 0 {
+		return 0
+	}
+
+	limiter.refill()
+	if limiter.tokens >= 1 {
+		limiter.tokens--
+		return 0
+	}
+	return time.Duration((1 - limiter.tokens) / float64(limiter.rate) * float64(time.Second))
+}
+
+// caller needs to hold lock
+func (limiter *metadataWriteLimiter) refill() {
+	now := limiter.now()
+	if limiter.rate > 0 {
+		limiter.tokens += now.Sub(limiter.last_refill).Seconds() * float64(limiter.rate)
+		if limiter.tokens > 1 {
+			limiter.tokens = 1
+		}
+	} else {
+		limiter.tokens = 1
+	}
+	limiter.last_refill = now
+}
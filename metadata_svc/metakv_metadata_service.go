@@ -20,6 +20,9 @@ import (
 	"time"
 )
 
+// metakv reports that the underlying config store is out of space only through error messages
+var storeFullErrorPatterns = []string{"no space left on device", "enospc", "insufficient storage", "quota exceeded"}
+
 type MetaKVMetadataSvc struct {
 	logger *log.CommonLogger
 }
@@ -63,6 +66,7 @@ func (meta_svc *MetaKVMetadataSvc) AddSensitive(key string, value []byte) error
 
 //Wrap metakv.Add with retries
 //if the key is already exist in metakv, return service_def.ErrorKeyAlreadyExist
+//if metakv is out of space, return service_def.MetadataStoreFullError
 //if metakv operation failed after max number of retries, return service_def.MetaKVFailedAfterMaxTries
 func (meta_svc *MetaKVMetadataSvc) add(key string, value []byte, sensitive bool) error {
 	start_time := time.Now()
@@ -80,6 +84,10 @@ func (meta_svc *MetaKVMetadataSvc) add(key string, value []byte, sensitive bool)
 			return service_def.ErrorKeyAlreadyExist
 		} else if err == nil {
 			return nil
+		} else if isStoreFullError(err) {
+			// no point retrying until space is freed up
+			meta_svc.logger.Errorf("metakv.Add failed since metakv is out of space. key=%v, err=%v\n", key, err)
+			return service_def.MetadataStoreFullError
 		} else {
 			meta_svc.logger.Errorf("metakv.Add failed. key=%v, value=%v, err=%v, num_of_retry=%v\n", key, value, err, i)
 		}
@@ -107,6 +115,7 @@ func (meta_svc *MetaKVMetadataSvc) SetSensitive(key string, value []byte, rev in
 
 //Wrap metakv.Set with retries
 //if the rev provided doesn't match with the rev metakv has, return service_def.ErrorRevisionMismatch
+//if metakv is out of space, return service_def.MetadataStoreFullError
 //if metakv operation failed after max number of retries, return service_def.MetaKVFailedAfterMaxTries
 func (meta_svc *MetaKVMetadataSvc) set(key string, value []byte, rev interface{}, sensitive bool) error {
 	start_time := time.Now()
@@ -124,6 +133,9 @@ func (meta_svc *MetaKVMetadataSvc) set(key string, value []byte, rev interface{}
 			return service_def.ErrorRevisionMismatch
 		} else if err == nil {
 			return nil
+		} else if isStoreFullError(err) {
+			meta_svc.logger.Errorf("metakv.Set failed since metakv is out of space. key=%v, err=%v\n", key, err)
+			return service_def.MetadataStoreFullError
 		} else {
 			meta_svc.logger.Errorf("metakv.Set failed. key=%v, value=%v, err=%v, num_of_retry=%v\n", key, value, err, i)
 		}
@@ -213,6 +225,16 @@ func (meta_svc *MetaKVMetadataSvc) GetAllKeysFromCatalog(catalogKey string) ([]s
 }
 
 // metakv requires that all paths start with "/"
+func isStoreFullError(err error) bool {
+	errMsg := strings.ToLower(err.Error())
+	for _, pattern := range storeFullErrorPatterns {
+		if strings.Contains(errMsg, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
 func getPathFromKey(key string) string {
 	return base.KeyPartsDelimiter + key
 }
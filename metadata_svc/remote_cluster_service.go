@@ -11,7 +11,10 @@
 package metadata_svc
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"github.com/couchbase/goxdcr/base"
@@ -22,6 +25,7 @@ import (
 	"github.com/couchbase/goxdcr/utils"
 	"net/http"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -41,11 +45,53 @@ var InvalidRemoteClusterErrorMessage = "Invalid remote cluster. "
 var UnknownRemoteClusterErrorMessage = "unknown remote cluster"
 var InvalidConnectionStrError = errors.New("invalid connection string")
 
+// reasons why a remote cluster fails the connectivity check on its /pools endpoint
+type RemoteClusterConnectivityErrorCategory int
+
+const (
+	RemoteClusterAuthFailure RemoteClusterConnectivityErrorCategory = iota
+	RemoteClusterUnreachable
+	RemoteClusterIncompatibleVersion
+	RemoteClusterNotInitialized
+)
+
+func (category RemoteClusterConnectivityErrorCategory) String() string {
+	switch category {
+	case RemoteClusterAuthFailure:
+		return "Authentication failure"
+	case RemoteClusterUnreachable:
+		return "Remote cluster unreachable"
+	case RemoteClusterIncompatibleVersion:
+		return "Incompatible remote cluster version"
+	case RemoteClusterNotInitialized:
+		return "Remote cluster not initialized"
+	default:
+		return "Unknown connectivity error"
+	}
+}
+
+// error returned when a remote cluster fails the connectivity check. it is an invalid remote cluster error,
+// which CheckAndUnwrapRemoteClusterError unwraps, and its message starts with the category
+type RemoteClusterConnectivityError struct {
+	Category RemoteClusterConnectivityErrorCategory
+	msg      string
+}
+
+func (err *RemoteClusterConnectivityError) Error() string {
+	return fmt.Sprintf("%v%v: %v", InvalidRemoteClusterErrorMessage, err.Category, err.msg)
+}
+
+func newRemoteClusterConnectivityError(category RemoteClusterConnectivityErrorCategory, errMsg string) error {
+	return &RemoteClusterConnectivityError{Category: category, msg: errMsg}
+}
+
 type remoteClusterVal struct {
 	key                 string
 	nodes_connectionstr []string
 	ref                 *metadata.RemoteClusterReference
 	cas                 int64
+	// whether remote cluster was reachable the last time the reference was cached
+	reachable bool
 }
 
 func (rcv *remoteClusterVal) CAS(obj CacheableMetadataObj) bool {
@@ -92,6 +138,16 @@ type RemoteClusterService struct {
 	cache_lock        *sync.Mutex
 
 	metadata_change_callback base.MetadataChangeHandlerCallback
+
+	// pooled http clients for rest calls to remote clusters, keyed by remote cluster uuid
+	http_clients      map[string]*remoteClusterHttpClient
+	http_clients_lock sync.Mutex
+}
+
+// pooled http client of a remote cluster, and the reference that it has been built for
+type remoteClusterHttpClient struct {
+	client *http.Client
+	ref    *metadata.RemoteClusterReference
 }
 
 func NewRemoteClusterService(uilog_svc service_def.UILogSvc, metakv_svc service_def.MetadataSvc,
@@ -106,6 +162,7 @@ func NewRemoteClusterService(uilog_svc service_def.UILogSvc, metakv_svc service_
 		cache:             nil,
 		cache_lock:        &sync.Mutex{},
 		logger:            logger,
+		http_clients:      make(map[string]*remoteClusterHttpClient),
 	}
 
 	err := svc.initCache()
@@ -221,6 +278,47 @@ func (service *RemoteClusterService) RemoteClusterByUuid(uuid string, refresh bo
 	}
 }
 
+// returns the remote cluster reference with the specified host name. host name without port matches references
+// to the host on any port, while host name with port matches references to the host on that port only.
+// error is returned when multiple references match, e.g., references to the same host on alternate ports
+func (service *RemoteClusterService) RemoteClusterByHostName(hostName string, refresh bool) (*metadata.RemoteClusterReference, error) {
+	var ref *metadata.RemoteClusterReference
+	var old_cas int64
+	matched_ref_names := make([]string, 0)
+
+	remote_cluster_map := service.RemoteClusterMap()
+	for _, ref_val := range remote_cluster_map {
+		if remoteClusterHostNameMatches(ref_val.ref.HostName, hostName) {
+			ref = ref_val.ref.Clone()
+			old_cas = ref_val.cas
+			matched_ref_names = append(matched_ref_names, ref_val.ref.Name)
+		}
+	}
+
+	if len(matched_ref_names) > 1 {
+		sort.Strings(matched_ref_names)
+		return nil, fmt.Errorf("Host name %v matches multiple remote cluster references %v. Specify the port to select one of them", hostName, matched_ref_names)
+	}
+	if ref == nil {
+		return nil, service_def.MetadataNotFoundErr
+	}
+
+	var err error
+	if refresh {
+		ref, err = service.refresh(ref, old_cas)
+	}
+	return ref, err
+}
+
+// host names of references have been normalized into the form of hostName:port
+func remoteClusterHostNameMatches(refHostName, hostName string) bool {
+	if strings.Contains(hostName, base.UrlPortNumberDelimiter) {
+		normalized, err := utils.NormalizeHostName(hostName)
+		return err == nil && refHostName == normalized
+	}
+	return utils.GetHostName(refHostName) == hostName
+}
+
 func (service *RemoteClusterService) AddRemoteCluster(ref *metadata.RemoteClusterReference, skipConnectivityValidation bool) error {
 	service.logger.Infof("Adding remote cluster with referenceId %v\n", ref.Id)
 
@@ -266,10 +364,10 @@ func (service *RemoteClusterService) updateRemoteCluster(ref *metadata.RemoteClu
 	return service.updateCache(ref.Id, ref)
 }
 
-func (service *RemoteClusterService) SetRemoteCluster(refName string, ref *metadata.RemoteClusterReference) error {
+func (service *RemoteClusterService) SetRemoteCluster(refName string, ref *metadata.RemoteClusterReference, skipConnectivityValidation bool) error {
 	service.logger.Infof("Setting remote cluster with refName %v\n", refName)
 
-	err := service.ValidateSetRemoteCluster(refName, ref)
+	err := service.validateSetRemoteCluster(refName, ref, skipConnectivityValidation)
 	if err != nil {
 		return err
 	}
@@ -353,6 +451,21 @@ func (service *RemoteClusterService) RemoteClusters(refresh bool) (map[string]*m
 	return remote_cluster_map_out, nil
 }
 
+// returns the numbers of remote clusters that were reachable and unreachable the last time they were refreshed.
+// it works off the cache and does not contact remote clusters
+func (service *RemoteClusterService) RemoteClusterReachabilityCounts() (int, int) {
+	reachable := 0
+	unreachable := 0
+	for _, ref_val := range service.RemoteClusterMap() {
+		if ref_val.reachable {
+			reachable++
+		} else {
+			unreachable++
+		}
+	}
+	return reachable, unreachable
+}
+
 func (service *RemoteClusterService) RemoteClusterMap() map[string]*remoteClusterVal {
 	ret := make(map[string]*remoteClusterVal)
 	values_map := service.getCache().GetMap()
@@ -374,10 +487,15 @@ func (service *RemoteClusterService) validateAddRemoteCluster(ref *metadata.Remo
 		return wrapAsInvalidRemoteClusterOperationError("Duplicate cluster names are not allowed")
 	}
 
+	err := normalizeHostName(ref)
+	if err != nil {
+		return err
+	}
+
 	// skip connectivity validation if so specified, e.g., when called from migration service
 	if !skipConnectivityValidation {
 		bUpdateUuid := (ref.Uuid == "")
-		err := service.validateRemoteCluster(ref, bUpdateUuid)
+		err = service.validateRemoteCluster(ref, bUpdateUuid)
 		if err != nil {
 			return err
 		}
@@ -394,16 +512,30 @@ func (service *RemoteClusterService) validateAddRemoteCluster(ref *metadata.Remo
 }
 
 func (service *RemoteClusterService) ValidateSetRemoteCluster(refName string, ref *metadata.RemoteClusterReference) error {
+	return service.validateSetRemoteCluster(refName, ref, false)
+}
+
+func (service *RemoteClusterService) validateSetRemoteCluster(refName string, ref *metadata.RemoteClusterReference, skipConnectivityValidation bool) error {
 	oldRef, err := service.RemoteClusterByRefName(refName, false)
 	if err != nil {
 		return err
 	}
 
-	err = service.validateRemoteCluster(ref, true)
+	err = normalizeHostName(ref)
 	if err != nil {
 		return err
 	}
 
+	if skipConnectivityValidation {
+		// the actual uuid cannot be retrieved. the new hostname is trusted to point to the same remote cluster
+		ref.Uuid = oldRef.Uuid
+	} else {
+		err = service.validateRemoteCluster(ref, true)
+		if err != nil {
+			return err
+		}
+	}
+
 	if oldRef.Uuid != ref.Uuid {
 		return wrapAsInvalidRemoteClusterOperationError("The new hostname points to a different remote cluster, which is not allowed.")
 	}
@@ -435,10 +567,46 @@ func (service *RemoteClusterService) validateRemoteCluster(ref *metadata.RemoteC
 		}
 	}
 
+	poolsInfo, err := service.validateRemoteClusterConnectivity(ref)
+	if err != nil {
+		return err
+	}
+
+	// get remote cluster uuid from the map
+	if updateUUid {
+		actualUuid, ok := poolsInfo[base.RemoteClusterUuid]
+		if !ok {
+			// should never get here
+			return wrapAsInvalidRemoteClusterError("Could not get uuid of remote cluster. Remote cluster may be invalid.")
+		}
+		actualUuidStr, ok := actualUuid.(string)
+		if !ok {
+			// should never get here
+			service.logger.Errorf("Uuid of remote cluster is of wrong type. Expected type: string; Actual type: %s", reflect.TypeOf(actualUuid))
+			return wrapAsInvalidRemoteClusterError("Could not get uuid of remote cluster. Remote cluster may be invalid.")
+		}
+
+		// update uuid in ref to real value
+		ref.Uuid = actualUuidStr
+	}
+
+	return nil
+}
+
+// checks that the remote cluster can be reached on its /pools endpoint with the credentials, encryption setting and
+// certificate in ref. Failures are returned as *RemoteClusterConnectivityError, which tells whether authentication
+// failed, the remote cluster could not be reached, or the remote cluster version is not compatible with ref
+func (service *RemoteClusterService) ValidateRemoteClusterConnectivity(ref *metadata.RemoteClusterReference) error {
+	_, err := service.validateRemoteClusterConnectivity(ref)
+	return err
+}
+
+// returns the pools info retrieved from the remote cluster
+func (service *RemoteClusterService) validateRemoteClusterConnectivity(ref *metadata.RemoteClusterReference) (map[string]interface{}, error) {
 	hostName := utils.GetHostName(ref.HostName)
 	port, err := utils.GetPortNumber(ref.HostName)
 	if err != nil {
-		return wrapAsInvalidRemoteClusterError(fmt.Sprintf("Failed to resolve address for \"%v\". The hostname may be incorrect or not resolvable.", ref.HostName))
+		return nil, newRemoteClusterConnectivityError(RemoteClusterUnreachable, fmt.Sprintf("Failed to resolve address for \"%v\". The hostname may be incorrect or not resolvable.", ref.HostName))
 	}
 
 	var hostAddr string
@@ -447,9 +615,9 @@ func (service *RemoteClusterService) validateRemoteCluster(ref *metadata.RemoteC
 			httpsHostAddr, err, isInternalError := service.httpsHostAddr(ref.HostName)
 			if err != nil {
 				if isInternalError {
-					return err
+					return nil, err
 				} else {
-					return wrapAsInvalidRemoteClusterError(fmt.Sprintf("Could not connect to \"%v\" on port %v. This could be due to an incorrect host/port combination or a firewall in place between the servers.", hostName, port))
+					return nil, newRemoteClusterConnectivityError(RemoteClusterUnreachable, fmt.Sprintf("Could not connect to \"%v\" on port %v. This could be due to an incorrect host/port combination or a firewall in place between the servers.", hostName, port))
 				}
 			}
 			// store https host name in ref for later re-use
@@ -461,7 +629,7 @@ func (service *RemoteClusterService) validateRemoteCluster(ref *metadata.RemoteC
 			// the correct value and all subsequent https calls will use the correct value
 			hasSANInCertificateSupport, err := pipeline_utils.HasSANInCertificateSupport(service.cluster_info_svc, ref)
 			if err != nil {
-				return wrapAsInvalidRemoteClusterError(fmt.Sprintf("Error checking if target cluster supports SANs in cerificates. err=%v", err))
+				return nil, newRemoteClusterConnectivityError(RemoteClusterUnreachable, fmt.Sprintf("Error checking if target cluster supports SANs in cerificates. err=%v", err))
 			}
 			ref.SANInCertificate = hasSANInCertificateSupport
 		}
@@ -476,19 +644,19 @@ func (service *RemoteClusterService) validateRemoteCluster(ref *metadata.RemoteC
 	service.logger.Infof("Result from validate remote cluster call: err=%v, statusCode=%v. time taken=%v\n", err, statusCode, time.Since(startTime))
 	if err != nil || statusCode != http.StatusOK {
 		if statusCode == http.StatusUnauthorized {
-			return wrapAsInvalidRemoteClusterError(fmt.Sprintf("Authentication failed. Verify username and password. Got HTTP status %v from REST call get to %v%v. Body was: []", statusCode, hostAddr, base.PoolsPath))
+			return nil, newRemoteClusterConnectivityError(RemoteClusterAuthFailure, fmt.Sprintf("Authentication failed. Verify username and password. Got HTTP status %v from REST call get to %v%v. Body was: []", statusCode, hostAddr, base.PoolsPath))
 		} else {
-			return service.formErrorFromValidatingRemotehost(ref, hostName, port, err)
+			return nil, service.formErrorFromValidatingRemotehost(ref, hostName, port, err)
 		}
 	}
 
 	// check if remote cluster has been initialized, i.e., has non-empty pools
 	pools, ok := poolsInfo[base.Pools].([]interface{})
 	if !ok {
-		return wrapAsInvalidRemoteClusterError("Could not get cluster info from remote cluster. Remote cluster may be invalid.")
+		return nil, newRemoteClusterConnectivityError(RemoteClusterIncompatibleVersion, "Could not get cluster info from remote cluster. Remote cluster may be invalid.")
 	}
 	if len(pools) == 0 {
-		return wrapAsInvalidRemoteClusterError("Remote node is not initialized.")
+		return nil, newRemoteClusterConnectivityError(RemoteClusterNotInitialized, "Remote node is not initialized.")
 	}
 
 	if ref.DemandEncryption {
@@ -501,47 +669,30 @@ func (service *RemoteClusterService) validateRemoteCluster(ref *metadata.RemoteC
 		}
 
 		if !isEnterprise_remote {
-			return wrapAsInvalidRemoteClusterError("Remote cluster is not enterprise version and does not support SSL.")
+			return nil, newRemoteClusterConnectivityError(RemoteClusterIncompatibleVersion, "Remote cluster is not enterprise version and does not support SSL.")
 		}
 
 		remoteSSLCompatible, err := service.cluster_info_svc.IsClusterCompatible(ref, []int{2, 5})
 		if err != nil {
-			return wrapAsInvalidRemoteClusterError("Failed to get target cluster version information")
+			return nil, newRemoteClusterConnectivityError(RemoteClusterUnreachable, "Failed to get target cluster version information")
 		}
 
 		if !remoteSSLCompatible {
-			return wrapAsInvalidRemoteClusterError("Remote cluster has a version lower than 2.5 and does not support SSL.")
-		}
-	}
-	// get remote cluster uuid from the map
-	if updateUUid {
-		actualUuid, ok := poolsInfo[base.RemoteClusterUuid]
-		if !ok {
-			// should never get here
-			return wrapAsInvalidRemoteClusterError("Could not get uuid of remote cluster. Remote cluster may be invalid.")
-		}
-		actualUuidStr, ok := actualUuid.(string)
-		if !ok {
-			// should never get here
-			service.logger.Errorf("Uuid of remote cluster is of wrong type. Expected type: string; Actual type: %s", reflect.TypeOf(actualUuid))
-			return wrapAsInvalidRemoteClusterError("Could not get uuid of remote cluster. Remote cluster may be invalid.")
+			return nil, newRemoteClusterConnectivityError(RemoteClusterIncompatibleVersion, "Remote cluster has a version lower than 2.5 and does not support SSL.")
 		}
-
-		// update uuid in ref to real value
-		ref.Uuid = actualUuidStr
 	}
 
-	return nil
+	return poolsInfo, nil
 }
 
 func (service *RemoteClusterService) formErrorFromValidatingRemotehost(ref *metadata.RemoteClusterReference, hostName string, port uint16, err error) error {
 	if !ref.DemandEncryption {
 		// if encryption is not on, most likely the error is caused by incorrect hostname or firewall.
-		return wrapAsInvalidRemoteClusterError(fmt.Sprintf("Could not connect to \"%v\" on port %v. This could be due to an incorrect host/port combination or a firewall in place between the servers.", hostName, port))
+		return newRemoteClusterConnectivityError(RemoteClusterUnreachable, fmt.Sprintf("Could not connect to \"%v\" on port %v. This could be due to an incorrect host/port combination or a firewall in place between the servers.", hostName, port))
 	} else {
 		// if encryption is on, several different errors could be returned here, e.g., invalid hostname, invalid certificate, certificate by unknown authority, etc.
 		// just return the err
-		return wrapAsInvalidRemoteClusterError(err.Error())
+		return newRemoteClusterConnectivityError(RemoteClusterUnreachable, err.Error())
 	}
 }
 
@@ -600,6 +751,7 @@ func (service *RemoteClusterService) cacheRef(ref *metadata.RemoteClusterReferen
 
 	// use GetNodeListWithMinInfo API to ensure that it is supported by target cluster, which could be an elastic search cluster
 	nodeList, err := utils.GetNodeListWithMinInfo(connStr, username, password, certificate, sanInCertificate, service.logger)
+	reachable := err == nil
 	if err == nil {
 		service.logger.Debugf("connStr=%v, nodeList=%v\n", connStr, nodeList)
 
@@ -634,7 +786,8 @@ func (service *RemoteClusterService) cacheRef(ref *metadata.RemoteClusterReferen
 	ref_cache := &remoteClusterVal{key: ref.Id,
 		nodes_connectionstr: nodes_connStrs,
 		ref:                 ref,
-		cas:                 old_cas}
+		cas:                 old_cas,
+		reachable:           reachable}
 
 	err1 := cache.Upsert(ref.Id, ref_cache)
 	if err1 != nil {
@@ -811,6 +964,16 @@ func (service *RemoteClusterService) GetRemoteClusterNameFromClusterUuid(uuid st
 	return remoteClusterRef.Name
 }
 
+// normalize the hostname in ref, e.g., strip scheme and trailing slash, so that it can be used to construct connection strings
+func normalizeHostName(ref *metadata.RemoteClusterReference) error {
+	hostName, err := utils.NormalizeHostName(ref.HostName)
+	if err != nil {
+		return wrapAsInvalidRemoteClusterError(err.Error())
+	}
+	ref.HostName = hostName
+	return nil
+}
+
 // wrap/mark an error as invalid remote cluster error - by adding "invalid remote cluster" message to the front
 func wrapAsInvalidRemoteClusterError(errMsg string) error {
 	return errors.New(InvalidRemoteClusterErrorMessage + errMsg)
@@ -893,6 +1056,10 @@ func (service *RemoteClusterService) updateCache(refId string, newRef *metadata.
 				if err != InvalidConnectionStrError && err != CASMisMatchError {
 					return err
 				} else {
+					if err == InvalidConnectionStrError && oldRef != nil {
+						// newRef has been cached even though target is not reachable
+						service.onRefChanged(oldRef, newRef)
+					}
 					// ignore InvalidConnectionStrError and CASMisMatchError
 					// since cache is still in valid state in spite of them
 					return nil
@@ -901,6 +1068,10 @@ func (service *RemoteClusterService) updateCache(refId string, newRef *metadata.
 		}
 	}
 
+	if updated && oldRef != nil {
+		service.onRefChanged(oldRef, newRef)
+	}
+
 	if updated && service.metadata_change_callback != nil {
 		err = service.metadata_change_callback(refId, oldRef, newRef)
 		if err != nil {
@@ -910,3 +1081,92 @@ func (service *RemoteClusterService) updateCache(refId string, newRef *metadata.
 
 	return nil
 }
+
+// returns the http client for rest calls to the remote cluster, which is pooled across the calls.
+// the client is built on first use, and is rebuilt when the host name, credentials or certificate of the reference change.
+// it connects over https when the reference demands encryption and has a certificate
+func (service *RemoteClusterService) GetHttpClient(ref *metadata.RemoteClusterReference) (*http.Client, error) {
+	service.http_clients_lock.Lock()
+	pooledClient, ok := service.http_clients[ref.Uuid]
+	service.http_clients_lock.Unlock()
+	if ok && sameHttpClientSettings(pooledClient.ref, ref) {
+		return pooledClient.client, nil
+	}
+
+	// build the client without holding http_clients_lock, since it may involve tls handshakes with the remote cluster,
+	// which would block the retrieval of the clients of all other remote clusters
+	connStr, err := ref.MyConnectionStr()
+	if err != nil {
+		return nil, err
+	}
+	var certificate []byte
+	if ref.DemandEncryption {
+		certificate = ref.Certificate
+	}
+	client, err := utils.GetPooledHttpClient(certificate, ref.SANInCertificate, connStr, base.DefaultConnectionSize, service.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	service.http_clients_lock.Lock()
+	defer service.http_clients_lock.Unlock()
+	if pooledClient, ok := service.http_clients[ref.Uuid]; ok {
+		if sameHttpClientSettings(pooledClient.ref, ref) {
+			// another go routine has built a client for the same reference in the meantime. use that one
+			closeHttpClient(client)
+			return pooledClient.client, nil
+		}
+		service.logger.Infof("Rebuilding http client of remote cluster %v since its reference has been changed\n", ref.Name)
+		closeHttpClient(pooledClient.client)
+	}
+	service.http_clients[ref.Uuid] = &remoteClusterHttpClient{client: client, ref: ref.Clone()}
+	return client, nil
+}
+
+// removes the pooled http client of the remote cluster, if any, and closes its idle connections
+func (service *RemoteClusterService) invalidateHttpClient(uuid string) {
+	service.http_clients_lock.Lock()
+	defer service.http_clients_lock.Unlock()
+	if pooledClient, ok := service.http_clients[uuid]; ok {
+		closeHttpClient(pooledClient.client)
+		delete(service.http_clients, uuid)
+	}
+}
+
+// drops what has been cached for oldRef, e.g., when its certificate has been rotated.
+// the pooled http client and its tls config are rebuilt with the new reference when they are next used.
+// newRef is nil when the reference has been deleted
+func (service *RemoteClusterService) onRefChanged(oldRef, newRef *metadata.RemoteClusterReference) {
+	if newRef != nil && !bytes.Equal(oldRef.Certificate, newRef.Certificate) {
+		service.logger.Infof("Certificate of remote cluster %v has been rotated. old fingerprint=%v, new fingerprint=%v\n",
+			newRef.Name, certificateFingerprint(oldRef.Certificate), certificateFingerprint(newRef.Certificate))
+	}
+	service.invalidateHttpClient(oldRef.Uuid)
+}
+
+// sha256 fingerprint of the first certificate in pem encoded certificate, for logging
+func certificateFingerprint(certificate []byte) string {
+	if len(certificate) == 0 {
+		return "none"
+	}
+	der := certificate
+	if block, _ := pem.Decode(certificate); block != nil {
+		der = block.Bytes
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(der))
+}
+
+// whether a http client built for ref can be used for ref2
+func sameHttpClientSettings(ref, ref2 *metadata.RemoteClusterReference) bool {
+	return ref.HostName == ref2.HostName && ref.HttpsHostName == ref2.HttpsHostName &&
+		ref.UserName == ref2.UserName && ref.Password == ref2.Password &&
+		ref.DemandEncryption == ref2.DemandEncryption && bytes.Equal(ref.Certificate, ref2.Certificate) &&
+		ref.SANInCertificate == ref2.SANInCertificate
+}
+
+// connections in use are closed by their callers after the rest calls complete
+func closeHttpClient(client *http.Client) {
+	if transport, ok := client.Transport.(*http.Transport); ok {
+		transport.CloseIdleConnections()
+	}
+}
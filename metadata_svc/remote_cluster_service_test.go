@@ -0,0 +1,307 @@
+// Copyright (c) 2013 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package metadata_svc
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/log"
+	"github.com/couchbase/goxdcr/metadata"
+	"github.com/couchbase/goxdcr/service_def"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// constructs a RemoteClusterService with an in-memory cache holding the given references, bypassing metakv
+func newTestRemoteClusterService(refs ...*metadata.RemoteClusterReference) *RemoteClusterService {
+	logger := log.NewLogger("RemoteClusterService", log.DefaultLoggerContext)
+	service := &RemoteClusterService{
+		cache:        NewMetadataCache(logger),
+		cache_lock:   &sync.Mutex{},
+		logger:       logger,
+		http_clients: make(map[string]*remoteClusterHttpClient),
+	}
+	for _, ref := range refs {
+		service.cache.Upsert(ref.Id, &remoteClusterVal{key: ref.Id, ref: ref, cas: CAS_NEW_ENTRY})
+	}
+	return service
+}
+
+func TestRemoteClusterByHostName(t *testing.T) {
+	service := newTestRemoteClusterService(
+		&metadata.RemoteClusterReference{Id: "ref1", Name: "cluster1", HostName: "host1:8091"},
+		&metadata.RemoteClusterReference{Id: "ref2", Name: "cluster2", HostName: "host2:8091"},
+		&metadata.RemoteClusterReference{Id: "ref3", Name: "cluster3", HostName: "host2:9000"})
+
+	for _, hostName := range []string{"host1", "host1:8091", "http://host1:8091/"} {
+		ref, err := service.RemoteClusterByHostName(hostName, false)
+		if err != nil {
+			t.Fatalf("Failed to get remote cluster by host name %v. err=%v", hostName, err)
+		}
+		if ref.Name != "cluster1" {
+			t.Errorf("Got remote cluster %v by host name %v, expected cluster1", ref.Name, hostName)
+		}
+	}
+
+	// references to the same host on alternate ports are told apart by port
+	ref, err := service.RemoteClusterByHostName("host2:9000", false)
+	if err != nil || ref.Name != "cluster3" {
+		t.Errorf("Got remote cluster %v and err=%v by host name with port, expected cluster3", ref, err)
+	}
+	if _, err = service.RemoteClusterByHostName("host2", false); err == nil {
+		t.Errorf("Expected error for host name that matches multiple remote clusters")
+	}
+
+	for _, hostName := range []string{"host3", "host1:9000"} {
+		if _, err = service.RemoteClusterByHostName(hostName, false); err != service_def.MetadataNotFoundErr {
+			t.Errorf("Got err=%v by non-existing host name %v, expected %v", err, hostName, service_def.MetadataNotFoundErr)
+		}
+	}
+}
+
+func TestGetHttpClient(t *testing.T) {
+	ref := &metadata.RemoteClusterReference{Id: "ref1", Uuid: "uuid1", Name: "cluster1", HostName: "host1:8091", UserName: "user", Password: "password"}
+	service := newTestRemoteClusterService(ref)
+
+	client, err := service.GetHttpClient(ref)
+	if err != nil {
+		t.Fatalf("Failed to get http client. err=%v", err)
+	}
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok || transport.MaxIdleConnsPerHost != base.DefaultConnectionSize || transport.TLSClientConfig != nil {
+		t.Errorf("http client has unexpected transport %+v", client.Transport)
+	}
+
+	// client is shared by callers as long as the reference is not changed
+	client2, err := service.GetHttpClient(ref.Clone())
+	if err != nil || client2 != client {
+		t.Errorf("Got a different http client %v for the same reference. err=%v", client2, err)
+	}
+
+	changedRef := ref.Clone()
+	changedRef.Password = "newPassword"
+	client3, err := service.GetHttpClient(changedRef)
+	if err != nil || client3 == client {
+		t.Errorf("Got the same http client after credentials have been changed. err=%v", err)
+	}
+
+	// client is removed when the reference is deleted
+	if err = service.updateCache(ref.Id, nil); err != nil {
+		t.Fatalf("Failed to delete reference. err=%v", err)
+	}
+	if _, ok := service.http_clients[ref.Uuid]; ok {
+		t.Errorf("http client of deleted reference has not been removed")
+	}
+}
+
+func TestGetHttpClientConcurrently(t *testing.T) {
+	ref := &metadata.RemoteClusterReference{Id: "ref1", Uuid: "uuid1", Name: "cluster1", HostName: "host1:8091", UserName: "user", Password: "password"}
+	service := newTestRemoteClusterService(ref)
+
+	// clients are built outside of the lock. concurrent callers should still end up sharing a single client
+	numOfCallers := 10
+	clients := make([]*http.Client, numOfCallers)
+	errs := make([]error, numOfCallers)
+	var waitGrp sync.WaitGroup
+	for i := 0; i < numOfCallers; i++ {
+		waitGrp.Add(1)
+		go func(i int) {
+			defer waitGrp.Done()
+			clients[i], errs[i] = service.GetHttpClient(ref.Clone())
+		}(i)
+	}
+	waitGrp.Wait()
+
+	pooledClient, ok := service.http_clients[ref.Uuid]
+	if !ok {
+		t.Fatalf("http client has not been pooled")
+	}
+	for i := 0; i < numOfCallers; i++ {
+		if errs[i] != nil {
+			t.Errorf("Failed to get http client. err=%v", errs[i])
+		} else if clients[i] != pooledClient.client {
+			t.Errorf("Caller %v got a http client other than the pooled one", i)
+		}
+	}
+}
+
+// returns a self signed certificate with the given common name, in pem and in the form used by tls servers
+func newTestCertificate(t *testing.T, commonName string) ([]byte, tls.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key. err=%v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate. err=%v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// whether the ca pool of the http client contains the certificate
+func httpClientTrusts(client *http.Client, certificate []byte) bool {
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok || transport.TLSClientConfig == nil || transport.TLSClientConfig.RootCAs == nil {
+		return false
+	}
+	block, _ := pem.Decode(certificate)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return false
+	}
+	for _, subject := range transport.TLSClientConfig.RootCAs.Subjects() {
+		if bytes.Equal(subject, cert.RawSubject) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCertificateRotation(t *testing.T) {
+	oldCertificate, tlsCertificate := newTestCertificate(t, "oldCA")
+	newCertificate, _ := newTestCertificate(t, "newCA")
+
+	// http clients verify certificates by connecting to target when they are built
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{tlsCertificate}})
+	if err != nil {
+		t.Fatalf("Failed to listen. err=%v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	ref := &metadata.RemoteClusterReference{Id: "ref1", Uuid: "uuid1", Name: "cluster1", HostName: "host1:8091",
+		HttpsHostName: listener.Addr().String(), DemandEncryption: true, Certificate: oldCertificate}
+	service := newTestRemoteClusterService(ref)
+
+	client, err := service.GetHttpClient(ref)
+	if err != nil {
+		t.Fatalf("Failed to get http client. err=%v", err)
+	}
+	if !httpClientTrusts(client, oldCertificate) {
+		t.Errorf("http client does not trust the certificate of the reference")
+	}
+
+	// certificate is rotated through metakv callback
+	rotatedRef := ref.Clone()
+	rotatedRef.Certificate = newCertificate
+	service.updateCache(ref.Id, rotatedRef)
+	if _, ok := service.http_clients[ref.Uuid]; ok {
+		t.Errorf("http client with the old certificate has not been removed")
+	}
+
+	client2, err := service.GetHttpClient(rotatedRef)
+	if err != nil {
+		t.Fatalf("Failed to get http client after certificate rotation. err=%v", err)
+	}
+	if client2 == client || !httpClientTrusts(client2, newCertificate) || httpClientTrusts(client2, oldCertificate) {
+		t.Errorf("http client does not use the new certificate after certificate rotation")
+	}
+
+	if certificateFingerprint(oldCertificate) == certificateFingerprint(newCertificate) {
+		t.Errorf("old and new certificates have the same fingerprint")
+	}
+}
+
+func TestValidateRemoteClusterConnectivity(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, _ := r.BasicAuth(); username != "admin" || password != "password" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"pools":[{"name":"default"}],"uuid":"uuid1"}`))
+	}))
+	defer server.Close()
+	serverAddr := server.Listener.Addr().String()
+
+	// server whose /pools response does not have the expected format
+	incompatibleServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer incompatibleServer.Close()
+
+	// server of a node that has not been initialized, which has no pools
+	uninitializedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"pools":[]}`))
+	}))
+	defer uninitializedServer.Close()
+
+	// reserve a port and release it, so that nothing listens on it
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen. err=%v", err)
+	}
+	closedAddr := listener.Addr().String()
+	listener.Close()
+
+	service := newTestRemoteClusterService()
+
+	ref := &metadata.RemoteClusterReference{Name: "cluster1", HostName: serverAddr, UserName: "admin", Password: "password"}
+	if err := service.ValidateRemoteClusterConnectivity(ref); err != nil {
+		t.Errorf("Unexpected error validating reachable remote cluster. err=%v", err)
+	}
+
+	incompatibleAddr := incompatibleServer.Listener.Addr().String()
+	uninitializedAddr := uninitializedServer.Listener.Addr().String()
+	expected := []struct {
+		ref      *metadata.RemoteClusterReference
+		category RemoteClusterConnectivityErrorCategory
+	}{
+		{&metadata.RemoteClusterReference{Name: "cluster2", HostName: serverAddr, UserName: "admin", Password: "wrong"}, RemoteClusterAuthFailure},
+		{&metadata.RemoteClusterReference{Name: "cluster3", HostName: closedAddr, UserName: "admin", Password: "password"}, RemoteClusterUnreachable},
+		{&metadata.RemoteClusterReference{Name: "cluster4", HostName: incompatibleAddr, UserName: "admin", Password: "password"}, RemoteClusterIncompatibleVersion},
+		{&metadata.RemoteClusterReference{Name: "cluster5", HostName: uninitializedAddr, UserName: "admin", Password: "password"}, RemoteClusterNotInitialized},
+	}
+	for _, test := range expected {
+		ref, category := test.ref, test.category
+		err := service.ValidateRemoteClusterConnectivity(ref)
+		connectivityErr, ok := err.(*RemoteClusterConnectivityError)
+		if !ok {
+			t.Errorf("Got err=%v validating %v, expected connectivity error", err, ref.Name)
+			continue
+		}
+		if connectivityErr.Category != category {
+			t.Errorf("Got category %v validating %v, expected %v", connectivityErr.Category, ref.Name, category)
+		}
+		// the category is surfaced to adminport after the error is unwrapped
+		isValidationErr, unwrappedErr := service.CheckAndUnwrapRemoteClusterError(err)
+		if !isValidationErr || !strings.HasPrefix(unwrappedErr.Error(), category.String()) {
+			t.Errorf("Got validation error=%v and err=%v after unwrapping, expected category %v", isValidationErr, unwrappedErr, category)
+		}
+	}
+}
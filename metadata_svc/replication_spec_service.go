@@ -11,34 +11,91 @@
 package metadata_svc
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/couchbase/go-couchbase"
 	"github.com/couchbase/goxdcr/base"
 	"github.com/couchbase/goxdcr/log"
 	"github.com/couchbase/goxdcr/metadata"
 	"github.com/couchbase/goxdcr/service_def"
 	"github.com/couchbase/goxdcr/utils"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
 	// parent dir of all Replication Specs
 	ReplicationSpecsCatalogKey = "replicationSpec"
+	// parent dir of the derived objects of replication specs that have been persisted through SetDerivedObjWithPersist
+	ReplicationSpecDerivedObjsCatalogKey = "replicationSpecDerivedObj"
+	// parent dir of the filters registered through RegisterNamedFilter
+	NamedFiltersCatalogKey = "namedFilter"
 )
 
 var ReplicationSpecAlreadyExistErrorMessage = "Replication to the same remote cluster and bucket already exists"
 var ReplicationSpecNotFoundErrorMessage = "Requested resource not found"
+var ReplicationSpecCorruptedErrorMessage = "Replication spec in metadata store is corrupted"
 var InvalidReplicationSpecError = errors.New("Invalid Replication spec")
+var ReplicationSpecWriteNotVisibleError = errors.New("Replication spec was added but the write did not become visible in time")
+var DuplicateReplicationSpecInBatchError = errors.New("Replication spec appears more than once in the batch")
+var BulkAddAbortedError = errors.New("Replication spec has not been added since other replication specs in the batch failed to be added")
+var InvalidGCSuspensionDurationError = errors.New("Duration of garbage collection suspension needs to be positive")
+var GCSuspendedError = errors.New("Garbage collection of replication specs is suspended")
+
+// returned when the replication to be added already exists. the message is shown on UI and is kept as is
+type SpecAlreadyExistsError struct {
+	ReplicationId string
+}
+
+func (err *SpecAlreadyExistsError) Error() string {
+	return ReplicationSpecAlreadyExistErrorMessage
+}
+
+// returned when the requested replication does not exist. the message is shown on UI and is kept as is
+type SpecNotFoundError struct {
+	ReplicationId string
+}
+
+func (err *SpecNotFoundError) Error() string {
+	return ReplicationSpecNotFoundErrorMessage
+}
+
+// interval between checks of the visibility of an added replication spec
+var ReplicationSpecVisibilityCheckInterval = 100 * time.Millisecond
+
+// the number of workers that construct replication specs when cache is initialized. 0 means the number of cpus
+var SpecCacheLoadConcurrency = 0
+
+// how long the compatibility of a target cluster with a version stays valid in cache, so that validations of replications
+// to the same target cluster within a short window do not each retrieve the cluster version. 0 disables the cache
+var ClusterCompatibilityCacheExpiry = 30 * time.Second
+
+type cachedClusterCompatibility struct {
+	compatible bool
+	cachedTime time.Time
+}
+
+// restores a derived object persisted through SetDerivedObjWithPersist from its serialized form
+type DerivedObjUnmarshaller func(specId string, data []byte) (interface{}, error)
 
 //replication spec and its derived object
 //This is what is put into the cache
 type ReplicationSpecVal struct {
 	spec       *metadata.ReplicationSpecification
 	derivedObj interface{}
-	cas        int64
+	// lifecycle state of the replication on this node. it is runtime information and is not persisted
+	state metadata.ReplicationState
+	cas   int64
 }
 
 func (rsv *ReplicationSpecVal) CAS(obj CacheableMetadataObj) bool {
@@ -61,6 +118,22 @@ func (rsv *ReplicationSpecVal) CAS(obj CacheableMetadataObj) bool {
 	}
 }
 
+// spec is nil when the spec has been deleted while its derived object is still cached
+func (rsv *ReplicationSpecVal) IsDeleted() bool {
+	return rsv.spec == nil
+}
+
+func (rsv *ReplicationSpecVal) MarshaledSize() (int, error) {
+	if rsv.spec == nil {
+		return 0, nil
+	}
+	value, err := json.Marshal(rsv.spec)
+	if err != nil {
+		return 0, err
+	}
+	return len(value), nil
+}
+
 type ReplicationSpecService struct {
 	xdcr_comp_topology_svc   service_def.XDCRCompTopologySvc
 	metadata_svc             service_def.MetadataSvc
@@ -71,11 +144,62 @@ type ReplicationSpecService struct {
 	cache_lock               *sync.Mutex
 	logger                   *log.CommonLogger
 	metadata_change_callback base.MetadataChangeHandlerCallback
+	// copy-on-write snapshot of all non-deleted specs in cache, map[string]*metadata.ReplicationSpecification.
+	// it is rebuilt whenever the spec portion of the cache changes, so that readers never need to copy
+	specs_snapshot *atomic.Value
+	// paces writes to metadata store
+	write_limiter *metadataWriteLimiter
+	// bounded history of settings changes made through this service, keyed by replication id
+	settings_history      map[string][]metadata.SettingsChange
+	settings_history_lock sync.RWMutex
+	// 1 when the last write to metadata store failed because metadata store is out of space
+	store_full int32
+	// ValidateAndGC does not garbage collect specs until this time, e.g., during maintenance
+	// when specs may be transiently invalid. zero when garbage collection is not suspended
+	gc_suspended_until time.Time
+	gc_suspension_lock sync.RWMutex
+	// callbacks registered through Subscribe, keyed by subscription id
+	subscribers        map[uint64]service_def.ReplicationSpecChangeCallback
+	next_subscriber_id uint64
+	// spec changes not yet delivered to subscribers, in the order in which they have been made to cache
+	pending_spec_changes []*specChange
+	// whether some goroutine is delivering pending_spec_changes
+	dispatching_spec_changes bool
+	subscribers_lock         sync.Mutex
+	// restores persisted derived objects when cache is initialized. derived objects are not restored when it is nil
+	derived_obj_unmarshal DerivedObjUnmarshaller
+	// ids of specs whose latest value from metakv could not be unmarshaled. the spec in cache may be stale,
+	// and is re-fetched from metadata store when it is accessed next time
+	corrupted_specs      map[string]bool
+	corrupted_specs_lock sync.RWMutex
+	// compatibility of target clusters with versions, keyed by cluster uuid and version
+	cluster_compatibility      map[string]*cachedClusterCompatibility
+	cluster_compatibility_lock sync.RWMutex
+	// number of times that the cache has been updated for each spec id, including updates that leave the cache
+	// as it is, e.g., deletions of specs not in cache. protected by cache_lock. see refreshCachedSpec
+	cache_update_counts map[string]uint64
+	// looks up buckets in the local cluster, which is done through ns_server with cbauth credentials
+	local_bucket_getter func(localConnStr, bucketName string) (*couchbase.Bucket, error)
+}
+
+type specChange struct {
+	specId  string
+	oldSpec *metadata.ReplicationSpecification
+	newSpec *metadata.ReplicationSpecification
 }
 
 func NewReplicationSpecService(uilog_svc service_def.UILogSvc, remote_cluster_svc service_def.RemoteClusterSvc,
 	metadata_svc service_def.MetadataSvc, xdcr_comp_topology_svc service_def.XDCRCompTopologySvc, cluster_info_svc service_def.ClusterInfoSvc,
 	logger_ctx *log.LoggerContext) (*ReplicationSpecService, error) {
+	return NewReplicationSpecServiceWithDerivedObjs(uilog_svc, remote_cluster_svc, metadata_svc, xdcr_comp_topology_svc,
+		cluster_info_svc, logger_ctx, nil)
+}
+
+// constructs a ReplicationSpecService that restores the derived objects persisted through SetDerivedObjWithPersist
+// with derived_obj_unmarshal when its cache is initialized
+func NewReplicationSpecServiceWithDerivedObjs(uilog_svc service_def.UILogSvc, remote_cluster_svc service_def.RemoteClusterSvc,
+	metadata_svc service_def.MetadataSvc, xdcr_comp_topology_svc service_def.XDCRCompTopologySvc, cluster_info_svc service_def.ClusterInfoSvc,
+	logger_ctx *log.LoggerContext, derived_obj_unmarshal DerivedObjUnmarshaller) (*ReplicationSpecService, error) {
 	logger := log.NewLogger("ReplicationSpecService", logger_ctx)
 	svc := &ReplicationSpecService{
 		metadata_svc:           metadata_svc,
@@ -85,7 +209,12 @@ func NewReplicationSpecService(uilog_svc service_def.UILogSvc, remote_cluster_sv
 		cluster_info_svc:       cluster_info_svc,
 		cache:                  nil,
 		cache_lock:             &sync.Mutex{},
+		specs_snapshot:         &atomic.Value{},
 		logger:                 logger,
+		write_limiter:          newMetadataWriteLimiter(0),
+		settings_history:       make(map[string][]metadata.SettingsChange),
+		derived_obj_unmarshal:  derived_obj_unmarshal,
+		local_bucket_getter:    utils.LocalBucket,
 	}
 
 	err := svc.initCache()
@@ -95,6 +224,13 @@ func NewReplicationSpecService(uilog_svc service_def.UILogSvc, remote_cluster_sv
 	return svc, nil
 }
 
+// limits the number of writes to metadata store per second. bulk operations pace themselves accordingly.
+// 0 removes the limit
+func (service *ReplicationSpecService) SetMetadataWriteRate(opsPerSec int) {
+	service.logger.Infof("Setting metadata write rate to %v ops/sec\n", opsPerSec)
+	service.write_limiter.setRate(opsPerSec)
+}
+
 func (service *ReplicationSpecService) SetMetadataChangeHandlerCallback(call_back base.MetadataChangeHandlerCallback) {
 	service.metadata_change_callback = call_back
 }
@@ -109,19 +245,191 @@ func (service *ReplicationSpecService) initCache() error {
 		return err
 	}
 
-	for _, entry := range entries {
-		spec, err := constructReplicationSpec(entry.Value, entry.Rev)
-		if err != nil || spec == nil {
-			service.logger.Errorf("Failed to contruct replication spec, key=%v, err=%v\n", entry.Key, err)
-			return err
+	specs, err := service.constructSpecs(entries)
+	if err != nil {
+		return err
+	}
+	for index, spec := range specs {
+		if spec == nil {
+			service.logger.Errorf("Skipping replication spec with empty value, key=%v\n", entries[index].Key)
+			continue
 		}
 		service.cacheSpec(cache, spec.Id, spec)
 	}
+	if service.derived_obj_unmarshal != nil {
+		service.restoreDerivedObjs(cache)
+	}
 	service.cache = cache
+	service.refreshSpecsSnapshot()
 	service.logger.Info("Cache has been initialized for ReplicationSpecService")
 	return nil
 }
 
+// reconciles the cache with metadata store at a fraction of the cost of initCache, which rebuilds the whole cache.
+// only the specs whose revisions differ from those in the cache are constructed and updated in the cache, and the specs
+// that are no longer in metadata store are removed from the cache. changes go through updateCache, so that callbacks
+// and subscribers are notified in the same way as they are of changes from metakv callbacks.
+// a spec that fails to be constructed does not stop the refresh. it is marked as corrupted, and the first such error is returned.
+// a spec that has been changed in metadata store since catalog was read is left to the metakv callbacks, so that a spec
+// deleted or recreated while the refresh is in progress is not put back into or removed from the cache
+func (service *ReplicationSpecService) RefreshCache() (added, updated, removed int, err error) {
+	// taken before catalog is read, so that specs cached after that are not mistaken for specs that are no longer in catalog
+	cachedSpecs, _ := service.AllReplicationSpecs()
+
+	entries, err := service.metadata_svc.GetAllMetadataFromCatalog(ReplicationSpecsCatalogKey)
+	if err != nil {
+		service.logger.Errorf("Failed to get all entries, err=%v\n", err)
+		return
+	}
+
+	inCatalog := make(map[string]bool)
+	for _, entry := range entries {
+		specId := service.getReplicationIdFromKey(entry.Key)
+		inCatalog[specId] = true
+
+		cachedSpec := cachedSpecs[specId]
+		if cachedSpec != nil && reflect.DeepEqual(cachedSpec.Revision, entry.Rev) && !service.isSpecCorrupted(specId) {
+			continue
+		}
+
+		spec, err_construct := constructReplicationSpec(entry.Value, entry.Rev)
+		if err_construct != nil {
+			service.logger.Errorf("Failed to contruct replication spec during cache refresh. Marking it as corrupted. key=%v, rev=%v, err=%v\n", entry.Key, entry.Rev, err_construct)
+			service.markSpecCorrupted(specId, true)
+			if err == nil {
+				err = err_construct
+			}
+			continue
+		}
+		if spec == nil {
+			service.logger.Errorf("Skipping replication spec with empty value, key=%v\n", entry.Key)
+			continue
+		}
+
+		current, err_update := service.refreshCachedSpec(specId, spec, entry.Rev)
+		if err_update != nil {
+			service.logger.Errorf("Failed to update replication spec in cache during cache refresh. key=%v, rev=%v, err=%v\n", entry.Key, entry.Rev, err_update)
+			if err == nil {
+				err = err_update
+			}
+			continue
+		}
+		if !current {
+			service.logger.Infof("Skipping replication spec changed since cache refresh started. key=%v, rev=%v\n", entry.Key, entry.Rev)
+			continue
+		}
+		service.markSpecCorrupted(specId, false)
+		if cachedSpec == nil {
+			added++
+		} else {
+			updated++
+		}
+	}
+
+	for specId, _ := range cachedSpecs {
+		if inCatalog[specId] {
+			continue
+		}
+		current, err_update := service.refreshCachedSpec(specId, nil, nil)
+		if err_update != nil {
+			service.logger.Errorf("Failed to remove replication spec from cache during cache refresh. id=%v, err=%v\n", specId, err_update)
+			if err == nil {
+				err = err_update
+			}
+			continue
+		}
+		if !current {
+			service.logger.Infof("Skipping replication spec recreated since cache refresh started. id=%v\n", specId)
+			continue
+		}
+		removed++
+	}
+
+	service.logger.Infof("Cache has been refreshed for ReplicationSpecService. added=%v, updated=%v, removed=%v, err=%v\n", added, updated, removed, err)
+	return
+}
+
+// updates the cache with a spec read during cache refresh, or removes the spec from the cache when newSpec is nil,
+// unless the spec has been changed since. metadata store is read before cache_lock is taken, so that a slow read does
+// not block other updates to the cache. a change made after the read is detected under cache_lock when its metakv
+// callback has updated the cache in the meantime, and is applied by its callback after the refresh otherwise.
+// returns whether the cache has been updated
+func (service *ReplicationSpecService) refreshCachedSpec(specId string, newSpec *metadata.ReplicationSpecification, rev interface{}) (bool, error) {
+	updateCount := service.cacheUpdateCount(specId)
+	if !service.isSpecCurrent(specId, rev, newSpec == nil /*deleted*/) {
+		return false, nil
+	}
+	return service.updateCacheIf(specId, newSpec, func() bool {
+		return service.cache_update_counts[specId] == updateCount
+	})
+}
+
+func (service *ReplicationSpecService) cacheUpdateCount(specId string) uint64 {
+	service.cache_lock.Lock()
+	defer service.cache_lock.Unlock()
+	return service.cache_update_counts[specId]
+}
+
+// checks whether the spec in metadata store has the given revision, or, when deleted is true, whether the spec
+// is not in metadata store. errors reading the spec are taken as the spec not being current
+func (service *ReplicationSpecService) isSpecCurrent(specId string, rev interface{}, deleted bool) bool {
+	_, curRev, err := service.metadata_svc.Get(getKeyFromReplicationId(specId))
+	if err == service_def.MetadataNotFoundErr {
+		return deleted
+	}
+	return err == nil && !deleted && reflect.DeepEqual(curRev, rev)
+}
+
+// constructs replication specs from catalog entries with a bounded number of workers.
+// specs are returned in the order of entries, and so is the first error encountered, so that the result is deterministic
+func (service *ReplicationSpecService) constructSpecs(entries []*service_def.MetadataEntry) ([]*metadata.ReplicationSpecification, error) {
+	specs := make([]*metadata.ReplicationSpecification, len(entries))
+	errs := make([]error, len(entries))
+
+	numOfWorkers := SpecCacheLoadConcurrency
+	if numOfWorkers <= 0 {
+		numOfWorkers = runtime.NumCPU()
+	}
+	if numOfWorkers > len(entries) {
+		numOfWorkers = len(entries)
+	}
+
+	index_ch := make(chan int, len(entries))
+	for index := range entries {
+		index_ch <- index
+	}
+	close(index_ch)
+
+	// set when any construction fails, so that the remaining entries are skipped, since cache init is aborted anyway
+	var failed int32
+	wait_grp := &sync.WaitGroup{}
+	for i := 0; i < numOfWorkers; i++ {
+		wait_grp.Add(1)
+		go func() {
+			defer wait_grp.Done()
+			// each worker writes to distinct indexes only
+			for index := range index_ch {
+				if atomic.LoadInt32(&failed) != 0 {
+					return
+				}
+				specs[index], errs[index] = constructReplicationSpec(entries[index].Value, entries[index].Rev)
+				if errs[index] != nil {
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}()
+	}
+	wait_grp.Wait()
+
+	for index, err := range errs {
+		if err != nil {
+			service.logger.Errorf("Failed to contruct replication spec, key=%v, err=%v\n", entries[index].Key, err)
+			return nil, err
+		}
+	}
+	return specs, nil
+}
+
 func (service *ReplicationSpecService) getCache() *MetadataCache {
 	if service.cache == nil {
 		panic("Cache has not been initialized for ReplicationSpecService")
@@ -129,30 +437,67 @@ func (service *ReplicationSpecService) getCache() *MetadataCache {
 	return service.cache
 }
 
+// replicationId may be url escaped or padded with whitespaces, in which case it is normalized before lookup
 func (service *ReplicationSpecService) ReplicationSpec(replicationId string) (*metadata.ReplicationSpecification, error) {
-	spec, err := service.replicationSpec(replicationId)
+	spec, err := service.replicationSpecWithRecovery(replicationId)
 	if err != nil {
-		return nil, err
+		// try again with normalized id. exact match is tried first since '%' is allowed in bucket names,
+		// and unescaping a valid id could turn it into a different id
+		normalizedId := normalizeReplicationId(replicationId)
+		if normalizedId == replicationId {
+			return nil, err
+		}
+		spec, err = service.replicationSpecWithRecovery(normalizedId)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// return a clone so that modification to the spec returned won't affect the spec in cache
 	return spec.Clone(), nil
 }
 
+// unescapes and trims replication id
+func normalizeReplicationId(replicationId string) string {
+	normalizedId := strings.TrimSpace(replicationId)
+	unescapedId, err := url.QueryUnescape(normalizedId)
+	if err == nil {
+		normalizedId = strings.TrimSpace(unescapedId)
+	}
+	return normalizedId
+}
+
 // this method is cheaper than ReplicationSpec() and should be called only when the spec returned won't be modified or that the modifications do not matter.
 func (service *ReplicationSpecService) replicationSpec(replicationId string) (*metadata.ReplicationSpecification, error) {
 	val, ok := service.getCache().Get(replicationId)
 	if !ok || val == nil || val.(*ReplicationSpecVal).spec == nil {
-		return nil, errors.New(ReplicationSpecNotFoundErrorMessage)
+		return nil, &SpecNotFoundError{ReplicationId: replicationId}
 	}
 
 	return val.(*ReplicationSpecVal).spec, nil
 }
 
-func (service *ReplicationSpecService) ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket string, settings map[string]interface{}) (string, string, *metadata.RemoteClusterReference, map[string]error) {
+// whether the replication exists in cache. unlike ReplicationSpec, replicationId is not normalized, and no error is
+// constructed for missing replications, which makes it cheap enough for existence checks in hot paths
+func (service *ReplicationSpecService) HasReplicationSpec(replicationId string) bool {
+	val, ok := service.getCache().Get(replicationId)
+	return ok && val != nil && val.(*ReplicationSpecVal).spec != nil
+}
+
+// validation is aborted when ctx is cancelled, in which case errorMap contains service_def.ValidationCancelledError
+// warningMap contains advisory issues that do not prevent the replication from being created
+func (service *ReplicationSpecService) ValidateNewReplicationSpec(ctx context.Context, sourceBucket, targetCluster, targetBucket string, settings map[string]interface{}) (string, string, *metadata.RemoteClusterReference, map[string]error, map[string]error) {
 	service.logger.Infof("Start ValidateAddReplicationSpec, sourceBucket=%v, targetCluster=%v, targetBucket=%v\n", sourceBucket, targetCluster, targetBucket)
 
 	errorMap := make(map[string]error)
+	warningMap := make(map[string]error)
+	settings = normalizeSettingsMap(settings)
+
+	// validate filter expression before any remote look up, so that a malformed expression is reported
+	// before the spec is persisted instead of when the pipeline starts
+	service.resolveFilterName(settings, errorMap)
+	validateFilterExpression(settings, errorMap)
+	validateFilterKeyPrefix(settings, errorMap)
 
 	//validate the existence of source bucket
 	local_connStr, _ := service.xdcr_comp_topology_svc.MyConnectionStr()
@@ -162,7 +507,7 @@ func (service *ReplicationSpecService) ValidateNewReplicationSpec(sourceBucket,
 
 	var err_source error
 	start_time := time.Now()
-	sourceBucketObj, err_source := utils.LocalBucket(local_connStr, sourceBucket)
+	sourceBucketObj, err_source := service.local_bucket_getter(local_connStr, sourceBucket)
 	service.logger.Infof("Result from local bucket look up: err_source=%v, time taken=%v\n", err_source, time.Since(start_time))
 	service.validateBucket(sourceBucket, targetCluster, targetBucket, sourceBucketObj.Type, err_source, errorMap, true)
 
@@ -171,15 +516,28 @@ func (service *ReplicationSpecService) ValidateNewReplicationSpec(sourceBucket,
 		sourceBucketUUID = sourceBucketObj.UUID
 	}
 
+	if service.isValidationCancelled(ctx, errorMap) {
+		return "", "", nil, errorMap, nil
+	}
+
 	// validate remote cluster ref
 	start_time = time.Now()
 	targetClusterRef, err := service.remote_cluster_svc.RemoteClusterByRefName(targetCluster, true)
 	if err != nil {
 		errorMap[base.ToCluster] = utils.NewEnhancedError("cannot find remote cluster", err)
-		return "", "", nil, errorMap
+		return "", "", nil, errorMap, nil
 	}
 	service.logger.Infof("Successfully retrieved target cluster reference. time take=%v\n", time.Since(start_time))
 
+	if targetClusterRef.ReadOnly {
+		errorMap[base.ToCluster] = fmt.Errorf("Remote cluster %v is read-only, and cannot be the target of replications", targetCluster)
+		return "", "", nil, errorMap, nil
+	}
+
+	if service.isValidationCancelled(ctx, errorMap) {
+		return "", "", nil, errorMap, nil
+	}
+
 	// validate that the source bucket and target bucket are not the same bucket
 	// i.e., validate that the following are not both true:
 	// 1. sourceBucketName == targetBucketName
@@ -193,7 +551,7 @@ func (service *ReplicationSpecService) ValidateNewReplicationSpec(sourceBucket,
 
 		if sourceClusterUuid == targetClusterRef.Uuid {
 			errorMap[base.PlaceHolderFieldKey] = errors.New("Replication from a bucket to the same bucket is not allowed")
-			return "", "", nil, errorMap
+			return "", "", nil, errorMap, nil
 		}
 		service.logger.Infof("Validated that source bucket and target bucket are not the same. time taken=%v\n", time.Since(start_time))
 	}
@@ -201,18 +559,19 @@ func (service *ReplicationSpecService) ValidateNewReplicationSpec(sourceBucket,
 	remote_connStr, err := targetClusterRef.MyConnectionStr()
 	if err != nil {
 		errorMap[base.ToCluster] = utils.NewEnhancedError("Invalid remote cluster. MyConnectionStr() failed.", err)
-		return "", "", nil, errorMap
+		return "", "", nil, errorMap, nil
 	}
 	remote_userName, remote_password, certificate, sanInCertificate, err := targetClusterRef.MyCredentials()
 	if err != nil {
 		errorMap[base.ToCluster] = utils.NewEnhancedError("Invalid remote cluster. MyCredentials() failed.", err)
-		return "", "", nil, errorMap
+		return "", "", nil, errorMap, nil
 	}
 
 	//validate target bucket
 	start_time = time.Now()
 	//get uuid and type from bucket info
-	targetBucketInfo, err_target := utils.GetBucketInfo(remote_connStr, targetBucket, remote_userName, remote_password, certificate, sanInCertificate, service.logger)
+	targetBucketInfo, err_target := utils.GetBucketInfoWithRetry(ctx, remote_connStr, targetBucket, remote_userName, remote_password, certificate, sanInCertificate,
+		service.remoteClusterHttpClient(targetClusterRef), base.RemoteBucketLookupMaxAttempts, base.RemoteBucketLookupBaseBackoff, service.logger)
 
 	targetBucketType := ""
 	if err_target == nil && targetBucketInfo != nil {
@@ -228,17 +587,26 @@ func (service *ReplicationSpecService) ValidateNewReplicationSpec(sourceBucket,
 	}
 
 	service.logger.Infof("Result from remote bucket look up: err_target=%v, time taken=%v\n", err_target, time.Since(start_time))
+
+	if service.isValidationCancelled(ctx, errorMap) {
+		return "", "", nil, errorMap, nil
+	}
 	service.validateBucket(sourceBucket, targetCluster, targetBucket, targetBucketType, err_target, errorMap, false)
 
 	// validate that source and target bucket have the same conflict resolution type metadata
 	targetConflictResolutionType, err := utils.GetConflictResolutionTypeFromBucketInfo(targetBucket, targetBucketInfo)
 	if err != nil {
 		errorMap[base.PlaceHolderFieldKey] = errors.New("Error retrieving ConflictResolutionType setting on target bucket")
-		return "", "", nil, errorMap
+		return "", "", nil, errorMap, nil
+	}
+	if !service.validateConflictResolutionType(sourceBucket, targetBucket, sourceBucketObj.ConflictResolutionType, targetConflictResolutionType,
+		base.AllowConflictResolutionTypeMismatch, errorMap, warningMap) {
+		return "", "", nil, errorMap, nil
 	}
-	if sourceBucketObj.ConflictResolutionType != targetConflictResolutionType {
-		errorMap[base.PlaceHolderFieldKey] = errors.New("Replication between buckets with different ConflictResolutionType setting is not allowed")
-		return "", "", nil, errorMap
+
+	// with LWW, clock skew between source and target causes conflicts to be resolved incorrectly
+	if targetConflictResolutionType == base.ConflictResolutionType_Lww {
+		service.validateClockSkew(ctx, local_connStr, remote_connStr, remote_userName, remote_password, certificate, sanInCertificate, warningMap)
 	}
 
 	targetBucketUUID := ""
@@ -250,15 +618,13 @@ func (service *ReplicationSpecService) ValidateNewReplicationSpec(sourceBucket,
 	}
 
 	repId := metadata.ReplicationId(sourceBucket, targetClusterRef.Uuid, targetBucket)
-	_, err = service.replicationSpec(repId)
-	if err == nil {
-		errorMap[base.PlaceHolderFieldKey] = errors.New(ReplicationSpecAlreadyExistErrorMessage)
+	if service.HasReplicationSpec(repId) {
+		errorMap[base.PlaceHolderFieldKey] = &SpecAlreadyExistsError{ReplicationId: repId}
 	}
 
 	// if replication type is set to xmem, validate that the target cluster is xmem compatible
-	repl_type, ok := settings[metadata.ReplicationType]
-	if !ok || repl_type == metadata.ReplicationTypeXmem {
-		xmemCompatible, err := service.cluster_info_svc.IsClusterCompatible(targetClusterRef, []int{2, 2})
+	if replicationTypeFromSettingsMap(settings) == metadata.ReplicationTypeXmem {
+		xmemCompatible, err := service.isClusterCompatible(targetClusterRef, []int{2, 2})
 		if err != nil {
 			errMsg := fmt.Sprintf("Failed to get cluster version information, err=%v\n", err)
 			service.logger.Error(errMsg)
@@ -270,9 +636,270 @@ func (service *ReplicationSpecService) ValidateNewReplicationSpec(sourceBucket,
 		}
 	}
 
-	service.logger.Infof("Finished ValidateAddReplicationSpec. errorMap=%v\n", errorMap)
+	if service.isValidationCancelled(ctx, errorMap) {
+		return "", "", nil, errorMap, nil
+	}
+
+	service.logger.Infof("Finished ValidateAddReplicationSpec. errorMap=%v, warningMap=%v\n", errorMap, warningMap)
+
+	return sourceBucketUUID, targetBucketUUID, targetClusterRef, errorMap, warningMap
+}
+
+// same as ClusterInfoSvc.IsClusterCompatible, except that results are cached for ClusterCompatibilityCacheExpiry.
+// errors are not cached
+func (service *ReplicationSpecService) isClusterCompatible(targetClusterRef *metadata.RemoteClusterReference, version []int) (bool, error) {
+	key := clusterCompatibilityCacheKey(targetClusterRef.Uuid, version)
+	service.cluster_compatibility_lock.RLock()
+	cached, ok := service.cluster_compatibility[key]
+	service.cluster_compatibility_lock.RUnlock()
+	if ok && time.Since(cached.cachedTime) < ClusterCompatibilityCacheExpiry {
+		return cached.compatible, nil
+	}
+
+	compatible, err := service.cluster_info_svc.IsClusterCompatible(targetClusterRef, version)
+	if err != nil || ClusterCompatibilityCacheExpiry <= 0 {
+		return compatible, err
+	}
+
+	service.cluster_compatibility_lock.Lock()
+	defer service.cluster_compatibility_lock.Unlock()
+	if service.cluster_compatibility == nil {
+		service.cluster_compatibility = make(map[string]*cachedClusterCompatibility)
+	}
+	service.cluster_compatibility[key] = &cachedClusterCompatibility{compatible: compatible,
+		cachedTime: time.Now()}
+	return compatible, nil
+}
+
+// removes the cached compatibility of the target cluster, e.g., when its remote cluster reference has been changed
+func (service *ReplicationSpecService) InvalidateClusterCompatibility(clusterUUID string) {
+	prefix := clusterUUID + base.KeyPartsDelimiter
+	service.cluster_compatibility_lock.Lock()
+	defer service.cluster_compatibility_lock.Unlock()
+	for key, _ := range service.cluster_compatibility {
+		if strings.HasPrefix(key, prefix) {
+			delete(service.cluster_compatibility, key)
+		}
+	}
+}
+
+// registers filterExpression under name in metadata store, so that new replications on any node can reference it by name
+// instead of repeating it. registering an existing name replaces its expression, which does not affect the replications
+// already created with it, since they keep their own copies of the expression
+func (service *ReplicationSpecService) RegisterNamedFilter(name, filterExpression string) error {
+	if err := metadata.ValidateNamedFilter(name, filterExpression); err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(&metadata.NamedFilter{Name: name, Expression: filterExpression})
+	if err != nil {
+		return err
+	}
+	key := getKeyFromFilterName(name)
+	_, rev, err := service.metadata_svc.Get(key)
+	if err == service_def.MetadataNotFoundErr {
+		err = service.metadata_svc.AddWithCatalog(NamedFiltersCatalogKey, key, value)
+	} else if err == nil {
+		err = service.metadata_svc.Set(key, value, rev)
+	}
+	if err != nil {
+		return err
+	}
+	service.logger.Infof("Registered filter %v with expression %v\n", name, filterExpression)
+	return nil
+}
+
+// returns the expression of the filter registered under name. the filter is read from metadata store,
+// so that filters registered on other nodes are visible
+func (service *ReplicationSpecService) GetNamedFilter(name string) (string, error) {
+	value, rev, err := service.metadata_svc.Get(getKeyFromFilterName(name))
+	if err == service_def.MetadataNotFoundErr {
+		return "", fmt.Errorf("Filter %v does not exist", name)
+	} else if err != nil {
+		return "", err
+	}
+	namedFilter, err := constructNamedFilter(value, rev)
+	if err != nil {
+		return "", err
+	}
+	return namedFilter.Expression, nil
+}
+
+// returns the expressions of all registered filters, keyed by filter name
+func (service *ReplicationSpecService) AllNamedFilters() (map[string]string, error) {
+	entries, err := service.metadata_svc.GetAllMetadataFromCatalog(NamedFiltersCatalogKey)
+	if err != nil {
+		return nil, err
+	}
+	namedFilters := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		namedFilter, err := constructNamedFilter(entry.Value, entry.Rev)
+		if err != nil {
+			service.logger.Errorf("Skipping named filter that cannot be unmarshaled. key=%v, err=%v\n", entry.Key, err)
+			continue
+		}
+		namedFilters[namedFilter.Name] = namedFilter.Expression
+	}
+	return namedFilters, nil
+}
+
+func constructNamedFilter(value []byte, rev interface{}) (*metadata.NamedFilter, error) {
+	namedFilter := &metadata.NamedFilter{}
+	err := json.Unmarshal(value, namedFilter)
+	if err != nil {
+		return nil, err
+	}
+	namedFilter.Revision = rev
+	return namedFilter, nil
+}
+
+func getKeyFromFilterName(name string) string {
+	return NamedFiltersCatalogKey + base.KeyPartsDelimiter + name
+}
+
+// copies the expression of the named filter in settings into filter expression, so that the expression is validated
+// and persisted like one specified directly. the filter name is kept in settings, so that the spec records where its
+// expression has come from. records an error in errorMap, keyed by base.FilterName, when the named filter does not exist,
+// or when filter expression is specified as well
+func (service *ReplicationSpecService) resolveFilterName(settings map[string]interface{}, errorMap map[string]error) {
+	filterNameObj, ok := settings[metadata.FilterName]
+	if !ok {
+		return
+	}
+	filterName, ok := filterNameObj.(string)
+	if !ok {
+		errorMap[base.FilterName] = fmt.Errorf("Filter name %v is not a string", filterNameObj)
+		return
+	}
+	if len(filterName) == 0 {
+		return
+	}
+	if filterExpression, ok := settings[metadata.FilterExpression].(string); ok && len(filterExpression) > 0 {
+		errorMap[base.FilterName] = fmt.Errorf("Filter name and filter expression cannot both be specified. Remove %v to use the expression of filter %v",
+			base.FilterExpression, filterName)
+		return
+	}
+
+	filterExpression, err := service.GetNamedFilter(filterName)
+	if err != nil {
+		errorMap[base.FilterName] = err
+		return
+	}
+	settings[metadata.FilterExpression] = filterExpression
+}
+
+func clusterCompatibilityCacheKey(clusterUUID string, version []int) string {
+	return clusterUUID + base.KeyPartsDelimiter + fmt.Sprint(version)
+}
+
+// compares the clocks of source and target clusters, and adds a warning to warningMap when they differ by more than base.MaxClockSkewForLWW.
+// failure to get the clock of either cluster is not treated as an error, since the check is advisory only
+func (service *ReplicationSpecService) validateClockSkew(ctx context.Context, local_connStr, remote_connStr, remote_userName, remote_password string, certificate []byte, sanInCertificate bool, warningMap map[string]error) {
+	sourceClockOffset, err := utils.GetServerClockOffsetWithContext(ctx, local_connStr, "", "", nil, false, service.logger)
+	if err != nil {
+		service.logger.Infof("Skipped clock skew check since clock of source cluster could not be retrieved. err=%v\n", err)
+		return
+	}
+	targetClockOffset, err := utils.GetServerClockOffsetWithContext(ctx, remote_connStr, remote_userName, remote_password, certificate, sanInCertificate, service.logger)
+	if err != nil {
+		service.logger.Infof("Skipped clock skew check since clock of target cluster could not be retrieved. err=%v\n", err)
+		return
+	}
+
+	clockSkew := targetClockOffset - sourceClockOffset
+	if clockSkew < 0 {
+		clockSkew = -clockSkew
+	}
+	service.logger.Infof("Clock skew between source and target clusters is %v\n", clockSkew)
+	if clockSkew > base.MaxClockSkewForLWW {
+		warningMap[base.ToCluster] = fmt.Errorf("Clocks of source and target clusters differ by %v, which exceeds %v. Conflicts may not be resolved correctly by Last Write Wins.", clockSkew, base.MaxClockSkewForLWW)
+	}
+}
+
+// returns false, and records an error in errorMap keyed by base.ToBucket, when source and target buckets have different
+// conflict resolution types. when allowMismatch is true, the mismatch is logged and recorded in warningMap instead
+func (service *ReplicationSpecService) validateConflictResolutionType(sourceBucket, targetBucket, sourceConflictResolutionType, targetConflictResolutionType string,
+	allowMismatch bool, errorMap, warningMap map[string]error) bool {
+	if sourceConflictResolutionType == targetConflictResolutionType {
+		return true
+	}
+	if allowMismatch {
+		warningMap[base.ToBucket] = fmt.Errorf("Source bucket %v and target bucket %v have different ConflictResolutionType setting, source=%v, target=%v. Conflicts may not be resolved consistently on source and target.",
+			sourceBucket, targetBucket, sourceConflictResolutionType, targetConflictResolutionType)
+		service.logger.Infof("Allowing replication since %v is set. %v\n", metadata.AllowConflictResolutionTypeMismatchKey, warningMap[base.ToBucket])
+		return true
+	}
+	errorMap[base.ToBucket] = fmt.Errorf("Replication between buckets with different ConflictResolutionType setting is not allowed, source=%v, target=%v",
+		sourceConflictResolutionType, targetConflictResolutionType)
+	return false
+}
+
+// a nil settings map is treated as an empty one, i.e., default values apply to all settings
+func normalizeSettingsMap(settings map[string]interface{}) map[string]interface{} {
+	if settings == nil {
+		return make(map[string]interface{})
+	}
+	return settings
+}
+
+// records an error in errorMap, keyed by base.FilterExpression, when the filter expression in settings
+// is not a valid regular expression. the error message of regexp compilation is returned as is
+func validateFilterExpression(settings map[string]interface{}, errorMap map[string]error) {
+	filterExpressionObj, ok := settings[metadata.FilterExpression]
+	if !ok {
+		return
+	}
+	filterExpression, ok := filterExpressionObj.(string)
+	if !ok {
+		errorMap[base.FilterExpression] = fmt.Errorf("Filter expression %v is not a string", filterExpressionObj)
+		return
+	}
+	if len(filterExpression) == 0 {
+		return
+	}
+	if _, err := regexp.Compile(filterExpression); err != nil {
+		errorMap[base.FilterExpression] = err
+	}
+}
+
+// records an error in errorMap, keyed by base.FilterKeyPrefix, when filter key prefix in settings is not a string,
+// or when it is set together with filter expression, since a document would have to pass both filters
+func validateFilterKeyPrefix(settings map[string]interface{}, errorMap map[string]error) {
+	filterKeyPrefixObj, ok := settings[metadata.FilterKeyPrefix]
+	if !ok {
+		return
+	}
+	filterKeyPrefix, ok := filterKeyPrefixObj.(string)
+	if !ok {
+		errorMap[base.FilterKeyPrefix] = fmt.Errorf("Filter key prefix %v is not a string", filterKeyPrefixObj)
+		return
+	}
+	if len(filterKeyPrefix) == 0 {
+		return
+	}
+	if filterExpression, ok := settings[metadata.FilterExpression].(string); ok && len(filterExpression) > 0 {
+		errorMap[base.FilterKeyPrefix] = fmt.Errorf("Filter key prefix and filter expression cannot both be specified. Remove %v to filter documents by key prefix, or remove %v to filter documents by regular expression",
+			base.FilterExpression, base.FilterKeyPrefix)
+	}
+}
+
+// replication type defaults to xmem when it is not specified
+func replicationTypeFromSettingsMap(settings map[string]interface{}) interface{} {
+	repl_type, ok := settings[metadata.ReplicationType]
+	if !ok {
+		return metadata.ReplicationTypeXmem
+	}
+	return repl_type
+}
 
-	return sourceBucketUUID, targetBucketUUID, targetClusterRef, errorMap
+// returns true, and records the cancellation in errorMap, if validation has been cancelled
+func (service *ReplicationSpecService) isValidationCancelled(ctx context.Context, errorMap map[string]error) bool {
+	if ctx.Err() == nil {
+		return false
+	}
+	service.logger.Infof("Validation of replication has been cancelled\n")
+	errorMap[base.PlaceHolderFieldKey] = service_def.ValidationCancelledError
+	return true
 }
 
 func (service *ReplicationSpecService) validateBucket(sourceBucket, targetCluster, targetBucket, bucketType string, err error, errorMap map[string]error, isSourceBucket bool) {
@@ -304,6 +931,10 @@ func (service *ReplicationSpecService) validateBucket(sourceBucket, targetCluste
 func (service *ReplicationSpecService) AddReplicationSpec(spec *metadata.ReplicationSpecification) error {
 	service.logger.Infof("Start AddReplicationSpec, spec=%v\n", spec)
 
+	if spec.Settings == nil {
+		spec.Settings = metadata.DefaultSettings()
+	}
+
 	value, err := json.Marshal(spec)
 	if err != nil {
 		return err
@@ -312,60 +943,431 @@ func (service *ReplicationSpecService) AddReplicationSpec(spec *metadata.Replica
 	service.logger.Info("Adding it to metadata store...")
 
 	key := getKeyFromReplicationId(spec.Id)
+	service.write_limiter.wait()
 	err = service.metadata_svc.AddWithCatalog(ReplicationSpecsCatalogKey, key, value)
-	if err != nil {
+	service.recordWriteResult(err)
+	if err == service_def.ErrorKeyAlreadyExist {
+		return &SpecAlreadyExistsError{ReplicationId: spec.Id}
+	} else if err != nil {
 		return err
 	}
 
 	err = service.updateCache(spec.Id, spec)
 	if err == nil {
-		service.writeUiLog(spec, "created", "")
+		service.writeUiLog(spec, createdAction(spec), "")
 	}
 	return err
 }
 
-func (service *ReplicationSpecService) SetReplicationSpec(spec *metadata.ReplicationSpecification) error {
-	value, err := json.Marshal(spec)
-	if err != nil {
-		return err
+// returns what AddReplicationSpec would do with the spec, i.e., the id and bucket uuids that it would be added with,
+// the ui log message that would be written, and whether it already exists, without writing to metadata store
+// or changing cache. bucket uuids missing in spec are looked up
+func (service *ReplicationSpecService) AddReplicationSpecDryRun(spec *metadata.ReplicationSpecification) (*metadata.ReplicationSpecDryRunResult, error) {
+	if spec == nil {
+		return nil, errors.New("Replication spec is nil")
 	}
-	key := getKeyFromReplicationId(spec.Id)
+	service.logger.Infof("Start AddReplicationSpecDryRun, spec=%v\n", spec)
 
-	err = service.metadata_svc.Set(key, value, spec.Revision)
-	if err != nil {
-		return err
+	replicationId := metadata.ReplicationId(spec.SourceBucketName, spec.TargetClusterUUID, spec.TargetBucketName)
+	if spec.Id != "" && spec.Id != replicationId {
+		return nil, fmt.Errorf("Id of replication spec, %v, does not match its source and target, which give id %v", spec.Id, replicationId)
 	}
 
-	_, rev, err := service.metadata_svc.Get(key)
-	if err != nil {
-		return err
+	// work on a copy so that the spec passed in is not modified, as AddReplicationSpec would do
+	specCopy := *spec
+	specCopy.Id = replicationId
+	if specCopy.Settings == nil {
+		specCopy.Settings = metadata.DefaultSettings()
+	}
+	if _, err := json.Marshal(&specCopy); err != nil {
+		return nil, err
 	}
-	spec.Revision = rev
 
-	err = service.updateCache(spec.Id, spec)
-	if err == nil {
-		service.logger.Infof("Replication spec %s has been updated, rev=%v\n", spec.Id, rev)
-		return nil
-	} else {
-		return err
+	var err error
+	if specCopy.SourceBucketUUID == "" {
+		specCopy.SourceBucketUUID, err = service.sourceBucketUUID(specCopy.SourceBucketName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if specCopy.TargetBucketUUID == "" {
+		specCopy.TargetBucketUUID, err = service.targetBucketUUID(specCopy.TargetClusterUUID, specCopy.TargetBucketName)
+		if err != nil {
+			return nil, err
+		}
 	}
-}
 
-func (service *ReplicationSpecService) DelReplicationSpec(replicationId string) (*metadata.ReplicationSpecification, error) {
-	return service.delReplicationSpec_internal(replicationId, "")
+	_, err = service.replicationSpec(replicationId)
+	return &metadata.ReplicationSpecDryRunResult{
+		ReplicationId:    replicationId,
+		SourceBucketUUID: specCopy.SourceBucketUUID,
+		TargetBucketUUID: specCopy.TargetBucketUUID,
+		UiLogMessage:     service.uiLogMessage(&specCopy, createdAction(&specCopy), ""),
+		AlreadyExists:    err == nil,
+	}, nil
 }
 
-func (service *ReplicationSpecService) delReplicationSpec_internal(replicationId, reason string) (*metadata.ReplicationSpecification, error) {
-	spec, err := service.replicationSpec(replicationId)
+// adds the replication spec and returns only after the write is visible in the local cache and,
+// when confirmWithStore is true, a Get from the metadata store on this node succeeds,
+// so that subsequent reads on this node are guaranteed to see the spec.
+// note that metakv propagates the write to other nodes asynchronously. reads on other nodes
+// may still not see the spec when this method returns.
+func (service *ReplicationSpecService) AddReplicationSpecAndWait(spec *metadata.ReplicationSpecification, timeout time.Duration, confirmWithStore bool) error {
+	err := service.AddReplicationSpec(spec)
 	if err != nil {
-		return nil, errors.New(ReplicationSpecNotFoundErrorMessage)
+		return err
 	}
 
-	key := getKeyFromReplicationId(replicationId)
-	err = service.metadata_svc.DelWithCatalog(ReplicationSpecsCatalogKey, key, spec.Revision)
-	if err != nil {
-		service.logger.Errorf("Failed to delete replication spec, key=%v, rev=%v\n", key, spec.Revision)
-		return nil, err
+	key := getKeyFromReplicationId(spec.Id)
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err = service.replicationSpec(spec.Id)
+		if err == nil && confirmWithStore {
+			_, _, err = service.metadata_svc.Get(key)
+		}
+		if err == nil {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			service.logger.Errorf("Replication spec %v was not visible after %v. err=%v\n", spec.Id, timeout, err)
+			return ReplicationSpecWriteNotVisibleError
+		}
+		time.Sleep(ReplicationSpecVisibilityCheckInterval)
+	}
+}
+
+// adds replication specs in bulk, and returns the errors of the specs that have not been added, keyed by spec id.
+// the batch is added as a whole or not at all. all specs are fully validated before any of them is written, and
+// nothing is written when any of them is invalid. metakv does not support batched writes, so the specs are written
+// one at a time. when a write fails, the specs that have been written in the batch are deleted from metadata store,
+// and the rest of the batch is not written. specs are put into cache only after the whole batch has been persisted.
+// the returned error is not nil when the batch has not been added
+func (service *ReplicationSpecService) BulkAddReplicationSpecs(specs []*metadata.ReplicationSpecification) (map[string]error, error) {
+	return service.bulkAddReplicationSpecs(specs, memoizeSpecValidationLookups(service.newSpecValidationLookups()))
+}
+
+func (service *ReplicationSpecService) bulkAddReplicationSpecs(specs []*metadata.ReplicationSpecification, lookups *specValidationLookups) (map[string]error, error) {
+	service.logger.Infof("Start BulkAddReplicationSpecs, number of specs=%v\n", len(specs))
+
+	errorMap := make(map[string]error)
+	values := make(map[string][]byte)
+	for _, spec := range specs {
+		if spec == nil {
+			return nil, InvalidReplicationSpecError
+		}
+		if _, ok := values[spec.Id]; ok {
+			errorMap[spec.Id] = DuplicateReplicationSpecInBatchError
+			continue
+		}
+		// a placeholder keeps later occurrences of the spec from being validated again
+		values[spec.Id] = nil
+		if err := service.validateNewSpecInBatch(spec, lookups); err != nil {
+			errorMap[spec.Id] = err
+			continue
+		}
+		value, err := json.Marshal(spec)
+		if err != nil {
+			errorMap[spec.Id] = err
+			continue
+		}
+		values[spec.Id] = value
+	}
+
+	if len(errorMap) > 0 {
+		service.abortBulkAdd(specs, errorMap)
+		service.logger.Errorf("BulkAddReplicationSpecs did not add any of the %v specs since some of them are invalid. errors=%v\n", len(specs), errorMap)
+		return errorMap, fmt.Errorf("%v out of %v replication specs are invalid. none of the replication specs has been added", len(errorMap), len(specs))
+	}
+
+	writtenSpecs := make([]*metadata.ReplicationSpecification, 0, len(specs))
+	for _, spec := range specs {
+		key := getKeyFromReplicationId(spec.Id)
+		service.write_limiter.wait()
+		err := service.metadata_svc.AddWithCatalog(ReplicationSpecsCatalogKey, key, values[spec.Id])
+		service.recordWriteResult(err)
+		if err != nil {
+			service.logger.Errorf("Failed to add replication spec %v. Rolling back %v specs that have been added in the batch. err=%v\n", spec.Id, len(writtenSpecs), err)
+			errorMap[spec.Id] = err
+			// specs that cannot be rolled back stay in metadata store, and are picked up by the metakv callbacks
+			service.rollbackBulkAdd(writtenSpecs)
+			service.abortBulkAdd(specs, errorMap)
+			return errorMap, fmt.Errorf("Failed to add replication spec %v. none of the replication specs has been added. err=%v", spec.Id, err)
+		}
+		writtenSpecs = append(writtenSpecs, spec)
+	}
+
+	for _, spec := range writtenSpecs {
+		// the revision will be filled in when the metakv callback on the spec comes in, if it cannot be read back here
+		_, rev, err := service.metadata_svc.Get(getKeyFromReplicationId(spec.Id))
+		if err == nil {
+			spec.Revision = rev
+		} else {
+			service.logger.Errorf("Failed to read revision of replication spec %v. err=%v\n", spec.Id, err)
+		}
+
+		err = service.updateCache(spec.Id, spec)
+		if err != nil {
+			service.logger.Errorf("Failed to cache replication spec %v. err=%v\n", spec.Id, err)
+		}
+	}
+
+	// specs created paused are logged separately, so that they are not mistaken for running replications
+	activeSpecs := make([]*metadata.ReplicationSpecification, 0, len(writtenSpecs))
+	pausedSpecs := make([]*metadata.ReplicationSpecification, 0)
+	for _, spec := range writtenSpecs {
+		if spec.Settings.Active {
+			activeSpecs = append(activeSpecs, spec)
+		} else {
+			pausedSpecs = append(pausedSpecs, spec)
+		}
+	}
+	service.writeBulkUiLog(activeSpecs, "created")
+	service.writeBulkUiLog(pausedSpecs, createdPausedAction)
+
+	service.logger.Infof("BulkAddReplicationSpecs added %v specs\n", len(writtenSpecs))
+	return errorMap, nil
+}
+
+// validates a spec in a batch to be added in the same way as a spec to be added on its own
+func (service *ReplicationSpecService) validateNewSpecInBatch(spec *metadata.ReplicationSpecification, lookups *specValidationLookups) error {
+	if service.HasReplicationSpec(spec.Id) {
+		return &SpecAlreadyExistsError{ReplicationId: spec.Id}
+	}
+	if spec.Id != metadata.ReplicationId(spec.SourceBucketName, spec.TargetClusterUUID, spec.TargetBucketName) {
+		return fmt.Errorf("spec %v does not match its source bucket, target cluster and target bucket", spec.Id)
+	}
+	if spec.Settings == nil {
+		spec.Settings = metadata.DefaultSettings()
+	}
+	// dry run, so that specs are not rebound before they are added
+	err, detailErr := service.validateExistingReplicationSpec(spec, lookups, true /*dryRun*/)
+	if err == InvalidReplicationSpecError && detailErr != nil {
+		return detailErr
+	}
+	return err
+}
+
+// deletes the specs that have been written in a batch that failed to be added
+func (service *ReplicationSpecService) rollbackBulkAdd(writtenSpecs []*metadata.ReplicationSpecification) {
+	for _, spec := range writtenSpecs {
+		key := getKeyFromReplicationId(spec.Id)
+		_, rev, err := service.metadata_svc.Get(key)
+		if err == nil {
+			err = service.metadata_svc.DelWithCatalog(ReplicationSpecsCatalogKey, key, rev)
+		}
+		if err != nil {
+			service.logger.Errorf("Failed to roll back replication spec %v. err=%v\n", spec.Id, err)
+		}
+	}
+}
+
+// records that the specs in a batch that failed to be added have not been added, unless they have errors of their own
+func (service *ReplicationSpecService) abortBulkAdd(specs []*metadata.ReplicationSpecification, errorMap map[string]error) {
+	for _, spec := range specs {
+		if _, ok := errorMap[spec.Id]; !ok {
+			errorMap[spec.Id] = BulkAddAbortedError
+		}
+	}
+}
+
+// keeps track of whether metadata store is out of space. operators are notified through ui log when it runs out of space
+func (service *ReplicationSpecService) recordWriteResult(err error) {
+	if err == service_def.MetadataStoreFullError {
+		if atomic.SwapInt32(&service.store_full, 1) == 0 {
+			service.logger.Errorf("Metadata store is out of space. Changes to replications will fail until space is freed up\n")
+			if service.uilog_svc != nil {
+				service.uilog_svc.Write("XDCR metadata store is out of space. Replications cannot be created, changed or deleted until space is freed up.")
+			}
+		}
+	} else if err == nil {
+		if atomic.SwapInt32(&service.store_full, 0) == 1 {
+			service.logger.Infof("Metadata store is no longer out of space\n")
+		}
+	}
+}
+
+// soft deleted specs that are counted in NumOfDeletedEntries for long are a sign that their derived objects have not been cleared
+func (service *ReplicationSpecService) GetCacheStats() *service_def.MetadataCacheStats {
+	return service.getCache().CacheStats()
+}
+
+// whether the last write to metadata store failed because metadata store is out of space
+func (service *ReplicationSpecService) IsMetadataStoreFull() bool {
+	return atomic.LoadInt32(&service.store_full) == 1
+}
+
+func (service *ReplicationSpecService) SetReplicationSpec(spec *metadata.ReplicationSpecification) error {
+	return service.setReplicationSpec(spec, true /*writeUiLog*/)
+}
+
+// when writeUiLog is false, the settings change is recorded in settings history but not written into ui log,
+// e.g., when the caller writes a single ui log message for a batch of specs
+func (service *ReplicationSpecService) setReplicationSpec(spec *metadata.ReplicationSpecification, writeUiLog bool) error {
+	// keep the current spec around for the settings change summary
+	oldSpec, _ := service.replicationSpec(spec.Id)
+
+	metadata.UpgradeSpecSettings(spec)
+	value, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	key := getKeyFromReplicationId(spec.Id)
+
+	service.write_limiter.wait()
+	err = service.metadata_svc.Set(key, value, spec.Revision)
+	service.recordWriteResult(err)
+	if err != nil {
+		return err
+	}
+
+	_, rev, err := service.metadata_svc.Get(key)
+	if err != nil {
+		return err
+	}
+	spec.Revision = rev
+
+	err = service.updateCache(spec.Id, spec)
+	if err == nil {
+		service.logger.Infof("Replication spec %s has been updated, rev=%v\n", spec.Id, rev)
+		if oldSpec != nil {
+			diff := metadata.DiffReplicationSpecs(oldSpec, spec).ChangedSettings
+			if writeUiLog {
+				service.writeSettingsChangeUiLog(spec, diff)
+			}
+			service.recordSettingsChange(spec.Id, diff)
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+// read-modify-write of the settings of a replication spec. mutate is applied to the settings of the current spec,
+// keyed and typed as in ReplicationSettings.ToMap, and the spec is persisted with the revision that it was read with,
+// so that concurrent updates are not overwritten. when the spec has been updated in the meantime, the spec is re-read
+// from metadata store, and the update is retried once. returns the settings of the persisted spec
+func (service *ReplicationSpecService) UpdateReplicationSpecSettings(replicationId string, mutate func(settings map[string]interface{}) error) (map[string]interface{}, error) {
+	return service.updateReplicationSpecSettings(replicationId, mutate, true /*writeUiLog*/)
+}
+
+func (service *ReplicationSpecService) updateReplicationSpecSettings(replicationId string, mutate func(settings map[string]interface{}) error, writeUiLog bool) (map[string]interface{}, error) {
+	cachedSpec, err := service.replicationSpecWithRecovery(replicationId)
+	if err != nil {
+		return nil, err
+	}
+	spec := cachedSpec.Clone()
+	spec.Revision = cachedSpec.Revision
+
+	settings, err := service.updateSpecSettings(spec, mutate, writeUiLog)
+	if err != service_def.ErrorRevisionMismatch {
+		return settings, err
+	}
+
+	service.logger.Infof("Replication spec %v was updated concurrently. Retrying settings update with the latest spec\n", replicationId)
+	value, rev, err := service.GetSpecRaw(replicationId)
+	if err != nil {
+		return nil, err
+	}
+	spec, err = constructReplicationSpec(value, rev)
+	if err != nil {
+		return nil, err
+	}
+	if spec == nil {
+		return nil, &SpecNotFoundError{ReplicationId: replicationId}
+	}
+	return service.updateSpecSettings(spec, mutate, writeUiLog)
+}
+
+func (service *ReplicationSpecService) updateSpecSettings(spec *metadata.ReplicationSpecification, mutate func(settings map[string]interface{}) error, writeUiLog bool) (map[string]interface{}, error) {
+	settings := spec.Settings.ToMap()
+	err := mutate(settings)
+	if err != nil {
+		return nil, err
+	}
+	_, errorMap := spec.Settings.UpdateSettingsFromMap(settings)
+	if len(errorMap) > 0 {
+		return nil, fmt.Errorf("Invalid settings for replication %v. errors=[%v]", spec.Id, settingsErrorsString(errorMap))
+	}
+	err = service.setReplicationSpec(spec, writeUiLog)
+	if err != nil {
+		return nil, err
+	}
+	return spec.Settings.ToMap(), nil
+}
+
+// pauses all replications from the source bucket, e.g., when the bucket is under maintenance.
+// returns the result of each replication, keyed by replication id. replications that are already paused succeed
+func (service *ReplicationSpecService) PauseAllReplicationsForBucket(sourceBucket string) (map[string]error, error) {
+	return service.setActiveForBucket(sourceBucket, false)
+}
+
+// resumes all replications from the source bucket. returns the result of each replication, keyed by replication id.
+// replications that are already active succeed
+func (service *ReplicationSpecService) ResumeAllReplicationsForBucket(sourceBucket string) (map[string]error, error) {
+	return service.setActiveForBucket(sourceBucket, true)
+}
+
+// returned by the settings mutation of setActiveForBucket to skip the write of a spec that is already in the requested state
+var errActiveUnchanged = errors.New("Active setting is unchanged")
+
+// sets the Active setting of all replications from the source bucket through UpdateReplicationSpecSettings, and writes
+// a single ui log message for the replications that have been changed
+func (service *ReplicationSpecService) setActiveForBucket(sourceBucket string, active bool) (map[string]error, error) {
+	replicationIds, err := service.AllReplicationSpecIdsForBucket(sourceBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]error)
+	changedSpecs := make([]*metadata.ReplicationSpecification, 0, len(replicationIds))
+	for _, replicationId := range replicationIds {
+		_, err := service.updateReplicationSpecSettings(replicationId, func(settings map[string]interface{}) error {
+			// evaluated again when the update is retried, since the spec may have been paused or resumed in the meantime
+			if settings[metadata.Active] == active {
+				return errActiveUnchanged
+			}
+			settings[metadata.Active] = active
+			return nil
+		}, false /*writeUiLog*/)
+		if err == errActiveUnchanged {
+			results[replicationId] = nil
+			continue
+		}
+		results[replicationId] = err
+		if err != nil {
+			service.logger.Errorf("Failed to set active=%v for replication %v. err=%v\n", active, replicationId, err)
+		} else if spec, err := service.replicationSpec(replicationId); err == nil {
+			changedSpecs = append(changedSpecs, spec)
+		}
+	}
+
+	action := "paused"
+	if active {
+		action = "resumed"
+	}
+	service.logger.Infof("Set active=%v for %v replications from bucket %v. changed=%v, results=%v\n", active, len(replicationIds), sourceBucket, len(changedSpecs), results)
+	service.writeBulkUiLog(changedSpecs, action)
+	return results, nil
+}
+
+func (service *ReplicationSpecService) DelReplicationSpec(replicationId string) (*metadata.ReplicationSpecification, error) {
+	return service.delReplicationSpec_internal(replicationId, "")
+}
+
+func (service *ReplicationSpecService) delReplicationSpec_internal(replicationId, reason string) (*metadata.ReplicationSpecification, error) {
+	spec, err := service.replicationSpec(replicationId)
+	if err != nil {
+		return nil, &SpecNotFoundError{ReplicationId: replicationId}
+	}
+
+	key := getKeyFromReplicationId(replicationId)
+	service.write_limiter.wait()
+	err = service.metadata_svc.DelWithCatalog(ReplicationSpecsCatalogKey, key, spec.Revision)
+	service.recordWriteResult(err)
+	if err != nil {
+		service.logger.Errorf("Failed to delete replication spec, key=%v, rev=%v\n", key, spec.Revision)
+		return nil, err
 	}
 
 	err = service.updateCache(replicationId, nil)
@@ -378,15 +1380,74 @@ func (service *ReplicationSpecService) delReplicationSpec_internal(replicationId
 
 }
 
+// the map returned is a snapshot shared by all callers and must not be modified
 func (service *ReplicationSpecService) AllReplicationSpecs() (map[string]*metadata.ReplicationSpecification, error) {
-	specs := make(map[string]*metadata.ReplicationSpecification, 0)
+	specs, ok := service.specs_snapshot.Load().(map[string]*metadata.ReplicationSpecification)
+	if !ok {
+		// snapshot has not been built yet. compute it from cache without publishing it
+		return service.buildSpecsSnapshot(), nil
+	}
+	return specs, nil
+}
+
+// returns the specs of replications that have not been paused, i.e., whose Active setting is true
+func (service *ReplicationSpecService) AllActiveReplicationSpecs() (map[string]*metadata.ReplicationSpecification, error) {
+	return service.replicationSpecsByActiveState(true)
+}
+
+// returns the specs of replications that have been paused, i.e., whose Active setting is false
+func (service *ReplicationSpecService) AllPausedReplicationSpecs() (map[string]*metadata.ReplicationSpecification, error) {
+	return service.replicationSpecsByActiveState(false)
+}
+
+func (service *ReplicationSpecService) replicationSpecsByActiveState(active bool) (map[string]*metadata.ReplicationSpecification, error) {
+	all_specs, err := service.AllReplicationSpecs()
+	if err != nil {
+		return nil, err
+	}
+	specs := make(map[string]*metadata.ReplicationSpecification)
+	for specId, spec := range all_specs {
+		if spec.Settings.Active == active {
+			specs[specId] = spec
+		}
+	}
+	return specs, nil
+}
+
+// whether the replication has not been paused
+func (service *ReplicationSpecService) IsSpecActive(replicationId string) (bool, error) {
+	spec, err := service.ReplicationSpec(replicationId)
+	if err != nil {
+		return false, err
+	}
+	return spec.Settings.Active, nil
+}
+
+// returns the revision of the spec in cache, for callers that only need it to update the spec with cas,
+// without cloning the whole spec as ReplicationSpec does
+func (service *ReplicationSpecService) GetReplicationSpecRevision(replicationId string) (interface{}, error) {
+	spec, err := service.replicationSpec(replicationId)
+	if err != nil {
+		return nil, err
+	}
+	return spec.Revision, nil
+}
+
+func (service *ReplicationSpecService) buildSpecsSnapshot() map[string]*metadata.ReplicationSpecification {
 	values_map := service.getCache().GetMap()
+	specs := make(map[string]*metadata.ReplicationSpecification, len(values_map))
 	for key, val := range values_map {
 		if val.(*ReplicationSpecVal).spec != nil {
 			specs[key] = val.(*ReplicationSpecVal).spec
 		}
 	}
-	return specs, nil
+	return specs
+}
+
+// rebuild the snapshot returned by AllReplicationSpecs from the current content of the cache.
+// should be called with cache_lock held, or before the service is made available to others
+func (service *ReplicationSpecService) refreshSpecsSnapshot() {
+	service.specs_snapshot.Store(service.buildSpecsSnapshot())
 }
 
 func (service *ReplicationSpecService) AllReplicationSpecIds() ([]string, error) {
@@ -401,23 +1462,58 @@ func (service *ReplicationSpecService) AllReplicationSpecIds() ([]string, error)
 	return repIds, nil
 }
 
+// same as AllReplicationSpecIds, except that ids are in lexicographic order, so that callers get the same order
+// every time as long as specs have not changed
+func (service *ReplicationSpecService) AllReplicationSpecIdsSorted() []string {
+	// AllReplicationSpecs never returns error
+	rep_map, _ := service.AllReplicationSpecs()
+	repIds := make([]string, 0, len(rep_map))
+	for key, _ := range rep_map {
+		repIds = append(repIds, key)
+	}
+	sort.Strings(repIds)
+	return repIds
+}
+
+// ids are in lexicographic order
 func (service *ReplicationSpecService) AllReplicationSpecIdsForBucket(bucket string) ([]string, error) {
 	var repIds []string
-	allRepIds, err := service.AllReplicationSpecIds()
-	if err != nil {
-		return nil, err
+	for _, repId := range service.AllReplicationSpecIdsSorted() {
+		// there should not be any errors since AllReplicationSpecIdsSorted should return valid replication ids
+		match, _ := metadata.IsReplicationIdForSourceBucket(repId, bucket)
+		if match {
+			repIds = append(repIds, repId)
+		}
 	}
+	return repIds, nil
+}
 
-	if allRepIds != nil {
-		for _, repId := range allRepIds {
-			// there should not be any errors since allRepIds should contain valid replication ids
-			match, _ := metadata.IsReplicationIdForSourceBucket(repId, bucket)
-			if match {
-				repIds = append(repIds, repId)
-			}
+// returns the ids of all replications to the remote cluster, e.g., when the remote cluster is being decommissioned
+func (service *ReplicationSpecService) AllReplicationSpecIdsForTargetCluster(targetClusterUUID string) ([]string, error) {
+	return service.replicationSpecIdsMatching(func(spec *metadata.ReplicationSpecification) bool {
+		return spec.TargetClusterUUID == targetClusterUUID
+	}), nil
+}
+
+func (service *ReplicationSpecService) AllReplicationSpecIdsForTargetBucket(targetClusterUUID, targetBucket string) ([]string, error) {
+	return service.replicationSpecIdsMatching(func(spec *metadata.ReplicationSpecification) bool {
+		return spec.TargetClusterUUID == targetClusterUUID && spec.TargetBucketName == targetBucket
+	}), nil
+}
+
+// scans the cache for specs that match. specs that have been deleted, i.e., whose spec is nil, are skipped
+func (service *ReplicationSpecService) replicationSpecIdsMatching(match func(spec *metadata.ReplicationSpecification) bool) []string {
+	var repIds []string
+	for repId, val := range service.getCache().GetMap() {
+		specVal, ok := val.(*ReplicationSpecVal)
+		if !ok || specVal == nil || specVal.spec == nil {
+			continue
+		}
+		if match(specVal.spec) {
+			repIds = append(repIds, repId)
 		}
 	}
-	return repIds, nil
+	return repIds
 }
 
 func (service *ReplicationSpecService) removeSpecFromCache(specId string) error {
@@ -445,6 +1541,8 @@ func constructReplicationSpec(value []byte, rev interface{}) (*metadata.Replicat
 		return nil, err
 	}
 	spec.Revision = rev
+	// the upgraded form is persisted the next time the spec is updated
+	metadata.UpgradeSpecSettings(spec)
 	return spec, nil
 }
 
@@ -452,79 +1550,353 @@ func constructReplicationSpec(value []byte, rev interface{}) (*metadata.Replicat
 func (service *ReplicationSpecService) ReplicationSpecServiceCallback(path string, value []byte, rev interface{}) error {
 	service.logger.Infof("ReplicationSpecServiceCallback called on path = %v\n", path)
 
+	key := GetKeyFromPath(path)
+	specId := service.getReplicationIdFromKey(key)
+
 	newSpec, err := constructReplicationSpec(value, rev)
 	if err != nil {
-		service.logger.Errorf("Error marshaling replication spec. value=%v, err=%v\n", string(value), err)
+		// the spec in cache is now stale. mark it so that it is re-fetched when it is accessed next time
+		service.logger.Errorf("Error unmarshaling replication spec. Marking it as corrupted. key=%v, rev=%v, value=%v, err=%v\n", key, rev, string(value), err)
+		service.markSpecCorrupted(specId, true)
 		return err
 	}
 
-	specId := service.getReplicationIdFromKey(GetKeyFromPath(path))
+	err = service.updateCache(specId, newSpec)
+	if err == nil {
+		service.markSpecCorrupted(specId, false)
+	}
+	return err
+}
+
+func (service *ReplicationSpecService) markSpecCorrupted(specId string, corrupted bool) {
+	service.corrupted_specs_lock.Lock()
+	defer service.corrupted_specs_lock.Unlock()
+	if corrupted {
+		if service.corrupted_specs == nil {
+			service.corrupted_specs = make(map[string]bool)
+		}
+		service.corrupted_specs[specId] = true
+	} else {
+		delete(service.corrupted_specs, specId)
+	}
+}
+
+func (service *ReplicationSpecService) isSpecCorrupted(specId string) bool {
+	service.corrupted_specs_lock.RLock()
+	defer service.corrupted_specs_lock.RUnlock()
+	return service.corrupted_specs[specId]
+}
+
+// returns the ids of specs whose latest value from metakv could not be unmarshaled and that have not been recovered yet
+func (service *ReplicationSpecService) GetCorruptedSpecIds() []string {
+	service.corrupted_specs_lock.RLock()
+	defer service.corrupted_specs_lock.RUnlock()
+	specIds := make([]string, 0, len(service.corrupted_specs))
+	for specId, _ := range service.corrupted_specs {
+		specIds = append(specIds, specId)
+	}
+	sort.Strings(specIds)
+	return specIds
+}
+
+// same as replicationSpec, except that a corrupted spec is re-fetched from metadata store first.
+// the stale spec in cache is not returned when the spec cannot be recovered
+func (service *ReplicationSpecService) replicationSpecWithRecovery(replicationId string) (*metadata.ReplicationSpecification, error) {
+	if service.isSpecCorrupted(replicationId) {
+		err := service.recoverCorruptedSpec(replicationId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return service.replicationSpec(replicationId)
+}
+
+func (service *ReplicationSpecService) recoverCorruptedSpec(specId string) error {
+	key := getKeyFromReplicationId(specId)
+	value, rev, err := service.metadata_svc.Get(key)
+	if err == service_def.MetadataNotFoundErr {
+		// spec has been deleted since
+		value, err = nil, nil
+	}
+	if err != nil {
+		service.logger.Errorf("Failed to re-fetch corrupted replication spec. key=%v, rev=%v, err=%v\n", key, rev, err)
+		return fmt.Errorf("%v. id=%v, err=%v", ReplicationSpecCorruptedErrorMessage, specId, err)
+	}
 
-	return service.updateCache(specId, newSpec)
+	spec, err := constructReplicationSpec(value, rev)
+	if err != nil {
+		service.logger.Errorf("Replication spec re-fetched is still corrupted. key=%v, rev=%v, value=%v, err=%v\n", key, rev, string(value), err)
+		return fmt.Errorf("%v. id=%v, err=%v", ReplicationSpecCorruptedErrorMessage, specId, err)
+	}
 
+	err = service.updateCache(specId, spec)
+	if err != nil {
+		service.logger.Errorf("Failed to cache recovered replication spec. key=%v, rev=%v, err=%v\n", key, rev, err)
+		return err
+	}
+	service.markSpecCorrupted(specId, false)
+	service.logger.Infof("Recovered corrupted replication spec. key=%v, rev=%v\n", key, rev)
+	return nil
 }
 
 func (service *ReplicationSpecService) updateCache(specId string, newSpec *metadata.ReplicationSpecification) error {
+	_, err := service.updateCacheIf(specId, newSpec, nil)
+	return err
+}
+
+// updates the cache as updateCache does, only when precondition, if not nil, holds. precondition is checked under
+// cache_lock, so that the cache cannot be updated by others between the check and the update. it must not do i/o,
+// which would block all updates to the cache. returns whether precondition holds
+func (service *ReplicationSpecService) updateCacheIf(specId string, newSpec *metadata.ReplicationSpecification, precondition func() bool) (bool, error) {
+	// deferred first so that subscribers are notified after cache_lock is released
+	defer service.dispatchSpecChanges()
+
 	//this ensures that all accesses to the cache in this method are a single atomic operation,
 	// this is needed because this method can be called concurrently
 	service.cache_lock.Lock()
 	defer service.cache_lock.Unlock()
 
-	oldSpec, err := service.replicationSpec(specId)
-	if err != nil {
-		oldSpec = nil
+	if precondition != nil && !precondition() {
+		return false, nil
+	}
+	if service.cache_update_counts == nil {
+		service.cache_update_counts = make(map[string]uint64)
+	}
+	service.cache_update_counts[specId]++
+
+	oldSpec, err := service.replicationSpec(specId)
+	if err != nil {
+		oldSpec = nil
+	}
+
+	updated := false
+	if newSpec == nil {
+		if oldSpec != nil {
+			// replication spec has been deleted
+			service.removeSpecFromCache(specId)
+			service.pruneSettingsHistory(specId)
+			updated = true
+		}
+	} else {
+		// replication spec has been created or updated
+
+		// no need to update cache if newSpec is the same as the one already in cache
+		if !newSpec.SameSpec(oldSpec) {
+			err = service.cacheSpec(service.getCache(), specId, newSpec)
+			if err == nil {
+				specId = newSpec.Id
+				updated = true
+			} else {
+				return true, err
+			}
+		}
+
+	}
+
+	if updated {
+		service.refreshSpecsSnapshot()
+		service.enqueueSpecChange(specId, oldSpec, newSpec)
+	}
+
+	if updated && service.metadata_change_callback != nil {
+		err := service.metadata_change_callback(specId, oldSpec, newSpec)
+		if err != nil {
+			service.logger.Error(err.Error())
+		}
+	}
+
+	return true, nil
+}
+
+// registers a callback that is called whenever a spec is created, updated or deleted in cache,
+// including changes made on other nodes and delivered through ReplicationSpecServiceCallback.
+// callbacks are called outside of cache_lock, and the changes to the same spec are delivered in the order
+// in which they have been made. callbacks are called one at a time and should not block
+func (service *ReplicationSpecService) Subscribe(callback service_def.ReplicationSpecChangeCallback) func() {
+	service.subscribers_lock.Lock()
+	defer service.subscribers_lock.Unlock()
+
+	if service.subscribers == nil {
+		service.subscribers = make(map[uint64]service_def.ReplicationSpecChangeCallback)
+	}
+	id := service.next_subscriber_id
+	service.next_subscriber_id++
+	service.subscribers[id] = callback
+
+	return func() {
+		service.subscribers_lock.Lock()
+		defer service.subscribers_lock.Unlock()
+		delete(service.subscribers, id)
+	}
+}
+
+// should be called with cache_lock held, so that changes are queued in the order in which they are made to cache
+func (service *ReplicationSpecService) enqueueSpecChange(specId string, oldSpec, newSpec *metadata.ReplicationSpecification) {
+	service.subscribers_lock.Lock()
+	defer service.subscribers_lock.Unlock()
+
+	if len(service.subscribers) == 0 {
+		return
+	}
+	service.pending_spec_changes = append(service.pending_spec_changes, &specChange{specId: specId, oldSpec: oldSpec, newSpec: newSpec})
+}
+
+// delivers pending spec changes to subscribers. only one goroutine delivers at a time, which keeps the changes in order.
+// a goroutine that finds another goroutine delivering returns right away, leaving its changes to the latter.
+// this also keeps callbacks that change specs themselves from deadlocking
+func (service *ReplicationSpecService) dispatchSpecChanges() {
+	service.subscribers_lock.Lock()
+	if service.dispatching_spec_changes {
+		service.subscribers_lock.Unlock()
+		return
+	}
+	service.dispatching_spec_changes = true
+
+	for len(service.pending_spec_changes) > 0 {
+		changes := service.pending_spec_changes
+		service.pending_spec_changes = nil
+		callbacks := make([]service_def.ReplicationSpecChangeCallback, 0, len(service.subscribers))
+		for _, callback := range service.subscribers {
+			callbacks = append(callbacks, callback)
+		}
+		service.subscribers_lock.Unlock()
+
+		for _, change := range changes {
+			for _, callback := range callbacks {
+				service.callSpecChangeCallback(callback, change)
+			}
+		}
+
+		service.subscribers_lock.Lock()
+	}
+
+	service.dispatching_spec_changes = false
+	service.subscribers_lock.Unlock()
+}
+
+// a panicking callback should not stop the delivery of changes to others
+func (service *ReplicationSpecService) callSpecChangeCallback(callback service_def.ReplicationSpecChangeCallback, change *specChange) {
+	defer func() {
+		if r := recover(); r != nil {
+			service.logger.Errorf("Spec change callback for %v panicked. err=%v\n", change.specId, r)
+		}
+	}()
+	callback(change.specId, change.oldSpec, change.newSpec)
+}
+
+func (service *ReplicationSpecService) writeUiLog(spec *metadata.ReplicationSpecification, action, reason string) {
+	if service.uilog_svc != nil {
+		service.uilog_svc.Write(service.uiLogMessage(spec, action, reason))
+	}
+}
+
+const createdPausedAction = "created (paused)"
+
+// the ui log action of the creation of the spec. a spec can be created with Active set to false, in which case
+// it is persisted without its pipeline being started, until it is resumed
+func createdAction(spec *metadata.ReplicationSpecification) string {
+	if spec != nil && spec.Settings != nil && !spec.Settings.Active {
+		return createdPausedAction
+	}
+	return "created"
+}
+
+func (service *ReplicationSpecService) uiLogMessage(spec *metadata.ReplicationSpecification, action, reason string) string {
+	remoteClusterName := service.remote_cluster_svc.GetRemoteClusterNameFromClusterUuid(spec.TargetClusterUUID)
+	if reason != "" {
+		return fmt.Sprintf("Replication from bucket \"%s\" to bucket \"%s\" on cluster \"%s\" %s, since %s", spec.SourceBucketName, spec.TargetBucketName, remoteClusterName, action, reason)
+	}
+	return fmt.Sprintf("Replication from bucket \"%s\" to bucket \"%s\" on cluster \"%s\" %s.", spec.SourceBucketName, spec.TargetBucketName, remoteClusterName, action)
+}
+
+// writes a single ui log message for replication specs that have gone through the same action in bulk
+func (service *ReplicationSpecService) writeBulkUiLog(specs []*metadata.ReplicationSpecification, action string) {
+	if service.uilog_svc == nil || len(specs) == 0 {
+		return
+	}
+	replications := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		remoteClusterName := service.remote_cluster_svc.GetRemoteClusterNameFromClusterUuid(spec.TargetClusterUUID)
+		replications = append(replications, fmt.Sprintf("from bucket \"%s\" to bucket \"%s\" on cluster \"%s\"", spec.SourceBucketName, spec.TargetBucketName, remoteClusterName))
+	}
+	service.uilog_svc.Write(fmt.Sprintf("%v replications %s: %s.", len(specs), action, strings.Join(replications, ", ")))
+}
+
+// writes a summary of changed settings, e.g., "checkpoint_interval: 1800 -> 600", into ui log
+func (service *ReplicationSpecService) writeSettingsChangeUiLog(spec *metadata.ReplicationSpecification, diff map[string][2]interface{}) {
+	if service.uilog_svc == nil || len(diff) == 0 {
+		return
+	}
+
+	changes := make([]string, 0, len(diff))
+	for _, key := range utils.SortedSettingsDiffKeys(diff) {
+		changes = append(changes, fmt.Sprintf("%v: %v -> %v", key, diff[key][0], diff[key][1]))
 	}
+	remoteClusterName := service.remote_cluster_svc.GetRemoteClusterNameFromClusterUuid(spec.TargetClusterUUID)
+	uiLogMsg := fmt.Sprintf("Settings of replication from bucket \"%s\" to bucket \"%s\" on cluster \"%s\" have been changed: %s.", spec.SourceBucketName, spec.TargetBucketName, remoteClusterName, strings.Join(changes, ", "))
+	service.uilog_svc.Write(uiLogMsg)
+}
 
-	updated := false
-	if newSpec == nil {
-		if oldSpec != nil {
-			// replication spec has been deleted
-			service.removeSpecFromCache(specId)
-			updated = true
-		}
-	} else {
-		// replication spec has been created or updated
+// appends a settings change to the history of the replication, dropping the oldest changes beyond base.SettingsHistoryDepth
+func (service *ReplicationSpecService) recordSettingsChange(replicationId string, diff map[string][2]interface{}) {
+	if len(diff) == 0 {
+		return
+	}
 
-		// no need to update cache if newSpec is the same as the one already in cache
-		if !newSpec.SameSpec(oldSpec) {
-			err = service.cacheSpec(service.getCache(), specId, newSpec)
-			if err == nil {
-				specId = newSpec.Id
-				updated = true
-			} else {
-				return err
-			}
-		}
+	service.settings_history_lock.Lock()
+	defer service.settings_history_lock.Unlock()
 
+	if base.SettingsHistoryDepth <= 0 {
+		delete(service.settings_history, replicationId)
+		return
 	}
 
-	if updated && service.metadata_change_callback != nil {
-		err := service.metadata_change_callback(specId, oldSpec, newSpec)
-		if err != nil {
-			service.logger.Error(err.Error())
-		}
+	history := append(service.settings_history[replicationId], metadata.SettingsChange{Time: time.Now(), Changes: diff})
+	if len(history) > base.SettingsHistoryDepth {
+		// copy so that the dropped changes can be garbage collected
+		history = append([]metadata.SettingsChange(nil), history[len(history)-base.SettingsHistoryDepth:]...)
 	}
+	service.settings_history[replicationId] = history
+}
 
-	return nil
+func (service *ReplicationSpecService) pruneSettingsHistory(replicationId string) {
+	service.settings_history_lock.Lock()
+	defer service.settings_history_lock.Unlock()
+	delete(service.settings_history, replicationId)
 }
 
-func (service *ReplicationSpecService) writeUiLog(spec *metadata.ReplicationSpecification, action, reason string) {
-	if service.uilog_svc != nil {
-		var uiLogMsg string
-		remoteClusterName := service.remote_cluster_svc.GetRemoteClusterNameFromClusterUuid(spec.TargetClusterUUID)
-		if reason != "" {
-			uiLogMsg = fmt.Sprintf("Replication from bucket \"%s\" to bucket \"%s\" on cluster \"%s\" %s, since %s", spec.SourceBucketName, spec.TargetBucketName, remoteClusterName, action, reason)
-		} else {
-			uiLogMsg = fmt.Sprintf("Replication from bucket \"%s\" to bucket \"%s\" on cluster \"%s\" %s.", spec.SourceBucketName, spec.TargetBucketName, remoteClusterName, action)
-		}
-		service.uilog_svc.Write(uiLogMsg)
+// returns the settings changes of the replication made through this node, oldest first.
+// changes made on other nodes are not included
+func (service *ReplicationSpecService) GetSettingsHistory(replicationId string) ([]metadata.SettingsChange, error) {
+	_, err := service.replicationSpec(replicationId)
+	if err != nil {
+		return nil, err
+	}
+
+	service.settings_history_lock.RLock()
+	defer service.settings_history_lock.RUnlock()
+
+	history := service.settings_history[replicationId]
+	result := make([]metadata.SettingsChange, len(history))
+	copy(result, history)
+	return result, nil
+}
+
+// returns the replication spec exactly as it is stored in metadata service, and its revision, without deserializing it.
+// this is for debugging cases where the stored spec differs from the spec in cache
+func (service *ReplicationSpecService) GetSpecRaw(replicationId string) ([]byte, interface{}, error) {
+	value, rev, err := service.metadata_svc.Get(getKeyFromReplicationId(replicationId))
+	if err == service_def.MetadataNotFoundErr {
+		return nil, nil, &SpecNotFoundError{ReplicationId: replicationId}
 	}
+	return value, rev, err
 }
 
 func (service *ReplicationSpecService) IsReplicationValidationError(err error) bool {
-	if err != nil {
-		return strings.HasPrefix(err.Error(), ReplicationSpecAlreadyExistErrorMessage) || strings.HasPrefix(err.Error(), ReplicationSpecNotFoundErrorMessage)
-	} else {
+	switch err.(type) {
+	case *SpecAlreadyExistsError, *SpecNotFoundError:
+		return true
+	default:
 		return false
 	}
 }
@@ -533,6 +1905,14 @@ func getKeyFromReplicationId(replicationId string) string {
 	return ReplicationSpecsCatalogKey + base.KeyPartsDelimiter + replicationId
 }
 
+func getDerivedObjKeyFromReplicationId(replicationId string) string {
+	return ReplicationSpecDerivedObjsCatalogKey + base.KeyPartsDelimiter + replicationId
+}
+
+func getReplicationIdFromDerivedObjKey(key string) string {
+	return key[len(ReplicationSpecDerivedObjsCatalogKey)+len(base.KeyPartsDelimiter):]
+}
+
 func (service *ReplicationSpecService) getReplicationIdFromKey(key string) string {
 	prefix := ReplicationSpecsCatalogKey + base.KeyPartsDelimiter
 	if !strings.HasPrefix(key, prefix) {
@@ -542,54 +1922,178 @@ func (service *ReplicationSpecService) getReplicationIdFromKey(key string) strin
 	return key[len(prefix):]
 }
 
-func (service *ReplicationSpecService) ValidateExistingReplicationSpec(spec *metadata.ReplicationSpecification) (error, error) {
-	//validate the existence of source bucket
-	local_connStr, _ := service.xdcr_comp_topology_svc.MyConnectionStr()
-	if local_connStr == "" {
-		panic("XDCRTopologySvc.MyConnectionStr() should not return empty string")
-	}
-	sourceBucketUuid, err_source := utils.LocalBucketUUID(local_connStr, spec.SourceBucketName)
+// connection string and credentials of a remote cluster, as resolved from its reference
+type remoteClusterConnInfo struct {
+	connStr          string
+	userName         string
+	password         string
+	certificate      []byte
+	sanInCertificate bool
+	// pooled http client of the remote cluster. nil when it is not available, in which case a new client is used per lookup
+	client *http.Client
+	// reference of the remote cluster, with which bucket uuids are looked up in cluster info service
+	ref *metadata.RemoteClusterReference
+}
 
-	if err_source == utils.NonExistentBucketError {
-		errMsg := fmt.Sprintf("spec %v refers to non-existent source bucket \"%v\"", spec.Id, spec.SourceBucketName)
-		service.logger.Error(errMsg)
-		return InvalidReplicationSpecError, errors.New(errMsg)
+// lookups needed to validate existing replication specs
+type specValidationLookups struct {
+	sourceBucketUUID func(bucketName string) (string, error)
+	// returns the reason why the remote cluster reference is invalid, when it is invalid
+	targetCluster    func(targetClusterUUID string) (*remoteClusterConnInfo, string, error)
+	targetBucketUUID func(targetCluster *remoteClusterConnInfo, bucketName string) (string, error)
+}
+
+// bucket uuids are looked up in cluster info service first, as they are when new specs are constructed
+func (service *ReplicationSpecService) newSpecValidationLookups() *specValidationLookups {
+	return &specValidationLookups{
+		sourceBucketUUID: service.sourceBucketUUID,
+		targetCluster:    service.resolveTargetCluster,
+		targetBucketUUID: func(targetCluster *remoteClusterConnInfo, bucketName string) (string, error) {
+			if !base.ForceDirectBucketUUIDLookup && targetCluster.ref != nil {
+				if bucketUUID, ok := service.cluster_info_svc.GetBucketUUID(targetCluster.ref, bucketName); ok {
+					return bucketUUID, nil
+				}
+			}
+			// transient errors are retried, so that live specs are not garbage collected because of a blip in the network
+			targetBucketUUID, err_target := utils.RemoteBucketUUIDWithRetry(context.Background(), targetCluster.connStr, bucketName, targetCluster.userName,
+				targetCluster.password, targetCluster.certificate, targetCluster.sanInCertificate, targetCluster.client,
+				base.RemoteBucketLookupMaxAttempts, base.RemoteBucketLookupBaseBackoff, service.logger)
+			service.logger.Infof("result of remote bucket call:  remote_connStr=%v, targetBucketUUID=%v, err_target=%v\n", targetCluster.connStr, targetBucketUUID, err_target)
+			return targetBucketUUID, err_target
+		},
 	}
+}
 
-	if spec.SourceBucketUUID != "" && spec.SourceBucketUUID != sourceBucketUuid {
-		//spec is referring to a deleted bucket
-		errMsg := fmt.Sprintf("spec %v refers to bucket %v which was deleted and recreated", spec.Id, spec.SourceBucketName)
-		service.logger.Error(errMsg)
-		return InvalidReplicationSpecError, errors.New(errMsg)
+// returns lookups that remember the results of the given lookups, so that specs that share source buckets,
+// remote clusters or target buckets are validated with a single lookup of each. not safe for concurrent use
+func memoizeSpecValidationLookups(lookups *specValidationLookups) *specValidationLookups {
+	type bucketUUIDResult struct {
+		uuid string
+		err  error
+	}
+	type targetClusterResult struct {
+		targetCluster *remoteClusterConnInfo
+		invalidReason string
+		err           error
+	}
+	source_buckets := make(map[string]*bucketUUIDResult)
+	target_clusters := make(map[string]*targetClusterResult)
+	target_buckets := make(map[*remoteClusterConnInfo]map[string]*bucketUUIDResult)
+
+	return &specValidationLookups{
+		sourceBucketUUID: func(bucketName string) (string, error) {
+			result, ok := source_buckets[bucketName]
+			if !ok {
+				result = &bucketUUIDResult{}
+				result.uuid, result.err = lookups.sourceBucketUUID(bucketName)
+				source_buckets[bucketName] = result
+			}
+			return result.uuid, result.err
+		},
+		targetCluster: func(targetClusterUUID string) (*remoteClusterConnInfo, string, error) {
+			result, ok := target_clusters[targetClusterUUID]
+			if !ok {
+				result = &targetClusterResult{}
+				result.targetCluster, result.invalidReason, result.err = lookups.targetCluster(targetClusterUUID)
+				target_clusters[targetClusterUUID] = result
+			}
+			return result.targetCluster, result.invalidReason, result.err
+		},
+		targetBucketUUID: func(targetCluster *remoteClusterConnInfo, bucketName string) (string, error) {
+			buckets, ok := target_buckets[targetCluster]
+			if !ok {
+				buckets = make(map[string]*bucketUUIDResult)
+				target_buckets[targetCluster] = buckets
+			}
+			result, ok := buckets[bucketName]
+			if !ok {
+				result = &bucketUUIDResult{}
+				result.uuid, result.err = lookups.targetBucketUUID(targetCluster, bucketName)
+				buckets[bucketName] = result
+			}
+			return result.uuid, result.err
+		},
 	}
+}
 
-	//validate target cluster
-	targetClusterRef, err := service.remote_cluster_svc.RemoteClusterByUuid(spec.TargetClusterUUID, true)
+// resolves the connection string and credentials of the remote cluster. when the remote cluster reference
+// is invalid, the reason is returned. err is returned for other errors
+func (service *ReplicationSpecService) resolveTargetCluster(targetClusterUUID string) (*remoteClusterConnInfo, string, error) {
+	targetClusterRef, err := service.remote_cluster_svc.RemoteClusterByUuid(targetClusterUUID, true)
 	if err == service_def.MetadataNotFoundErr {
 		//remote cluster is no longer valid
-		errMsg := fmt.Sprintf("spec %v refers to non-existent remote cluster reference \"%v\"", spec.Id, spec.TargetClusterUUID)
-		service.logger.Errorf(errMsg)
-		return InvalidReplicationSpecError, errors.New(errMsg)
+		return nil, fmt.Sprintf("non-existent remote cluster reference \"%v\"", targetClusterUUID), nil
 	} else if err != nil {
-		return err, nil
+		return nil, "", err
 	}
 
-	remote_connStr, err := targetClusterRef.MyConnectionStr()
+	targetCluster := &remoteClusterConnInfo{}
+	targetCluster.connStr, err = targetClusterRef.MyConnectionStr()
 	if err != nil {
-		errMsg := fmt.Sprintf("spec %v refers to an invalid remote cluster reference \"%v\", as RemoteClusterRef.MyConnectionStr() returns err=%v\n", spec.Id, spec.TargetClusterUUID, err)
-		service.logger.Errorf(errMsg)
+		return nil, fmt.Sprintf("an invalid remote cluster reference \"%v\", as RemoteClusterRef.MyConnectionStr() returns err=%v\n", targetClusterUUID, err), nil
+	}
+	targetCluster.userName, targetCluster.password, targetCluster.certificate, targetCluster.sanInCertificate, err = targetClusterRef.MyCredentials()
+	if err != nil {
+		return nil, fmt.Sprintf("an invalid remote cluster reference \"%v\", as RemoteClusterRef.MyCredentials() returns err=%v\n", targetClusterUUID, err), nil
+	}
+	targetCluster.client = service.remoteClusterHttpClient(targetClusterRef)
+	targetCluster.ref = targetClusterRef
+	return targetCluster, "", nil
+}
+
+// returns the pooled http client of the remote cluster, or nil when it cannot be built, in which case
+// rest calls to the remote cluster fall back to creating new clients
+func (service *ReplicationSpecService) remoteClusterHttpClient(ref *metadata.RemoteClusterReference) *http.Client {
+	client, err := service.remote_cluster_svc.GetHttpClient(ref)
+	if err != nil {
+		service.logger.Infof("Failed to get pooled http client of remote cluster %v. err=%v\n", ref.Name, err)
+		return nil
+	}
+	return client
+}
+
+func (service *ReplicationSpecService) ValidateExistingReplicationSpec(spec *metadata.ReplicationSpecification) (error, error) {
+	return service.validateExistingReplicationSpec(spec, service.newSpecValidationLookups(), false /*dryRun*/)
+}
+
+func (service *ReplicationSpecService) validateExistingReplicationSpec(spec *metadata.ReplicationSpecification, lookups *specValidationLookups, dryRun bool) (error, error) {
+	//validate the existence of source bucket
+	sourceBucketUuid, err_source := lookups.sourceBucketUUID(spec.SourceBucketName)
+
+	if err_source == utils.NonExistentBucketError {
+		errMsg := fmt.Sprintf("spec %v refers to non-existent source bucket \"%v\"", spec.Id, spec.SourceBucketName)
+		service.logger.Error(errMsg)
 		return InvalidReplicationSpecError, errors.New(errMsg)
 	}
-	remote_userName, remote_password, certificate, sanInCertificate, err := targetClusterRef.MyCredentials()
+
+	rebindOnRecreate := spec.Settings != nil && spec.Settings.RebindOnRecreate
+	// the new uuids of the buckets that have been deleted and recreated, which the spec is to be bound to
+	newSourceBucketUUID := ""
+	newTargetBucketUUID := ""
+
+	if spec.SourceBucketUUID != "" && spec.SourceBucketUUID != sourceBucketUuid {
+		if rebindOnRecreate && err_source == nil && sourceBucketUuid != "" {
+			newSourceBucketUUID = sourceBucketUuid
+		} else {
+			//spec is referring to a deleted bucket
+			errMsg := fmt.Sprintf("spec %v refers to bucket %v which was deleted and recreated", spec.Id, spec.SourceBucketName)
+			service.logger.Error(errMsg)
+			return InvalidReplicationSpecError, errors.New(errMsg)
+		}
+	}
+
+	//validate target cluster
+	targetCluster, invalidReason, err := lookups.targetCluster(spec.TargetClusterUUID)
 	if err != nil {
-		errMsg := fmt.Sprintf("spec %v refers to an invalid remote cluster reference \"%v\", as RemoteClusterRef.MyCredentials() returns err=%v\n", spec.Id, spec.TargetClusterUUID, err)
+		return err, nil
+	} else if invalidReason != "" {
+		errMsg := fmt.Sprintf("spec %v refers to %v", spec.Id, invalidReason)
 		service.logger.Errorf(errMsg)
 		return InvalidReplicationSpecError, errors.New(errMsg)
 	}
 
 	//validate target bucket
-	targetBucketUUID, err_target := utils.RemoteBucketUUID(remote_connStr, spec.TargetBucketName, remote_userName, remote_password, certificate, sanInCertificate, service.logger)
-	service.logger.Infof("result of remote bucket call:  remote_connStr=%v, targetBucketUUID=%v, err_target=%v\n", remote_connStr, targetBucketUUID, err_target)
+	targetBucketUUID, err_target := lookups.targetBucketUUID(targetCluster, spec.TargetBucketName)
 
 	if err_target == utils.NonExistentBucketError {
 		errMsg := fmt.Sprintf("spec %v refers to non-existent target bucket \"%v\"\n", spec.Id, spec.TargetBucketName)
@@ -597,35 +2101,194 @@ func (service *ReplicationSpecService) ValidateExistingReplicationSpec(spec *met
 		return InvalidReplicationSpecError, errors.New(errMsg)
 	} else if err_target != nil {
 		service.logger.Infof("Received error %v when validating target bucket %v for spec %v. Skipping target bucket validation. remote_connStr=%v, remote_userName=%v\n",
-			err_target, spec.TargetBucketName, spec.Id, remote_connStr, remote_userName)
+			err_target, spec.TargetBucketName, spec.Id, targetCluster.connStr, targetCluster.userName)
 	}
 
 	if spec.TargetBucketUUID != "" && spec.TargetBucketUUID != targetBucketUUID {
-		//spec is referring to a deleted bucket
-		errMsg := fmt.Sprintf("spec %v refers to bucket %v which was deleted and recreated\n", spec.Id, spec.TargetBucketName)
-		service.logger.Errorf(errMsg)
-		return InvalidReplicationSpecError, errors.New(errMsg)
+		if rebindOnRecreate && err_target == nil && targetBucketUUID != "" {
+			newTargetBucketUUID = targetBucketUUID
+		} else {
+			//spec is referring to a deleted bucket
+			errMsg := fmt.Sprintf("spec %v refers to bucket %v which was deleted and recreated\n", spec.Id, spec.TargetBucketName)
+			service.logger.Errorf(errMsg)
+			return InvalidReplicationSpecError, errors.New(errMsg)
+		}
+	}
+
+	if newSourceBucketUUID != "" || newTargetBucketUUID != "" {
+		if dryRun {
+			// the spec is still valid since it would be rebound, which is left to the next validation that is not a dry run
+			service.logger.Infof("Spec %v would be rebound to recreated buckets. Skipping rebinding in dry run\n", spec.Id)
+			return nil, nil
+		}
+		return service.rebindSpec(spec, newSourceBucketUUID, newTargetBucketUUID), nil
 	}
 
 	return nil, nil
 }
 
+// binds the spec to the recreated source and/or target buckets, as indicated by non-empty new bucket uuids, and
+// persists it. the spec passed in, which may be the one in cache, is left untouched
+func (service *ReplicationSpecService) rebindSpec(spec *metadata.ReplicationSpecification, newSourceBucketUUID, newTargetBucketUUID string) error {
+	reboundSpec := spec.Clone()
+	reboundSpec.SourceBucketUUID = spec.SourceBucketUUID
+	reboundSpec.TargetBucketUUID = spec.TargetBucketUUID
+	reboundSpec.Revision = spec.Revision
+
+	recreatedBuckets := make([]string, 0, 2)
+	if newSourceBucketUUID != "" {
+		service.logger.Infof("Rebinding spec %v to recreated source bucket %v. old uuid=%v, new uuid=%v\n", spec.Id, spec.SourceBucketName, spec.SourceBucketUUID, newSourceBucketUUID)
+		reboundSpec.SourceBucketUUID = newSourceBucketUUID
+		recreatedBuckets = append(recreatedBuckets, fmt.Sprintf("source bucket \"%s\"", spec.SourceBucketName))
+	}
+	if newTargetBucketUUID != "" {
+		service.logger.Infof("Rebinding spec %v to recreated target bucket %v. old uuid=%v, new uuid=%v\n", spec.Id, spec.TargetBucketName, spec.TargetBucketUUID, newTargetBucketUUID)
+		reboundSpec.TargetBucketUUID = newTargetBucketUUID
+		recreatedBuckets = append(recreatedBuckets, fmt.Sprintf("target bucket \"%s\"", spec.TargetBucketName))
+	}
+
+	err := service.SetReplicationSpec(reboundSpec)
+	if err != nil {
+		service.logger.Errorf("Failed to rebind spec %v to recreated buckets. err=%v\n", spec.Id, err)
+		return err
+	}
+	verb := "was"
+	if len(recreatedBuckets) > 1 {
+		verb = "were"
+	}
+	service.writeUiLog(reboundSpec, "has been rebound", fmt.Sprintf("%v %v deleted and recreated", strings.Join(recreatedBuckets, " and "), verb))
+	return nil
+}
+
 func (service *ReplicationSpecService) ValidateAndGC(spec *metadata.ReplicationSpecification) {
+	if suspended, remaining := service.GCSuspensionStatus(); suspended {
+		service.logger.Infof("Garbage collection is suspended for another %v. Skipping validation of replication specification %v\n", remaining, spec.Id)
+		return
+	}
+
 	err, detail_err := service.ValidateExistingReplicationSpec(spec)
 	if err == InvalidReplicationSpecError {
-		service.logger.Errorf("Replication specification %v is no longer valid, garbage collect it. error=%v\n", spec.Id, detail_err)
-		_, err1 := service.delReplicationSpec_internal(spec.Id, detail_err.Error())
-		if err1 != nil {
-			service.logger.Infof("Failed to garbage collect spec %v, err=%v\n", spec.Id, err1)
+		service.garbageCollectSpec(spec, detail_err)
+	}
+}
+
+// same as calling ValidateAndGC on each of the specs, except that specs are grouped by target cluster, and each
+// remote cluster reference, source bucket and target bucket is looked up only once for the whole batch
+func (service *ReplicationSpecService) ValidateAndGCBatch(specs []*metadata.ReplicationSpecification) *metadata.ReplicationSpecGCSummary {
+	summary := metadata.NewReplicationSpecGCSummary()
+	if len(specs) == 0 {
+		return summary
+	}
+	if suspended, remaining := service.GCSuspensionStatus(); suspended {
+		service.logger.Infof("Garbage collection is suspended for another %v. Skipping validation of %v replication specifications\n", remaining, len(specs))
+		for _, spec := range specs {
+			summary.Skipped[spec.Id] = GCSuspendedError
+		}
+		return summary
+	}
+
+	service.validateAndGCBatch(specs, memoizeSpecValidationLookups(service.newSpecValidationLookups()), false /*dryRun*/, summary)
+	return summary
+}
+
+// same as ValidateAndGCBatch, except that invalid specs are only reported in the Invalid field of the summary and are
+// not garbage collected. it is not affected by the suspension of garbage collection
+func (service *ReplicationSpecService) ValidateBatch(specs []*metadata.ReplicationSpecification) *metadata.ReplicationSpecGCSummary {
+	summary := metadata.NewReplicationSpecGCSummary()
+	if len(specs) == 0 {
+		return summary
+	}
+
+	service.validateAndGCBatch(specs, memoizeSpecValidationLookups(service.newSpecValidationLookups()), true /*dryRun*/, summary)
+	return summary
+}
+
+func (service *ReplicationSpecService) validateAndGCBatch(specs []*metadata.ReplicationSpecification, lookups *specValidationLookups, dryRun bool, summary *metadata.ReplicationSpecGCSummary) {
+	// specs to the same target cluster are validated together, after its reference has been resolved
+	targetClusterUUIDs := make([]string, 0)
+	specs_by_target_cluster := make(map[string][]*metadata.ReplicationSpecification)
+	for _, spec := range specs {
+		if _, ok := specs_by_target_cluster[spec.TargetClusterUUID]; !ok {
+			targetClusterUUIDs = append(targetClusterUUIDs, spec.TargetClusterUUID)
+		}
+		specs_by_target_cluster[spec.TargetClusterUUID] = append(specs_by_target_cluster[spec.TargetClusterUUID], spec)
+	}
+
+	for _, targetClusterUUID := range targetClusterUUIDs {
+		for _, spec := range specs_by_target_cluster[targetClusterUUID] {
+			err, detail_err := service.validateExistingReplicationSpec(spec, lookups, dryRun)
+			if err == InvalidReplicationSpecError {
+				if dryRun {
+					summary.Invalid[spec.Id] = detail_err
+				} else if err1 := service.garbageCollectSpec(spec, detail_err); err1 != nil {
+					summary.GCFailed[spec.Id] = err1
+				} else {
+					summary.GarbageCollected[spec.Id] = detail_err
+				}
+			} else if err != nil {
+				summary.Skipped[spec.Id] = err
+			}
 		}
 	}
+	service.logger.Infof("Validated %v replication specifications in batch. dryRun=%v, invalid=%v, garbage collected=%v, failed to garbage collect=%v, skipped=%v\n",
+		len(specs), dryRun, summary.Invalid, summary.GarbageCollected, summary.GCFailed, summary.Skipped)
+}
+
+func (service *ReplicationSpecService) garbageCollectSpec(spec *metadata.ReplicationSpecification, detail_err error) error {
+	service.logger.Errorf("Replication specification %v is no longer valid, garbage collect it. error=%v\n", spec.Id, detail_err)
+	_, err := service.delReplicationSpec_internal(spec.Id, detail_err.Error())
+	if err != nil {
+		service.logger.Infof("Failed to garbage collect spec %v, err=%v\n", spec.Id, err)
+	}
+	return err
+}
+
+// suspends garbage collection of invalid specs for the duration, e.g., during a rolling bucket recreation or restore.
+// a new suspension replaces the current one
+func (service *ReplicationSpecService) SuspendGC(duration time.Duration) error {
+	if duration <= 0 {
+		return InvalidGCSuspensionDurationError
+	}
+	service.gc_suspension_lock.Lock()
+	defer service.gc_suspension_lock.Unlock()
+	service.gc_suspended_until = time.Now().Add(duration)
+	service.logger.Infof("Garbage collection of replication specifications is suspended until %v\n", service.gc_suspended_until)
+	return nil
+}
+
+// resumes garbage collection of invalid specs before the suspension expires
+func (service *ReplicationSpecService) ResumeGC() {
+	service.gc_suspension_lock.Lock()
+	defer service.gc_suspension_lock.Unlock()
+	if !service.gc_suspended_until.IsZero() {
+		service.gc_suspended_until = time.Time{}
+		service.logger.Infof("Garbage collection of replication specifications has been resumed\n")
+	}
+}
+
+// whether garbage collection of invalid specs is suspended, and the remaining time of the suspension
+func (service *ReplicationSpecService) GCSuspensionStatus() (bool, time.Duration) {
+	service.gc_suspension_lock.RLock()
+	defer service.gc_suspension_lock.RUnlock()
+	remaining := service.gc_suspended_until.Sub(time.Now())
+	if service.gc_suspended_until.IsZero() || remaining <= 0 {
+		return false, 0
+	}
+	return true, remaining
 }
 
+// bucket uuids are retrieved from cluster info service when available there, which saves rest calls.
+// direct rest calls are the authoritative fallback, and are always used when base.ForceDirectBucketUUIDLookup is set
 func (service *ReplicationSpecService) sourceBucketUUID(bucketName string) (string, error) {
 	local_connStr, _ := service.xdcr_comp_topology_svc.MyConnectionStr()
 	if local_connStr == "" {
 		panic("XDCRTopologySvc.MyConnectionStr() should not return empty string")
 	}
+	if !base.ForceDirectBucketUUIDLookup {
+		if bucketUUID, ok := service.cluster_info_svc.GetBucketUUID(service.xdcr_comp_topology_svc, bucketName); ok {
+			return bucketUUID, nil
+		}
+	}
 	return utils.LocalBucketUUID(local_connStr, bucketName)
 }
 
@@ -643,6 +2306,12 @@ func (service *ReplicationSpecService) targetBucketUUID(targetClusterUUID, bucke
 		return "", err_target
 	}
 
+	if !base.ForceDirectBucketUUIDLookup {
+		if bucketUUID, ok := service.cluster_info_svc.GetBucketUUID(ref, bucketName); ok {
+			return bucketUUID, nil
+		}
+	}
+
 	return utils.RemoteBucketUUID(remote_connStr, bucketName, remote_userName, remote_password, certificate, sanInCertificate, service.logger)
 }
 
@@ -662,6 +2331,39 @@ func (service *ReplicationSpecService) ConstructNewReplicationSpec(sourceBucketN
 	return spec, nil
 }
 
+// same as ConstructNewReplicationSpec, and applies settings, keyed and typed as in ReplicationSettings.ToMap, on top of
+// the default settings of the new spec. settings are validated before bucket uuids are looked up, and error is returned
+// when any of them has an unknown key or a value of the wrong type
+func (service *ReplicationSpecService) ConstructNewReplicationSpecWithSettings(sourceBucketName, targetClusterUUID, targetBucketName string, settings map[string]interface{}) (*metadata.ReplicationSpecification, error) {
+	replSettings := metadata.DefaultSettings()
+	_, errorMap := replSettings.UpdateSettingsFromMap(settings)
+	if len(errorMap) > 0 {
+		return nil, fmt.Errorf("Invalid settings for replication from bucket %v to bucket %v on cluster %v. errors=[%v]",
+			sourceBucketName, targetBucketName, targetClusterUUID, settingsErrorsString(errorMap))
+	}
+
+	spec, err := service.ConstructNewReplicationSpec(sourceBucketName, targetClusterUUID, targetBucketName)
+	if err != nil {
+		return nil, err
+	}
+	spec.Settings = replSettings
+	return spec, nil
+}
+
+// errors of settings, sorted by settings key
+func settingsErrorsString(errorMap map[string]error) string {
+	keys := make([]string, 0, len(errorMap))
+	for key, _ := range errorMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	errMsgs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		errMsgs = append(errMsgs, fmt.Sprintf("%v: %v", key, errorMap[key]))
+	}
+	return strings.Join(errMsgs, ", ")
+}
+
 func (service *ReplicationSpecService) cacheSpec(cache *MetadataCache, specId string, spec *metadata.ReplicationSpecification) error {
 	var cachedObj *ReplicationSpecVal = nil
 	var updatedCachedObj *ReplicationSpecVal = nil
@@ -675,7 +2377,13 @@ func (service *ReplicationSpecService) cacheSpec(cache *MetadataCache, specId st
 		updatedCachedObj = &ReplicationSpecVal{
 			spec:       spec,
 			derivedObj: cachedObj.derivedObj,
+			state:      cachedObj.state,
 			cas:        cachedObj.cas}
+		// a spec recreated with the same id as a deleted spec, which may still be in terminal Deleting state
+		// while its derived object is cleaned up, starts over in Created state
+		if cachedObj.spec == nil {
+			updatedCachedObj.state = metadata.ReplicationStateCreated
+		}
 	} else {
 		//never being cached before
 		updatedCachedObj = &ReplicationSpecVal{spec: spec}
@@ -688,7 +2396,7 @@ func (service *ReplicationSpecService) SetDerivedObj(specId string, derivedObj i
 
 	cachedVal, ok := cache.Get(specId)
 	if !ok || cachedVal == nil {
-		return fmt.Errorf(ReplicationSpecNotFoundErrorMessage)
+		return &SpecNotFoundError{ReplicationId: specId}
 	}
 	cachedObj, ok := cachedVal.(*ReplicationSpecVal)
 	if !ok {
@@ -699,10 +2407,13 @@ func (service *ReplicationSpecService) SetDerivedObj(specId string, derivedObj i
 		//remove it from the cache
 		service.logger.Infof("Remove spec %v from the cache\n", specId)
 		cache.Delete(specId)
+		// the derived object of the spec may have been persisted through SetDerivedObjWithPersist
+		return service.delDerivedObjRecord(specId)
 	} else {
 		updatedCachedObj := &ReplicationSpecVal{
 			spec:       cachedObj.spec,
 			derivedObj: derivedObj,
+			state:      cachedObj.state,
 			cas:        cachedObj.cas}
 		err := cache.Upsert(specId, updatedCachedObj)
 		if err != nil {
@@ -712,10 +2423,105 @@ func (service *ReplicationSpecService) SetDerivedObj(specId string, derivedObj i
 	return nil
 }
 
+// same as SetDerivedObj, except that the derived object is also persisted in metadata service in a record separate
+// from the spec, so that it can be restored when cache is initialized, instead of being recomputed.
+// the record is removed when derivedObj is nil, or when the spec is finally removed from the cache
+func (service *ReplicationSpecService) SetDerivedObjWithPersist(specId string, derivedObj interface{}, marshal func(interface{}) ([]byte, error)) error {
+	var value []byte
+	var err error
+	if derivedObj != nil {
+		value, err = marshal(derivedObj)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = service.SetDerivedObj(specId, derivedObj)
+	if err != nil {
+		return err
+	}
+
+	if derivedObj == nil {
+		return service.delDerivedObjRecord(specId)
+	}
+	return service.setDerivedObjRecord(specId, value)
+}
+
+func (service *ReplicationSpecService) setDerivedObjRecord(specId string, value []byte) error {
+	key := getDerivedObjKeyFromReplicationId(specId)
+	_, rev, err := service.metadata_svc.Get(key)
+	service.write_limiter.wait()
+	if err == service_def.MetadataNotFoundErr {
+		err = service.metadata_svc.AddWithCatalog(ReplicationSpecDerivedObjsCatalogKey, key, value)
+	} else if err == nil {
+		err = service.metadata_svc.Set(key, value, rev)
+	}
+	service.recordWriteResult(err)
+	if err != nil {
+		service.logger.Errorf("Failed to persist derived object of replication spec %v. err=%v\n", specId, err)
+	}
+	return err
+}
+
+func (service *ReplicationSpecService) delDerivedObjRecord(specId string) error {
+	key := getDerivedObjKeyFromReplicationId(specId)
+	_, rev, err := service.metadata_svc.Get(key)
+	if err == service_def.MetadataNotFoundErr {
+		// derived object of the spec has never been persisted
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	service.write_limiter.wait()
+	err = service.metadata_svc.DelWithCatalog(ReplicationSpecDerivedObjsCatalogKey, key, rev)
+	service.recordWriteResult(err)
+	if err != nil {
+		service.logger.Errorf("Failed to delete persisted derived object of replication spec %v. err=%v\n", specId, err)
+	}
+	return err
+}
+
+// restores persisted derived objects into cache, which has been loaded with specs. failure to restore a derived object
+// is not fatal, since it can be recomputed. records of specs that no longer exist, e.g., specs deleted while the process
+// was down, are cleaned up
+func (service *ReplicationSpecService) restoreDerivedObjs(cache *MetadataCache) {
+	entries, err := service.metadata_svc.GetAllMetadataFromCatalog(ReplicationSpecDerivedObjsCatalogKey)
+	if err != nil {
+		service.logger.Errorf("Failed to get persisted derived objects of replication specs. err=%v\n", err)
+		return
+	}
+
+	for _, entry := range entries {
+		specId := getReplicationIdFromDerivedObjKey(entry.Key)
+		cachedVal, ok := cache.Get(specId)
+		if !ok || cachedVal == nil {
+			service.logger.Infof("Deleting persisted derived object of replication spec %v, which no longer exists\n", specId)
+			err = service.metadata_svc.DelWithCatalog(ReplicationSpecDerivedObjsCatalogKey, entry.Key, entry.Rev)
+			if err != nil {
+				service.logger.Errorf("Failed to delete persisted derived object of replication spec %v. err=%v\n", specId, err)
+			}
+			continue
+		}
+
+		derivedObj, err := service.derived_obj_unmarshal(specId, entry.Value)
+		if err != nil {
+			service.logger.Errorf("Failed to restore derived object of replication spec %v. It will be recomputed. err=%v\n", specId, err)
+			continue
+		}
+		cachedObj := cachedVal.(*ReplicationSpecVal)
+		cache.Upsert(specId, &ReplicationSpecVal{
+			spec:       cachedObj.spec,
+			derivedObj: derivedObj,
+			state:      cachedObj.state,
+			cas:        cachedObj.cas})
+	}
+}
+
 func (service *ReplicationSpecService) GetDerviedObj(specId string) (interface{}, error) {
 	cachedVal, ok := service.getCache().Get(specId)
 	if !ok || cachedVal == nil {
-		return nil, fmt.Errorf(ReplicationSpecNotFoundErrorMessage)
+		return nil, &SpecNotFoundError{ReplicationId: specId}
 	}
 
 	cachedObj, ok := cachedVal.(*ReplicationSpecVal)
@@ -724,3 +2530,55 @@ func (service *ReplicationSpecService) GetDerviedObj(specId string) (interface{}
 	}
 	return cachedObj.derivedObj, nil
 }
+
+// move replication to the specified lifecycle state. returns error if the transition is not allowed
+func (service *ReplicationSpecService) SetReplicationState(replicationId string, state metadata.ReplicationState) error {
+	cache := service.getCache()
+
+	for i := 0; i < service_def.MaxNumOfRetries; i++ {
+		cachedVal, ok := cache.Get(replicationId)
+		if !ok || cachedVal == nil {
+			return &SpecNotFoundError{ReplicationId: replicationId}
+		}
+		cachedObj, ok := cachedVal.(*ReplicationSpecVal)
+		if !ok {
+			panic("Object in ReplicationSpecServcie cache is not of type *replciationSpecVal")
+		}
+
+		err := metadata.ValidateReplicationStateTransition(cachedObj.state, state)
+		if err != nil {
+			return err
+		}
+		if cachedObj.state == state {
+			return nil
+		}
+
+		updatedCachedObj := &ReplicationSpecVal{
+			spec:       cachedObj.spec,
+			derivedObj: cachedObj.derivedObj,
+			state:      state,
+			cas:        cachedObj.cas}
+		err = cache.Upsert(replicationId, updatedCachedObj)
+		if err == nil {
+			service.logger.Infof("Replication %v moved from state %v to state %v\n", replicationId, cachedObj.state, state)
+			return nil
+		} else if err != CASMisMatchError {
+			return err
+		}
+		// spec has been changed concurrently. retry with the latest value in cache
+	}
+	return fmt.Errorf("Failed to set state of replication %v to %v after %v retries", replicationId, state, service_def.MaxNumOfRetries)
+}
+
+func (service *ReplicationSpecService) GetReplicationState(replicationId string) (metadata.ReplicationState, error) {
+	cachedVal, ok := service.getCache().Get(replicationId)
+	if !ok || cachedVal == nil {
+		return metadata.ReplicationStateCreated, &SpecNotFoundError{ReplicationId: replicationId}
+	}
+
+	cachedObj, ok := cachedVal.(*ReplicationSpecVal)
+	if !ok || cachedObj == nil {
+		panic("Object in ReplicationSpecServcie cache is not of type *replciationSpecVal")
+	}
+	return cachedObj.state, nil
+}
@@ -0,0 +1,2120 @@
+// Copyright (c) 2013 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package metadata_svc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/couchbase/go-couchbase"
+	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/log"
+	"github.com/couchbase/goxdcr/metadata"
+	"github.com/couchbase/goxdcr/service_def"
+	"github.com/couchbase/goxdcr/utils"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const benchmarkNumOfSpecs = 5000
+
+// constructs a ReplicationSpecService with an in-memory cache only, bypassing metakv
+func newTestReplicationSpecService(numOfSpecs int) *ReplicationSpecService {
+	logger := log.NewLogger("ReplicationSpecServiceTest", log.DefaultLoggerContext)
+	service := &ReplicationSpecService{
+		cache:            NewMetadataCache(logger),
+		cache_lock:       &sync.Mutex{},
+		specs_snapshot:   &atomic.Value{},
+		logger:           logger,
+		write_limiter:    newMetadataWriteLimiter(0),
+		settings_history: make(map[string][]metadata.SettingsChange),
+	}
+	for i := 0; i < numOfSpecs; i++ {
+		spec := newTestReplicationSpec(i, 0)
+		service.cacheSpec(service.cache, spec.Id, spec)
+	}
+	service.refreshSpecsSnapshot()
+	return service
+}
+
+func newTestReplicationSpec(index int, rev int) *metadata.ReplicationSpecification {
+	spec := metadata.NewReplicationSpecification(fmt.Sprintf("source%v", index), "", "targetClusterUUID", fmt.Sprintf("target%v", index), "")
+	spec.Revision = rev
+	return spec
+}
+
+// the implementation of AllReplicationSpecs before the snapshot was introduced
+func allReplicationSpecsByCopy(service *ReplicationSpecService) map[string]*metadata.ReplicationSpecification {
+	service.cache_lock.Lock()
+	defer service.cache_lock.Unlock()
+	return service.buildSpecsSnapshot()
+}
+
+// keeps updating specs in cache, as metakv callbacks would, until fin_ch is closed
+func startCallbacks(service *ReplicationSpecService, fin_ch chan bool, wait_grp *sync.WaitGroup) {
+	wait_grp.Add(1)
+	go func() {
+		defer wait_grp.Done()
+		for rev := 1; ; rev++ {
+			select {
+			case <-fin_ch:
+				return
+			default:
+				spec := newTestReplicationSpec(rev%benchmarkNumOfSpecs, rev)
+				service.updateCache(spec.Id, spec)
+			}
+		}
+	}()
+}
+
+func benchmarkAllReplicationSpecs(b *testing.B, getSpecs func(*ReplicationSpecService) map[string]*metadata.ReplicationSpecification) {
+	service := newTestReplicationSpecService(benchmarkNumOfSpecs)
+	fin_ch := make(chan bool)
+	wait_grp := &sync.WaitGroup{}
+	startCallbacks(service, fin_ch, wait_grp)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if len(getSpecs(service)) != benchmarkNumOfSpecs {
+				b.Fatalf("unexpected number of specs")
+			}
+		}
+	})
+	b.StopTimer()
+
+	close(fin_ch)
+	wait_grp.Wait()
+}
+
+func BenchmarkAllReplicationSpecsCopy(b *testing.B) {
+	benchmarkAllReplicationSpecs(b, allReplicationSpecsByCopy)
+}
+
+func BenchmarkAllReplicationSpecsSnapshot(b *testing.B) {
+	benchmarkAllReplicationSpecs(b, func(service *ReplicationSpecService) map[string]*metadata.ReplicationSpecification {
+		specs, _ := service.AllReplicationSpecs()
+		return specs
+	})
+}
+
+func TestAllReplicationSpecsSnapshot(t *testing.T) {
+	service := newTestReplicationSpecService(10)
+	specs, _ := service.AllReplicationSpecs()
+	if len(specs) != 10 {
+		t.Fatalf("expected 10 specs, got %v", len(specs))
+	}
+
+	spec := newTestReplicationSpec(10, 0)
+	service.updateCache(spec.Id, spec)
+	specs, _ = service.AllReplicationSpecs()
+	if len(specs) != 11 {
+		t.Fatalf("expected 11 specs after add, got %v", len(specs))
+	}
+
+	service.updateCache(spec.Id, nil)
+	specs, _ = service.AllReplicationSpecs()
+	if _, ok := specs[spec.Id]; ok || len(specs) != 10 {
+		t.Fatalf("expected deleted spec %v to be removed from snapshot", spec.Id)
+	}
+}
+
+// in-memory metadata service that supports the catalog operations used by AddReplicationSpec
+type testMetadataSvc struct {
+	entries map[string][]byte
+	// revisions of entries, when set. entries have nil revisions otherwise
+	revs map[string]interface{}
+	// when set, returned by all writes
+	write_err error
+}
+
+func newTestMetadataSvc() *testMetadataSvc {
+	return &testMetadataSvc{entries: make(map[string][]byte)}
+}
+
+func (meta_svc *testMetadataSvc) Get(key string) ([]byte, interface{}, error) {
+	value, ok := meta_svc.entries[key]
+	if !ok {
+		return nil, nil, service_def.MetadataNotFoundErr
+	}
+	return value, meta_svc.revs[key], nil
+}
+
+func (meta_svc *testMetadataSvc) Add(key string, value []byte) error {
+	if meta_svc.write_err != nil {
+		return meta_svc.write_err
+	}
+	if _, ok := meta_svc.entries[key]; ok {
+		return service_def.ErrorKeyAlreadyExist
+	}
+	meta_svc.entries[key] = value
+	return nil
+}
+
+func (meta_svc *testMetadataSvc) AddSensitive(key string, value []byte) error {
+	return meta_svc.Add(key, value)
+}
+
+func (meta_svc *testMetadataSvc) Set(key string, value []byte, rev interface{}) error {
+	if meta_svc.write_err != nil {
+		return meta_svc.write_err
+	}
+	meta_svc.entries[key] = value
+	return nil
+}
+
+func (meta_svc *testMetadataSvc) SetSensitive(key string, value []byte, rev interface{}) error {
+	return meta_svc.Set(key, value, rev)
+}
+
+func (meta_svc *testMetadataSvc) Del(key string, rev interface{}) error {
+	delete(meta_svc.entries, key)
+	return nil
+}
+
+func (meta_svc *testMetadataSvc) AddWithCatalog(catalogKey, key string, value []byte) error {
+	return meta_svc.Add(key, value)
+}
+
+func (meta_svc *testMetadataSvc) AddSensitiveWithCatalog(catalogKey, key string, value []byte) error {
+	return meta_svc.Add(key, value)
+}
+
+func (meta_svc *testMetadataSvc) DelWithCatalog(catalogKey, key string, rev interface{}) error {
+	return meta_svc.Del(key, rev)
+}
+
+func (meta_svc *testMetadataSvc) GetAllMetadataFromCatalog(catalogKey string) ([]*service_def.MetadataEntry, error) {
+	entries := make([]*service_def.MetadataEntry, 0)
+	for key, value := range meta_svc.entries {
+		if strings.HasPrefix(key, catalogKey+base.KeyPartsDelimiter) {
+			entries = append(entries, &service_def.MetadataEntry{Key: key, Value: value, Rev: meta_svc.revs[key]})
+		}
+	}
+	return entries, nil
+}
+
+func (meta_svc *testMetadataSvc) GetAllKeysFromCatalog(catalogKey string) ([]string, error) {
+	keys := make([]string, 0)
+	for key, _ := range meta_svc.entries {
+		if strings.HasPrefix(key, catalogKey+base.KeyPartsDelimiter) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (meta_svc *testMetadataSvc) DelAllFromCatalog(catalogKey string) error {
+	meta_svc.entries = make(map[string][]byte)
+	return nil
+}
+
+func TestValidateNewReplicationSpecWithNilSettings(t *testing.T) {
+	// target cluster, which serves the info of bucket "target"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != base.DefaultPoolBucketsPath+"target" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"uuid":"targetUUID","bucketType":"membase","conflictResolutionType":"seqno"}`))
+	}))
+	defer server.Close()
+
+	service := newTestReplicationSpecService(0)
+	service.xdcr_comp_topology_svc = &testXDCRTopologySvc{}
+	service.remote_cluster_svc = &testSingleRemoteClusterSvc{ref: &metadata.RemoteClusterReference{Uuid: "targetClusterUUID",
+		Name: "remote", HostName: server.Listener.Addr().String()}}
+	service.local_bucket_getter = func(localConnStr, bucketName string) (*couchbase.Bucket, error) {
+		return &couchbase.Bucket{Name: bucketName, Type: base.CouchbaseBucketType, UUID: "sourceUUID",
+			ConflictResolutionType: base.ConflictResolutionType_Seqno}, nil
+	}
+
+	for _, testCase := range []struct {
+		settings map[string]interface{}
+		// replication type of the settings, which is xmem when the settings are nil
+		isXmem bool
+	}{
+		{nil, true},
+		{map[string]interface{}{metadata.ReplicationType: metadata.ReplicationTypeCapi}, false},
+	} {
+		// the target cluster is checked to be xmem compatible for xmem replications only
+		cluster_info_svc := &countingClusterInfoSvc{compatible: true}
+		service.cluster_info_svc = cluster_info_svc
+		service.InvalidateClusterCompatibility("targetClusterUUID")
+
+		sourceBucketUUID, targetBucketUUID, targetClusterRef, errorMap, _ := service.ValidateNewReplicationSpec(context.Background(),
+			"source", "remote", "target", testCase.settings)
+		if len(errorMap) != 0 {
+			t.Errorf("unexpected errors validating spec with settings %v. errorMap=%v", testCase.settings, errorMap)
+			continue
+		}
+		if sourceBucketUUID != "sourceUUID" || targetBucketUUID != "targetUUID" || targetClusterRef.Uuid != "targetClusterUUID" {
+			t.Errorf("validation returned %v, %v and %v", sourceBucketUUID, targetBucketUUID, targetClusterRef.Uuid)
+		}
+		if xmemChecked := cluster_info_svc.num_of_requests > 0; xmemChecked != testCase.isXmem {
+			t.Errorf("xmem compatibility checked=%v for settings %v, expected %v", xmemChecked, testCase.settings, testCase.isXmem)
+		}
+	}
+}
+
+func TestValidateFilterExpression(t *testing.T) {
+	for _, filterExpression := range []interface{}{"", "^abc.*", "[0-9]+$"} {
+		errorMap := make(map[string]error)
+		validateFilterExpression(map[string]interface{}{metadata.FilterExpression: filterExpression}, errorMap)
+		if len(errorMap) != 0 {
+			t.Errorf("unexpected errors for filter expression %q: %v", filterExpression, errorMap)
+		}
+	}
+
+	errorMap := make(map[string]error)
+	validateFilterExpression(map[string]interface{}{metadata.FilterExpression: "abc[", metadata.Active: true}, errorMap)
+	_, expectedErr := regexp.Compile("abc[")
+	if err := errorMap[base.FilterExpression]; err == nil || err.Error() != expectedErr.Error() {
+		t.Errorf("expected error %v for invalid filter expression, got %v", expectedErr, err)
+	}
+
+	errorMap = make(map[string]error)
+	validateFilterExpression(map[string]interface{}{metadata.FilterExpression: 1}, errorMap)
+	if errorMap[base.FilterExpression] == nil {
+		t.Errorf("expected error for filter expression of wrong type")
+	}
+}
+
+func TestNamedFilter(t *testing.T) {
+	service := newTestReplicationSpecService(0)
+	meta_svc := newTestMetadataSvc()
+	service.metadata_svc = meta_svc
+
+	for name, filterExpression := range map[string]string{"": "^abc", "app/1": "^abc", "empty": "", "invalid": "abc["} {
+		if err := service.RegisterNamedFilter(name, filterExpression); err == nil {
+			t.Errorf("expected error registering filter %q with expression %q", name, filterExpression)
+		}
+	}
+	if len(meta_svc.entries) != 0 {
+		t.Errorf("invalid filters have been persisted: %v", meta_svc.entries)
+	}
+
+	if err := service.RegisterNamedFilter("app1", "^app0:"); err != nil {
+		t.Fatalf("failed to register filter. err=%v", err)
+	}
+	// registering an existing name replaces its expression
+	if err := service.RegisterNamedFilter("app1", "^app1:"); err != nil {
+		t.Fatalf("failed to replace filter. err=%v", err)
+	}
+	if _, ok := meta_svc.entries[getKeyFromFilterName("app1")]; !ok {
+		t.Errorf("filter has not been persisted in metadata store")
+	}
+	if filterExpression, err := service.GetNamedFilter("app1"); err != nil || filterExpression != "^app1:" {
+		t.Errorf("got filter expression %q, err=%v, expected ^app1:", filterExpression, err)
+	}
+	// filters registered on other nodes are read from metadata store
+	otherService := newTestReplicationSpecService(0)
+	otherService.metadata_svc = meta_svc
+	if namedFilters, err := otherService.AllNamedFilters(); err != nil || !reflect.DeepEqual(namedFilters, map[string]string{"app1": "^app1:"}) {
+		t.Errorf("got named filters %v, err=%v, expected app1 only", namedFilters, err)
+	}
+
+	// the expression of the named filter is used, and the name is kept so that the spec records it
+	errorMap := make(map[string]error)
+	settings := map[string]interface{}{metadata.FilterName: "app1"}
+	service.resolveFilterName(settings, errorMap)
+	if len(errorMap) != 0 {
+		t.Errorf("unexpected errors resolving filter name: %v", errorMap)
+	}
+	if settings[metadata.FilterExpression] != "^app1:" || settings[metadata.FilterName] != "app1" {
+		t.Errorf("settings are %v after filter name has been resolved", settings)
+	}
+	replSettings := metadata.DefaultSettings()
+	if _, errorMap := replSettings.UpdateSettingsFromMap(settings); len(errorMap) != 0 || replSettings.FilterName != "app1" {
+		t.Errorf("filter name of replication is %q, errors=%v, expected app1", replSettings.FilterName, errorMap)
+	}
+
+	settings = map[string]interface{}{metadata.FilterName: "app1", metadata.FilterExpression: "^app2:"}
+	service.resolveFilterName(settings, errorMap)
+	if errorMap[base.FilterName] == nil || settings[metadata.FilterExpression] != "^app2:" {
+		t.Errorf("expected error for filter name specified together with filter expression")
+	}
+
+	errorMap = make(map[string]error)
+	settings = map[string]interface{}{metadata.FilterName: "missing"}
+	service.resolveFilterName(settings, errorMap)
+	if errorMap[base.FilterName] == nil {
+		t.Errorf("expected error for filter that does not exist")
+	}
+	if _, ok := settings[metadata.FilterExpression]; ok {
+		t.Errorf("filter expression should not have been set for filter that does not exist")
+	}
+}
+
+func TestValidateFilterKeyPrefix(t *testing.T) {
+	for _, settings := range []map[string]interface{}{
+		{metadata.FilterKeyPrefix: "app1:"},
+		{metadata.FilterKeyPrefix: "", metadata.FilterExpression: "^app1:.*"},
+		{metadata.FilterKeyPrefix: "app1:", metadata.FilterExpression: ""},
+		{metadata.FilterExpression: "^app1:.*"},
+	} {
+		errorMap := make(map[string]error)
+		validateFilterKeyPrefix(settings, errorMap)
+		if len(errorMap) != 0 {
+			t.Errorf("unexpected errors for settings %v: %v", settings, errorMap)
+		}
+	}
+
+	errorMap := make(map[string]error)
+	validateFilterKeyPrefix(map[string]interface{}{metadata.FilterKeyPrefix: "app1:", metadata.FilterExpression: "^app1:.*"}, errorMap)
+	err := errorMap[base.FilterKeyPrefix]
+	if err == nil || !strings.Contains(err.Error(), base.FilterExpression) {
+		t.Errorf("expected error naming the setting to remove when both filters are set, got %v", err)
+	}
+
+	errorMap = make(map[string]error)
+	validateFilterKeyPrefix(map[string]interface{}{metadata.FilterKeyPrefix: 1}, errorMap)
+	if errorMap[base.FilterKeyPrefix] == nil {
+		t.Errorf("expected error for filter key prefix of wrong type")
+	}
+}
+
+func TestAddReplicationSpecWithNilSettings(t *testing.T) {
+	service := newTestReplicationSpecService(0)
+	service.metadata_svc = newTestMetadataSvc()
+
+	spec := newTestReplicationSpec(0, 0)
+	spec.Settings = nil
+	err := service.AddReplicationSpec(spec)
+	if err != nil {
+		t.Fatalf("failed to add spec with nil settings. err=%v", err)
+	}
+
+	added, err := service.ReplicationSpec(spec.Id)
+	if err != nil {
+		t.Fatalf("failed to retrieve added spec. err=%v", err)
+	}
+	if added.Settings == nil || added.Settings.RepType != metadata.ReplicationTypeXmem {
+		t.Fatalf("expected default settings with replication type %v, got %v", metadata.ReplicationTypeXmem, added.Settings)
+	}
+}
+
+// clock that advances only when sleep is called, and keeps track of the total time slept
+type testLimiterClock struct {
+	cur_time time.Time
+	slept    time.Duration
+}
+
+func (clock *testLimiterClock) now() time.Time {
+	return clock.cur_time
+}
+
+func (clock *testLimiterClock) sleep(d time.Duration) {
+	clock.cur_time = clock.cur_time.Add(d)
+	clock.slept += d
+}
+
+func TestMetadataWriteRate(t *testing.T) {
+	service := newTestReplicationSpecService(0)
+	service.metadata_svc = newTestMetadataSvc()
+	clock := &testLimiterClock{cur_time: time.Unix(0, 0)}
+	service.write_limiter = newMetadataWriteLimiterWithClock(0, clock.now, clock.sleep)
+
+	rate := 50
+	numOfWrites := 26
+	service.SetMetadataWriteRate(rate)
+
+	for i := 0; i < numOfWrites; i++ {
+		err := service.AddReplicationSpec(newTestReplicationSpec(i, 0))
+		if err != nil {
+			t.Fatalf("failed to add spec. err=%v", err)
+		}
+	}
+
+	// the first write goes through immediately and each subsequent write waits for 1/rate second
+	expected := time.Duration(numOfWrites-1) * time.Second / time.Duration(rate)
+	if diff := clock.slept - expected; diff < -time.Millisecond || diff > time.Millisecond {
+		t.Fatalf("%v writes at %v ops/sec waited for %v, expected %v", numOfWrites, rate, clock.slept, expected)
+	}
+
+	// writes are not paced once the limit is removed
+	service.SetMetadataWriteRate(0)
+	clock.slept = 0
+	for i := numOfWrites; i < 2*numOfWrites; i++ {
+		service.AddReplicationSpec(newTestReplicationSpec(i, 0))
+	}
+	if clock.slept != 0 {
+		t.Fatalf("%v writes without rate limit waited for %v", numOfWrites, clock.slept)
+	}
+}
+
+func TestReplicationSpecWithNonCanonicalId(t *testing.T) {
+	service := newTestReplicationSpecService(3)
+	spec := newTestReplicationSpec(1, 0)
+
+	ids := []string{
+		spec.Id,
+		url.QueryEscape(spec.Id),
+		"  " + spec.Id + "\t",
+		" " + url.QueryEscape(spec.Id) + " ",
+	}
+	for _, id := range ids {
+		found, err := service.ReplicationSpec(id)
+		if err != nil {
+			t.Errorf("Unexpected error looking up spec with id %q. err=%v", id, err)
+		} else if found.Id != spec.Id {
+			t.Errorf("Id %q was mapped to spec %v, expected %v", id, found.Id, spec.Id)
+		}
+	}
+
+	if _, err := service.ReplicationSpec(url.QueryEscape("nonExistingId/bucket")); err == nil {
+		t.Errorf("Expected error looking up non-existing spec")
+	}
+}
+
+func TestSettingsHistory(t *testing.T) {
+	service := newTestReplicationSpecService(0)
+	service.metadata_svc = newTestMetadataSvc()
+
+	oldDepth := base.SettingsHistoryDepth
+	base.SettingsHistoryDepth = 2
+	defer func() { base.SettingsHistoryDepth = oldDepth }()
+
+	spec := newTestReplicationSpec(0, 0)
+	if err := service.AddReplicationSpec(spec); err != nil {
+		t.Fatalf("failed to add spec. err=%v", err)
+	}
+
+	for _, batchCount := range []int{100, 200, 300} {
+		spec, _ = service.ReplicationSpec(spec.Id)
+		spec.Settings.BatchCount = batchCount
+		if err := service.SetReplicationSpec(spec); err != nil {
+			t.Fatalf("failed to set spec. err=%v", err)
+		}
+	}
+
+	history, err := service.GetSettingsHistory(spec.Id)
+	if err != nil {
+		t.Fatalf("failed to get settings history. err=%v", err)
+	}
+	// the oldest change is dropped since history depth is 2
+	if len(history) != 2 {
+		t.Fatalf("expected 2 settings changes, got %v", history)
+	}
+	for index, expected := range [][2]interface{}{{100, 200}, {200, 300}} {
+		if history[index].Changes[metadata.BatchCount] != expected {
+			t.Errorf("expected change %v of %v, got %v", expected, metadata.BatchCount, history[index].Changes)
+		}
+	}
+	if history[0].Time.After(history[1].Time) {
+		t.Errorf("expected settings changes to be in chronological order, got %v", history)
+	}
+
+	if _, err = service.DelReplicationSpec(spec.Id); err != nil {
+		t.Fatalf("failed to delete spec. err=%v", err)
+	}
+	if _, ok := service.settings_history[spec.Id]; ok {
+		t.Errorf("expected settings history to be pruned on spec deletion")
+	}
+	if _, err = service.GetSettingsHistory(spec.Id); err == nil {
+		t.Errorf("expected error getting settings history of deleted spec")
+	}
+}
+
+func TestGetSpecRaw(t *testing.T) {
+	service := newTestReplicationSpecService(0)
+	service.metadata_svc = newTestMetadataSvc()
+
+	spec := newTestReplicationSpec(0, 0)
+	if err := service.AddReplicationSpec(spec); err != nil {
+		t.Fatalf("failed to add spec. err=%v", err)
+	}
+	// the stored spec diverges from the spec in cache
+	stored := []byte(`{"id":"` + spec.Id + `","unknownField":true}`)
+	service.metadata_svc.Set(getKeyFromReplicationId(spec.Id), stored, nil)
+
+	value, _, err := service.GetSpecRaw(spec.Id)
+	if err != nil {
+		t.Fatalf("failed to get raw spec. err=%v", err)
+	}
+	if string(value) != string(stored) {
+		t.Errorf("raw spec is %s, expected %s", value, stored)
+	}
+
+	if _, _, err = service.GetSpecRaw("nonExistingId"); !service.IsReplicationValidationError(err) {
+		t.Errorf("expected spec not found error for non-existing spec, got %v", err)
+	}
+}
+
+// constructs a ReplicationSpecService on top of a metadata service that stores numOfSpecs specs, without initializing cache
+func newTestReplicationSpecServiceForCacheLoad(numOfSpecs int) *ReplicationSpecService {
+	service := newTestReplicationSpecService(0)
+	meta_svc := newTestMetadataSvc()
+	for i := 0; i < numOfSpecs; i++ {
+		spec := newTestReplicationSpec(i, 0)
+		value, _ := json.Marshal(spec)
+		meta_svc.entries[getKeyFromReplicationId(spec.Id)] = value
+	}
+	service.metadata_svc = meta_svc
+	return service
+}
+
+func TestInitCacheConcurrently(t *testing.T) {
+	oldConcurrency := SpecCacheLoadConcurrency
+	defer func() { SpecCacheLoadConcurrency = oldConcurrency }()
+
+	numOfSpecs := 1000
+	for _, concurrency := range []int{0, 1, 8} {
+		SpecCacheLoadConcurrency = concurrency
+		service := newTestReplicationSpecServiceForCacheLoad(numOfSpecs)
+		if err := service.initCache(); err != nil {
+			t.Fatalf("failed to init cache with concurrency %v. err=%v", concurrency, err)
+		}
+		specs, _ := service.AllReplicationSpecs()
+		if len(specs) != numOfSpecs {
+			t.Fatalf("expected %v specs with concurrency %v, got %v", numOfSpecs, concurrency, len(specs))
+		}
+		for i := 0; i < numOfSpecs; i++ {
+			expected := newTestReplicationSpec(i, 0)
+			if spec, ok := specs[expected.Id]; !ok || spec.TargetBucketName != expected.TargetBucketName {
+				t.Fatalf("spec %v is not loaded correctly with concurrency %v, got %v", expected.Id, concurrency, spec)
+			}
+		}
+	}
+
+	// a malformed entry fails cache initialization regardless of concurrency
+	SpecCacheLoadConcurrency = 8
+	service := newTestReplicationSpecServiceForCacheLoad(numOfSpecs)
+	service.metadata_svc.(*testMetadataSvc).entries[getKeyFromReplicationId("malformed")] = []byte("{")
+	if err := service.initCache(); err == nil {
+		t.Errorf("expected error initializing cache with malformed entry")
+	}
+}
+
+func benchmarkInitCache(b *testing.B, concurrency int) {
+	oldConcurrency := SpecCacheLoadConcurrency
+	SpecCacheLoadConcurrency = concurrency
+	defer func() { SpecCacheLoadConcurrency = oldConcurrency }()
+
+	service := newTestReplicationSpecServiceForCacheLoad(20000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := service.initCache(); err != nil {
+			b.Fatalf("failed to init cache. err=%v", err)
+		}
+	}
+}
+
+func BenchmarkInitCacheSerial(b *testing.B) {
+	benchmarkInitCache(b, 1)
+}
+
+func BenchmarkInitCacheConcurrent(b *testing.B) {
+	benchmarkInitCache(b, 0)
+}
+
+// lookups under which specs with source bucket "source9" are invalid
+func newTestBulkAddLookups() *specValidationLookups {
+	return &specValidationLookups{
+		sourceBucketUUID: func(bucketName string) (string, error) {
+			if bucketName == "source9" {
+				return "", utils.NonExistentBucketError
+			}
+			return "", nil
+		},
+		targetCluster: func(targetClusterUUID string) (*remoteClusterConnInfo, string, error) {
+			return &remoteClusterConnInfo{connStr: targetClusterUUID}, "", nil
+		},
+		targetBucketUUID: func(targetCluster *remoteClusterConnInfo, bucketName string) (string, error) {
+			return "", nil
+		},
+	}
+}
+
+func TestBulkAddReplicationSpecs(t *testing.T) {
+	service := newTestReplicationSpecService(1)
+	meta_svc := newTestMetadataSvc()
+	service.metadata_svc = meta_svc
+
+	// nothing is written when any spec in the batch is invalid
+	existingSpec := newTestReplicationSpec(0, 0)
+	duplicateSpec := newTestReplicationSpec(2, 0)
+	invalidSpec := newTestReplicationSpec(9, 0)
+	validSpec := newTestReplicationSpec(1, 0)
+	errorMap, err := service.bulkAddReplicationSpecs([]*metadata.ReplicationSpecification{validSpec, existingSpec,
+		duplicateSpec, invalidSpec, newTestReplicationSpec(2, 0)}, newTestBulkAddLookups())
+	if err == nil {
+		t.Errorf("expected error for batch with invalid specs")
+	}
+	if len(errorMap) != 4 {
+		t.Errorf("error map is %v, expected errors for 4 specs", errorMap)
+	}
+	if errorMap[duplicateSpec.Id] != DuplicateReplicationSpecInBatchError {
+		t.Errorf("error of duplicate spec is %v, expected %v", errorMap[duplicateSpec.Id], DuplicateReplicationSpecInBatchError)
+	}
+	if _, ok := errorMap[existingSpec.Id].(*SpecAlreadyExistsError); !ok {
+		t.Errorf("error of existing spec is %v, expected SpecAlreadyExistsError", errorMap[existingSpec.Id])
+	}
+	if errorMap[invalidSpec.Id] == nil || errorMap[invalidSpec.Id] == BulkAddAbortedError {
+		t.Errorf("error of invalid spec is %v, expected validation error", errorMap[invalidSpec.Id])
+	}
+	if errorMap[validSpec.Id] != BulkAddAbortedError {
+		t.Errorf("error of valid spec is %v, expected %v", errorMap[validSpec.Id], BulkAddAbortedError)
+	}
+	if len(meta_svc.entries) != 0 {
+		t.Errorf("%v specs persisted, expected none", len(meta_svc.entries))
+	}
+	if specs, _ := service.AllReplicationSpecs(); len(specs) != 1 {
+		t.Errorf("%v specs in cache, expected 1", len(specs))
+	}
+
+	// specs written before a failed write are rolled back
+	// the key of this spec is already in metadata store, so writing it fails
+	failedSpec := newTestReplicationSpec(3, 0)
+	meta_svc.entries[getKeyFromReplicationId(failedSpec.Id)] = []byte("{}")
+	newSpecs := []*metadata.ReplicationSpecification{newTestReplicationSpec(1, 0), newTestReplicationSpec(4, 0)}
+	errorMap, err = service.bulkAddReplicationSpecs([]*metadata.ReplicationSpecification{newSpecs[0], failedSpec,
+		newSpecs[1]}, newTestBulkAddLookups())
+	if err == nil {
+		t.Errorf("expected error for batch with failed write")
+	}
+	if errorMap[failedSpec.Id] != service_def.ErrorKeyAlreadyExist {
+		t.Errorf("error of failed spec is %v, expected %v", errorMap[failedSpec.Id], service_def.ErrorKeyAlreadyExist)
+	}
+	for _, spec := range newSpecs {
+		if errorMap[spec.Id] != BulkAddAbortedError {
+			t.Errorf("error of spec %v is %v, expected %v", spec.Id, errorMap[spec.Id], BulkAddAbortedError)
+		}
+		if _, ok := meta_svc.entries[getKeyFromReplicationId(spec.Id)]; ok {
+			t.Errorf("spec %v is persisted though the batch has not been added", spec.Id)
+		}
+		if _, err := service.ReplicationSpec(spec.Id); err == nil {
+			t.Errorf("spec %v is in cache though the batch has not been added", spec.Id)
+		}
+	}
+
+	// a valid batch is added as a whole
+	delete(meta_svc.entries, getKeyFromReplicationId(failedSpec.Id))
+	errorMap, err = service.bulkAddReplicationSpecs([]*metadata.ReplicationSpecification{newSpecs[0], failedSpec,
+		newSpecs[1]}, newTestBulkAddLookups())
+	if err != nil || len(errorMap) != 0 {
+		t.Errorf("unexpected errors adding valid batch. err=%v, errorMap=%v", err, errorMap)
+	}
+	for _, spec := range append(newSpecs, failedSpec) {
+		if _, ok := meta_svc.entries[getKeyFromReplicationId(spec.Id)]; !ok {
+			t.Errorf("spec %v is not persisted", spec.Id)
+		}
+		if _, err := service.ReplicationSpec(spec.Id); err != nil {
+			t.Errorf("spec %v is not in cache. err=%v", spec.Id, err)
+		}
+	}
+	if specs, _ := service.AllReplicationSpecs(); len(specs) != 4 {
+		t.Errorf("%v specs in cache, expected 4", len(specs))
+	}
+}
+
+func TestReplicationStateNotPersisted(t *testing.T) {
+	service := newTestReplicationSpecService(0)
+	meta_svc := newTestMetadataSvc()
+	service.metadata_svc = meta_svc
+
+	spec := newTestReplicationSpec(0, 0)
+	value, _ := json.Marshal(spec)
+	key := getKeyFromReplicationId(spec.Id)
+	meta_svc.entries[key] = value
+	service.updateCache(spec.Id, spec)
+
+	if err := service.SetReplicationState(spec.Id, metadata.ReplicationStateStarting); err != nil {
+		t.Fatalf("unexpected error setting state. err=%v", err)
+	}
+	if state, _ := service.GetReplicationState(spec.Id); state != metadata.ReplicationStateStarting {
+		t.Errorf("state is %v, expected %v", state, metadata.ReplicationStateStarting)
+	}
+	// state is runtime information of this node, and state transitions do not write to metadata store
+	if !reflect.DeepEqual(meta_svc.entries[key], value) {
+		t.Errorf("spec in metadata store has been changed by state transition")
+	}
+
+	// state is kept when the spec is updated
+	updatedSpec := newTestReplicationSpec(0, 1)
+	service.updateCache(updatedSpec.Id, updatedSpec)
+	if state, _ := service.GetReplicationState(spec.Id); state != metadata.ReplicationStateStarting {
+		t.Errorf("state of updated spec is %v, expected %v", state, metadata.ReplicationStateStarting)
+	}
+
+	// a deleted spec keeps its state in cache while it is being cleaned up
+	service.updateCache(spec.Id, nil)
+	if err := service.SetReplicationState(spec.Id, metadata.ReplicationStateDeleting); err != nil {
+		t.Fatalf("unexpected error setting state of deleted spec. err=%v", err)
+	}
+	if state, _ := service.GetReplicationState(spec.Id); state != metadata.ReplicationStateDeleting {
+		t.Errorf("state of deleted spec is %v, expected %v", state, metadata.ReplicationStateDeleting)
+	}
+
+	// a spec recreated with the same id does not inherit the terminal state of the deleted one
+	recreatedSpec := newTestReplicationSpec(0, 2)
+	service.updateCache(recreatedSpec.Id, recreatedSpec)
+	if state, _ := service.GetReplicationState(recreatedSpec.Id); state != metadata.ReplicationStateCreated {
+		t.Errorf("state of recreated spec is %v, expected %v", state, metadata.ReplicationStateCreated)
+	}
+	if err := service.SetReplicationState(recreatedSpec.Id, metadata.ReplicationStateStarting); err != nil {
+		t.Errorf("unexpected error starting recreated spec. err=%v", err)
+	}
+}
+
+func TestMetadataStoreFull(t *testing.T) {
+	service := newTestReplicationSpecService(0)
+	meta_svc := newTestMetadataSvc()
+	service.metadata_svc = meta_svc
+
+	meta_svc.write_err = service_def.MetadataStoreFullError
+	spec := newTestReplicationSpec(0, 0)
+	if err := service.AddReplicationSpec(spec); err != service_def.MetadataStoreFullError {
+		t.Errorf("error adding spec is %v, expected %v", err, service_def.MetadataStoreFullError)
+	}
+	if !service.IsMetadataStoreFull() {
+		t.Errorf("metadata store is not reported as full")
+	}
+	if _, err := service.ReplicationSpec(spec.Id); err == nil {
+		t.Errorf("spec is in cache though it has not been persisted")
+	}
+
+	// other write failures do not change the state
+	meta_svc.write_err = service_def.MetaKVFailedAfterMaxTries
+	service.AddReplicationSpec(spec)
+	if !service.IsMetadataStoreFull() {
+		t.Errorf("metadata store is not reported as full after an unrelated write failure")
+	}
+
+	meta_svc.write_err = nil
+	if err := service.AddReplicationSpec(spec); err != nil {
+		t.Fatalf("failed to add spec. err=%v", err)
+	}
+	if service.IsMetadataStoreFull() {
+		t.Errorf("metadata store is still reported as full after a successful write")
+	}
+}
+
+func TestSuspendGC(t *testing.T) {
+	service := newTestReplicationSpecService(1)
+	spec := newTestReplicationSpec(0, 0)
+
+	if suspended, _ := service.GCSuspensionStatus(); suspended {
+		t.Errorf("garbage collection is suspended by default")
+	}
+	if err := service.SuspendGC(0); err != InvalidGCSuspensionDurationError {
+		t.Errorf("error suspending with 0 duration is %v, expected %v", err, InvalidGCSuspensionDurationError)
+	}
+
+	if err := service.SuspendGC(time.Minute); err != nil {
+		t.Fatalf("failed to suspend garbage collection. err=%v", err)
+	}
+	suspended, remaining := service.GCSuspensionStatus()
+	if !suspended || remaining <= 0 || remaining > time.Minute {
+		t.Errorf("suspension status is (%v, %v), expected suspension with up to %v remaining", suspended, remaining, time.Minute)
+	}
+	// returns without validating the spec, which would need services that are not set up
+	service.ValidateAndGC(spec)
+	if _, err := service.ReplicationSpec(spec.Id); err != nil {
+		t.Errorf("spec is gone after ValidateAndGC while garbage collection is suspended. err=%v", err)
+	}
+
+	service.ResumeGC()
+	if suspended, _ := service.GCSuspensionStatus(); suspended {
+		t.Errorf("garbage collection is still suspended after it is resumed")
+	}
+
+	// suspension expires by itself
+	service.SuspendGC(10 * time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if suspended, _ := service.GCSuspensionStatus(); suspended {
+		t.Errorf("garbage collection is still suspended after suspension has expired")
+	}
+}
+
+type testSpecChange struct {
+	specId string
+	oldRev interface{}
+	newRev interface{}
+}
+
+func specRevision(spec *metadata.ReplicationSpecification) interface{} {
+	if spec == nil {
+		return nil
+	}
+	return spec.Revision
+}
+
+func TestSubscribeToSpecChanges(t *testing.T) {
+	service := newTestReplicationSpecService(0)
+	spec := newTestReplicationSpec(0, 0)
+	otherSpec := newTestReplicationSpec(1, 0)
+
+	changes := make([]testSpecChange, 0)
+	unsubscribe := service.Subscribe(func(specId string, oldSpec, newSpec *metadata.ReplicationSpecification) {
+		changes = append(changes, testSpecChange{specId, specRevision(oldSpec), specRevision(newSpec)})
+		// changing specs from a callback does not deadlock, and the change is delivered after the current one
+		if specId == spec.Id && newSpec != nil && newSpec.Revision == 1 {
+			service.updateCache(otherSpec.Id, otherSpec)
+		}
+	})
+
+	service.updateCache(spec.Id, spec)
+	// no change is delivered when the spec in cache is the same
+	service.updateCache(spec.Id, newTestReplicationSpec(0, 0))
+	service.updateCache(spec.Id, newTestReplicationSpec(0, 1))
+	service.updateCache(spec.Id, nil)
+
+	expected := []testSpecChange{
+		{spec.Id, nil, 0},
+		{spec.Id, 0, 1},
+		{otherSpec.Id, nil, 0},
+		{spec.Id, 1, nil},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("delivered changes are %v, expected %v", changes, expected)
+	}
+
+	unsubscribe()
+	service.updateCache(spec.Id, spec)
+	if len(changes) != len(expected) {
+		t.Errorf("changes are delivered after unsubscribing: %v", changes[len(expected):])
+	}
+}
+
+func TestActiveAndPausedReplicationSpecs(t *testing.T) {
+	service := newTestReplicationSpecService(0)
+	pausedSpec := newTestReplicationSpec(0, 0)
+	pausedSpec.Settings.Active = false
+	activeSpec := newTestReplicationSpec(1, 0)
+	activeSpec.Settings.Active = true
+	service.updateCache(pausedSpec.Id, pausedSpec)
+	service.updateCache(activeSpec.Id, activeSpec)
+
+	activeSpecs, err := service.AllActiveReplicationSpecs()
+	if err != nil || len(activeSpecs) != 1 || activeSpecs[activeSpec.Id] == nil {
+		t.Errorf("active specs are %v, err=%v, expected %v only", activeSpecs, err, activeSpec.Id)
+	}
+	pausedSpecs, err := service.AllPausedReplicationSpecs()
+	if err != nil || len(pausedSpecs) != 1 || pausedSpecs[pausedSpec.Id] == nil {
+		t.Errorf("paused specs are %v, err=%v, expected %v only", pausedSpecs, err, pausedSpec.Id)
+	}
+
+	if active, err := service.IsSpecActive(activeSpec.Id); err != nil || !active {
+		t.Errorf("IsSpecActive of active spec returned (%v, %v)", active, err)
+	}
+	if active, err := service.IsSpecActive(pausedSpec.Id); err != nil || active {
+		t.Errorf("IsSpecActive of paused spec returned (%v, %v)", active, err)
+	}
+	if _, err := service.IsSpecActive("nonExistingId"); err == nil {
+		t.Errorf("expected error for non-existing spec")
+	}
+}
+
+// remote cluster service that knows the names of remote clusters only
+type testRemoteClusterSvc struct {
+	service_def.RemoteClusterSvc
+	names map[string]string
+}
+
+func (remote_cluster_svc *testRemoteClusterSvc) GetRemoteClusterNameFromClusterUuid(uuid string) string {
+	return remote_cluster_svc.names[uuid]
+}
+
+func TestAddReplicationSpecDryRun(t *testing.T) {
+	service := newTestReplicationSpecService(1)
+	meta_svc := newTestMetadataSvc()
+	service.metadata_svc = meta_svc
+	service.remote_cluster_svc = &testRemoteClusterSvc{names: map[string]string{"targetClusterUUID": "remote"}}
+
+	// bucket uuids are set so that they need not be looked up
+	spec := newTestReplicationSpec(1, 0)
+	spec.SourceBucketUUID = "sourceUUID"
+	spec.TargetBucketUUID = "targetUUID"
+	spec.Settings = nil
+
+	result, err := service.AddReplicationSpecDryRun(spec)
+	if err != nil {
+		t.Fatalf("dry run failed. err=%v", err)
+	}
+	expected := &metadata.ReplicationSpecDryRunResult{
+		ReplicationId:    spec.Id,
+		SourceBucketUUID: "sourceUUID",
+		TargetBucketUUID: "targetUUID",
+		UiLogMessage:     "Replication from bucket \"source1\" to bucket \"target1\" on cluster \"remote\" created.",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("dry run result is %+v, expected %+v", result, expected)
+	}
+	// nothing is persisted or cached, and the spec passed in is left as it is
+	if len(meta_svc.entries) != 0 {
+		t.Errorf("dry run wrote %v to metadata store", meta_svc.entries)
+	}
+	if _, err := service.ReplicationSpec(spec.Id); err == nil {
+		t.Errorf("spec has been added to cache by dry run")
+	}
+	if spec.Settings != nil {
+		t.Errorf("settings of spec have been modified by dry run")
+	}
+
+	existingSpec := newTestReplicationSpec(0, 0)
+	existingSpec.SourceBucketUUID = "sourceUUID"
+	existingSpec.TargetBucketUUID = "targetUUID"
+	result, err = service.AddReplicationSpecDryRun(existingSpec)
+	if err != nil || !result.AlreadyExists {
+		t.Errorf("dry run of existing spec returned (%+v, %v), expected spec to be reported as existing", result, err)
+	}
+
+	mismatchedSpec := newTestReplicationSpec(2, 0)
+	mismatchedSpec.Id = "otherId"
+	if _, err = service.AddReplicationSpecDryRun(mismatchedSpec); err == nil {
+		t.Errorf("expected error for spec whose id does not match its source and target")
+	}
+}
+
+// derived object that is persisted as json
+type testDerivedObj struct {
+	Count int
+}
+
+func marshalTestDerivedObj(obj interface{}) ([]byte, error) {
+	return json.Marshal(obj)
+}
+
+func unmarshalTestDerivedObj(specId string, data []byte) (interface{}, error) {
+	obj := &testDerivedObj{}
+	err := json.Unmarshal(data, obj)
+	return obj, err
+}
+
+func TestPersistDerivedObj(t *testing.T) {
+	service := newTestReplicationSpecServiceForCacheLoad(3)
+	service.derived_obj_unmarshal = unmarshalTestDerivedObj
+	if err := service.initCache(); err != nil {
+		t.Fatalf("failed to init cache. err=%v", err)
+	}
+	meta_svc := service.metadata_svc.(*testMetadataSvc)
+	spec0, spec1, spec2 := newTestReplicationSpec(0, 0), newTestReplicationSpec(1, 0), newTestReplicationSpec(2, 0)
+
+	if err := service.SetDerivedObjWithPersist(spec0.Id, &testDerivedObj{Count: 1}, marshalTestDerivedObj); err != nil {
+		t.Fatalf("failed to persist derived object. err=%v", err)
+	}
+	// persisted record is overwritten
+	if err := service.SetDerivedObjWithPersist(spec0.Id, &testDerivedObj{Count: 2}, marshalTestDerivedObj); err != nil {
+		t.Fatalf("failed to persist derived object. err=%v", err)
+	}
+	if err := service.SetDerivedObjWithPersist(spec1.Id, &testDerivedObj{Count: 3}, marshalTestDerivedObj); err != nil {
+		t.Fatalf("failed to persist derived object. err=%v", err)
+	}
+	// derived objects set through SetDerivedObj are not persisted
+	service.SetDerivedObj(spec2.Id, &testDerivedObj{Count: 4})
+	if err := service.SetDerivedObjWithPersist("nonExisting", &testDerivedObj{}, marshalTestDerivedObj); err == nil {
+		t.Errorf("expected error persisting derived object of non-existing spec")
+	}
+	if _, _, err := meta_svc.Get(getDerivedObjKeyFromReplicationId("nonExisting")); err != service_def.MetadataNotFoundErr {
+		t.Errorf("derived object of non-existing spec has been persisted")
+	}
+
+	// restart
+	if err := service.initCache(); err != nil {
+		t.Fatalf("failed to init cache. err=%v", err)
+	}
+	for specId, expected := range map[string]interface{}{spec0.Id: &testDerivedObj{Count: 2}, spec1.Id: &testDerivedObj{Count: 3}, spec2.Id: nil} {
+		derivedObj, err := service.GetDerviedObj(specId)
+		if err != nil {
+			t.Fatalf("failed to get derived object. err=%v", err)
+		}
+		if !reflect.DeepEqual(derivedObj, expected) {
+			t.Errorf("derived object of %v is %v after restart, expected %v", specId, derivedObj, expected)
+		}
+	}
+
+	// soft deleted spec keeps its derived object and the persisted record until the derived object is cleared
+	service.updateCache(spec0.Id, nil)
+	if _, _, err := meta_svc.Get(getDerivedObjKeyFromReplicationId(spec0.Id)); err != nil {
+		t.Errorf("persisted derived object was removed when spec was soft deleted. err=%v", err)
+	}
+	if err := service.SetDerivedObj(spec0.Id, nil); err != nil {
+		t.Fatalf("failed to clear derived object. err=%v", err)
+	}
+	if _, _, err := meta_svc.Get(getDerivedObjKeyFromReplicationId(spec0.Id)); err != service_def.MetadataNotFoundErr {
+		t.Errorf("persisted derived object was not removed when spec was finally deleted. err=%v", err)
+	}
+
+	// records of specs deleted while the process was down are cleaned up on restart, and
+	// records that cannot be unmarshalled are skipped
+	delete(meta_svc.entries, getKeyFromReplicationId(spec1.Id))
+	meta_svc.entries[getDerivedObjKeyFromReplicationId(spec2.Id)] = []byte("{")
+	if err := service.initCache(); err != nil {
+		t.Fatalf("failed to init cache. err=%v", err)
+	}
+	if _, _, err := meta_svc.Get(getDerivedObjKeyFromReplicationId(spec1.Id)); err != service_def.MetadataNotFoundErr {
+		t.Errorf("persisted derived object of deleted spec was not cleaned up. err=%v", err)
+	}
+	if derivedObj, err := service.GetDerviedObj(spec2.Id); err != nil || derivedObj != nil {
+		t.Errorf("derived object restored from malformed record is %v. err=%v", derivedObj, err)
+	}
+}
+
+func TestValidateAndGCBatch(t *testing.T) {
+	service := newTestReplicationSpecService(0)
+	meta_svc := newTestMetadataSvc()
+	service.metadata_svc = meta_svc
+
+	// spec index -> target cluster
+	targetClusters := []string{"cluster1", "cluster1", "cluster1", "cluster2", "cluster2", "deletedCluster", "unreachableCluster"}
+	specs := make([]*metadata.ReplicationSpecification, len(targetClusters))
+	for index, targetClusterUUID := range targetClusters {
+		spec := metadata.NewReplicationSpecification(fmt.Sprintf("source%v", index/2), "", targetClusterUUID, fmt.Sprintf("target%v", index%2), "")
+		spec.TargetBucketUUID = "targetUUID"
+		value, _ := json.Marshal(spec)
+		meta_svc.entries[getKeyFromReplicationId(spec.Id)] = value
+		service.cacheSpec(service.cache, spec.Id, spec)
+		specs[index] = spec
+	}
+	service.refreshSpecsSnapshot()
+
+	source_lookups := 0
+	target_cluster_lookups := make(map[string]int)
+	target_bucket_lookups := 0
+	lookups := &specValidationLookups{
+		sourceBucketUUID: func(bucketName string) (string, error) {
+			source_lookups++
+			return "", nil
+		},
+		targetCluster: func(targetClusterUUID string) (*remoteClusterConnInfo, string, error) {
+			target_cluster_lookups[targetClusterUUID]++
+			switch targetClusterUUID {
+			case "deletedCluster":
+				return nil, "non-existent remote cluster reference", nil
+			case "unreachableCluster":
+				return nil, "", errors.New("remote cluster is not reachable")
+			}
+			return &remoteClusterConnInfo{connStr: targetClusterUUID}, "", nil
+		},
+		targetBucketUUID: func(targetCluster *remoteClusterConnInfo, bucketName string) (string, error) {
+			target_bucket_lookups++
+			// target1 on cluster2 has been recreated
+			if targetCluster.connStr == "cluster2" && bucketName == "target1" {
+				return "recreatedTargetUUID", nil
+			}
+			return "targetUUID", nil
+		},
+	}
+
+	// a dry run reports invalid specs without garbage collecting them
+	summary := metadata.NewReplicationSpecGCSummary()
+	service.validateAndGCBatch(specs, memoizeSpecValidationLookups(lookups), true /*dryRun*/, summary)
+	if len(summary.Invalid) != 2 || summary.Invalid[specs[3].Id] == nil || summary.Invalid[specs[5].Id] == nil {
+		t.Errorf("invalid specs in dry run are %v, expected %v and %v", summary.Invalid, specs[3].Id, specs[5].Id)
+	}
+	if len(summary.GarbageCollected) != 0 || len(summary.GCFailed) != 0 {
+		t.Errorf("specs are garbage collected in dry run. summary=%+v", summary)
+	}
+	for _, spec := range specs {
+		if _, err := service.ReplicationSpec(spec.Id); err != nil {
+			t.Errorf("spec %v is removed from cache in dry run", spec.Id)
+		}
+	}
+	source_lookups = 0
+	target_cluster_lookups = make(map[string]int)
+	target_bucket_lookups = 0
+
+	summary = metadata.NewReplicationSpecGCSummary()
+	service.validateAndGCBatch(specs, memoizeSpecValidationLookups(lookups), false /*dryRun*/, summary)
+
+	// each source bucket, remote cluster and target bucket is looked up once
+	if source_lookups != 4 {
+		t.Errorf("source buckets were looked up %v times, expected 4", source_lookups)
+	}
+	for targetClusterUUID, count := range target_cluster_lookups {
+		if count != 1 {
+			t.Errorf("remote cluster %v was looked up %v times, expected once", targetClusterUUID, count)
+		}
+	}
+	if len(target_cluster_lookups) != 4 {
+		t.Errorf("%v remote clusters were looked up, expected 4", len(target_cluster_lookups))
+	}
+	if target_bucket_lookups != 4 {
+		t.Errorf("target buckets were looked up %v times, expected 4", target_bucket_lookups)
+	}
+
+	expectedGC := []string{specs[3].Id, specs[5].Id}
+	if len(summary.GarbageCollected) != len(expectedGC) {
+		t.Errorf("garbage collected specs are %v, expected %v", summary.GarbageCollected, expectedGC)
+	}
+	for _, specId := range expectedGC {
+		if summary.GarbageCollected[specId] == nil {
+			t.Errorf("spec %v was not garbage collected", specId)
+		}
+		if _, err := service.ReplicationSpec(specId); err == nil {
+			t.Errorf("garbage collected spec %v is still in cache", specId)
+		}
+	}
+	if len(summary.Skipped) != 1 || summary.Skipped[specs[6].Id] == nil {
+		t.Errorf("skipped specs are %v, expected %v", summary.Skipped, specs[6].Id)
+	}
+	if len(summary.GCFailed) != 0 {
+		t.Errorf("specs that failed to be garbage collected are %v, expected none", summary.GCFailed)
+	}
+
+	// nothing is validated while garbage collection is suspended
+	service.SuspendGC(time.Minute)
+	summary = service.ValidateAndGCBatch(specs[:1])
+	if summary.Skipped[specs[0].Id] != GCSuspendedError || len(summary.GarbageCollected) != 0 {
+		t.Errorf("summary of batch while garbage collection is suspended is %+v", summary)
+	}
+}
+
+func TestValidateConflictResolutionType(t *testing.T) {
+	service := newTestReplicationSpecService(0)
+	for _, allowMismatch := range []bool{false, true} {
+		errorMap := make(map[string]error)
+		warningMap := make(map[string]error)
+		if !service.validateConflictResolutionType("source", "target", base.ConflictResolutionType_Lww, base.ConflictResolutionType_Lww, allowMismatch, errorMap, warningMap) {
+			t.Errorf("replication between buckets with the same conflict resolution type is rejected, allowMismatch=%v", allowMismatch)
+		}
+		if len(errorMap) != 0 || len(warningMap) != 0 {
+			t.Errorf("unexpected errors %v and warnings %v, allowMismatch=%v", errorMap, warningMap, allowMismatch)
+		}
+	}
+
+	errorMap := make(map[string]error)
+	warningMap := make(map[string]error)
+	if service.validateConflictResolutionType("source", "target", base.ConflictResolutionType_Seqno, base.ConflictResolutionType_Lww, false, errorMap, warningMap) {
+		t.Errorf("replication between buckets with different conflict resolution types is not rejected")
+	}
+	err := errorMap[base.ToBucket]
+	if err == nil || !strings.Contains(err.Error(), base.ConflictResolutionType_Seqno) || !strings.Contains(err.Error(), base.ConflictResolutionType_Lww) {
+		t.Errorf("expected error with both conflict resolution types, got %v", err)
+	}
+	if len(warningMap) != 0 {
+		t.Errorf("unexpected warnings %v", warningMap)
+	}
+
+	// the override downgrades the mismatch to a warning
+	errorMap = make(map[string]error)
+	warningMap = make(map[string]error)
+	if !service.validateConflictResolutionType("source", "target", base.ConflictResolutionType_Seqno, base.ConflictResolutionType_Lww, true, errorMap, warningMap) {
+		t.Errorf("replication between buckets with different conflict resolution types is rejected when mismatch is allowed")
+	}
+	if len(errorMap) != 0 {
+		t.Errorf("unexpected errors %v", errorMap)
+	}
+	if warningMap[base.ToBucket] == nil {
+		t.Errorf("expected warning for conflict resolution type mismatch")
+	}
+}
+
+func TestGetCacheStats(t *testing.T) {
+	service := newTestReplicationSpecService(3)
+	specs := allReplicationSpecsByCopy(service)
+
+	stats := service.GetCacheStats()
+	expectedSize := 0
+	for _, spec := range specs {
+		value, err := json.Marshal(spec)
+		if err != nil {
+			t.Fatalf("failed to marshal spec. err=%v", err)
+		}
+		expectedSize += len(value)
+	}
+	if stats.NumOfLiveEntries != 3 || stats.NumOfDeletedEntries != 0 || stats.MarshaledSize != expectedSize {
+		t.Errorf("cache stats are %+v, expected 3 live entries of %v bytes", stats, expectedSize)
+	}
+
+	// deleted spec is kept in cache for its derived object
+	deletedSpec := newTestReplicationSpec(0, 0)
+	if err := service.SetDerivedObj(deletedSpec.Id, "derivedObj"); err != nil {
+		t.Fatalf("failed to set derived obj. err=%v", err)
+	}
+	service.removeSpecFromCache(deletedSpec.Id)
+	value, _ := json.Marshal(deletedSpec)
+	stats = service.GetCacheStats()
+	if stats.NumOfLiveEntries != 2 || stats.NumOfDeletedEntries != 1 || stats.MarshaledSize != expectedSize-len(value) {
+		t.Errorf("cache stats are %+v, expected 2 live entries of %v bytes and 1 deleted entry", stats, expectedSize-len(value))
+	}
+}
+
+func TestAllReplicationSpecIdsForTarget(t *testing.T) {
+	service := newTestReplicationSpecService(0)
+	targets := [][2]string{{"cluster1", "bucket1"}, {"cluster1", "bucket1"}, {"cluster1", "bucket2"}, {"cluster2", "bucket1"}, {"cluster1", "bucket2"}}
+	specIds := make([]string, len(targets))
+	for index, target := range targets {
+		spec := metadata.NewReplicationSpecification(fmt.Sprintf("source%v", index), "", target[0], target[1], "")
+		service.cacheSpec(service.cache, spec.Id, spec)
+		specIds[index] = spec.Id
+	}
+	// deleted spec that is still cached for its derived object
+	service.removeSpecFromCache(specIds[4])
+
+	sortedIds := func(ids []string, err error) []string {
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+	expected := []string{specIds[0], specIds[1], specIds[2]}
+	sort.Strings(expected)
+	if ids := sortedIds(service.AllReplicationSpecIdsForTargetCluster("cluster1")); !reflect.DeepEqual(ids, expected) {
+		t.Errorf("replications to cluster1 are %v, expected %v", ids, expected)
+	}
+	if ids := sortedIds(service.AllReplicationSpecIdsForTargetBucket("cluster1", "bucket2")); !reflect.DeepEqual(ids, []string{specIds[2]}) {
+		t.Errorf("replications to bucket2 on cluster1 are %v, expected %v", ids, []string{specIds[2]})
+	}
+	if ids := sortedIds(service.AllReplicationSpecIdsForTargetCluster("cluster3")); len(ids) != 0 {
+		t.Errorf("replications to cluster3 are %v, expected none", ids)
+	}
+}
+
+func TestCorruptedSpecRecovery(t *testing.T) {
+	service := newTestReplicationSpecService(2)
+	meta_svc := newTestMetadataSvc()
+	service.metadata_svc = meta_svc
+
+	spec := newTestReplicationSpec(0, 0)
+	key := getKeyFromReplicationId(spec.Id)
+	path := base.KeyPartsDelimiter + key
+	if err := service.ReplicationSpecServiceCallback(path, []byte(`{"id":`), 1); err == nil {
+		t.Fatalf("expected error for malformed value")
+	}
+	if specIds := service.GetCorruptedSpecIds(); !reflect.DeepEqual(specIds, []string{spec.Id}) {
+		t.Fatalf("corrupted specs are %v, expected %v", specIds, []string{spec.Id})
+	}
+
+	// the stale spec in cache is not returned while the stored spec is still corrupted
+	meta_svc.entries[key] = []byte(`{"id":`)
+	if _, err := service.ReplicationSpec(spec.Id); err == nil || !strings.HasPrefix(err.Error(), ReplicationSpecCorruptedErrorMessage) {
+		t.Errorf("expected corrupted spec error, got %v", err)
+	}
+
+	updatedSpec := spec.Clone()
+	updatedSpec.Settings.Active = false
+	value, _ := json.Marshal(updatedSpec)
+	meta_svc.entries[key] = value
+	recoveredSpec, err := service.ReplicationSpec(spec.Id)
+	if err != nil {
+		t.Fatalf("failed to recover corrupted spec. err=%v", err)
+	}
+	if recoveredSpec.Settings.Active {
+		t.Errorf("stale spec is returned after recovery")
+	}
+	if specIds := service.GetCorruptedSpecIds(); len(specIds) != 0 {
+		t.Errorf("corrupted specs are %v after recovery, expected none", specIds)
+	}
+
+	// a valid value from metakv clears the mark as well
+	otherSpec := newTestReplicationSpec(1, 0)
+	otherPath := base.KeyPartsDelimiter + getKeyFromReplicationId(otherSpec.Id)
+	service.ReplicationSpecServiceCallback(otherPath, []byte("not json"), 2)
+	value, _ = json.Marshal(otherSpec)
+	if err := service.ReplicationSpecServiceCallback(otherPath, value, 3); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if specIds := service.GetCorruptedSpecIds(); len(specIds) != 0 {
+		t.Errorf("corrupted specs are %v, expected none", specIds)
+	}
+}
+
+func TestAllReplicationSpecIdsForBucketWithUnderscores(t *testing.T) {
+	service := newTestReplicationSpecService(0)
+	specIds := make(map[string]string)
+	for _, sourceBucketName := range []string{"bucket", "my_bucket", "my_bucket_1", "bucket_my"} {
+		spec := metadata.NewReplicationSpecification(sourceBucketName, "", "targetClusterUUID", "my_bucket", "")
+		service.cacheSpec(service.cache, spec.Id, spec)
+		specIds[sourceBucketName] = spec.Id
+	}
+	service.refreshSpecsSnapshot()
+
+	for sourceBucketName, specId := range specIds {
+		repIds, err := service.AllReplicationSpecIdsForBucket(sourceBucketName)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if !reflect.DeepEqual(repIds, []string{specId}) {
+			t.Errorf("replications for bucket %v are %v, expected %v", sourceBucketName, repIds, []string{specId})
+		}
+	}
+}
+
+func TestUpgradeSpecSettingsOnLoad(t *testing.T) {
+	service := newTestReplicationSpecService(0)
+	service.metadata_svc = newTestMetadataSvc()
+
+	// a spec persisted before settings versioning and the settings introduced since then
+	replicationId := metadata.ReplicationId("source", "targetClusterUUID", "target")
+	v0Value := []byte(`{"id":"` + replicationId + `","sourceBucketName":"source","targetClusterUUID":"targetClusterUUID",` +
+		`"targetBucketName":"target","replicationSettings":{"type":"xmem","active":true,"batch_count":500,` +
+		`"batch_size":2048,"replicate_ops":"deletions_only"}}`)
+	spec, err := constructReplicationSpec(v0Value, nil)
+	if err != nil {
+		t.Fatalf("failed to construct spec. err=%v", err)
+	}
+	if spec.SettingsVersion != metadata.CurrentSpecSettingsVersion {
+		t.Errorf("settings version is %v, expected %v", spec.SettingsVersion, metadata.CurrentSpecSettingsVersion)
+	}
+	defaults := metadata.DefaultSettings()
+	if spec.Settings.TargetDurability != defaults.TargetDurability || spec.Settings.CompressionType != defaults.CompressionType ||
+		spec.Settings.BalanceMode != defaults.BalanceMode || spec.Settings.TargetNodeAllowlist == nil {
+		t.Errorf("defaults of new settings are not filled in, settings=%v", spec.Settings)
+	}
+	// settings present in the persisted spec are kept
+	if spec.Settings.ReplicateOps != metadata.ReplicateOpsDeletionsOnly {
+		t.Errorf("replicate_ops is %v, expected %v", spec.Settings.ReplicateOps, metadata.ReplicateOpsDeletionsOnly)
+	}
+
+	// the upgraded form is persisted when the spec is updated
+	old := &metadata.ReplicationSpecification{}
+	if err = json.Unmarshal(v0Value, old); err != nil {
+		t.Fatalf("failed to unmarshal spec. err=%v", err)
+	}
+	if err = service.SetReplicationSpec(old); err != nil {
+		t.Fatalf("failed to set spec. err=%v", err)
+	}
+	persisted := &metadata.ReplicationSpecification{}
+	if err = json.Unmarshal(service.metadata_svc.(*testMetadataSvc).entries[getKeyFromReplicationId(replicationId)], persisted); err != nil {
+		t.Fatalf("failed to unmarshal persisted spec. err=%v", err)
+	}
+	if persisted.SettingsVersion != metadata.CurrentSpecSettingsVersion || persisted.Settings.BalanceMode != defaults.BalanceMode {
+		t.Errorf("persisted spec is not upgraded, version=%v, settings=%v", persisted.SettingsVersion, persisted.Settings)
+	}
+}
+
+// ui log service that keeps the messages written
+type testUILogSvc struct {
+	messages []string
+}
+
+func (uilog_svc *testUILogSvc) Write(message string) {
+	uilog_svc.messages = append(uilog_svc.messages, message)
+}
+
+func TestAddPausedReplicationSpec(t *testing.T) {
+	service := newTestReplicationSpecService(0)
+	service.metadata_svc = newTestMetadataSvc()
+	service.remote_cluster_svc = &testRemoteClusterSvc{names: map[string]string{"targetClusterUUID": "remote"}}
+	uilog_svc := &testUILogSvc{}
+	service.uilog_svc = uilog_svc
+
+	spec := newTestReplicationSpec(0, 0)
+	spec.Settings.Active = false
+	if err := service.AddReplicationSpec(spec); err != nil {
+		t.Fatalf("failed to add spec. err=%v", err)
+	}
+	expectedMessage := "Replication from bucket \"source0\" to bucket \"target0\" on cluster \"remote\" created (paused)."
+	if len(uilog_svc.messages) != 1 || uilog_svc.messages[0] != expectedMessage {
+		t.Errorf("ui log messages are %v, expected %q", uilog_svc.messages, expectedMessage)
+	}
+
+	pausedSpecs, err := service.AllPausedReplicationSpecs()
+	if err != nil || len(pausedSpecs) != 1 || pausedSpecs[spec.Id] == nil {
+		t.Errorf("paused specs are %v, err=%v, expected %v only", pausedSpecs, err, spec.Id)
+	}
+	if activeSpecs, err := service.AllActiveReplicationSpecs(); err != nil || len(activeSpecs) != 0 {
+		t.Errorf("active specs are %v, err=%v, expected none", activeSpecs, err)
+	}
+
+	// resuming the spec moves it to the active set
+	spec, _ = service.ReplicationSpec(spec.Id)
+	spec.Settings.Active = true
+	if err := service.SetReplicationSpec(spec); err != nil {
+		t.Fatalf("failed to set spec. err=%v", err)
+	}
+	activeSpecs, err := service.AllActiveReplicationSpecs()
+	if err != nil || len(activeSpecs) != 1 || activeSpecs[spec.Id] == nil {
+		t.Errorf("active specs are %v, err=%v, expected %v only", activeSpecs, err, spec.Id)
+	}
+	if pausedSpecs, err := service.AllPausedReplicationSpecs(); err != nil || len(pausedSpecs) != 0 {
+		t.Errorf("paused specs are %v, err=%v, expected none", pausedSpecs, err)
+	}
+}
+
+func TestAllReplicationSpecIdsSorted(t *testing.T) {
+	service := newTestReplicationSpecService(20)
+
+	first := service.AllReplicationSpecIdsSorted()
+	if len(first) != 20 || !sort.StringsAreSorted(first) {
+		t.Fatalf("replication ids are %v, expected 20 ids in lexicographic order", first)
+	}
+	for i := 0; i < 10; i++ {
+		if ids := service.AllReplicationSpecIdsSorted(); !reflect.DeepEqual(ids, first) {
+			t.Fatalf("replication ids are %v, expected the same order as %v", ids, first)
+		}
+	}
+
+	// ids of replications from the same source bucket follow the same order
+	for i := 0; i < 3; i++ {
+		spec := metadata.NewReplicationSpecification("source0", "", fmt.Sprintf("targetClusterUUID%v", 2-i), "target", "")
+		service.cacheSpec(service.cache, spec.Id, spec)
+	}
+	service.refreshSpecsSnapshot()
+	repIds, err := service.AllReplicationSpecIdsForBucket("source0")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(repIds) != 4 || !sort.StringsAreSorted(repIds) {
+		t.Errorf("replications for bucket source0 are %v, expected 4 ids in lexicographic order", repIds)
+	}
+}
+
+func TestRefreshCache(t *testing.T) {
+	service := newTestReplicationSpecService(0)
+	meta_svc := newTestMetadataSvc()
+	meta_svc.revs = make(map[string]interface{})
+	service.metadata_svc = meta_svc
+
+	persist := func(spec *metadata.ReplicationSpecification, rev int) {
+		value, err := json.Marshal(spec)
+		if err != nil {
+			t.Fatalf("failed to marshal spec. err=%v", err)
+		}
+		key := getKeyFromReplicationId(spec.Id)
+		meta_svc.entries[key] = value
+		meta_svc.revs[key] = rev
+	}
+	refresh := func(expectedAdded, expectedUpdated, expectedRemoved int) {
+		added, updated, removed, err := service.RefreshCache()
+		if err != nil {
+			t.Fatalf("failed to refresh cache. err=%v", err)
+		}
+		if added != expectedAdded || updated != expectedUpdated || removed != expectedRemoved {
+			t.Errorf("refresh added %v, updated %v and removed %v specs, expected %v, %v and %v",
+				added, updated, removed, expectedAdded, expectedUpdated, expectedRemoved)
+		}
+	}
+
+	specs := make([]*metadata.ReplicationSpecification, 4)
+	for i := range specs {
+		specs[i] = newTestReplicationSpec(i, 0)
+	}
+	for _, spec := range specs[:3] {
+		persist(spec, 1)
+	}
+	refresh(3, 0, 0)
+	// nothing has changed
+	refresh(0, 0, 0)
+
+	specs[0].Settings.BatchCount = 100
+	persist(specs[0], 2)
+	delete(meta_svc.entries, getKeyFromReplicationId(specs[1].Id))
+	persist(specs[3], 1)
+	refresh(1, 1, 1)
+
+	if spec, err := service.ReplicationSpec(specs[0].Id); err != nil || spec.Settings.BatchCount != 100 {
+		t.Errorf("updated spec in cache is %v, err=%v, expected batch count of 100", spec, err)
+	}
+	if _, err := service.ReplicationSpec(specs[1].Id); err == nil {
+		t.Errorf("spec removed from metadata store is still in cache")
+	}
+	if ids := service.AllReplicationSpecIdsSorted(); len(ids) != 3 {
+		t.Errorf("specs in cache are %v, expected 3", ids)
+	}
+
+	// a malformed spec does not stop the refresh, and is marked as corrupted
+	meta_svc.entries[getKeyFromReplicationId(specs[2].Id)] = []byte("{")
+	meta_svc.revs[getKeyFromReplicationId(specs[2].Id)] = 2
+	specs[3].Settings.BatchCount = 200
+	persist(specs[3], 2)
+	added, updated, removed, err := service.RefreshCache()
+	if err == nil || added != 0 || updated != 1 || removed != 0 {
+		t.Errorf("refresh returned (%v, %v, %v, %v), expected one update and error", added, updated, removed, err)
+	}
+	if ids := service.GetCorruptedSpecIds(); !reflect.DeepEqual(ids, []string{specs[2].Id}) {
+		t.Errorf("corrupted specs are %v, expected %v", ids, []string{specs[2].Id})
+	}
+}
+
+// metadata service that runs hooks after catalog or entries are read, e.g., to change specs while a cache refresh
+// is in progress
+type catalogHookMetadataSvc struct {
+	*testMetadataSvc
+	after_catalog_read func()
+	after_get          func(key string)
+}
+
+func (meta_svc *catalogHookMetadataSvc) Get(key string) ([]byte, interface{}, error) {
+	value, rev, err := meta_svc.testMetadataSvc.Get(key)
+	if meta_svc.after_get != nil {
+		meta_svc.after_get(key)
+	}
+	return value, rev, err
+}
+
+func (meta_svc *catalogHookMetadataSvc) GetAllMetadataFromCatalog(catalogKey string) ([]*service_def.MetadataEntry, error) {
+	entries, err := meta_svc.testMetadataSvc.GetAllMetadataFromCatalog(catalogKey)
+	if meta_svc.after_catalog_read != nil {
+		meta_svc.after_catalog_read()
+	}
+	return entries, err
+}
+
+func TestRefreshCacheWithConcurrentChanges(t *testing.T) {
+	service := newTestReplicationSpecService(0)
+	meta_svc := &catalogHookMetadataSvc{testMetadataSvc: newTestMetadataSvc()}
+	meta_svc.revs = make(map[string]interface{})
+	service.metadata_svc = meta_svc
+
+	specs := make([]*metadata.ReplicationSpecification, 3)
+	for i := range specs {
+		specs[i] = newTestReplicationSpec(i, 1)
+		value, _ := json.Marshal(specs[i])
+		meta_svc.entries[getKeyFromReplicationId(specs[i].Id)] = value
+		meta_svc.revs[getKeyFromReplicationId(specs[i].Id)] = 1
+	}
+	// specs[2] has been deleted from metadata store before refresh, and is only in cache
+	service.updateCache(specs[2].Id, specs[2])
+	delete(meta_svc.entries, getKeyFromReplicationId(specs[2].Id))
+
+	meta_svc.after_catalog_read = func() {
+		// specs[0] is deleted, and specs[2] is recreated, with metakv callbacks updating the cache,
+		// after catalog is read but before the cache is updated by the refresh
+		delete(meta_svc.entries, getKeyFromReplicationId(specs[0].Id))
+		service.updateCache(specs[0].Id, nil)
+		recreatedSpec := newTestReplicationSpec(2, 2)
+		value, _ := json.Marshal(recreatedSpec)
+		meta_svc.entries[getKeyFromReplicationId(recreatedSpec.Id)] = value
+		meta_svc.revs[getKeyFromReplicationId(recreatedSpec.Id)] = 2
+		service.updateCache(recreatedSpec.Id, recreatedSpec)
+	}
+	// specs[1] is deleted, with its metakv callback updating the cache, after the refresh has checked that
+	// it is current in metadata store but before the refresh updates the cache
+	meta_svc.after_get = func(key string) {
+		if key != getKeyFromReplicationId(specs[1].Id) {
+			return
+		}
+		delete(meta_svc.entries, key)
+		service.updateCache(specs[1].Id, nil)
+	}
+	added, updated, removed, err := service.RefreshCache()
+	if err != nil || added != 0 || updated != 0 || removed != 0 {
+		t.Errorf("refresh returned (%v, %v, %v, %v), expected no changes", added, updated, removed, err)
+	}
+	if _, err := service.ReplicationSpec(specs[1].Id); err == nil {
+		t.Errorf("spec deleted after it was read by refresh has been put into cache")
+	}
+	if _, err := service.ReplicationSpec(specs[0].Id); err == nil {
+		t.Errorf("spec deleted during refresh has been put back into cache")
+	}
+	if spec, err := service.ReplicationSpec(specs[2].Id); err != nil || spec.Revision != 2 {
+		t.Errorf("spec recreated during refresh is %v, err=%v, expected the recreated spec in cache", spec, err)
+	}
+}
+
+func TestReplicationValidationErrorTypes(t *testing.T) {
+	service := newTestReplicationSpecService(0)
+	service.metadata_svc = newTestMetadataSvc()
+
+	spec := newTestReplicationSpec(0, 0)
+	if err := service.AddReplicationSpec(spec); err != nil {
+		t.Fatalf("failed to add spec. err=%v", err)
+	}
+	err := service.AddReplicationSpec(newTestReplicationSpec(0, 0))
+	if existsErr, ok := err.(*SpecAlreadyExistsError); !ok || existsErr.ReplicationId != spec.Id {
+		t.Errorf("adding existing spec returned %v, expected already exists error for %v", err, spec.Id)
+	}
+	// messages are shown on UI and stay the same
+	if err == nil || err.Error() != ReplicationSpecAlreadyExistErrorMessage || !service.IsReplicationValidationError(err) {
+		t.Errorf("unexpected already exists error %v", err)
+	}
+
+	if _, err = service.ReplicationSpec("nonExistingId"); !isSpecNotFoundError(err) {
+		t.Errorf("getting non-existing spec returned %v, expected spec not found error", err)
+	}
+	if _, err = service.DelReplicationSpec("nonExistingId"); !isSpecNotFoundError(err) {
+		t.Errorf("deleting non-existing spec returned %v, expected spec not found error", err)
+	}
+	if err == nil || err.Error() != ReplicationSpecNotFoundErrorMessage || !service.IsReplicationValidationError(err) {
+		t.Errorf("unexpected spec not found error %v", err)
+	}
+
+	// errors are no longer recognized by their messages
+	if service.IsReplicationValidationError(errors.New(ReplicationSpecNotFoundErrorMessage + " for replication")) {
+		t.Errorf("untyped error is recognized as replication validation error")
+	}
+}
+
+func isSpecNotFoundError(err error) bool {
+	_, ok := err.(*SpecNotFoundError)
+	return ok
+}
+
+func TestGetReplicationSpecRevision(t *testing.T) {
+	service := newTestReplicationSpecService(0)
+	meta_svc := newTestMetadataSvc()
+	meta_svc.revs = make(map[string]interface{})
+	service.metadata_svc = meta_svc
+
+	spec := newTestReplicationSpec(0, 0)
+	if err := service.AddReplicationSpec(spec); err != nil {
+		t.Fatalf("failed to add spec. err=%v", err)
+	}
+
+	// the revision is refreshed from metadata store when the spec is updated
+	meta_svc.revs[getKeyFromReplicationId(spec.Id)] = 2
+	spec.Settings.BatchCount = 100
+	if err := service.SetReplicationSpec(spec); err != nil {
+		t.Fatalf("failed to set spec. err=%v", err)
+	}
+	if rev, err := service.GetReplicationSpecRevision(spec.Id); err != nil || rev != 2 {
+		t.Errorf("got revision %v and err=%v, expected 2", rev, err)
+	}
+
+	// deleted specs are kept in cache with nil spec until their derived objects are cleared
+	if _, err := service.DelReplicationSpec(spec.Id); err != nil {
+		t.Fatalf("failed to delete spec. err=%v", err)
+	}
+	if _, err := service.GetReplicationSpecRevision(spec.Id); !isSpecNotFoundError(err) {
+		t.Errorf("got err=%v for deleted spec, expected spec not found error", err)
+	}
+}
+
+// topology service that knows the connection string of the local node only
+type testXDCRTopologySvc struct {
+	service_def.XDCRCompTopologySvc
+}
+
+func (topology_svc *testXDCRTopologySvc) MyConnectionStr() (string, error) {
+	return "127.0.0.1:8091", nil
+}
+
+// cluster info service that knows the uuids of buckets only
+type testClusterInfoSvc struct {
+	service_def.ClusterInfoSvc
+	bucketUUIDs map[string]string
+}
+
+func (cluster_info_svc *testClusterInfoSvc) GetBucketUUID(clusterConnInfoProvider base.ClusterConnectionInfoProvider, bucketName string) (string, bool) {
+	bucketUUID, ok := cluster_info_svc.bucketUUIDs[bucketName]
+	return bucketUUID, ok
+}
+
+// remote cluster service that knows a single remote cluster
+type testSingleRemoteClusterSvc struct {
+	service_def.RemoteClusterSvc
+	ref *metadata.RemoteClusterReference
+}
+
+func (remote_cluster_svc *testSingleRemoteClusterSvc) RemoteClusterByRefName(refName string, refresh bool) (*metadata.RemoteClusterReference, error) {
+	if refName != remote_cluster_svc.ref.Name {
+		return nil, service_def.MetadataNotFoundErr
+	}
+	return remote_cluster_svc.ref, nil
+}
+
+// rest calls to the remote cluster fall back to creating new clients
+func (remote_cluster_svc *testSingleRemoteClusterSvc) GetHttpClient(ref *metadata.RemoteClusterReference) (*http.Client, error) {
+	return nil, errors.New("no pooled http client")
+}
+
+func (remote_cluster_svc *testSingleRemoteClusterSvc) RemoteClusterByUuid(uuid string, refresh bool) (*metadata.RemoteClusterReference, error) {
+	if uuid != remote_cluster_svc.ref.Uuid {
+		return nil, service_def.MetadataNotFoundErr
+	}
+	return remote_cluster_svc.ref, nil
+}
+
+func TestSpecValidationLookupsUseClusterInfo(t *testing.T) {
+	service := newTestReplicationSpecService(0)
+	service.xdcr_comp_topology_svc = &testXDCRTopologySvc{}
+	service.cluster_info_svc = &testClusterInfoSvc{bucketUUIDs: map[string]string{"source": "sourceUUID", "target": "targetUUID"}}
+	ref := &metadata.RemoteClusterReference{Uuid: "targetClusterUUID", HostName: "127.0.0.1:9000"}
+	service.remote_cluster_svc = &testSingleRemoteClusterSvc{ref: ref}
+
+	lookups := service.newSpecValidationLookups()
+	if bucketUUID, err := lookups.sourceBucketUUID("source"); err != nil || bucketUUID != "sourceUUID" {
+		t.Errorf("source bucket uuid is %v, err=%v, expected the uuid from cluster info service", bucketUUID, err)
+	}
+	targetCluster, invalidReason, err := lookups.targetCluster("targetClusterUUID")
+	if err != nil || invalidReason != "" {
+		t.Fatalf("unexpected error resolving target cluster. invalidReason=%v, err=%v", invalidReason, err)
+	}
+	if bucketUUID, err := lookups.targetBucketUUID(targetCluster, "target"); err != nil || bucketUUID != "targetUUID" {
+		t.Errorf("target bucket uuid is %v, err=%v, expected the uuid from cluster info service", bucketUUID, err)
+	}
+}
+
+func TestConstructNewReplicationSpecWithSettings(t *testing.T) {
+	service := newTestReplicationSpecService(0)
+	service.xdcr_comp_topology_svc = &testXDCRTopologySvc{}
+	service.cluster_info_svc = &testClusterInfoSvc{bucketUUIDs: map[string]string{"source": "sourceUUID", "target": "targetUUID"}}
+	service.remote_cluster_svc = &testSingleRemoteClusterSvc{ref: &metadata.RemoteClusterReference{Uuid: "targetClusterUUID", HostName: "127.0.0.1:9000"}}
+
+	settings := map[string]interface{}{metadata.BatchCount: 800, metadata.FilterExpression: "app1:.*"}
+	spec, err := service.ConstructNewReplicationSpecWithSettings("source", "targetClusterUUID", "target", settings)
+	if err != nil {
+		t.Fatalf("failed to construct spec. err=%v", err)
+	}
+	if spec.Id != metadata.ReplicationId("source", "targetClusterUUID", "target") {
+		t.Errorf("id of spec is %v", spec.Id)
+	}
+	if spec.Settings.BatchCount != 800 || spec.Settings.FilterExpression != "app1:.*" {
+		t.Errorf("settings of spec are %+v, expected batch count and filter expression to be set", spec.Settings)
+	}
+	// settings that are not given keep their default values
+	if spec.Settings.CheckpointInterval != metadata.DefaultSettings().CheckpointInterval {
+		t.Errorf("checkpoint interval of spec is %v, expected default value", spec.Settings.CheckpointInterval)
+	}
+
+	for _, invalidSettings := range []map[string]interface{}{{"unknownKey": 1}, {metadata.BatchCount: "800"}} {
+		if _, err = service.ConstructNewReplicationSpecWithSettings("source", "targetClusterUUID", "target", invalidSettings); err == nil {
+			t.Errorf("expected error for invalid settings %v", invalidSettings)
+		}
+	}
+}
+
+// cluster info service that counts the retrievals of cluster compatibility
+type countingClusterInfoSvc struct {
+	service_def.ClusterInfoSvc
+	compatible      bool
+	err             error
+	num_of_requests int
+}
+
+func (cluster_info_svc *countingClusterInfoSvc) IsClusterCompatible(clusterConnInfoProvider base.ClusterConnectionInfoProvider, version []int) (bool, error) {
+	cluster_info_svc.num_of_requests++
+	return cluster_info_svc.compatible, cluster_info_svc.err
+}
+
+func TestClusterCompatibilityCache(t *testing.T) {
+	oldExpiry := ClusterCompatibilityCacheExpiry
+	defer func() { ClusterCompatibilityCacheExpiry = oldExpiry }()
+	ClusterCompatibilityCacheExpiry = time.Minute
+
+	service := newTestReplicationSpecService(0)
+	cluster_info_svc := &countingClusterInfoSvc{compatible: true}
+	service.cluster_info_svc = cluster_info_svc
+	ref1 := &metadata.RemoteClusterReference{Uuid: "cluster1"}
+	ref2 := &metadata.RemoteClusterReference{Uuid: "cluster2"}
+
+	isCompatible := func(ref *metadata.RemoteClusterReference, version []int, expectedRequests int) {
+		compatible, err := service.isClusterCompatible(ref, version)
+		if err != nil || !compatible {
+			t.Errorf("compatibility of %v with %v is %v, err=%v", ref.Uuid, version, compatible, err)
+		}
+		if cluster_info_svc.num_of_requests != expectedRequests {
+			t.Errorf("compatibility has been retrieved %v times, expected %v", cluster_info_svc.num_of_requests, expectedRequests)
+		}
+	}
+
+	// results are cached per cluster and version
+	isCompatible(ref1, []int{2, 2}, 1)
+	isCompatible(ref1, []int{2, 2}, 1)
+	isCompatible(ref1, []int{4, 0}, 2)
+	isCompatible(ref2, []int{2, 2}, 3)
+
+	// invalidation affects the given cluster only
+	service.InvalidateClusterCompatibility("cluster1")
+	isCompatible(ref2, []int{2, 2}, 3)
+	isCompatible(ref1, []int{2, 2}, 4)
+
+	// errors are not cached
+	service.InvalidateClusterCompatibility("cluster1")
+	cluster_info_svc.err = errors.New("cluster is not reachable")
+	if _, err := service.isClusterCompatible(ref1, []int{2, 2}); err == nil {
+		t.Errorf("expected error retrieving compatibility")
+	}
+	cluster_info_svc.err = nil
+	isCompatible(ref1, []int{2, 2}, 6)
+
+	// expired results are retrieved again
+	ClusterCompatibilityCacheExpiry = 0
+	isCompatible(ref1, []int{2, 2}, 7)
+	isCompatible(ref1, []int{2, 2}, 8)
+}
+
+func TestHasReplicationSpec(t *testing.T) {
+	service := newTestReplicationSpecService(2)
+	spec := newTestReplicationSpec(0, 0)
+	if !service.HasReplicationSpec(spec.Id) {
+		t.Errorf("spec %v is not found in cache", spec.Id)
+	}
+	if service.HasReplicationSpec("nonExistingId") {
+		t.Errorf("non-existing spec is found in cache")
+	}
+
+	// specs that are deleted but still cached for their derived objects do not exist
+	service.removeSpecFromCache(spec.Id)
+	if service.HasReplicationSpec(spec.Id) {
+		t.Errorf("deleted spec %v is found in cache", spec.Id)
+	}
+}
+
+func BenchmarkHasReplicationSpecMissing(b *testing.B) {
+	service := newTestReplicationSpecService(100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		service.HasReplicationSpec("nonExistingId")
+	}
+}
+
+func BenchmarkReplicationSpecMissing(b *testing.B) {
+	service := newTestReplicationSpecService(100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		service.replicationSpec("nonExistingId")
+	}
+}
+
+func TestRebindOnRecreate(t *testing.T) {
+	service := newTestReplicationSpecService(0)
+	meta_svc := newTestMetadataSvc()
+	service.metadata_svc = meta_svc
+	service.remote_cluster_svc = &testRemoteClusterSvc{names: map[string]string{"targetClusterUUID": "remote"}}
+	uilog_svc := &testUILogSvc{}
+	service.uilog_svc = uilog_svc
+
+	specs := make([]*metadata.ReplicationSpecification, 2)
+	for index := range specs {
+		spec := newTestReplicationSpec(index, 0)
+		spec.SourceBucketUUID = "oldSourceUUID"
+		spec.TargetBucketUUID = "oldTargetUUID"
+		value, _ := json.Marshal(spec)
+		meta_svc.entries[getKeyFromReplicationId(spec.Id)] = value
+		service.cacheSpec(service.cache, spec.Id, spec)
+		specs[index] = spec
+	}
+	specs[1].Settings.RebindOnRecreate = true
+	service.refreshSpecsSnapshot()
+
+	// both buckets have been deleted and recreated
+	lookups := &specValidationLookups{
+		sourceBucketUUID: func(bucketName string) (string, error) {
+			return "newSourceUUID", nil
+		},
+		targetCluster: func(targetClusterUUID string) (*remoteClusterConnInfo, string, error) {
+			return &remoteClusterConnInfo{connStr: targetClusterUUID}, "", nil
+		},
+		targetBucketUUID: func(targetCluster *remoteClusterConnInfo, bucketName string) (string, error) {
+			return "newTargetUUID", nil
+		},
+	}
+
+	// specs are garbage collected by default
+	if err, detail_err := service.validateExistingReplicationSpec(specs[0], lookups, false /*dryRun*/); err != InvalidReplicationSpecError {
+		t.Errorf("validation of spec without rebind_on_recreate returned %v, %v, expected InvalidReplicationSpecError", err, detail_err)
+	}
+
+	// dry runs do not rebind specs
+	if err, detail_err := service.validateExistingReplicationSpec(specs[1], lookups, true /*dryRun*/); err != nil || detail_err != nil {
+		t.Errorf("dry run validation of spec with rebind_on_recreate returned %v, %v", err, detail_err)
+	}
+	if spec, _ := service.ReplicationSpec(specs[1].Id); spec.SourceBucketUUID != "oldSourceUUID" || len(uilog_svc.messages) != 0 {
+		t.Errorf("spec has been rebound in dry run")
+	}
+
+	if err, detail_err := service.validateExistingReplicationSpec(specs[1], lookups, false /*dryRun*/); err != nil || detail_err != nil {
+		t.Fatalf("validation of spec with rebind_on_recreate returned %v, %v", err, detail_err)
+	}
+	// the spec in cache is replaced rather than modified in place
+	if specs[1].SourceBucketUUID != "oldSourceUUID" || specs[1].TargetBucketUUID != "oldTargetUUID" {
+		t.Errorf("spec passed in has been modified to have bucket uuids %v and %v", specs[1].SourceBucketUUID, specs[1].TargetBucketUUID)
+	}
+	rebound, err := service.ReplicationSpec(specs[1].Id)
+	if err != nil || rebound.SourceBucketUUID != "newSourceUUID" || rebound.TargetBucketUUID != "newTargetUUID" {
+		t.Errorf("spec in cache is %+v, err=%v, expected it to be bound to the recreated buckets", rebound, err)
+	}
+	persisted := &metadata.ReplicationSpecification{}
+	json.Unmarshal(meta_svc.entries[getKeyFromReplicationId(specs[1].Id)], persisted)
+	if persisted.SourceBucketUUID != "newSourceUUID" || persisted.TargetBucketUUID != "newTargetUUID" {
+		t.Errorf("persisted spec has bucket uuids %v and %v, expected the new ones", persisted.SourceBucketUUID, persisted.TargetBucketUUID)
+	}
+
+	expectedMessage := "Replication from bucket \"source1\" to bucket \"target1\" on cluster \"remote\" has been rebound, since " +
+		"source bucket \"source1\" and target bucket \"target1\" were deleted and recreated"
+	if len(uilog_svc.messages) != 1 || uilog_svc.messages[0] != expectedMessage {
+		t.Errorf("ui log messages are %v, expected %q", uilog_svc.messages, expectedMessage)
+	}
+
+	// the rebound spec is valid from now on
+	if err, detail_err := service.validateExistingReplicationSpec(rebound, lookups, false /*dryRun*/); err != nil || detail_err != nil {
+		t.Errorf("validation of rebound spec returned %v, %v", err, detail_err)
+	}
+}
+
+func TestPauseAndResumeAllReplicationsForBucket(t *testing.T) {
+	service := newTestReplicationSpecService(0)
+	meta_svc := &casTestMetadataSvc{testMetadataSvc: newTestMetadataSvc()}
+	meta_svc.revs = make(map[string]interface{})
+	service.metadata_svc = meta_svc
+	service.remote_cluster_svc = &testRemoteClusterSvc{names: map[string]string{"targetClusterUUID": "remote"}}
+	uilog_svc := &testUILogSvc{}
+	service.uilog_svc = uilog_svc
+
+	// specs 0 to 3 are from bucket1, among which spec 1 has been paused already and spec 3 is missing from metadata store.
+	// spec 4 is from another bucket
+	specs := make([]*metadata.ReplicationSpecification, 5)
+	for index := range specs {
+		sourceBucket := "bucket1"
+		if index == 4 {
+			sourceBucket = "bucket2"
+		}
+		spec := metadata.NewReplicationSpecification(sourceBucket, "", "targetClusterUUID", fmt.Sprintf("target%v", index), "")
+		spec.Settings.Active = index != 1
+		spec.Revision = 1
+		if index != 3 {
+			key := getKeyFromReplicationId(spec.Id)
+			value, _ := json.Marshal(spec)
+			meta_svc.entries[key] = value
+			meta_svc.revs[key] = 1
+		}
+		service.cacheSpec(service.cache, spec.Id, spec)
+		specs[index] = spec
+	}
+	service.refreshSpecsSnapshot()
+
+	isActive := func(index int) bool {
+		spec, _ := service.ReplicationSpec(specs[index].Id)
+		return spec.Settings.Active
+	}
+
+	results, err := service.PauseAllReplicationsForBucket("bucket1")
+	if err != nil {
+		t.Fatalf("Failed to pause replications. err=%v", err)
+	}
+	if len(results) != 4 || results[specs[0].Id] != nil || results[specs[1].Id] != nil || results[specs[2].Id] != nil || results[specs[3].Id] == nil {
+		t.Errorf("results of pause are %v", results)
+	}
+	if isActive(0) || isActive(1) || isActive(2) || !isActive(4) {
+		t.Errorf("replications are not paused as expected")
+	}
+	// replications that are already paused are not written
+	if meta_svc.revs[getKeyFromReplicationId(specs[1].Id)] != 1 {
+		t.Errorf("paused replication has been written")
+	}
+	if len(uilog_svc.messages) != 1 || !strings.HasPrefix(uilog_svc.messages[0], "2 replications paused: ") {
+		t.Errorf("ui log messages are %v, expected a single message for 2 paused replications", uilog_svc.messages)
+	}
+
+	uilog_svc.messages = nil
+	results, err = service.ResumeAllReplicationsForBucket("bucket1")
+	if err != nil {
+		t.Fatalf("Failed to resume replications. err=%v", err)
+	}
+	// spec 3 is still active, so it is not written and its resume succeeds
+	for replicationId, err := range results {
+		if err != nil {
+			t.Errorf("failed to resume %v. err=%v", replicationId, err)
+		}
+	}
+	if len(results) != 4 {
+		t.Errorf("results of resume are %v", results)
+	}
+	if !isActive(0) || !isActive(1) || !isActive(2) {
+		t.Errorf("replications are not resumed")
+	}
+	if len(uilog_svc.messages) != 1 || !strings.HasPrefix(uilog_svc.messages[0], "3 replications resumed: ") {
+		t.Errorf("ui log messages are %v, expected a single message for 3 resumed replications", uilog_svc.messages)
+	}
+}
+
+// metadata service that rejects writes whose revisions do not match those of the entries, as metakv does.
+// revisions are ints that are bumped by each write
+type casTestMetadataSvc struct {
+	*testMetadataSvc
+	// number of writes rejected because of revision mismatch
+	num_of_mismatches int
+}
+
+func (meta_svc *casTestMetadataSvc) Set(key string, value []byte, rev interface{}) error {
+	if meta_svc.revs[key] != rev {
+		meta_svc.num_of_mismatches++
+		return service_def.ErrorRevisionMismatch
+	}
+	meta_svc.revs[key] = rev.(int) + 1
+	return meta_svc.testMetadataSvc.Set(key, value, rev)
+}
+
+func TestUpdateReplicationSpecSettings(t *testing.T) {
+	service := newTestReplicationSpecService(0)
+	meta_svc := &casTestMetadataSvc{testMetadataSvc: newTestMetadataSvc()}
+	meta_svc.revs = make(map[string]interface{})
+	service.metadata_svc = meta_svc
+	service.remote_cluster_svc = &testRemoteClusterSvc{names: map[string]string{"targetClusterUUID": "remote"}}
+	service.uilog_svc = &testUILogSvc{}
+
+	spec := newTestReplicationSpec(0, 1)
+	key := getKeyFromReplicationId(spec.Id)
+	service.cacheSpec(service.cache, spec.Id, spec)
+	service.refreshSpecsSnapshot()
+
+	// another node has updated the spec since it was cached
+	concurrentSpec := spec.Clone()
+	concurrentSpec.Settings.BatchCount = 700
+	value, _ := json.Marshal(concurrentSpec)
+	meta_svc.entries[key] = value
+	meta_svc.revs[key] = 2
+
+	settings, err := service.UpdateReplicationSpecSettings(spec.Id, func(settings map[string]interface{}) error {
+		settings[metadata.CheckpointInterval] = 1000
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to update settings. err=%v", err)
+	}
+	// the update is retried on top of the concurrent update, instead of overwriting it
+	if meta_svc.num_of_mismatches != 1 {
+		t.Errorf("%v writes were rejected, expected 1", meta_svc.num_of_mismatches)
+	}
+	if settings[metadata.CheckpointInterval] != 1000 || settings[metadata.BatchCount] != 700 {
+		t.Errorf("Returned settings are %v, expected both the update and the concurrent update", settings)
+	}
+	persisted := &metadata.ReplicationSpecification{}
+	json.Unmarshal(meta_svc.entries[key], persisted)
+	if persisted.Settings.CheckpointInterval != 1000 || persisted.Settings.BatchCount != 700 {
+		t.Errorf("Persisted settings are %+v, expected both the update and the concurrent update", persisted.Settings)
+	}
+	if cachedSpec, _ := service.ReplicationSpec(spec.Id); cachedSpec.Settings.CheckpointInterval != 1000 {
+		t.Errorf("Spec in cache has not been updated")
+	}
+
+	// errors of mutate and invalid settings are returned without writes
+	mutateErr := errors.New("mutate error")
+	if _, err := service.UpdateReplicationSpecSettings(spec.Id, func(settings map[string]interface{}) error {
+		return mutateErr
+	}); err != mutateErr {
+		t.Errorf("Update returned %v, expected %v", err, mutateErr)
+	}
+	if _, err := service.UpdateReplicationSpecSettings(spec.Id, func(settings map[string]interface{}) error {
+		settings[metadata.BatchCount] = "700"
+		return nil
+	}); err == nil {
+		t.Errorf("Expected error for invalid settings")
+	}
+	if meta_svc.revs[key] != 3 {
+		t.Errorf("Spec has revision %v, expected no writes after the update", meta_svc.revs[key])
+	}
+
+	// the update is retried only once
+	meta_svc.revs[key] = 10
+	service.cacheSpec(service.cache, spec.Id, newTestReplicationSpec(0, 1))
+	mutated := 0
+	if _, err := service.UpdateReplicationSpecSettings(spec.Id, func(settings map[string]interface{}) error {
+		mutated++
+		meta_svc.revs[key] = meta_svc.revs[key].(int) + 1
+		return nil
+	}); err != service_def.ErrorRevisionMismatch {
+		t.Errorf("Update returned %v, expected %v", err, service_def.ErrorRevisionMismatch)
+	}
+	if mutated != 2 {
+		t.Errorf("Settings were mutated %v times, expected 2", mutated)
+	}
+
+	if _, err := service.UpdateReplicationSpecSettings("nonexistent", func(settings map[string]interface{}) error {
+		return nil
+	}); err == nil {
+		t.Errorf("Expected error for nonexistent spec")
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2013 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package parts
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// the max time a blocked send sleeps before it checks the rate again, so that rate changes take effect promptly
+	max_bandwidth_wait_interval = 100 * time.Millisecond
+	// throughput is averaged over this many one-second slots
+	throughput_window_secs = 10
+)
+
+// token bucket, in bytes, that paces the sends of a nozzle to cap its outbound throughput.
+// the bucket holds at most one second's worth of tokens. a send is allowed as soon as the bucket is not in debt,
+// and may put the bucket into debt, so that sends larger than the bucket are not blocked forever.
+// a rate of 0 means that sends are not limited.
+// it also keeps track of the bytes sent, for the throughput of the nozzle
+type bandwidthLimiter struct {
+	// number of bytes allowed per second
+	rate        int
+	tokens      float64
+	last_refill time.Time
+	// bytes sent in each of the last throughput_window_secs seconds, indexed by unix time modulo the window
+	sent_bytes [throughput_window_secs]uint64
+	sent_secs  [throughput_window_secs]int64
+	lock       sync.Mutex
+}
+
+func newBandwidthLimiter(rate int) *bandwidthLimiter {
+	return &bandwidthLimiter{rate: rate,
+		tokens:      float64(rate),
+		last_refill: time.Now()}
+}
+
+func (limiter *bandwidthLimiter) setRate(rate int) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	limiter.refill(time.Now())
+	if limiter.rate <= 0 || limiter.tokens > float64(rate) {
+		limiter.tokens = float64(rate)
+	}
+	limiter.rate = rate
+}
+
+func (limiter *bandwidthLimiter) getRate() int {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	return limiter.rate
+}
+
+// blocks until numOfBytes are allowed to be sent. returns PartStoppedError if finch is closed in the meantime
+func (limiter *bandwidthLimiter) wait(numOfBytes int, finch chan bool) error {
+	for {
+		wait_time := limiter.take(numOfBytes)
+		if wait_time == 0 {
+			return nil
+		}
+		if wait_time > max_bandwidth_wait_interval {
+			wait_time = max_bandwidth_wait_interval
+		}
+		select {
+		case <-finch:
+			return PartStoppedError
+		case <-time.After(wait_time):
+		}
+	}
+}
+
+// takes numOfBytes tokens if the bucket is not in debt and returns 0. otherwise returns the time to wait for the debt to be paid off
+func (limiter *bandwidthLimiter) take(numOfBytes int) time.Duration {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	if limiter.rate <= 0 {
+		return 0
+	}
+
+	limiter.refill(time.Now())
+	if limiter.tokens >= 0 {
+		limiter.tokens -= float64(numOfBytes)
+		return 0
+	}
+	return time.Duration(-limiter.tokens / float64(limiter.rate) * float64(time.Second))
+}
+
+// caller needs to hold lock
+func (limiter *bandwidthLimiter) refill(now time.Time) {
+	if limiter.rate > 0 {
+		limiter.tokens += now.Sub(limiter.last_refill).Seconds() * float64(limiter.rate)
+		if limiter.tokens > float64(limiter.rate) {
+			limiter.tokens = float64(limiter.rate)
+		}
+	}
+	limiter.last_refill = now
+}
+
+// records numOfBytes that have been sent
+func (limiter *bandwidthLimiter) recordSent(numOfBytes int, now time.Time) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	sec := now.Unix()
+	index := sec % throughput_window_secs
+	if limiter.sent_secs[index] != sec {
+		limiter.sent_secs[index] = sec
+		limiter.sent_bytes[index] = 0
+	}
+	limiter.sent_bytes[index] += uint64(numOfBytes)
+}
+
+// returns the average number of bytes sent per second over the last throughput_window_secs seconds
+func (limiter *bandwidthLimiter) throughput(now time.Time) uint64 {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	sec := now.Unix()
+	var total uint64
+	for index, slot_sec := range limiter.sent_secs {
+		if slot_sec > sec-throughput_window_secs && slot_sec <= sec {
+			total += limiter.sent_bytes[index]
+		}
+	}
+	return total / throughput_window_secs
+}
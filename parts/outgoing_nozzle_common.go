@@ -120,6 +120,8 @@ type DataSentEventAdditional struct {
 	IsExpirySet    bool
 	VBucket        uint16
 	Req_size       int
+	// whether the response is the ack of a durable write, which takes longer than that of a plain write
+	IsDurable bool
 }
 
 // does not return error since the assumption is that settings have been validated prior
@@ -175,6 +177,10 @@ type dataBatch struct {
 	logger            *log.CommonLogger
 	batch_nonempty_ch chan bool
 	nonempty_set      bool
+	// total size of the bodies of the documents in the batch, in bytes
+	curBodySize uint32
+	// the batch is full once curBodySize reaches this. 0 disables the limit
+	capacity_body_size uint32
 }
 
 func newBatch(cap_count uint32, cap_size uint32, logger *log.CommonLogger) *dataBatch {
@@ -209,7 +215,11 @@ func (b *dataBatch) accumuBatch(req *base.WrappedMCRequest, classifyFunc func(re
 			b.bigDoc_map[req.UniqueKey] = req
 		}
 		curSize := b.incrementSize(uint32(size))
-		if curCount < b.capacity_count && curSize < b.capacity_size*1000 {
+		curBodySize := atomic.AddUint32(&b.curBodySize, uint32(len(req.Req.Body)))
+		// a capacity count of 0 disables the count limit, in which case the batch is limited by body size alone
+		countFull := b.capacity_count > 0 && curCount >= b.capacity_count
+		bodySizeFull := b.capacity_body_size > 0 && curBodySize >= b.capacity_body_size
+		if !countFull && !bodySizeFull && curSize < b.capacity_size*1000 {
 			ret = false
 		}
 	}
@@ -224,6 +234,10 @@ func (b *dataBatch) size() uint32 {
 	return atomic.LoadUint32(&b.curSize)
 }
 
+func (b *dataBatch) bodySize() uint32 {
+	return atomic.LoadUint32(&b.curBodySize)
+}
+
 func (b *dataBatch) incrementCount(delta uint32) uint32 {
 	return atomic.AddUint32(&b.curCount, delta)
 }
@@ -10,6 +10,7 @@
 package parts
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	mc "github.com/couchbase/gomemcached"
@@ -18,8 +19,11 @@ import (
 	common "github.com/couchbase/goxdcr/common"
 	connector "github.com/couchbase/goxdcr/connector"
 	"github.com/couchbase/goxdcr/log"
+	"github.com/couchbase/goxdcr/metadata"
 	"github.com/couchbase/goxdcr/utils"
 	"regexp"
+	"sort"
+	"sync"
 	"time"
 )
 
@@ -30,6 +34,13 @@ var ErrorInvalidRoutingMapForRouter = errors.New("routingMap in Router is invali
 
 type ReqCreator func(id string) (*base.WrappedMCRequest, error)
 
+// downstream parts that routers can balance load among in least-loaded balance mode, e.g., XmemNozzle
+type balancedPart interface {
+	GetQueueDepth() int
+	// parts with the same connection string write to the same target node, and can take each other's vbuckets
+	ConnStr() string
+}
+
 // XDCR Router does two things:
 // 1. converts UprEvent to MCRequest
 // 2. routes MCRequest to downstream parts
@@ -37,14 +48,30 @@ type Router struct {
 	id string
 	*connector.Router
 	filterRegexp *regexp.Regexp    // filter expression
+	filterPrefix []byte            // filter key prefix
+	replicateOps string            // types of operations that are replicated
+	canaryKey    []byte            // key of the canary document of the replication
 	routingMap   map[uint16]string // pvbno -> partId. This defines the loading balancing strategy of which vbnos would be routed to which part
 	req_creator  ReqCreator
 	topic        string
 	// whether lww conflict resolution mode has been enabled
 	sourceCRMode base.ConflictResolutionMode
+	// one of the values of metadata.BalanceMode
+	balanceMode string
+	// partId -> downstream parts that write to the same target node, including the part itself. only used in least-loaded balance mode
+	balanceGroups map[string][]balancedPartEntry
+	// vbno -> partId that the vbucket has been assigned to in least-loaded balance mode.
+	// a vbucket stays with the same part once assigned, so that mutations in the vbucket are sent in order
+	vbAffinity     map[uint16]string
+	vbAffinityLock sync.Mutex
 }
 
-func NewRouter(id string, topic string, filterExpression string,
+type balancedPartEntry struct {
+	partId string
+	part   balancedPart
+}
+
+func NewRouter(id string, topic string, filterExpression string, filterKeyPrefix string, replicateOps string, balanceMode string,
 	downStreamParts map[string]common.Part,
 	routingMap map[uint16]string,
 	sourceCRMode base.ConflictResolutionMode,
@@ -61,15 +88,24 @@ func NewRouter(id string, topic string, filterExpression string,
 	router := &Router{
 		id:           id,
 		filterRegexp: filterRegexp,
+		filterPrefix: []byte(filterKeyPrefix),
+		replicateOps: replicateOps,
+		canaryKey:    []byte(base.CanaryKeyPrefix + topic),
 		routingMap:   routingMap,
 		topic:        topic,
 		sourceCRMode: sourceCRMode,
+		balanceMode:  balanceMode,
 		req_creator:  req_creator}
 
+	if balanceMode == metadata.BalanceModeLeastLoaded {
+		router.balanceGroups = buildBalanceGroups(downStreamParts)
+		router.vbAffinity = make(map[uint16]string)
+	}
+
 	var routingFunc connector.Routing_Callback_Func = router.route
 	router.Router = connector.NewRouter(id, downStreamParts, &routingFunc, logger_context, "XDCRRouter")
 
-	router.Logger().Infof("%v created with %d downstream parts \n", router.id, len(downStreamParts))
+	router.Logger().Infof("%v created with %d downstream parts and balanceMode=%v\n", router.id, len(downStreamParts), balanceMode)
 	return router, nil
 }
 
@@ -85,6 +121,8 @@ func (router *Router) ComposeMCRequest(event *mcc.UprEvent) (*base.WrappedMCRequ
 	req.VBucket = event.VBucket
 	req.Key = event.Key
 	req.Body = event.Value
+	// requests are reused, and may carry the datatype set by xmem, e.g., snappy, from their last use
+	req.DataType = 0
 	//opCode
 	req.Opcode = event.Opcode
 
@@ -129,7 +167,9 @@ func (router *Router) ComposeMCRequest(event *mcc.UprEvent) (*base.WrappedMCRequ
 }
 
 // Implementation of the routing algorithm
-// Currently doing static dispatching based on vbucket number.
+// In vbucket balance mode, dispatching is static and based on vbucket number.
+// In least-loaded balance mode, a vbucket is dispatched to the least loaded part among the parts to the same target node
+// when it is first seen, and to the same part afterwards.
 func (router *Router) route(data interface{}) (map[string]interface{}, error) {
 	result := make(map[string]interface{})
 
@@ -149,6 +189,18 @@ func (router *Router) route(data interface{}) (map[string]interface{}, error) {
 		return nil, ErrorInvalidRoutingMapForRouter
 	}
 
+	// drop operations that are not replicated
+	if !router.isOpReplicated(uprEvent.Opcode) {
+		router.RaiseEvent(common.NewEvent(common.DataFiltered, uprEvent, router, nil, nil))
+		return result, nil
+	}
+
+	// drop canary documents of other replications from the same source bucket
+	if router.isOtherCanary(uprEvent.Key) {
+		router.RaiseEvent(common.NewEvent(common.DataFiltered, uprEvent, router, nil, nil))
+		return result, nil
+	}
+
 	// filter data if filter expession has been defined
 	if router.filterRegexp != nil {
 		if !utils.RegexpMatch(router.filterRegexp, uprEvent.Key) {
@@ -157,14 +209,90 @@ func (router *Router) route(data interface{}) (map[string]interface{}, error) {
 			return result, nil
 		}
 	}
+	// filter data if filter key prefix has been defined. this is much cheaper than matching filter expression
+	if len(router.filterPrefix) > 0 && !bytes.HasPrefix(uprEvent.Key, router.filterPrefix) {
+		router.RaiseEvent(common.NewEvent(common.DataFiltered, uprEvent, router, nil, nil))
+		return result, nil
+	}
 	mcRequest, err := router.ComposeMCRequest(uprEvent)
 	if err != nil {
 		return nil, utils.NewEnhancedError("Error creating new memcached request.", err)
 	}
+	if router.balanceGroups != nil {
+		partId = router.balancedPartId(uprEvent.VBucket, partId)
+	}
 	result[partId] = mcRequest
 	return result, nil
 }
 
+// groups downstream parts by the target nodes that they write to. parts that cannot report their loads are in groups of their own
+func buildBalanceGroups(downStreamParts map[string]common.Part) map[string][]balancedPartEntry {
+	// parts are visited in sorted order so that ties are broken the same way every time
+	partIds := make([]string, 0, len(downStreamParts))
+	for partId, _ := range downStreamParts {
+		partIds = append(partIds, partId)
+	}
+	sort.Strings(partIds)
+
+	partsByConnStr := make(map[string][]balancedPartEntry)
+	for _, partId := range partIds {
+		if bp, ok := downStreamParts[partId].(balancedPart); ok {
+			partsByConnStr[bp.ConnStr()] = append(partsByConnStr[bp.ConnStr()], balancedPartEntry{partId, bp})
+		}
+	}
+
+	balanceGroups := make(map[string][]balancedPartEntry)
+	for _, group := range partsByConnStr {
+		for _, entry := range group {
+			balanceGroups[entry.partId] = group
+		}
+	}
+	return balanceGroups
+}
+
+// returns the part that the vbucket has been assigned to, or assigns the vbucket to the least loaded part
+// among the parts to the same target node as defaultPartId, which is the part that the vbucket is mapped to in routingMap
+func (router *Router) balancedPartId(vbno uint16, defaultPartId string) string {
+	router.vbAffinityLock.Lock()
+	defer router.vbAffinityLock.Unlock()
+
+	if partId, ok := router.vbAffinity[vbno]; ok {
+		return partId
+	}
+
+	partId := defaultPartId
+	minQueueDepth := -1
+	for _, entry := range router.balanceGroups[defaultPartId] {
+		queueDepth := entry.part.GetQueueDepth()
+		if minQueueDepth < 0 || queueDepth < minQueueDepth {
+			partId = entry.partId
+			minQueueDepth = queueDepth
+		}
+	}
+	router.vbAffinity[vbno] = partId
+	router.Logger().Debugf("%v assigned vb %v to %v with queue depth %v\n", router.id, vbno, partId, minQueueDepth)
+	return partId
+}
+
+// whether operations with the specified opcode are replicated per replicate_ops setting.
+// an empty setting, e.g., in specs created before the setting was introduced, replicates all operations
+func (router *Router) isOpReplicated(opcode mc.CommandCode) bool {
+	switch router.replicateOps {
+	case metadata.ReplicateOpsMutationsOnly:
+		return opcode != mc.UPR_DELETION && opcode != mc.UPR_EXPIRATION
+	case metadata.ReplicateOpsDeletionsOnly:
+		return opcode != mc.UPR_MUTATION
+	default:
+		return true
+	}
+}
+
+// whether the key is that of a canary document written by a replication other than the one of the router
+func (router *Router) isOtherCanary(key []byte) bool {
+	return len(key) >= len(base.CanaryKeyPrefix) && string(key[:len(base.CanaryKeyPrefix)]) == base.CanaryKeyPrefix &&
+		!bytes.Equal(key, router.canaryKey)
+}
+
 func (router *Router) RoutingMap() map[uint16]string {
 	return router.routingMap
 }
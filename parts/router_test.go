@@ -0,0 +1,182 @@
+// Copyright (c) 2013 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package parts
+
+import (
+	mc "github.com/couchbase/gomemcached"
+	mcc "github.com/couchbase/gomemcached/client"
+	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/common"
+	"github.com/couchbase/goxdcr/log"
+	"github.com/couchbase/goxdcr/metadata"
+	"testing"
+)
+
+// counts DataFiltered events raised by router
+type testFilteredListener struct {
+	count int
+}
+
+func (l *testFilteredListener) OnEvent(event *common.Event) {
+	l.count++
+}
+
+func TestRouterReplicateOps(t *testing.T) {
+	opcodes := []mc.CommandCode{mc.UPR_MUTATION, mc.UPR_DELETION, mc.UPR_EXPIRATION}
+	expected := map[string][]bool{
+		"":                                 {true, true, true},
+		metadata.ReplicateOpsAll:           {true, true, true},
+		metadata.ReplicateOpsMutationsOnly: {true, false, false},
+		metadata.ReplicateOpsDeletionsOnly: {false, true, true},
+	}
+
+	for replicateOps, replicated := range expected {
+		router, err := NewRouter("testRouter", "testTopic", "", "", replicateOps, metadata.BalanceModeVbucket, map[string]common.Part{},
+			map[uint16]string{0: "testPart"}, base.CRMode_RevId, log.DefaultLoggerContext, nil)
+		if err != nil {
+			t.Fatalf("Failed to create router. err=%v", err)
+		}
+		listener := &testFilteredListener{}
+		router.RegisterComponentEventListener(common.DataFiltered, listener)
+
+		numOfFiltered := 0
+		for index, opcode := range opcodes {
+			result, err := router.route(&mcc.UprEvent{Opcode: opcode, VBucket: 0, Key: []byte("key"), Seqno: uint64(index + 1)})
+			if err != nil {
+				t.Fatalf("Unexpected error routing %v with replicate_ops=%q. err=%v", opcode, replicateOps, err)
+			}
+			if _, ok := result["testPart"]; ok != replicated[index] {
+				t.Errorf("%v is routed=%v with replicate_ops=%q, expected %v", opcode, ok, replicateOps, replicated[index])
+			}
+			if !replicated[index] {
+				numOfFiltered++
+			}
+		}
+		// dropped operations are reported so that through seqno keeps advancing
+		if listener.count != numOfFiltered {
+			t.Errorf("%v DataFiltered events raised with replicate_ops=%q, expected %v", listener.count, replicateOps, numOfFiltered)
+		}
+	}
+}
+
+func TestRouterFilterKeyPrefix(t *testing.T) {
+	router, err := NewRouter("testRouter", "testTopic", "", "app1:", metadata.ReplicateOpsAll, metadata.BalanceModeVbucket, map[string]common.Part{},
+		map[uint16]string{0: "testPart"}, base.CRMode_RevId, log.DefaultLoggerContext, nil)
+	if err != nil {
+		t.Fatalf("Failed to create router. err=%v", err)
+	}
+	listener := &testFilteredListener{}
+	router.RegisterComponentEventListener(common.DataFiltered, listener)
+
+	expected := map[string]bool{"app1:doc": true, "app1:": true, "app2:doc": false, "app1": false, "doc:app1:": false}
+	seqno := uint64(0)
+	for key, replicated := range expected {
+		seqno++
+		result, err := router.route(&mcc.UprEvent{Opcode: mc.UPR_MUTATION, VBucket: 0, Key: []byte(key), Seqno: seqno})
+		if err != nil {
+			t.Fatalf("Unexpected error routing %q. err=%v", key, err)
+		}
+		if _, ok := result["testPart"]; ok != replicated {
+			t.Errorf("%q is routed=%v, expected %v", key, ok, replicated)
+		}
+	}
+	if listener.count != 3 {
+		t.Errorf("%v DataFiltered events raised, expected 3", listener.count)
+	}
+}
+
+func TestRouterFilterOtherCanaries(t *testing.T) {
+	router, err := NewRouter("testRouter", "testTopic", "", "", metadata.ReplicateOpsAll, metadata.BalanceModeVbucket, map[string]common.Part{},
+		map[uint16]string{0: "testPart"}, base.CRMode_RevId, log.DefaultLoggerContext, nil)
+	if err != nil {
+		t.Fatalf("Failed to create router. err=%v", err)
+	}
+	listener := &testFilteredListener{}
+	router.RegisterComponentEventListener(common.DataFiltered, listener)
+
+	// canary documents of other replications from the same source bucket are not replicated
+	expected := map[string]bool{base.CanaryKeyPrefix + "testTopic": true, base.CanaryKeyPrefix + "otherTopic": false,
+		base.CanaryKeyPrefix + "testTopic2": false, "doc": true}
+	seqno := uint64(0)
+	for key, replicated := range expected {
+		seqno++
+		result, err := router.route(&mcc.UprEvent{Opcode: mc.UPR_MUTATION, VBucket: 0, Key: []byte(key), Seqno: seqno})
+		if err != nil {
+			t.Fatalf("Unexpected error routing %q. err=%v", key, err)
+		}
+		if _, ok := result["testPart"]; ok != replicated {
+			t.Errorf("%q is routed=%v, expected %v", key, ok, replicated)
+		}
+	}
+	if listener.count != 2 {
+		t.Errorf("%v DataFiltered events raised, expected 2", listener.count)
+	}
+}
+
+// downstream part that reports a fixed queue depth
+type testBalancedPart struct {
+	common.Part
+	connStr    string
+	queueDepth int
+}
+
+func (p *testBalancedPart) GetQueueDepth() int {
+	return p.queueDepth
+}
+
+func (p *testBalancedPart) ConnStr() string {
+	return p.connStr
+}
+
+func TestRouterLeastLoaded(t *testing.T) {
+	partA1 := &testBalancedPart{connStr: "nodeA", queueDepth: 10}
+	partA2 := &testBalancedPart{connStr: "nodeA", queueDepth: 5}
+	partB1 := &testBalancedPart{connStr: "nodeB", queueDepth: 20}
+	partB2 := &testBalancedPart{connStr: "nodeB", queueDepth: 0}
+	downStreamParts := map[string]common.Part{"a1": partA1, "a2": partA2, "b1": partB1, "b2": partB2}
+	// vbs 0 and 1 live on nodeA, vb 2 lives on nodeB
+	routingMap := map[uint16]string{0: "a1", 1: "a1", 2: "b1"}
+	router, err := NewRouter("testRouter", "testTopic", "", "", metadata.ReplicateOpsAll, metadata.BalanceModeLeastLoaded, downStreamParts,
+		routingMap, base.CRMode_RevId, log.DefaultLoggerContext, nil)
+	if err != nil {
+		t.Fatalf("Failed to create router. err=%v", err)
+	}
+
+	routedTo := func(vbno uint16, seqno uint64) string {
+		result, err := router.route(&mcc.UprEvent{Opcode: mc.UPR_MUTATION, VBucket: vbno, Key: []byte("key"), Seqno: seqno})
+		if err != nil {
+			t.Fatalf("Unexpected error routing vb %v. err=%v", vbno, err)
+		}
+		if len(result) != 1 {
+			t.Fatalf("vb %v is routed to %v parts, expected 1", vbno, len(result))
+		}
+		for partId, _ := range result {
+			return partId
+		}
+		return ""
+	}
+
+	// vbs go to the least loaded part on their own target node
+	if partId := routedTo(0, 1); partId != "a2" {
+		t.Errorf("vb 0 is routed to %v, expected a2", partId)
+	}
+	if partId := routedTo(2, 1); partId != "b2" {
+		t.Errorf("vb 2 is routed to %v, expected b2", partId)
+	}
+
+	// vbs stick to the parts that they have been assigned to, even when the loads change
+	partA2.queueDepth = 100
+	if partId := routedTo(0, 2); partId != "a2" {
+		t.Errorf("vb 0 is routed to %v after its part got loaded, expected a2", partId)
+	}
+	if partId := routedTo(1, 1); partId != "a1" {
+		t.Errorf("vb 1 is routed to %v, expected a1", partId)
+	}
+}
@@ -11,6 +11,7 @@ package parts
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	mc "github.com/couchbase/gomemcached"
@@ -20,7 +21,9 @@ import (
 	gen_server "github.com/couchbase/goxdcr/gen_server"
 	"github.com/couchbase/goxdcr/log"
 	"github.com/couchbase/goxdcr/metadata"
+	"github.com/couchbase/goxdcr/simple_utils"
 	"github.com/couchbase/goxdcr/utils"
+	"github.com/golang/snappy"
 	"io"
 	"math"
 	"math/rand"
@@ -35,6 +38,8 @@ import (
 
 //configuration settings for XmemNozzle
 const (
+	// estimate of the response time of target, which adapts to observed response times. it determines how often
+	// in-flight requests are checked for timeouts, and how long to wait for responses when op_timeout is not set
 	SETTING_RESP_TIMEOUT             = "resp_timeout"
 	XMEM_SETTING_DEMAND_ENCRYPTION   = "demandEncryption"
 	XMEM_SETTING_CERTIFICATE         = "certificate"
@@ -43,6 +48,51 @@ const (
 	XMEM_SETTING_REMOTE_PROXY_PORT   = "remote_proxy_port"
 	XMEM_SETTING_LOCAL_PROXY_PORT    = "local_proxy_port"
 	XMEM_SETTING_REMOTE_MEM_SSL_PORT = "remote_ssl_port"
+	XMEM_SETTING_KEY_PREFIX          = "key_prefix"
+	XMEM_SETTING_KEY_SUFFIX          = "key_suffix"
+	XMEM_SETTING_FLUSH_INTERVAL      = "flush_interval"
+	// fixed time to wait for the response to a request before the request is considered failed and is resent.
+	// unlike resp_timeout, it does not adapt to observed response times.
+	// it is either a time.Duration, which applies to all documents, or a map[string]interface{} with
+	// OP_TIMEOUT_BASE and OP_TIMEOUT_PER_KB, so that large documents are given more time to be written
+	XMEM_SETTING_OP_TIMEOUT = "op_timeout"
+	// keys of the tiered form of op_timeout. the timeout of a request is base + per_kb * size of body in KB
+	OP_TIMEOUT_BASE   = "base"
+	OP_TIMEOUT_PER_KB = "per_kb"
+	// durability requirement of writes to target, one of the values of metadata.TargetDurability
+	XMEM_SETTING_TARGET_DURABILITY = "target_durability"
+	// compression of document bodies sent to target, one of the values of metadata.CompressionType
+	XMEM_SETTING_COMPRESSION = "compression"
+	// document bodies smaller than this (in bytes) are sent uncompressed, since compressing them saves little
+	XMEM_SETTING_COMPRESSION_THRESHOLD = "compression_threshold"
+	// how routers distribute documents among the xmem nozzles to the same target node, one of the values of
+	// metadata.BalanceMode. routers are configured with it when they are constructed, and xmem only reports it
+	XMEM_SETTING_BALANCE_MODE = "balance_mode"
+	// a batch is flushed once the total size (in bytes) of the bodies of its documents reaches this, even if it has not
+	// reached batch count. 0 disables the limit. batch count can be set to 0 when this is set, so that batches are
+	// limited by body size alone
+	XMEM_SETTING_BATCHSIZE_BYTES = "batch_size_bytes"
+	// a ConflictLogger, which is told about the documents whose source mutations lost conflict resolution.
+	// it is validated in initializeConfig instead of through xmem_setting_defs, since its type is an interface
+	XMEM_SETTING_CONFLICT_LOGGER = "conflict_logger"
+	// names of the HELLO features, as in base.HelloFeatureNames, that target needs to support for the nozzle to start,
+	// in addition to those needed by target_durability and compression
+	XMEM_SETTING_REQUIRED_FEATURES = "required_features"
+	// max number of bytes per second that the nozzle sends to target. sends are blocked until they are allowed by
+	// the limit. 0 means unlimited. it can be changed at runtime through UpdateSettings
+	XMEM_SETTING_MAX_BYTES_PER_SEC = "max_bytes_per_sec"
+	// base wait time before resending a doc that got a retriable response, i.e., TMPFAIL or ENOMEM, from target.
+	// the wait time doubles with each retriable response that the doc gets, up to retry_max_backoff, and is jittered
+	// so that docs are not resent in lockstep. when it is not set, only TMPFAIL responses are backed off, as per
+	// default_tmpfail_backoff_time, and docs that got ENOMEM are resent right away.
+	// either way, docs that got other temporary errors are resent right away, and num_of_retry still applies to
+	// resends for response timeouts
+	XMEM_SETTING_RETRY_BACKOFF = "retry_backoff"
+	// cap of the wait time before resending a doc that got a retriable response. defaults to max_tmpfail_backoff_time
+	XMEM_SETTING_RETRY_MAX_BACKOFF = "retry_max_backoff"
+	// a Transformer, which mutates or drops documents before they are sent to target. none by default.
+	// like conflict_logger, it is validated in initializeConfig instead of through xmem_setting_defs
+	XMEM_SETTING_TRANSFORMER = "transformer"
 
 	//default configuration
 	default_numofretry          int           = 5
@@ -58,25 +108,56 @@ const (
 	default_backoff_wait_time    time.Duration = 10 * time.Millisecond
 	default_getMeta_readTimeout  time.Duration = time.Duration(1) * time.Second
 	default_newconn_backoff_time time.Duration = 1 * time.Second
+	// a partial batch is sent out after it has waited for this long, even if it has not reached batch count.
+	default_flushInterval time.Duration = 500 * time.Millisecond
+	// wait time before resending a doc that got temporary failure response from target is
+	// default_tmpfail_backoff_time*2^(num_of_tmpfails-1)*(backoff_factor+1), capped at max_tmpfail_backoff_time
+	default_tmpfail_backoff_time time.Duration = 100 * time.Millisecond
+	max_tmpfail_backoff_time     time.Duration = 10 * time.Second
+	// reason under which resends for response timeouts are counted in retry stats. resends for error responses are
+	// counted under the names of the response statuses
+	retry_reason_timeout = "TIMEOUT"
+	// when a doc has received this many non-temporary error responses from target, its vb is isolated,
+	// i.e., docs in the vb are no longer sent to target, so that the other vbs can make progress
+	max_errors_before_vb_isolation int = 10
+	default_compressionThreshold   int = 128
+	// used in place of batch count to size the data channel and the request buffer when batches are limited by
+	// body size alone
+	default_batchCount int = 500
 
 	//the maximum data (in byte) data channel can hold
 	max_datachannelSize = 10 * 1024 * 1024
+
+	// interval between checks for outstanding docs when the nozzle is flushed before it is stopped
+	flush_check_interval = 10 * time.Millisecond
 )
 
 var xmem_setting_defs base.SettingDefinitions = base.SettingDefinitions{SETTING_BATCHCOUNT: base.NewSettingDef(reflect.TypeOf((*int)(nil)), true),
-	SETTING_BATCHSIZE:               base.NewSettingDef(reflect.TypeOf((*int)(nil)), true),
-	SETTING_NUMOFRETRY:              base.NewSettingDef(reflect.TypeOf((*int)(nil)), false),
-	SETTING_RESP_TIMEOUT:            base.NewSettingDef(reflect.TypeOf((*time.Duration)(nil)), false),
-	SETTING_WRITE_TIMEOUT:           base.NewSettingDef(reflect.TypeOf((*time.Duration)(nil)), false),
-	SETTING_READ_TIMEOUT:            base.NewSettingDef(reflect.TypeOf((*time.Duration)(nil)), false),
-	SETTING_MAX_RETRY_INTERVAL:      base.NewSettingDef(reflect.TypeOf((*time.Duration)(nil)), false),
-	SETTING_SELF_MONITOR_INTERVAL:   base.NewSettingDef(reflect.TypeOf((*time.Duration)(nil)), false),
-	SETTING_BATCH_EXPIRATION_TIME:   base.NewSettingDef(reflect.TypeOf((*time.Duration)(nil)), false),
-	SETTING_OPTI_REP_THRESHOLD:      base.NewSettingDef(reflect.TypeOf((*int)(nil)), true),
-	XMEM_SETTING_DEMAND_ENCRYPTION:  base.NewSettingDef(reflect.TypeOf((*bool)(nil)), false),
-	XMEM_SETTING_CERTIFICATE:        base.NewSettingDef(reflect.TypeOf((*[]byte)(nil)), false),
-	XMEM_SETTING_SAN_IN_CERITICATE:  base.NewSettingDef(reflect.TypeOf((*bool)(nil)), false),
-	XMEM_SETTING_INSECURESKIPVERIFY: base.NewSettingDef(reflect.TypeOf((*bool)(nil)), false),
+	SETTING_BATCHSIZE:                  base.NewSettingDef(reflect.TypeOf((*int)(nil)), true),
+	SETTING_NUMOFRETRY:                 base.NewSettingDef(reflect.TypeOf((*int)(nil)), false),
+	SETTING_RESP_TIMEOUT:               base.NewSettingDef(reflect.TypeOf((*time.Duration)(nil)), false),
+	SETTING_WRITE_TIMEOUT:              base.NewSettingDef(reflect.TypeOf((*time.Duration)(nil)), false),
+	SETTING_READ_TIMEOUT:               base.NewSettingDef(reflect.TypeOf((*time.Duration)(nil)), false),
+	SETTING_MAX_RETRY_INTERVAL:         base.NewSettingDef(reflect.TypeOf((*time.Duration)(nil)), false),
+	SETTING_SELF_MONITOR_INTERVAL:      base.NewSettingDef(reflect.TypeOf((*time.Duration)(nil)), false),
+	SETTING_BATCH_EXPIRATION_TIME:      base.NewSettingDef(reflect.TypeOf((*time.Duration)(nil)), false),
+	SETTING_OPTI_REP_THRESHOLD:         base.NewSettingDef(reflect.TypeOf((*int)(nil)), true),
+	XMEM_SETTING_DEMAND_ENCRYPTION:     base.NewSettingDef(reflect.TypeOf((*bool)(nil)), false),
+	XMEM_SETTING_CERTIFICATE:           base.NewSettingDef(reflect.TypeOf((*[]byte)(nil)), false),
+	XMEM_SETTING_SAN_IN_CERITICATE:     base.NewSettingDef(reflect.TypeOf((*bool)(nil)), false),
+	XMEM_SETTING_INSECURESKIPVERIFY:    base.NewSettingDef(reflect.TypeOf((*bool)(nil)), false),
+	XMEM_SETTING_KEY_PREFIX:            base.NewSettingDef(reflect.TypeOf((*string)(nil)), false),
+	XMEM_SETTING_KEY_SUFFIX:            base.NewSettingDef(reflect.TypeOf((*string)(nil)), false),
+	XMEM_SETTING_FLUSH_INTERVAL:        base.NewSettingDef(reflect.TypeOf((*time.Duration)(nil)), false),
+	XMEM_SETTING_TARGET_DURABILITY:     base.NewSettingDef(reflect.TypeOf((*string)(nil)), false),
+	XMEM_SETTING_COMPRESSION:           base.NewSettingDef(reflect.TypeOf((*string)(nil)), false),
+	XMEM_SETTING_COMPRESSION_THRESHOLD: base.NewSettingDef(reflect.TypeOf((*int)(nil)), false),
+	XMEM_SETTING_BALANCE_MODE:          base.NewSettingDef(reflect.TypeOf((*string)(nil)), false),
+	XMEM_SETTING_BATCHSIZE_BYTES:       base.NewSettingDef(reflect.TypeOf((*int)(nil)), false),
+	XMEM_SETTING_REQUIRED_FEATURES:     base.NewSettingDef(reflect.TypeOf((*[]string)(nil)), false),
+	XMEM_SETTING_MAX_BYTES_PER_SEC:     base.NewSettingDef(reflect.TypeOf((*int)(nil)), false),
+	XMEM_SETTING_RETRY_BACKOFF:         base.NewSettingDef(reflect.TypeOf((*time.Duration)(nil)), false),
+	XMEM_SETTING_RETRY_MAX_BACKOFF:     base.NewSettingDef(reflect.TypeOf((*time.Duration)(nil)), false),
 
 	//only used for xmem over ssl via ns_proxy for 2.5
 	XMEM_SETTING_REMOTE_PROXY_PORT: base.NewSettingDef(reflect.TypeOf((*uint16)(nil)), false),
@@ -84,8 +165,42 @@ var xmem_setting_defs base.SettingDefinitions = base.SettingDefinitions{SETTING_
 
 var UninitializedReseverationNumber = -1
 
+var ErrorTargetDurabilityNotSupported = errors.New("Target does not support durable writes. target_durability needs to be set to none for replications to this target")
+var ErrorTargetCompressionNotSupported = errors.New("Target does not support snappy compression. compression_type needs to be set to none for replications to this target")
+
+// target_durability setting -> durability level in durability requirement frames
+var durabilityLevels = map[string]byte{
+	metadata.TargetDurabilityNone:                     base.DurabilityLevelNone,
+	metadata.TargetDurabilityMajority:                 base.DurabilityLevelMajority,
+	metadata.TargetDurabilityMajorityAndPersistActive: base.DurabilityLevelMajorityAndPersistActive,
+	metadata.TargetDurabilityPersistToMajority:        base.DurabilityLevelPersistToMajority,
+}
+
 type ConflictResolver func(doc_metadata_source documentMetadata, doc_metadata_target documentMetadata, source_cr_mode base.ConflictResolutionMode, logger *log.CommonLogger) bool
 
+// receives the documents whose source mutations lost conflict resolution, either on source side, where they are
+// not sent to target, or on target side, where target rejects them with KEY_EEXISTS.
+// it is called from the routines that send data and receive responses, and hence should not block.
+// key is reused once the call returns, and needs to be copied if it is kept
+type ConflictLogger interface {
+	LogConflict(key []byte, sourceCas, targetCas uint64, vbucket uint16)
+}
+
+// mutates documents in flight, e.g., to strip a field or to add a routing attribute, before they are sent to target.
+// it is called from Receive, before key_prefix and key_suffix are applied, and hence sees source keys.
+// it returns the request to send in place of req, which may be req itself, and whether the document is to be dropped.
+// dropped documents are reported as filtered, so that checkpoints advance past them. an error stops the nozzle,
+// so that the document is replicated again once the pipeline restarts, instead of being lost
+type Transformer interface {
+	Transform(req *mc.MCRequest) (*mc.MCRequest, bool, error)
+}
+
+// the default ConflictLogger, which drops conflicts
+type noopConflictLogger struct{}
+
+func (logger *noopConflictLogger) LogConflict(key []byte, sourceCas, targetCas uint64, vbucket uint16) {
+}
+
 /************************************
 /* struct bufferedMCRequest
 *************************************/
@@ -98,6 +213,13 @@ type bufferedMCRequest struct {
 	timedout     bool
 	reservation  int
 	lock         sync.RWMutex
+	// number of temporary failure responses received for the request.
+	// these do not count towards num_of_retry, since the target is reachable and the request is expected to succeed later
+	num_of_tmpfails int
+	// when the request is to be resent after a temporary failure response. zero if no resend is pending
+	tmpfail_resend_time time.Time
+	// number of non-temporary error responses received for the request
+	num_of_errors int
 }
 
 func newBufferedMCRequest() *bufferedMCRequest {
@@ -116,6 +238,9 @@ func resetBufferedMCRequest(request *bufferedMCRequest) {
 	request.req = nil
 	request.sent_time = nil
 	request.num_of_retry = 0
+	request.num_of_tmpfails = 0
+	request.tmpfail_resend_time = time.Time{}
+	request.num_of_errors = 0
 	request.timedout = false
 	request.reservation = UninitializedReseverationNumber
 }
@@ -137,6 +262,12 @@ type requestBuffer struct {
 	logger           *log.CommonLogger
 	notifych_lock    sync.RWMutex
 	token_ch         chan int
+	// durability level of the requests sent out of the buffer. requests carry no durability requirement when it is none
+	durability_level byte
+	// whether the bodies of requests are compressed with snappy before they are sent out of the buffer
+	compress bool
+	// bodies smaller than this are not compressed
+	compression_threshold int
 }
 
 func newReqBuffer(size uint16, threshold uint16, token_ch chan int, logger *log.CommonLogger) *requestBuffer {
@@ -380,7 +511,7 @@ func (buf *requestBuffer) enSlot(mcreq *base.WrappedMCRequest) (uint16, int, []b
 	req.reservation = reservation_num
 	req.req = mcreq
 	buf.adjustRequest(mcreq, index)
-	item_bytes := mcreq.Req.Bytes()
+	item_bytes := buf.requestBytes(mcreq.Req)
 	now := time.Now()
 	req.sent_time = &now
 	buf.token_ch <- 1
@@ -397,6 +528,50 @@ func (buf *requestBuffer) adjustRequest(req *base.WrappedMCRequest, index uint16
 	mc_req.Opcode = encodeOpCode(mc_req.Opcode)
 	mc_req.Cas = 0
 	mc_req.Opaque = getOpaque(index, buf.sequences[int(index)])
+	if buf.compress {
+		compressRequest(mc_req, buf.compression_threshold)
+	}
+}
+
+// compresses the body of the request with snappy, and sets the snappy bit in its datatype, when the body is no
+// smaller than threshold. bodies that have already been compressed, e.g., when requests are resent, are left as they are,
+// and so are bodies that do not get smaller after compression
+func compressRequest(req *mc.MCRequest, threshold int) {
+	if req.DataType&base.SnappyDataType != 0 || len(req.Body) == 0 || len(req.Body) < threshold {
+		return
+	}
+	compressed := snappy.Encode(nil, req.Body)
+	if len(compressed) >= len(req.Body) {
+		return
+	}
+	req.Body = compressed
+	req.DataType |= base.SnappyDataType
+}
+
+// encodes the request, with durability requirement when durability level is set
+func (buf *requestBuffer) requestBytes(req *mc.MCRequest) []byte {
+	if buf.durability_level == base.DurabilityLevelNone {
+		return req.Bytes()
+	}
+	return durableRequestBytes(req, buf.durability_level)
+}
+
+// encodes the request with the alternative request magic, which allows a durability requirement frame to be
+// placed in the framing extras between header and extras. durability timeout is left to the default of target
+func durableRequestBytes(req *mc.MCRequest, durability_level byte) []byte {
+	req_bytes := req.Bytes()
+	frame := []byte{base.DURABILITY_FRAME_ID<<4 | 1, durability_level}
+
+	data := make([]byte, len(req_bytes)+len(frame))
+	copy(data, req_bytes[:mc.HDR_LEN])
+	data[0] = base.ALT_REQ_MAGIC
+	// key length is one byte in alternative requests, with framing extras length taking the other byte
+	data[2] = byte(len(frame))
+	data[3] = byte(len(req.Key))
+	binary.BigEndian.PutUint32(data[8:12], binary.BigEndian.Uint32(req_bytes[8:12])+uint32(len(frame)))
+	copy(data[mc.HDR_LEN:], frame)
+	copy(data[mc.HDR_LEN+len(frame):], req_bytes[mc.HDR_LEN:])
+	return data
 }
 
 func getOpaque(index, sequence uint16) uint32 {
@@ -433,6 +608,36 @@ type xmemConfig struct {
 	respTimeout        unsafe.Pointer // *time.Duration
 	max_read_downtime  time.Duration
 	logger             *log.CommonLogger
+	keyPrefix          []byte
+	keySuffix          []byte
+	// interval after which a partial batch is flushed. 0 disables the flush timer
+	flushInterval time.Duration
+	// time to wait for the response to a request before resending it. 0 means that respTimeout is used instead
+	opTimeout time.Duration
+	// additional time to wait for each KB of the body of the request, on top of opTimeout
+	opTimeoutPerKB time.Duration
+	// durability level of writes to target
+	durabilityLevel byte
+	// whether document bodies are compressed with snappy before they are sent to target
+	compress bool
+	// document bodies smaller than this (in bytes) are not compressed
+	compressionThreshold int
+	// how routers distribute documents to this nozzle and the other nozzles to the same target node
+	balanceMode string
+	// max total size of the bodies of the documents in a batch, in bytes. 0 disables the limit
+	batchSizeBytes int
+	// told about the documents whose source mutations lost conflict resolution
+	conflictLogger ConflictLogger
+	// HELLO features required by the required_features setting
+	requiredFeatures []uint16
+	// max number of bytes per second sent to target. 0 means unlimited
+	maxBytesPerSec int
+	// base and max wait time before resending a doc that got a retriable response. 0 retryBackoff means that
+	// only TMPFAIL responses are backed off, with default_tmpfail_backoff_time
+	retryBackoff    time.Duration
+	retryMaxBackoff time.Duration
+	// mutates or drops documents before they are sent. nil means that documents are sent as they are
+	transformer Transformer
 }
 
 func newConfig(logger *log.CommonLogger) xmemConfig {
@@ -448,14 +653,21 @@ func newConfig(logger *log.CommonLogger) xmemConfig {
 			username:            "",
 			password:            "",
 		},
-		bucketName:         "",
-		demandEncryption:   default_demandEncryption,
-		certificate:        []byte{},
-		remote_proxy_port:  0,
-		local_proxy_port:   0,
-		max_read_downtime:  default_max_read_downtime,
-		memcached_ssl_port: 0,
-		logger:             logger,
+		bucketName:           "",
+		demandEncryption:     default_demandEncryption,
+		certificate:          []byte{},
+		remote_proxy_port:    0,
+		local_proxy_port:     0,
+		max_read_downtime:    default_max_read_downtime,
+		memcached_ssl_port:   0,
+		logger:               logger,
+		keyPrefix:            []byte{},
+		keySuffix:            []byte{},
+		flushInterval:        default_flushInterval,
+		compressionThreshold: default_compressionThreshold,
+		balanceMode:          metadata.BalanceModeVbucket,
+		conflictLogger:       &noopConflictLogger{},
+		retryMaxBackoff:      max_tmpfail_backoff_time,
 	}
 
 	atomic.StoreUint32(&config.maxIdleCount, default_maxIdleCount)
@@ -471,6 +683,112 @@ func (config *xmemConfig) initializeConfig(settings map[string]interface{}) erro
 
 	if err == nil {
 		config.baseConfig.initializeConfig(settings)
+		if val, ok := settings[SETTING_RESP_TIMEOUT]; ok && val.(time.Duration) <= 0 {
+			return fmt.Errorf("%v needs to be positive. value=%v", SETTING_RESP_TIMEOUT, val)
+		}
+		if val, ok := settings[XMEM_SETTING_OP_TIMEOUT]; ok {
+			config.opTimeout, config.opTimeoutPerKB, err = parseOpTimeout(val)
+			if err != nil {
+				return err
+			}
+		}
+		if val, ok := settings[XMEM_SETTING_KEY_PREFIX]; ok {
+			config.keyPrefix = []byte(val.(string))
+		}
+		if val, ok := settings[XMEM_SETTING_KEY_SUFFIX]; ok {
+			config.keySuffix = []byte(val.(string))
+		}
+		if val, ok := settings[XMEM_SETTING_FLUSH_INTERVAL]; ok {
+			config.flushInterval = val.(time.Duration)
+		}
+		if val, ok := settings[XMEM_SETTING_TARGET_DURABILITY]; ok {
+			durabilityLevel, ok := durabilityLevels[val.(string)]
+			if !ok {
+				return fmt.Errorf("%v is not a valid value for %v", val, XMEM_SETTING_TARGET_DURABILITY)
+			}
+			config.durabilityLevel = durabilityLevel
+		}
+		if val, ok := settings[XMEM_SETTING_COMPRESSION]; ok {
+			switch val.(string) {
+			// specs created before the setting was introduced have an empty setting
+			case "", metadata.CompressionTypeNone:
+				config.compress = false
+			case metadata.CompressionTypeSnappy:
+				config.compress = true
+			default:
+				return fmt.Errorf("%v is not a valid value for %v", val, XMEM_SETTING_COMPRESSION)
+			}
+		}
+		if val, ok := settings[XMEM_SETTING_COMPRESSION_THRESHOLD]; ok {
+			if val.(int) < 0 {
+				return fmt.Errorf("%v cannot be negative. value=%v", XMEM_SETTING_COMPRESSION_THRESHOLD, val)
+			}
+			config.compressionThreshold = val.(int)
+		}
+		if val, ok := settings[XMEM_SETTING_BALANCE_MODE]; ok {
+			switch val.(string) {
+			// specs created before the setting was introduced have an empty setting
+			case "":
+			case metadata.BalanceModeVbucket, metadata.BalanceModeLeastLoaded:
+				config.balanceMode = val.(string)
+			default:
+				return fmt.Errorf("%v is not a valid value for %v", val, XMEM_SETTING_BALANCE_MODE)
+			}
+		}
+		if val, ok := settings[XMEM_SETTING_CONFLICT_LOGGER]; ok && val != nil {
+			conflictLogger, ok := val.(ConflictLogger)
+			if !ok {
+				return fmt.Errorf("%v needs to be a ConflictLogger. supplied type is %v", XMEM_SETTING_CONFLICT_LOGGER, reflect.TypeOf(val))
+			}
+			config.conflictLogger = conflictLogger
+		}
+		if val, ok := settings[XMEM_SETTING_TRANSFORMER]; ok && val != nil {
+			transformer, ok := val.(Transformer)
+			if !ok {
+				return fmt.Errorf("%v needs to be a Transformer. supplied type is %v", XMEM_SETTING_TRANSFORMER, reflect.TypeOf(val))
+			}
+			config.transformer = transformer
+		}
+		if val, ok := settings[XMEM_SETTING_BATCHSIZE_BYTES]; ok {
+			config.batchSizeBytes = val.(int)
+		}
+		if val, ok := settings[XMEM_SETTING_REQUIRED_FEATURES]; ok {
+			config.requiredFeatures, err = helloFeaturesFromNames(val.([]string))
+			if err != nil {
+				return err
+			}
+		}
+		if val, ok := settings[XMEM_SETTING_MAX_BYTES_PER_SEC]; ok {
+			if val.(int) < 0 {
+				return fmt.Errorf("%v cannot be negative. value=%v", XMEM_SETTING_MAX_BYTES_PER_SEC, val)
+			}
+			config.maxBytesPerSec = val.(int)
+		}
+		if val, ok := settings[XMEM_SETTING_RETRY_BACKOFF]; ok {
+			if val.(time.Duration) < 0 {
+				return fmt.Errorf("%v cannot be negative. value=%v", XMEM_SETTING_RETRY_BACKOFF, val)
+			}
+			config.retryBackoff = val.(time.Duration)
+		}
+		if val, ok := settings[XMEM_SETTING_RETRY_MAX_BACKOFF]; ok {
+			if val.(time.Duration) <= 0 {
+				return fmt.Errorf("%v needs to be positive. value=%v", XMEM_SETTING_RETRY_MAX_BACKOFF, val)
+			}
+			config.retryMaxBackoff = val.(time.Duration)
+		}
+		if config.retryBackoff > config.retryMaxBackoff {
+			return fmt.Errorf("%v cannot be larger than %v. %v=%v, %v=%v", XMEM_SETTING_RETRY_BACKOFF, XMEM_SETTING_RETRY_MAX_BACKOFF,
+				XMEM_SETTING_RETRY_BACKOFF, config.retryBackoff, XMEM_SETTING_RETRY_MAX_BACKOFF, config.retryMaxBackoff)
+		}
+		if config.maxCount < 0 {
+			return fmt.Errorf("%v cannot be negative. value=%v", SETTING_BATCHCOUNT, config.maxCount)
+		}
+		if config.batchSizeBytes < 0 {
+			return fmt.Errorf("%v cannot be negative. value=%v", XMEM_SETTING_BATCHSIZE_BYTES, config.batchSizeBytes)
+		}
+		if config.maxCount == 0 && config.batchSizeBytes == 0 {
+			return fmt.Errorf("At least one of %v and %v needs to be positive", SETTING_BATCHCOUNT, XMEM_SETTING_BATCHSIZE_BYTES)
+		}
 		if val, ok := settings[XMEM_SETTING_DEMAND_ENCRYPTION]; ok {
 			config.demandEncryption = val.(bool)
 		}
@@ -506,6 +824,14 @@ func (config *xmemConfig) initializeConfig(settings map[string]interface{}) erro
 	return err
 }
 
+// the number of documents that the data channel and the request buffer are sized for
+func (config *xmemConfig) bufferCount() int {
+	if config.maxCount > 0 {
+		return config.maxCount
+	}
+	return default_batchCount
+}
+
 /************************************
 /* struct xmemClient
 *************************************/
@@ -742,6 +1068,7 @@ type XmemNozzle struct {
 	receiver_finch    chan bool
 	checker_finch     chan bool
 	selfMonitor_finch chan bool
+	flusher_finch     chan bool
 
 	counter_sent     uint32
 	counter_received uint32
@@ -762,6 +1089,31 @@ type XmemNozzle struct {
 
 	// whether lww conflict resolution mode has been enabled
 	source_cr_mode base.ConflictResolutionMode
+
+	// number of temporary failure responses received from target
+	counter_tmpfail uint32
+
+	// vbs isolated because of repeated error responses from target, and the errors that caused the isolation
+	isolated_vbs      map[uint16]error
+	isolated_vbs_lock sync.RWMutex
+	// number of isolated vbs. allows docs to be checked against isolated_vbs without locking when there are none
+	num_of_isolated_vbs int32
+
+	// HELLO features that target enabled on the setMeta connection the last time they were negotiated
+	negotiated_features      []uint16
+	negotiated_features_lock sync.RWMutex
+
+	// caps the outbound throughput of the nozzle, and keeps track of it
+	bandwidth_limiter *bandwidthLimiter
+
+	// number of resends of docs by reason, i.e., the status of the response that caused the resend, or retry_reason_timeout
+	retry_counts      map[string]uint64
+	retry_counts_lock sync.Mutex
+
+	// 1 when the nozzle is being flushed by StopWithFlush, during which new docs are not accepted
+	flushing int32
+	// 1 while a batch taken off batches_ready_queue is being sent. its docs may be neither in dataChan nor in buf
+	sending_batch int32
 }
 
 func NewXmemNozzle(id string,
@@ -802,13 +1154,17 @@ func NewXmemNozzle(id string,
 		checker_finch:       make(chan bool, 1),
 		sender_finch:        make(chan bool, 1),
 		selfMonitor_finch:   make(chan bool, 1),
+		flusher_finch:       make(chan bool, 1),
 		counter_sent:        0,
 		counter_received:    0,
 		counter_waittime:    0,
 		counter_batches:     0,
 		dataObj_recycler:    dataObj_recycler,
 		topic:               topic,
-		source_cr_mode:      source_cr_mode}
+		isolated_vbs:        make(map[uint16]error),
+		source_cr_mode:      source_cr_mode,
+		bandwidth_limiter:   newBandwidthLimiter(0),
+		retry_counts:        make(map[string]uint64)}
 
 	initial_last_ten_batches_size := []uint32{0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
 	atomic.StorePointer(&xmem.last_ten_batches_size, unsafe.Pointer(&initial_last_ten_batches_size))
@@ -884,6 +1240,11 @@ func (xmem *XmemNozzle) Start(settings map[string]interface{}) error {
 	xmem.childrenWaitGrp.Add(1)
 	go xmem.processData_sendbatch(xmem.sender_finch, &xmem.childrenWaitGrp)
 
+	if xmem.config.flushInterval > 0 {
+		xmem.childrenWaitGrp.Add(1)
+		go xmem.flusher(xmem.flusher_finch, &xmem.childrenWaitGrp)
+	}
+
 	xmem.start_time = time.Now()
 	err = xmem.Start_server()
 	xmem.SetState(common.Part_Running)
@@ -925,6 +1286,76 @@ func (xmem *XmemNozzle) Stop() error {
 	return err
 }
 
+// stops accepting new docs, sends out the partial batch, and waits up to timeout for the responses to all the docs
+// that have been accepted, before stopping the nozzle. the nozzle is stopped even if the timeout elapses, in which case
+// an error listing the vbs with docs that have not been acknowledged by target is returned
+func (xmem *XmemNozzle) StopWithFlush(timeout time.Duration) error {
+	var flushErr error
+	if xmem.validateRunningState() == nil {
+		flushErr = xmem.flush(timeout)
+		if flushErr != nil {
+			xmem.Logger().Errorf("%v %v\n", xmem.Id(), flushErr)
+		}
+	}
+
+	err := xmem.Stop()
+	if flushErr != nil {
+		return flushErr
+	}
+	return err
+}
+
+func (xmem *XmemNozzle) flush(timeout time.Duration) error {
+	xmem.Logger().Infof("%v flushing outstanding docs. timeout=%v\n", xmem.Id(), timeout)
+	atomic.StoreInt32(&xmem.flushing, 1)
+
+	timeout_timer := time.NewTimer(timeout)
+	defer timeout_timer.Stop()
+	check_ticker := time.NewTicker(flush_check_interval)
+	defer check_ticker.Stop()
+	for {
+		// docs received concurrently with the start of the flush may be added to the batch after it has been flushed
+		xmem.flushPartialBatch()
+		if xmem.isFlushed() {
+			xmem.Logger().Infof("%v has been flushed\n", xmem.Id())
+			return nil
+		}
+
+		select {
+		case <-timeout_timer.C:
+			return fmt.Errorf("Failed to flush within %v. vbs with unacknowledged docs=%v, docs not sent yet=%v",
+				timeout, xmem.unacknowledgedVBs(), len(xmem.dataChan))
+		case <-check_ticker.C:
+			if xmem.validateRunningState() != nil {
+				return fmt.Errorf("Stopped flushing since nozzle is in %v state", xmem.State())
+			}
+		}
+	}
+}
+
+// whether all the docs that have been accepted have been acknowledged by target, or dropped
+func (xmem *XmemNozzle) isFlushed() bool {
+	return len(xmem.dataChan) == 0 && atomic.LoadInt32(&xmem.sending_batch) == 0 && xmem.buf.itemCountInBuffer() == 0
+}
+
+// vbs of the docs in the buffer, i.e., docs that have been sent and have not been acknowledged by target yet
+func (xmem *XmemNozzle) unacknowledgedVBs() []uint16 {
+	vbs := make([]uint16, 0)
+	if xmem.buf == nil {
+		return vbs
+	}
+	vbSet := make(map[uint16]bool)
+	for pos, _ := range xmem.buf.slots {
+		req, err := xmem.buf.slot(uint16(pos))
+		if err == nil && req != nil && !vbSet[req.Req.VBucket] {
+			vbSet[req.Req.VBucket] = true
+			vbs = append(vbs, req.Req.VBucket)
+		}
+	}
+	simple_utils.SortUint16List(vbs)
+	return vbs
+}
+
 func (xmem *XmemNozzle) batchReady() error {
 	defer func() {
 		if r := recover(); r != nil {
@@ -974,11 +1405,85 @@ func (xmem *XmemNozzle) Receive(data interface{}) error {
 
 	}
 
+	if xmem.isVBIsolated(request.Req.VBucket) {
+		// the doc is not counted as sent, and will be replicated again when the vb is resumed
+		xmem.recycleDataObj(request)
+		return nil
+	}
+
+	if atomic.LoadInt32(&xmem.flushing) == 1 {
+		// the doc is not counted as sent, and will be replicated again when the pipeline is restarted
+		xmem.recycleDataObj(request)
+		return nil
+	}
+
+	dropped, err := xmem.transformDoc(request)
+	if err != nil {
+		err = fmt.Errorf("Failed to transform document. key=%v, seqno=%v, vb=%v, err=%v", string(request.Req.Key), request.Seqno, request.Req.VBucket, err)
+		xmem.Logger().Errorf("%v %v", xmem.Id(), err)
+		xmem.handleGeneralError(err)
+		return err
+	}
+	if dropped {
+		xmem.RaiseEvent(common.NewEvent(common.DataFiltered, droppedDocEvent(request), xmem, nil, nil))
+		xmem.recycleDataObj(request)
+		return nil
+	}
+
+	xmem.transformKey(request)
 	xmem.accumuBatch(request)
 
 	return nil
 }
 
+// applies the configured transformer to the document. returns true if the document is to be dropped
+func (xmem *XmemNozzle) transformDoc(request *base.WrappedMCRequest) (bool, error) {
+	if xmem.config.transformer == nil {
+		return false, nil
+	}
+
+	req, drop, err := xmem.config.transformer.Transform(request.Req)
+	if err != nil || drop {
+		return drop, err
+	}
+	if req == nil {
+		return false, errors.New("Transformer returned nil request for document that is not dropped")
+	}
+	request.Req = req
+	return false, nil
+}
+
+// DataFiltered events carry the upr events of the documents filtered. dropped documents are described the same way,
+// so that through seqno tracker handles them as the documents filtered by routers
+func droppedDocEvent(request *base.WrappedMCRequest) *mcc.UprEvent {
+	// key is left out, since the request is recycled before the event is handled
+	event := &mcc.UprEvent{Opcode: mc.UPR_MUTATION,
+		VBucket: request.Req.VBucket,
+		Seqno:   request.Seqno}
+	if request.Req.Opcode == base.DELETE_WITH_META {
+		event.Opcode = mc.UPR_DELETION
+	}
+	if len(request.Req.Extras) >= 8 {
+		event.Expiry = binary.BigEndian.Uint32(request.Req.Extras[4:8])
+	}
+	return event
+}
+
+// add the configured prefix and suffix to the key of the document to be written to target.
+// the transformation applies to the requests sent to target only. UniqueKey, seqno, and hence
+// checkpoints and through seqnos, keep tracking the source document
+func (xmem *XmemNozzle) transformKey(request *base.WrappedMCRequest) {
+	if len(xmem.config.keyPrefix) == 0 && len(xmem.config.keySuffix) == 0 {
+		return
+	}
+
+	key := make([]byte, 0, len(xmem.config.keyPrefix)+len(request.Req.Key)+len(xmem.config.keySuffix))
+	key = append(key, xmem.config.keyPrefix...)
+	key = append(key, request.Req.Key...)
+	key = append(key, xmem.config.keySuffix...)
+	request.Req.Key = key
+}
+
 func (xmem *XmemNozzle) accumuBatch(request *base.WrappedMCRequest) {
 
 	if string(request.Req.Key) == "" {
@@ -1046,6 +1551,7 @@ func (xmem *XmemNozzle) processData_sendbatch(finch chan bool, waitGrp *sync.Wai
 				xmem.Logger().Infof("%v batches_ready_queue closed. Exiting processData_sendBatch.", xmem.Id())
 				goto done
 			}
+			atomic.StoreInt32(&xmem.sending_batch, 1)
 
 			if xmem.validateRunningState() != nil {
 				xmem.Logger().Infof("%v has stopped.", xmem.Id())
@@ -1069,6 +1575,7 @@ func (xmem *XmemNozzle) processData_sendbatch(finch chan bool, waitGrp *sync.Wai
 				xmem.handleGeneralError(err)
 			}
 			xmem.recordBatchSize(batch.count())
+			atomic.StoreInt32(&xmem.sending_batch, 0)
 		case <-xmem.getBatchNonEmptyCh():
 			if xmem.validateRunningState() != nil {
 				xmem.Logger().Infof("%v has stopped.", xmem.Id())
@@ -1091,6 +1598,42 @@ done:
 	return
 }
 
+// periodically moves the current batch to batches_ready_queue, even if it has not reached batch count,
+// so that documents do not sit in a partial batch for longer than flushInterval when traffic is light
+func (xmem *XmemNozzle) flusher(finch chan bool, waitGrp *sync.WaitGroup) {
+	defer waitGrp.Done()
+	ticker := time.NewTicker(xmem.config.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-finch:
+			goto done
+		case <-ticker.C:
+			if xmem.validateRunningState() != nil {
+				goto done
+			}
+			xmem.flushPartialBatch()
+		}
+	}
+done:
+	xmem.Logger().Infof("%v flusher routine exits", xmem.Id())
+}
+
+// moves the current batch, if not empty, to batches_ready_queue.
+// it never blocks, so as to avoid the deadlocks described in getBatchNonEmptyCh()
+func (xmem *XmemNozzle) flushPartialBatch() {
+	select {
+	case xmem.batch_lock <- true:
+		defer func() { <-xmem.batch_lock }()
+		if xmem.batch.count() > 0 && len(xmem.batches_ready_queue) < cap(xmem.batches_ready_queue) {
+			xmem.Logger().Debugf("%v flushing partial batch (count=%d)\n", xmem.Id(), xmem.batch.count())
+			xmem.batchReady()
+		}
+	default:
+		// batch is being worked on. try again at the next tick
+	}
+}
+
 func (xmem *XmemNozzle) processBatch(batch *dataBatch) error {
 	if xmem.IsOpen() {
 		xmem.buf.flowControl()
@@ -1108,6 +1651,7 @@ func (xmem *XmemNozzle) onExit() {
 	close(xmem.receiver_finch)
 	close(xmem.checker_finch)
 	close(xmem.selfMonitor_finch)
+	close(xmem.flusher_finch)
 
 	go xmem.finalCleanup()
 }
@@ -1283,10 +1827,14 @@ func resolveConflictByRevSeq(doc_meta_source documentMetadata,
 }
 
 func (xmem *XmemNozzle) sendWithRetry(client *xmemClient, numOfRetry int, item_byte []byte) error {
-	var err error
+	err := xmem.bandwidth_limiter.wait(len(item_byte), xmem.sender_finch)
+	if err != nil {
+		return err
+	}
 	for j := 0; j < numOfRetry; j++ {
 		err, rev := xmem.writeToClient(client, item_byte, true)
 		if err == nil {
+			xmem.bandwidth_limiter.recordSent(len(item_byte), time.Now())
 			return nil
 		} else if err == badConnectionError {
 			xmem.repairConn(client, err.Error(), rev)
@@ -1501,6 +2049,7 @@ func (xmem *XmemNozzle) batchGetMeta(bigDoc_map map[string]*base.WrappedMCReques
 					xmem.Logger().Debugf("%v doc %v failed source side conflict resolution. source meta=%v, target meta=%v. no need to send\n", xmem.Id(), key, doc_meta_source, doc_meta_target)
 				}
 				bigDoc_noRep_map[wrappedReq.UniqueKey] = true
+				xmem.config.conflictLogger.LogConflict(wrappedReq.Req.Key, doc_meta_source.cas, doc_meta_target.cas, wrappedReq.Req.VBucket)
 			} else if xmem.Logger().GetLogLevel() >= log.LogLevelDebug {
 				xmem.Logger().Debugf("%v doc %v succeeded source side conflict resolution. source meta=%v, target meta=%v. sending it to target\n", xmem.Id(), key, doc_meta_source, doc_meta_target)
 			}
@@ -1548,11 +2097,16 @@ func (xmem *XmemNozzle) sendSingleSetMeta(adjustRequest bool, item *base.Wrapped
 		if adjustRequest {
 			xmem.buf.adjustRequest(item, index)
 		}
-		bytes := item.Req.Bytes()
+		bytes := xmem.packageRequest(1, xmem.buf.requestBytes(item.Req))
+		err = xmem.bandwidth_limiter.wait(len(bytes), xmem.sender_finch)
+		if err != nil {
+			return err
+		}
 
 		for j := 0; j < numOfRetry; j++ {
-			err, rev := xmem.writeToClient(xmem.client_for_setMeta, xmem.packageRequest(1, bytes), true)
+			err, rev := xmem.writeToClient(xmem.client_for_setMeta, bytes, true)
 			if err == nil {
+				xmem.bandwidth_limiter.recordSent(len(bytes), time.Now())
 				return nil
 			} else if err == badConnectionError {
 				xmem.repairConn(xmem.client_for_setMeta, err.Error(), rev)
@@ -1623,6 +2177,12 @@ func (xmem *XmemNozzle) initializeConnection() (err error) {
 		return
 	}
 
+	err = xmem.negotiateFeatures(memClient_setMeta)
+	if err != nil {
+		memClient_setMeta.Close()
+		return
+	}
+
 	memClient_getMeta, err := pool.GetNew()
 	if err != nil {
 		return
@@ -1639,6 +2199,130 @@ func (xmem *XmemNozzle) initializeConnection() (err error) {
 	return err
 }
 
+// HELLO features needed by the settings of the nozzle, and the error to return when target does not enable them
+type helloFeatureRequirement struct {
+	feature uint16
+	err     error
+}
+
+func (xmem *XmemNozzle) requiredHelloFeatures() []helloFeatureRequirement {
+	required := make([]helloFeatureRequirement, 0)
+	if xmem.config.durabilityLevel != base.DurabilityLevelNone {
+		required = append(required, helloFeatureRequirement{base.HELLO_FEATURE_ALT_REQUEST, ErrorTargetDurabilityNotSupported},
+			helloFeatureRequirement{base.HELLO_FEATURE_SYNC_REPLICATION, ErrorTargetDurabilityNotSupported})
+	}
+	if xmem.config.compress {
+		required = append(required, helloFeatureRequirement{base.HELLO_FEATURE_SNAPPY, ErrorTargetCompressionNotSupported})
+	}
+	for _, feature := range xmem.config.requiredFeatures {
+		if !isHelloFeatureRequired(required, feature) {
+			required = append(required, helloFeatureRequirement{feature, targetFeatureNotSupportedError(feature)})
+		}
+	}
+	return required
+}
+
+func isHelloFeatureRequired(required []helloFeatureRequirement, feature uint16) bool {
+	for _, requirement := range required {
+		if requirement.feature == feature {
+			return true
+		}
+	}
+	return false
+}
+
+func targetFeatureNotSupportedError(feature uint16) error {
+	return fmt.Errorf("Target does not support feature %v, which is required by %v setting", base.HelloFeatureNames[feature], XMEM_SETTING_REQUIRED_FEATURES)
+}
+
+func helloFeaturesFromNames(names []string) ([]uint16, error) {
+	features := make([]uint16, 0, len(names))
+	for _, name := range names {
+		found := false
+		for feature, featureName := range base.HelloFeatureNames {
+			if name == featureName {
+				features = append(features, feature)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%v is not a valid feature in %v", name, XMEM_SETTING_REQUIRED_FEATURES)
+		}
+	}
+	return features, nil
+}
+
+// returns the HELLO features that target enabled on the connection for setMeta. it is empty when the nozzle needs
+// no feature, in which case no HELLO is sent
+func (xmem *XmemNozzle) GetNegotiatedFeatures() []uint16 {
+	xmem.negotiated_features_lock.RLock()
+	defer xmem.negotiated_features_lock.RUnlock()
+	features := make([]uint16, len(xmem.negotiated_features))
+	copy(features, xmem.negotiated_features)
+	return features
+}
+
+func (xmem *XmemNozzle) setNegotiatedFeatures(features []uint16) {
+	xmem.negotiated_features_lock.Lock()
+	defer xmem.negotiated_features_lock.Unlock()
+	xmem.negotiated_features = features
+}
+
+// enables durable writes and snappy compression on the connection through HELLO, as needed by the settings of the nozzle,
+// and verifies that target supports them. all features are requested in a single HELLO, since every HELLO replaces
+// the features enabled by the previous one. it is a no-op when no feature is needed
+func (xmem *XmemNozzle) negotiateFeatures(memClient *mcc.Client) error {
+	required := xmem.requiredHelloFeatures()
+	if len(required) == 0 {
+		return nil
+	}
+	if xmem.connType == base.SSLOverProxy {
+		// ssl over proxy is used only for targets older than 3.0, which support neither durable writes nor snappy
+		return required[0].err
+	}
+
+	conn := memClient.Hijack().(net.Conn)
+	conn.SetDeadline(time.Now().Add(default_getMeta_readTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	body := make([]byte, 2*len(required))
+	for i, requirement := range required {
+		binary.BigEndian.PutUint16(body[2*i:2*i+2], requirement.feature)
+	}
+	req := &mc.MCRequest{Opcode: base.HELLO, Key: []byte(xmem.Id()), Body: body}
+	if err := memClient.Transmit(req); err != nil {
+		return err
+	}
+	res, err := memClient.Receive()
+	if err != nil {
+		if res != nil && err == res {
+			// targets that do not know HELLO reject it
+			xmem.Logger().Errorf("%v target rejected HELLO. response=%v", xmem.Id(), res)
+			xmem.setNegotiatedFeatures(nil)
+			return required[0].err
+		}
+		return err
+	}
+
+	// response body contains the features that target has enabled
+	enabled_features := make(map[uint16]bool)
+	negotiated_features := make([]uint16, 0, len(res.Body)/2)
+	for i := 0; i+1 < len(res.Body); i += 2 {
+		feature := binary.BigEndian.Uint16(res.Body[i : i+2])
+		enabled_features[feature] = true
+		negotiated_features = append(negotiated_features, feature)
+	}
+	xmem.setNegotiatedFeatures(negotiated_features)
+	for _, requirement := range required {
+		if !enabled_features[requirement.feature] {
+			xmem.Logger().Errorf("%v target did not enable feature %v in HELLO. enabled features=%v", xmem.Id(), requirement.feature, enabled_features)
+			return requirement.err
+		}
+	}
+	return nil
+}
+
 func (xmem *XmemNozzle) getPoolName() string {
 	return xmem.config.connPoolNamePrefix + base.KeyPartsDelimiter + "Couch_Xmem_" + xmem.config.connectStr + base.KeyPartsDelimiter + xmem.config.bucketName
 }
@@ -1646,6 +2330,7 @@ func (xmem *XmemNozzle) getPoolName() string {
 func (xmem *XmemNozzle) initNewBatch() {
 	xmem.Logger().Debugf("%v initializing a new batch", xmem.Id())
 	xmem.batch = newBatch(uint32(xmem.config.maxCount), uint32(xmem.config.maxSize), xmem.Logger())
+	xmem.batch.capacity_body_size = uint32(xmem.config.batchSizeBytes)
 	atomic.StoreUint32(&xmem.cur_batch_count, 0)
 }
 
@@ -1654,7 +2339,8 @@ func (xmem *XmemNozzle) initialize(settings map[string]interface{}) error {
 	if err != nil {
 		return err
 	}
-	xmem.dataChan = make(chan *base.WrappedMCRequest, xmem.config.maxCount*10)
+	bufferCount := xmem.config.bufferCount()
+	xmem.dataChan = make(chan *base.WrappedMCRequest, bufferCount*10)
 	xmem.bytes_in_dataChan = 0
 	xmem.dataChan_control = make(chan bool, 1)
 	xmem.dataChan_control <- true
@@ -1667,8 +2353,12 @@ func (xmem *XmemNozzle) initialize(settings map[string]interface{}) error {
 	//init a new batch
 	xmem.initNewBatch()
 
-	xmem.receive_token_ch = make(chan int, xmem.config.maxCount*2)
-	xmem.buf = newReqBuffer(uint16(xmem.config.maxCount*2), uint16(float64(xmem.config.maxCount)*0.2), xmem.receive_token_ch, xmem.Logger())
+	xmem.receive_token_ch = make(chan int, bufferCount*2)
+	xmem.buf = newReqBuffer(uint16(bufferCount*2), uint16(float64(bufferCount)*0.2), xmem.receive_token_ch, xmem.Logger())
+	xmem.buf.durability_level = xmem.config.durabilityLevel
+	xmem.buf.compress = xmem.config.compress
+	xmem.bandwidth_limiter.setRate(xmem.config.maxBytesPerSec)
+	xmem.buf.compression_threshold = xmem.config.compressionThreshold
 
 	xmem.receiver_finch = make(chan bool, 1)
 	xmem.checker_finch = make(chan bool, 1)
@@ -1722,9 +2412,12 @@ func (xmem *XmemNozzle) receiveResponse(finch chan bool, waitGrp *sync.WaitGroup
 			} else if response == nil {
 				panic("readFromClient returned nil error and nil response")
 			} else if response.Status != mc.SUCCESS && !isIgnorableMCError(response.Status) {
-				if isTemporaryMCError(response.Status) {
+				if xmem.backsOffOn(response.Status) {
+					xmem.handleTmpfailResponse(response)
+				} else if isTemporaryMCError(response.Status) {
 					// target may be overloaded. increase backoff factor to alleviate stress on target
 					xmem.client_for_setMeta.incrementBackOffFactor()
+					xmem.recordRetry(response.Status.String())
 
 					// error is temporary. resend doc
 					pos := xmem.getPosFromOpaque(response.Opaque)
@@ -1757,7 +2450,10 @@ func (xmem *XmemNozzle) receiveResponse(finch chan bool, waitGrp *sync.WaitGroup
 								// this is an extremely rare scenario considering the fact that tombstones are kept for 7 days.
 								// make GOXDCR exhibit the same behavior as that of 3.x XDCR -> log the error and resend the doc
 								xmem.Logger().Errorf("%v received KEY_ENOENT error from setMeta client. response status=%v, opcode=%v, seqno=%v, req.Key=%v, req.Cas=%v, req.Extras=%v\n", xmem.Id(), response.Status, response.Opcode, seqno, string(req.Key), req.Cas, req.Extras)
+								xmem.recordRetry(response.Status.String())
 								_, err = xmem.buf.modSlot(pos, xmem.resendWithReset)
+							} else if xmem.checkVBIsolation(pos, req, response) {
+								xmem.Logger().Errorf("%v isolated vb %v after repeated error responses from setMeta client. response status=%v, opcode=%v, seqno=%v, req.Key=%v\n", xmem.Id(), req.VBucket, response.Status, response.Opcode, seqno, string(req.Key))
 							} else {
 								// for other non-temporary errors, repair connections
 								xmem.Logger().Errorf("%v received error response from setMeta client. Repairing connection. response status=%v, opcode=%v, seqno=%v, req.Key=%v, req.Cas=%v, req.Extras=%v\n", xmem.Id(), response.Status, response.Opcode, seqno, string(req.Key), req.Cas, req.Extras)
@@ -1791,6 +2487,8 @@ func (xmem *XmemNozzle) receiveResponse(finch chan bool, waitGrp *sync.WaitGroup
 				}
 
 				if req != nil && req.Opaque == response.Opaque {
+					xmem.logTargetConflict(req, response)
+
 					additionalInfo := DataSentEventAdditional{Seqno: seqno,
 						IsOptRepd:      xmem.optimisticRep(req),
 						Opcode:         req.Opcode,
@@ -1799,6 +2497,7 @@ func (xmem *XmemNozzle) receiveResponse(finch chan bool, waitGrp *sync.WaitGroup
 						Req_size:       req.Size(),
 						Commit_time:    committing_time,
 						Resp_wait_time: resp_wait_time,
+						IsDurable:      xmem.config.durabilityLevel != base.DurabilityLevelNone,
 					}
 					xmem.RaiseEvent(common.NewEvent(common.DataSent, nil, xmem, nil, additionalInfo))
 
@@ -1828,6 +2527,163 @@ done:
 	xmem.Logger().Infof("%v receiveResponse exits\n", xmem.Id())
 }
 
+// KEY_EEXISTS response to setWithMeta and deleteWithMeta means that the source mutation lost conflict resolution on target
+func (xmem *XmemNozzle) logTargetConflict(req *mc.MCRequest, response *mc.MCResponse) {
+	if response.Status != mc.KEY_EEXISTS || len(req.Extras) < 24 {
+		return
+	}
+	// req.Cas has been reset when the request was sent. source cas is in extras
+	sourceCas := binary.BigEndian.Uint64(req.Extras[16:24])
+	xmem.config.conflictLogger.LogConflict(req.Key, sourceCas, response.Cas, req.VBucket)
+}
+
+// target is temporarily unable to handle the request, e.g., when it is under memory pressure.
+// the request is resent by the checking routine after a backoff, instead of right away
+func (xmem *XmemNozzle) handleTmpfailResponse(response *mc.MCResponse) {
+	// target may be overloaded. increase backoff factor to alleviate stress on target
+	xmem.client_for_setMeta.incrementBackOffFactor()
+	if response.Status == mc.TMPFAIL {
+		atomic.AddUint32(&xmem.counter_tmpfail, 1)
+	}
+	xmem.recordRetry(response.Status.String())
+
+	retryHint := getTmpfailRetryHint(response)
+	pos := xmem.getPosFromOpaque(response.Opaque)
+	_, err := xmem.buf.modSlot(pos, func(req *bufferedMCRequest, p uint16) (bool, error) {
+		if req.req.Req.Opaque != response.Opaque {
+			// the response is for a request that no longer occupies the slot
+			return false, nil
+		}
+		req.num_of_tmpfails++
+		backoff := retryHint
+		if backoff <= 0 {
+			backoff = xmem.tmpfailBackoff(req.num_of_tmpfails)
+		}
+		req.tmpfail_resend_time = time.Now().Add(backoff)
+		// the target has responded. reset retry count, which is an indicator of network status
+		req.num_of_retry = 0
+		xmem.Logger().Debugf("%v Received retriable error in setMeta response. Resending after %v. response=%v\n", xmem.Id(), backoff, response)
+		return true, nil
+	})
+	if err != nil {
+		xmem.Logger().Errorf("%v Failed to schedule resend of doc that received temporary failure. err=%v\n", xmem.Id(), err)
+	}
+}
+
+// whether a doc that got a response with the status is resent after a backoff.
+// TMPFAIL always is. the other retriable statuses are only when retry_backoff is set
+func (xmem *XmemNozzle) backsOffOn(resp_status mc.Status) bool {
+	return resp_status == mc.TMPFAIL || (xmem.config.retryBackoff > 0 && isRetriableMCError(resp_status))
+}
+
+// wait time before resending a doc that has received num_of_tmpfails retriable responses.
+// when retry_backoff is set, it is retry_backoff*2^(num_of_tmpfails-1), capped at retry_max_backoff, with jitter.
+// otherwise the wait time grows with the backoff factor of the setMeta client, which reflects the load on target
+func (xmem *XmemNozzle) tmpfailBackoff(num_of_tmpfails int) time.Duration {
+	if xmem.config.retryBackoff > 0 {
+		return jitteredBackoff(xmem.config.retryBackoff, xmem.config.retryMaxBackoff, num_of_tmpfails)
+	}
+	backoff := default_tmpfail_backoff_time * time.Duration(xmem.client_for_setMeta.getBackOffFactor()+1)
+	for i := 1; i < num_of_tmpfails && backoff < max_tmpfail_backoff_time; i++ {
+		backoff *= 2
+	}
+	if backoff > max_tmpfail_backoff_time {
+		backoff = max_tmpfail_backoff_time
+	}
+	return backoff
+}
+
+// capped exponential backoff with "equal jitter", i.e., a random duration between half of and all of
+// baseBackoff*2^(num_of_retries-1), capped at maxBackoff
+func jitteredBackoff(baseBackoff, maxBackoff time.Duration, num_of_retries int) time.Duration {
+	backoff := baseBackoff
+	for i := 1; i < num_of_retries && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(backoff-half)+1))
+}
+
+// the json body of temporary failure responses may include a hint, in milliseconds, on when to retry,
+// e.g., {"error":{"context":"...","retry_after_ms":500}}. returns 0 if there is no valid hint
+func getTmpfailRetryHint(response *mc.MCResponse) time.Duration {
+	if len(response.Body) == 0 {
+		return 0
+	}
+
+	var body struct {
+		Error struct {
+			RetryAfterMs int64 `json:"retry_after_ms"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(response.Body, &body) != nil || body.Error.RetryAfterMs <= 0 {
+		return 0
+	}
+
+	hint := time.Duration(body.Error.RetryAfterMs) * time.Millisecond
+	if hint > max_tmpfail_backoff_time {
+		hint = max_tmpfail_backoff_time
+	}
+	return hint
+}
+
+// counts the non-temporary error response against the doc in slot pos. when the doc has failed too many times,
+// isolates its vb and drops the doc, and returns true.
+// the doc is not counted as sent, hence the checkpoints of the vb do not advance past it
+func (xmem *XmemNozzle) checkVBIsolation(pos uint16, req *mc.MCRequest, response *mc.MCResponse) bool {
+	var wrappedReq *base.WrappedMCRequest
+	_, err := xmem.buf.modSlot(pos, func(bufferedReq *bufferedMCRequest, p uint16) (bool, error) {
+		if bufferedReq.req.Req.Opaque != response.Opaque {
+			return false, nil
+		}
+		bufferedReq.num_of_errors++
+		if bufferedReq.num_of_errors >= max_errors_before_vb_isolation {
+			wrappedReq = bufferedReq.req
+		}
+		return true, nil
+	})
+	if err != nil || wrappedReq == nil {
+		return false
+	}
+
+	vb_err := fmt.Errorf("Doc with seqno %v received error response %v from target %v times", wrappedReq.Seqno, response.Status, max_errors_before_vb_isolation)
+	xmem.isolateVB(req.VBucket, vb_err)
+
+	if xmem.buf.evictSlot(pos) != nil {
+		panic(fmt.Sprintf("Failed to evict slot %d\n", pos))
+	}
+	xmem.recycleDataObj(wrappedReq)
+	return true
+}
+
+func (xmem *XmemNozzle) isolateVB(vbno uint16, err error) {
+	xmem.isolated_vbs_lock.Lock()
+	_, alreadyIsolated := xmem.isolated_vbs[vbno]
+	if !alreadyIsolated {
+		xmem.isolated_vbs[vbno] = err
+		atomic.AddInt32(&xmem.num_of_isolated_vbs, 1)
+	}
+	xmem.isolated_vbs_lock.Unlock()
+
+	if !alreadyIsolated {
+		additionalInfo := &base.VBErrorEventAdditional{vbno, err, base.VBErrorType_Isolated}
+		xmem.RaiseEvent(common.NewEvent(common.VBErrorEncountered, nil, xmem, nil, additionalInfo))
+	}
+}
+
+func (xmem *XmemNozzle) isVBIsolated(vbno uint16) bool {
+	if atomic.LoadInt32(&xmem.num_of_isolated_vbs) == 0 {
+		return false
+	}
+	xmem.isolated_vbs_lock.RLock()
+	defer xmem.isolated_vbs_lock.RUnlock()
+	_, ok := xmem.isolated_vbs[vbno]
+	return ok
+}
+
 func (xmem *XmemNozzle) handleVBError(vbno uint16, err error) {
 	additionalInfo := &base.VBErrorEventAdditional{vbno, err, base.VBErrorType_Target}
 	xmem.RaiseEvent(common.NewEvent(common.VBErrorEncountered, nil, xmem, nil, additionalInfo))
@@ -1875,6 +2731,19 @@ func isFatalMCError(resp_status mc.Status) bool {
 	}
 }
 
+// check if memcached response status indicates that target is short of resources, so that corresponding requests need to be
+// retried after a backoff. memcached reports ETMPFAIL with the TMPFAIL status code
+func isRetriableMCError(resp_status mc.Status) bool {
+	switch resp_status {
+	case mc.TMPFAIL:
+		fallthrough
+	case mc.ENOMEM:
+		return true
+	default:
+		return false
+	}
+}
+
 // check if memcached response status indicates error of temporary nature, which requires retrying corresponding requests
 func isTemporaryMCError(resp_status mc.Status) bool {
 	switch resp_status {
@@ -1885,6 +2754,16 @@ func isTemporaryMCError(resp_status mc.Status) bool {
 	case mc.EBUSY:
 		fallthrough
 	case mc.NOT_INITIALIZED:
+		fallthrough
+	// durable writes that could not be completed for now, e.g., when there are not enough replicas,
+	// or whose outcomes are unknown. resending them is safe since set_with_meta is idempotent
+	case base.DURABILITY_IMPOSSIBLE:
+		fallthrough
+	case base.SYNC_WRITE_IN_PROGRESS:
+		fallthrough
+	case base.SYNC_WRITE_AMBIGUOUS:
+		fallthrough
+	case base.SYNC_WRITE_RECOMMIT_IN_PROGRESS:
 		return true
 	default:
 		return false
@@ -1991,7 +2870,7 @@ func (xmem *XmemNozzle) selfMonitor(finch chan bool, waitGrp *sync.WaitGroup) {
 				goto done
 			}
 		case <-statsTicker.C:
-			xmem.RaiseEvent(common.NewEvent(common.StatsUpdate, nil, xmem, nil, []int{len(xmem.dataChan), xmem.bytesInDataChan()}))
+			xmem.RaiseEvent(common.NewEvent(common.StatsUpdate, nil, xmem, nil, []int{len(xmem.dataChan), xmem.bytesInDataChan(), int(atomic.LoadUint32(&xmem.counter_tmpfail))}))
 		}
 	}
 done:
@@ -2001,7 +2880,12 @@ done:
 
 func (xmem *XmemNozzle) check(finch chan bool, waitGrp *sync.WaitGroup) {
 	defer waitGrp.Done()
-	ticker := time.NewTicker(xmem.getRespTimeout())
+	checkInterval := xmem.getRespTimeout()
+	if xmem.config.opTimeout > 0 && xmem.config.opTimeout < checkInterval {
+		// check often enough to detect op timeouts in time
+		checkInterval = xmem.config.opTimeout
+	}
+	ticker := time.NewTicker(checkInterval)
 	defer ticker.Stop()
 	for {
 		select {
@@ -2032,6 +2916,14 @@ func (xmem *XmemNozzle) checkTimeout(req *bufferedMCRequest, pos uint16) (bool,
 		return false, nil
 	}
 
+	if !req.tmpfail_resend_time.IsZero() {
+		if time.Now().Before(req.tmpfail_resend_time) {
+			// still backing off after temporary failure
+			return false, nil
+		}
+		return xmem.resendAfterTmpfail(req, pos)
+	}
+
 	if req.num_of_retry > xmem.config.maxRetry {
 		req.timedout = true
 		err := errors.New(fmt.Sprintf("%v Failed to resend document %s, has tried to resend it %v, maximum retry %v reached",
@@ -2043,7 +2935,8 @@ func (xmem *XmemNozzle) checkTimeout(req *bufferedMCRequest, pos uint16) (bool,
 	}
 
 	respWaitTime := time.Since(*req.sent_time)
-	if respWaitTime > xmem.timeoutDuration(req.num_of_retry) {
+	if respWaitTime > xmem.timeoutDuration(req.num_of_retry, len(req.req.Req.Body)) {
+		xmem.recordRetry(retry_reason_timeout)
 		modified, err := xmem.resend(req, pos)
 
 		return modified, err
@@ -2051,8 +2944,44 @@ func (xmem *XmemNozzle) checkTimeout(req *bufferedMCRequest, pos uint16) (bool,
 	return false, nil
 }
 
-func (xmem *XmemNozzle) timeoutDuration(numofRetry int) time.Duration {
+// op_timeout can be a flat duration, for backward compatibility, or tiered by document size.
+// returns the base timeout and the increment per KB of document body
+func parseOpTimeout(val interface{}) (time.Duration, time.Duration, error) {
+	switch opTimeout := val.(type) {
+	case time.Duration:
+		if opTimeout <= 0 {
+			return 0, 0, fmt.Errorf("%v needs to be positive. value=%v", XMEM_SETTING_OP_TIMEOUT, val)
+		}
+		return opTimeout, 0, nil
+	case map[string]interface{}:
+		baseTimeout, ok := opTimeout[OP_TIMEOUT_BASE].(time.Duration)
+		if !ok || baseTimeout <= 0 {
+			return 0, 0, fmt.Errorf("%v of %v needs to be a positive duration. value=%v", OP_TIMEOUT_BASE, XMEM_SETTING_OP_TIMEOUT, val)
+		}
+		var perKB time.Duration
+		if perKBObj, ok := opTimeout[OP_TIMEOUT_PER_KB]; ok {
+			perKB, ok = perKBObj.(time.Duration)
+			if !ok || perKB < 0 {
+				return 0, 0, fmt.Errorf("%v of %v needs to be a non-negative duration. value=%v", OP_TIMEOUT_PER_KB, XMEM_SETTING_OP_TIMEOUT, val)
+			}
+		}
+		for key, _ := range opTimeout {
+			if key != OP_TIMEOUT_BASE && key != OP_TIMEOUT_PER_KB {
+				return 0, 0, fmt.Errorf("%v is not a valid key in %v. value=%v", key, XMEM_SETTING_OP_TIMEOUT, val)
+			}
+		}
+		return baseTimeout, perKB, nil
+	default:
+		return 0, 0, fmt.Errorf("%v needs to be a time.Duration or a map[string]interface{}. supplied type is %v", XMEM_SETTING_OP_TIMEOUT, reflect.TypeOf(val))
+	}
+}
+
+// time to wait for the response to a request with a body of bodySize bytes, before it is resent for the numofRetry+1 time
+func (xmem *XmemNozzle) timeoutDuration(numofRetry int, bodySize int) time.Duration {
 	duration := xmem.getRespTimeout()
+	if xmem.config.opTimeout > 0 {
+		duration = xmem.config.opTimeout + xmem.config.opTimeoutPerKB*time.Duration(bodySize)/1024
+	}
 	for i := 1; i <= numofRetry; i++ {
 		duration *= 2
 		if duration > xmem.config.maxRetryInterval {
@@ -2093,6 +3022,19 @@ func (xmem *XmemNozzle) resendWithReset(req *bufferedMCRequest, pos uint16) (boo
 
 }
 
+// resends a doc that has received temporary failure response, without counting it as a retry
+func (xmem *XmemNozzle) resendAfterTmpfail(req *bufferedMCRequest, pos uint16) (bool, error) {
+	req.tmpfail_resend_time = time.Time{}
+	err := xmem.sendSingleSetMeta(false, req.req, pos, xmem.config.maxRetry)
+	if err != nil {
+		req.err = err
+	} else {
+		now := time.Now()
+		req.sent_time = &now
+	}
+	return true, err
+}
+
 func (xmem *XmemNozzle) resendForNewConn(req *bufferedMCRequest, pos uint16) (bool, error) {
 	err := xmem.sendSingleSetMeta(false, req.req, pos, xmem.config.maxRetry)
 	if err != nil {
@@ -2132,7 +3074,7 @@ func (xmem *XmemNozzle) StatusSummary() string {
 		if counter_sent > 0 {
 			avg_wait_time = float64(atomic.LoadUint32(&xmem.counter_waittime)) / float64(counter_sent)
 		}
-		return fmt.Sprintf("%v state =%v connType=%v received %v items, sent %v items, %v items waiting to confirm, %v in queue, %v in current batch, avg wait time is %vms, size of last ten batches processed %v, len(batches_ready_queue)=%v\n", xmem.Id(), xmem.State(), connType, atomic.LoadUint32(&xmem.counter_received), atomic.LoadUint32(&xmem.counter_sent), xmem.buf.itemCountInBuffer(), len(xmem.dataChan), atomic.LoadUint32(&xmem.cur_batch_count), avg_wait_time, xmem.getLastTenBatchSize(), len(xmem.batches_ready_queue))
+		return fmt.Sprintf("%v state =%v connType=%v balanceMode=%v received %v items, sent %v items, %v items waiting to confirm, %v in queue, %v in current batch, avg wait time is %vms, size of last ten batches processed %v, len(batches_ready_queue)=%v\n", xmem.Id(), xmem.State(), connType, xmem.config.balanceMode, atomic.LoadUint32(&xmem.counter_received), atomic.LoadUint32(&xmem.counter_sent), xmem.buf.itemCountInBuffer(), len(xmem.dataChan), atomic.LoadUint32(&xmem.cur_batch_count), avg_wait_time, xmem.getLastTenBatchSize(), len(xmem.batches_ready_queue))
 	} else {
 		return fmt.Sprintf("%v state =%v ", xmem.Id(), xmem.State())
 	}
@@ -2282,6 +3224,12 @@ func (xmem *XmemNozzle) repairConn(client *xmemClient, reason string, rev int) e
 	backoffTime := default_newconn_backoff_time
 	for {
 		memClient, err := pool.GetNew()
+		if err == nil && client == xmem.client_for_setMeta {
+			err = xmem.negotiateFeatures(memClient)
+			if err != nil {
+				memClient.Close()
+			}
+		}
 
 		if err == nil {
 			repaired := client.repairConn(memClient, rev, xmem.Id())
@@ -2331,6 +3279,12 @@ func (xmem *XmemNozzle) ConnStr() string {
 	return xmem.config.connectStr
 }
 
+// number of requests queued in the nozzle that have not been sent to target yet.
+// routers in least-loaded balance mode route new vbuckets to the nozzle with the smallest queue depth
+func (xmem *XmemNozzle) GetQueueDepth() int {
+	return len(xmem.dataChan)
+}
+
 func (xmem *XmemNozzle) packageRequest(count int, reqs_bytes []byte) []byte {
 	if xmem.ConnType() == base.SSLOverProxy {
 		bytes := make([]byte, 8+len(reqs_bytes))
@@ -2344,14 +3298,50 @@ func (xmem *XmemNozzle) packageRequest(count int, reqs_bytes []byte) []byte {
 }
 
 func (xmem *XmemNozzle) UpdateSettings(settings map[string]interface{}) error {
-	optimisticReplicationThreshold, err := utils.GetIntSettingFromSettings(settings, metadata.OptimisticReplicationThreshold)
-	if err != nil {
-		return err
+	if utils.GetSettingFromSettings(settings, XMEM_SETTING_MAX_BYTES_PER_SEC) != nil {
+		maxBytesPerSec, err := utils.GetIntSettingFromSettings(settings, XMEM_SETTING_MAX_BYTES_PER_SEC)
+		if err != nil {
+			return err
+		}
+		if maxBytesPerSec < 0 {
+			return fmt.Errorf("%v cannot be negative. value=%v", XMEM_SETTING_MAX_BYTES_PER_SEC, maxBytesPerSec)
+		}
+		xmem.bandwidth_limiter.setRate(maxBytesPerSec)
+	}
+
+	if utils.GetSettingFromSettings(settings, metadata.OptimisticReplicationThreshold) != nil {
+		optimisticReplicationThreshold, err := utils.GetIntSettingFromSettings(settings, metadata.OptimisticReplicationThreshold)
+		if err != nil {
+			return err
+		}
+		atomic.StoreUint32(&xmem.config.optiRepThreshold, uint32(optimisticReplicationThreshold))
 	}
-	atomic.StoreUint32(&xmem.config.optiRepThreshold, uint32(optimisticReplicationThreshold))
 	return nil
 }
 
+// returns the average number of bytes per second that the nozzle has sent to target over the last few seconds
+func (xmem *XmemNozzle) GetCurrentThroughput() uint64 {
+	return xmem.bandwidth_limiter.throughput(time.Now())
+}
+
+func (xmem *XmemNozzle) recordRetry(reason string) {
+	xmem.retry_counts_lock.Lock()
+	defer xmem.retry_counts_lock.Unlock()
+	xmem.retry_counts[reason]++
+}
+
+// returns the number of resends of docs by reason, i.e., the status of the response that caused the resend,
+// e.g., "TMPFAIL" or "ENOMEM", or "TIMEOUT" when no response was received in time
+func (xmem *XmemNozzle) GetRetryStats() map[string]uint64 {
+	xmem.retry_counts_lock.Lock()
+	defer xmem.retry_counts_lock.Unlock()
+	stats := make(map[string]uint64)
+	for reason, count := range xmem.retry_counts {
+		stats[reason] = count
+	}
+	return stats
+}
+
 func (xmem *XmemNozzle) dataChanControl() {
 	if xmem.bytesInDataChan() < max_datachannelSize {
 		select {
@@ -0,0 +1,1021 @@
+// Copyright (c) 2013 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package parts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	mc "github.com/couchbase/gomemcached"
+	mcc "github.com/couchbase/gomemcached/client"
+	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/common"
+	"github.com/couchbase/goxdcr/log"
+	"github.com/couchbase/goxdcr/metadata"
+	"github.com/golang/snappy"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// constructs an xmem nozzle with batching set up, but without connections to target
+func newTestXmemNozzle(flushInterval time.Duration) *XmemNozzle {
+	xmem := NewXmemNozzle("testXmem", "testTopic", "", 0, "", "", "", nil, base.CRMode_RevId, log.DefaultLoggerContext)
+	xmem.config.maxCount = 500
+	xmem.config.maxSize = 2048
+	xmem.config.flushInterval = flushInterval
+	xmem.dataChan = make(chan *base.WrappedMCRequest, xmem.config.maxCount*10)
+	xmem.dataChan_control = make(chan bool, 1)
+	xmem.dataChan_control <- true
+	xmem.batches_ready_queue = make(chan *dataBatch, 100)
+	xmem.initNewBatch()
+	return xmem
+}
+
+func newTestRequest(index int) *base.WrappedMCRequest {
+	return &base.WrappedMCRequest{Seqno: uint64(index),
+		Req:       &mc.MCRequest{Opcode: base.SET_WITH_META, Key: []byte(fmt.Sprintf("key%v", index)), Body: []byte("body")},
+		UniqueKey: fmt.Sprintf("key%v", index)}
+}
+
+func TestFlushPartialBatchOnTrickle(t *testing.T) {
+	flushInterval := 100 * time.Millisecond
+	xmem := newTestXmemNozzle(flushInterval)
+
+	finch := make(chan bool)
+	waitGrp := &sync.WaitGroup{}
+	waitGrp.Add(1)
+	go xmem.flusher(finch, waitGrp)
+	defer func() {
+		close(finch)
+		waitGrp.Wait()
+	}()
+
+	// a slow trickle of documents, far below batch count
+	numOfDocs := 5
+	for i := 0; i < numOfDocs; i++ {
+		xmem.accumuBatch(newTestRequest(i))
+
+		select {
+		case batch := <-xmem.batches_ready_queue:
+			waitTime := time.Since(batch.start_time)
+			// allow some slack for scheduling
+			if waitTime > flushInterval+50*time.Millisecond {
+				t.Errorf("Partial batch waited %v before being flushed, expected at most %v", waitTime, flushInterval)
+			}
+			if batch.count() != 1 {
+				t.Errorf("Flushed batch has %v documents, expected 1", batch.count())
+			}
+		case <-time.After(3 * flushInterval):
+			t.Fatalf("Document %v was not flushed within %v", i, 3*flushInterval)
+		}
+	}
+}
+
+func TestFlushPartialBatchSkipsEmptyBatch(t *testing.T) {
+	xmem := newTestXmemNozzle(10 * time.Millisecond)
+
+	xmem.flushPartialBatch()
+	if len(xmem.batches_ready_queue) != 0 {
+		t.Errorf("Empty batch should not have been flushed")
+	}
+}
+
+// mock target that responds to the first numOfTmpfails requests with TMPFAIL and to the rest with SUCCESS
+func startMockTmpfailServer(t *testing.T, numOfTmpfails int, counter *int32) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start mock server. err=%v", err)
+	}
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			req := &mc.MCRequest{}
+			if _, err := req.Receive(conn, nil); err != nil {
+				return
+			}
+			status := mc.SUCCESS
+			if int(atomic.AddInt32(counter, 1)) <= numOfTmpfails {
+				status = mc.TMPFAIL
+			}
+			res := &mc.MCResponse{Opcode: req.Opcode, Opaque: req.Opaque, Status: status}
+			if _, err := res.Transmit(conn); err != nil {
+				return
+			}
+		}
+	}()
+	return listener
+}
+
+func TestResendAfterTmpfail(t *testing.T) {
+	var numOfRequests int32
+	listener := startMockTmpfailServer(t, 1, &numOfRequests)
+	defer listener.Close()
+
+	xmem := newTestXmemNozzle(0)
+	respTimeout := 50 * time.Millisecond
+	atomic.StorePointer(&xmem.config.respTimeout, unsafe.Pointer(&respTimeout))
+	xmem.receive_token_ch = make(chan int, xmem.config.maxCount*2)
+	xmem.buf = newReqBuffer(uint16(xmem.config.maxCount*2), uint16(float64(xmem.config.maxCount)*0.2), xmem.receive_token_ch, xmem.Logger())
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to mock server. err=%v", err)
+	}
+	memClient, err := mcc.Wrap(conn)
+	if err != nil {
+		t.Fatalf("Failed to create memcached client. err=%v", err)
+	}
+	xmem.client_for_setMeta = newXmemClient("client_setMeta", xmem.config.readTimeout, xmem.config.writeTimeout, memClient,
+		xmem.config.maxRetry, xmem.config.max_read_downtime, xmem.Logger())
+
+	finch := make(chan bool)
+	waitGrp := &sync.WaitGroup{}
+	waitGrp.Add(2)
+	go xmem.receiveResponse(finch, waitGrp)
+	go xmem.check(finch, waitGrp)
+	defer func() {
+		// stop the nozzle first so that closing the connection does not trigger connection repair
+		xmem.SetState(common.Part_Stopping)
+		close(finch)
+		memClient.Close()
+		waitGrp.Wait()
+	}()
+
+	req := newTestRequest(0)
+	req.Req.Extras = make([]byte, 24)
+	start_time := time.Now()
+	_, _, item_bytes := xmem.buf.enSlot(req)
+	if err, _ = xmem.writeToClient(xmem.client_for_setMeta, xmem.packageRequest(1, item_bytes), true); err != nil {
+		t.Fatalf("Failed to send request. err=%v", err)
+	}
+
+	// the doc is resent after backoff and acknowledged by target
+	deadline := time.Now().Add(3 * time.Second)
+	for xmem.buf.itemCountInBuffer() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Doc was not acknowledged after temporary failure. requests received by target=%v", atomic.LoadInt32(&numOfRequests))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if elapsed := time.Since(start_time); elapsed < default_tmpfail_backoff_time {
+		t.Errorf("Doc was resent after %v, expected backoff of at least %v", elapsed, default_tmpfail_backoff_time)
+	}
+	if count := atomic.LoadUint32(&xmem.counter_tmpfail); count != 1 {
+		t.Errorf("Temporary failure counter is %v, expected 1", count)
+	}
+	if count := atomic.LoadInt32(&numOfRequests); count != 2 {
+		t.Errorf("Target received %v requests, expected 2", count)
+	}
+}
+
+func TestTmpfailRetryHint(t *testing.T) {
+	inputs := map[string]time.Duration{
+		"":                                       0,
+		"not json":                               0,
+		`{"error":{"context":"no hint"}}`:        0,
+		`{"error":{"retry_after_ms":-1}}`:        0,
+		`{"error":{"retry_after_ms":500}}`:       500 * time.Millisecond,
+		`{"error":{"retry_after_ms":100000000}}`: max_tmpfail_backoff_time,
+	}
+	for body, expected := range inputs {
+		hint := getTmpfailRetryHint(&mc.MCResponse{Status: mc.TMPFAIL, Body: []byte(body)})
+		if hint != expected {
+			t.Errorf("Retry hint from body %q is %v, expected %v", body, hint, expected)
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	settings := map[string]interface{}{SETTING_BATCHCOUNT: 500,
+		SETTING_BATCHSIZE:          2048,
+		SETTING_OPTI_REP_THRESHOLD: 256}
+
+	// without retry_backoff, only TMPFAIL is backed off, as before
+	xmem := newTestXmemNozzle(0)
+	if err := xmem.config.initializeConfig(settings); err != nil {
+		t.Fatalf("Unexpected error initializing config. err=%v", err)
+	}
+	if !xmem.backsOffOn(mc.TMPFAIL) || xmem.backsOffOn(mc.ENOMEM) || xmem.backsOffOn(mc.EBUSY) {
+		t.Errorf("Unexpected backoff statuses without %v", XMEM_SETTING_RETRY_BACKOFF)
+	}
+
+	settings[XMEM_SETTING_RETRY_BACKOFF] = 50 * time.Millisecond
+	settings[XMEM_SETTING_RETRY_MAX_BACKOFF] = 300 * time.Millisecond
+	xmem = newTestXmemNozzle(0)
+	if err := xmem.config.initializeConfig(settings); err != nil {
+		t.Fatalf("Unexpected error initializing config. err=%v", err)
+	}
+	if !xmem.backsOffOn(mc.TMPFAIL) || !xmem.backsOffOn(mc.ENOMEM) || xmem.backsOffOn(mc.EBUSY) || xmem.backsOffOn(mc.EINVAL) {
+		t.Errorf("Unexpected backoff statuses with %v", XMEM_SETTING_RETRY_BACKOFF)
+	}
+	// backoff doubles with each retriable response, up to the cap, with jitter of up to half of it
+	expected := []time.Duration{50 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}
+	for index, max := range expected {
+		for i := 0; i < 20; i++ {
+			if backoff := xmem.tmpfailBackoff(index + 1); backoff < max/2 || backoff > max {
+				t.Errorf("Backoff after %v retriable responses is %v, expected between %v and %v", index+1, backoff, max/2, max)
+			}
+		}
+	}
+
+	// retriable responses are counted by reason, and the doc is scheduled to be resent after backoff
+	xmem.client_for_setMeta = newXmemClient("client_setMeta", xmem.config.readTimeout, xmem.config.writeTimeout, nil,
+		xmem.config.maxRetry, xmem.config.max_read_downtime, xmem.Logger())
+	xmem.receive_token_ch = make(chan int, xmem.config.maxCount*2)
+	xmem.buf = newReqBuffer(uint16(xmem.config.maxCount*2), uint16(float64(xmem.config.maxCount)*0.2), xmem.receive_token_ch, xmem.Logger())
+	req := newTestRequest(0)
+	pos, _, _ := xmem.buf.enSlot(req)
+	for _, status := range []mc.Status{mc.ENOMEM, mc.TMPFAIL, mc.ENOMEM} {
+		xmem.handleTmpfailResponse(&mc.MCResponse{Opcode: req.Req.Opcode, Opaque: req.Req.Opaque, Status: status})
+	}
+	xmem.recordRetry(retry_reason_timeout)
+	bufferedReq, err := xmem.buf.slot(pos)
+	if err != nil || bufferedReq == nil {
+		t.Fatalf("Doc is no longer in buffer. err=%v", err)
+	}
+	if resend_time := xmem.buf.slots[pos].tmpfail_resend_time; resend_time.IsZero() {
+		t.Errorf("Doc was not scheduled to be resent after backoff")
+	}
+	stats := xmem.GetRetryStats()
+	expectedStats := map[string]uint64{"ENOMEM": 2, "TMPFAIL": 1, retry_reason_timeout: 1}
+	if !reflect.DeepEqual(stats, expectedStats) {
+		t.Errorf("Retry stats are %v, expected %v", stats, expectedStats)
+	}
+	// only TMPFAIL counts towards the temporary failure counter
+	if count := atomic.LoadUint32(&xmem.counter_tmpfail); count != 1 {
+		t.Errorf("Temporary failure counter is %v, expected 1", count)
+	}
+
+	invalid := []map[string]interface{}{
+		{XMEM_SETTING_RETRY_BACKOFF: -time.Second},
+		{XMEM_SETTING_RETRY_MAX_BACKOFF: time.Duration(0)},
+		{XMEM_SETTING_RETRY_BACKOFF: 2 * time.Second, XMEM_SETTING_RETRY_MAX_BACKOFF: time.Second},
+	}
+	for _, retrySettings := range invalid {
+		invalidSettings := map[string]interface{}{SETTING_BATCHCOUNT: 500,
+			SETTING_BATCHSIZE:          2048,
+			SETTING_OPTI_REP_THRESHOLD: 256}
+		for key, value := range retrySettings {
+			invalidSettings[key] = value
+		}
+		if err := newTestXmemNozzle(0).config.initializeConfig(invalidSettings); err == nil {
+			t.Errorf("Expected error for %v", retrySettings)
+		}
+	}
+}
+
+func TestVBIsolationAfterRepeatedErrors(t *testing.T) {
+	xmem := newTestXmemNozzle(0)
+	xmem.receive_token_ch = make(chan int, xmem.config.maxCount*2)
+	xmem.buf = newReqBuffer(uint16(xmem.config.maxCount*2), uint16(float64(xmem.config.maxCount)*0.2), xmem.receive_token_ch, xmem.Logger())
+
+	req := newTestRequest(0)
+	req.Req.VBucket = 7
+	pos, _, _ := xmem.buf.enSlot(req)
+	response := &mc.MCResponse{Opcode: req.Req.Opcode, Opaque: req.Req.Opaque, Status: mc.EINVAL}
+
+	for i := 1; i < max_errors_before_vb_isolation; i++ {
+		if xmem.checkVBIsolation(pos, req.Req, response) {
+			t.Fatalf("vb was isolated after %v errors, expected %v", i, max_errors_before_vb_isolation)
+		}
+	}
+	if xmem.isVBIsolated(req.Req.VBucket) {
+		t.Fatalf("vb was isolated before reaching error threshold")
+	}
+
+	if !xmem.checkVBIsolation(pos, req.Req, response) {
+		t.Fatalf("vb was not isolated after %v errors", max_errors_before_vb_isolation)
+	}
+	if !xmem.isVBIsolated(req.Req.VBucket) {
+		t.Errorf("vb %v is not marked as isolated", req.Req.VBucket)
+	}
+	if xmem.isVBIsolated(req.Req.VBucket + 1) {
+		t.Errorf("vb %v is marked as isolated, expected only vb %v", req.Req.VBucket+1, req.Req.VBucket)
+	}
+	if count := xmem.buf.itemCountInBuffer(); count != 0 {
+		t.Errorf("Failed doc was not evicted from buffer. items in buffer=%v", count)
+	}
+}
+
+func TestOpTimeout(t *testing.T) {
+	settings := map[string]interface{}{SETTING_BATCHCOUNT: 500,
+		SETTING_BATCHSIZE:          2048,
+		SETTING_OPTI_REP_THRESHOLD: 256}
+
+	// response timeout adapts to response times when op timeout is not set
+	xmem := newTestXmemNozzle(0)
+	if err := xmem.config.initializeConfig(settings); err != nil {
+		t.Fatalf("Unexpected error initializing config. err=%v", err)
+	}
+	xmem.adjustRespTimeout(20 * time.Millisecond)
+	if timeout := xmem.timeoutDuration(0, 0); timeout != 20*time.Millisecond {
+		t.Errorf("Timeout is %v, expected adapted response timeout %v", timeout, 20*time.Millisecond)
+	}
+
+	// op timeout is fixed
+	settings[XMEM_SETTING_OP_TIMEOUT] = 2 * time.Second
+	xmem = newTestXmemNozzle(0)
+	if err := xmem.config.initializeConfig(settings); err != nil {
+		t.Fatalf("Unexpected error initializing config. err=%v", err)
+	}
+	xmem.adjustRespTimeout(20 * time.Millisecond)
+	if timeout := xmem.timeoutDuration(0, 0); timeout != 2*time.Second {
+		t.Errorf("Timeout is %v, expected op timeout %v", timeout, 2*time.Second)
+	}
+	if timeout := xmem.timeoutDuration(1, 0); timeout != 4*time.Second {
+		t.Errorf("Timeout after one retry is %v, expected %v", timeout, 4*time.Second)
+	}
+
+	for _, key := range []string{SETTING_RESP_TIMEOUT, XMEM_SETTING_OP_TIMEOUT} {
+		for _, value := range []time.Duration{0, -time.Second} {
+			invalidSettings := map[string]interface{}{SETTING_BATCHCOUNT: 500,
+				SETTING_BATCHSIZE:          2048,
+				SETTING_OPTI_REP_THRESHOLD: 256,
+				key:                        value}
+			if err := newTestXmemNozzle(0).config.initializeConfig(invalidSettings); err == nil {
+				t.Errorf("Expected error for %v=%v", key, value)
+			}
+		}
+	}
+}
+
+func TestTieredOpTimeout(t *testing.T) {
+	settings := map[string]interface{}{SETTING_BATCHCOUNT: 500,
+		SETTING_BATCHSIZE:          2048,
+		SETTING_OPTI_REP_THRESHOLD: 256,
+		XMEM_SETTING_OP_TIMEOUT:    map[string]interface{}{OP_TIMEOUT_BASE: time.Second, OP_TIMEOUT_PER_KB: 100 * time.Millisecond}}
+
+	xmem := newTestXmemNozzle(0)
+	if err := xmem.config.initializeConfig(settings); err != nil {
+		t.Fatalf("Unexpected error initializing config. err=%v", err)
+	}
+	for bodySize, expected := range map[int]time.Duration{0: time.Second, 512: 1050 * time.Millisecond, 20 * 1024: 3 * time.Second} {
+		if timeout := xmem.timeoutDuration(0, bodySize); timeout != expected {
+			t.Errorf("Timeout for body of %v bytes is %v, expected %v", bodySize, timeout, expected)
+		}
+	}
+	if timeout := xmem.timeoutDuration(1, 20*1024); timeout != 6*time.Second {
+		t.Errorf("Timeout after one retry is %v, expected %v", timeout, 6*time.Second)
+	}
+
+	// per KB increment is optional
+	settings[XMEM_SETTING_OP_TIMEOUT] = map[string]interface{}{OP_TIMEOUT_BASE: time.Second}
+	xmem = newTestXmemNozzle(0)
+	if err := xmem.config.initializeConfig(settings); err != nil {
+		t.Fatalf("Unexpected error initializing config. err=%v", err)
+	}
+	if timeout := xmem.timeoutDuration(0, 20*1024); timeout != time.Second {
+		t.Errorf("Timeout is %v, expected %v", timeout, time.Second)
+	}
+
+	for _, value := range []interface{}{
+		map[string]interface{}{OP_TIMEOUT_PER_KB: time.Second},
+		map[string]interface{}{OP_TIMEOUT_BASE: 0 * time.Second},
+		map[string]interface{}{OP_TIMEOUT_BASE: time.Second, OP_TIMEOUT_PER_KB: -time.Second},
+		map[string]interface{}{OP_TIMEOUT_BASE: 1000},
+		map[string]interface{}{OP_TIMEOUT_BASE: time.Second, "per_mb": time.Second},
+		"1s",
+	} {
+		settings[XMEM_SETTING_OP_TIMEOUT] = value
+		if err := newTestXmemNozzle(0).config.initializeConfig(settings); err == nil {
+			t.Errorf("Expected error for %v=%v", XMEM_SETTING_OP_TIMEOUT, value)
+		}
+	}
+}
+
+func TestDurableRequestBytes(t *testing.T) {
+	req := newTestRequest(0)
+	req.Req.Extras = make([]byte, 24)
+	req.Req.VBucket = 7
+	req.Req.Opaque = 11
+	plain := req.Req.Bytes()
+
+	data := durableRequestBytes(req.Req, base.DurabilityLevelPersistToMajority)
+	if len(data) != len(plain)+2 {
+		t.Fatalf("Durable request has %v bytes, expected %v", len(data), len(plain)+2)
+	}
+	if data[0] != base.ALT_REQ_MAGIC {
+		t.Errorf("Magic is %x, expected %x", data[0], base.ALT_REQ_MAGIC)
+	}
+	if int(data[2]) != 2 || int(data[3]) != len(req.Req.Key) {
+		t.Errorf("Framing extras length is %v and key length is %v, expected 2 and %v", data[2], data[3], len(req.Req.Key))
+	}
+	if bodyLen := binary.BigEndian.Uint32(data[8:12]); bodyLen != binary.BigEndian.Uint32(plain[8:12])+2 {
+		t.Errorf("Body length is %v, expected %v", bodyLen, binary.BigEndian.Uint32(plain[8:12])+2)
+	}
+	if !bytes.Equal(data[mc.HDR_LEN:mc.HDR_LEN+2], []byte{0x11, base.DurabilityLevelPersistToMajority}) {
+		t.Errorf("Framing extras are %v, expected durability requirement frame", data[mc.HDR_LEN:mc.HDR_LEN+2])
+	}
+	// the rest of header and the extras, key and body are the same as those of the plain request
+	if data[1] != plain[1] || !bytes.Equal(data[4:8], plain[4:8]) || !bytes.Equal(data[12:mc.HDR_LEN], plain[12:mc.HDR_LEN]) {
+		t.Errorf("Header of durable request %v does not match that of plain request %v", data[:mc.HDR_LEN], plain[:mc.HDR_LEN])
+	}
+	if !bytes.Equal(data[mc.HDR_LEN+2:], plain[mc.HDR_LEN:]) {
+		t.Errorf("Body of durable request does not match that of plain request")
+	}
+
+	buf := newReqBuffer(10, 2, make(chan int, 20), log.NewLogger("testBuffer", log.DefaultLoggerContext))
+	if !bytes.Equal(buf.requestBytes(req.Req), plain) {
+		t.Errorf("Request is encoded with durability requirement when durability level is none")
+	}
+}
+
+// mock target that responds to HELLO with the given features enabled, or with the given error status
+func startMockHelloServer(t *testing.T, features []uint16, status mc.Status) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start mock server. err=%v", err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			req := &mc.MCRequest{}
+			if _, err := req.Receive(conn, nil); err == nil && req.Opcode == base.HELLO {
+				body := make([]byte, 2*len(features))
+				for i, feature := range features {
+					binary.BigEndian.PutUint16(body[2*i:2*i+2], feature)
+				}
+				res := &mc.MCResponse{Opcode: req.Opcode, Opaque: req.Opaque, Status: status, Body: body}
+				res.Transmit(conn)
+			}
+			conn.Close()
+		}
+	}()
+	return listener
+}
+
+func TestNegotiateDurability(t *testing.T) {
+	tests := []struct {
+		features []uint16
+		status   mc.Status
+		expected error
+	}{
+		{[]uint16{base.HELLO_FEATURE_ALT_REQUEST, base.HELLO_FEATURE_SYNC_REPLICATION}, mc.SUCCESS, nil},
+		{[]uint16{base.HELLO_FEATURE_ALT_REQUEST}, mc.SUCCESS, ErrorTargetDurabilityNotSupported},
+		{nil, mc.UNKNOWN_COMMAND, ErrorTargetDurabilityNotSupported},
+	}
+
+	for _, test := range tests {
+		listener := startMockHelloServer(t, test.features, test.status)
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect to mock server. err=%v", err)
+		}
+		memClient, err := mcc.Wrap(conn)
+		if err != nil {
+			t.Fatalf("Failed to create memcached client. err=%v", err)
+		}
+
+		xmem := newTestXmemNozzle(0)
+		xmem.config.durabilityLevel = base.DurabilityLevelMajority
+		if err = xmem.negotiateFeatures(memClient); err != test.expected {
+			t.Errorf("Negotiation with target enabling features %v and responding with %v returned %v, expected %v",
+				test.features, test.status, err, test.expected)
+		}
+		memClient.Close()
+		listener.Close()
+	}
+
+	// nothing is negotiated when there is no durability requirement
+	if err := newTestXmemNozzle(0).negotiateFeatures(nil); err != nil {
+		t.Errorf("Negotiation without durability requirement returned %v", err)
+	}
+}
+
+func TestNegotiateCompression(t *testing.T) {
+	tests := []struct {
+		durabilityLevel byte
+		features        []uint16
+		status          mc.Status
+		expected        error
+	}{
+		{base.DurabilityLevelNone, []uint16{base.HELLO_FEATURE_SNAPPY}, mc.SUCCESS, nil},
+		{base.DurabilityLevelNone, nil, mc.SUCCESS, ErrorTargetCompressionNotSupported},
+		{base.DurabilityLevelNone, nil, mc.UNKNOWN_COMMAND, ErrorTargetCompressionNotSupported},
+		// durability and compression are negotiated together
+		{base.DurabilityLevelMajority, []uint16{base.HELLO_FEATURE_ALT_REQUEST, base.HELLO_FEATURE_SYNC_REPLICATION, base.HELLO_FEATURE_SNAPPY}, mc.SUCCESS, nil},
+		{base.DurabilityLevelMajority, []uint16{base.HELLO_FEATURE_ALT_REQUEST, base.HELLO_FEATURE_SYNC_REPLICATION}, mc.SUCCESS, ErrorTargetCompressionNotSupported},
+		{base.DurabilityLevelMajority, []uint16{base.HELLO_FEATURE_SNAPPY}, mc.SUCCESS, ErrorTargetDurabilityNotSupported},
+	}
+
+	for _, test := range tests {
+		listener := startMockHelloServer(t, test.features, test.status)
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect to mock server. err=%v", err)
+		}
+		memClient, err := mcc.Wrap(conn)
+		if err != nil {
+			t.Fatalf("Failed to create memcached client. err=%v", err)
+		}
+
+		xmem := newTestXmemNozzle(0)
+		xmem.config.durabilityLevel = test.durabilityLevel
+		xmem.config.compress = true
+		if err = xmem.negotiateFeatures(memClient); err != test.expected {
+			t.Errorf("Negotiation with durability level %v and target enabling features %v and responding with %v returned %v, expected %v",
+				test.durabilityLevel, test.features, test.status, err, test.expected)
+		}
+		memClient.Close()
+		listener.Close()
+	}
+}
+
+func TestNegotiateRequiredFeatures(t *testing.T) {
+	settings := map[string]interface{}{SETTING_BATCHCOUNT: 500,
+		SETTING_BATCHSIZE:              2048,
+		SETTING_OPTI_REP_THRESHOLD:     256,
+		XMEM_SETTING_REQUIRED_FEATURES: []string{"xattr", "json"}}
+	tests := []struct {
+		features []uint16
+		missing  string
+	}{
+		{[]uint16{base.HELLO_FEATURE_XATTR, base.HELLO_FEATURE_JSON}, ""},
+		{[]uint16{base.HELLO_FEATURE_JSON}, "xattr"},
+	}
+	for _, test := range tests {
+		listener := startMockHelloServer(t, test.features, mc.SUCCESS)
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect to mock server. err=%v", err)
+		}
+		memClient, err := mcc.Wrap(conn)
+		if err != nil {
+			t.Fatalf("Failed to create memcached client. err=%v", err)
+		}
+
+		xmem := newTestXmemNozzle(0)
+		if err = xmem.config.initializeConfig(settings); err != nil {
+			t.Fatalf("Unexpected error initializing config. err=%v", err)
+		}
+		err = xmem.negotiateFeatures(memClient)
+		if test.missing == "" && err != nil {
+			t.Errorf("Negotiation with target enabling features %v returned %v", test.features, err)
+		} else if test.missing != "" && (err == nil || !strings.Contains(err.Error(), test.missing)) {
+			t.Errorf("Negotiation with target enabling features %v returned %v, expected error naming %v", test.features, err, test.missing)
+		}
+		// features enabled by target are recorded even when some required features are missing
+		if negotiated := xmem.GetNegotiatedFeatures(); !reflect.DeepEqual(negotiated, test.features) {
+			t.Errorf("Negotiated features are %v, expected %v", negotiated, test.features)
+		}
+		memClient.Close()
+		listener.Close()
+	}
+
+	settings[XMEM_SETTING_REQUIRED_FEATURES] = []string{"invalid"}
+	if err := newTestXmemNozzle(0).config.initializeConfig(settings); err == nil {
+		t.Errorf("Expected error for invalid required feature")
+	}
+}
+
+func TestCompressionSetting(t *testing.T) {
+	settings := map[string]interface{}{SETTING_BATCHCOUNT: 500,
+		SETTING_BATCHSIZE:          2048,
+		SETTING_OPTI_REP_THRESHOLD: 256}
+	xmem := newTestXmemNozzle(0)
+	if err := xmem.config.initializeConfig(settings); err != nil {
+		t.Fatalf("Unexpected error initializing config. err=%v", err)
+	}
+	if xmem.config.compress || xmem.config.compressionThreshold != default_compressionThreshold {
+		t.Errorf("Compression is %v with threshold %v by default, expected no compression with threshold %v",
+			xmem.config.compress, xmem.config.compressionThreshold, default_compressionThreshold)
+	}
+
+	settings[XMEM_SETTING_COMPRESSION] = metadata.CompressionTypeSnappy
+	settings[XMEM_SETTING_COMPRESSION_THRESHOLD] = 0
+	xmem = newTestXmemNozzle(0)
+	if err := xmem.config.initializeConfig(settings); err != nil {
+		t.Fatalf("Unexpected error initializing config. err=%v", err)
+	}
+	if !xmem.config.compress || xmem.config.compressionThreshold != 0 {
+		t.Errorf("Compression is %v with threshold %v, expected snappy compression with threshold 0", xmem.config.compress, xmem.config.compressionThreshold)
+	}
+
+	settings[XMEM_SETTING_COMPRESSION_THRESHOLD] = -1
+	if err := newTestXmemNozzle(0).config.initializeConfig(settings); err == nil {
+		t.Errorf("Expected error for negative compression threshold")
+	}
+
+	settings[XMEM_SETTING_COMPRESSION_THRESHOLD] = 0
+	settings[XMEM_SETTING_COMPRESSION] = "invalid"
+	if err := newTestXmemNozzle(0).config.initializeConfig(settings); err == nil {
+		t.Errorf("Expected error for invalid compression")
+	}
+}
+
+func TestCompressRequest(t *testing.T) {
+	body := bytes.Repeat([]byte(`{"key":"value"}`), 20)
+
+	req := &mc.MCRequest{Body: body}
+	compressRequest(req, len(body))
+	if req.DataType&base.SnappyDataType == 0 {
+		t.Fatalf("Snappy bit is not set in datatype after compression")
+	}
+	decoded, err := snappy.Decode(nil, req.Body)
+	if err != nil || !bytes.Equal(decoded, body) {
+		t.Errorf("Compressed body cannot be decoded to the original body. err=%v", err)
+	}
+
+	// already compressed bodies, e.g., of requests that are resent, are not compressed again
+	compressed := req.Body
+	compressRequest(req, 0)
+	if !bytes.Equal(req.Body, compressed) {
+		t.Errorf("Body is compressed twice")
+	}
+
+	// bodies below threshold are left as they are
+	req = &mc.MCRequest{Body: body}
+	compressRequest(req, len(body)+1)
+	if req.DataType != 0 || !bytes.Equal(req.Body, body) {
+		t.Errorf("Body below threshold is compressed")
+	}
+
+	// so are bodies that do not get smaller after compression
+	req = &mc.MCRequest{Body: []byte("a")}
+	compressRequest(req, 0)
+	if req.DataType != 0 || !bytes.Equal(req.Body, []byte("a")) {
+		t.Errorf("Body that does not get smaller after compression is compressed")
+	}
+}
+
+func TestTargetDurabilitySetting(t *testing.T) {
+	settings := map[string]interface{}{SETTING_BATCHCOUNT: 500,
+		SETTING_BATCHSIZE:              2048,
+		SETTING_OPTI_REP_THRESHOLD:     256,
+		XMEM_SETTING_TARGET_DURABILITY: metadata.TargetDurabilityMajorityAndPersistActive}
+	xmem := newTestXmemNozzle(0)
+	if err := xmem.config.initializeConfig(settings); err != nil {
+		t.Fatalf("Unexpected error initializing config. err=%v", err)
+	}
+	if xmem.config.durabilityLevel != base.DurabilityLevelMajorityAndPersistActive {
+		t.Errorf("Durability level is %v, expected %v", xmem.config.durabilityLevel, base.DurabilityLevelMajorityAndPersistActive)
+	}
+
+	settings[XMEM_SETTING_TARGET_DURABILITY] = "invalid"
+	if err := newTestXmemNozzle(0).config.initializeConfig(settings); err == nil {
+		t.Errorf("Expected error for invalid durability")
+	}
+}
+
+func TestBatchSizeBytes(t *testing.T) {
+	// a batch limited by body size alone
+	settings := map[string]interface{}{SETTING_BATCHCOUNT: 0,
+		SETTING_BATCHSIZE:            2048,
+		SETTING_OPTI_REP_THRESHOLD:   256,
+		XMEM_SETTING_BATCHSIZE_BYTES: 10}
+	xmem := newTestXmemNozzle(0)
+	if err := xmem.config.initializeConfig(settings); err != nil {
+		t.Fatalf("Unexpected error initializing config. err=%v", err)
+	}
+	if bufferCount := xmem.config.bufferCount(); bufferCount != default_batchCount {
+		t.Errorf("Buffer count is %v, expected %v", bufferCount, default_batchCount)
+	}
+	xmem.initNewBatch()
+
+	// each test request has a body of 4 bytes, so the third one fills up the batch
+	for i := 0; i < 3; i++ {
+		xmem.accumuBatch(newTestRequest(i))
+		if i < 2 && len(xmem.batches_ready_queue) != 0 {
+			t.Fatalf("Batch is flushed after %v documents, before reaching the body size limit", i+1)
+		}
+	}
+	select {
+	case batch := <-xmem.batches_ready_queue:
+		if batch.count() != 3 || batch.bodySize() != 12 {
+			t.Errorf("Flushed batch has %v documents and %v bytes of bodies, expected 3 and 12", batch.count(), batch.bodySize())
+		}
+	default:
+		t.Fatalf("Batch is not flushed after reaching the body size limit")
+	}
+
+	// whichever limit is reached first flushes the batch
+	settings[SETTING_BATCHCOUNT] = 2
+	xmem = newTestXmemNozzle(0)
+	if err := xmem.config.initializeConfig(settings); err != nil {
+		t.Fatalf("Unexpected error initializing config. err=%v", err)
+	}
+	xmem.initNewBatch()
+	xmem.accumuBatch(newTestRequest(0))
+	xmem.accumuBatch(newTestRequest(1))
+	if len(xmem.batches_ready_queue) != 1 {
+		t.Errorf("Batch is not flushed after reaching batch count")
+	}
+
+	for _, invalid := range []map[string]interface{}{
+		{SETTING_BATCHCOUNT: 0, XMEM_SETTING_BATCHSIZE_BYTES: 0},
+		{SETTING_BATCHCOUNT: 0},
+		{SETTING_BATCHCOUNT: -1, XMEM_SETTING_BATCHSIZE_BYTES: 10},
+		{SETTING_BATCHCOUNT: 500, XMEM_SETTING_BATCHSIZE_BYTES: -1},
+	} {
+		invalid[SETTING_BATCHSIZE] = 2048
+		invalid[SETTING_OPTI_REP_THRESHOLD] = 256
+		if err := newTestXmemNozzle(0).config.initializeConfig(invalid); err == nil {
+			t.Errorf("Expected error for %v", invalid)
+		}
+	}
+}
+
+// sample file-backed ConflictLogger, which writes one line per conflict
+type fileConflictLogger struct {
+	file *os.File
+	lock sync.Mutex
+}
+
+func (logger *fileConflictLogger) LogConflict(key []byte, sourceCas, targetCas uint64, vbucket uint16) {
+	logger.lock.Lock()
+	defer logger.lock.Unlock()
+	fmt.Fprintf(logger.file, "key=%s vb=%v sourceCas=%v targetCas=%v\n", key, vbucket, sourceCas, targetCas)
+}
+
+func TestConflictLogger(t *testing.T) {
+	file, err := ioutil.TempFile("", "conflicts")
+	if err != nil {
+		t.Fatalf("Failed to create conflict log file. err=%v", err)
+	}
+	defer os.Remove(file.Name())
+
+	settings := map[string]interface{}{SETTING_BATCHCOUNT: 500,
+		SETTING_BATCHSIZE:            2048,
+		SETTING_OPTI_REP_THRESHOLD:   256,
+		XMEM_SETTING_CONFLICT_LOGGER: &fileConflictLogger{file: file}}
+	xmem := newTestXmemNozzle(0)
+	if err := xmem.config.initializeConfig(settings); err != nil {
+		t.Fatalf("Unexpected error initializing config. err=%v", err)
+	}
+
+	req := newTestRequest(1).Req
+	req.VBucket = 5
+	req.Extras = make([]byte, 24)
+	binary.BigEndian.PutUint64(req.Extras[16:24], 100)
+	// only responses that reject the source mutation are conflicts
+	xmem.logTargetConflict(req, &mc.MCResponse{Status: mc.SUCCESS, Cas: 200})
+	xmem.logTargetConflict(req, &mc.MCResponse{Status: mc.KEY_EEXISTS, Cas: 200})
+	file.Close()
+
+	content, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatalf("Failed to read conflict log file. err=%v", err)
+	}
+	if expected := "key=key1 vb=5 sourceCas=100 targetCas=200\n"; string(content) != expected {
+		t.Errorf("Conflict log is %q, expected %q", content, expected)
+	}
+
+	// conflicts are dropped by default
+	newTestXmemNozzle(0).logTargetConflict(req, &mc.MCResponse{Status: mc.KEY_EEXISTS, Cas: 200})
+
+	settings[XMEM_SETTING_CONFLICT_LOGGER] = "invalid"
+	if err := newTestXmemNozzle(0).config.initializeConfig(settings); err == nil {
+		t.Errorf("Expected error for invalid conflict logger")
+	}
+}
+
+func TestBandwidthLimiter(t *testing.T) {
+	xmem := newTestXmemNozzle(0)
+	limiter := xmem.bandwidth_limiter
+	finch := make(chan bool)
+
+	// unlimited by default
+	if wait_time := limiter.take(1024 * 1024); wait_time != 0 {
+		t.Fatalf("unlimited limiter asked to wait %v", wait_time)
+	}
+
+	if err := xmem.UpdateSettings(map[string]interface{}{XMEM_SETTING_MAX_BYTES_PER_SEC: 10000}); err != nil {
+		t.Fatalf("Failed to update %v. err=%v", XMEM_SETTING_MAX_BYTES_PER_SEC, err)
+	}
+	if limiter.getRate() != 10000 {
+		t.Fatalf("rate is %v after update, expected 10000", limiter.getRate())
+	}
+
+	// the first second's worth of bytes, plus the send that puts the bucket into debt, go out right away.
+	// the send after them waits for the debt to be paid off
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := limiter.wait(5000, finch); err != nil {
+			t.Fatalf("Unexpected error waiting. err=%v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("sending 20000 bytes at 10000 bytes/sec took %v, expected about 500ms", elapsed)
+	}
+
+	// blocked sends return once the nozzle stops
+	limiter.setRate(1)
+	limiter.take(1000)
+	close(finch)
+	if err := limiter.wait(1, finch); err != PartStoppedError {
+		t.Errorf("wait returned %v after stop, expected PartStoppedError", err)
+	}
+
+	// setting the rate back to 0 lifts the limit
+	if err := xmem.UpdateSettings(map[string]interface{}{XMEM_SETTING_MAX_BYTES_PER_SEC: 0}); err != nil {
+		t.Fatalf("Failed to update %v. err=%v", XMEM_SETTING_MAX_BYTES_PER_SEC, err)
+	}
+	if wait_time := limiter.take(1024 * 1024); wait_time != 0 {
+		t.Errorf("limiter asked to wait %v after the limit was lifted", wait_time)
+	}
+
+	if err := xmem.UpdateSettings(map[string]interface{}{XMEM_SETTING_MAX_BYTES_PER_SEC: -1}); err == nil {
+		t.Errorf("negative %v was accepted", XMEM_SETTING_MAX_BYTES_PER_SEC)
+	}
+	if err := xmem.UpdateSettings(map[string]interface{}{XMEM_SETTING_MAX_BYTES_PER_SEC: "1000"}); err == nil {
+		t.Errorf("%v of wrong type was accepted", XMEM_SETTING_MAX_BYTES_PER_SEC)
+	}
+}
+
+func TestGetCurrentThroughput(t *testing.T) {
+	limiter := newBandwidthLimiter(0)
+	now := time.Now()
+	// bytes sent before the window are not counted
+	limiter.recordSent(1000000, now.Add(-throughput_window_secs*time.Second))
+	for i := throughput_window_secs - 1; i >= 0; i-- {
+		limiter.recordSent(1000, now.Add(time.Duration(-i)*time.Second))
+	}
+
+	if throughput := limiter.throughput(now); throughput != 1000 {
+		t.Errorf("throughput is %v, expected 1000", throughput)
+	}
+	if throughput := limiter.throughput(now.Add(time.Hour)); throughput != 0 {
+		t.Errorf("throughput is %v after an hour of no sends, expected 0", throughput)
+	}
+}
+
+// drops docs whose keys start with "drop", fails docs whose keys start with "fail", and replaces the bodies of other docs
+type testTransformer struct{}
+
+func (transformer *testTransformer) Transform(req *mc.MCRequest) (*mc.MCRequest, bool, error) {
+	key := string(req.Key)
+	if strings.HasPrefix(key, "drop") {
+		return nil, true, nil
+	}
+	if strings.HasPrefix(key, "fail") {
+		return nil, false, fmt.Errorf("cannot transform %v", key)
+	}
+	transformed := *req
+	transformed.Body = []byte("transformed")
+	return &transformed, false, nil
+}
+
+// records the events raised by a part
+type testEventRecorder struct {
+	events []*common.Event
+}
+
+func (recorder *testEventRecorder) OnEvent(event *common.Event) {
+	recorder.events = append(recorder.events, event)
+}
+
+func TestTransformer(t *testing.T) {
+	settings := map[string]interface{}{SETTING_BATCHCOUNT: 500,
+		SETTING_BATCHSIZE:          2048,
+		SETTING_OPTI_REP_THRESHOLD: 256,
+		XMEM_SETTING_KEY_PREFIX:    "prefix:",
+		XMEM_SETTING_TRANSFORMER:   &testTransformer{}}
+	xmem := newTestXmemNozzle(0)
+	if err := xmem.config.initializeConfig(settings); err != nil {
+		t.Fatalf("Unexpected error initializing config. err=%v", err)
+	}
+	xmem.SetState(common.Part_Starting)
+	xmem.SetState(common.Part_Running)
+	filtered := &testEventRecorder{}
+	xmem.RegisterComponentEventListener(common.DataFiltered, filtered)
+	errored := &testEventRecorder{}
+	xmem.RegisterComponentEventListener(common.ErrorEncountered, errored)
+
+	// transformed docs are sent in place of the original ones, with key prefix applied to the source key
+	if err := xmem.Receive(newTestRequest(1)); err != nil {
+		t.Fatalf("Failed to receive doc. err=%v", err)
+	}
+	select {
+	case req := <-xmem.dataChan:
+		if string(req.Req.Key) != "prefix:key1" || string(req.Req.Body) != "transformed" {
+			t.Errorf("Doc sent has key %q and body %q, expected the transformed doc", req.Req.Key, req.Req.Body)
+		}
+	default:
+		t.Fatalf("Transformed doc was not queued")
+	}
+
+	// dropped docs are reported as filtered, so that checkpoints advance past them
+	req := newTestRequest(2)
+	req.Req.Key = []byte("drop2")
+	req.Req.VBucket = 3
+	req.Req.Opcode = base.DELETE_WITH_META
+	if err := xmem.Receive(req); err != nil {
+		t.Fatalf("Failed to receive dropped doc. err=%v", err)
+	}
+	if len(xmem.dataChan) != 0 {
+		t.Errorf("Dropped doc was queued")
+	}
+	if len(filtered.events) != 1 {
+		t.Fatalf("%v DataFiltered events raised, expected 1", len(filtered.events))
+	}
+	if event := filtered.events[0].Data.(*mcc.UprEvent); event.Seqno != 2 || event.VBucket != 3 || event.Opcode != mc.UPR_DELETION {
+		t.Errorf("DataFiltered event is for seqno %v, vb %v, opcode %v, expected seqno 2, vb 3, opcode %v", event.Seqno, event.VBucket, event.Opcode, mc.UPR_DELETION)
+	}
+
+	// transformation errors stop the nozzle instead of dropping docs silently
+	req = newTestRequest(3)
+	req.Req.Key = []byte("fail3")
+	if err := xmem.Receive(req); err == nil {
+		t.Errorf("Expected error for doc that failed to be transformed")
+	}
+	if len(errored.events) != 1 || xmem.State() != common.Part_Error {
+		t.Errorf("%v ErrorEncountered events raised and nozzle is in state %v, expected 1 event and error state", len(errored.events), xmem.State())
+	}
+	if len(xmem.dataChan) != 0 || len(filtered.events) != 1 {
+		t.Errorf("Doc that failed to be transformed was queued or reported as filtered")
+	}
+
+	// docs are sent as they are by default
+	xmem = newTestXmemNozzle(0)
+	if xmem.config.transformer != nil {
+		t.Errorf("Transformer is set by default")
+	}
+	if dropped, err := xmem.transformDoc(newTestRequest(4)); dropped || err != nil {
+		t.Errorf("Doc is dropped=%v, err=%v without transformer", dropped, err)
+	}
+
+	settings[XMEM_SETTING_TRANSFORMER] = "invalid"
+	if err := newTestXmemNozzle(0).config.initializeConfig(settings); err == nil {
+		t.Errorf("Expected error for invalid transformer")
+	}
+}
+
+func TestFlush(t *testing.T) {
+	xmem := newTestXmemNozzle(0)
+	xmem.receive_token_ch = make(chan int, xmem.config.maxCount*2)
+	xmem.buf = newReqBuffer(uint16(xmem.config.maxCount*2), uint16(float64(xmem.config.maxCount)*0.2), xmem.receive_token_ch, xmem.Logger())
+	xmem.SetState(common.Part_Starting)
+	xmem.SetState(common.Part_Running)
+
+	// mock sender and target, which send the docs in the flushed batch and acknowledge them
+	acked := make(chan bool)
+	go func() {
+		batch := <-xmem.batches_ready_queue
+		atomic.StoreInt32(&xmem.sending_batch, 1)
+		for i := 0; i < int(batch.count()); i++ {
+			item, err := xmem.readFromDataChan()
+			if err != nil {
+				t.Errorf("Failed to read doc from data channel. err=%v", err)
+				continue
+			}
+			pos, _, _ := xmem.buf.enSlot(item)
+			atomic.StoreInt32(&xmem.sending_batch, 0)
+			time.Sleep(20 * time.Millisecond)
+			xmem.buf.evictSlot(pos)
+		}
+		close(acked)
+	}()
+
+	if err := xmem.Receive(newTestRequest(0)); err != nil {
+		t.Fatalf("Failed to receive doc. err=%v", err)
+	}
+	if err := xmem.flush(time.Second); err != nil {
+		t.Errorf("Unexpected error flushing. err=%v", err)
+	}
+	<-acked
+
+	// docs received after flush has started are not accepted
+	if err := xmem.Receive(newTestRequest(1)); err != nil {
+		t.Errorf("Unexpected error receiving doc during flush. err=%v", err)
+	}
+	if len(xmem.dataChan) != 0 {
+		t.Errorf("Doc received during flush has been queued")
+	}
+
+	// flush times out when target does not acknowledge the docs
+	req := newTestRequest(2)
+	req.Req.VBucket = 5
+	xmem.buf.enSlot(req)
+	err := xmem.flush(50 * time.Millisecond)
+	if err == nil {
+		t.Fatalf("Expected error flushing with unacknowledged docs")
+	}
+	if !strings.Contains(err.Error(), "[5]") {
+		t.Errorf("Flush error %q does not list vb 5", err.Error())
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/couchbase/goxdcr/metadata"
 	"github.com/couchbase/goxdcr/pipeline_utils"
 	"github.com/couchbase/goxdcr/simple_utils"
+	"math"
 	"sync"
 	"time"
 )
@@ -67,6 +68,14 @@ func (errArray PipelineErrorArray) String() string {
 	return string(bytes)
 }
 
+// a temporary increase of the nozzle counts of a replication, which is not persisted in replication settings
+type ResourceBoost struct {
+	// factor by which nozzle counts in replication settings are scaled up
+	Multiplier float64
+	// time when the boost expires and the replication reverts to its replication settings
+	Expiry time.Time
+}
+
 type ReplicationStatus struct {
 	pipeline         common.Pipeline
 	err_list         PipelineErrorArray
@@ -81,6 +90,12 @@ type ReplicationStatus struct {
 	// useful when replication is paused, when it can be compared with the current vb_list to determine
 	// whether topology change has occured on source
 	vb_list []uint16
+	// history of key statistics. nil when statistics history is disabled
+	stats_history *StatsHistory
+	// latency of the last canary document. 0 when canary is disabled or has not completed yet
+	canary_latency time.Duration
+	// nil when the replication is not boosted
+	boost *ResourceBoost
 }
 
 func NewReplicationStatus(specId string, spec_getter ReplicationSpecGetter, logger *log.CommonLogger) *ReplicationStatus {
@@ -93,6 +108,10 @@ func NewReplicationStatus(specId string, spec_getter ReplicationSpecGetter, logg
 		obj_pool:    base.NewMCRequestPool(specId, logger),
 		progress:    ""}
 
+	if base.StatsHistorySize > 0 {
+		rep_status.stats_history = NewStatsHistory(base.StatsHistorySize)
+	}
+
 	rep_status.Publish(false)
 	return rep_status
 }
@@ -272,6 +291,84 @@ func (rs *ReplicationStatus) Pipeline() common.Pipeline {
 	return rs.pipeline
 }
 
+// vbs of the running pipeline that have been isolated because of repeated failures, and the errors that caused the isolation
+func (rs *ReplicationStatus) IsolatedVBs() map[uint16]error {
+	isolated_vbs := make(map[uint16]error)
+	p := rs.Pipeline()
+	if p == nil {
+		return isolated_vbs
+	}
+	vb_err_map_obj, ok := p.Settings()[base.IsolatedVBs].(*base.ObjectWithLock)
+	if !ok {
+		return isolated_vbs
+	}
+	vb_err_map_obj.Lock.RLock()
+	defer vb_err_map_obj.Lock.RUnlock()
+	for vbno, err := range vb_err_map_obj.Object.(map[uint16]error) {
+		isolated_vbs[vbno] = err
+	}
+	return isolated_vbs
+}
+
+func (rs *ReplicationStatus) CanaryLatency() time.Duration {
+	rs.Lock.RLock()
+	defer rs.Lock.RUnlock()
+	return rs.canary_latency
+}
+
+func (rs *ReplicationStatus) SetCanaryLatency(latency time.Duration) {
+	rs.Lock.Lock()
+	defer rs.Lock.Unlock()
+	rs.canary_latency = latency
+}
+
+func (rs *ReplicationStatus) Boost() *ResourceBoost {
+	rs.Lock.RLock()
+	defer rs.Lock.RUnlock()
+	return rs.boost
+}
+
+func (rs *ReplicationStatus) SetBoost(boost *ResourceBoost) {
+	rs.Lock.Lock()
+	defer rs.Lock.Unlock()
+	rs.boost = boost
+}
+
+// clears the boost if it is still the one in effect, and returns whether it has been cleared
+func (rs *ReplicationStatus) ClearBoost(boost *ResourceBoost) bool {
+	rs.Lock.Lock()
+	defer rs.Lock.Unlock()
+	if rs.boost == nil || rs.boost != boost {
+		return false
+	}
+	rs.boost = nil
+	return true
+}
+
+// settings that the pipeline of the replication is constructed with, which are the replication settings
+// with nozzle counts scaled up when the replication is boosted
+func (rs *ReplicationStatus) EffectiveSettings() *metadata.ReplicationSettings {
+	settings := rs.Settings()
+	boost := rs.Boost()
+	if settings == nil || boost == nil {
+		return settings
+	}
+
+	effective_settings := *settings
+	effective_settings.SourceNozzlePerNode = boostNozzlePerNode(settings.SourceNozzlePerNode, boost.Multiplier, metadata.SourceNozzlePerNodeConfig)
+	effective_settings.TargetNozzlePerNode = boostNozzlePerNode(settings.TargetNozzlePerNode, boost.Multiplier, metadata.TargetNozzlePerNodeConfig)
+	return &effective_settings
+}
+
+// scales up nozzle count, without going beyond the max value allowed by settings
+func boostNozzlePerNode(nozzlePerNode int, multiplier float64, config *metadata.SettingsConfig) int {
+	boosted := int(math.Ceil(float64(nozzlePerNode) * multiplier))
+	if boosted > config.MaxValue {
+		boosted = config.MaxValue
+	}
+	return boosted
+}
+
 func (rs *ReplicationStatus) VbList() []uint16 {
 	rs.Lock.RLock()
 	defer rs.Lock.RUnlock()
@@ -350,6 +447,11 @@ func (rs *ReplicationStatus) SetUpdater(updater interface{}) error {
 	return nil
 }
 
+// returns nil when statistics history is disabled
+func (rs *ReplicationStatus) StatsHistory() *StatsHistory {
+	return rs.stats_history
+}
+
 func (rs *ReplicationStatus) ObjectPool() *base.MCRequestPool {
 	return rs.obj_pool
 }
@@ -0,0 +1,56 @@
+// Copyright (c) 2013 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package pipeline
+
+import (
+	"github.com/couchbase/goxdcr/log"
+	"github.com/couchbase/goxdcr/metadata"
+	"testing"
+	"time"
+)
+
+func TestEffectiveSettingsWithBoost(t *testing.T) {
+	spec := metadata.NewReplicationSpecification("sourceBucket", "sourceBucketUUID", "targetClusterUUID", "targetBucket", "targetBucketUUID")
+	spec.Settings.SourceNozzlePerNode = 3
+	spec.Settings.TargetNozzlePerNode = 40
+	rs := NewReplicationStatus(spec.Id, func(specId string) (*metadata.ReplicationSpecification, error) {
+		return spec, nil
+	}, log.NewLogger("testReplicationStatus", log.DefaultLoggerContext))
+
+	if settings := rs.EffectiveSettings(); settings != spec.Settings {
+		t.Errorf("Effective settings differ from replication settings when replication is not boosted")
+	}
+
+	boost := &ResourceBoost{Multiplier: 2.5, Expiry: time.Now().Add(time.Minute)}
+	rs.SetBoost(boost)
+	settings := rs.EffectiveSettings()
+	if settings.SourceNozzlePerNode != 8 {
+		t.Errorf("Boosted source nozzle per node is %v, expected 8", settings.SourceNozzlePerNode)
+	}
+	// capped at the max value of the setting
+	if settings.TargetNozzlePerNode != metadata.TargetNozzlePerNodeConfig.MaxValue {
+		t.Errorf("Boosted target nozzle per node is %v, expected %v", settings.TargetNozzlePerNode, metadata.TargetNozzlePerNodeConfig.MaxValue)
+	}
+	// replication settings are left intact
+	if spec.Settings.SourceNozzlePerNode != 3 || spec.Settings.TargetNozzlePerNode != 40 {
+		t.Errorf("Replication settings have been changed by boost")
+	}
+
+	// a boost that has been replaced is not cleared
+	if rs.ClearBoost(&ResourceBoost{Multiplier: 2, Expiry: boost.Expiry}) {
+		t.Errorf("Boost that is not in effect has been cleared")
+	}
+	if !rs.ClearBoost(boost) {
+		t.Errorf("Boost in effect has not been cleared")
+	}
+	if rs.Boost() != nil || rs.EffectiveSettings().SourceNozzlePerNode != 3 {
+		t.Errorf("Replication is still boosted after boost has been cleared")
+	}
+}
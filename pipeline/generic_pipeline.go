@@ -186,6 +186,7 @@ func (genericPipeline *GenericPipeline) Start(settings map[string]interface{}) e
 	settings[base.VBTimestamps] = &base.ObjectWithLock{make(map[uint16]*base.VBTimestamp), &sync.RWMutex{}}
 	settings[base.ProblematicVBSource] = &base.ObjectWithLock{make(map[uint16]error), &sync.RWMutex{}}
 	settings[base.ProblematicVBTarget] = &base.ObjectWithLock{make(map[uint16]error), &sync.RWMutex{}}
+	settings[base.IsolatedVBs] = &base.ObjectWithLock{make(map[uint16]error), &sync.RWMutex{}}
 
 	genericPipeline.settings = settings
 
@@ -712,6 +713,7 @@ func (genericPipeline *GenericPipeline) updateTimestampsSetting(settings map[str
 func (genericPipeline *GenericPipeline) updateProblematicVBSettings(settings map[string]interface{}) {
 	genericPipeline.updateProblematicVBSetting(settings, base.ProblematicVBSource)
 	genericPipeline.updateProblematicVBSetting(settings, base.ProblematicVBTarget)
+	genericPipeline.updateProblematicVBSetting(settings, base.IsolatedVBs)
 }
 
 func (genericPipeline *GenericPipeline) updateProblematicVBSetting(settings map[string]interface{}, settings_key string) {
@@ -0,0 +1,89 @@
+// Copyright (c) 2013 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package pipeline
+
+import (
+	"github.com/couchbase/goxdcr/base"
+	"sync"
+	"time"
+)
+
+// fixed size ring buffer of samples of one statistic. once full, the oldest sample is overwritten
+type statsRingBuffer struct {
+	samples []base.TimedSample
+	// position where the next sample will be written
+	next int
+	full bool
+}
+
+func newStatsRingBuffer(size int) *statsRingBuffer {
+	return &statsRingBuffer{samples: make([]base.TimedSample, size)}
+}
+
+func (buf *statsRingBuffer) add(sample base.TimedSample) {
+	buf.samples[buf.next] = sample
+	buf.next++
+	if buf.next == len(buf.samples) {
+		buf.next = 0
+		buf.full = true
+	}
+}
+
+// returns a copy of the samples in the buffer, from the oldest to the newest
+func (buf *statsRingBuffer) list() []base.TimedSample {
+	if !buf.full {
+		samples := make([]base.TimedSample, buf.next)
+		copy(samples, buf.samples[:buf.next])
+		return samples
+	}
+	samples := make([]base.TimedSample, 0, len(buf.samples))
+	samples = append(samples, buf.samples[buf.next:]...)
+	samples = append(samples, buf.samples[:buf.next]...)
+	return samples
+}
+
+// StatsHistory keeps the most recent samples of a set of statistics of a replication.
+// memory usage is bounded by size * number of statistics recorded
+type StatsHistory struct {
+	size    int
+	buffers map[string]*statsRingBuffer
+	lock    sync.RWMutex
+}
+
+func NewStatsHistory(size int) *StatsHistory {
+	return &StatsHistory{size: size,
+		buffers: make(map[string]*statsRingBuffer)}
+}
+
+func (history *StatsHistory) Record(timestamp time.Time, values map[string]float64) {
+	history.lock.Lock()
+	defer history.lock.Unlock()
+
+	for metric, value := range values {
+		buf, ok := history.buffers[metric]
+		if !ok {
+			buf = newStatsRingBuffer(history.size)
+			history.buffers[metric] = buf
+		}
+		buf.add(base.TimedSample{Time: timestamp.UnixNano(), Value: value})
+	}
+}
+
+// returns samples of the specified statistic, from the oldest to the newest, and whether the statistic is being recorded
+func (history *StatsHistory) Samples(metric string) ([]base.TimedSample, bool) {
+	history.lock.RLock()
+	defer history.lock.RUnlock()
+
+	buf, ok := history.buffers[metric]
+	if !ok {
+		return nil, false
+	}
+	return buf.list(), true
+}
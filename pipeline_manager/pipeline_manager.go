@@ -10,6 +10,7 @@
 package pipeline_manager
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/couchbase/goxdcr/base"
@@ -28,6 +29,24 @@ var ReplicationSpecNotFound error = errors.New("Replication specification not fo
 
 var default_failure_restart_interval = 10
 
+// replications that have failed to start for this number of consecutive times are quarantined,
+// i.e., the interval between start attempts is doubled after each further failure
+var QuarantineStartFailureThreshold = 5
+
+// upper bound of the interval between start attempts for quarantined replications
+var MaxQuarantineRetryInterval = 1 * time.Hour
+
+var ReplicationNotQuarantinedError = errors.New("Replication is not quarantined")
+
+// prefix of the metakv catalogs where quarantined replications are persisted, so that quarantines survive restarts.
+// quarantines are per node, hence each node has its own catalog
+var QuarantineCatalogKeyPrefix = "pipelineQuarantine"
+
+var InvalidBoostMultiplierError = errors.New("Boost multiplier needs to be larger than 1")
+var InvalidBoostDurationError = errors.New("Boost duration needs to be positive")
+var ReplicationNotRunningError = errors.New("Replication is not running")
+var ReplicationNotBoostedError = errors.New("Replication is not boosted")
+
 type func_report_fixed func(topic string)
 
 type pipelineManager struct {
@@ -35,23 +54,52 @@ type pipelineManager struct {
 	repl_spec_svc      service_def.ReplicationSpecSvc
 	xdcr_topology_svc  service_def.XDCRCompTopologySvc
 	remote_cluster_svc service_def.RemoteClusterSvc
+	metadata_svc       service_def.MetadataSvc
 	once               sync.Once
 	logger             *log.CommonLogger
 	child_waitGrp      *sync.WaitGroup
+
+	// consecutive start failures of replications, keyed by replication id.
+	// kept outside of pipeline updaters so that the count survives across updaters
+	start_failures      map[string]*startFailureRecord
+	start_failures_lock sync.RWMutex
+}
+
+type startFailureRecord struct {
+	num_of_failures int
+	next_retry_time time.Time
+}
+
+// replication that has failed to start too many times and is retried on an exponential schedule
+type QuarantinedReplication struct {
+	ReplicationId      string
+	NumOfStartFailures int
+	NextRetryTime      time.Time
+}
+
+// changes made when reconciling pipelines against replication specs
+type ReconcileReport struct {
+	// inactive replications whose pipelines were still running
+	StoppedPipelines []string `json:"stoppedPipelines"`
+	// active replications whose pipelines were not running
+	StartedPipelines []string `json:"startedPipelines"`
 }
 
 var pipeline_mgr pipelineManager
 
 func PipelineManager(factory common.PipelineFactory, repl_spec_svc service_def.ReplicationSpecSvc, xdcr_topology_svc service_def.XDCRCompTopologySvc,
-	remote_cluster_svc service_def.RemoteClusterSvc, logger_context *log.LoggerContext) {
+	remote_cluster_svc service_def.RemoteClusterSvc, metadata_svc service_def.MetadataSvc, logger_context *log.LoggerContext) {
 	pipeline_mgr.once.Do(func() {
 		pipeline_mgr.pipeline_factory = factory
 		pipeline_mgr.repl_spec_svc = repl_spec_svc
 		pipeline_mgr.xdcr_topology_svc = xdcr_topology_svc
 		pipeline_mgr.remote_cluster_svc = remote_cluster_svc
+		pipeline_mgr.metadata_svc = metadata_svc
 		pipeline_mgr.logger = log.NewLogger("PipelineManager", logger_context)
 		pipeline_mgr.logger.Info("Pipeline Manager is constucted")
 		pipeline_mgr.child_waitGrp = &sync.WaitGroup{}
+		pipeline_mgr.start_failures = make(map[string]*startFailureRecord)
+		pipeline_mgr.loadQuarantinedReplications()
 
 		//initialize the expvar storage for replication status
 		pipeline.RootStorage()
@@ -117,6 +165,9 @@ func RemoveReplicationStatus(topic string) error {
 		return err
 	}
 
+	pipeline_mgr.setReplicationState(topic, metadata.ReplicationStateDeleting)
+	pipeline_mgr.clearStartFailures(topic)
+
 	//ask the updater on this topic if any to stop
 	stopUpdater(topic)
 	rs.ResetStorage()
@@ -220,11 +271,17 @@ func IsPipelineRunning(topic string) bool {
 
 func CheckPipelines() {
 	rep_status_map := ReplicationStatusMap()
-	for specId, rep_status := range rep_status_map {
-		//validate replication spec
-		if rep_status.Spec() != nil {
-			pipeline_mgr.repl_spec_svc.ValidateAndGC(rep_status.Spec())
+
+	//validate replication specs. specs to the same remote cluster are validated together to reduce rest calls
+	specs := make([]*metadata.ReplicationSpecification, 0, len(rep_status_map))
+	for _, rep_status := range rep_status_map {
+		if spec := rep_status.Spec(); spec != nil {
+			specs = append(specs, spec)
 		}
+	}
+	pipeline_mgr.repl_spec_svc.ValidateAndGCBatch(specs)
+
+	for specId, rep_status := range rep_status_map {
 		if rep_status.RuntimeStatus(true) == pipeline.Pending {
 			if rep_status.Updater() == nil {
 				pipeline_mgr.logger.Infof("Pipeline %v is broken, but not yet attended, launch updater", specId)
@@ -235,6 +292,144 @@ func CheckPipelines() {
 	LogStatusSummary()
 }
 
+// list replications that are quarantined because of repeated start failures, along with their next retry times
+func GetQuarantinedReplications() []*QuarantinedReplication {
+	pipeline_mgr.start_failures_lock.RLock()
+	defer pipeline_mgr.start_failures_lock.RUnlock()
+
+	quarantined := make([]*QuarantinedReplication, 0)
+	for topic, record := range pipeline_mgr.start_failures {
+		if record.num_of_failures >= QuarantineStartFailureThreshold {
+			quarantined = append(quarantined, &QuarantinedReplication{ReplicationId: topic,
+				NumOfStartFailures: record.num_of_failures,
+				NextRetryTime:      record.next_retry_time})
+		}
+	}
+	return quarantined
+}
+
+// release a replication from quarantine and retry starting it immediately
+func UnquarantineReplication(topic string) error {
+	pipeline_mgr.start_failures_lock.Lock()
+	record, ok := pipeline_mgr.start_failures[topic]
+	if !ok || record.num_of_failures < QuarantineStartFailureThreshold {
+		pipeline_mgr.start_failures_lock.Unlock()
+		return ReplicationNotQuarantinedError
+	}
+	delete(pipeline_mgr.start_failures, topic)
+	pipeline_mgr.start_failures_lock.Unlock()
+	pipeline_mgr.unpersistQuarantine(topic)
+
+	pipeline_mgr.logger.Infof("Replication %v has been released from quarantine. Retrying it now\n", topic)
+	return Update(topic, nil)
+}
+
+// brings pipelines in line with the activeness of replication specs, in case they have drifted apart,
+// e.g., because of missed spec change events. replications with an updater launched are skipped,
+// since the updater will bring them in line
+func ReconcilePipelines() (*ReconcileReport, error) {
+	report := &ReconcileReport{StoppedPipelines: make([]string, 0),
+		StartedPipelines: make([]string, 0)}
+
+	isKV, err := pipeline_mgr.xdcr_topology_svc.IsKVNode()
+	if err == nil && !isKV {
+		pipeline_mgr.logger.Infof("This node is not a KV node, skipping reconciling pipelines\n")
+		return report, nil
+	}
+
+	specs, err := pipeline_mgr.repl_spec_svc.AllReplicationSpecs()
+	if err != nil {
+		return nil, err
+	}
+
+	for topic, spec := range specs {
+		rep_status, _ := ReplicationStatus(topic)
+		if rep_status == nil {
+			rep_status = InitReplicationStatusForReplication(topic)
+		}
+		if rep_status.Updater() != nil {
+			continue
+		}
+
+		p := rep_status.Pipeline()
+		if spec.Settings.Active {
+			if p != nil && (p.State() == common.Pipeline_Running || p.State() == common.Pipeline_Starting) {
+				continue
+			}
+			pipeline_mgr.logger.Infof("Pipeline %v is not running while replication is active. Starting it\n", topic)
+			if err = pipeline_mgr.launchUpdater(topic, nil, rep_status); err == nil {
+				report.StartedPipelines = append(report.StartedPipelines, topic)
+			}
+		} else if p != nil {
+			pipeline_mgr.logger.Infof("Pipeline %v is running while replication is paused. Stopping it\n", topic)
+			if err = pipeline_mgr.launchUpdater(topic, nil, rep_status); err == nil {
+				report.StoppedPipelines = append(report.StoppedPipelines, topic)
+			}
+		}
+	}
+
+	pipeline_mgr.logger.Infof("Reconciled pipelines. report=%v\n", report)
+	return report, nil
+}
+
+// temporarily scales up the nozzle counts of a running replication, e.g., to let it catch up after an outage.
+// the pipeline is restarted with the boosted nozzle counts, and again with the original ones when the boost expires
+func BoostReplication(topic string, multiplier float64, duration time.Duration) error {
+	if multiplier <= 1 {
+		return InvalidBoostMultiplierError
+	}
+	if duration <= 0 {
+		return InvalidBoostDurationError
+	}
+	rep_status, err := ReplicationStatus(topic)
+	if err != nil {
+		return err
+	}
+	if rep_status.RuntimeStatus(true) != pipeline.Replicating {
+		return ReplicationNotRunningError
+	}
+
+	boost := &pipeline.ResourceBoost{Multiplier: multiplier, Expiry: time.Now().Add(duration)}
+	rep_status.SetBoost(boost)
+	time.AfterFunc(duration, func() {
+		// the boost may have been released or replaced in the meantime
+		if rep_status.ClearBoost(boost) {
+			pipeline_mgr.logger.Infof("Boost of replication %v has expired. Reverting to replication settings\n", topic)
+			Update(topic, nil)
+		}
+	})
+
+	pipeline_mgr.logger.Infof("Boosting replication %v by %v until %v\n", topic, multiplier, boost.Expiry)
+	return Update(topic, nil)
+}
+
+// ends the boost of a replication before it expires
+func ReleaseBoost(topic string) error {
+	rep_status, err := ReplicationStatus(topic)
+	if err != nil {
+		return err
+	}
+	if !rep_status.ClearBoost(rep_status.Boost()) {
+		return ReplicationNotBoostedError
+	}
+
+	pipeline_mgr.logger.Infof("Boost of replication %v has been released. Reverting to replication settings\n", topic)
+	return Update(topic, nil)
+}
+
+// settings that the pipeline of a replication is constructed with, and the boost in effect, if any
+func EffectiveSettings(topic string) (*metadata.ReplicationSettings, *pipeline.ResourceBoost, error) {
+	rep_status, err := ReplicationStatus(topic)
+	if err != nil {
+		return nil, nil, err
+	}
+	settings := rep_status.EffectiveSettings()
+	if settings == nil {
+		return nil, nil, ReplicationSpecNotFound
+	}
+	return settings, rep_status.Boost(), nil
+}
+
 func RuntimeCtx(topic string) common.PipelineRuntimeContext {
 	return pipeline_mgr.runtimeCtx(topic)
 }
@@ -283,10 +478,12 @@ func (pipelineMgr *pipelineManager) startPipeline(topic string) (common.Pipeline
 		}
 
 		rep_status.RecordProgress("Start pipeline construction")
+		pipelineMgr.setReplicationState(topic, metadata.ReplicationStateStarting)
 
 		p, err := pipelineMgr.pipeline_factory.NewPipeline(topic, rep_status.RecordProgress)
 		if err != nil {
 			pipelineMgr.logger.Errorf("Failed to construct a new pipeline with topic %v: %s", topic, err.Error())
+			pipelineMgr.setReplicationState(topic, metadata.ReplicationStateError)
 			return p, err
 		}
 
@@ -298,9 +495,11 @@ func (pipelineMgr *pipelineManager) startPipeline(topic string) (common.Pipeline
 		err = p.Start(rep_status.SettingsMap())
 		if err != nil {
 			pipelineMgr.logger.Error("Failed to start the pipeline")
+			pipelineMgr.setReplicationState(topic, metadata.ReplicationStateError)
 			return p, err
 		}
 
+		pipelineMgr.setReplicationState(topic, metadata.ReplicationStateRunning)
 		return p, nil
 	} else {
 		//the pipeline is already running
@@ -335,6 +534,157 @@ func (pipelineMgr *pipelineManager) validatePipeline(topic string) error {
 	return nil
 }
 
+// state tracking is informational and should not interfere with pipeline operations. errors are logged only
+func (pipelineMgr *pipelineManager) setReplicationState(topic string, state metadata.ReplicationState) {
+	err := pipelineMgr.repl_spec_svc.SetReplicationState(topic, state)
+	if err != nil {
+		pipelineMgr.logger.Infof("Failed to set state of replication %v to %v. err=%v\n", topic, state, err)
+	}
+}
+
+// record a failed attempt to start the replication and return the interval to wait before the next attempt.
+// once the replication has failed QuarantineStartFailureThreshold times in a row, it is quarantined and
+// the interval doubles after each failure, up to MaxQuarantineRetryInterval
+func (pipelineMgr *pipelineManager) recordStartFailure(topic string, retry_interval time.Duration) time.Duration {
+	pipelineMgr.start_failures_lock.Lock()
+	record, ok := pipelineMgr.start_failures[topic]
+	if !ok {
+		record = &startFailureRecord{}
+		pipelineMgr.start_failures[topic] = record
+	}
+	record.num_of_failures++
+
+	next_retry_interval := retry_interval
+	if record.num_of_failures >= QuarantineStartFailureThreshold {
+		for i := QuarantineStartFailureThreshold; i <= record.num_of_failures && next_retry_interval < MaxQuarantineRetryInterval; i++ {
+			next_retry_interval *= 2
+		}
+		if next_retry_interval > MaxQuarantineRetryInterval {
+			next_retry_interval = MaxQuarantineRetryInterval
+		}
+		if record.num_of_failures == QuarantineStartFailureThreshold {
+			pipelineMgr.logger.Errorf("Replication %v has failed to start %v times in a row and is quarantined\n", topic, record.num_of_failures)
+		}
+	}
+	record.next_retry_time = time.Now().Add(next_retry_interval)
+	quarantined := &QuarantinedReplication{ReplicationId: topic,
+		NumOfStartFailures: record.num_of_failures,
+		NextRetryTime:      record.next_retry_time}
+	pipelineMgr.start_failures_lock.Unlock()
+
+	if quarantined.NumOfStartFailures >= QuarantineStartFailureThreshold {
+		pipelineMgr.persistQuarantine(quarantined)
+	}
+	return next_retry_interval
+}
+
+func (pipelineMgr *pipelineManager) clearStartFailures(topic string) {
+	pipelineMgr.start_failures_lock.Lock()
+	record, ok := pipelineMgr.start_failures[topic]
+	delete(pipelineMgr.start_failures, topic)
+	pipelineMgr.start_failures_lock.Unlock()
+
+	if ok && record.num_of_failures >= QuarantineStartFailureThreshold {
+		pipelineMgr.unpersistQuarantine(topic)
+	}
+}
+
+// returns how long to wait before the next attempt to start the replication. it is non-zero only when
+// the replication is quarantined and its next retry time, which may have been set before a restart, has not been reached
+func (pipelineMgr *pipelineManager) quarantineWait(topic string) time.Duration {
+	pipelineMgr.start_failures_lock.RLock()
+	defer pipelineMgr.start_failures_lock.RUnlock()
+
+	record, ok := pipelineMgr.start_failures[topic]
+	if !ok || record.num_of_failures < QuarantineStartFailureThreshold {
+		return 0
+	}
+	wait := record.next_retry_time.Sub(time.Now())
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+func (pipelineMgr *pipelineManager) getQuarantineCatalogKey() (string, error) {
+	hostAddr, err := pipelineMgr.xdcr_topology_svc.MyHostAddr()
+	if err != nil {
+		return "", err
+	}
+	return QuarantineCatalogKeyPrefix + base.KeyPartsDelimiter + hostAddr, nil
+}
+
+// rebuilds the quarantines on this node from metakv. quarantines of replications that have been deleted
+// in the meantime are removed
+func (pipelineMgr *pipelineManager) loadQuarantinedReplications() {
+	if pipelineMgr.metadata_svc == nil {
+		return
+	}
+	catalogKey, err := pipelineMgr.getQuarantineCatalogKey()
+	if err != nil {
+		pipelineMgr.logger.Errorf("Failed to load quarantined replications. err=%v\n", err)
+		return
+	}
+	entries, err := pipelineMgr.metadata_svc.GetAllMetadataFromCatalog(catalogKey)
+	if err != nil {
+		pipelineMgr.logger.Errorf("Failed to load quarantined replications. err=%v\n", err)
+		return
+	}
+
+	for _, entry := range entries {
+		quarantined := &QuarantinedReplication{}
+		err = json.Unmarshal(entry.Value, quarantined)
+		if err != nil {
+			pipelineMgr.logger.Errorf("Skipping invalid quarantine record %v. err=%v\n", entry.Key, err)
+			continue
+		}
+		if _, err = pipelineMgr.repl_spec_svc.ReplicationSpec(quarantined.ReplicationId); err != nil {
+			pipelineMgr.logger.Infof("Removing quarantine of replication %v since the replication no longer exists\n", quarantined.ReplicationId)
+			pipelineMgr.unpersistQuarantine(quarantined.ReplicationId)
+			continue
+		}
+		pipelineMgr.start_failures[quarantined.ReplicationId] = &startFailureRecord{num_of_failures: quarantined.NumOfStartFailures,
+			next_retry_time: quarantined.NextRetryTime}
+		pipelineMgr.logger.Infof("Replication %v is quarantined after %v start failures. Next retry time is %v\n", quarantined.ReplicationId,
+			quarantined.NumOfStartFailures, quarantined.NextRetryTime)
+	}
+}
+
+func (pipelineMgr *pipelineManager) persistQuarantine(quarantined *QuarantinedReplication) {
+	if pipelineMgr.metadata_svc == nil {
+		return
+	}
+	catalogKey, err := pipelineMgr.getQuarantineCatalogKey()
+	if err == nil {
+		var value []byte
+		value, err = json.Marshal(quarantined)
+		if err == nil {
+			err = pipelineMgr.metadata_svc.Set(catalogKey+base.KeyPartsDelimiter+quarantined.ReplicationId, value, nil)
+		}
+	}
+	if err != nil {
+		pipelineMgr.logger.Errorf("Failed to persist quarantine of replication %v. err=%v\n", quarantined.ReplicationId, err)
+	}
+}
+
+func (pipelineMgr *pipelineManager) unpersistQuarantine(topic string) {
+	if pipelineMgr.metadata_svc == nil {
+		return
+	}
+	catalogKey, err := pipelineMgr.getQuarantineCatalogKey()
+	if err == nil {
+		key := catalogKey + base.KeyPartsDelimiter + topic
+		var rev interface{}
+		_, rev, err = pipelineMgr.metadata_svc.Get(key)
+		if err == nil {
+			err = pipelineMgr.metadata_svc.DelWithCatalog(catalogKey, key, rev)
+		}
+	}
+	if err != nil && err != service_def.MetadataNotFoundErr {
+		pipelineMgr.logger.Errorf("Failed to remove persisted quarantine of replication %v. err=%v\n", topic, err)
+	}
+}
+
 func (pipelineMgr *pipelineManager) getPipelineFromMap(topic string) common.Pipeline {
 	rep_status, _ := ReplicationStatus(topic)
 	if rep_status != nil {
@@ -458,13 +808,11 @@ func (pipelineMgr *pipelineManager) launchUpdater(topic string, cur_err error, r
 		pipelineMgr.logger.Infof("This node is not a KV node, would not act on replication spec %s's update\n", topic)
 		return nil
 	}
-	settingsMap := rep_status.SettingsMap()
-	retry_interval_obj := settingsMap[metadata.FailureRestartInterval]
-	// retry_interval_obj may be null in abnormal scenarios, e.g., when replication spec has been deleted
+	// failure restart interval may be missing in abnormal scenarios, e.g., when replication spec has been deleted
 	// default retry_interval to 10 seconds in such cases
 	retry_interval := default_failure_restart_interval
-	if retry_interval_obj != nil {
-		retry_interval = settingsMap[metadata.FailureRestartInterval].(int)
+	if interval, ok := metadata.SettingsMap(rep_status.SettingsMap()).GetFailureRestartInterval(); ok {
+		retry_interval = interval
 	}
 
 	updater, err := newPipelineUpdater(topic, retry_interval, pipelineMgr.child_waitGrp, cur_err, rep_status, pipelineMgr.logger)
@@ -493,6 +841,9 @@ func (pipelineMgr *pipelineManager) update(topic string, cur_err error) error {
 		pipelineMgr.repl_spec_svc.SetDerivedObj(topic, rep_status)
 		pipelineMgr.logger.Infof("ReplicationStatus is created and set with %v\n", topic)
 	}
+	if cur_err != nil {
+		pipelineMgr.setReplicationState(topic, metadata.ReplicationStateError)
+	}
 	updaterObj := rep_status.Updater()
 	if updaterObj == nil {
 		return pipelineMgr.launchUpdater(topic, cur_err, rep_status)
@@ -529,6 +880,8 @@ type pipelineUpdater struct {
 	pipeline_name string
 	//the interval to wait after the failure for next retry
 	retry_interval time.Duration
+	//the interval to wait before the next retry, which grows when the replication is quarantined
+	next_retry_interval time.Duration
 	//the number of retries
 	num_of_retries uint64
 	//finish channel
@@ -561,6 +914,7 @@ func newPipelineUpdater(pipeline_name string, retry_interval int, waitGrp *sync.
 		retry_interval: time.Duration(retry_interval) * time.Second,
 		num_of_retries: 0,
 		fin_ch:         make(chan bool, 1),
+		update_now_ch:  make(chan bool, 1),
 		done_ch:        make(chan bool, 1),
 		waitGrp:        waitGrp,
 		rep_status:     rep_status,
@@ -576,16 +930,25 @@ func (r *pipelineUpdater) start() {
 	defer r.waitGrp.Done()
 	defer close(r.done_ch)
 
-	if r.current_error == nil {
+	retry_interval := r.retry_interval
+	if wait := pipeline_mgr.quarantineWait(r.pipeline_name); wait > 0 {
+		//the replication is quarantined, possibly from before a restart. hold off until its next retry time
+		r.logger.Infof("Replication %v is quarantined. Next attempt to update it is in %v\n", r.pipeline_name, wait)
+		retry_interval = wait
+		if r.current_error != nil {
+			r.reportStatus()
+		}
+	} else if r.current_error == nil {
 		//the update is not initiated from a failure case, so don't wait, update now
 		if r.update() {
 			return
 		}
+		retry_interval = r.next_retry_interval
 	} else {
 		r.reportStatus()
 	}
 
-	ticker := time.NewTicker(r.retry_interval)
+	ticker := time.NewTicker(retry_interval)
 	defer ticker.Stop()
 	for {
 		r.updateState(Updater_Running)
@@ -600,7 +963,7 @@ func (r *pipelineUpdater) start() {
 				return
 			} else {
 				r.num_of_retries++
-				ticker = time.NewTicker(r.retry_interval)
+				ticker = time.NewTicker(r.next_retry_interval)
 			}
 		case <-ticker.C:
 			ticker.Stop()
@@ -608,7 +971,7 @@ func (r *pipelineUpdater) start() {
 				return
 			} else {
 				r.num_of_retries++
-				ticker = time.NewTicker(r.retry_interval)
+				ticker = time.NewTicker(r.next_retry_interval)
 			}
 		}
 	}
@@ -629,6 +992,10 @@ func (r *pipelineUpdater) update() bool {
 		return true
 	}
 
+	if r.checkReplicationActiveness() == ReplicationSpecNotActive && r.rep_status.Pipeline() != nil {
+		pipeline_mgr.setReplicationState(r.pipeline_name, metadata.ReplicationStatePausing)
+	}
+
 	r.logger.Infof("Try to stop pipeline %v\n", r.pipeline_name)
 	err = pipeline_mgr.stopPipeline(r.rep_status)
 	if err != nil {
@@ -646,10 +1013,12 @@ RE:
 		r.logger.Infof("Replication %v has been updated. Back to business\n", r.pipeline_name)
 	} else if err == ReplicationSpecNotActive {
 		r.logger.Infof("Replication %v has been paused. no need to update\n", r.pipeline_name)
+		pipeline_mgr.setReplicationState(r.pipeline_name, metadata.ReplicationStatePaused)
 	} else if err == service_def.MetadataNotFoundErr {
 		r.logger.Infof("Replication %v has been deleted. no need to update\n", r.pipeline_name)
 	} else {
 		r.logger.Errorf("Failed to update pipeline %v, err=%v\n", r.pipeline_name, err)
+		pipeline_mgr.setReplicationState(r.pipeline_name, metadata.ReplicationStateError)
 	}
 
 	if err == nil || err == ReplicationSpecNotActive || err == service_def.MetadataNotFoundErr {
@@ -657,6 +1026,7 @@ RE:
 		if err1 := pipeline_mgr.reportFixed(r.pipeline_name, r); err1 == nil {
 			r.rep_status.ClearErrors()
 			r.current_error = nil
+			pipeline_mgr.clearStartFailures(r.pipeline_name)
 			return true
 		} else {
 			r.logger.Errorf("Update of pipeline %v failed with error=%v\n", r.pipeline_name, err1)
@@ -666,6 +1036,7 @@ RE:
 		r.logger.Errorf("Update of pipeline %v failed with error=%v\n", r.pipeline_name, err)
 		r.current_error = err
 	}
+	r.next_retry_interval = pipeline_mgr.recordStartFailure(r.pipeline_name, r.retry_interval)
 	r.reportStatus()
 
 	return false
@@ -676,8 +1047,8 @@ func (r *pipelineUpdater) reportStatus() {
 }
 
 func (r *pipelineUpdater) checkReplicationActiveness() (err error) {
-	spec, err := pipeline_mgr.repl_spec_svc.ReplicationSpec(r.pipeline_name)
-	if err != nil || spec == nil || !spec.Settings.Active {
+	active, err := pipeline_mgr.repl_spec_svc.IsSpecActive(r.pipeline_name)
+	if err != nil || !active {
 		err = ReplicationSpecNotActive
 	} else {
 		r.logger.Debugf("Pipeline %v is not paused or deleted\n", r.pipeline_name)
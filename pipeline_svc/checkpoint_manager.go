@@ -731,6 +731,30 @@ func (ckmgr *CheckpointManager) PerformCkpt(fin_ch <-chan bool) {
 	worker_wait_grp.Wait()
 }
 
+// public API. performs one checkpoint operation right away, and returns the latest persisted checkpoint record
+// of each vb managed by the pipeline instance. vbs that have never been checkpointed are not included
+func (ckmgr *CheckpointManager) CheckpointNow() (map[uint16]metadata.CheckpointRecord, error) {
+	if !pipeline_utils.IsPipelineRunning(ckmgr.pipeline.State()) {
+		return nil, fmt.Errorf("Pipeline %v is not running", ckmgr.pipeline.Topic())
+	}
+
+	ckmgr.PerformCkpt(ckmgr.finish_ch)
+
+	ckptDocs, err := ckmgr.checkpoints_svc.CheckpointsDocs(ckmgr.pipeline.Topic())
+	if err != nil {
+		return nil, err
+	}
+
+	ckpt_records := make(map[uint16]metadata.CheckpointRecord)
+	for _, vbno := range ckmgr.getMyVBs() {
+		ckptDoc, ok := ckptDocs[vbno]
+		if ok && ckptDoc != nil && len(ckptDoc.Checkpoint_records) > 0 && ckptDoc.Checkpoint_records[0] != nil {
+			ckpt_records[vbno] = *ckptDoc.Checkpoint_records[0]
+		}
+	}
+	return ckpt_records, nil
+}
+
 // local API. supports periodical checkpoint operations
 func (ckmgr *CheckpointManager) performCkpt(fin_ch <-chan bool, wait_grp *sync.WaitGroup) {
 	ckmgr.logger.Infof("Start checkpointing for replication %v\n", ckmgr.pipeline.Topic())
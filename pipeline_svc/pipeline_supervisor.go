@@ -220,6 +220,10 @@ func (pipelineSupervisor *PipelineSupervisor) OnEvent(event *common.Event) {
 		settings := make(map[string]interface{})
 		if errType == base.VBErrorType_Source {
 			settings[base.ProblematicVBSource] = map[uint16]error{vbno: err}
+		} else if errType == base.VBErrorType_Isolated {
+			// isolated vbs do not require pipeline restart. they stay isolated till pipeline is restarted
+			pipelineSupervisor.Logger().Errorf("%v vb %v has been isolated. err=%v\n", pipelineSupervisor.pipeline.Topic(), vbno, err)
+			settings[base.IsolatedVBs] = map[uint16]error{vbno: err}
 		} else {
 			settings[base.ProblematicVBTarget] = map[uint16]error{vbno: err}
 		}
@@ -62,6 +62,15 @@ const (
 	DOCS_LATENCY_METRIC = "wtavg_docs_latency"
 	META_LATENCY_METRIC = "wtavg_meta_latency"
 	RESP_WAIT_METRIC    = "resp_wait_time"
+	// time waited for the acks of durable writes, which are kept apart from RESP_WAIT_METRIC
+	// since they include the time taken to replicate and persist the writes on target
+	DURABLE_RESP_WAIT_METRIC = "durable_resp_wait_time"
+
+	// end to end latency of the last canary document, in milliseconds
+	CANARY_LATENCY_METRIC = "canary_latency"
+
+	// the number of temporary failure responses received from target
+	DOCS_TMPFAIL_METRIC = "docs_tmpfail"
 
 	//checkpointing related statistics
 	DOCS_CHECKED_METRIC    = "docs_checked" //calculated
@@ -129,9 +138,18 @@ var OverviewMetricKeys = []string{DOCS_WRITTEN_METRIC, EXPIRY_DOCS_WRITTEN_METRI
 	EXPIRY_FILTERED_METRIC, DELETION_FILTERED_METRIC, SET_FILTERED_METRIC, NUM_CHECKPOINTS_METRIC, NUM_FAILEDCKPTS_METRIC,
 	TIME_COMMITING_METRIC, DOCS_OPT_REPD_METRIC, DOCS_RECEIVED_DCP_METRIC, EXPIRY_RECEIVED_DCP_METRIC,
 	DELETION_RECEIVED_DCP_METRIC, SET_RECEIVED_DCP_METRIC, SIZE_REP_QUEUE_METRIC, DOCS_REP_QUEUE_METRIC, DOCS_LATENCY_METRIC,
-	RESP_WAIT_METRIC, META_LATENCY_METRIC, DCP_DISPATCH_TIME_METRIC, DCP_DATACH_LEN,
+	RESP_WAIT_METRIC, META_LATENCY_METRIC, DCP_DISPATCH_TIME_METRIC, DCP_DATACH_LEN, DOCS_TMPFAIL_METRIC,
+	DURABLE_RESP_WAIT_METRIC,
 }
 
+// key metrics in overview whose history is retained when statistics history is enabled
+var StatsHistoryMetricKeys = []string{CHANGES_LEFT_METRIC, DOCS_WRITTEN_METRIC, DOCS_PROCESSED_METRIC,
+	DOCS_FAILED_CR_SOURCE_METRIC, DOCS_FILTERED_METRIC, SIZE_REP_QUEUE_METRIC, DOCS_REP_QUEUE_METRIC,
+	DOCS_LATENCY_METRIC, META_LATENCY_METRIC, RATE_REPLICATED_METRIC, RATE_RECEIVED_DCP_METRIC,
+	BANDWIDTH_USAGE_METRIC}
+
+var StatsHistoryDisabledError = errors.New("Statistics history is not enabled")
+
 type SampleStats struct {
 	Count int64
 	Mean  float64
@@ -220,6 +238,25 @@ func GetStatisticsForPipeline(topic string) (*expvar.Map, error) {
 	return repl_status.GetOverviewStats(), nil
 }
 
+// Statistics history of a replication, from the oldest sample to the newest
+func GetStatisticsHistory(replicationId string, metric string) ([]base.TimedSample, error) {
+	repl_status, err := pipeline_manager.ReplicationStatus(replicationId)
+	if err != nil {
+		return nil, err
+	}
+
+	history := repl_status.StatsHistory()
+	if history == nil {
+		return nil, StatsHistoryDisabledError
+	}
+
+	samples, ok := history.Samples(metric)
+	if !ok {
+		return nil, fmt.Errorf("No history is recorded for metric %v of replication %v", metric, replicationId)
+	}
+	return samples, nil
+}
+
 func (stats_mgr *StatisticsManager) initialize() {
 	for _, vb_list := range stats_mgr.active_vbs {
 		for _, vb := range vb_list {
@@ -458,11 +495,42 @@ func (stats_mgr *StatisticsManager) processRawStats() error {
 		return err
 	}
 
+	//publish latency measured with canary documents, if available
+	if canary_latency := rs.CanaryLatency(); canary_latency > 0 {
+		canary_latency_var := new(expvar.Int)
+		canary_latency_var.Set(int64(canary_latency / time.Millisecond))
+		map_for_overview.Set(CANARY_LATENCY_METRIC, canary_latency_var)
+	}
+
 	stats_mgr.logger.Debugf("Overview=%v for pipeline %v\n", map_for_overview, stats_mgr.pipeline.Topic())
 	rs.SetOverviewStats(map_for_overview)
+	stats_mgr.recordStatsHistory(rs, map_for_overview)
 	return nil
 }
 
+// add the current values of key metrics to statistics history of the replication
+func (stats_mgr *StatisticsManager) recordStatsHistory(rs *pipeline_pkg.ReplicationStatus, overview_expvar_map *expvar.Map) {
+	history := rs.StatsHistory()
+	if history == nil {
+		return
+	}
+
+	values := make(map[string]float64)
+	for _, metric := range StatsHistoryMetricKeys {
+		metric_var := overview_expvar_map.Get(metric)
+		if metric_var == nil {
+			continue
+		}
+		value, err := strconv.ParseFloat(metric_var.String(), 64)
+		if err != nil {
+			stats_mgr.logger.Debugf("Skipping history of metric %v with non-numeric value %v\n", metric, metric_var.String())
+			continue
+		}
+		values[metric] = value
+	}
+	history.Record(time.Now(), values)
+}
+
 func (stats_mgr *StatisticsManager) processCalculatedStats(overview_expvar_map *expvar.Map, docs_written_old,
 	docs_received_dcp_old, docs_opt_repd_old, data_replicated_old, docs_checked_old int64) error {
 
@@ -792,8 +860,12 @@ func (outNozzle_collector *outNozzleCollector) Mount(pipeline common.Pipeline, s
 		registry.Register(DOCS_LATENCY_METRIC, docs_latency)
 		resp_wait := metrics.NewHistogram(metrics.NewUniformSample(stats_mgr.sample_size))
 		registry.Register(RESP_WAIT_METRIC, resp_wait)
+		durable_resp_wait := metrics.NewHistogram(metrics.NewUniformSample(stats_mgr.sample_size))
+		registry.Register(DURABLE_RESP_WAIT_METRIC, durable_resp_wait)
 		meta_latency := metrics.NewHistogram(metrics.NewUniformSample(stats_mgr.sample_size))
 		registry.Register(META_LATENCY_METRIC, meta_latency)
+		docs_tmpfail := metrics.NewCounter()
+		registry.Register(DOCS_TMPFAIL_METRIC, docs_tmpfail)
 
 		metric_map := make(map[string]interface{})
 		metric_map[SIZE_REP_QUEUE_METRIC] = size_rep_queue
@@ -810,7 +882,9 @@ func (outNozzle_collector *outNozzleCollector) Mount(pipeline common.Pipeline, s
 		metric_map[DOCS_OPT_REPD_METRIC] = docs_opt_repd
 		metric_map[DOCS_LATENCY_METRIC] = docs_latency
 		metric_map[RESP_WAIT_METRIC] = resp_wait
+		metric_map[DURABLE_RESP_WAIT_METRIC] = durable_resp_wait
 		metric_map[META_LATENCY_METRIC] = meta_latency
+		metric_map[DOCS_TMPFAIL_METRIC] = docs_tmpfail
 		outNozzle_collector.component_map[part.Id()] = metric_map
 
 		// register outNozzle_collector as the sync event listener/handler for StatsUpdate event
@@ -842,6 +916,10 @@ func (outNozzle_collector *outNozzleCollector) ProcessEvent(event *common.Event)
 		queue_size_bytes := event.OtherInfos.([]int)[1]
 		setCounter(metric_map[DOCS_REP_QUEUE_METRIC].(metrics.Counter), queue_size)
 		setCounter(metric_map[SIZE_REP_QUEUE_METRIC].(metrics.Counter), queue_size_bytes)
+		// only xmem nozzles report the number of temporary failures
+		if len(event.OtherInfos.([]int)) > 2 {
+			setCounter(metric_map[DOCS_TMPFAIL_METRIC].(metrics.Counter), event.OtherInfos.([]int)[2])
+		}
 	} else if event.EventType == common.DataSent {
 		outNozzle_collector.stats_mgr.logger.Debugf("Received a DataSent event from %v", reflect.TypeOf(event.Component))
 		event_otherInfo := event.OtherInfos.(parts.DataSentEventAdditional)
@@ -870,7 +948,11 @@ func (outNozzle_collector *outNozzleCollector) ProcessEvent(event *common.Event)
 		}
 
 		metric_map[DOCS_LATENCY_METRIC].(metrics.Histogram).Sample().Update(commit_time.Nanoseconds() / 1000000)
-		metric_map[RESP_WAIT_METRIC].(metrics.Histogram).Sample().Update(resp_wait_time.Nanoseconds() / 1000000)
+		if event_otherInfo.IsDurable {
+			metric_map[DURABLE_RESP_WAIT_METRIC].(metrics.Histogram).Sample().Update(resp_wait_time.Nanoseconds() / 1000000)
+		} else {
+			metric_map[RESP_WAIT_METRIC].(metrics.Histogram).Sample().Update(resp_wait_time.Nanoseconds() / 1000000)
+		}
 	} else if event.EventType == common.DataFailedCRSource {
 		outNozzle_collector.stats_mgr.logger.Debugf("Received a DataFailedCRSource event from %v", reflect.TypeOf(event.Component))
 		metric_map[DOCS_FAILED_CR_SOURCE_METRIC].(metrics.Counter).Inc(1)
@@ -1037,14 +1119,15 @@ func (r_collector *routerCollector) ProcessEvent(event *common.Event) error {
 		r_collector.stats_mgr.logger.Debugf("Received a DataFiltered event for %v", seqno)
 		metric_map[DOCS_FILTERED_METRIC].(metrics.Counter).Inc(1)
 
-		if uprEvent.Expiry != 0 {
+		// expirations are filtered by router when only mutations are replicated
+		if uprEvent.Expiry != 0 || uprEvent.Opcode == mc.UPR_EXPIRATION {
 			metric_map[EXPIRY_FILTERED_METRIC].(metrics.Counter).Inc(1)
 		}
 		if uprEvent.Opcode == mc.UPR_DELETION {
 			metric_map[DELETION_FILTERED_METRIC].(metrics.Counter).Inc(1)
 		} else if uprEvent.Opcode == mc.UPR_MUTATION {
 			metric_map[SET_FILTERED_METRIC].(metrics.Counter).Inc(1)
-		} else {
+		} else if uprEvent.Opcode != mc.UPR_EXPIRATION {
 			panic(fmt.Sprintf("Invalid opcode, %v, in DataFiltered event from %v.", uprEvent.Opcode, event.Component.Id()))
 		}
 	}
@@ -1121,60 +1204,119 @@ func UpdateStats(cluster_info_svc service_def.ClusterInfoSvc, xdcr_topology_svc
 	kv_mem_client_error_count map[string]int, logger *log.CommonLogger) {
 	logger.Debug("updateStats for paused replications")
 
+	// replications whose stats are not being updated by running pipelines
+	repl_statuses := make(map[string]*pipeline_pkg.ReplicationStatus)
+	sourceBucketNames := make([]string, 0)
 	for repl_id, repl_status := range pipeline_manager.ReplicationStatusMap() {
-		overview_stats := repl_status.GetOverviewStats()
 		spec := repl_status.Spec()
+		if spec == nil {
+			continue
+		}
+		// overview stats may be nil the first time GetStats is called on a paused replication that has never been run in the current goxdcr session
+		// or it may be nil when the underying replication is not paused but has not completed startup process
+		if repl_status.GetOverviewStats() != nil && repl_status.RuntimeStatus(true) == pipeline_pkg.Replicating {
+			continue
+		}
+		repl_statuses[repl_id] = repl_status
+		sourceBucketNames = append(sourceBucketNames, spec.SourceBucketName)
+	}
+
+	if len(repl_statuses) == 0 {
+		return
+	}
+
+	fetcher := func(sourceBucketName string) (*sourceBucketStats, error) {
+		return getSourceBucketStats(sourceBucketName, cluster_info_svc, xdcr_topology_svc, kv_mem_clients, kv_mem_client_error_count, logger)
+	}
+	bucket_stats_map, bucket_err_map := fetchSourceBucketStats(sourceBucketNames, fetcher)
 
+	for repl_id, repl_status := range repl_statuses {
+		spec := repl_status.Spec()
 		if spec == nil {
 			continue
 		}
 
-		cur_kv_vb_map, err := pipeline_utils.GetSourceVBMap(cluster_info_svc, xdcr_topology_svc, spec.SourceBucketName, logger)
-		if err != nil {
-			logger.Errorf("Error retrieving kv_vb_map for paused replication %v. err=%v", repl_id, err)
+		bucket_stats, ok := bucket_stats_map[spec.SourceBucketName]
+		if !ok {
+			logger.Errorf("Error retrieving stats of source bucket %v for paused replication %v. err=%v", spec.SourceBucketName, repl_id, bucket_err_map[spec.SourceBucketName])
 			continue
 		}
 
-		if overview_stats == nil {
-			// overview stats may be nil the first time GetStats is called on a paused replication that has never been run in the current goxdcr session
-			// or it may be nil when the underying replication is not paused but has not completed startup process
-			// construct it
-			overview_stats, err := constructStatsForReplication(spec, cur_kv_vb_map, checkpoints_svc, kv_mem_clients, kv_mem_client_error_count, logger)
+		if repl_status.GetOverviewStats() == nil {
+			// construct overview stats
+			overview_stats, err := constructStatsForReplication(spec, bucket_stats, checkpoints_svc, logger)
 			if err != nil {
 				logger.Errorf("Error constructing stats for paused replication %v. err=%v", repl_id, err)
 				continue
 			}
 			repl_status.SetOverviewStats(overview_stats)
 		} else {
-			if repl_status.RuntimeStatus(true) != pipeline_pkg.Replicating {
-				err := updateStatsForReplication(repl_status, cur_kv_vb_map, checkpoints_svc, kv_mem_clients, kv_mem_client_error_count, logger)
-				if err != nil {
-					logger.Errorf("Error updating stats for paused replication %v. err=%v", repl_id, err)
-					continue
-				}
+			err := updateStatsForReplication(repl_status, bucket_stats, checkpoints_svc, logger)
+			if err != nil {
+				logger.Errorf("Error updating stats for paused replication %v. err=%v", repl_id, err)
+				continue
 			}
 		}
 	}
 }
 
-// compute and set changes_left and docs_processed stats. set other stats to 0
-func constructStatsForReplication(spec *metadata.ReplicationSpecification, cur_kv_vb_map map[string][]uint16,
-	checkpoints_svc service_def.CheckpointsService, kv_mem_clients map[string]*mcc.Client,
-	kv_mem_client_error_count map[string]int, logger *log.CommonLogger) (*expvar.Map, error) {
-	cur_vb_list := simple_utils.GetVbListFromKvVbMap(cur_kv_vb_map)
-	docs_processed, err := getDocsProcessedForReplication(spec.Id, cur_vb_list, checkpoints_svc, logger)
+// snapshot of the vb map and the high seqnos of a source bucket.
+// it is retrieved once per stats update and shared by all replications sourcing the bucket
+type sourceBucketStats struct {
+	kv_vb_map     map[string][]uint16
+	total_changes int64
+}
+
+type sourceBucketStatsFetcher func(sourceBucketName string) (*sourceBucketStats, error)
+
+// retrieves the stats of each distinct source bucket exactly once, no matter how many replications source the bucket.
+// buckets whose stats cannot be retrieved are returned in the error map
+func fetchSourceBucketStats(sourceBucketNames []string, fetcher sourceBucketStatsFetcher) (map[string]*sourceBucketStats, map[string]error) {
+	bucket_stats_map := make(map[string]*sourceBucketStats)
+	bucket_err_map := make(map[string]error)
+	for _, sourceBucketName := range sourceBucketNames {
+		if _, ok := bucket_stats_map[sourceBucketName]; ok {
+			continue
+		}
+		if _, ok := bucket_err_map[sourceBucketName]; ok {
+			continue
+		}
+		bucket_stats, err := fetcher(sourceBucketName)
+		if err != nil {
+			bucket_err_map[sourceBucketName] = err
+		} else {
+			bucket_stats_map[sourceBucketName] = bucket_stats
+		}
+	}
+	return bucket_stats_map, bucket_err_map
+}
+
+func getSourceBucketStats(sourceBucketName string, cluster_info_svc service_def.ClusterInfoSvc, xdcr_topology_svc service_def.XDCRCompTopologySvc,
+	kv_mem_clients map[string]*mcc.Client, kv_mem_client_error_count map[string]int, logger *log.CommonLogger) (*sourceBucketStats, error) {
+	kv_vb_map, err := pipeline_utils.GetSourceVBMap(cluster_info_svc, xdcr_topology_svc, sourceBucketName, logger)
+	if err != nil {
+		return nil, err
+	}
+	total_changes, err := calculateTotalChanges(kv_vb_map, kv_mem_clients, kv_mem_client_error_count, sourceBucketName, logger)
 	if err != nil {
 		return nil, err
 	}
+	return &sourceBucketStats{kv_vb_map: kv_vb_map, total_changes: total_changes}, nil
+}
 
-	total_changes, err := calculateTotalChanges(cur_kv_vb_map, kv_mem_clients, kv_mem_client_error_count, spec.SourceBucketName, logger)
+// compute and set changes_left and docs_processed stats. set other stats to 0
+func constructStatsForReplication(spec *metadata.ReplicationSpecification, bucket_stats *sourceBucketStats,
+	checkpoints_svc service_def.CheckpointsService, logger *log.CommonLogger) (*expvar.Map, error) {
+	cur_vb_list := simple_utils.GetVbListFromKvVbMap(bucket_stats.kv_vb_map)
+	docs_processed, err := getDocsProcessedForReplication(spec.Id, cur_vb_list, checkpoints_svc, logger)
 	if err != nil {
 		return nil, err
 	}
 
+	total_changes := bucket_stats.total_changes
 	changes_left := total_changes - int64(docs_processed)
 
-	logger.Infof("Calculating stats for never run replication %v. kv_vb_map=%v, total_docs=%v, docs_processed=%v, changes_left=%v\n", spec.Id, cur_kv_vb_map, total_changes, docs_processed, changes_left)
+	logger.Infof("Calculating stats for never run replication %v. kv_vb_map=%v, total_docs=%v, docs_processed=%v, changes_left=%v\n", spec.Id, bucket_stats.kv_vb_map, total_changes, docs_processed, changes_left)
 
 	overview_map := new(expvar.Map).Init()
 	overview_map.Add(DOCS_PROCESSED_METRIC, int64(docs_processed))
@@ -1222,9 +1364,8 @@ func calculateTotalChanges(kv_vb_map map[string][]uint16, kv_mem_clients map[str
 	return int64(total_changes), nil
 }
 
-func updateStatsForReplication(repl_status *pipeline_pkg.ReplicationStatus, cur_kv_vb_map map[string][]uint16,
-	checkpoints_svc service_def.CheckpointsService, kv_mem_clients map[string]*mcc.Client,
-	kv_mem_client_error_count map[string]int, logger *log.CommonLogger) error {
+func updateStatsForReplication(repl_status *pipeline_pkg.ReplicationStatus, bucket_stats *sourceBucketStats,
+	checkpoints_svc service_def.CheckpointsService, logger *log.CommonLogger) error {
 
 	// if pipeline is not running, update docs_processed and changes_left stats, which are not being
 	// updated by running pipeline and may have become inaccurate
@@ -1245,6 +1386,7 @@ func updateStatsForReplication(repl_status *pipeline_pkg.ReplicationStatus, cur_
 		return nil
 	}
 
+	cur_kv_vb_map := bucket_stats.kv_vb_map
 	cur_vb_list := simple_utils.GetVbListFromKvVbMap(cur_kv_vb_map)
 	simple_utils.SortUint16List(cur_vb_list)
 	sameList := simple_utils.AreSortedUint16ListsTheSame(old_vb_list, cur_vb_list)
@@ -1264,11 +1406,7 @@ func updateStatsForReplication(repl_status *pipeline_pkg.ReplicationStatus, cur_
 		repl_status.SetVbList(cur_vb_list)
 	}
 
-	total_changes, err := calculateTotalChanges(cur_kv_vb_map, kv_mem_clients, kv_mem_client_error_count, spec.SourceBucketName, logger)
-	if err != nil {
-		return err
-	}
-
+	total_changes := bucket_stats.total_changes
 	changes_left := total_changes - docs_processed
 	changes_left_var := new(expvar.Int)
 	changes_left_var.Set(changes_left)
@@ -0,0 +1,153 @@
+// Copyright (c) 2013 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package pipeline_svc
+
+import (
+	"errors"
+	"fmt"
+	mc "github.com/couchbase/gomemcached"
+	mcc "github.com/couchbase/gomemcached/client"
+	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/common"
+	"github.com/couchbase/goxdcr/log"
+	"github.com/couchbase/goxdcr/metadata"
+	"github.com/couchbase/goxdcr/parts"
+	"github.com/rcrowley/go-metrics"
+	"testing"
+)
+
+const (
+	benchmarkNumOfReplications = 100
+	benchmarkNumOfBuckets      = 5
+	benchmarkNumOfVbs          = 1024
+)
+
+// returns a fetcher that builds source bucket stats in memory, and the number of times it has been called.
+// every call stands for one round of stats requests to source
+func newTestSourceBucketStatsFetcher(failedBuckets map[string]bool) (sourceBucketStatsFetcher, *int) {
+	calls := 0
+	fetcher := func(sourceBucketName string) (*sourceBucketStats, error) {
+		calls++
+		if failedBuckets[sourceBucketName] {
+			return nil, errors.New("stats not available")
+		}
+		vbnos := make([]uint16, benchmarkNumOfVbs)
+		highseqno_map := make(map[uint16]uint64)
+		for i := range vbnos {
+			vbnos[i] = uint16(i)
+			highseqno_map[uint16(i)] = uint64(i)
+		}
+		var total_changes int64
+		for _, vbno := range vbnos {
+			total_changes += int64(highseqno_map[vbno])
+		}
+		return &sourceBucketStats{kv_vb_map: map[string][]uint16{"127.0.0.1:11210": vbnos}, total_changes: total_changes}, nil
+	}
+	return fetcher, &calls
+}
+
+func testSourceBucketNames(numOfReplications, numOfBuckets int) []string {
+	sourceBucketNames := make([]string, numOfReplications)
+	for i := range sourceBucketNames {
+		sourceBucketNames[i] = fmt.Sprintf("bucket%v", i%numOfBuckets)
+	}
+	return sourceBucketNames
+}
+
+func TestFetchSourceBucketStats(t *testing.T) {
+	fetcher, calls := newTestSourceBucketStatsFetcher(map[string]bool{"bucket1": true})
+	bucket_stats_map, bucket_err_map := fetchSourceBucketStats(testSourceBucketNames(10, 3), fetcher)
+
+	// stats of each bucket are retrieved once, including those that failed
+	if *calls != 3 {
+		t.Errorf("fetcher was called %v times, expected 3", *calls)
+	}
+	if len(bucket_stats_map) != 2 || bucket_stats_map["bucket0"] == nil || bucket_stats_map["bucket2"] == nil {
+		t.Errorf("unexpected bucket stats %v", bucket_stats_map)
+	}
+	if len(bucket_err_map) != 1 || bucket_err_map["bucket1"] == nil {
+		t.Errorf("unexpected bucket errors %v", bucket_err_map)
+	}
+}
+
+// passes the events raised by router to router collector synchronously, in place of the async event listener in pipelines
+type testRouterCollectorListener struct {
+	collector *routerCollector
+	t         *testing.T
+}
+
+func (l *testRouterCollectorListener) OnEvent(event *common.Event) {
+	if err := l.collector.ProcessEvent(event); err != nil {
+		l.t.Errorf("router collector failed to process event. err=%v", err)
+	}
+}
+
+// downstream part that accepts and discards everything it receives
+type testDiscardingPart struct {
+	common.Part
+}
+
+func (p *testDiscardingPart) Receive(data interface{}) error {
+	return nil
+}
+
+func TestRouterCollectorWithMutationsOnly(t *testing.T) {
+	router, err := parts.NewRouter("testRouter", "testTopic", "", "", metadata.ReplicateOpsMutationsOnly, metadata.BalanceModeVbucket,
+		map[string]common.Part{"testPart": &testDiscardingPart{}},
+		map[uint16]string{0: "testPart"}, base.CRMode_RevId, log.DefaultLoggerContext, nil)
+	if err != nil {
+		t.Fatalf("Failed to create router. err=%v", err)
+	}
+
+	metric_map := map[string]interface{}{DOCS_FILTERED_METRIC: metrics.NewCounter(),
+		EXPIRY_FILTERED_METRIC:   metrics.NewCounter(),
+		DELETION_FILTERED_METRIC: metrics.NewCounter(),
+		SET_FILTERED_METRIC:      metrics.NewCounter()}
+	collector := &routerCollector{stats_mgr: &StatisticsManager{logger: log.NewLogger("StatisticsManagerTest", log.DefaultLoggerContext)},
+		component_map: map[string]map[string]interface{}{router.Id(): metric_map}}
+	router.RegisterComponentEventListener(common.DataFiltered, &testRouterCollectorListener{collector, t})
+
+	for index, opcode := range []mc.CommandCode{mc.UPR_MUTATION, mc.UPR_EXPIRATION, mc.UPR_DELETION} {
+		if err := router.Forward(&mcc.UprEvent{Opcode: opcode, VBucket: 0, Key: []byte("key"), Seqno: uint64(index + 1)}); err != nil {
+			t.Fatalf("Unexpected error routing %v. err=%v", opcode, err)
+		}
+	}
+
+	expected := map[string]int64{DOCS_FILTERED_METRIC: 2, EXPIRY_FILTERED_METRIC: 1, DELETION_FILTERED_METRIC: 1, SET_FILTERED_METRIC: 0}
+	for metric, count := range expected {
+		if actual := metric_map[metric].(metrics.Counter).Count(); actual != count {
+			t.Errorf("%v is %v, expected %v", metric, actual, count)
+		}
+	}
+}
+
+func BenchmarkSourceBucketStatsPerReplication(b *testing.B) {
+	fetcher, _ := newTestSourceBucketStatsFetcher(nil)
+	sourceBucketNames := testSourceBucketNames(benchmarkNumOfReplications, benchmarkNumOfBuckets)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, sourceBucketName := range sourceBucketNames {
+			if _, err := fetcher(sourceBucketName); err != nil {
+				b.Fatalf("unexpected error %v", err)
+			}
+		}
+	}
+}
+
+func BenchmarkSourceBucketStatsBatched(b *testing.B) {
+	fetcher, _ := newTestSourceBucketStatsFetcher(nil)
+	sourceBucketNames := testSourceBucketNames(benchmarkNumOfReplications, benchmarkNumOfBuckets)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, bucket_err_map := fetchSourceBucketStats(sourceBucketNames, fetcher); len(bucket_err_map) != 0 {
+			b.Fatalf("unexpected errors %v", bucket_err_map)
+		}
+	}
+}
@@ -18,9 +18,15 @@ import (
 	"github.com/couchbase/goxdcr/service_def"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var ErrorNoSourceKV = errors.New("Invalid configuration. No source kv node is found.")
+var ErrorSourceBucketNotReady = errors.New("Source bucket is not ready. Its vbuckets are not available yet.")
+
+// initial and max backoff time between two checks of source bucket readiness
+var SourceBucketReadyCheckInitialBackoff = 500 * time.Millisecond
+var SourceBucketReadyCheckMaxBackoff = 10 * time.Second
 
 func GetSourceVBListPerPipeline(pipeline common.Pipeline) []uint16 {
 	ret := []uint16{}
@@ -69,6 +75,41 @@ func GetSourceVBMap(cluster_info_svc service_def.ClusterInfoSvc, xdcr_topology_s
 	return kv_vb_map, nil
 }
 
+// waits for the vbuckets of source bucket to become available, e.g., when source bucket is still warming up.
+// getServerVBMap returns the server vb map of source bucket. it is retried with exponential backoff till timeout expires,
+// after which ErrorSourceBucketNotReady is returned
+func WaitForSourceBucketReady(sourceBucketName string, getServerVBMap func() (map[string][]uint16, error),
+	timeout time.Duration, logger *log.CommonLogger) error {
+	deadline := time.Now().Add(timeout)
+	backoff := SourceBucketReadyCheckInitialBackoff
+	for {
+		server_vbmap, err := getServerVBMap()
+		if err == nil {
+			for _, vbnos := range server_vbmap {
+				if len(vbnos) > 0 {
+					return nil
+				}
+			}
+		}
+
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			logger.Errorf("Source bucket %v is still not ready after %v. err=%v\n", sourceBucketName, timeout, err)
+			return ErrorSourceBucketNotReady
+		}
+		if backoff > remaining {
+			backoff = remaining
+		}
+		logger.Infof("Source bucket %v is not ready. Checking again in %v. err=%v\n", sourceBucketName, backoff, err)
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > SourceBucketReadyCheckMaxBackoff {
+			backoff = SourceBucketReadyCheckMaxBackoff
+		}
+	}
+}
+
 // checks if target cluster supports ssl over memcached
 func HasSSLOverMemSupport(cluster_info_svc service_def.ClusterInfoSvc, targetClusterRef *metadata.RemoteClusterReference) (bool, error) {
 	return cluster_info_svc.IsClusterCompatible(targetClusterRef, []int{3, 0})
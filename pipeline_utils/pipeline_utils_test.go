@@ -0,0 +1,61 @@
+package pipeline_utils
+
+import (
+	"errors"
+	"github.com/couchbase/goxdcr/log"
+	"testing"
+	"time"
+)
+
+var testLogger = log.NewLogger("PipelineUtilsTest", log.DefaultLoggerContext)
+
+// returns a server vb map getter for a bucket that becomes ready after warmupTime
+func newWarmingBucket(warmupTime time.Duration) func() (map[string][]uint16, error) {
+	readyTime := time.Now().Add(warmupTime)
+	return func() (map[string][]uint16, error) {
+		if time.Now().Before(readyTime) {
+			if time.Now().Before(readyTime.Add(-warmupTime / 2)) {
+				return nil, errors.New("bucket info not available")
+			}
+			return map[string][]uint16{"127.0.0.1:12000": []uint16{}}, nil
+		}
+		return map[string][]uint16{"127.0.0.1:12000": []uint16{0, 1, 2}}, nil
+	}
+}
+
+func TestWaitForSourceBucketReady(t *testing.T) {
+	oldInitialBackoff := SourceBucketReadyCheckInitialBackoff
+	SourceBucketReadyCheckInitialBackoff = 10 * time.Millisecond
+	defer func() { SourceBucketReadyCheckInitialBackoff = oldInitialBackoff }()
+
+	warmupTime := 200 * time.Millisecond
+	start_time := time.Now()
+	err := WaitForSourceBucketReady("testBucket", newWarmingBucket(warmupTime), 5*time.Second, testLogger)
+	if err != nil {
+		t.Fatalf("Unexpected error waiting for source bucket. err=%v", err)
+	}
+	if elapsed := time.Since(start_time); elapsed < warmupTime {
+		t.Errorf("Returned after %v, before source bucket became ready after %v", elapsed, warmupTime)
+	}
+}
+
+func TestWaitForSourceBucketReadyTimeout(t *testing.T) {
+	oldInitialBackoff := SourceBucketReadyCheckInitialBackoff
+	SourceBucketReadyCheckInitialBackoff = 10 * time.Millisecond
+	defer func() { SourceBucketReadyCheckInitialBackoff = oldInitialBackoff }()
+
+	timeout := 100 * time.Millisecond
+	start_time := time.Now()
+	err := WaitForSourceBucketReady("testBucket", newWarmingBucket(time.Hour), timeout, testLogger)
+	if err != ErrorSourceBucketNotReady {
+		t.Fatalf("Error is %v, expected %v", err, ErrorSourceBucketNotReady)
+	}
+	if elapsed := time.Since(start_time); elapsed < timeout || elapsed > timeout+time.Second {
+		t.Errorf("Gave up after %v, expected timeout of %v", elapsed, timeout)
+	}
+
+	// ready bucket does not need to wait even when timeout is 0
+	if err = WaitForSourceBucketReady("testBucket", newWarmingBucket(0), 0, testLogger); err != nil {
+		t.Errorf("Unexpected error for ready source bucket. err=%v", err)
+	}
+}
@@ -12,6 +12,7 @@
 package replication_manager
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -22,8 +23,11 @@ import (
 	"github.com/couchbase/goxdcr/log"
 	"github.com/couchbase/goxdcr/metadata"
 	"github.com/couchbase/goxdcr/pipeline_manager"
+	"github.com/couchbase/goxdcr/service_def"
 	"github.com/couchbase/goxdcr/simple_utils"
+	"github.com/couchbase/goxdcr/supervisor"
 	"github.com/couchbase/goxdcr/utils"
+	"net"
 	"net/http"
 	"runtime"
 	"strconv"
@@ -33,8 +37,8 @@ import (
 
 import _ "net/http/pprof"
 
-var StaticPaths = []string{base.RemoteClustersPath, CreateReplicationPath, InternalSettingsPath, SettingsReplicationsPath, AllReplicationsPath, AllReplicationInfosPath, RegexpValidationPrefix, MemStatsPath, BlockProfileStartPath, BlockProfileStopPath, XDCRInternalSettingsPath}
-var DynamicPathPrefixes = []string{base.RemoteClustersPath, DeleteReplicationPrefix, SettingsReplicationsPath, StatisticsPrefix, AllReplicationsPath, BucketSettingsPrefix}
+var StaticPaths = []string{base.RemoteClustersPath, CreateReplicationPath, InternalSettingsPath, SettingsReplicationsPath, AllReplicationsPath, AllReplicationInfosPath, RegexpValidationPrefix, MemStatsPath, BlockProfileStartPath, BlockProfileStopPath, XDCRInternalSettingsPath, ValidationsPath, ReconcilePipelinesPath, SettingsSchemaPath, ValidateSpecsPath, NamedFiltersPath}
+var DynamicPathPrefixes = []string{base.RemoteClustersPath, DeleteReplicationPrefix, SettingsReplicationsPath, StatisticsPrefix, AllReplicationsPath, BucketSettingsPrefix, ValidationsPath, CompareSettingsPrefix}
 
 var logger_ap *log.CommonLogger = log.NewLogger("AdminPort", log.DefaultLoggerContext)
 
@@ -44,11 +48,13 @@ var logger_ap *log.CommonLogger = log.NewLogger("AdminPort", log.DefaultLoggerCo
 type Adminport struct {
 	sourceKVHost string
 	xdcrRestPort uint16
+	// when not nil, adminport serves https instead of http
+	tlsConfig *tls.Config
 	gen_server.GenServer
 	finch chan bool
 }
 
-func NewAdminport(laddr string, xdcrRestPort uint16, finch chan bool) *Adminport {
+func NewAdminport(laddr string, xdcrRestPort uint16, tlsConfig *tls.Config, finch chan bool) *Adminport {
 
 	//callback functions from GenServer
 	var msg_callback_func gen_server.Msg_Callback_Func
@@ -61,6 +67,7 @@ func NewAdminport(laddr string, xdcrRestPort uint16, finch chan bool) *Adminport
 	adminport := &Adminport{
 		sourceKVHost: laddr,
 		xdcrRestPort: xdcrRestPort,
+		tlsConfig:    tlsConfig,
 		GenServer:    server, /*gen_server.GenServer*/
 		finch:        finch,
 	}
@@ -87,10 +94,21 @@ func (adminport *Adminport) Start() {
 
 	// start http server
 	reqch := make(chan ap.Request)
-	hostAddr := utils.GetHostAddr(adminport.sourceKVHost, adminport.xdcrRestPort)
-	server := ap.NewHTTPServer("xdcr", hostAddr, base.AdminportUrlPrefix, reqch, new(ap.Handler))
+	// JoinHostPort adds the brackets needed by ipv6 bind addresses
+	hostAddr := net.JoinHostPort(adminport.sourceKVHost, strconv.FormatUint(uint64(adminport.xdcrRestPort), base.ParseIntBase))
+	var server ap.Server
+	if adminport.tlsConfig != nil {
+		server = ap.NewHTTPSServer("xdcr", hostAddr, base.AdminportUrlPrefix, reqch, new(ap.Handler), adminport.tlsConfig)
+	} else {
+		server = ap.NewHTTPServer("xdcr", hostAddr, base.AdminportUrlPrefix, reqch, new(ap.Handler))
+	}
 	finch := adminport.finch
 
+	err = utils.ValidateBindAddress(adminport.sourceKVHost)
+	if err != nil {
+		goto done
+	}
+
 	err = server.Start()
 	if err != nil {
 		goto done
@@ -132,13 +150,32 @@ func (adminport *Adminport) processRequest(msg []interface{}) error {
 	}
 
 	req := msg[0].(ap.Request)
-	httpReq := req.GetHttpRequest()
-	if response, err := adminport.handleRequest(httpReq); err == nil {
+	if adminport.isCancellableRequest(req.GetHttpRequest()) {
+		// cancellable requests may take a long time to finish. process them outside of the
+		// serialized request loop so that the requests to cancel them can get through.
+		// this means that they may run concurrently with each other and with any other request.
+		// this is safe since creating a replication does not depend on the serialization:
+		// the validation registry has its own lock, and the replication spec service rejects
+		// the creation of a replication that has been created by a concurrent request
+		go adminport.processRequestAndRespond(req)
+	} else {
+		adminport.processRequestAndRespond(req)
+	}
+	return nil
+}
+
+func (adminport *Adminport) processRequestAndRespond(req ap.Request) {
+	if response, err := adminport.handleRequest(req.GetHttpRequest()); err == nil {
 		req.Send(response)
 	} else {
 		req.SendError(err)
 	}
-	return nil
+}
+
+// create replication requests can be cancelled through ValidationsPath while their validations are in progress
+func (adminport *Adminport) isCancellableRequest(request *http.Request) bool {
+	key, err := adminport.GetMessageKeyFromRequest(request)
+	return err == nil && key == CreateReplicationPath+base.UrlDelimiter+base.MethodPost
 }
 
 // handleRequest have two return values:
@@ -164,6 +201,8 @@ func (adminport *Adminport) handleRequest(
 		response, err = adminport.doChangeRemoteClusterRequest(request)
 	case base.RemoteClustersPath + DynamicSuffix + base.UrlDelimiter + base.MethodDelete:
 		response, err = adminport.doDeleteRemoteClusterRequest(request)
+	case base.RemoteClustersPath + DynamicSuffix + base.UrlDelimiter + base.MethodGet:
+		response, err = adminport.doGetRemoteClusterReplicationsRequest(request)
 	case AllReplicationsPath + base.UrlDelimiter + base.MethodGet:
 		response, err = adminport.doGetAllReplicationsRequest(request)
 	case AllReplicationInfosPath + base.UrlDelimiter + base.MethodGet:
@@ -205,12 +244,64 @@ func (adminport *Adminport) handleRequest(
 		response, err = adminport.doViewXDCRInternalSettingsRequest(request)
 	case XDCRInternalSettingsPath + base.UrlDelimiter + base.MethodPost:
 		response, err = adminport.doChangeXDCRInternalSettingsRequest(request)
+	case ValidationsPath + base.UrlDelimiter + base.MethodGet:
+		response, err = adminport.doGetInFlightValidationsRequest(request)
+	case ValidationsPath + DynamicSuffix + base.UrlDelimiter + base.MethodDelete:
+		response, err = adminport.doCancelValidationRequest(request)
+	case CompareSettingsPrefix + DynamicSuffix + base.UrlDelimiter + base.MethodGet:
+		response, err = adminport.doCompareSettingsRequest(request)
+	case ReconcilePipelinesPath + base.UrlDelimiter + base.MethodPost:
+		response, err = adminport.doReconcilePipelinesRequest(request)
+	case SettingsSchemaPath + base.UrlDelimiter + base.MethodGet:
+		response, err = adminport.doGetSettingsSchemaRequest(request)
+	case ValidateSpecsPath + base.UrlDelimiter + base.MethodPost:
+		response, err = adminport.doValidateSpecsRequest(request)
+	case NamedFiltersPath + base.UrlDelimiter + base.MethodGet:
+		response, err = adminport.doGetNamedFiltersRequest(request)
+	case NamedFiltersPath + base.UrlDelimiter + base.MethodPost:
+		response, err = adminport.doRegisterNamedFilterRequest(request)
 	default:
 		err = ap.ErrorInvalidRequest
 	}
 	return response, err
 }
 
+// lists the replications to a remote cluster, e.g., to warn users about them before the remote cluster reference is deleted
+func (adminport *Adminport) doGetRemoteClusterReplicationsRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Debugf("doGetRemoteClusterReplicationsRequest\n")
+
+	response, err := authWebCreds(request, base.PermissionRemoteClusterRead)
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	remoteClusterName, err := DecodeRemoteClusterReplicationsRequest(request)
+	if err != nil {
+		return EncodeRemoteClusterValidationErrorIntoResponse(err)
+	}
+
+	ref, err := RemoteClusterService().RemoteClusterByRefName(remoteClusterName, false)
+	if err != nil {
+		return EncodeRemoteClusterValidationErrorIntoResponse(err)
+	}
+
+	replIds, err := ReplicationSpecService().AllReplicationSpecIdsForTargetCluster(ref.Uuid)
+	if err != nil {
+		return nil, err
+	}
+	specs := make([]*metadata.ReplicationSpecification, 0, len(replIds))
+	for _, replId := range replIds {
+		spec, err := ReplicationSpecService().ReplicationSpec(replId)
+		if err != nil {
+			// the replication has been deleted since its id was listed
+			continue
+		}
+		specs = append(specs, spec)
+	}
+
+	return NewRemoteClusterReplicationsResponse(specs)
+}
+
 func (adminport *Adminport) doGetRemoteClustersRequest(request *http.Request) (*ap.Response, error) {
 	logger_ap.Debugf("doGetRemoteClustersRequest\n")
 
@@ -238,7 +329,7 @@ func (adminport *Adminport) doCreateRemoteClusterRequest(request *http.Request)
 
 	remoteClusterService := RemoteClusterService()
 
-	justValidate, remoteClusterRef, errorsMap, err := DecodeCreateRemoteClusterRequest(request)
+	justValidate, skipConnectivityCheck, remoteClusterRef, errorsMap, err := DecodeCreateRemoteClusterRequest(request)
 	if err != nil {
 		return nil, err
 	} else if len(errorsMap) > 0 {
@@ -246,14 +337,18 @@ func (adminport *Adminport) doCreateRemoteClusterRequest(request *http.Request)
 		return EncodeRemoteClusterErrorsMapIntoResponse(errorsMap)
 	}
 
-	logger_ap.Infof("Request params: justValidate=%v, remoterClusterRef=%v\n",
-		justValidate, remoteClusterRef)
+	logger_ap.Infof("Request params: justValidate=%v, skipConnectivityCheck=%v, remoterClusterRef=%v\n",
+		justValidate, skipConnectivityCheck, remoteClusterRef.Redacted())
 
 	if justValidate {
 		err = remoteClusterService.ValidateAddRemoteCluster(remoteClusterRef)
 		return EncodeRemoteClusterErrorIntoResponse(err)
 	} else {
-		err = remoteClusterService.AddRemoteCluster(remoteClusterRef, false /*skipConnectivityValidation*/)
+		// uuid of remote cluster cannot be retrieved when connectivity check is skipped, and has to be given
+		if skipConnectivityCheck && len(remoteClusterRef.Uuid) == 0 {
+			return EncodeRemoteClusterValidationErrorIntoResponse(fmt.Errorf("uuid must be given if %v is set", base.RemoteClusterSkipConnectivityCheck))
+		}
+		err = remoteClusterService.AddRemoteCluster(remoteClusterRef, skipConnectivityCheck)
 		if err != nil {
 			return EncodeRemoteClusterErrorIntoResponse(err)
 		} else {
@@ -280,7 +375,7 @@ func (adminport *Adminport) doChangeRemoteClusterRequest(request *http.Request)
 
 	logger_ap.Infof("Request params: remoteClusterName=%v\n", remoteClusterName)
 
-	justValidate, remoteClusterRef, errorsMap, err := DecodeCreateRemoteClusterRequest(request)
+	justValidate, skipConnectivityCheck, remoteClusterRef, errorsMap, err := DecodeCreateRemoteClusterRequest(request)
 	if err != nil {
 		return nil, err
 	} else if len(errorsMap) > 0 {
@@ -288,16 +383,31 @@ func (adminport *Adminport) doChangeRemoteClusterRequest(request *http.Request)
 		return EncodeRemoteClusterErrorsMapIntoResponse(errorsMap)
 	}
 
-	logger_ap.Infof("Request params: justValidate=%v, remoterClusterRef=%v\n",
-		justValidate, remoteClusterRef)
+	logger_ap.Infof("Request params: justValidate=%v, skipConnectivityCheck=%v, remoterClusterRef=%v\n",
+		justValidate, skipConnectivityCheck, remoteClusterRef.Redacted())
 
 	remoteClusterService := RemoteClusterService()
 
+	// replications to a remote cluster would be stranded if it became read-only
+	if remoteClusterRef.ReadOnly {
+		oldRef, err := remoteClusterService.RemoteClusterByRefName(remoteClusterName, false)
+		if err == nil {
+			replIds, err := ReplicationSpecService().AllReplicationSpecIdsForTargetCluster(oldRef.Uuid)
+			if err != nil {
+				return nil, err
+			}
+			if len(replIds) > 0 {
+				return EncodeRemoteClusterValidationErrorIntoResponse(fmt.Errorf("Remote cluster %v cannot be made read-only since there are replications to it: %v",
+					remoteClusterName, strings.Join(replIds, " ")))
+			}
+		}
+	}
+
 	if justValidate {
 		err = remoteClusterService.ValidateSetRemoteCluster(remoteClusterName, remoteClusterRef)
 		return EncodeRemoteClusterErrorIntoResponse(err)
 	} else {
-		err = remoteClusterService.SetRemoteCluster(remoteClusterName, remoteClusterRef)
+		err = remoteClusterService.SetRemoteCluster(remoteClusterName, remoteClusterRef, skipConnectivityCheck)
 		if err != nil {
 			return EncodeRemoteClusterErrorIntoResponse(err)
 		} else {
@@ -322,7 +432,13 @@ func (adminport *Adminport) doDeleteRemoteClusterRequest(request *http.Request)
 		return EncodeRemoteClusterValidationErrorIntoResponse(err)
 	}
 
-	logger_ap.Infof("Request params: remoteClusterName=%v\n", remoteClusterName)
+	force, errorsMap := DecodeDeleteRemoteClusterRequest(request)
+	if len(errorsMap) > 0 {
+		logger_ap.Errorf("Validation error in inputs. errorsMap=%v\n", errorsMap)
+		return EncodeRemoteClusterErrorsMapIntoResponse(errorsMap)
+	}
+
+	logger_ap.Infof("Request params: remoteClusterName=%v, force=%v\n", remoteClusterName, force)
 
 	remoteClusterService := RemoteClusterService()
 	ref, err := remoteClusterService.RemoteClusterByRefName(remoteClusterName, false)
@@ -330,20 +446,16 @@ func (adminport *Adminport) doDeleteRemoteClusterRequest(request *http.Request)
 		return EncodeRemoteClusterValidationErrorIntoResponse(err)
 	}
 
-	// TODO get spec from replication status cache after the caching issue is fixed
-	specs, err := ReplicationSpecService().AllReplicationSpecs()
+	replIds, err := ReplicationSpecService().AllReplicationSpecIdsForTargetCluster(ref.Uuid)
 	if err != nil {
 		return nil, err
 	}
-	replIds := make([]string, 0)
-	for _, spec := range specs {
-		if spec.TargetClusterUUID == ref.Uuid {
-			replIds = append(replIds, spec.Id)
-		}
-	}
 	if len(replIds) > 0 {
-		err = fmt.Errorf("Cannot delete remote cluster `%v` since it is referenced by replications %v", ref.Name, replIds)
-		return EncodeRemoteClusterValidationErrorIntoResponse(err)
+		if !force {
+			return NewRemoteClusterInUseResponse(ref.Name, replIds)
+		}
+		// the replications are garbage collected once the remote cluster reference is gone
+		logger_ap.Infof("Deleting remote cluster %v, which is referenced by replications %v, since force is set\n", ref.Name, replIds)
 	}
 
 	ref, err = remoteClusterService.DelRemoteCluster(remoteClusterName)
@@ -395,7 +507,7 @@ func (adminport *Adminport) doCreateReplicationRequest(request *http.Request) (*
 	logger_ap.Info("doCreateReplicationRequest")
 	defer logger_ap.Info("Finished doCreateReplicationRequest call")
 
-	justValidate, fromBucket, toCluster, toBucket, settings, errorsMap, err := DecodeCreateReplicationRequest(request)
+	justValidate, createOrGet, fromBucket, toCluster, toBucket, settings, errorsMap, err := DecodeCreateReplicationRequest(request)
 	if err != nil {
 		return nil, err
 	} else if len(errorsMap) > 0 {
@@ -408,18 +520,29 @@ func (adminport *Adminport) doCreateReplicationRequest(request *http.Request) (*
 		return response, err
 	}
 
-	logger_ap.Infof("Request parameters: justValidate=%v, fromBucket=%v, toCluster=%v, toBucket=%v, settings=%v\n",
-		justValidate, fromBucket, toCluster, toBucket, settings)
+	logger_ap.Infof("Request parameters: justValidate=%v, createOrGet=%v, fromBucket=%v, toCluster=%v, toBucket=%v, settings=%v\n",
+		justValidate, createOrGet, fromBucket, toCluster, toBucket, settings)
 
-	replicationId, errorsMap, err := CreateReplication(justValidate, fromBucket, toCluster, toBucket, settings, getRealUserIdFromRequest(request))
+	if createOrGet && !justValidate {
+		replicationId, err := GetExistingReplicationId(fromBucket, toCluster, toBucket)
+		if err != nil {
+			return EncodeReplicationSpecErrorIntoResponse(err)
+		} else if len(replicationId) > 0 {
+			return NewCreateReplicationResponse(replicationId, "", nil, true)
+		}
+	}
 
-	if err != nil {
+	replicationId, validationId, errorsMap, warningsMap, err := CreateReplication(justValidate, fromBucket, toCluster, toBucket, settings, getRealUserIdFromRequest(request))
+
+	if err == service_def.ValidationCancelledError {
+		return NewValidationCancelledResponse()
+	} else if err != nil {
 		return EncodeReplicationSpecErrorIntoResponse(err)
 	} else if len(errorsMap) > 0 {
 		logger_ap.Errorf("Error creating replication. errorsMap=%v\n", errorsMap)
 		return EncodeErrorsMapIntoResponse(errorsMap, true)
 	} else {
-		return NewCreateReplicationResponse(replicationId)
+		return NewCreateReplicationResponse(replicationId, validationId, warningsMap, false)
 	}
 }
 
@@ -448,6 +571,139 @@ func (adminport *Adminport) doDeleteReplicationRequest(request *http.Request) (*
 	}
 }
 
+func (adminport *Adminport) doGetInFlightValidationsRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Debugf("doGetInFlightValidationsRequest\n")
+
+	response, err := authWebCreds(request, base.PermissionXDCRInternalRead)
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	return NewGetInFlightValidationsResponse(InFlightValidations())
+}
+
+// describes the replication settings and supervisor settings that xdcr accepts, for clients to introspect
+func (adminport *Adminport) doGetSettingsSchemaRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Debugf("doGetSettingsSchemaRequest\n")
+
+	response, err := authWebCreds(request, base.PermissionXDCRSettingsRead)
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	return NewSettingsSchemaResponse(metadata.ReplicationSettingsSchema(), supervisor.SettingsSchema())
+}
+
+func (adminport *Adminport) doReconcilePipelinesRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Infof("doReconcilePipelinesRequest\n")
+	defer logger_ap.Infof("Finished doReconcilePipelinesRequest\n")
+
+	response, err := authWebCreds(request, base.PermissionXDCRInternalWrite)
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	report, err := ReconcilePipelines()
+	if err != nil {
+		return nil, err
+	}
+	return NewReconcilePipelinesResponse(report)
+}
+
+// validates all replication specs on demand, and garbage collects the invalid ones unless it is a dry run
+func (adminport *Adminport) doValidateSpecsRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Infof("doValidateSpecsRequest\n")
+	defer logger_ap.Infof("Finished doValidateSpecsRequest\n")
+
+	response, err := authWebCreds(request, base.PermissionXDCRInternalWrite)
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	dryRun, err := DecodeValidateSpecsRequest(request)
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusBadRequest)
+	}
+
+	logger_ap.Infof("Request params: dryRun=%v\n", dryRun)
+
+	summary, err := ValidateSpecs(dryRun)
+	if err != nil {
+		return nil, err
+	}
+	return NewValidateSpecsResponse(summary, dryRun)
+}
+
+// lists the registered filters, as a map from filter name to filter expression
+func (adminport *Adminport) doGetNamedFiltersRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Debugf("doGetNamedFiltersRequest\n")
+
+	response, err := authWebCreds(request, base.PermissionXDCRSettingsRead)
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	namedFilters, err := ReplicationSpecService().AllNamedFilters()
+	if err != nil {
+		return nil, err
+	}
+	return EncodeObjectIntoResponse(namedFilters)
+}
+
+// registers a filter that new replications can reference by name. registering an existing name replaces its expression
+func (adminport *Adminport) doRegisterNamedFilterRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Infof("doRegisterNamedFilterRequest\n")
+	defer logger_ap.Infof("Finished doRegisterNamedFilterRequest\n")
+
+	response, err := authWebCreds(request, base.PermissionXDCRSettingsWrite)
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	name, expression, errorsMap := DecodeRegisterNamedFilterRequest(request)
+	if len(errorsMap) > 0 {
+		logger_ap.Errorf("Validation error in inputs. errorsMap=%v\n", errorsMap)
+		return EncodeErrorsMapIntoResponse(errorsMap, true)
+	}
+
+	logger_ap.Infof("Request params: name=%v, expression=%v\n", name, expression)
+
+	err = ReplicationSpecService().RegisterNamedFilter(name, expression)
+	if err != nil {
+		return nil, err
+	}
+	return NewEmptyArrayResponse()
+}
+
+func (adminport *Adminport) doCancelValidationRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Infof("doCancelValidationRequest\n")
+	defer logger_ap.Infof("Finished doCancelValidationRequest\n")
+
+	validationId, err := DecodeDynamicParamInURL(request, ValidationsPath, "Validation Id")
+	if err != nil {
+		return EncodeReplicationValidationErrorIntoResponse(err)
+	}
+
+	logger_ap.Infof("Request params: validationId=%v\n", validationId)
+
+	validation, err := GetInFlightValidation(validationId)
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusNotFound)
+	}
+
+	// cancelling a validation requires the same permission as creating the replication
+	response, err := authWebCreds(request, constructBucketPermission(validation.SourceBucket, base.PermissionBucketXDCRWriteSuffix))
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	err = CancelValidation(validationId)
+	if err != nil {
+		return EncodeErrorMessageIntoResponse(err, http.StatusNotFound)
+	}
+	return NewEmptyArrayResponse()
+}
+
 func (adminport *Adminport) doViewInternalSettingsRequest(request *http.Request) (*ap.Response, error) {
 	logger_ap.Infof("doViewInternalSettingsRequest\n")
 
@@ -590,6 +846,35 @@ func (adminport *Adminport) doViewReplicationSettingsRequest(request *http.Reque
 	return NewReplicationSettingsResponse(replSpec.Settings)
 }
 
+// compares the settings of a replication against the default replication settings
+func (adminport *Adminport) doCompareSettingsRequest(request *http.Request) (*ap.Response, error) {
+	logger_ap.Infof("doCompareSettingsRequest\n")
+
+	replicationId, err := DecodeDynamicParamInURL(request, CompareSettingsPrefix, "Replication Id")
+	if err != nil {
+		return EncodeReplicationValidationErrorIntoResponse(err)
+	}
+
+	logger_ap.Infof("Request params: replicationId=%v", replicationId)
+
+	response, err := authWebCredsForReplication(request, replicationId, []string{base.PermissionBucketXDCRReadSuffix})
+	if response != nil || err != nil {
+		return response, err
+	}
+
+	replSpec, err := ReplicationSpecService().ReplicationSpec(replicationId)
+	if err != nil {
+		return EncodeReplicationSpecErrorIntoResponse(err)
+	}
+
+	defaultSettings, err := ReplicationSettingsService().GetDefaultReplicationSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCompareSettingsResponse(defaultSettings, replSpec.Settings)
+}
+
 func (adminport *Adminport) doChangeReplicationSettingsRequest(request *http.Request) (*ap.Response, error) {
 	logger_ap.Infof("doChangeReplicationSettingsRequest\n")
 
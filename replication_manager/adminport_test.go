@@ -0,0 +1,89 @@
+package replication_manager
+
+import (
+	ap "github.com/couchbase/goxdcr/adminport"
+	"github.com/couchbase/goxdcr/base"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// request whose response blocks until it is released, to tell whether adminport waits for it
+type blockingTestRequest struct {
+	request *http.Request
+	release chan bool
+	sent    chan bool
+}
+
+func newBlockingTestRequest(t *testing.T, method, path string) *blockingTestRequest {
+	request, err := http.NewRequest(method, "http://localhost:9998"+base.AdminportUrlPrefix+path, strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("Unexpected error creating request. err=%v", err)
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return &blockingTestRequest{request: request,
+		release: make(chan bool),
+		sent:    make(chan bool)}
+}
+
+func (req *blockingTestRequest) GetHttpRequest() *http.Request {
+	return req.request
+}
+
+func (req *blockingTestRequest) Send(response *ap.Response) error {
+	<-req.release
+	close(req.sent)
+	return nil
+}
+
+func (req *blockingTestRequest) SendError(err error) error {
+	<-req.release
+	close(req.sent)
+	return nil
+}
+
+func TestIsCancellableRequest(t *testing.T) {
+	adminport := &Adminport{}
+	tests := []struct {
+		method      string
+		path        string
+		cancellable bool
+	}{
+		{base.MethodPost, CreateReplicationPath, true},
+		{base.MethodGet, base.RemoteClustersPath, false},
+		{base.MethodGet, ValidationsPath, false},
+	}
+	for _, test := range tests {
+		req := newBlockingTestRequest(t, test.method, test.path)
+		if cancellable := adminport.isCancellableRequest(req.GetHttpRequest()); cancellable != test.cancellable {
+			t.Errorf("%v %v: cancellable=%v, expected %v", test.method, test.path, cancellable, test.cancellable)
+		}
+	}
+}
+
+func TestCreateReplicationRequestDoesNotBlockRequestLoop(t *testing.T) {
+	adminport := &Adminport{}
+	// requests are processed one at a time, which would not let requests to cancel validations through
+	// if create replication requests were processed in the request loop. an empty create replication
+	// request fails input validation without calling out to other services
+	createReq := newBlockingTestRequest(t, base.MethodPost, CreateReplicationPath)
+	processed := make(chan bool)
+	go func() {
+		adminport.processRequest([]interface{}{createReq})
+		close(processed)
+	}()
+
+	select {
+	case <-processed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("processRequest waited for the response to the create replication request")
+	}
+
+	close(createReq.release)
+	select {
+	case <-createReq.sent:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No response was sent for the create replication request")
+	}
+}
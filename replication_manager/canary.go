@@ -0,0 +1,243 @@
+// Copyright (c) 2013 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package replication_manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/metadata"
+	"github.com/couchbase/goxdcr/pipeline"
+	"github.com/couchbase/goxdcr/pipeline_manager"
+	"github.com/couchbase/goxdcr/pipeline_utils"
+	"github.com/couchbase/goxdcr/simple_utils"
+	"github.com/couchbase/goxdcr/utils"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// the interval between two checks for replications whose canary documents are due
+var CanaryCheckInterval = 5 * time.Second
+
+// the max time to wait for a canary document to show up on target
+var CanaryTimeout = 60 * time.Second
+
+// the interval between two reads of a canary document from target
+var CanaryPollInterval = 100 * time.Millisecond
+
+var CanaryTimeoutError = errors.New("Canary document did not show up on target before timeout")
+var CanaryCancelledError = errors.New("Canary has been cancelled")
+
+// content of canary documents
+type canaryDoc struct {
+	ReplicationId string `json:"replicationId"`
+	// time when the canary document was written to source, in nanoseconds since epoch
+	WriteTime int64 `json:"writeTime"`
+}
+
+// each replication has one canary document, which is overwritten every time
+func canaryKey(replicationId string) string {
+	return base.CanaryKeyPrefix + replicationId
+}
+
+// key of the canary document on target, after key prefix and key suffix are applied
+func canaryTargetKey(spec *metadata.ReplicationSpecification) string {
+	return spec.Settings.AddKeyPrefix + canaryKey(spec.Id) + spec.Settings.AddKeySuffix
+}
+
+// whether the canary document of the replication would be replicated to target, i.e., passes the filters of the replication
+func isCanaryReplicated(spec *metadata.ReplicationSpecification) (bool, error) {
+	if spec.Settings.ReplicateOps == metadata.ReplicateOpsDeletionsOnly {
+		return false, nil
+	}
+	if len(spec.Settings.FilterExpression) > 0 {
+		filterRegexp, err := regexp.Compile(spec.Settings.FilterExpression)
+		if err != nil {
+			return false, err
+		}
+		return filterRegexp.MatchString(canaryKey(spec.Id)), nil
+	}
+	if len(spec.Settings.FilterKeyPrefix) > 0 {
+		return strings.HasPrefix(canaryKey(spec.Id), spec.Settings.FilterKeyPrefix), nil
+	}
+	return true, nil
+}
+
+// canary documents cannot be read from remote clusters with encryption enabled
+func isCanarySupported(spec *metadata.ReplicationSpecification) (bool, error) {
+	targetClusterRef, err := RemoteClusterService().RemoteClusterByUuid(spec.TargetClusterUUID, false)
+	if err != nil {
+		return false, err
+	}
+	return !targetClusterRef.DemandEncryption, nil
+}
+
+// periodically measures the end to end latency of replications that have canary enabled
+func (rm *replicationManager) runCanaries(fin_chan chan bool) {
+	ticker := time.NewTicker(CanaryCheckInterval)
+	defer ticker.Stop()
+
+	// replication id -> time when the last canary of the replication was started
+	last_canary_times := make(map[string]time.Time)
+	// replications whose canaries are in progress
+	inflight_canaries := make(map[string]bool)
+	// replications whose canaries are skipped since they are not supported
+	unsupported_canaries := make(map[string]bool)
+	done_ch := make(chan string)
+
+	for {
+		select {
+		case <-fin_chan:
+			return
+		case replicationId := <-done_ch:
+			delete(inflight_canaries, replicationId)
+		case <-ticker.C:
+			specs, err := ReplicationSpecService().AllReplicationSpecs()
+			if err != nil {
+				logger_rm.Errorf("Failed to get replication specs for canary. err=%v\n", err)
+				continue
+			}
+			for replicationId, spec := range specs {
+				rep_status, _ := pipeline_manager.ReplicationStatus(replicationId)
+				if rep_status == nil {
+					continue
+				}
+				interval := time.Duration(spec.Settings.CanaryInterval) * time.Second
+				if !spec.Settings.Active || interval == 0 {
+					rep_status.SetCanaryLatency(0)
+					delete(last_canary_times, replicationId)
+					delete(unsupported_canaries, replicationId)
+					continue
+				}
+				supported, err := isCanarySupported(spec)
+				if err != nil {
+					logger_rm.Errorf("Failed to check whether canary is supported by replication %v. err=%v\n", replicationId, err)
+					continue
+				}
+				if !supported {
+					if !unsupported_canaries[replicationId] {
+						logger_rm.Infof("Skipping canary of replication %v since its remote cluster has encryption enabled\n", replicationId)
+						unsupported_canaries[replicationId] = true
+					}
+					rep_status.SetCanaryLatency(0)
+					continue
+				}
+				delete(unsupported_canaries, replicationId)
+				if inflight_canaries[replicationId] || time.Since(last_canary_times[replicationId]) < interval {
+					continue
+				}
+
+				last_canary_times[replicationId] = time.Now()
+				inflight_canaries[replicationId] = true
+				go func(spec *metadata.ReplicationSpecification, rep_status *pipeline.ReplicationStatus) {
+					latency, err := measureCanaryLatency(spec, rep_status, fin_chan)
+					if err != nil {
+						logger_rm.Errorf("Failed to measure latency of replication %v with canary document. err=%v\n", spec.Id, err)
+					} else if latency > 0 {
+						logger_rm.Debugf("Canary latency of replication %v is %v\n", spec.Id, latency)
+						rep_status.SetCanaryLatency(latency)
+					}
+					select {
+					case done_ch <- spec.Id:
+					case <-fin_chan:
+					}
+				}(spec, rep_status)
+			}
+		}
+	}
+}
+
+// writes the canary document of the replication to source bucket, and waits for it to show up on target bucket.
+// only the node whose pipeline streams the vb of the canary document does so. 0 is returned on other nodes
+func measureCanaryLatency(spec *metadata.ReplicationSpecification, rep_status *pipeline.ReplicationStatus, fin_chan chan bool) (time.Duration, error) {
+	p := rep_status.Pipeline()
+	if p == nil || !pipeline_utils.IsPipelineRunning(p.State()) {
+		return 0, nil
+	}
+
+	replicated, err := isCanaryReplicated(spec)
+	if err != nil {
+		return 0, err
+	}
+	if !replicated {
+		return 0, fmt.Errorf("Canary document %v is not replicated because of filter expression, filter key prefix or replicate_ops setting", canaryKey(spec.Id))
+	}
+
+	localConnStr, err := XDCRCompTopologyService().MyConnectionStr()
+	if err != nil {
+		return 0, err
+	}
+	sourceBucket, err := utils.LocalBucket(localConnStr, spec.SourceBucketName)
+	if err != nil {
+		return 0, err
+	}
+	defer sourceBucket.Close()
+
+	key := canaryKey(spec.Id)
+	vbno := uint16(sourceBucket.VBHash(key))
+	if !simple_utils.IsVbInList(vbno, pipeline_utils.GetSourceVBListPerPipeline(p)) {
+		return 0, nil
+	}
+
+	targetClusterRef, err := RemoteClusterService().RemoteClusterByUuid(spec.TargetClusterUUID, false)
+	if err != nil {
+		return 0, err
+	}
+	if targetClusterRef.DemandEncryption {
+		return 0, errors.New("Canary is not supported by replications to remote clusters with encryption enabled")
+	}
+	targetBucketInfo, err := utils.GetBucketInfo(targetClusterRef.HostName, spec.TargetBucketName, targetClusterRef.UserName,
+		targetClusterRef.Password, targetClusterRef.Certificate, targetClusterRef.SANInCertificate, logger_rm)
+	if err != nil {
+		return 0, err
+	}
+	targetBucketPassword, ok := targetBucketInfo[base.SASLPasswordKey].(string)
+	if !ok {
+		return 0, fmt.Errorf("Cannot get sasl password from target bucket %v", spec.TargetBucketName)
+	}
+	targetBucket, err := utils.RemoteBucket(targetClusterRef.HostName, spec.TargetBucketName, targetBucketPassword)
+	if err != nil {
+		return 0, err
+	}
+	defer targetBucket.Close()
+
+	write_time := time.Now()
+	doc, err := json.Marshal(&canaryDoc{ReplicationId: spec.Id, WriteTime: write_time.UnixNano()})
+	if err != nil {
+		return 0, err
+	}
+	err = sourceBucket.SetRaw(key, 0, doc)
+	if err != nil {
+		return 0, err
+	}
+
+	targetKey := canaryTargetKey(spec)
+	timeout_timer := time.NewTimer(CanaryTimeout)
+	defer timeout_timer.Stop()
+	poll_ticker := time.NewTicker(CanaryPollInterval)
+	defer poll_ticker.Stop()
+	for {
+		select {
+		case <-fin_chan:
+			return 0, CanaryCancelledError
+		case <-timeout_timer.C:
+			return 0, CanaryTimeoutError
+		case <-poll_ticker.C:
+			// the document read may be an older canary document, which has not been overwritten on target yet
+			value, err := targetBucket.GetRaw(targetKey)
+			if err == nil && bytes.Equal(value, doc) {
+				return time.Since(write_time), nil
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package replication_manager
+
+import (
+	"github.com/couchbase/goxdcr/metadata"
+	"testing"
+)
+
+func TestCanaryReplicated(t *testing.T) {
+	spec := metadata.NewReplicationSpecification("sourceBucket", "sourceBucketUUID", "targetClusterUUID", "targetBucket", "targetBucketUUID")
+
+	inputs := []struct {
+		filterExpression string
+		filterKeyPrefix  string
+		replicateOps     string
+		replicated       bool
+	}{
+		{"", "", metadata.ReplicateOpsAll, true},
+		{"", "", metadata.ReplicateOpsMutationsOnly, true},
+		{"", "", metadata.ReplicateOpsDeletionsOnly, false},
+		{"^_xdcr_canary_", "", metadata.ReplicateOpsAll, true},
+		{"^user_", "", metadata.ReplicateOpsAll, false},
+		{"", "_xdcr_canary_", metadata.ReplicateOpsAll, true},
+		{"", "user_", metadata.ReplicateOpsAll, false},
+	}
+	for _, input := range inputs {
+		spec.Settings.FilterExpression = input.filterExpression
+		spec.Settings.FilterKeyPrefix = input.filterKeyPrefix
+		spec.Settings.ReplicateOps = input.replicateOps
+		replicated, err := isCanaryReplicated(spec)
+		if err != nil {
+			t.Fatalf("Unexpected error. err=%v", err)
+		}
+		if replicated != input.replicated {
+			t.Errorf("Canary is replicated=%v with filter expression %q, filter key prefix %q and replicate_ops %q, expected %v",
+				replicated, input.filterExpression, input.filterKeyPrefix, input.replicateOps, input.replicated)
+		}
+	}
+}
+
+func TestCanaryTargetKey(t *testing.T) {
+	spec := metadata.NewReplicationSpecification("sourceBucket", "sourceBucketUUID", "targetClusterUUID", "targetBucket", "targetBucketUUID")
+	spec.Settings.AddKeyPrefix = "dc1:"
+	spec.Settings.AddKeySuffix = ":copy"
+
+	expected := "dc1:" + canaryKey(spec.Id) + ":copy"
+	if key := canaryTargetKey(spec); key != expected {
+		t.Errorf("Canary target key is %q, expected %q", key, expected)
+	}
+}
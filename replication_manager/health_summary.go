@@ -0,0 +1,87 @@
+// Copyright (c) 2013 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package replication_manager
+
+import (
+	"github.com/couchbase/goxdcr/pipeline"
+	"github.com/couchbase/goxdcr/pipeline_manager"
+	"time"
+)
+
+// summary of the health of all subsystems of replication manager.
+// it is computed from cached states only, and is cheap enough to be polled frequently
+type HealthSummary struct {
+	Time time.Time `json:"time"`
+	// whether all metakv change listeners are observing metadata changes
+	MetadataConnected bool `json:"metadataConnected"`
+	// whether metadata store has been found out of space, in which case replications cannot be changed
+	MetadataStoreFull bool `json:"metadataStoreFull"`
+	// number of pipeline supervisors under pipeline master supervisor
+	NumOfSupervisedPipelines int `json:"supervisedPipelines"`
+	NumOfRunningReplications int `json:"runningReplications"`
+	NumOfPausedReplications  int `json:"pausedReplications"`
+	NumOfPendingReplications int `json:"pendingReplications"`
+	// replications that are neither quarantined nor in error state
+	NumOfValidReplications         int `json:"validReplications"`
+	NumOfQuarantinedReplications   int `json:"quarantinedReplications"`
+	NumOfErrorReplications         int `json:"errorReplications"`
+	NumOfReachableRemoteClusters   int `json:"reachableRemoteClusters"`
+	NumOfUnreachableRemoteClusters int `json:"unreachableRemoteClusters"`
+}
+
+func GetHealthSummary() *HealthSummary {
+	return replication_mgr.HealthSummary()
+}
+
+func (rm *replicationManager) HealthSummary() *HealthSummary {
+	summary := &HealthSummary{Time: time.Now()}
+
+	if rm.metadata_change_monitor != nil {
+		summary.MetadataConnected = rm.metadata_change_monitor.AllListenersObserving()
+	}
+
+	if rm.repl_spec_svc != nil {
+		summary.MetadataStoreFull = rm.repl_spec_svc.IsMetadataStoreFull()
+	}
+
+	if rm.pipelineMasterSupervisor != nil {
+		summary.NumOfSupervisedPipelines = rm.pipelineMasterSupervisor.NumOfChildren()
+	}
+
+	quarantined := make(map[string]bool)
+	for _, quarantinedReplication := range pipeline_manager.GetQuarantinedReplications() {
+		quarantined[quarantinedReplication.ReplicationId] = true
+	}
+
+	for topic, rep_status := range pipeline_manager.ReplicationStatusMap() {
+		switch rep_status.RuntimeStatus(true) {
+		case pipeline.Replicating:
+			summary.NumOfRunningReplications++
+		case pipeline.Paused:
+			summary.NumOfPausedReplications++
+		case pipeline.Pending:
+			summary.NumOfPendingReplications++
+		}
+
+		if quarantined[topic] {
+			summary.NumOfQuarantinedReplications++
+		} else if len(rep_status.Errors()) > 0 {
+			summary.NumOfErrorReplications++
+		} else {
+			summary.NumOfValidReplications++
+		}
+	}
+
+	if rm.remote_cluster_svc != nil {
+		summary.NumOfReachableRemoteClusters, summary.NumOfUnreachableRemoteClusters = rm.remote_cluster_svc.RemoteClusterReachabilityCounts()
+	}
+
+	return summary
+}
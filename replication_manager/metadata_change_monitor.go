@@ -38,3 +38,16 @@ func (mcm *MetadataChangeMonitor) RegisterListener(listener base.MetadataChangeL
 	mcm.listeners[listener.Id()] = listener
 	return nil
 }
+
+// whether all registered listeners are currently observing metadata changes
+func (mcm *MetadataChangeMonitor) AllListenersObserving() bool {
+	for _, listener := range mcm.listeners {
+		observingListener, ok := listener.(interface {
+			IsObserving() bool
+		})
+		if ok && !observingListener.IsObserving() {
+			return false
+		}
+	}
+	return true
+}
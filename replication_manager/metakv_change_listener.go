@@ -26,6 +26,7 @@ import (
 	"runtime"
 	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -41,6 +42,8 @@ type MetakvChangeListener struct {
 	children_waitgrp           *sync.WaitGroup
 	metadata_service_call_back base.MetadataServiceCallback
 	logger                     *log.CommonLogger
+	// 1 when the listener is observing metakv, 0 otherwise
+	observing int32
 }
 
 func NewMetakvChangeListener(id, dirpath string, cancel_chan chan struct{},
@@ -71,9 +74,16 @@ func (mcl *MetakvChangeListener) Start() error {
 	return nil
 }
 
+// whether the listener is currently observing metakv
+func (mcl *MetakvChangeListener) IsObserving() bool {
+	return atomic.LoadInt32(&mcl.observing) == 1
+}
+
 func (mcl *MetakvChangeListener) observeChildren() {
 	defer mcl.children_waitgrp.Done()
+	atomic.StoreInt32(&mcl.observing, 1)
 	err := metakv.RunObserveChildren(mcl.dirpath, mcl.metakvCallback, mcl.cancel_chan)
+	atomic.StoreInt32(&mcl.observing, 0)
 	// call failure call back only when there are real errors
 	// err may be nil when observeChildren is canceled, in which case there is no need to call failure call back
 	mcl.failureCallback(err)
@@ -241,6 +251,13 @@ func needToReconstructPipeline(oldSettings *metadata.ReplicationSettings, newSet
 	repTypeChanged := !(oldSettings.RepType == newSettings.RepType)
 	sourceNozzlePerNodeChanged := !(oldSettings.SourceNozzlePerNode == newSettings.SourceNozzlePerNode)
 	targetNozzlePerNodeChanged := !(oldSettings.TargetNozzlePerNode == newSettings.TargetNozzlePerNode)
+	targetNodeAllowlistChanged := !metadata.SameTargetNodeAllowlist(oldSettings.TargetNodeAllowlist, newSettings.TargetNodeAllowlist)
+	replicateOpsChanged := !(oldSettings.ReplicateOps == newSettings.ReplicateOps)
+	// durability and compression support are negotiated with target when xmem connections are set up
+	targetDurabilityChanged := !(oldSettings.TargetDurability == newSettings.TargetDurability)
+	compressionTypeChanged := !(oldSettings.CompressionType == newSettings.CompressionType)
+	// routers assign vbuckets to xmem nozzles when they are constructed
+	balanceModeChanged := !(oldSettings.BalanceMode == newSettings.BalanceMode)
 
 	// the following may qualify for live update in the future.
 	// batchCount is tricky since the sizes of xmem data channels depend on it.
@@ -249,7 +266,7 @@ func needToReconstructPipeline(oldSettings *metadata.ReplicationSettings, newSet
 	batchSizeChanged := (oldSettings.BatchSize != newSettings.BatchSize)
 
 	return repTypeChanged || sourceNozzlePerNodeChanged || targetNozzlePerNodeChanged ||
-		batchCountChanged || batchSizeChanged
+		targetNodeAllowlistChanged || replicateOpsChanged || targetDurabilityChanged || compressionTypeChanged || balanceModeChanged || batchCountChanged || batchSizeChanged
 }
 
 func (rscl *ReplicationSpecChangeListener) liveUpdatePipeline(topic string, oldSettings *metadata.ReplicationSettings, newSettings *metadata.ReplicationSettings) error {
@@ -313,9 +330,17 @@ func (rccl *RemoteClusterChangeListener) remoteClusterChangeHandlerCallback(remo
 		return err
 	}
 
-	rccl.logger.Infof("remoteClusterChangedCallback called on id = %v, oldRef=%v, newRef=%v\n", remoteClusterRefId, oldRemoteClusterRef.String(), newRemoteClusterRef.String())
+	rccl.logger.Infof("remoteClusterChangedCallback called on id = %v, oldRef=%v, newRef=%v\n", remoteClusterRefId, oldRemoteClusterRef.Redacted(), newRemoteClusterRef.Redacted())
 	defer rccl.logger.Infof("Completed remoteClusterChangedCallback called on id = %v", remoteClusterRefId)
 
+	// the reference may now point to a different cluster, or the cluster may be gone
+	if oldRemoteClusterRef != nil {
+		rccl.repl_spec_svc.InvalidateClusterCompatibility(oldRemoteClusterRef.Uuid)
+	}
+	if newRemoteClusterRef != nil {
+		rccl.repl_spec_svc.InvalidateClusterCompatibility(newRemoteClusterRef.Uuid)
+	}
+
 	if oldRemoteClusterRef == nil {
 		// nothing to do if remote cluster has been created
 		return nil
@@ -17,6 +17,8 @@ import (
 	"github.com/couchbase/goxdcr/base"
 	"github.com/couchbase/goxdcr/log"
 	"github.com/couchbase/goxdcr/metadata"
+	"github.com/couchbase/goxdcr/pipeline_manager"
+	"github.com/couchbase/goxdcr/service_def"
 	"github.com/couchbase/goxdcr/simple_utils"
 	"github.com/couchbase/goxdcr/utils"
 	"io/ioutil"
@@ -30,6 +32,9 @@ import (
 // xdcr prefix for internal settings keys
 var XDCRPrefix = "xdcr"
 var ErrorsKey = "errors"
+var WarningsKey = "warnings"
+var AlreadyExistsKey = "alreadyExists"
+var ValidationIdKey = "validationId"
 
 const (
 	DefaultAdminPort = "8091"
@@ -50,11 +55,20 @@ const (
 	BlockProfileStopPath     = "profile/block/stop"
 	BucketSettingsPrefix     = "controller/bucketSettings"
 	XDCRInternalSettingsPath = "xdcr/internalSettings"
+	ValidationsPath          = "xdcr/validations"
+	CompareSettingsPrefix    = "xdcr/compareSettings"
+	ReconcilePipelinesPath   = "xdcr/reconcilePipelines"
+	SettingsSchemaPath       = "xdcr/settingsSchema"
+	ValidateSpecsPath        = "controller/validateSpecs"
+	NamedFiltersPath         = "xdcr/namedFilters"
 
 	// Some url paths are not static and have variable contents, e.g., settings/replications/$replication_id
 	// The message keys for such paths are constructed by appending the dynamic suffix below to the static portion of the path.
 	// e.g., settings/replications/dynamic
 	DynamicSuffix = "/dynamic"
+
+	// suffix of the path for the replications to a remote cluster, e.g., pools/default/remoteClusters/$name/replications
+	RemoteClusterReplicationsSuffix = "/replications"
 )
 
 // constants used for parsing replication settings
@@ -62,6 +76,7 @@ const (
 	Type                           = "type"
 	ReplicationType                = "replicationType"
 	FilterExpression               = "filterExpression"
+	FilterKeyPrefix                = "filterKeyPrefix"
 	PauseRequested                 = "pauseRequested"
 	CheckpointInterval             = "checkpointInterval"
 	BatchCount                     = "workerBatchSize"
@@ -74,9 +89,19 @@ const (
 	TimeoutPercentageCap           = "timeoutPercentageCap"
 	LogLevel                       = "logLevel"
 	StatsInterval                  = "statsInterval"
+	AddKeyPrefix                   = "addKeyPrefix"
+	AddKeySuffix                   = "addKeySuffix"
+	TargetNodeAllowlist            = "targetNodeAllowlist"
+	ReplicateOps                   = "replicateOps"
+	CanaryInterval                 = "canaryInterval"
+	TargetDurability               = "targetDurability"
+	CompressionType                = "compressionType"
+	BalanceMode                    = "balanceMode"
+	RebindOnRecreate               = "rebindOnRecreate"
 	ReplicationTypeValue           = "continuous"
 	GoMaxProcs                     = "goMaxProcs"
 	GoGC                           = "goGC"
+	FilterName                     = "filterName"
 )
 
 // constants for parsing create replication response
@@ -92,6 +117,15 @@ const (
 	EndIndex   = "endIndex"
 )
 
+// constants for validate specs request and response
+const (
+	DryRun      = "dryRun"
+	InvalidKey  = "invalid"
+	GCedKey     = "garbageCollected"
+	GCFailedKey = "gcFailed"
+	SkippedKey  = "skipped"
+)
+
 // constants used for parsing bucket setting changes
 const (
 	BucketName = "bucketName"
@@ -131,6 +165,7 @@ var MissingOldSettingsInRequest = errors.New("Invalid http request. No old repli
 var RestKeyToSettingsKeyMap = map[string]string{
 	Type:                           metadata.ReplicationType,
 	FilterExpression:               metadata.FilterExpression,
+	FilterKeyPrefix:                metadata.FilterKeyPrefix,
 	PauseRequested:                 metadata.Active,
 	CheckpointInterval:             metadata.CheckpointInterval,
 	BatchCount:                     metadata.BatchCount,
@@ -141,16 +176,27 @@ var RestKeyToSettingsKeyMap = map[string]string{
 	TargetNozzlePerNode:            metadata.TargetNozzlePerNode,
 	/*MaxExpectedReplicationLag:      metadata.MaxExpectedReplicationLag,
 	TimeoutPercentageCap:           metadata.TimeoutPercentageCap,*/
-	LogLevel:      metadata.PipelineLogLevel,
-	StatsInterval: metadata.PipelineStatsInterval,
-	GoMaxProcs:    metadata.GoMaxProcs,
-	GoGC:          metadata.GoGC,
+	LogLevel:            metadata.PipelineLogLevel,
+	StatsInterval:       metadata.PipelineStatsInterval,
+	AddKeyPrefix:        metadata.AddKeyPrefix,
+	AddKeySuffix:        metadata.AddKeySuffix,
+	TargetNodeAllowlist: metadata.TargetNodeAllowlist,
+	ReplicateOps:        metadata.ReplicateOps,
+	CanaryInterval:      metadata.CanaryInterval,
+	TargetDurability:    metadata.TargetDurability,
+	CompressionType:     metadata.CompressionType,
+	BalanceMode:         metadata.BalanceMode,
+	RebindOnRecreate:    metadata.RebindOnRecreate,
+	GoMaxProcs:          metadata.GoMaxProcs,
+	GoGC:                metadata.GoGC,
+	FilterName:          metadata.FilterName,
 }
 
 // internal replication settings key -> replication settings key in rest api
 var SettingsKeyToRestKeyMap = map[string]string{
 	metadata.ReplicationType:                Type,
 	metadata.FilterExpression:               FilterExpression,
+	metadata.FilterKeyPrefix:                FilterKeyPrefix,
 	metadata.Active:                         PauseRequested,
 	metadata.CheckpointInterval:             CheckpointInterval,
 	metadata.BatchCount:                     BatchCount,
@@ -163,8 +209,18 @@ var SettingsKeyToRestKeyMap = map[string]string{
 	metadata.TimeoutPercentageCap:           TimeoutPercentageCap,*/
 	metadata.PipelineLogLevel:      LogLevel,
 	metadata.PipelineStatsInterval: StatsInterval,
+	metadata.AddKeyPrefix:          AddKeyPrefix,
+	metadata.AddKeySuffix:          AddKeySuffix,
+	metadata.TargetNodeAllowlist:   TargetNodeAllowlist,
+	metadata.ReplicateOps:          ReplicateOps,
+	metadata.CanaryInterval:        CanaryInterval,
+	metadata.TargetDurability:      TargetDurability,
+	metadata.CompressionType:       CompressionType,
+	metadata.BalanceMode:           BalanceMode,
+	metadata.RebindOnRecreate:      RebindOnRecreate,
 	metadata.GoMaxProcs:            GoMaxProcs,
 	metadata.GoGC:                  GoGC,
+	metadata.FilterName:            FilterName,
 }
 
 var logger_msgutil *log.CommonLogger = log.NewLogger("MessageUtils", log.DefaultLoggerContext)
@@ -243,13 +299,14 @@ func DecodeJustValidateFromRequest(request *http.Request) (bool, error) {
 }
 
 // decode parameters from create remote cluster request
-func DecodeCreateRemoteClusterRequest(request *http.Request) (justValidate bool, remoteClusterRef *metadata.RemoteClusterReference, errorsMap map[string]error, err error) {
+func DecodeCreateRemoteClusterRequest(request *http.Request) (justValidate, skipConnectivityCheck bool, remoteClusterRef *metadata.RemoteClusterReference, errorsMap map[string]error, err error) {
 	errorsMap = make(map[string]error)
-	var name, hostName, userName, password string
+	var uuid, name, hostName, userName, password string
 	var certificate []byte
 
 	// default to false if not passed in
 	demandEncryption := false
+	readOnly := false
 
 	if err = request.ParseForm(); err != nil {
 		errorsMap[base.PlaceHolderFieldKey] = ErrorParsingForm
@@ -264,6 +321,13 @@ func DecodeCreateRemoteClusterRequest(request *http.Request) (justValidate bool,
 			if err != nil {
 				errorsMap[base.JustValidate] = err
 			}
+		case base.RemoteClusterSkipConnectivityCheck:
+			skipConnectivityCheck, err = getBoolFromValArr(valArr, false)
+			if err != nil {
+				errorsMap[base.RemoteClusterSkipConnectivityCheck] = err
+			}
+		case base.RemoteClusterUuid:
+			uuid = getStringFromValArr(valArr)
 		case base.RemoteClusterName:
 			name = getStringFromValArr(valArr)
 		case base.RemoteClusterHostName:
@@ -277,6 +341,12 @@ func DecodeCreateRemoteClusterRequest(request *http.Request) (justValidate bool,
 		case base.RemoteClusterCertificate:
 			certificateStr := getStringFromValArr(valArr)
 			certificate = []byte(certificateStr)
+		case base.RemoteClusterReadOnly:
+			readOnly, err = getBoolFromValArr(valArr, false)
+			if err != nil {
+				errorsMap[base.RemoteClusterReadOnly] = err
+				err = nil
+			}
 		default:
 			// ignore other parameters
 		}
@@ -301,12 +371,24 @@ func DecodeCreateRemoteClusterRequest(request *http.Request) (justValidate bool,
 		errorsMap[base.RemoteClusterCertificate] = errors.New("certificate must be given if demand encryption is on")
 	}
 
-	//validate the format of hostName, if it doesn't contain port number, append default port number 8091
-	if !strings.Contains(hostName, base.UrlPortNumberDelimiter) {
-		hostName = hostName + base.UrlPortNumberDelimiter + DefaultAdminPort
+	// uuid of remote cluster is retrieved from remote cluster unless connectivity check is skipped
+	if len(uuid) > 0 && !skipConnectivityCheck {
+		errorsMap[base.RemoteClusterUuid] = fmt.Errorf("uuid can only be given if %v is set", base.RemoteClusterSkipConnectivityCheck)
+	}
+
+	//validate the format of hostName, strip scheme and trailing slash, and append default port number 8091 if it doesn't contain port number
+	if len(hostName) > 0 {
+		hostName, err = utils.NormalizeHostName(hostName)
+		if err != nil {
+			errorsMap[base.RemoteClusterHostName] = err
+			err = nil
+		}
 	}
 	if len(errorsMap) == 0 {
-		remoteClusterRef, err = metadata.NewRemoteClusterReference("", name, hostName, userName, password, demandEncryption, certificate)
+		remoteClusterRef, err = metadata.NewRemoteClusterReference(uuid, name, hostName, userName, password, demandEncryption, certificate)
+		if err == nil {
+			remoteClusterRef.ReadOnly = readOnly
+		}
 	}
 
 	return
@@ -316,6 +398,28 @@ func NewCreateRemoteClusterResponse(remoteClusterRef *metadata.RemoteClusterRefe
 	return EncodeObjectIntoResponse(remoteClusterRef.ToMap())
 }
 
+// replication to a remote cluster, as listed before the remote cluster reference is deleted
+type RemoteClusterReplication struct {
+	Id string `json:"id"`
+	// false when the replication is paused
+	Active bool `json:"active"`
+}
+
+func NewRemoteClusterReplicationsResponse(specs []*metadata.ReplicationSpecification) (*ap.Response, error) {
+	replications := make([]*RemoteClusterReplication, 0, len(specs))
+	for _, spec := range specs {
+		replications = append(replications, &RemoteClusterReplication{Id: spec.Id, Active: spec.Settings.Active})
+	}
+	return EncodeObjectIntoResponse(replications)
+}
+
+// response for a delete remote cluster request that is refused because there are replications to the remote cluster
+func NewRemoteClusterInUseResponse(remoteClusterName string, replIds []string) (*ap.Response, error) {
+	err := fmt.Errorf("Cannot delete remote cluster `%v` since it is referenced by replications %v. Delete the replications first, or set %v to true to delete the remote cluster anyway",
+		remoteClusterName, replIds, base.RemoteClusterForceDelete)
+	return EncodeErrorMessageIntoResponse(err, http.StatusConflict)
+}
+
 func NewOKResponse() (*ap.Response, error) {
 	// return "ok" in success case
 	return EncodeByteArrayIntoResponse([]byte("\"ok\""))
@@ -327,7 +431,7 @@ func NewEmptyArrayResponse() (*ap.Response, error) {
 }
 
 // decode parameters from create replication request
-func DecodeCreateReplicationRequest(request *http.Request) (justValidate bool, fromBucket, toCluster, toBucket string, settings map[string]interface{}, errorsMap map[string]error, err error) {
+func DecodeCreateReplicationRequest(request *http.Request) (justValidate, createOrGet bool, fromBucket, toCluster, toBucket string, settings map[string]interface{}, errorsMap map[string]error, err error) {
 	errorsMap = make(map[string]error)
 	var replicationType string
 
@@ -355,6 +459,11 @@ func DecodeCreateReplicationRequest(request *http.Request) (justValidate bool, f
 			if err != nil {
 				errorsMap[base.JustValidate] = err
 			}
+		case base.CreateOrGet:
+			createOrGet, err = getBoolFromValArr(valArr, false)
+			if err != nil {
+				errorsMap[base.CreateOrGet] = err
+			}
 		default:
 			// ignore other parameters
 		}
@@ -379,16 +488,37 @@ func DecodeCreateReplicationRequest(request *http.Request) (justValidate bool, f
 		errorsMap[key] = value
 	}
 
+	// key transformation is performed by xmem nozzles and is not supported by capi replication
+	typedSettings := metadata.SettingsMap(settings)
+	if replicationType, _ := typedSettings.GetReplicationType(); replicationType == metadata.ReplicationTypeCapi {
+		for _, settingsKey := range []string{metadata.AddKeyPrefix, metadata.AddKeySuffix} {
+			if affix, ok := settings[settingsKey]; ok && len(affix.(string)) > 0 {
+				errorsMap[SettingsKeyToRestKeyMap[settingsKey]] = errors.New("Key transformation is not supported by capi replication")
+			}
+		}
+		if allowlist, ok := settings[metadata.TargetNodeAllowlist]; ok && len(allowlist.([]string)) > 0 {
+			errorsMap[TargetNodeAllowlist] = errors.New("Target node allowlist is not supported by capi replication")
+		}
+		if balanceMode, _ := typedSettings.GetBalanceMode(); balanceMode == metadata.BalanceModeLeastLoaded {
+			errorsMap[BalanceMode] = errors.New("Least-loaded balance mode is not supported by capi replication")
+		}
+	}
+
 	isEnterprise, err := XDCRCompTopologyService().IsMyClusterEnterprise()
 	if err != nil {
 		return
 	}
 
 	if !isEnterprise {
-		filterExpression, ok := settings[metadata.FilterExpression]
-		if ok && len(filterExpression.(string)) > 0 {
+		if filterExpression, _ := typedSettings.GetFilterExpression(); len(filterExpression) > 0 {
 			errorsMap[FilterExpression] = errors.New("Filter expression can be specified in Enterprise edition only")
 		}
+		if filterKeyPrefix, _ := typedSettings.GetFilterKeyPrefix(); len(filterKeyPrefix) > 0 {
+			errorsMap[FilterKeyPrefix] = errors.New("Filter key prefix can be specified in Enterprise edition only")
+		}
+		if filterName, _ := typedSettings.GetFilterName(); len(filterName) > 0 {
+			errorsMap[FilterName] = errors.New("Filter name can be specified in Enterprise edition only")
+		}
 	}
 
 	return
@@ -415,33 +545,83 @@ func DecodeChangeReplicationSettings(request *http.Request, isDefaultSettings bo
 	return
 }
 
+// result of a successful create replication request
+type CreateReplicationResult struct {
+	ReplicationId string
+	// id of the validation of the replication, which could be used to cancel it while it was in progress.
+	// empty when the replication already existed, or when the response comes from an older server
+	ValidationId string
+	// non-fatal advisories from the validation of the replication.
+	// empty when there are no warnings, or when the response comes from an older server that does not return warnings
+	Warnings []string
+	// whether the replication already existed, when the request was made in create-or-get mode
+	AlreadyExists bool
+}
+
 // decode replicationId from create replication response
-func DecodeCreateReplicationResponse(response *http.Response) (string, error) {
+func DecodeCreateReplicationResponse(response *http.Response) (*CreateReplicationResult, error) {
 	defer response.Body.Close()
 
 	bodyBytes, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	var paramsMap map[string]interface{}
 	err = json.Unmarshal(bodyBytes, &paramsMap)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	replicationId, ok := paramsMap[ReplicationId]
 
 	if !ok {
-		return "", simple_utils.MissingParameterInHttpResponseError(ReplicationId)
+		return nil, simple_utils.MissingParameterInHttpResponseError(ReplicationId)
 	}
 
 	replicationIdStr, ok := replicationId.(string)
 	if !ok {
-		return "", simple_utils.IncorrectValueTypeInHttpResponseError(ReplicationId, replicationId, "string")
+		return nil, simple_utils.IncorrectValueTypeInHttpResponseError(ReplicationId, replicationId, "string")
+	}
+
+	result := &CreateReplicationResult{ReplicationId: replicationIdStr,
+		Warnings: make([]string, 0)}
+
+	// warnings are optional
+	warnings, ok := paramsMap[WarningsKey]
+	if ok {
+		warningsList, ok := warnings.([]interface{})
+		if !ok {
+			return nil, simple_utils.IncorrectValueTypeInHttpResponseError(WarningsKey, warnings, "list")
+		}
+		for _, warning := range warningsList {
+			warningStr, ok := warning.(string)
+			if !ok {
+				return nil, simple_utils.IncorrectValueTypeInHttpResponseError(WarningsKey, warning, "string")
+			}
+			result.Warnings = append(result.Warnings, warningStr)
+		}
+	}
+
+	// validation id is optional
+	validationId, ok := paramsMap[ValidationIdKey]
+	if ok {
+		result.ValidationId, ok = validationId.(string)
+		if !ok {
+			return nil, simple_utils.IncorrectValueTypeInHttpResponseError(ValidationIdKey, validationId, "string")
+		}
 	}
 
-	return replicationIdStr, nil
+	// alreadyExists is returned only when the replication already existed
+	alreadyExists, ok := paramsMap[AlreadyExistsKey]
+	if ok {
+		result.AlreadyExists, ok = alreadyExists.(bool)
+		if !ok {
+			return nil, simple_utils.IncorrectValueTypeInHttpResponseError(AlreadyExistsKey, alreadyExists, "bool")
+		}
+	}
+
+	return result, nil
 
 }
 
@@ -557,12 +737,114 @@ func DecodeRegexpValidationRequest(request *http.Request) (string, []string, err
 	return expression, keys, nil
 }
 
-func NewCreateReplicationResponse(replicationId string) (*ap.Response, error) {
+// returns whether the validate specs request is a dry run, in which invalid specs are only reported
+func DecodeValidateSpecsRequest(request *http.Request) (bool, error) {
+	if err := request.ParseForm(); err != nil {
+		return false, ErrorParsingForm
+	}
+
+	dryRun, err := getBoolFromValArr(request.Form[DryRun], false)
+	if err != nil {
+		return false, fmt.Errorf("Invalid value for %v. %v", DryRun, err)
+	}
+	return dryRun, nil
+}
+
+// decodes the name and the expression of the filter to be registered, which are passed in the same parameters
+// as the filter name and the filter expression of replications
+func DecodeRegisterNamedFilterRequest(request *http.Request) (name, expression string, errorsMap map[string]error) {
+	errorsMap = make(map[string]error)
+	if err := request.ParseForm(); err != nil {
+		errorsMap[base.PlaceHolderFieldKey] = ErrorParsingForm
+		return
+	}
+
+	name = getStringFromValArr(request.Form[FilterName])
+	expression = getStringFromValArr(request.Form[FilterExpression])
+	if len(name) == 0 {
+		errorsMap[FilterName] = simple_utils.MissingValueError("filter name")
+	}
+	if len(expression) == 0 {
+		errorsMap[FilterExpression] = simple_utils.MissingValueError("filter expression")
+	}
+	if len(errorsMap) == 0 {
+		if err := metadata.ValidateNamedFilter(name, expression); err != nil {
+			errorsMap[base.PlaceHolderFieldKey] = err
+		}
+	}
+	return
+}
+
+// summary of a validate specs request. each group of specs is a map from replication id to the error message
+func NewValidateSpecsResponse(summary *metadata.ReplicationSpecGCSummary, dryRun bool) (*ap.Response, error) {
+	errorMessages := func(errorsMap map[string]error) map[string]string {
+		messages := make(map[string]string)
+		for replicationId, err := range errorsMap {
+			messages[replicationId] = err.Error()
+		}
+		return messages
+	}
+
+	params := make(map[string]interface{})
+	params[DryRun] = dryRun
+	params[InvalidKey] = errorMessages(summary.Invalid)
+	params[GCedKey] = errorMessages(summary.GarbageCollected)
+	params[GCFailedKey] = errorMessages(summary.GCFailed)
+	params[SkippedKey] = errorMessages(summary.Skipped)
+	return EncodeObjectIntoResponse(params)
+}
+
+// warnings from validation, if any, are included in the response as a list of messages.
+// the list is omitted when there are no warnings, so that the response stays the same for older clients
+func NewCreateReplicationResponse(replicationId, validationId string, warningsMap map[string]error, alreadyExists bool) (*ap.Response, error) {
 	params := make(map[string]interface{})
 	params[ReplicationId] = replicationId
+	if len(validationId) > 0 {
+		params[ValidationIdKey] = validationId
+	}
+	if alreadyExists {
+		params[AlreadyExistsKey] = true
+	}
+	if len(warningsMap) > 0 {
+		warnings := make([]string, 0, len(warningsMap))
+		for _, warning := range warningsMap {
+			warnings = append(warnings, warning.Error())
+		}
+		sort.Strings(warnings)
+		params[WarningsKey] = warnings
+	}
 	return EncodeObjectIntoResponse(params)
 }
 
+// constants for compare settings response
+const (
+	DefaultValue     = "default"
+	ReplicationValue = "replication"
+)
+
+// lists the settings of a replication that differ from the default replication settings
+func NewCompareSettingsResponse(defaultSettings, replSettings *metadata.ReplicationSettings) (*ap.Response, error) {
+	diff := utils.DiffSettings(convertSettingsToRestSettingsMap(defaultSettings, true), convertSettingsToRestSettingsMap(replSettings, true))
+	diffMap := make(map[string]interface{})
+	for key, values := range diff {
+		diffMap[key] = map[string]interface{}{DefaultValue: values[0], ReplicationValue: values[1]}
+	}
+	return EncodeObjectIntoResponse(diffMap)
+}
+
+func NewReconcilePipelinesResponse(report *pipeline_manager.ReconcileReport) (*ap.Response, error) {
+	return EncodeObjectIntoResponse(report)
+}
+
+func NewGetInFlightValidationsResponse(validations []*InFlightValidation) (*ap.Response, error) {
+	return EncodeObjectIntoResponse(validations)
+}
+
+// response for a create replication request whose validation has been cancelled
+func NewValidationCancelledResponse() (*ap.Response, error) {
+	return EncodeErrorMessageIntoResponse(service_def.ValidationCancelledError, http.StatusConflict)
+}
+
 func NewReplicationSettingsResponse(settings *metadata.ReplicationSettings) (*ap.Response, error) {
 	if settings == nil {
 		return NewEmptyArrayResponse()
@@ -607,6 +889,40 @@ func NewXDCRInternalSettingsResponse(settings *metadata.InternalSettings) (*ap.R
 	}
 }
 
+// constants for settings schema response
+const (
+	ReplicationSettingsSchemaKey = "replicationSettings"
+	SupervisorSettingsSchemaKey  = "supervisorSettings"
+)
+
+// describes replication settings by their keys in rest api. settings that cannot be set through rest api are left out
+func NewSettingsSchemaResponse(replicationSettingsSchema, supervisorSettingsSchema []*base.SettingSchema) (*ap.Response, error) {
+	restSchema := make([]*base.SettingSchema, 0, len(replicationSettingsSchema))
+	for _, setting := range replicationSettingsSchema {
+		restKey, ok := SettingsKeyToRestKeyMap[setting.Name]
+		if !ok {
+			continue
+		}
+		restSetting := *setting
+		restSetting.Name = restKey
+		// active is exposed as its negation in rest api
+		if setting.Name == metadata.Active {
+			restSetting.Default = !setting.Default.(bool)
+		}
+		restSchema = append(restSchema, &restSetting)
+	}
+	sort.Sort(settingSchemasByName(restSchema))
+
+	return EncodeObjectIntoResponse(map[string]interface{}{ReplicationSettingsSchemaKey: restSchema,
+		SupervisorSettingsSchemaKey: supervisorSettingsSchema})
+}
+
+type settingSchemasByName []*base.SettingSchema
+
+func (schemas settingSchemasByName) Len() int           { return len(schemas) }
+func (schemas settingSchemasByName) Swap(i, j int)      { schemas[i], schemas[j] = schemas[j], schemas[i] }
+func (schemas settingSchemasByName) Less(i, j int) bool { return schemas[i].Name < schemas[j].Name }
+
 func NewRegexpValidationResponse(matchesMap map[string][][]int) (*ap.Response, error) {
 	returnMap := make(map[string]interface{})
 
@@ -625,6 +941,36 @@ func NewRegexpValidationResponse(matchesMap map[string][][]int) (*ap.Response, e
 }
 
 // decode dynamic paramater from the path of http request
+// decodes the remote cluster name from the path of a request for the replications to the remote cluster
+func DecodeRemoteClusterReplicationsRequest(request *http.Request) (string, error) {
+	param, err := DecodeDynamicParamInURL(request, base.RemoteClustersPath, "Remote Cluster Name")
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(param, RemoteClusterReplicationsSuffix) || len(param) == len(RemoteClusterReplicationsSuffix) {
+		return "", simple_utils.InvalidPathInHttpRequestError(request.URL.Path)
+	}
+	return param[:len(param)-len(RemoteClusterReplicationsSuffix)], nil
+}
+
+// decodes whether the remote cluster reference is to be deleted even when there are replications to the remote cluster
+func DecodeDeleteRemoteClusterRequest(request *http.Request) (force bool, errorsMap map[string]error) {
+	errorsMap = make(map[string]error)
+	if err := request.ParseForm(); err != nil {
+		errorsMap[base.PlaceHolderFieldKey] = ErrorParsingForm
+		return
+	}
+
+	if val, ok := request.Form[base.RemoteClusterForceDelete]; ok && len(val) > 0 {
+		var err error
+		force, err = strconv.ParseBool(val[0])
+		if err != nil {
+			errorsMap[base.RemoteClusterForceDelete] = simple_utils.GenericInvalidValueError(base.RemoteClusterForceDelete)
+		}
+	}
+	return
+}
+
 func DecodeDynamicParamInURL(request *http.Request, pathPrefix string, paramName string) (string, error) {
 	// length of prefix preceding replicationId in request url path
 	prefixLength := len(base.AdminportUrlPrefix) + len(pathPrefix) + len(base.UrlDelimiter)
@@ -0,0 +1,252 @@
+package replication_manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/metadata"
+	"github.com/couchbase/goxdcr/supervisor"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func toHttpResponse(body []byte) *http.Response {
+	return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(body))}
+}
+
+func TestCreateReplicationResponseWithWarnings(t *testing.T) {
+	warningsMap := map[string]error{"toCluster": errors.New("warning2"), "_": errors.New("warning1")}
+	response, err := NewCreateReplicationResponse("replId", "validationId", warningsMap, false)
+	if err != nil {
+		t.Fatalf("Unexpected error encoding response. err=%v", err)
+	}
+
+	result, err := DecodeCreateReplicationResponse(toHttpResponse(response.Body))
+	if err != nil {
+		t.Fatalf("Unexpected error decoding response. err=%v", err)
+	}
+	if result.ReplicationId != "replId" {
+		t.Errorf("Decoded replication id %q, expected %q", result.ReplicationId, "replId")
+	}
+	if result.ValidationId != "validationId" {
+		t.Errorf("Decoded validation id %q, expected %q", result.ValidationId, "validationId")
+	}
+	expectedWarnings := []string{"warning1", "warning2"}
+	if !reflect.DeepEqual(result.Warnings, expectedWarnings) {
+		t.Errorf("Decoded warnings %v, expected %v", result.Warnings, expectedWarnings)
+	}
+}
+
+func TestCreateReplicationResponseWithoutWarnings(t *testing.T) {
+	// response from older servers contains replication id only
+	result, err := DecodeCreateReplicationResponse(toHttpResponse([]byte(`{"id":"replId"}`)))
+	if err != nil {
+		t.Fatalf("Unexpected error decoding response. err=%v", err)
+	}
+	if result.ReplicationId != "replId" {
+		t.Errorf("Decoded replication id %q, expected %q", result.ReplicationId, "replId")
+	}
+	if len(result.Warnings) != 0 {
+		t.Errorf("Decoded warnings %v, expected none", result.Warnings)
+	}
+	if result.AlreadyExists {
+		t.Errorf("Decoded alreadyExists flag when it is not in response")
+	}
+	if len(result.ValidationId) != 0 {
+		t.Errorf("Decoded validation id %q when it is not in response", result.ValidationId)
+	}
+
+	_, err = DecodeCreateReplicationResponse(toHttpResponse([]byte(`{"id":"replId","warnings":"warning"}`)))
+	if err == nil {
+		t.Errorf("Expected error decoding warnings of wrong type")
+	}
+}
+
+func TestCreateReplicationResponseForExistingReplication(t *testing.T) {
+	response, err := NewCreateReplicationResponse("replId", "", nil, true)
+	if err != nil {
+		t.Fatalf("Unexpected error encoding response. err=%v", err)
+	}
+
+	result, err := DecodeCreateReplicationResponse(toHttpResponse(response.Body))
+	if err != nil {
+		t.Fatalf("Unexpected error decoding response. err=%v", err)
+	}
+	if result.ReplicationId != "replId" {
+		t.Errorf("Decoded replication id %q, expected %q", result.ReplicationId, "replId")
+	}
+	if !result.AlreadyExists {
+		t.Errorf("alreadyExists flag was not decoded")
+	}
+}
+
+func TestSettingsSchemaResponse(t *testing.T) {
+	response, err := NewSettingsSchemaResponse(metadata.ReplicationSettingsSchema(), supervisor.SettingsSchema())
+	if err != nil {
+		t.Fatalf("Unexpected error encoding response. err=%v", err)
+	}
+
+	schema := make(map[string][]*base.SettingSchema)
+	if err = json.Unmarshal(response.Body, &schema); err != nil {
+		t.Fatalf("Unexpected error decoding response. err=%v", err)
+	}
+
+	// replication settings are described by their keys in rest api
+	replicationSettings := make(map[string]*base.SettingSchema)
+	for _, setting := range schema[ReplicationSettingsSchemaKey] {
+		replicationSettings[setting.Name] = setting
+	}
+	if len(replicationSettings) != len(RestKeyToSettingsKeyMap)-2 {
+		t.Errorf("%v replication settings are described, expected all settings in rest api except goMaxProcs and goGC", len(replicationSettings))
+	}
+	expected := []*base.SettingSchema{
+		{Name: BatchCount, Type: "int", Default: float64(500)},
+		{Name: PauseRequested, Type: "bool", Default: false},
+		{Name: LogLevel, Type: "string", Default: "Info"},
+		{Name: CompressionType, Type: "string", Default: metadata.CompressionTypeNone},
+	}
+	for _, setting := range expected {
+		if !reflect.DeepEqual(replicationSettings[setting.Name], setting) {
+			t.Errorf("%v is described as %+v, expected %+v", setting.Name, replicationSettings[setting.Name], setting)
+		}
+	}
+
+	supervisorSettings := make(map[string]*base.SettingSchema)
+	for _, setting := range schema[SupervisorSettingsSchemaKey] {
+		supervisorSettings[setting.Name] = setting
+	}
+	if setting := supervisorSettings[supervisor.HEARTBEAT_INTERVAL]; setting == nil || setting.Type != "time.Duration" {
+		t.Errorf("%v is described as %+v", supervisor.HEARTBEAT_INTERVAL, setting)
+	}
+	if setting := supervisorSettings[supervisor.FAILURE_ISOLATION_POLICY]; setting == nil || setting.Default != string(supervisor.FailureIsolationIsolate) {
+		t.Errorf("%v is described as %+v", supervisor.FAILURE_ISOLATION_POLICY, setting)
+	}
+}
+
+func TestDecodeRemoteClusterReplicationsRequest(t *testing.T) {
+	prefix := base.AdminportUrlPrefix + base.RemoteClustersPath + base.UrlDelimiter
+	expected := map[string]string{
+		prefix + "remote1/replications": "remote1",
+		prefix + "remote1":              "",
+		prefix + "/replications":        "",
+	}
+	for path, expectedName := range expected {
+		request, _ := http.NewRequest(base.MethodGet, "http://localhost:9998"+path, nil)
+		name, err := DecodeRemoteClusterReplicationsRequest(request)
+		if name != expectedName || (err == nil) != (expectedName != "") {
+			t.Errorf("%v is decoded into %q, err=%v, expected %q", path, name, err, expectedName)
+		}
+	}
+}
+
+func TestDecodeDeleteRemoteClusterRequest(t *testing.T) {
+	path := "http://localhost:9998" + base.AdminportUrlPrefix + base.RemoteClustersPath + "/remote1"
+	for query, expectedForce := range map[string]bool{"": false, "?force=true": true, "?force=false": false} {
+		request, _ := http.NewRequest(base.MethodDelete, path+query, nil)
+		if force, errorsMap := DecodeDeleteRemoteClusterRequest(request); force != expectedForce || len(errorsMap) != 0 {
+			t.Errorf("%q is decoded into force=%v, errorsMap=%v, expected force=%v", query, force, errorsMap, expectedForce)
+		}
+	}
+
+	request, _ := http.NewRequest(base.MethodDelete, path+"?force=maybe", nil)
+	if _, errorsMap := DecodeDeleteRemoteClusterRequest(request); errorsMap[base.RemoteClusterForceDelete] == nil {
+		t.Errorf("invalid force flag was accepted")
+	}
+}
+
+func TestRemoteClusterInUseResponse(t *testing.T) {
+	response, err := NewRemoteClusterInUseResponse("remote1", []string{"repl1", "repl2"})
+	if err != nil {
+		t.Fatalf("Unexpected error encoding response. err=%v", err)
+	}
+	if response.StatusCode != http.StatusConflict || !bytes.Contains(response.Body, []byte("repl1 repl2")) {
+		t.Errorf("response has status code %v and body %s", response.StatusCode, response.Body)
+	}
+}
+
+func TestDecodeCreateRemoteClusterRequestReadOnly(t *testing.T) {
+	path := "http://localhost:9998" + base.AdminportUrlPrefix + base.RemoteClustersPath
+	params := "name=remote1&hostname=host1&username=user&password=secret"
+	expected := map[string]bool{"": false, "&readOnly=true": true, "&readOnly=false": false}
+	for readOnlyParam, expectedReadOnly := range expected {
+		request, _ := http.NewRequest(base.MethodPost, path, bytes.NewBufferString(params+readOnlyParam))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		_, _, ref, errorsMap, err := DecodeCreateRemoteClusterRequest(request)
+		if err != nil || len(errorsMap) != 0 {
+			t.Fatalf("Failed to decode %q. errorsMap=%v, err=%v", readOnlyParam, errorsMap, err)
+		}
+		if ref.ReadOnly != expectedReadOnly {
+			t.Errorf("%q is decoded into readOnly=%v, expected %v", readOnlyParam, ref.ReadOnly, expectedReadOnly)
+		}
+		if output, _ := NewCreateRemoteClusterResponse(ref); !bytes.Contains(output.Body, []byte(`"readOnly":`+strconv.FormatBool(expectedReadOnly))) {
+			t.Errorf("Response for %q is %s, expected readOnly=%v", readOnlyParam, output.Body, expectedReadOnly)
+		}
+	}
+
+	request, _ := http.NewRequest(base.MethodPost, path, bytes.NewBufferString(params+"&readOnly=maybe"))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if _, _, _, errorsMap, err := DecodeCreateRemoteClusterRequest(request); err != nil || errorsMap[base.RemoteClusterReadOnly] == nil {
+		t.Errorf("Invalid read-only flag was accepted. errorsMap=%v, err=%v", errorsMap, err)
+	}
+}
+
+func TestValidateSpecsRequestAndResponse(t *testing.T) {
+	path := "http://localhost:9998" + base.AdminportUrlPrefix + ValidateSpecsPath
+	for query, expectedDryRun := range map[string]bool{"": false, "?dryRun=true": true, "?dryRun=false": false} {
+		request, _ := http.NewRequest(base.MethodPost, path+query, nil)
+		if dryRun, err := DecodeValidateSpecsRequest(request); dryRun != expectedDryRun || err != nil {
+			t.Errorf("%q is decoded into dryRun=%v, err=%v, expected dryRun=%v", query, dryRun, err, expectedDryRun)
+		}
+	}
+	request, _ := http.NewRequest(base.MethodPost, path+"?dryRun=maybe", nil)
+	if _, err := DecodeValidateSpecsRequest(request); err == nil {
+		t.Errorf("invalid dryRun flag was accepted")
+	}
+
+	summary := metadata.NewReplicationSpecGCSummary()
+	summary.Invalid["repl1"] = errors.New("non-existent target bucket")
+	summary.Skipped["repl2"] = errors.New("remote cluster is not reachable")
+	response, err := NewValidateSpecsResponse(summary, true)
+	if err != nil {
+		t.Fatalf("Unexpected error encoding response. err=%v", err)
+	}
+	result := make(map[string]interface{})
+	if err = json.Unmarshal(response.Body, &result); err != nil {
+		t.Fatalf("Failed to unmarshal response %s. err=%v", response.Body, err)
+	}
+	expected := map[string]interface{}{
+		DryRun:      true,
+		InvalidKey:  map[string]interface{}{"repl1": "non-existent target bucket"},
+		GCedKey:     map[string]interface{}{},
+		GCFailedKey: map[string]interface{}{},
+		SkippedKey:  map[string]interface{}{"repl2": "remote cluster is not reachable"},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("response is %v, expected %v", result, expected)
+	}
+}
+
+func TestDecodeRegisterNamedFilterRequest(t *testing.T) {
+	path := "http://localhost:9998" + base.AdminportUrlPrefix + NamedFiltersPath
+	request, _ := http.NewRequest(base.MethodPost, path+"?filterName=app1&filterExpression=%5Eapp1%3A", nil)
+	if name, expression, errorsMap := DecodeRegisterNamedFilterRequest(request); name != "app1" || expression != "^app1:" || len(errorsMap) != 0 {
+		t.Errorf("request is decoded into name=%q, expression=%q, errors=%v", name, expression, errorsMap)
+	}
+
+	expectedErrorKeys := map[string]string{
+		"?filterExpression=%5Eapp1%3A":          FilterName,
+		"?filterName=app1":                      FilterExpression,
+		"?filterName=app1&filterExpression=%5B": base.PlaceHolderFieldKey,
+		"?filterName=a%2Fb&filterExpression=x":  base.PlaceHolderFieldKey,
+	}
+	for query, errorKey := range expectedErrorKeys {
+		request, _ := http.NewRequest(base.MethodPost, path+query, nil)
+		if _, _, errorsMap := DecodeRegisterNamedFilterRequest(request); errorsMap[errorKey] == nil {
+			t.Errorf("%q is decoded with errors %v, expected error for %v", query, errorsMap, errorKey)
+		}
+	}
+}
@@ -13,6 +13,8 @@ package replication_manager
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"expvar"
@@ -45,6 +47,9 @@ var StatsUpdateIntervalForPausedReplications = 60 * time.Second
 var StatusCheckInterval = 15 * time.Second
 var MemStatsLogInterval = 2 * time.Minute
 
+var GlobalReplicationDisabledError = errors.New("Replications have been disabled globally. Replications cannot be resumed individually until they are enabled globally.")
+var VBNotIsolatedError = errors.New("VBucket has not been isolated")
+
 var GoXDCROptions struct {
 	SourceKVAdminPort    uint64 //source kv admin port
 	XdcrRestPort         uint64 // port number of XDCR rest server
@@ -105,12 +110,16 @@ type replicationManager struct {
 	status_logger_finch chan bool
 
 	mem_stats_logger_finch chan bool
+
+	canary_finch chan bool
+
+	metadata_change_monitor *MetadataChangeMonitor
 }
 
 //singleton
 var replication_mgr replicationManager
 
-func StartReplicationManager(sourceKVHost string, xdcrRestPort uint16,
+func StartReplicationManager(sourceKVHost string, xdcrRestPort uint16, adminportTLSConfig *tls.Config,
 	repl_spec_svc service_def.ReplicationSpecSvc,
 	remote_cluster_svc service_def.RemoteClusterSvc,
 	cluster_info_svc service_def.ClusterInfoSvc,
@@ -122,7 +131,8 @@ func StartReplicationManager(sourceKVHost string, xdcrRestPort uint16,
 	uilog_svc service_def.UILogSvc,
 	global_setting_svc service_def.GlobalSettingsSvc,
 	bucket_settings_svc service_def.BucketSettingsSvc,
-	internal_settings_svc service_def.InternalSettingsSvc) {
+	internal_settings_svc service_def.InternalSettingsSvc,
+	metadata_svc service_def.MetadataSvc) {
 
 	replication_mgr.once.Do(func() {
 		// ns_server shutdown protocol: poll stdin and exit upon reciept of EOF
@@ -132,7 +142,7 @@ func StartReplicationManager(sourceKVHost string, xdcrRestPort uint16,
 		initInternalSettings(internal_settings_svc)
 
 		// initializes replication manager
-		replication_mgr.init(repl_spec_svc, remote_cluster_svc, cluster_info_svc, xdcr_topology_svc, replication_settings_svc, checkpoints_svc, capi_svc, audit_svc, uilog_svc, global_setting_svc, bucket_settings_svc, internal_settings_svc)
+		replication_mgr.init(repl_spec_svc, remote_cluster_svc, cluster_info_svc, xdcr_topology_svc, replication_settings_svc, checkpoints_svc, capi_svc, audit_svc, uilog_svc, global_setting_svc, bucket_settings_svc, internal_settings_svc, metadata_svc)
 
 		// start pipeline master supervisor
 		// TODO should we make heart beat settings configurable?
@@ -159,8 +169,12 @@ func StartReplicationManager(sourceKVHost string, xdcrRestPort uint16,
 		replication_mgr.mem_stats_logger_finch = make(chan bool, 1)
 		go logMemStats(replication_mgr.mem_stats_logger_finch)
 
+		// periodically measure end to end latency of replications that have canary enabled
+		replication_mgr.canary_finch = make(chan bool, 1)
+		go replication_mgr.runCanaries(replication_mgr.canary_finch)
+
 		// start adminport
-		adminport := NewAdminport(sourceKVHost, xdcrRestPort, replication_mgr.adminport_finch)
+		adminport := NewAdminport(sourceKVHost, xdcrRestPort, adminportTLSConfig, replication_mgr.adminport_finch)
 		go adminport.Start()
 		logger_rm.Info("Admin port has been launched")
 		// add adminport as children of replication manager supervisor
@@ -181,7 +195,11 @@ func initInternalSettings(internal_settings_svc service_def.InternalSettingsSvc)
 	base.InitConstants(time.Duration(internal_settings.TopologyChangeCheckInterval)*time.Second, internal_settings.MaxTopologyChangeCountBeforeRestart,
 		internal_settings.MaxTopologyStableCountBeforeRestart, internal_settings.MaxWorkersForCheckpointing,
 		time.Duration(internal_settings.TimeoutCheckpointBeforeStop)*time.Second,
-		internal_settings.CapiDataChanSizeMultiplier)
+		internal_settings.CapiDataChanSizeMultiplier, internal_settings.StatsHistorySize,
+		internal_settings.ForceDirectBucketUUIDLookup == 1, internal_settings.SettingsHistoryDepth,
+		time.Duration(internal_settings.SourceBucketWarmupTimeout)*time.Second,
+		internal_settings.RemoteBucketLookupMaxAttempts, time.Duration(internal_settings.RemoteBucketLookupBaseBackoff)*time.Millisecond,
+		internal_settings.AllowConflictResolutionTypeMismatch == 1)
 }
 
 func (rm *replicationManager) initMetadataChangeMonitor() {
@@ -224,6 +242,7 @@ func (rm *replicationManager) initMetadataChangeMonitor() {
 	mcm.RegisterListener(internalSettingsChangeListener)
 	rm.internal_settings_svc.SetMetadataChangeHandlerCallback(internalSettingsChangeListener.internalSettingsChangeHandlerCallback)
 
+	rm.metadata_change_monitor = mcm
 	mcm.Start()
 }
 
@@ -285,7 +304,8 @@ func (rm *replicationManager) init(
 	uilog_svc service_def.UILogSvc,
 	global_setting_svc service_def.GlobalSettingsSvc,
 	bucket_settings_svc service_def.BucketSettingsSvc,
-	internal_settings_svc service_def.InternalSettingsSvc) {
+	internal_settings_svc service_def.InternalSettingsSvc,
+	metadata_svc service_def.MetadataSvc) {
 
 	rm.GenericSupervisor = *supervisor.NewGenericSupervisor(base.ReplicationManagerSupervisorId, log.DefaultLoggerContext, rm, nil)
 	rm.pipelineMasterSupervisor = supervisor.NewGenericSupervisor(base.PipelineMasterSupervisorId, log.DefaultLoggerContext, rm, &rm.GenericSupervisor)
@@ -304,7 +324,7 @@ func (rm *replicationManager) init(
 	rm.internal_settings_svc = internal_settings_svc
 	fac := factory.NewXDCRFactory(repl_spec_svc, remote_cluster_svc, cluster_info_svc, xdcr_topology_svc, checkpoint_svc, capi_svc, uilog_svc, bucket_settings_svc, log.DefaultLoggerContext, log.DefaultLoggerContext, rm, rm.pipelineMasterSupervisor)
 
-	pipeline_manager.PipelineManager(fac, repl_spec_svc, xdcr_topology_svc, remote_cluster_svc, log.DefaultLoggerContext)
+	pipeline_manager.PipelineManager(fac, repl_spec_svc, xdcr_topology_svc, remote_cluster_svc, metadata_svc, log.DefaultLoggerContext)
 
 	rm.metadata_change_callback_cancel_ch = make(chan struct{}, 1)
 
@@ -352,29 +372,61 @@ func InternalSettingsService() service_def.InternalSettingsSvc {
 }
 
 //CreateReplication create the replication specification in metadata store
-//and start the replication pipeline
-func CreateReplication(justValidate bool, sourceBucket, targetCluster, targetBucket string, settings map[string]interface{}, realUserId *base.RealUserId) (string, map[string]error, error) {
+//and start the replication pipeline. besides the replication id, it returns the id under which the validation
+//of the replication was registered while it was in progress
+func CreateReplication(justValidate bool, sourceBucket, targetCluster, targetBucket string, settings map[string]interface{}, realUserId *base.RealUserId) (string, string, map[string]error, map[string]error, error) {
 	logger_rm.Infof("Creating replication - justValidate=%v, sourceBucket=%s, targetCluster=%s, targetBucket=%s, settings=%v\n",
 		justValidate, sourceBucket, targetCluster, targetBucket, settings)
 
+	// register the validation so that it can be cancelled through adminport while it is in progress
+	validation, ctx, err := registerValidation(sourceBucket, targetCluster, targetBucket)
+	if err != nil {
+		logger_rm.Errorf("%v\n", err)
+		return "", "", nil, nil, err
+	}
+	defer unregisterValidation(validation.ValidationId)
+
 	var spec *metadata.ReplicationSpecification
-	spec, errorsMap, err := replication_mgr.createAndPersistReplicationSpec(justValidate, sourceBucket, targetCluster, targetBucket, settings)
+	spec, errorsMap, warningsMap, err := replication_mgr.createAndPersistReplicationSpec(ctx, justValidate, sourceBucket, targetCluster, targetBucket, settings)
 	if err != nil {
 		logger_rm.Errorf("%v\n", err)
-		return "", nil, err
+		return "", validation.ValidationId, nil, nil, err
 	} else if len(errorsMap) != 0 {
-		return "", errorsMap, nil
+		return "", validation.ValidationId, errorsMap, nil, nil
 	}
 
 	if justValidate {
-		return spec.Id, nil, nil
+		return spec.Id, validation.ValidationId, nil, warningsMap, nil
 	}
 
 	go writeCreateReplicationEvent(spec, realUserId)
 
 	logger_rm.Infof("Replication specification %s is created\n", spec.Id)
 
-	return spec.Id, nil, nil
+	return spec.Id, validation.ValidationId, nil, warningsMap, nil
+}
+
+// returns the id of the replication with the given source bucket, target cluster and target bucket,
+// or an empty string if the replication does not exist
+func GetExistingReplicationId(sourceBucket, targetCluster, targetBucket string) (string, error) {
+	targetClusterRef, err := RemoteClusterService().RemoteClusterByRefName(targetCluster, false)
+	if err != nil {
+		// let the regular create replication flow report the invalid target cluster
+		return "", nil
+	}
+
+	replicationId := metadata.ReplicationId(sourceBucket, targetClusterRef.Uuid, targetBucket)
+	_, err = ReplicationSpecService().ReplicationSpec(replicationId)
+	if err != nil {
+		if ReplicationSpecService().IsReplicationValidationError(err) {
+			// replication does not exist
+			return "", nil
+		}
+		return "", err
+	}
+
+	logger_rm.Infof("Replication %v already exists\n", replicationId)
+	return replicationId, nil
 }
 
 //DeleteReplication stops the running replication of given replicationId and
@@ -497,19 +549,8 @@ func UpdateReplicationSettings(topic string, settings map[string]interface{}, re
 		return nil, err
 	}
 
-	oldFilterExpression := replSpec.Settings.FilterExpression
-
 	// update replication spec with input settings
-	changedSettingsMap, errorMap := replSpec.Settings.UpdateSettingsFromMap(settings)
-
-	// enforce that filter expression cannot be changed
-	newFilterExpression, ok := settings[FilterExpression]
-	if ok {
-		if newFilterExpression != oldFilterExpression {
-			errorMap[FilterExpression] = errors.New("Filter expression cannot be changed after the replication is created")
-		}
-	}
-
+	changedSettingsMap, errorMap := applyReplicationSettingsChange(replSpec, settings)
 	if len(errorMap) != 0 {
 		return errorMap, nil
 	}
@@ -541,6 +582,114 @@ func UpdateReplicationSettings(topic string, settings map[string]interface{}, re
 	return nil, nil
 }
 
+// whether replications are enabled through the global switch.
+// returns false when the state of the global switch cannot be retrieved, so that replications are not resumed by mistake
+func GlobalReplicationEnabled() bool {
+	globalSettings, err := GlobalSettingsService().GetDefaultGlobalSettings()
+	if err != nil {
+		logger_rm.Errorf("Failed to retrieve global settings. err=%v\n", err)
+		return false
+	}
+	return !globalSettings.ReplicationsDisabled
+}
+
+// pauses all replications and disables replications through the global switch,
+// so that no replication can be resumed individually until ResumeAllReplications is called
+func PauseAllReplications(realUserId *base.RealUserId) error {
+	logger_rm.Infof("Pausing all replications\n")
+
+	err := setGlobalReplicationEnabled(false)
+	if err != nil {
+		return err
+	}
+
+	return setActiveForAllReplications(false, realUserId)
+}
+
+// enables replications through the global switch and resumes all replications
+func ResumeAllReplications(realUserId *base.RealUserId) error {
+	logger_rm.Infof("Resuming all replications\n")
+
+	err := setGlobalReplicationEnabled(true)
+	if err != nil {
+		return err
+	}
+
+	return setActiveForAllReplications(true, realUserId)
+}
+
+// persists the state of the global switch in global settings, so that it survives restart
+func setGlobalReplicationEnabled(enabled bool) error {
+	globalSettings, err := GlobalSettingsService().GetDefaultGlobalSettings()
+	if err != nil {
+		return err
+	}
+
+	if globalSettings.ReplicationsDisabled == !enabled {
+		logger_rm.Infof("Did not update global replication switch since it is already set to enabled=%v\n", enabled)
+		return nil
+	}
+
+	globalSettings.ReplicationsDisabled = !enabled
+	err = GlobalSettingsService().SetDefaultGlobalSettings(globalSettings)
+	if err != nil {
+		return err
+	}
+	logger_rm.Infof("Updated global replication switch to enabled=%v\n", enabled)
+	return nil
+}
+
+// sets the Active flag on the specs of all replications. pipeline manager is signaled through the resulting spec changes.
+// the update is attempted on all replications even if it fails on some of them
+func setActiveForAllReplications(active bool, realUserId *base.RealUserId) error {
+	specs, err := ReplicationSpecService().AllReplicationSpecs()
+	if err != nil {
+		return err
+	}
+
+	failedReplications := make(map[string]interface{})
+	for topic, spec := range specs {
+		if spec.Settings.Active == active {
+			continue
+		}
+
+		errorMap, err := UpdateReplicationSettings(topic, map[string]interface{}{metadata.Active: active}, realUserId)
+		if err != nil {
+			failedReplications[topic] = err
+		} else if len(errorMap) != 0 {
+			failedReplications[topic] = errorMap
+		}
+	}
+
+	if len(failedReplications) != 0 {
+		logger_rm.Errorf("Failed to set active=%v on replications. errors=%v\n", active, failedReplications)
+		return fmt.Errorf("Failed to set active=%v on replications %v", active, failedReplications)
+	}
+	return nil
+}
+
+// updates the settings of replSpec with input settings, and validates the changes
+func applyReplicationSettingsChange(replSpec *metadata.ReplicationSpecification, settings map[string]interface{}) (map[string]interface{}, map[string]error) {
+	oldFilterExpression := replSpec.Settings.FilterExpression
+
+	changedSettingsMap, errorMap := replSpec.Settings.UpdateSettingsFromMap(settings)
+
+	// enforce that filter expression cannot be changed
+	newFilterExpression, ok := settings[FilterExpression]
+	if ok {
+		if newFilterExpression != oldFilterExpression {
+			errorMap[FilterExpression] = errors.New("Filter expression cannot be changed after the replication is created")
+		}
+	}
+
+	// replications cannot be resumed individually while they are disabled through the global switch
+	if active, ok := changedSettingsMap[metadata.Active]; ok && active.(bool) && !GlobalReplicationEnabled() {
+		errorMap[base.PlaceHolderFieldKey] = GlobalReplicationDisabledError
+	}
+
+	return changedSettingsMap, errorMap
+}
+
 // get statistics for all running replications
 //% returns a list of replication stats for the bucket. the format for each
 //% item in the list is:
@@ -567,41 +716,169 @@ func GetStatistics(bucket string) (*expvar.Map, error) {
 	return stats, nil
 }
 
+func GetStatisticsHistory(replicationId string, metric string) ([]base.TimedSample, error) {
+	return pipeline_svc.GetStatisticsHistory(replicationId, metric)
+}
+
+// get the recent settings changes of a replication, oldest first
+func GetSettingsHistory(replicationId string) ([]metadata.SettingsChange, error) {
+	return ReplicationSpecService().GetSettingsHistory(replicationId)
+}
+
+func GetQuarantinedReplications() []*pipeline_manager.QuarantinedReplication {
+	return pipeline_manager.GetQuarantinedReplications()
+}
+
+func UnquarantineReplication(replicationId string) error {
+	return pipeline_manager.UnquarantineReplication(replicationId)
+}
+
+// checkpoints a running replication right away, and returns the latest persisted checkpoint record of each vb
+func CheckpointNow(replicationId string) (map[uint16]metadata.CheckpointRecord, error) {
+	rep_status, err := pipeline_manager.ReplicationStatus(replicationId)
+	if err != nil {
+		return nil, err
+	}
+	p := rep_status.Pipeline()
+	if p == nil || p.RuntimeContext() == nil {
+		return nil, fmt.Errorf("Replication %v is not running", replicationId)
+	}
+	ckmgr, ok := p.RuntimeContext().Service(base.CHECKPOINT_MGR_SVC).(*pipeline_svc.CheckpointManager)
+	if !ok {
+		return nil, fmt.Errorf("Checkpoint manager is not found for replication %v", replicationId)
+	}
+
+	logger_rm.Infof("Checkpointing replication %v on demand\n", replicationId)
+	return ckmgr.CheckpointNow()
+}
+
+// vbs of a replication that have been isolated because of repeated failures on target, and the errors that caused the isolation
+func GetIsolatedVBs(replicationId string) (map[uint16]error, error) {
+	rep_status, err := pipeline_manager.ReplicationStatus(replicationId)
+	if err != nil {
+		return nil, err
+	}
+	return rep_status.IsolatedVBs(), nil
+}
+
+// retries a vb that has been isolated. since docs in the vb have been dropped since the isolation,
+// the pipeline is restarted and all vbs resume from their last checkpoints
+func ResumeVbucket(replicationId string, vbno uint16) error {
+	isolatedVBs, err := GetIsolatedVBs(replicationId)
+	if err != nil {
+		return err
+	}
+	if _, ok := isolatedVBs[vbno]; !ok {
+		return VBNotIsolatedError
+	}
+
+	logger_rm.Infof("Resuming isolated vb %v of replication %v\n", vbno, replicationId)
+	return pipeline_manager.Update(replicationId, nil)
+}
+
+// temporarily scales up the nozzle counts of a running replication by multiplier, without changing its replication settings.
+// the replication reverts to its replication settings after duration, or when the boost is released
+func BoostReplication(replicationId string, multiplier float64, duration time.Duration) error {
+	logger_rm.Infof("Boosting replication %v by %v for %v\n", replicationId, multiplier, duration)
+	return pipeline_manager.BoostReplication(replicationId, multiplier, duration)
+}
+
+func ReleaseBoost(replicationId string) error {
+	logger_rm.Infof("Releasing boost of replication %v\n", replicationId)
+	return pipeline_manager.ReleaseBoost(replicationId)
+}
+
+// settings that a replication currently runs with, and its boost, including the expiry of the boost.
+// the boost is nil when the replication is not boosted
+func GetEffectiveSettings(replicationId string) (*metadata.ReplicationSettings, *pipeline.ResourceBoost, error) {
+	return pipeline_manager.EffectiveSettings(replicationId)
+}
+
+// stops pipelines of paused replications and starts pipelines of active replications that are not running
+func ReconcilePipelines() (*pipeline_manager.ReconcileReport, error) {
+	return pipeline_manager.ReconcilePipelines()
+}
+
+// validates all replication specs and garbage collects the invalid ones. in a dry run the invalid ones are only reported
+func ValidateSpecs(dryRun bool) (*metadata.ReplicationSpecGCSummary, error) {
+	specMap, err := ReplicationSpecService().AllReplicationSpecs()
+	if err != nil {
+		return nil, err
+	}
+	specs := make([]*metadata.ReplicationSpecification, 0, len(specMap))
+	for _, spec := range specMap {
+		specs = append(specs, spec)
+	}
+
+	if dryRun {
+		return ReplicationSpecService().ValidateBatch(specs), nil
+	}
+	return ReplicationSpecService().ValidateAndGCBatch(specs), nil
+}
+
+// result of the validation of a new replication spec
+type specValidationResult struct {
+	sourceBucketUUID string
+	targetBucketUUID string
+	targetClusterRef *metadata.RemoteClusterReference
+	errorMap         map[string]error
+	warningMap       map[string]error
+}
+
 //create and persist the replication specification
-func (rm *replicationManager) createAndPersistReplicationSpec(justValidate bool, sourceBucket, targetCluster, targetBucket string, settings map[string]interface{}) (*metadata.ReplicationSpecification, map[string]error, error) {
+func (rm *replicationManager) createAndPersistReplicationSpec(ctx context.Context, justValidate bool, sourceBucket, targetCluster, targetBucket string, settings map[string]interface{}) (*metadata.ReplicationSpecification, map[string]error, map[string]error, error) {
 	logger_rm.Infof("Creating replication spec - justValidate=%v, sourceBucket=%s, targetCluster=%s, targetBucket=%s, settings=%v\n",
 		justValidate, sourceBucket, targetCluster, targetBucket, settings)
 
-	// validate that everything is alright with the replication configuration before actually creating it
-	sourceBucketUUID, targetBucketUUID, targetClusterRef, errorMap := replication_mgr.repl_spec_svc.ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket, settings)
-	if len(errorMap) > 0 {
-		return nil, errorMap, nil
+	// validate that everything is alright with the replication configuration before actually creating it.
+	// validation runs in a separate go routine so that we can return as soon as it is cancelled,
+	// even if it is stuck in a call that does not observe ctx
+	result_ch := make(chan *specValidationResult, 1)
+	go func() {
+		sourceBucketUUID, targetBucketUUID, targetClusterRef, errorMap, warningMap := replication_mgr.repl_spec_svc.ValidateNewReplicationSpec(ctx, sourceBucket, targetCluster, targetBucket, settings)
+		result_ch <- &specValidationResult{sourceBucketUUID, targetBucketUUID, targetClusterRef, errorMap, warningMap}
+	}()
+
+	var result *specValidationResult
+	select {
+	case result = <-result_ch:
+	case <-ctx.Done():
+	}
+	if ctx.Err() != nil {
+		return nil, nil, nil, service_def.ValidationCancelledError
+	}
+	if len(result.errorMap) > 0 {
+		return nil, result.errorMap, nil, nil
 	}
 
-	spec := metadata.NewReplicationSpecification(sourceBucket, sourceBucketUUID, targetClusterRef.Uuid, targetBucket, targetBucketUUID)
+	spec := metadata.NewReplicationSpecification(sourceBucket, result.sourceBucketUUID, result.targetClusterRef.Uuid, targetBucket, result.targetBucketUUID)
 
 	replSettings, err := ReplicationSettingsService().GetDefaultReplicationSettings()
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
-	_, errorMap = replSettings.UpdateSettingsFromMap(settings)
+	_, errorMap := replSettings.UpdateSettingsFromMap(settings)
 	if len(errorMap) != 0 {
-		return nil, errorMap, nil
+		return nil, errorMap, nil, nil
 	}
 	spec.Settings = replSettings
 
+	if len(result.warningMap) > 0 {
+		logger_rm.Infof("Warnings from validation of replication specification %s. warningMap=%v\n", spec.Id, result.warningMap)
+	}
+
 	if justValidate {
-		return spec, nil, nil
+		return spec, nil, result.warningMap, nil
 	}
 
 	//persist it
 	err = replication_mgr.repl_spec_svc.AddReplicationSpec(spec)
 	if err == nil {
 		logger_rm.Infof("Success adding replication specification %s\n", spec.Id)
-		return spec, nil, nil
+		return spec, nil, result.warningMap, nil
 	} else {
 		logger_rm.Errorf("Error adding replication specification %s. err=%v\n", spec.Id, err)
-		return spec, nil, err
+		return spec, nil, nil, err
 	}
 }
 
@@ -617,6 +894,11 @@ func GetReplicationInfos() ([]base.ReplicationInfo, error) {
 		replInfo.StatsMap = make(map[string]interface{})
 		replInfo.ErrorList = make([]base.ErrorInfo, 0)
 
+		state, err := ReplicationSpecService().GetReplicationState(replId)
+		if err == nil {
+			replInfo.State = state.String()
+		}
+
 		rep_status, _ := pipeline_manager.ReplicationStatus(replId)
 		if rep_status != nil {
 			// set stats map
@@ -641,6 +923,20 @@ func GetReplicationInfos() ([]base.ReplicationInfo, error) {
 					replInfo.ErrorList = append(replInfo.ErrorList, errInfo)
 				}
 			}
+
+			// isolated vbs are reported as errors so that they can be investigated
+			isolatedVBs := rep_status.IsolatedVBs()
+			if len(isolatedVBs) > 0 {
+				cur_node, err := XDCRCompTopologyService().MyHost()
+				if err != nil {
+					panic("cannot find current host")
+				}
+
+				for vbno, vb_err := range isolatedVBs {
+					err_msg := fmt.Sprintf("%v:vb %v has been isolated. err=%v", cur_node, vbno, vb_err)
+					replInfo.ErrorList = append(replInfo.ErrorList, base.ErrorInfo{time.Now().UnixNano(), err_msg})
+				}
+			}
 		}
 
 		// set maxVBReps stats to 0 when replication has never been run or has been paused to ensure that ns_server gets the correct replication status
@@ -813,6 +1109,7 @@ func cleanup() {
 
 		close(replication_mgr.status_logger_finch)
 		close(replication_mgr.mem_stats_logger_finch)
+		close(replication_mgr.canary_finch)
 
 		logger_rm.Infof("Replication manager exists")
 	} else {
@@ -0,0 +1,70 @@
+// Copyright (c) 2013 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package replication_manager
+
+import (
+	"github.com/couchbase/goxdcr/utils"
+)
+
+// how far back a replication goes as a result of a settings change
+type RewindType string
+
+const (
+	// pipeline keeps running, or stays stopped
+	RewindNone RewindType = "none"
+	// pipeline is (re)started, and resumes from the last checkpoint.
+	// docs replicated after the last checkpoint are replicated again
+	RewindToLastCheckpoint RewindType = "lastCheckpoint"
+)
+
+// consequences of a settings change to a replication, if it were applied
+type SettingsChangePreview struct {
+	// effective settings of the replication after the change
+	Settings map[string]interface{} `json:"settings"`
+	// settings that would be changed, each with its old value followed by its new value
+	ChangedSettings map[string][2]interface{} `json:"changedSettings"`
+	// whether the running pipeline would be stopped, reconstructed and restarted
+	RequiresRestart bool       `json:"requiresRestart"`
+	Rewind          RewindType `json:"rewind"`
+	// validation errors. the change would be rejected if this is not empty
+	Errors map[string]error `json:"-"`
+}
+
+// validates settings changes to a replication, and reports their consequences without persisting them
+func PreviewSettingsChange(replicationId string, changes map[string]interface{}) (*SettingsChangePreview, error) {
+	logger_rm.Infof("Previewing settings change for %v, changes=%v\n", replicationId, changes)
+
+	// ReplicationSpec() returns a copy of the spec, which can be modified safely
+	replSpec, err := ReplicationSpecService().ReplicationSpec(replicationId)
+	if err != nil {
+		return nil, err
+	}
+	oldSettings := replSpec.Settings.Clone()
+
+	_, errorMap := applyReplicationSettingsChange(replSpec, changes)
+	newSettings := replSpec.Settings
+
+	preview := &SettingsChangePreview{Settings: newSettings.ToMap(),
+		ChangedSettings: utils.DiffSettings(oldSettings.ToMap(), newSettings.ToMap()),
+		Rewind:          RewindNone,
+		Errors:          errorMap}
+
+	// mirrors the handling of spec changes in ReplicationSpecChangeListener
+	if oldSettings.Active && newSettings.Active {
+		if needToReconstructPipeline(oldSettings, newSettings) {
+			preview.RequiresRestart = true
+			preview.Rewind = RewindToLastCheckpoint
+		}
+	} else if !oldSettings.Active && newSettings.Active {
+		preview.Rewind = RewindToLastCheckpoint
+	}
+
+	return preview, nil
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2013 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+// registry of in-flight replication validations, which can be cancelled through adminport.
+
+package replication_manager
+
+import (
+	"context"
+	"errors"
+	"github.com/couchbase/goxdcr/simple_utils"
+	"sync"
+	"time"
+)
+
+// length, in bytes, of the random part of validation ids
+const ValidationIdLength = 12
+
+var ValidationNotFoundError = errors.New("Validation with the specified id does not exist or has already finished")
+
+// an in-flight validation of a replication to be created
+type InFlightValidation struct {
+	ValidationId  string    `json:"id"`
+	SourceBucket  string    `json:"fromBucket"`
+	TargetCluster string    `json:"toCluster"`
+	TargetBucket  string    `json:"toBucket"`
+	StartTime     time.Time `json:"startTime"`
+
+	cancel context.CancelFunc
+}
+
+var inflight_validations = make(map[string]*InFlightValidation)
+var inflight_validations_lock sync.RWMutex
+
+// registers a new validation and returns it along with a context that is cancelled when the validation is cancelled
+func registerValidation(sourceBucket, targetCluster, targetBucket string) (*InFlightValidation, context.Context, error) {
+	validationId, err := simple_utils.GenerateRandomId(ValidationIdLength, 5)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	validation := &InFlightValidation{ValidationId: validationId,
+		SourceBucket:  sourceBucket,
+		TargetCluster: targetCluster,
+		TargetBucket:  targetBucket,
+		StartTime:     time.Now(),
+		cancel:        cancel}
+
+	inflight_validations_lock.Lock()
+	defer inflight_validations_lock.Unlock()
+	inflight_validations[validationId] = validation
+
+	logger_rm.Infof("Registered validation %v for sourceBucket=%v, targetCluster=%v, targetBucket=%v\n", validationId, sourceBucket, targetCluster, targetBucket)
+	return validation, ctx, nil
+}
+
+// removes a finished validation from the registry and releases the resources associated with its context
+func unregisterValidation(validationId string) {
+	inflight_validations_lock.Lock()
+	defer inflight_validations_lock.Unlock()
+	validation, ok := inflight_validations[validationId]
+	if ok {
+		delete(inflight_validations, validationId)
+		validation.cancel()
+	}
+}
+
+func GetInFlightValidation(validationId string) (*InFlightValidation, error) {
+	inflight_validations_lock.RLock()
+	defer inflight_validations_lock.RUnlock()
+	validation, ok := inflight_validations[validationId]
+	if !ok {
+		return nil, ValidationNotFoundError
+	}
+	return validation, nil
+}
+
+func InFlightValidations() []*InFlightValidation {
+	inflight_validations_lock.RLock()
+	defer inflight_validations_lock.RUnlock()
+	validations := make([]*InFlightValidation, 0, len(inflight_validations))
+	for _, validation := range inflight_validations {
+		validations = append(validations, validation)
+	}
+	return validations
+}
+
+// cancels an in-flight validation. the create replication request that started the validation
+// returns with service_def.ValidationCancelledError
+func CancelValidation(validationId string) error {
+	inflight_validations_lock.Lock()
+	defer inflight_validations_lock.Unlock()
+	validation, ok := inflight_validations[validationId]
+	if !ok {
+		return ValidationNotFoundError
+	}
+	delete(inflight_validations, validationId)
+	validation.cancel()
+
+	logger_rm.Infof("Cancelled validation %v\n", validationId)
+	return nil
+}
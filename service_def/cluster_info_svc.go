@@ -16,4 +16,7 @@ import (
 type ClusterInfoSvc interface {
 	GetServerVBucketsMap(clusterConnInfoProvider base.ClusterConnectionInfoProvider, Bucket string) (map[string][]uint16, error)
 	IsClusterCompatible(clusterConnInfoProvider base.ClusterConnectionInfoProvider, version []int) (bool, error)
+	// returns the uuid of the bucket from bucket metadata cached by the service, without making rest calls.
+	// returns false when the uuid of the bucket is not cached or has expired
+	GetBucketUUID(clusterConnInfoProvider base.ClusterConnectionInfoProvider, bucketName string) (string, bool)
 }
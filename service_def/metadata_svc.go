@@ -21,6 +21,7 @@ var MetadataNotFoundErr error = errors.New("key not found")
 var ErrorKeyAlreadyExist = errors.New("key being added already exists")
 var ErrorRevisionMismatch = errors.New("revision number does not match")
 var MetaKVFailedAfterMaxTries error = fmt.Errorf("metakv failed for max number of retries = %v", MaxNumOfRetries)
+var MetadataStoreFullError = errors.New("metadata store is out of space. metadata cannot be changed until space is freed up")
 
 // struct for general metadata entry maintained by metadata service
 type MetadataEntry struct {
@@ -29,6 +30,16 @@ type MetadataEntry struct {
 	Rev   interface{}
 }
 
+// statistics of the objects in a metadata cache, e.g., for capacity planning
+type MetadataCacheStats struct {
+	// number of entries with live metadata
+	NumOfLiveEntries int
+	// number of entries whose metadata has been deleted, but which are still kept in the cache
+	NumOfDeletedEntries int
+	// total size (in bytes) of the marshaled metadata of live entries
+	MarshaledSize int
+}
+
 type MetadataSvc interface {
 	Get(key string) ([]byte, interface{}, error)
 	Add(key string, value []byte) error
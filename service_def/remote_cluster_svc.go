@@ -12,24 +12,38 @@ package service_def
 import (
 	"github.com/couchbase/goxdcr/base"
 	"github.com/couchbase/goxdcr/metadata"
+	"net/http"
 )
 
 type RemoteClusterSvc interface {
 	RemoteClusterByRefId(refId string, refresh bool) (*metadata.RemoteClusterReference, error)
 	RemoteClusterByRefName(refName string, refresh bool) (*metadata.RemoteClusterReference, error)
 	RemoteClusterByUuid(uuid string, refresh bool) (*metadata.RemoteClusterReference, error)
+	// returns MetadataNotFoundErr when no reference has the host name, and error when multiple references have it
+	RemoteClusterByHostName(hostName string, refresh bool) (*metadata.RemoteClusterReference, error)
 	ValidateAddRemoteCluster(ref *metadata.RemoteClusterReference) error
-	// skipConnectivityValidation is true when called from migration service
+	// skipConnectivityValidation is true when called from migration service, or when the remote cluster cannot be reached
+	// at the time of the creation, e.g., in air-gapped provisioning
 	AddRemoteCluster(ref *metadata.RemoteClusterReference, skipConnectivityValidation bool) error
 	ValidateSetRemoteCluster(refName string, ref *metadata.RemoteClusterReference) error
-	SetRemoteCluster(refName string, ref *metadata.RemoteClusterReference) error
+	// skipConnectivityValidation is true when the remote cluster cannot be reached at the time of the update, e.g., in air-gapped provisioning
+	SetRemoteCluster(refName string, ref *metadata.RemoteClusterReference, skipConnectivityValidation bool) error
 	ValidateRemoteCluster(ref *metadata.RemoteClusterReference) error
+	// checks that the remote cluster is reachable with the credentials in ref, and returns an error that tells
+	// whether authentication failed, the remote cluster is unreachable, or its version is incompatible
+	ValidateRemoteClusterConnectivity(ref *metadata.RemoteClusterReference) error
 	DelRemoteCluster(refName string) (*metadata.RemoteClusterReference, error)
 	RemoteClusters(refresh bool) (map[string]*metadata.RemoteClusterReference, error)
+	// returns the numbers of reachable and unreachable remote clusters, based on cached connectivity
+	RemoteClusterReachabilityCounts() (int, int)
 
 	// used by auditing and ui logging
 	GetRemoteClusterNameFromClusterUuid(uuid string) string
 
+	// returns a pooled http client for rest calls to the remote cluster, which is shared by all callers so that
+	// connections are reused. callers need to keep the connections alive after rest calls, and must not change the client
+	GetHttpClient(ref *metadata.RemoteClusterReference) (*http.Client, error)
+
 	// Remote cluster service could return two different types of errors:
 	// 1. unexpected internal server error
 	// 2. validation error indicating the remote cluster involved is not valid or does not exist
@@ -10,19 +10,54 @@
 package service_def
 
 import (
+	"context"
+	"errors"
 	"github.com/couchbase/goxdcr/base"
 	"github.com/couchbase/goxdcr/metadata"
+	"time"
 )
 
+var ValidationCancelledError = errors.New("Validation of replication has been cancelled")
+
+// called when a replication spec in cache has been created, updated or deleted.
+// oldSpec is nil when the spec has been created, and newSpec is nil when the spec has been deleted
+type ReplicationSpecChangeCallback func(specId string, oldSpec, newSpec *metadata.ReplicationSpecification)
+
 type ReplicationSpecSvc interface {
 	ReplicationSpec(replicationId string) (*metadata.ReplicationSpecification, error)
+	// whether the replication exists, without constructing an error when it does not
+	HasReplicationSpec(replicationId string) bool
 	AddReplicationSpec(spec *metadata.ReplicationSpecification) error
-	ValidateNewReplicationSpec(sourceBucket, targetCluster, targetBucket string, settings map[string]interface{}) (string, string, *metadata.RemoteClusterReference, map[string]error)
+	// same as AddReplicationSpec, but returns only after the write is visible locally, see implementation for caveats
+	AddReplicationSpecAndWait(spec *metadata.ReplicationSpecification, timeout time.Duration, confirmWithStore bool) error
+	// validates the spec as AddReplicationSpec does, and returns what adding it would do, without persisting it
+	AddReplicationSpecDryRun(spec *metadata.ReplicationSpecification) (*metadata.ReplicationSpecDryRunResult, error)
+	// adds replication specs in bulk, and returns the errors of the specs that have not been added, keyed by spec id
+	BulkAddReplicationSpecs(specs []*metadata.ReplicationSpecification) (map[string]error, error)
+	ValidateNewReplicationSpec(ctx context.Context, sourceBucket, targetCluster, targetBucket string, settings map[string]interface{}) (string, string, *metadata.RemoteClusterReference, map[string]error, map[string]error)
 	SetReplicationSpec(spec *metadata.ReplicationSpecification) error
+	// applies mutate to the settings of the spec and persists the spec with cas, retrying once on revision mismatch.
+	// returns the settings of the persisted spec
+	UpdateReplicationSpecSettings(replicationId string, mutate func(settings map[string]interface{}) error) (map[string]interface{}, error)
+	// pause or resume all replications from the source bucket. return the result of each replication, keyed by replication id
+	PauseAllReplicationsForBucket(sourceBucket string) (map[string]error, error)
+	ResumeAllReplicationsForBucket(sourceBucket string) (map[string]error, error)
 	DelReplicationSpec(replicationId string) (*metadata.ReplicationSpecification, error)
 	AllReplicationSpecs() (map[string]*metadata.ReplicationSpecification, error)
 	AllReplicationSpecIds() ([]string, error)
+	// same as AllReplicationSpecIds, except that ids are in lexicographic order
+	AllReplicationSpecIdsSorted() []string
 	AllReplicationSpecIdsForBucket(bucket string) ([]string, error)
+	// ids of replications to the remote cluster, and to the bucket on the remote cluster, respectively
+	AllReplicationSpecIdsForTargetCluster(targetClusterUUID string) ([]string, error)
+	AllReplicationSpecIdsForTargetBucket(targetClusterUUID, targetBucket string) ([]string, error)
+	// partition of AllReplicationSpecs by the Active setting of specs
+	AllActiveReplicationSpecs() (map[string]*metadata.ReplicationSpecification, error)
+	AllPausedReplicationSpecs() (map[string]*metadata.ReplicationSpecification, error)
+	// whether the replication has not been paused
+	IsSpecActive(replicationId string) (bool, error)
+	// revision of the replication spec in cache, to be used as the cas of a subsequent SetReplicationSpec
+	GetReplicationSpecRevision(replicationId string) (interface{}, error)
 
 	// checks if an error returned by the replication spec service is an internal server error or a validation error,
 	// e.g., an error indicating the replication spec involved should exist but does not, or the other way around
@@ -31,11 +66,52 @@ type ReplicationSpecSvc interface {
 
 	// Service call back function for replication spec changed event
 	ReplicationSpecServiceCallback(path string, value []byte, rev interface{}) error
+	// ids of specs whose latest value from metakv could not be unmarshaled. they are re-fetched when accessed
+	GetCorruptedSpecIds() []string
+	// reconciles the cache with metadata store, touching only specs that have changed.
+	// returns the numbers of specs added to, updated in and removed from the cache
+	RefreshCache() (int, int, int, error)
 
 	ValidateAndGC(spec *metadata.ReplicationSpecification)
+	// validates the specs and garbage collects the invalid ones, resolving each remote cluster reference only once.
+	// returns the specs that have been garbage collected and why, and the specs that could not be validated or garbage collected
+	ValidateAndGCBatch(specs []*metadata.ReplicationSpecification) *metadata.ReplicationSpecGCSummary
+	// validates the specs like ValidateAndGCBatch, but only reports the invalid ones instead of garbage collecting them
+	ValidateBatch(specs []*metadata.ReplicationSpecification) *metadata.ReplicationSpecGCSummary
+	// removes the cached compatibility of the target cluster used by validation, e.g., when its remote cluster reference has changed
+	InvalidateClusterCompatibility(clusterUUID string)
+	// registers a filter expression under name, which new replications can reference through their filter name setting
+	RegisterNamedFilter(name, filterExpression string) error
+	// returns the expression of the filter registered under name
+	GetNamedFilter(name string) (string, error)
+	// returns the expressions of all registered filters, keyed by filter name
+	AllNamedFilters() (map[string]string, error)
+	// suspends garbage collection of invalid specs by ValidateAndGC, e.g., during maintenance
+	SuspendGC(duration time.Duration) error
+	ResumeGC()
+	// whether garbage collection is suspended, and the remaining time of the suspension
+	GCSuspensionStatus() (bool, time.Duration)
+
+	// limits the number of writes to metadata store per second. 0 removes the limit
+	SetMetadataWriteRate(opsPerSec int)
+
+	// returns the settings changes of the replication made through the local node, oldest first
+	GetSettingsHistory(replicationId string) ([]metadata.SettingsChange, error)
+
+	// returns the bytes of the replication spec as stored in metadata service and its revision, without deserializing them
+	GetSpecRaw(replicationId string) ([]byte, interface{}, error)
+
+	// whether the last write to metadata store failed because metadata store is out of space
+	IsMetadataStoreFull() bool
+
+	// returns the number of cached specs, including specs that have been deleted but are still cached
+	// for their derived objects, and the memory they consume
+	GetCacheStats() *MetadataCacheStats
 
 	// being used by unit tests only
 	ConstructNewReplicationSpec(sourceBucketName, targetClusterUUID, targetBucketName string) (*metadata.ReplicationSpecification, error)
+	// same as ConstructNewReplicationSpec, and merges the validated settings into the default settings of the new spec
+	ConstructNewReplicationSpecWithSettings(sourceBucketName, targetClusterUUID, targetBucketName string, settings map[string]interface{}) (*metadata.ReplicationSpecification, error)
 
 	//get the derived object (i.e. ReplicationStatus) for the specification
 	//this is used to keep the derived object and replication spec in the same cache
@@ -44,8 +120,21 @@ type ReplicationSpecSvc interface {
 	//set the derived object (i.e ReplicationStatus) for the specification
 	SetDerivedObj(specId string, derivedObj interface{}) error
 
+	// set the derived object for the specification, and persist it with marshal in metadata service, so that it
+	// can be restored when the cache is initialized after restart. nil derivedObj removes the persisted record
+	SetDerivedObjWithPersist(specId string, derivedObj interface{}, marshal func(interface{}) ([]byte, error)) error
+
+	// lifecycle state of the replication, which is kept in the same cache as the specification
+	// SetReplicationState returns error when the transition from the current state is not allowed
+	SetReplicationState(replicationId string, state metadata.ReplicationState) error
+	GetReplicationState(replicationId string) (metadata.ReplicationState, error)
+
 	// set the metadata change call back method
 	// when the replication spec service makes changes, it needs to call the call back
 	// explicitly, so that the actions can be taken immediately
 	SetMetadataChangeHandlerCallback(callBack base.MetadataChangeHandlerCallback)
+
+	// registers a callback for changes to replication specs, including changes made on other nodes,
+	// so that consumers do not need to poll AllReplicationSpecs. the returned function unsubscribes the callback
+	Subscribe(callback ReplicationSpecChangeCallback) (unsubscribe func())
 }
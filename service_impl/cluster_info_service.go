@@ -15,15 +15,30 @@ import (
 	"github.com/couchbase/goxdcr/log"
 	"github.com/couchbase/goxdcr/utils"
 	"reflect"
+	"sync"
+	"time"
 )
 
+// how long a bucket uuid retrieved from bucket info stays valid in cache.
+// a bucket could be deleted and re-created with the same name, so uuids cannot be cached for too long
+var BucketUUIDCacheExpiry = 1 * time.Minute
+
+type cachedBucketUUID struct {
+	uuid       string
+	cachedTime time.Time
+}
+
 type ClusterInfoSvc struct {
 	logger *log.CommonLogger
+	// bucket uuids retrieved from bucket info, keyed by connection string and bucket name
+	bucket_uuids      map[string]*cachedBucketUUID
+	bucket_uuids_lock sync.RWMutex
 }
 
 func NewClusterInfoSvc(logger_ctx *log.LoggerContext) *ClusterInfoSvc {
 	return &ClusterInfoSvc{
-		logger: log.NewLogger("ClusterInfoService", logger_ctx),
+		logger:       log.NewLogger("ClusterInfoService", logger_ctx),
+		bucket_uuids: make(map[string]*cachedBucketUUID),
 	}
 }
 
@@ -42,10 +57,43 @@ func (ci_svc *ClusterInfoSvc) GetServerVBucketsMap(clusterConnInfoProvider base.
 		return nil, err
 	}
 
+	ci_svc.cacheBucketUUID(connStr, bucketName, bucketInfo)
+
 	return utils.GetServerVBucketsMap(connStr, bucketName, bucketInfo)
 
 }
 
+func (ci_svc *ClusterInfoSvc) GetBucketUUID(clusterConnInfoProvider base.ClusterConnectionInfoProvider, bucketName string) (string, bool) {
+	connStr, err := clusterConnInfoProvider.MyConnectionStr()
+	if err != nil {
+		return "", false
+	}
+
+	ci_svc.bucket_uuids_lock.RLock()
+	defer ci_svc.bucket_uuids_lock.RUnlock()
+	cachedUUID, ok := ci_svc.bucket_uuids[bucketUUIDCacheKey(connStr, bucketName)]
+	if !ok || time.Since(cachedUUID.cachedTime) > BucketUUIDCacheExpiry {
+		return "", false
+	}
+	return cachedUUID.uuid, true
+}
+
+func (ci_svc *ClusterInfoSvc) cacheBucketUUID(connStr, bucketName string, bucketInfo map[string]interface{}) {
+	bucketUUID, err := utils.GetBucketUuidFromBucketInfo(bucketName, bucketInfo, ci_svc.logger)
+	if err != nil {
+		return
+	}
+
+	ci_svc.bucket_uuids_lock.Lock()
+	defer ci_svc.bucket_uuids_lock.Unlock()
+	ci_svc.bucket_uuids[bucketUUIDCacheKey(connStr, bucketName)] = &cachedBucketUUID{uuid: bucketUUID,
+		cachedTime: time.Now()}
+}
+
+func bucketUUIDCacheKey(connStr, bucketName string) string {
+	return connStr + base.KeyPartsDelimiter + bucketName
+}
+
 func (ci_svc *ClusterInfoSvc) IsClusterCompatible(clusterConnInfoProvider base.ClusterConnectionInfoProvider, version []int) (bool, error) {
 
 	connStr, err := clusterConnInfoProvider.MyConnectionStr()
@@ -350,7 +350,7 @@ func (service *MigrationSvc) migrateRemoteCluster(remoteClusterData interface{},
 		return deletedRemoteClusterUuidList, fatalErrorList, mildErrorList
 	}
 
-	service.logger.Infof("Remote cluster constructed = %v\n", ref)
+	service.logger.Infof("Remote cluster constructed = %v\n", ref.Redacted())
 
 	// delete remote cluster if it already exists
 	_, err = service.remote_cluster_svc.DelRemoteCluster(name)
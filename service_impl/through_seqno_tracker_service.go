@@ -41,6 +41,10 @@ type ThroughSeqnoTrackerSvc struct {
 	vb_filtered_seqno_list_map map[uint16]*SortedSeqnoListWithLock
 	// stores for each vb a sorted list of seqnos that have failed conflict resolution on source
 	vb_failed_cr_seqno_list_map map[uint16]*SortedSeqnoListWithLock
+	// stores for each vb a list of seqnos that have been dropped by target nozzles. the list is merged with filtered seqnos
+	// in through_seqno computation. it is kept apart since drops are delivered through listeners other than those for
+	// filtered seqnos, and hence are not ordered with them. like sent seqnos, the list is sorted before it is used
+	vb_dropped_seqno_list_map map[uint16]*SortedSeqnoListWithLock
 
 	// gap_seqno_list_1[i] stores the start seqno of the ith gap range
 	// gap_seqno_list_2[i] stores the end seqno of  the ith gap range
@@ -168,6 +172,7 @@ func NewThroughSeqnoTrackerSvc(logger_ctx *log.LoggerContext) *ThroughSeqnoTrack
 		vb_sent_seqno_list_map:      make(map[uint16]*SortedSeqnoListWithLock),
 		vb_filtered_seqno_list_map:  make(map[uint16]*SortedSeqnoListWithLock),
 		vb_failed_cr_seqno_list_map: make(map[uint16]*SortedSeqnoListWithLock),
+		vb_dropped_seqno_list_map:   make(map[uint16]*SortedSeqnoListWithLock),
 		vb_gap_seqno_list_map:       make(map[uint16]*DualSortedSeqnoListWithLock),
 	}
 	return tsTracker
@@ -185,6 +190,7 @@ func (tsTracker *ThroughSeqnoTrackerSvc) initialize(pipeline common.Pipeline) {
 		tsTracker.vb_sent_seqno_list_map[vbno] = newSortedSeqnoListWithLock()
 		tsTracker.vb_filtered_seqno_list_map[vbno] = newSortedSeqnoListWithLock()
 		tsTracker.vb_failed_cr_seqno_list_map[vbno] = newSortedSeqnoListWithLock()
+		tsTracker.vb_dropped_seqno_list_map[vbno] = newSortedSeqnoListWithLock()
 		tsTracker.vb_gap_seqno_list_map[vbno] = newDualSortedSeqnoListWithLock()
 	}
 }
@@ -199,6 +205,7 @@ func (tsTracker *ThroughSeqnoTrackerSvc) Attach(pipeline common.Pipeline) error
 	pipeline_utils.RegisterAsyncComponentEventHandler(asyncListenerMap, base.DataSentEventListener, tsTracker)
 	pipeline_utils.RegisterAsyncComponentEventHandler(asyncListenerMap, base.DataFailedCREventListener, tsTracker)
 	pipeline_utils.RegisterAsyncComponentEventHandler(asyncListenerMap, base.DataFilteredEventListener, tsTracker)
+	pipeline_utils.RegisterAsyncComponentEventHandler(asyncListenerMap, base.DataDroppedEventListener, tsTracker)
 	pipeline_utils.RegisterAsyncComponentEventHandler(asyncListenerMap, base.DataReceivedEventListener, tsTracker)
 	return nil
 }
@@ -216,7 +223,11 @@ func (tsTracker *ThroughSeqnoTrackerSvc) ProcessEvent(event *common.Event) error
 		upr_event := event.Data.(*mcc.UprEvent)
 		seqno := upr_event.Seqno
 		vbno := upr_event.VBucket
-		tsTracker.addFilteredSeqno(vbno, seqno)
+		if _, ok := event.Component.(*parts.XmemNozzle); ok {
+			tsTracker.addDroppedSeqno(vbno, seqno)
+		} else {
+			tsTracker.addFilteredSeqno(vbno, seqno)
+		}
 	} else if event.EventType == common.DataFailedCRSource {
 		seqno := event.OtherInfos.(parts.DataFailedCRSourceEventAdditional).Seqno
 		vbno := event.OtherInfos.(parts.DataFailedCRSourceEventAdditional).VBucket
@@ -246,6 +257,12 @@ func (tsTracker *ThroughSeqnoTrackerSvc) addFilteredSeqno(vbno uint16, filtered_
 	tsTracker.vb_filtered_seqno_list_map[vbno].appendSeqno(filtered_seqno, tsTracker.logger)
 }
 
+func (tsTracker *ThroughSeqnoTrackerSvc) addDroppedSeqno(vbno uint16, dropped_seqno uint64) {
+	tsTracker.validateVbno(vbno, "addDroppedSeqno")
+	tsTracker.logger.Tracef("%v adding dropped seqno %v for vb %v.", tsTracker.id, dropped_seqno, vbno)
+	tsTracker.vb_dropped_seqno_list_map[vbno].appendSeqno(dropped_seqno, tsTracker.logger)
+}
+
 func (tsTracker *ThroughSeqnoTrackerSvc) addFailedCRSeqno(vbno uint16, failed_cr_seqno uint64) {
 	tsTracker.validateVbno(vbno, "addFailedCRSeqno")
 
@@ -278,6 +295,7 @@ func (tsTracker *ThroughSeqnoTrackerSvc) truncateSeqnoLists(vbno uint16, through
 	tsTracker.vb_sent_seqno_list_map[vbno].truncateSeqnos(through_seqno)
 	tsTracker.vb_filtered_seqno_list_map[vbno].truncateSeqnos(through_seqno)
 	tsTracker.vb_failed_cr_seqno_list_map[vbno].truncateSeqnos(through_seqno)
+	tsTracker.vb_dropped_seqno_list_map[vbno].truncateSeqnos(through_seqno)
 	tsTracker.vb_gap_seqno_list_map[vbno].truncateSeqnos(through_seqno)
 }
 
@@ -294,7 +312,9 @@ func (tsTracker *ThroughSeqnoTrackerSvc) GetThroughSeqno(vbno uint16) uint64 {
 	last_through_seqno := through_seqno_obj.GetSeqnoWithoutLock()
 	sent_seqno_list := tsTracker.vb_sent_seqno_list_map[vbno].getSortedSeqnoList(true)
 	max_sent_seqno := maxSeqno(sent_seqno_list)
-	filtered_seqno_list := tsTracker.vb_filtered_seqno_list_map[vbno].getSortedSeqnoList(false)
+	// dropped seqnos are treated as filtered ones
+	filtered_seqno_list := mergeSortedSeqnoLists(tsTracker.vb_filtered_seqno_list_map[vbno].getSortedSeqnoList(false),
+		tsTracker.vb_dropped_seqno_list_map[vbno].getSortedSeqnoList(true))
 	max_filtered_seqno := maxSeqno(filtered_seqno_list)
 	failed_cr_seqno_list := tsTracker.vb_failed_cr_seqno_list_map[vbno].getSortedSeqnoList(false)
 	max_failed_cr_seqno := maxSeqno(failed_cr_seqno_list)
@@ -388,6 +408,30 @@ func (tsTracker *ThroughSeqnoTrackerSvc) GetThroughSeqno(vbno uint16) uint64 {
 	return through_seqno
 }
 
+// returns a sorted list containing the seqnos in both of the sorted lists
+func mergeSortedSeqnoLists(seqno_list_1, seqno_list_2 []uint64) []uint64 {
+	if len(seqno_list_2) == 0 {
+		return seqno_list_1
+	}
+	if len(seqno_list_1) == 0 {
+		return seqno_list_2
+	}
+
+	merged_list := make([]uint64, 0, len(seqno_list_1)+len(seqno_list_2))
+	index_1, index_2 := 0, 0
+	for index_1 < len(seqno_list_1) && index_2 < len(seqno_list_2) {
+		if seqno_list_1[index_1] <= seqno_list_2[index_2] {
+			merged_list = append(merged_list, seqno_list_1[index_1])
+			index_1++
+		} else {
+			merged_list = append(merged_list, seqno_list_2[index_2])
+			index_2++
+		}
+	}
+	merged_list = append(merged_list, seqno_list_1[index_1:]...)
+	return append(merged_list, seqno_list_2[index_2:]...)
+}
+
 func isSeqnoGapSeqno(gap_seqno_list_1, gap_seqno_list_2 []uint64, seqno uint64) bool {
 	if len(gap_seqno_list_1) == 0 {
 		return false
@@ -22,6 +22,7 @@ import (
 	mrand "math/rand"
 	"reflect"
 	"sort"
+	"strings"
 	"time"
 )
 
@@ -324,6 +325,32 @@ func GenerateRandomId(length, maxRetry int) (string, error) {
 
 }
 
+// replaces credentials in logged structures and connection strings
+const RedactedValue = "*****"
+
+// replaces the password in a connection string of the form [scheme://][username[:password]@]host[:port][/path]
+// with asterisks, so that the connection string can be logged or included in error messages
+func RedactConnectionStr(connStr string) string {
+	atIndex := strings.LastIndex(connStr, "@")
+	if atIndex < 0 {
+		return connStr
+	}
+
+	userInfoStart := 0
+	if schemeIndex := strings.Index(connStr, "://"); schemeIndex >= 0 && schemeIndex < atIndex {
+		userInfoStart = schemeIndex + len("://")
+	}
+
+	userInfo := connStr[userInfoStart:atIndex]
+	colonIndex := strings.Index(userInfo, ":")
+	if colonIndex < 0 {
+		// no password in connection string
+		return connStr
+	}
+
+	return connStr[:userInfoStart] + userInfo[:colonIndex+1] + RedactedValue + connStr[atIndex:]
+}
+
 // translate conflict resolution type bucket metadata into base.ConflictResolutionMode
 func GetCRModeFromConflictResolutionTypeSetting(conflictResolutionType string) base.ConflictResolutionMode {
 	if conflictResolutionType == base.ConflictResolutionType_Lww {
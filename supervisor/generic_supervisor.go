@@ -18,6 +18,7 @@ import (
 	"github.com/couchbase/goxdcr/log"
 	"github.com/couchbase/goxdcr/utils"
 	"reflect"
+	"sort"
 	"sync"
 	"time"
 )
@@ -34,16 +35,74 @@ const (
 	HEARTBEAT_RESP_CHECK_INTERVAL = "heartbeat_resp_resp_check_interval"
 	// child is considered to be broken if it had missed this number of heart beats consecutively
 	MISSED_HEARTBEAT_THRESHOLD = "missed_heartbeat_threshold"
+	// how the failure of some children is acted on, as in FailureIsolationPolicy
+	FAILURE_ISOLATION_POLICY = "failure_isolation_policy"
+	// whether children are stopped when the supervisor is stopped, in the order of their StopDependencies.
+	// off by default, e.g., for pipeline supervisors, whose children are parts that are stopped by the pipeline
+	STOP_CHILDREN = "stop_children"
 
 	default_heartbeat_interval            time.Duration = 1000 * time.Millisecond
 	default_heartbeat_resp_check_interval time.Duration = 500 * time.Millisecond
 	default_heartbeat_timeout             time.Duration = 4000 * time.Millisecond
 	default_missed_heartbeat_threshold                  = 5
+
+	// heart beat latencies observed within this period are used to compute latency percentiles
+	heartbeat_latency_window time.Duration = 5 * time.Minute
+	// number of slots that the window is divided into. the window slides by one slot at a time
+	heartbeat_latency_window_slots = 10
 )
 
+// upper bounds of the buckets of heart beat latency histograms. latencies above the last bound fall into an overflow bucket
+var heartbeat_latency_bucket_bounds = []time.Duration{time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 20 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond,
+	500 * time.Millisecond, time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second}
+
 var supervisor_setting_defs base.SettingDefinitions = base.SettingDefinitions{HEARTBEAT_TIMEOUT: base.NewSettingDef(reflect.TypeOf((*time.Duration)(nil)), false),
 	HEARTBEAT_INTERVAL:         base.NewSettingDef(reflect.TypeOf((*time.Duration)(nil)), false),
-	MISSED_HEARTBEAT_THRESHOLD: base.NewSettingDef(reflect.TypeOf((*uint16)(nil)), false)}
+	MISSED_HEARTBEAT_THRESHOLD: base.NewSettingDef(reflect.TypeOf((*uint16)(nil)), false),
+	FAILURE_ISOLATION_POLICY:   base.NewSettingDef(reflect.TypeOf((*string)(nil)), false),
+	STOP_CHILDREN:              base.NewSettingDef(reflect.TypeOf((*bool)(nil)), false)}
+
+// describes the settings of GenericSupervisor, including their types and default values, sorted by name
+func SettingsSchema() []*base.SettingSchema {
+	return supervisor_setting_defs.Schema(map[string]interface{}{HEARTBEAT_INTERVAL: default_heartbeat_interval,
+		HEARTBEAT_TIMEOUT:          default_heartbeat_timeout,
+		MISSED_HEARTBEAT_THRESHOLD: uint16(default_missed_heartbeat_threshold),
+		FAILURE_ISOLATION_POLICY:   string(FailureIsolationIsolate)})
+}
+
+// how a supervisor acts on the failure of some of its children
+type FailureIsolationPolicy string
+
+const (
+	// only the failed children are reported to the failure handler, so that their siblings are left alone
+	FailureIsolationIsolate FailureIsolationPolicy = "isolate"
+	// the siblings of the failed children are reported along with them, so that the failure handler acts on all children
+	FailureIsolationCascade FailureIsolationPolicy = "cascade"
+)
+
+// error reported for children that have not failed themselves, but are affected by the failure of their siblings
+// under cascade policy
+type SiblingFailureError struct {
+	FailedChildren []string
+}
+
+func (err *SiblingFailureError) Error() string {
+	return fmt.Sprintf("Affected by the failure of sibling(s) %v", err.FailedChildren)
+}
+
+// failure that a supervisor has reported to its failure handler
+type FailureReport struct {
+	Policy FailureIsolationPolicy
+	// ids of children that failed, sorted
+	FailedChildren []string
+	// ids of children that the failure handler was asked to act on, sorted. they are the failed children under
+	// isolate policy, and all children under cascade policy
+	AffectedChildren []string
+	// errors of affected children, as passed to the failure handler
+	Errors map[string]error
+	Time   time.Time
+}
 
 type heartbeatRespStatus int
 
@@ -54,6 +113,143 @@ const (
 	respondedNotOk  heartbeatRespStatus = iota
 )
 
+// heart beat health of a child, for diagnosing children that are slow to respond before they are reported as broken
+type ChildHealth struct {
+	// time taken by the child to respond to the last heart beat it has responded to.
+	// it is accurate up to heartbeat_resp_check_interval
+	LastLatency time.Duration
+	// number of heart beats missed consecutively. the child is reported as broken when it exceeds missed_heartbeat_threshold
+	ConsecutiveMisses uint16
+	// time when the last heart beat that the child has responded to was sent. zero if the child has never responded
+	LastBeatTime time.Time
+}
+
+// health of a node in a supervision tree, i.e., a supervisor or a child of a supervisor
+type HealthReport struct {
+	Id string
+	// heart beat health of the node as seen by its parent supervisor. nil for the root of the tree
+	Health *ChildHealth
+	// false when the node has exceeded the missed_heartbeat_threshold of its parent supervisor, or when any node below it is unhealthy
+	Healthy bool
+	// reports of the children of the node, keyed by child id. nil when the node is not a supervisor
+	Children map[string]*HealthReport
+	// true when the node is a supervisor that already appears above it in the tree. its children are not reported again
+	Cycle bool
+}
+
+// percentiles of heart beat round-trip latencies of children over the sliding window, for tuning heartbeat_timeout
+// and missed_heartbeat_threshold. percentiles are upper bounds of histogram buckets, capped at the max latency
+// observed. latencies are accurate up to heartbeat_resp_check_interval
+type HeartbeatLatencyPercentiles struct {
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+	// number of heart beat responses that the percentiles are computed from
+	Count uint64
+}
+
+// histogram of a slot of the sliding window
+type heartbeatLatencySlot struct {
+	// start time of the slot. the slot is reset when it is reused for a later period
+	start  time.Time
+	counts []uint64
+	max    time.Duration
+}
+
+// histograms of heart beat latencies over a sliding window. it is safe for concurrent use
+type heartbeatLatencyHistogram struct {
+	slots         []heartbeatLatencySlot
+	slot_duration time.Duration
+	lock          sync.Mutex
+}
+
+func newHeartbeatLatencyHistogram(window time.Duration, num_of_slots int) *heartbeatLatencyHistogram {
+	histogram := &heartbeatLatencyHistogram{slots: make([]heartbeatLatencySlot, num_of_slots),
+		slot_duration: window / time.Duration(num_of_slots)}
+	for i := range histogram.slots {
+		histogram.slots[i].counts = make([]uint64, len(heartbeat_latency_bucket_bounds)+1)
+	}
+	return histogram
+}
+
+func (histogram *heartbeatLatencyHistogram) record(latency time.Duration, now time.Time) {
+	histogram.lock.Lock()
+	defer histogram.lock.Unlock()
+
+	start := now.Truncate(histogram.slot_duration)
+	slot := &histogram.slots[(start.UnixNano()/int64(histogram.slot_duration))%int64(len(histogram.slots))]
+	if !slot.start.Equal(start) {
+		// the slot holds latencies of an earlier period, which has slid out of the window
+		slot.start = start
+		for i := range slot.counts {
+			slot.counts[i] = 0
+		}
+		slot.max = 0
+	}
+
+	bucket := sort.Search(len(heartbeat_latency_bucket_bounds), func(i int) bool {
+		return latency <= heartbeat_latency_bucket_bounds[i]
+	})
+	slot.counts[bucket]++
+	if latency > slot.max {
+		slot.max = latency
+	}
+}
+
+func (histogram *heartbeatLatencyHistogram) percentiles(now time.Time) *HeartbeatLatencyPercentiles {
+	histogram.lock.Lock()
+	defer histogram.lock.Unlock()
+
+	// slots that started before the oldest slot in the window are stale
+	oldest_start := now.Truncate(histogram.slot_duration).Add(-histogram.slot_duration * time.Duration(len(histogram.slots)-1))
+	counts := make([]uint64, len(heartbeat_latency_bucket_bounds)+1)
+	var max time.Duration
+	result := &HeartbeatLatencyPercentiles{}
+	for _, slot := range histogram.slots {
+		if slot.start.Before(oldest_start) {
+			continue
+		}
+		for i, count := range slot.counts {
+			counts[i] += count
+			result.Count += count
+		}
+		if slot.max > max {
+			max = slot.max
+		}
+	}
+	if result.Count == 0 {
+		return result
+	}
+
+	percentile := func(p uint64) time.Duration {
+		// rank of the percentile among the latencies, rounded up
+		rank := (result.Count*p + 99) / 100
+		var cumulative uint64
+		for i, count := range counts {
+			cumulative += count
+			if cumulative >= rank && i < len(heartbeat_latency_bucket_bounds) && heartbeat_latency_bucket_bounds[i] < max {
+				return heartbeat_latency_bucket_bounds[i]
+			} else if cumulative >= rank {
+				// no latency is above max
+				return max
+			}
+		}
+		return max
+	}
+	result.P50 = percentile(50)
+	result.P90 = percentile(90)
+	result.P99 = percentile(99)
+	return result
+}
+
+// Lock ordering in GenericSupervisor:
+// children_lock protects children, childrenHealthMap, last_report_time and last_failure_report, and is always the innermost lock.
+// settings_lock protects heart beat settings, failure_isolation_policy and heartbeat_ticker, which can be updated while the supervisor is running.
+// settings_lock is never held together with children_lock.
+// children_lock, and likewise settings_lock, must not be held while calling out of the supervisor, i.e., when sending
+// heart beats to children, when calling the failure handler, or when calling into the parent supervisor, since all of these
+// may call back into the supervisor, e.g., through RemoveChild or Child, and deadlock.
+// Callers take a snapshot of what they need while holding the lock, release it, and then act on the snapshot.
 type GenericSupervisor struct {
 	id string
 	gen_server.GenServer
@@ -64,14 +260,28 @@ type GenericSupervisor struct {
 	heartbeat_interval            time.Duration
 	heartbeat_resp_check_interval time.Duration
 	missed_heartbeat_threshold    uint16
-	// key - child Id; value - number of consecutive heart beat misses
-	childrenBeatMissedMap map[string]uint16
-	heartbeat_ticker      *time.Ticker
-	failure_handler       common.SupervisorFailureHandler
-	finch                 chan bool
-	childrenWaitGrp       sync.WaitGroup
-	err_ch                chan bool
-	parent_supervisor     *GenericSupervisor
+	// key - child Id; value - heart beat health, including number of consecutive heart beat misses
+	childrenHealthMap map[string]*ChildHealth
+	// time when heart beat report was last processed. zero if no report has been processed
+	last_report_time  time.Time
+	heartbeat_ticker  *time.Ticker
+	failure_handler   common.SupervisorFailureHandler
+	finch             chan bool
+	childrenWaitGrp   sync.WaitGroup
+	err_ch            chan bool
+	parent_supervisor *GenericSupervisor
+	// set when heartbeat_ticker has been stopped through StopHeartBeatTicker, after which it is not reset
+	heartbeat_ticker_stopped bool
+	settings_lock            sync.RWMutex
+	// signals the supervising routine to reset heartbeat_ticker after heartbeat_interval has been changed
+	heartbeat_interval_change_ch chan bool
+	// round-trip latencies of heart beats that children have responded to. it has its own lock
+	heartbeat_latency_histogram *heartbeatLatencyHistogram
+	failure_isolation_policy    FailureIsolationPolicy
+	// the last failure that has been reported to failure_handler. nil if no failure has been reported
+	last_failure_report *FailureReport
+	// whether children are stopped in Stop
+	stop_children bool
 }
 
 func NewGenericSupervisor(id string, logger_ctx *log.LoggerContext, failure_handler common.SupervisorFailureHandler, parent_supervisor *GenericSupervisor) *GenericSupervisor {
@@ -85,7 +295,10 @@ func NewGenericSupervisor(id string, logger_ctx *log.LoggerContext, failure_hand
 		heartbeat_interval:            default_heartbeat_interval,
 		heartbeat_resp_check_interval: default_heartbeat_resp_check_interval,
 		missed_heartbeat_threshold:    default_missed_heartbeat_threshold,
-		childrenBeatMissedMap:         make(map[string]uint16, 0),
+		childrenHealthMap:             make(map[string]*ChildHealth, 0),
+		heartbeat_interval_change_ch:  make(chan bool, 1),
+		heartbeat_latency_histogram:   newHeartbeatLatencyHistogram(heartbeat_latency_window, heartbeat_latency_window_slots),
+		failure_isolation_policy:      FailureIsolationIsolate,
 		failure_handler:               failure_handler,
 		finch:                         make(chan bool, 1),
 		childrenWaitGrp:               sync.WaitGroup{},
@@ -112,7 +325,7 @@ func (supervisor *GenericSupervisor) AddChild(child common.Supervisable) error {
 	supervisor.children_lock.Lock()
 	defer supervisor.children_lock.Unlock()
 	supervisor.children[child.Id()] = child
-	supervisor.childrenBeatMissedMap[child.Id()] = 0
+	supervisor.childrenHealthMap[child.Id()] = &ChildHealth{}
 	return nil
 }
 
@@ -128,7 +341,7 @@ func (supervisor *GenericSupervisor) removeChild_internal(childId string, lock b
 	}
 	// TODO should we return error when childId does not exist?
 	delete(supervisor.children, childId)
-	delete(supervisor.childrenBeatMissedMap, childId)
+	delete(supervisor.childrenHealthMap, childId)
 	return nil
 }
 
@@ -142,13 +355,27 @@ func (supervisor *GenericSupervisor) Child(childId string) (common.Supervisable,
 	}
 }
 
+// returns a copy of the heart beat health of the child
+func (supervisor *GenericSupervisor) GetChildHealth(childId string) (*ChildHealth, error) {
+	supervisor.children_lock.RLock()
+	defer supervisor.children_lock.RUnlock()
+	if health, ok := supervisor.childrenHealthMap[childId]; ok {
+		healthCopy := *health
+		return &healthCopy, nil
+	} else {
+		return nil, errors.New(fmt.Sprintf("Cannot find child %v of supervisor %v\n", childId, supervisor.Id()))
+	}
+}
+
 func (supervisor *GenericSupervisor) Start(settings map[string]interface{}) error {
 	supervisor.Logger().Infof("Starting supervisor %v.\n", supervisor.Id())
 
 	err := supervisor.Init(settings)
 	if err == nil {
 		//start heartbeat ticker
+		supervisor.settings_lock.Lock()
 		supervisor.heartbeat_ticker = time.NewTicker(supervisor.heartbeat_interval)
+		supervisor.settings_lock.Unlock()
 
 		supervisor.childrenWaitGrp.Add(1)
 		go supervisor.supervising()
@@ -172,13 +399,18 @@ func (supervisor *GenericSupervisor) Stop() error {
 	// stop gen_server
 	err := supervisor.Stop_server()
 
-	if supervisor.heartbeat_ticker != nil {
-		supervisor.heartbeat_ticker.Stop()
-	}
+	supervisor.StopHeartBeatTicker()
 
 	supervisor.Logger().Debug("Wait for children goroutines to exit")
 	supervisor.childrenWaitGrp.Wait()
 
+	supervisor.settings_lock.RLock()
+	stopChildren := supervisor.stop_children
+	supervisor.settings_lock.RUnlock()
+	if stopChildren {
+		supervisor.stopChildren()
+	}
+
 	supervisor.Logger().Infof("Stopped supervisor %v.\n", supervisor.Id())
 
 	if supervisor.parent_supervisor != nil {
@@ -187,6 +419,143 @@ func (supervisor *GenericSupervisor) Stop() error {
 	return err
 }
 
+// children that can be stopped
+type stoppable interface {
+	Stop() error
+}
+
+// stops children in the order of their StopDependencies, without holding children_lock, since children that are
+// supervisors remove themselves from the supervisor when they are stopped. errors of children are logged only
+func (supervisor *GenericSupervisor) stopChildren() {
+	children := supervisor.childrenSnapshot()
+	for _, childId := range supervisor.childrenStopOrder(children) {
+		child, ok := children[childId].(stoppable)
+		if !ok {
+			continue
+		}
+		supervisor.Logger().Infof("Stopping child %v of supervisor %v\n", childId, supervisor.Id())
+		if err := child.Stop(); err != nil {
+			supervisor.Logger().Errorf("Child %v of supervisor %v failed to stop. err=%v\n", childId, supervisor.Id(), err)
+		}
+	}
+}
+
+// returns the ids of children in an order where each child comes after its StopDependencies. children without
+// dependencies between them are ordered by id. dependencies on ids that are not children are ignored. children in
+// dependency cycles, or depending on them, are logged and put at the end, ordered by id, so that they are still stopped
+func (supervisor *GenericSupervisor) childrenStopOrder(children map[string]common.Supervisable) []string {
+	// child id -> number of its dependencies that have not been ordered yet
+	pending := make(map[string]int, len(children))
+	// child id -> ids of the children that depend on it
+	dependents := make(map[string][]string)
+	for childId, child := range children {
+		pending[childId] = 0
+		dependent, ok := child.(common.StopDependent)
+		if !ok {
+			continue
+		}
+		for _, dependencyId := range dependent.StopDependencies() {
+			if _, ok := children[dependencyId]; !ok || dependencyId == childId {
+				continue
+			}
+			pending[childId]++
+			dependents[dependencyId] = append(dependents[dependencyId], childId)
+		}
+	}
+
+	ready := make([]string, 0)
+	for childId, count := range pending {
+		if count == 0 {
+			ready = append(ready, childId)
+		}
+	}
+
+	order := make([]string, 0, len(children))
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		childId := ready[0]
+		ready = ready[1:]
+		order = append(order, childId)
+		delete(pending, childId)
+		for _, dependentId := range dependents[childId] {
+			pending[dependentId]--
+			if pending[dependentId] == 0 {
+				ready = append(ready, dependentId)
+			}
+		}
+	}
+
+	if len(pending) > 0 {
+		cyclic := make([]string, 0, len(pending))
+		for childId, _ := range pending {
+			cyclic = append(cyclic, childId)
+		}
+		sort.Strings(cyclic)
+		supervisor.Logger().Errorf("Stop dependencies of children %v of supervisor %v form cycles. They are stopped in arbitrary order\n", cyclic, supervisor.Id())
+		order = append(order, cyclic...)
+	}
+	return order
+}
+
+// asks children to finish their in-flight work, e.g., to flush their final batches, before stopping the supervisor.
+// children are drained concurrently, and each is given up to timeout to do so. children that have not been drained
+// by then are logged, and the supervisor is stopped regardless
+func (supervisor *GenericSupervisor) StopWithDrain(timeout time.Duration) error {
+	supervisor.drainChildren(timeout)
+	return supervisor.Stop()
+}
+
+// returns the ids of children that have not been drained within timeout, or that have failed to drain
+func (supervisor *GenericSupervisor) drainChildren(timeout time.Duration) []string {
+	supervisor.Logger().Infof("Draining children of supervisor %v. timeout=%v\n", supervisor.Id(), timeout)
+
+	// children are drained without holding children_lock, since they may call back into the supervisor
+	children := supervisor.childrenSnapshot()
+	if len(children) == 0 {
+		return nil
+	}
+
+	type drainResult struct {
+		childId string
+		err     error
+	}
+	// buffered so that children which finish draining after timeout do not block
+	result_ch := make(chan drainResult, len(children))
+	for childId, child := range children {
+		go func(childId string, child common.Supervisable) {
+			result_ch <- drainResult{childId, child.Drain()}
+		}(childId, child)
+	}
+
+	undrained := make(map[string]bool, len(children))
+	for childId, _ := range children {
+		undrained[childId] = true
+	}
+	timeout_timer := time.NewTimer(timeout)
+	defer timeout_timer.Stop()
+	var failedChildren []string
+loop:
+	for len(undrained) > 0 {
+		select {
+		case result := <-result_ch:
+			delete(undrained, result.childId)
+			if result.err != nil {
+				supervisor.Logger().Errorf("Child %v of supervisor %v failed to drain. err=%v\n", result.childId, supervisor.Id(), result.err)
+				failedChildren = append(failedChildren, result.childId)
+			}
+		case <-timeout_timer.C:
+			break loop
+		}
+	}
+
+	for childId, _ := range undrained {
+		supervisor.Logger().Errorf("Child %v of supervisor %v was not drained within %v\n", childId, supervisor.Id(), timeout)
+		failedChildren = append(failedChildren, childId)
+	}
+	sort.Strings(failedChildren)
+	return failedChildren
+}
+
 func (supervisor *GenericSupervisor) supervising() error {
 	defer supervisor.childrenWaitGrp.Done()
 
@@ -199,6 +568,10 @@ loop:
 		select {
 		case <-supervisor.finch:
 			break loop
+		case <-supervisor.heartbeat_interval_change_ch:
+			// heart beats that are in flight are not affected. the new interval takes effect from the next tick
+			supervisor.resetHeartBeatTicker()
+		// heartbeat_ticker is replaced only by this routine, hence it can be read here without settings_lock
 		case <-supervisor.heartbeat_ticker.C:
 			supervisor.Logger().Debugf("heart beat tick from super %v\n", supervisor.Id())
 			//wait until the previous heartbeat response are received or timed-out to send a new heartbeat
@@ -211,16 +584,28 @@ loop:
 	return nil
 }
 
+// replaces heartbeat_ticker with one that ticks at the current heartbeat_interval
+func (supervisor *GenericSupervisor) resetHeartBeatTicker() {
+	supervisor.settings_lock.Lock()
+	defer supervisor.settings_lock.Unlock()
+	if supervisor.heartbeat_ticker_stopped {
+		return
+	}
+	supervisor.heartbeat_ticker.Stop()
+	supervisor.heartbeat_ticker = time.NewTicker(supervisor.heartbeat_interval)
+	supervisor.Logger().Infof("Heart beat interval of supervisor %v has been changed to %v\n", supervisor.Id(), supervisor.heartbeat_interval)
+}
+
 func (supervisor *GenericSupervisor) sendHeartBeats(waitGrp *sync.WaitGroup) {
 	supervisor.Logger().Debugf("Sending heart beat msg from supervisor %v\n", supervisor.Id())
 
-	supervisor.children_lock.RLock()
-	defer supervisor.children_lock.RUnlock()
+	// heart beats are sent to a snapshot of children so that children_lock is not held when calling into children
+	children := supervisor.childrenSnapshot()
 
-	if len(supervisor.children) > 0 {
+	if len(children) > 0 {
 		heartbeat_report := make(map[string]heartbeatRespStatus)
 		heartbeat_resp_chs := make(map[string]chan []interface{})
-		for childId, child := range supervisor.children {
+		for childId, child := range children {
 			if child.IsReadyForHeartBeat() {
 				respch := make(chan []interface{}, 1)
 				supervisor.Logger().Debugf("heart beat sent to child %v from super %v\n", childId, supervisor.Id())
@@ -244,25 +629,123 @@ func (supervisor *GenericSupervisor) sendHeartBeats(waitGrp *sync.WaitGroup) {
 	return
 }
 
+func (supervisor *GenericSupervisor) childrenSnapshot() map[string]common.Supervisable {
+	supervisor.children_lock.RLock()
+	defer supervisor.children_lock.RUnlock()
+
+	children := make(map[string]common.Supervisable, len(supervisor.children))
+	for childId, child := range supervisor.children {
+		children[childId] = child
+	}
+	return children
+}
+
+func (supervisor *GenericSupervisor) NumOfChildren() int {
+	supervisor.children_lock.RLock()
+	defer supervisor.children_lock.RUnlock()
+	return len(supervisor.children)
+}
+
 func (supervisor *GenericSupervisor) Init(settings map[string]interface{}) error {
 	//initialize settings
-	err := utils.ValidateSettings(supervisor_setting_defs, settings, supervisor.Logger())
+	err := supervisor.validateSettings(settings)
 	if err != nil {
 		supervisor.Logger().Errorf("The setting for supervisor %v is not valid. err=%v", supervisor.Id(), err)
 		return err
 	}
 
+	supervisor.applySettings(settings)
+	if val, ok := settings[HEARTBEAT_RESP_CHECK_INTERVAL]; ok {
+		supervisor.settings_lock.Lock()
+		supervisor.heartbeat_resp_check_interval = val.(time.Duration)
+		supervisor.settings_lock.Unlock()
+	}
+
+	return nil
+}
+
+// updates heartbeat_interval, heartbeat_timeout and missed_heartbeat_threshold without restarting the supervisor,
+// so that children are not dropped. heart beats that are in flight are checked against the settings that were in
+// effect when they were sent
+func (supervisor *GenericSupervisor) UpdateSettings(settings map[string]interface{}) error {
+	supervisor.Logger().Infof("Updating settings on supervisor %v. settings=%v\n", supervisor.Id(), settings)
+	err := supervisor.validateSettings(settings)
+	if err != nil {
+		supervisor.Logger().Errorf("The setting for supervisor %v is not valid. err=%v", supervisor.Id(), err)
+		return err
+	}
+
+	if supervisor.applySettings(settings) {
+		// signal is dropped when there is a pending one, which will pick up the latest interval anyway.
+		// when the supervisor has not been started, the ticker is created with the latest interval in Start
+		select {
+		case supervisor.heartbeat_interval_change_ch <- true:
+		default:
+		}
+	}
+	return nil
+}
+
+func (supervisor *GenericSupervisor) SetHeartbeatInterval(interval time.Duration) error {
+	return supervisor.UpdateSettings(map[string]interface{}{HEARTBEAT_INTERVAL: interval})
+}
+
+func (supervisor *GenericSupervisor) SetFailureIsolationPolicy(policy FailureIsolationPolicy) error {
+	return supervisor.UpdateSettings(map[string]interface{}{FAILURE_ISOLATION_POLICY: string(policy)})
+}
+
+func (supervisor *GenericSupervisor) SetStopChildren(stopChildren bool) error {
+	return supervisor.UpdateSettings(map[string]interface{}{STOP_CHILDREN: stopChildren})
+}
+
+func (supervisor *GenericSupervisor) FailureIsolationPolicy() FailureIsolationPolicy {
+	supervisor.settings_lock.RLock()
+	defer supervisor.settings_lock.RUnlock()
+	return supervisor.failure_isolation_policy
+}
+
+func (supervisor *GenericSupervisor) validateSettings(settings map[string]interface{}) error {
+	err := utils.ValidateSettings(supervisor_setting_defs, settings, supervisor.Logger())
+	if err != nil {
+		return err
+	}
+	// tickers cannot be created with non-positive intervals
+	if val, ok := settings[HEARTBEAT_INTERVAL]; ok && val.(time.Duration) <= 0 {
+		return fmt.Errorf("%v needs to be positive. value=%v", HEARTBEAT_INTERVAL, val)
+	}
+	if val, ok := settings[FAILURE_ISOLATION_POLICY]; ok {
+		switch FailureIsolationPolicy(val.(string)) {
+		case FailureIsolationIsolate, FailureIsolationCascade:
+		default:
+			return fmt.Errorf("%v is not a valid value for %v", val, FAILURE_ISOLATION_POLICY)
+		}
+	}
+	return nil
+}
+
+// applies validated settings, and returns whether heartbeat_interval has been changed
+func (supervisor *GenericSupervisor) applySettings(settings map[string]interface{}) bool {
+	supervisor.settings_lock.Lock()
+	defer supervisor.settings_lock.Unlock()
+
+	intervalChanged := false
 	if val, ok := settings[HEARTBEAT_INTERVAL]; ok {
+		intervalChanged = supervisor.heartbeat_interval != val.(time.Duration)
 		supervisor.heartbeat_interval = val.(time.Duration)
 	}
 	if val, ok := settings[HEARTBEAT_TIMEOUT]; ok {
 		supervisor.heartbeat_timeout = val.(time.Duration)
 	}
-	if val, ok := settings[HEARTBEAT_RESP_CHECK_INTERVAL]; ok {
-		supervisor.heartbeat_resp_check_interval = val.(time.Duration)
+	if val, ok := settings[MISSED_HEARTBEAT_THRESHOLD]; ok {
+		supervisor.missed_heartbeat_threshold = val.(uint16)
 	}
-
-	return nil
+	if val, ok := settings[FAILURE_ISOLATION_POLICY]; ok {
+		supervisor.failure_isolation_policy = FailureIsolationPolicy(val.(string))
+	}
+	if val, ok := settings[STOP_CHILDREN]; ok {
+		supervisor.stop_children = val.(bool)
+	}
+	return intervalChanged
 }
 
 func (supervisor *GenericSupervisor) waitForResponse(heartbeat_report map[string]heartbeatRespStatus, heartbeat_resp_chs map[string]chan []interface{}, finch chan bool, waitGrp *sync.WaitGroup) {
@@ -271,10 +754,16 @@ func (supervisor *GenericSupervisor) waitForResponse(heartbeat_report map[string
 
 	//start a timer
 	ping_time := time.Now()
-	heartbeat_timeout_ch := time.After(supervisor.heartbeat_timeout)
-	heartbeat_resp_check_ticker := time.NewTicker(supervisor.heartbeat_resp_check_interval)
+	supervisor.settings_lock.RLock()
+	heartbeat_timeout := supervisor.heartbeat_timeout
+	heartbeat_resp_check_interval := supervisor.heartbeat_resp_check_interval
+	supervisor.settings_lock.RUnlock()
+	heartbeat_timeout_ch := time.After(heartbeat_timeout)
+	heartbeat_resp_check_ticker := time.NewTicker(heartbeat_resp_check_interval)
 	defer heartbeat_resp_check_ticker.Stop()
 	responded_count := 0
+	// key - child Id; value - time taken by the child to respond
+	heartbeat_latencies := make(map[string]time.Duration)
 
 	for {
 		select {
@@ -292,6 +781,8 @@ func (supervisor *GenericSupervisor) waitForResponse(heartbeat_report map[string
 					select {
 					case <-heartbeat_resp_chs[childId]:
 						responded_count++
+						heartbeat_latencies[childId] = time.Since(ping_time)
+						supervisor.heartbeat_latency_histogram.record(heartbeat_latencies[childId], time.Now())
 						supervisor.Logger().Debugf("Child %v has responded to the heartbeat ping sent at %v to supervisor %v\n", childId, ping_time, supervisor.Id())
 						heartbeat_report[childId] = respondedOk
 					default:
@@ -306,52 +797,228 @@ func (supervisor *GenericSupervisor) waitForResponse(heartbeat_report map[string
 
 	//process the result
 REPORT:
-	supervisor.processReport(heartbeat_report)
+	supervisor.processReport(heartbeat_report, heartbeat_latencies, ping_time)
 }
 
-func (supervisor *GenericSupervisor) processReport(heartbeat_report map[string]heartbeatRespStatus) {
+// reports the children that have exceeded missed_heartbeat_threshold to the failure handler, and then removes them.
+// the failed children are still children of the supervisor when the failure handler is called, i.e., they can be
+// retrieved through Child in OnError. previously they were removed before the failure handler was called
+func (supervisor *GenericSupervisor) processReport(heartbeat_report map[string]heartbeatRespStatus, heartbeat_latencies map[string]time.Duration, ping_time time.Time) {
 	supervisor.Logger().Debugf("***********ProcessReport for supervisor %v*************\n", supervisor.Id())
 	supervisor.Logger().Debugf("len(heartbeat_report)=%v\n", len(heartbeat_report))
 
+	brokenChildren := supervisor.updateChildrenHealth(heartbeat_report, heartbeat_latencies, ping_time)
+
+	if len(brokenChildren) > 0 {
+		supervisor.Logger().Errorf("%v has exceeded heartbeat_missed_threshold", brokenChildren)
+		// children_lock is not held here, so that the failure handler can call RemoveChild or Child.
+		// broken children are removed only after the failure has been reported, so that they can still
+		// be retrieved by the failure handler
+		supervisor.ReportFailure(brokenChildren)
+		for childId, _ := range brokenChildren {
+			supervisor.RemoveChild(childId)
+		}
+	}
+}
+
+// update the health of children, including missed heart beat counts, with the heart beat report and return the children
+// that have exceeded missed_heartbeat_threshold
+func (supervisor *GenericSupervisor) updateChildrenHealth(heartbeat_report map[string]heartbeatRespStatus, heartbeat_latencies map[string]time.Duration, ping_time time.Time) map[string]error {
+	supervisor.settings_lock.RLock()
+	missed_heartbeat_threshold := supervisor.missed_heartbeat_threshold
+	supervisor.settings_lock.RUnlock()
+
 	supervisor.children_lock.Lock()
 	defer supervisor.children_lock.Unlock()
 
+	supervisor.last_report_time = time.Now()
+
 	brokenChildren := make(map[string]error)
 	for childId, status := range heartbeat_report {
 		supervisor.Logger().Debugf("childId=%v, status=%v\n", childId, status)
 
+		if _, ok := supervisor.children[childId]; !ok {
+			// child has been removed since the heart beat was sent. do not add it back to childrenHealthMap
+			continue
+		}
+
+		health, ok := supervisor.childrenHealthMap[childId]
+		if !ok {
+			health = &ChildHealth{}
+			supervisor.childrenHealthMap[childId] = health
+		}
+
 		if status == respondedNotOk || status == notYetResponded {
-			var missedCount uint16
-			// missedCount would be zero when child is not yet in the map, which would be the correct value
-			missedCount, _ = supervisor.childrenBeatMissedMap[childId]
-			missedCount++
-			supervisor.Logger().Infof("Child %v of supervisor %v missed %v consecutive heart beats\n", childId, supervisor.Id(), missedCount)
-			supervisor.childrenBeatMissedMap[childId] = missedCount
-			if missedCount > supervisor.missed_heartbeat_threshold {
+			health.ConsecutiveMisses++
+			supervisor.Logger().Infof("Child %v of supervisor %v missed %v consecutive heart beats. latency of last response=%v\n", childId, supervisor.Id(), health.ConsecutiveMisses, health.LastLatency)
+			if health.ConsecutiveMisses > missed_heartbeat_threshold {
 				// report the child as broken if it exceeded the beat_missed_threshold
 				brokenChildren[childId] = errors.New("Not responding")
-				supervisor.removeChild_internal(childId, false)
 			}
 		} else {
 			// reset missed count to 0 when child responds
-			supervisor.childrenBeatMissedMap[childId] = 0
+			health.ConsecutiveMisses = 0
+			if status == respondedOk {
+				health.LastLatency = heartbeat_latencies[childId]
+				health.LastBeatTime = ping_time
+			}
 		}
 	}
+	return brokenChildren
+}
 
-	if len(brokenChildren) > 0 {
-		supervisor.Logger().Errorf("%v has exceeded heartbeat_missed_threshold", brokenChildren)
-		supervisor.ReportFailure(brokenChildren)
+// returns false, along with the children that have exceeded missed_heartbeat_threshold, when there are such children.
+// note that such children are removed after they have been reported to the failure handler
+func (supervisor *GenericSupervisor) IsHealthy() (bool, map[string]error) {
+	supervisor.settings_lock.RLock()
+	missed_heartbeat_threshold := supervisor.missed_heartbeat_threshold
+	supervisor.settings_lock.RUnlock()
+
+	supervisor.children_lock.RLock()
+	defer supervisor.children_lock.RUnlock()
+
+	brokenChildren := make(map[string]error)
+	for childId, health := range supervisor.childrenHealthMap {
+		if health.ConsecutiveMisses > missed_heartbeat_threshold {
+			brokenChildren[childId] = fmt.Errorf("Missed %v consecutive heart beats", health.ConsecutiveMisses)
+		}
+	}
+	return len(brokenChildren) == 0, brokenChildren
+}
+
+// returns the health of the supervision tree rooted at the supervisor. children that are supervisors themselves
+// are reported along with their own children, and other children are reported with their last heart beat health
+func (supervisor *GenericSupervisor) TreeHealthReport() *HealthReport {
+	return supervisor.treeHealthReport(nil, make(map[*GenericSupervisor]bool))
+}
+
+// ancestors contains the supervisors on the path from the root to the supervisor, which guards against cycles
+func (supervisor *GenericSupervisor) treeHealthReport(health *ChildHealth, ancestors map[*GenericSupervisor]bool) *HealthReport {
+	report := &HealthReport{Id: supervisor.Id(),
+		Health:  health,
+		Healthy: true}
+	if ancestors[supervisor] {
+		supervisor.Logger().Errorf("Supervisor %v is its own descendant. Its children are not reported again\n", supervisor.Id())
+		report.Cycle = true
+		return report
+	}
+	ancestors[supervisor] = true
+	defer delete(ancestors, supervisor)
+
+	supervisor.settings_lock.RLock()
+	missed_heartbeat_threshold := supervisor.missed_heartbeat_threshold
+	supervisor.settings_lock.RUnlock()
+
+	// take a snapshot so that no lock is held when recursing into child supervisors, which could otherwise deadlock on cycles
+	children, childrenHealth := supervisor.childrenAndHealthSnapshot()
+
+	report.Children = make(map[string]*HealthReport, len(children))
+	for childId, child := range children {
+		childHealth := childrenHealth[childId]
+		var childReport *HealthReport
+		if childSupervisor, ok := child.(*GenericSupervisor); ok {
+			childReport = childSupervisor.treeHealthReport(childHealth, ancestors)
+		} else {
+			childReport = &HealthReport{Id: childId,
+				Health:  childHealth,
+				Healthy: true}
+		}
+		if childHealth != nil && childHealth.ConsecutiveMisses > missed_heartbeat_threshold {
+			childReport.Healthy = false
+		}
+		if !childReport.Healthy {
+			report.Healthy = false
+		}
+		report.Children[childId] = childReport
+	}
+	return report
+}
+
+// returns a copy of children and of their heart beat health, which are taken together so that they are consistent
+func (supervisor *GenericSupervisor) childrenAndHealthSnapshot() (map[string]common.Supervisable, map[string]*ChildHealth) {
+	supervisor.children_lock.RLock()
+	defer supervisor.children_lock.RUnlock()
+
+	children := make(map[string]common.Supervisable, len(supervisor.children))
+	childrenHealth := make(map[string]*ChildHealth, len(supervisor.children))
+	for childId, child := range supervisor.children {
+		children[childId] = child
+		if health, ok := supervisor.childrenHealthMap[childId]; ok {
+			healthCopy := *health
+			childrenHealth[childId] = &healthCopy
+		}
 	}
+	return children, childrenHealth
+}
+
+// returns p50, p90 and p99 of the heart beat round-trip latencies of children observed in the last heartbeat_latency_window
+func (supervisor *GenericSupervisor) HeartbeatLatencyPercentiles() *HeartbeatLatencyPercentiles {
+	return supervisor.heartbeat_latency_histogram.percentiles(time.Now())
 }
 
+// time when heart beat report was last processed, which tells whether the supervisor is still checking its children
+func (supervisor *GenericSupervisor) LastReportTime() time.Time {
+	supervisor.children_lock.RLock()
+	defer supervisor.children_lock.RUnlock()
+	return supervisor.last_report_time
+}
+
+// reports the failed children to the failure handler. under cascade policy, their siblings are reported along with them
 func (supervisor *GenericSupervisor) ReportFailure(errors map[string]error) {
+	report := supervisor.newFailureReport(errors, supervisor.FailureIsolationPolicy())
+	if len(report.AffectedChildren) > len(report.FailedChildren) {
+		supervisor.Logger().Infof("Failure of children %v of supervisor %v cascades to all children %v\n", report.FailedChildren, supervisor.Id(), report.AffectedChildren)
+	}
+
+	supervisor.children_lock.Lock()
+	supervisor.last_failure_report = report
+	supervisor.children_lock.Unlock()
+
 	//report the failure to decision maker
-	supervisor.failure_handler.OnError(supervisor, errors)
+	supervisor.failure_handler.OnError(supervisor, report.Errors)
+}
+
+func (supervisor *GenericSupervisor) newFailureReport(errors map[string]error, policy FailureIsolationPolicy) *FailureReport {
+	report := &FailureReport{Policy: policy,
+		FailedChildren: make([]string, 0, len(errors)),
+		Errors:         make(map[string]error, len(errors)),
+		Time:           time.Now()}
+	for childId, err := range errors {
+		report.FailedChildren = append(report.FailedChildren, childId)
+		report.Errors[childId] = err
+	}
+	sort.Strings(report.FailedChildren)
+
+	if policy == FailureIsolationCascade {
+		sibling_err := &SiblingFailureError{FailedChildren: report.FailedChildren}
+		for childId, _ := range supervisor.childrenSnapshot() {
+			if _, ok := report.Errors[childId]; !ok {
+				report.Errors[childId] = sibling_err
+			}
+		}
+	}
+
+	report.AffectedChildren = make([]string, 0, len(report.Errors))
+	for childId, _ := range report.Errors {
+		report.AffectedChildren = append(report.AffectedChildren, childId)
+	}
+	sort.Strings(report.AffectedChildren)
+	return report
+}
+
+// returns the last failure that has been reported to the failure handler, or nil if no failure has been reported
+func (supervisor *GenericSupervisor) LastFailureReport() *FailureReport {
+	supervisor.children_lock.RLock()
+	defer supervisor.children_lock.RUnlock()
+	return supervisor.last_failure_report
 }
 
 func (supervisor *GenericSupervisor) StopHeartBeatTicker() {
+	supervisor.settings_lock.Lock()
+	defer supervisor.settings_lock.Unlock()
 	if supervisor.heartbeat_ticker != nil {
 		supervisor.heartbeat_ticker.Stop()
+		supervisor.heartbeat_ticker_stopped = true
 	}
 }
 
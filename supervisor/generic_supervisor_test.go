@@ -0,0 +1,655 @@
+// Copyright (c) 2013 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package supervisor
+
+import (
+	"errors"
+	"fmt"
+	"github.com/couchbase/goxdcr/common"
+	"github.com/couchbase/goxdcr/log"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// child that responds to heart beats only when responsive is true
+type testChild struct {
+	id         string
+	responsive bool
+}
+
+func (child *testChild) Id() string {
+	return child.id
+}
+
+func (child *testChild) IsReadyForHeartBeat() bool {
+	return true
+}
+
+func (child *testChild) HeartBeat_sync() bool {
+	return child.responsive
+}
+
+func (child *testChild) HeartBeat_async(respchan chan []interface{}, timestamp time.Time) error {
+	if child.responsive {
+		respchan <- []interface{}{true}
+	}
+	return nil
+}
+
+func (child *testChild) Drain() error {
+	return nil
+}
+
+// failure handler that calls back into the supervisor, as replication manager does
+type testFailureHandler struct {
+	num_of_failures int32
+}
+
+func (handler *testFailureHandler) OnError(supervisor common.Supervisor, errors map[string]error) {
+	atomic.AddInt32(&handler.num_of_failures, 1)
+	for childId, _ := range errors {
+		supervisor.Child(childId)
+		supervisor.RemoveChild(childId)
+	}
+}
+
+// run with -race to detect concurrent access to children and childrenHealthMap
+func TestChildrenChurnDuringHeartBeatAndFailure(t *testing.T) {
+	handler := &testFailureHandler{}
+	supervisor := NewGenericSupervisor("TestSupervisor", log.DefaultLoggerContext, handler, nil)
+	supervisor.heartbeat_resp_check_interval = 2 * time.Millisecond
+	supervisor.missed_heartbeat_threshold = 1
+
+	for i := 0; i < 10; i++ {
+		supervisor.AddChild(&testChild{id: fmt.Sprintf("child%v", i), responsive: i%2 == 0})
+	}
+
+	settings := map[string]interface{}{HEARTBEAT_INTERVAL: 5 * time.Millisecond,
+		HEARTBEAT_TIMEOUT: 10 * time.Millisecond}
+	err := supervisor.Start(settings)
+	if err != nil {
+		t.Fatalf("Failed to start supervisor. err=%v", err)
+	}
+
+	fin_ch := make(chan bool)
+	wait_grp := &sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wait_grp.Add(1)
+		go func(routine int) {
+			defer wait_grp.Done()
+			for j := 0; ; j++ {
+				select {
+				case <-fin_ch:
+					return
+				default:
+					childId := fmt.Sprintf("churn%v_%v", routine, j%5)
+					supervisor.AddChild(&testChild{id: childId, responsive: j%3 == 0})
+					supervisor.Child(childId)
+					if j%2 == 0 {
+						supervisor.RemoveChild(childId)
+					}
+					time.Sleep(time.Millisecond)
+				}
+			}
+		}(i)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	close(fin_ch)
+	wait_grp.Wait()
+
+	stop_ch := make(chan error, 1)
+	go func() {
+		stop_ch <- supervisor.Stop()
+	}()
+	select {
+	case err = <-stop_ch:
+		if err != nil {
+			t.Fatalf("Failed to stop supervisor. err=%v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Supervisor did not stop in time, possible deadlock")
+	}
+
+	if atomic.LoadInt32(&handler.num_of_failures) == 0 {
+		t.Fatalf("Expected failures to be reported for unresponsive children")
+	}
+}
+
+func TestGetChildHealth(t *testing.T) {
+	supervisor := NewGenericSupervisor("TestSupervisor", log.DefaultLoggerContext, &testFailureHandler{}, nil)
+	supervisor.missed_heartbeat_threshold = 2
+	supervisor.AddChild(&testChild{id: "slow", responsive: true})
+	supervisor.AddChild(&testChild{id: "dead"})
+
+	if _, err := supervisor.GetChildHealth("nonExisting"); err == nil {
+		t.Errorf("expected error for non-existing child")
+	}
+
+	ping_time := time.Now()
+	report := map[string]heartbeatRespStatus{"slow": respondedOk, "dead": notYetResponded}
+	latencies := map[string]time.Duration{"slow": 3 * time.Second}
+	for i := 0; i < 2; i++ {
+		if brokenChildren := supervisor.updateChildrenHealth(report, latencies, ping_time); len(brokenChildren) != 0 {
+			t.Errorf("children %v are reported as broken before missed_heartbeat_threshold is exceeded", brokenChildren)
+		}
+	}
+
+	health, err := supervisor.GetChildHealth("slow")
+	if err != nil {
+		t.Fatalf("failed to get health of child. err=%v", err)
+	}
+	if health.LastLatency != 3*time.Second || health.ConsecutiveMisses != 0 || !health.LastBeatTime.Equal(ping_time) {
+		t.Errorf("health of slow child is %+v", health)
+	}
+	health, err = supervisor.GetChildHealth("dead")
+	if err != nil {
+		t.Fatalf("failed to get health of child. err=%v", err)
+	}
+	if health.ConsecutiveMisses != 2 || !health.LastBeatTime.IsZero() {
+		t.Errorf("health of dead child is %+v", health)
+	}
+
+	brokenChildren := supervisor.updateChildrenHealth(report, latencies, ping_time)
+	if _, ok := brokenChildren["dead"]; !ok || len(brokenChildren) != 1 {
+		t.Errorf("broken children are %v, expected dead child only", brokenChildren)
+	}
+}
+
+// failure handler that records whether the failed children could be retrieved from the supervisor
+type childLookupFailureHandler struct {
+	found_children map[string]bool
+}
+
+func (handler *childLookupFailureHandler) OnError(supervisor common.Supervisor, errors map[string]error) {
+	for childId, _ := range errors {
+		child, err := supervisor.Child(childId)
+		handler.found_children[childId] = err == nil && child != nil
+	}
+}
+
+func TestFailedChildReportedBeforeRemoval(t *testing.T) {
+	handler := &childLookupFailureHandler{found_children: make(map[string]bool)}
+	supervisor := NewGenericSupervisor("TestSupervisor", log.DefaultLoggerContext, handler, nil)
+	supervisor.missed_heartbeat_threshold = 0
+	supervisor.AddChild(&testChild{id: "alive", responsive: true})
+	supervisor.AddChild(&testChild{id: "dead"})
+
+	report := map[string]heartbeatRespStatus{"alive": respondedOk, "dead": notYetResponded}
+	supervisor.processReport(report, map[string]time.Duration{}, time.Now())
+
+	found, ok := handler.found_children["dead"]
+	if !ok || len(handler.found_children) != 1 {
+		t.Fatalf("failure handler was called with %v, expected dead child only", handler.found_children)
+	}
+	if !found {
+		t.Errorf("failed child could not be retrieved by the failure handler")
+	}
+	if _, err := supervisor.Child("dead"); err == nil {
+		t.Errorf("failed child has not been removed after it has been reported")
+	}
+	if _, err := supervisor.Child("alive"); err != nil {
+		t.Errorf("healthy child has been removed. err=%v", err)
+	}
+}
+
+// child that counts the heart beats it has received, and responds to them after the given delay
+type countingChild struct {
+	id           string
+	delay        time.Duration
+	num_of_beats int32
+}
+
+func (child *countingChild) Id() string {
+	return child.id
+}
+
+func (child *countingChild) IsReadyForHeartBeat() bool {
+	return true
+}
+
+func (child *countingChild) HeartBeat_sync() bool {
+	return true
+}
+
+func (child *countingChild) HeartBeat_async(respchan chan []interface{}, timestamp time.Time) error {
+	atomic.AddInt32(&child.num_of_beats, 1)
+	go func() {
+		time.Sleep(child.delay)
+		respchan <- []interface{}{true}
+	}()
+	return nil
+}
+
+func (child *countingChild) Drain() error {
+	return nil
+}
+
+func TestUpdateSettings(t *testing.T) {
+	handler := &testFailureHandler{}
+	supervisor := NewGenericSupervisor("TestSupervisor", log.DefaultLoggerContext, handler, nil)
+	supervisor.heartbeat_resp_check_interval = 2 * time.Millisecond
+	child := &countingChild{id: "child", delay: 20 * time.Millisecond}
+	supervisor.AddChild(child)
+
+	err := supervisor.Start(map[string]interface{}{HEARTBEAT_INTERVAL: 10 * time.Millisecond,
+		HEARTBEAT_TIMEOUT: time.Second})
+	if err != nil {
+		t.Fatalf("Failed to start supervisor. err=%v", err)
+	}
+	defer supervisor.Stop()
+
+	// wait for a heart beat to be in flight when the interval is changed
+	for atomic.LoadInt32(&child.num_of_beats) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err = supervisor.SetHeartbeatInterval(time.Hour); err != nil {
+		t.Fatalf("Failed to set heart beat interval. err=%v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	num_of_beats := atomic.LoadInt32(&child.num_of_beats)
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt32(&child.num_of_beats) != num_of_beats {
+		t.Errorf("Heart beats are still sent at the old interval after the interval has been changed")
+	}
+
+	// the heart beat in flight when the interval was changed has been responded to
+	health, err := supervisor.GetChildHealth(child.Id())
+	if err != nil {
+		t.Fatalf("Child has been dropped after the interval was changed. err=%v", err)
+	}
+	if health.ConsecutiveMisses != 0 || health.LastBeatTime.IsZero() {
+		t.Errorf("Health of child is %+v after the interval was changed", health)
+	}
+	if atomic.LoadInt32(&handler.num_of_failures) != 0 {
+		t.Errorf("Failures were reported after the interval was changed")
+	}
+
+	if err = supervisor.SetHeartbeatInterval(10 * time.Millisecond); err != nil {
+		t.Fatalf("Failed to set heart beat interval. err=%v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt32(&child.num_of_beats) == num_of_beats {
+		t.Errorf("Heart beats are not sent at the new interval")
+	}
+
+	err = supervisor.UpdateSettings(map[string]interface{}{HEARTBEAT_TIMEOUT: 2 * time.Second,
+		MISSED_HEARTBEAT_THRESHOLD: uint16(3)})
+	if err != nil {
+		t.Fatalf("Failed to update settings. err=%v", err)
+	}
+	supervisor.settings_lock.RLock()
+	if supervisor.heartbeat_timeout != 2*time.Second || supervisor.missed_heartbeat_threshold != 3 {
+		t.Errorf("Settings are heartbeat_timeout=%v and missed_heartbeat_threshold=%v after update",
+			supervisor.heartbeat_timeout, supervisor.missed_heartbeat_threshold)
+	}
+	supervisor.settings_lock.RUnlock()
+
+	if err = supervisor.UpdateSettings(map[string]interface{}{MISSED_HEARTBEAT_THRESHOLD: 3}); err == nil {
+		t.Errorf("Expected error for setting of wrong type")
+	}
+	if err = supervisor.SetHeartbeatInterval(0); err == nil {
+		t.Errorf("Expected error for non-positive heart beat interval")
+	}
+}
+
+// child that takes the given time to drain its in-flight work
+type drainingChild struct {
+	testChild
+	drain_time time.Duration
+	drain_err  error
+	drained    int32
+}
+
+func (child *drainingChild) Drain() error {
+	time.Sleep(child.drain_time)
+	atomic.StoreInt32(&child.drained, 1)
+	return child.drain_err
+}
+
+func TestStopWithDrain(t *testing.T) {
+	supervisor := NewGenericSupervisor("TestSupervisor", log.DefaultLoggerContext, &testFailureHandler{}, nil)
+	fast := &drainingChild{testChild: testChild{id: "fast", responsive: true}, drain_time: 10 * time.Millisecond}
+	slow := &drainingChild{testChild: testChild{id: "slow", responsive: true}, drain_time: 10 * time.Second}
+	failed := &drainingChild{testChild: testChild{id: "failed", responsive: true}, drain_err: fmt.Errorf("drain failed")}
+	for _, child := range []*drainingChild{fast, slow, failed} {
+		supervisor.AddChild(child)
+	}
+
+	start_time := time.Now()
+	undrained := supervisor.drainChildren(200 * time.Millisecond)
+	if time.Since(start_time) > 5*time.Second {
+		t.Errorf("drainChildren did not return after timeout")
+	}
+	if len(undrained) != 2 || undrained[0] != "failed" || undrained[1] != "slow" {
+		t.Errorf("undrained children are %v, expected [failed slow]", undrained)
+	}
+	if atomic.LoadInt32(&fast.drained) != 1 {
+		t.Errorf("fast child has not been drained")
+	}
+
+	err := supervisor.Start(map[string]interface{}{HEARTBEAT_INTERVAL: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to start supervisor. err=%v", err)
+	}
+	atomic.StoreInt32(&fast.drained, 0)
+	stop_ch := make(chan error, 1)
+	go func() {
+		stop_ch <- supervisor.StopWithDrain(100 * time.Millisecond)
+	}()
+	select {
+	case err = <-stop_ch:
+		if err != nil {
+			t.Fatalf("Failed to stop supervisor. err=%v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Supervisor did not stop in time")
+	}
+	if atomic.LoadInt32(&fast.drained) != 1 {
+		t.Errorf("fast child has not been drained before supervisor was stopped")
+	}
+}
+
+func TestIsHealthy(t *testing.T) {
+	supervisor := NewGenericSupervisor("TestSupervisor", log.DefaultLoggerContext, &testFailureHandler{}, nil)
+	supervisor.missed_heartbeat_threshold = 1
+	supervisor.AddChild(&testChild{id: "alive", responsive: true})
+	supervisor.AddChild(&testChild{id: "dead"})
+
+	if !supervisor.LastReportTime().IsZero() {
+		t.Errorf("last report time is %v before any report has been processed", supervisor.LastReportTime())
+	}
+
+	report := map[string]heartbeatRespStatus{"alive": respondedOk, "dead": notYetResponded}
+	for i := 0; i < 2; i++ {
+		if healthy, brokenChildren := supervisor.IsHealthy(); !healthy || len(brokenChildren) != 0 {
+			t.Errorf("supervisor is unhealthy with broken children %v before missed_heartbeat_threshold is exceeded", brokenChildren)
+		}
+		supervisor.updateChildrenHealth(report, nil, time.Now())
+	}
+
+	healthy, brokenChildren := supervisor.IsHealthy()
+	if _, ok := brokenChildren["dead"]; healthy || !ok || len(brokenChildren) != 1 {
+		t.Errorf("supervisor is healthy=%v with broken children %v, expected dead child only", healthy, brokenChildren)
+	}
+	if supervisor.LastReportTime().IsZero() {
+		t.Errorf("last report time has not been updated")
+	}
+
+	supervisor.RemoveChild("dead")
+	if healthy, brokenChildren := supervisor.IsHealthy(); !healthy {
+		t.Errorf("supervisor is unhealthy with broken children %v after broken child has been removed", brokenChildren)
+	}
+}
+
+func TestHeartbeatLatencyPercentiles(t *testing.T) {
+	histogram := newHeartbeatLatencyHistogram(time.Minute, 6)
+	now := time.Now()
+	if percentiles := histogram.percentiles(now); percentiles.Count != 0 || percentiles.P99 != 0 {
+		t.Errorf("percentiles without latencies are %+v", percentiles)
+	}
+
+	// latencies that have slid out of the window are not counted
+	histogram.record(30*time.Second, now.Add(-2*time.Minute))
+	for i := 0; i < 100; i++ {
+		latency := 3 * time.Millisecond
+		if i >= 90 {
+			latency = 150 * time.Millisecond
+		} else if i >= 50 {
+			latency = 40 * time.Millisecond
+		}
+		histogram.record(latency, now.Add(-time.Duration(i%5)*10*time.Second))
+	}
+	// recorded concurrently, as by multiple waitForResponse routines
+	wait_grp := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wait_grp.Add(1)
+		go func() {
+			defer wait_grp.Done()
+			histogram.record(3*time.Millisecond, now)
+		}()
+	}
+	wait_grp.Wait()
+
+	expected := HeartbeatLatencyPercentiles{P50: 5 * time.Millisecond, P90: 50 * time.Millisecond, P99: 150 * time.Millisecond, Count: 110}
+	if percentiles := histogram.percentiles(now); *percentiles != expected {
+		t.Errorf("percentiles are %+v, expected %+v", percentiles, expected)
+	}
+
+	supervisor := NewGenericSupervisor("TestSupervisor", log.DefaultLoggerContext, &testFailureHandler{}, nil)
+	if percentiles := supervisor.HeartbeatLatencyPercentiles(); percentiles.Count != 0 {
+		t.Errorf("percentiles of new supervisor are %+v", percentiles)
+	}
+}
+
+// failure handler that keeps the errors of the last failure reported to it
+type recordingFailureHandler struct {
+	errors map[string]error
+}
+
+func (handler *recordingFailureHandler) OnError(supervisor common.Supervisor, errors map[string]error) {
+	handler.errors = errors
+}
+
+func TestFailureIsolationPolicy(t *testing.T) {
+	handler := &recordingFailureHandler{}
+	parent := NewGenericSupervisor("TestParent", log.DefaultLoggerContext, handler, nil)
+	for _, childId := range []string{"pipeline1", "pipeline2", "pipeline3"} {
+		NewGenericSupervisor(childId, log.DefaultLoggerContext, &testFailureHandler{}, parent)
+	}
+
+	if parent.FailureIsolationPolicy() != FailureIsolationIsolate {
+		t.Errorf("default policy is %v, expected %v", parent.FailureIsolationPolicy(), FailureIsolationIsolate)
+	}
+	if parent.LastFailureReport() != nil {
+		t.Errorf("failure report %+v exists before any failure has been reported", parent.LastFailureReport())
+	}
+
+	// only the failed child is acted on under isolate policy
+	parent.ReportFailure(map[string]error{"pipeline2": errors.New("Not responding")})
+	if len(handler.errors) != 1 || handler.errors["pipeline2"] == nil {
+		t.Errorf("errors %v are reported under isolate policy, expected pipeline2 only", handler.errors)
+	}
+	report := parent.LastFailureReport()
+	if report == nil || report.Policy != FailureIsolationIsolate || !reflect.DeepEqual(report.AffectedChildren, []string{"pipeline2"}) {
+		t.Errorf("unexpected failure report %+v under isolate policy", report)
+	}
+
+	// siblings are acted on along with the failed child under cascade policy
+	if err := parent.SetFailureIsolationPolicy(FailureIsolationCascade); err != nil {
+		t.Fatalf("Failed to set failure isolation policy. err=%v", err)
+	}
+	parent.ReportFailure(map[string]error{"pipeline2": errors.New("Not responding")})
+	if len(handler.errors) != 3 {
+		t.Errorf("errors %v are reported under cascade policy, expected all children", handler.errors)
+	}
+	for _, childId := range []string{"pipeline1", "pipeline3"} {
+		if sibling_err, ok := handler.errors[childId].(*SiblingFailureError); !ok || !reflect.DeepEqual(sibling_err.FailedChildren, []string{"pipeline2"}) {
+			t.Errorf("error of sibling %v is %v, expected SiblingFailureError", childId, handler.errors[childId])
+		}
+	}
+	report = parent.LastFailureReport()
+	if report.Policy != FailureIsolationCascade || !reflect.DeepEqual(report.FailedChildren, []string{"pipeline2"}) ||
+		!reflect.DeepEqual(report.AffectedChildren, []string{"pipeline1", "pipeline2", "pipeline3"}) {
+		t.Errorf("unexpected failure report %+v under cascade policy", report)
+	}
+
+	if err := parent.SetFailureIsolationPolicy("restart_all"); err == nil {
+		t.Errorf("invalid failure isolation policy was accepted")
+	}
+	if parent.FailureIsolationPolicy() != FailureIsolationCascade {
+		t.Errorf("policy is %v after invalid update, expected %v", parent.FailureIsolationPolicy(), FailureIsolationCascade)
+	}
+}
+
+// top-level supervisors, e.g., ReplicationManagerSupervisor, have no parent to be removed from when they stop
+func TestStopWithNilParent(t *testing.T) {
+	stopSupervisor := func(supervisor *GenericSupervisor) {
+		err := supervisor.Start(map[string]interface{}{HEARTBEAT_INTERVAL: 5 * time.Millisecond})
+		if err != nil {
+			t.Fatalf("Failed to start supervisor %v. err=%v", supervisor.Id(), err)
+		}
+		stop_ch := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					stop_ch <- r
+				}
+			}()
+			stop_ch <- supervisor.Stop()
+		}()
+		select {
+		case result := <-stop_ch:
+			if result != nil {
+				t.Fatalf("Failed to stop supervisor %v. result=%v", supervisor.Id(), result)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Supervisor %v did not stop in time", supervisor.Id())
+		}
+	}
+
+	root := NewGenericSupervisor("RootSupervisor", log.DefaultLoggerContext, &testFailureHandler{}, nil)
+	child := NewGenericSupervisor("ChildSupervisor", log.DefaultLoggerContext, &testFailureHandler{}, root)
+	if _, err := root.Child(child.Id()); err != nil {
+		t.Fatalf("Child supervisor has not been added to root supervisor. err=%v", err)
+	}
+
+	// supervisors with parents are removed from their parents when they stop
+	stopSupervisor(child)
+	if _, err := root.Child(child.Id()); err == nil {
+		t.Errorf("Child supervisor has not been removed from root supervisor after it stopped")
+	}
+
+	stopSupervisor(root)
+}
+
+// child that records the order in which it is stopped
+type stoppingChild struct {
+	testChild
+	dependencies []string
+	stop_order   *[]string
+}
+
+func (child *stoppingChild) StopDependencies() []string {
+	return child.dependencies
+}
+
+func (child *stoppingChild) Stop() error {
+	*child.stop_order = append(*child.stop_order, child.id)
+	return nil
+}
+
+func TestStopChildrenInDependencyOrder(t *testing.T) {
+	supervisor := NewGenericSupervisor("TestSupervisor", log.DefaultLoggerContext, &testFailureHandler{}, nil)
+	stop_order := make([]string, 0)
+	newChild := func(id string, dependencies ...string) *stoppingChild {
+		child := &stoppingChild{testChild: testChild{id: id, responsive: true}, dependencies: dependencies, stop_order: &stop_order}
+		supervisor.AddChild(child)
+		return child
+	}
+	// nozzles are stopped after the router, which is stopped after the source. dependencies on non-children are ignored
+	newChild("a_nozzle1", "router")
+	newChild("a_nozzle2", "router", "nonexistent")
+	newChild("router", "source")
+	newChild("source")
+	newChild("standalone")
+	// children without Stop are skipped
+	supervisor.AddChild(&testChild{id: "unstoppable", responsive: true})
+
+	// children are not stopped by default
+	if err := supervisor.Start(map[string]interface{}{HEARTBEAT_INTERVAL: 5 * time.Millisecond}); err != nil {
+		t.Fatalf("Failed to start supervisor. err=%v", err)
+	}
+	if err := supervisor.Stop(); err != nil {
+		t.Fatalf("Failed to stop supervisor. err=%v", err)
+	}
+	if len(stop_order) != 0 {
+		t.Errorf("children %v are stopped when %v is off", stop_order, STOP_CHILDREN)
+	}
+
+	supervisor = NewGenericSupervisor("TestSupervisor", log.DefaultLoggerContext, &testFailureHandler{}, nil)
+	newChild("a_nozzle1", "router")
+	newChild("a_nozzle2", "router", "nonexistent")
+	newChild("router", "source")
+	newChild("source")
+	newChild("standalone")
+	supervisor.AddChild(&testChild{id: "unstoppable", responsive: true})
+	if err := supervisor.Start(map[string]interface{}{HEARTBEAT_INTERVAL: 5 * time.Millisecond, STOP_CHILDREN: true}); err != nil {
+		t.Fatalf("Failed to start supervisor. err=%v", err)
+	}
+	if err := supervisor.Stop(); err != nil {
+		t.Fatalf("Failed to stop supervisor. err=%v", err)
+	}
+	expected := []string{"source", "router", "a_nozzle1", "a_nozzle2", "standalone"}
+	if !reflect.DeepEqual(stop_order, expected) {
+		t.Errorf("children are stopped in order %v, expected %v", stop_order, expected)
+	}
+
+	// children in cycles are still stopped, after the others
+	supervisor = NewGenericSupervisor("TestSupervisor", log.DefaultLoggerContext, &testFailureHandler{}, nil)
+	children := map[string]common.Supervisable{
+		"a": &stoppingChild{testChild: testChild{id: "a"}, dependencies: []string{"b"}},
+		"b": &stoppingChild{testChild: testChild{id: "b"}, dependencies: []string{"a"}},
+		"c": &stoppingChild{testChild: testChild{id: "c"}, dependencies: []string{"a"}},
+		"d": &stoppingChild{testChild: testChild{id: "d"}, dependencies: []string{"d"}},
+	}
+	order := supervisor.childrenStopOrder(children)
+	expected = []string{"d", "a", "b", "c"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("stop order with cycles is %v, expected %v", order, expected)
+	}
+}
+
+func TestTreeHealthReport(t *testing.T) {
+	root := NewGenericSupervisor("root", log.DefaultLoggerContext, &testFailureHandler{}, nil)
+	child := NewGenericSupervisor("child", log.DefaultLoggerContext, &testFailureHandler{}, root)
+	child.missed_heartbeat_threshold = 1
+	root.AddChild(&testChild{id: "alive", responsive: true})
+	child.AddChild(&testChild{id: "dead"})
+
+	report := root.TreeHealthReport()
+	if !report.Healthy || report.Health != nil || len(report.Children) != 2 {
+		t.Fatalf("unexpected report %+v before any heart beat", report)
+	}
+	childReport := report.Children["child"]
+	if childReport == nil || childReport.Children["dead"] == nil || !childReport.Healthy {
+		t.Fatalf("unexpected report %+v of child supervisor", childReport)
+	}
+	if aliveReport := report.Children["alive"]; aliveReport == nil || aliveReport.Children != nil || aliveReport.Health == nil {
+		t.Errorf("unexpected report %+v of child that is not a supervisor", aliveReport)
+	}
+
+	for i := 0; i < 2; i++ {
+		child.updateChildrenHealth(map[string]heartbeatRespStatus{"dead": notYetResponded}, nil, time.Now())
+	}
+	report = root.TreeHealthReport()
+	childReport = report.Children["child"]
+	if report.Healthy || childReport.Healthy || childReport.Children["dead"].Healthy {
+		t.Errorf("unhealthy grandchild is not reported up the tree. report=%+v, child report=%+v", report, childReport)
+	}
+	if misses := childReport.Children["dead"].Health.ConsecutiveMisses; misses != 2 {
+		t.Errorf("grandchild missed %v heart beats, expected 2", misses)
+	}
+	if !report.Children["alive"].Healthy {
+		t.Errorf("healthy child is reported as unhealthy")
+	}
+
+	// cycles are reported once instead of being walked forever
+	child.RemoveChild("dead")
+	child.AddChild(root)
+	report = root.TreeHealthReport()
+	cycleReport := report.Children["child"].Children["root"]
+	if cycleReport == nil || !cycleReport.Cycle || cycleReport.Children != nil {
+		t.Errorf("unexpected report %+v of supervisor in cycle", cycleReport)
+	}
+}
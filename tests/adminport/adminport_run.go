@@ -297,10 +297,14 @@ func testCreateReplication() (string, string, error) {
 		return "", "", err
 	}
 
-	replicationId, err := rm.DecodeCreateReplicationResponse(response)
+	result, err := rm.DecodeCreateReplicationResponse(response)
+	if err != nil {
+		return "", "", err
+	}
+	replicationId := result.ReplicationId
 	escapedReplId := url.QueryEscape(replicationId)
 
-	fmt.Printf("id=%v, eid=%v\n", replicationId, escapedReplId)
+	fmt.Printf("id=%v, eid=%v, warnings=%v\n", replicationId, escapedReplId, result.Warnings)
 
 	fmt.Println("Waiting for replication to finish starting")
 	time.Sleep(30 * time.Second)
@@ -159,11 +159,11 @@ func setup() error {
 	buckerSettings_svc := metadata_svc.NewBucketSettingsService(metakv_svc, top_svc, nil)
 	internalSettings_svc := metadata_svc.NewInternalSettingsSvc(metakv_svc, nil)
 
-	replication_manager.StartReplicationManager(options.source_kv_host, base.AdminportNumber,
+	replication_manager.StartReplicationManager(options.source_kv_host, base.AdminportNumber, nil,
 		repl_spec_svc, remote_cluster_svc,
 		cluster_info_svc, top_svc, metadata_svc.NewReplicationSettingsSvc(metakv_svc, nil),
 		metadata_svc.NewCheckpointsService(metakv_svc, nil), service_impl.NewCAPIService(cluster_info_svc, nil),
-		audit_svc, uilog_svc, processSetting_svc, buckerSettings_svc, internalSettings_svc)
+		audit_svc, uilog_svc, processSetting_svc, buckerSettings_svc, internalSettings_svc, metakv_svc)
 
 	logger.Info("Finish setup")
 	return nil
@@ -191,7 +191,7 @@ func test() {
 
 	defer testcommon.DeleteTestRemoteCluster(replication_manager.RemoteClusterService(), options.remoteName)
 
-	topic, errorsMap, err := replication_manager.CreateReplication(false, options.source_bucket, options.remoteName, options.target_bucket, settings, &base.RealUserId{})
+	topic, _, errorsMap, _, err := replication_manager.CreateReplication(false, options.source_bucket, options.remoteName, options.target_bucket, settings, &base.RealUserId{})
 	if err != nil {
 		fail(fmt.Sprintf("%v", err))
 	} else if len(errorsMap) != 0 {
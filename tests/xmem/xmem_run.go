@@ -20,6 +20,7 @@ import (
 	"github.com/couchbase/goxdcr/base"
 	"github.com/couchbase/goxdcr/log"
 	parts "github.com/couchbase/goxdcr/parts"
+	"github.com/couchbase/goxdcr/simple_utils"
 	utils "github.com/couchbase/goxdcr/utils"
 	"net/http"
 	"os"
@@ -74,7 +75,7 @@ func usage() {
 func setup() (err error) {
 
 	logger.Info("Start Testing Xmem...")
-	logger.Infof("target_clusterAddr=%s, username=%s, password=%s\n", options.target_cluster_addr, options.username, options.password)
+	logger.Infof("target_clusterAddr=%s, username=%s, password=%s\n", options.target_cluster_addr, options.username, simple_utils.RedactedValue)
 	logger.Info("Done with parsing the arguments")
 
 	//flush the target bucket
@@ -303,7 +304,7 @@ func getConnectStr(clusterAddr string, poolName string, bucketName string, usern
 			return addrs[0], nil
 		}
 	} else {
-		panic(fmt.Sprintf("failed to instantiate target bucket - %v, err=%v", c, err))
+		panic(fmt.Sprintf("failed to instantiate target bucket - %v, err=%v", utils.UrlForLog(c), err))
 	}
 	return "", err
 }
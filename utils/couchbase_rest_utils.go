@@ -2,6 +2,7 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
@@ -120,8 +121,13 @@ func GetSSLPort(hostAddr string, logger *log.CommonLogger) (uint16, error, bool)
 }
 
 func GetClusterInfo(hostAddr, path, username, password string, certificate []byte, sanInCertificate bool, logger *log.CommonLogger) (map[string]interface{}, error) {
+	return GetClusterInfoWithContext(context.Background(), hostAddr, path, username, password, certificate, sanInCertificate, logger)
+}
+
+// same as GetClusterInfo, except that the rest call is aborted when ctx is cancelled
+func GetClusterInfoWithContext(ctx context.Context, hostAddr, path, username, password string, certificate []byte, sanInCertificate bool, logger *log.CommonLogger) (map[string]interface{}, error) {
 	clusterInfo := make(map[string]interface{})
-	err, statusCode := QueryRestApiWithAuth(hostAddr, path, false, username, password, certificate, sanInCertificate, base.MethodGet, "", nil, 0, &clusterInfo, nil, false, logger)
+	err, statusCode := QueryRestApiWithAuthAndContext(ctx, hostAddr, path, false, username, password, certificate, sanInCertificate, base.MethodGet, "", nil, 0, &clusterInfo, nil, false, logger)
 	if err != nil || statusCode != http.StatusOK {
 		return nil, fmt.Errorf("Failed on calling host=%v, path=%v, err=%v, statusCode=%v", hostAddr, path, err, statusCode)
 	}
@@ -157,8 +163,20 @@ func GetNodeListWithMinInfo(hostAddr, username, password string, certificate []b
 // get bucket info
 // a specialized case of GetClusterInfo
 func GetBucketInfo(hostAddr, bucketName, username, password string, certificate []byte, sanInCertificate bool, logger *log.CommonLogger) (map[string]interface{}, error) {
+	return GetBucketInfoWithContext(context.Background(), hostAddr, bucketName, username, password, certificate, sanInCertificate, logger)
+}
+
+// same as GetBucketInfo, except that the rest call is aborted when ctx is cancelled
+func GetBucketInfoWithContext(ctx context.Context, hostAddr, bucketName, username, password string, certificate []byte, sanInCertificate bool, logger *log.CommonLogger) (map[string]interface{}, error) {
+	return getBucketInfo(ctx, hostAddr, bucketName, username, password, certificate, sanInCertificate, nil, logger)
+}
+
+// the rest call is made with client, and its connection is kept alive for reuse, when client is not nil.
+// otherwise a new client is created for the call
+func getBucketInfo(ctx context.Context, hostAddr, bucketName, username, password string, certificate []byte, sanInCertificate bool,
+	client *http.Client, logger *log.CommonLogger) (map[string]interface{}, error) {
 	bucketInfo := make(map[string]interface{})
-	err, statusCode := QueryRestApiWithAuth(hostAddr, base.DefaultPoolBucketsPath+bucketName, false, username, password, certificate, sanInCertificate, base.MethodGet, "", nil, 0, &bucketInfo, nil, false, logger)
+	err, statusCode := QueryRestApiWithAuthAndContext(ctx, hostAddr, base.DefaultPoolBucketsPath+bucketName, false, username, password, certificate, sanInCertificate, base.MethodGet, "", nil, 0, &bucketInfo, client, client != nil, logger)
 	if err == nil && statusCode == http.StatusOK {
 		return bucketInfo, nil
 	}
@@ -170,10 +188,46 @@ func GetBucketInfo(hostAddr, bucketName, username, password string, certificate
 	}
 }
 
+// get the offset of the clock of the server at hostAddr from the local clock, based on the Date header of its rest response.
+// the server time is assumed to be taken half way through the round trip of the rest call.
+// the Date header has a granularity of one second, so the offset is accurate to about a second
+func GetServerClockOffsetWithContext(ctx context.Context, hostAddr, username, password string, certificate []byte, sanInCertificate bool, logger *log.CommonLogger) (time.Duration, error) {
+	client, req, err := prepareForRestCall(hostAddr, base.PoolsPath, false, username, password, certificate, sanInCertificate, base.MethodGet, "", nil, nil, logger)
+	if err != nil {
+		return 0, err
+	}
+	client.Timeout = base.ShortHttpTimeout
+
+	start_time := time.Now()
+	res, err := client.Do(req.WithContext(ctx))
+	round_trip_time := time.Since(start_time)
+	cleanupAfterRestCall(false, err, client, logger)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+
+	dateHeader := res.Header.Get(base.DateHeaderKey)
+	if dateHeader == "" {
+		return 0, fmt.Errorf("No %v header in response from %v", base.DateHeaderKey, hostAddr)
+	}
+	serverTime, err := http.ParseTime(dateHeader)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %v header %v in response from %v. err=%v", base.DateHeaderKey, dateHeader, hostAddr, err)
+	}
+
+	return serverTime.Sub(start_time.Add(round_trip_time / 2)), nil
+}
+
 // get bucket uuid
 // use base.BPath to get less info than the regular base.DefaultPoolBucketsPath
 func RemoteBucketUUID(hostAddr, bucketName, username, password string, certificate []byte, sanInCertificate bool, logger *log.CommonLogger) (string, error) {
-	bucketInfo, err := GetClusterInfo(hostAddr, base.BPath+bucketName, username, password, certificate, sanInCertificate, logger)
+	return RemoteBucketUUIDWithContext(context.Background(), hostAddr, bucketName, username, password, certificate, sanInCertificate, logger)
+}
+
+// same as RemoteBucketUUID, except that the rest call is aborted when ctx is cancelled
+func RemoteBucketUUIDWithContext(ctx context.Context, hostAddr, bucketName, username, password string, certificate []byte, sanInCertificate bool, logger *log.CommonLogger) (string, error) {
+	bucketInfo, err := GetClusterInfoWithContext(ctx, hostAddr, base.BPath+bucketName, username, password, certificate, sanInCertificate, logger)
 	if err != nil {
 		return "", err
 	}
@@ -181,6 +235,75 @@ func RemoteBucketUUID(hostAddr, bucketName, username, password string, certifica
 	return GetBucketUuidFromBucketInfo(bucketName, bucketInfo, logger)
 }
 
+// get the uuid of a bucket in local cluster, with a rest call authenticated through cbauth.
+// the rest call is aborted when ctx is cancelled. NonExistentBucketError is returned when the bucket does not exist
+func LocalBucketUUIDWithContext(ctx context.Context, local_connStr string, bucketName string) (string, error) {
+	bucketInfo := make(map[string]interface{})
+	err, statusCode := QueryRestApiWithContext(ctx, local_connStr, base.BPath+bucketName, false, base.MethodGet, "", nil, 0, &bucketInfo, logger_utils)
+	if statusCode == http.StatusNotFound {
+		return "", NonExistentBucketError
+	}
+	if err != nil || statusCode != http.StatusOK {
+		return "", fmt.Errorf("Failed on calling host=%v, path=%v, err=%v, statusCode=%v", local_connStr, base.BPath+bucketName, err, statusCode)
+	}
+
+	return GetBucketUuidFromBucketInfo(bucketName, bucketInfo, logger_utils)
+}
+
+// same as RemoteBucketUUID, except that NonExistentBucketError is returned when, and only when, the remote cluster
+// reports that the bucket does not exist. lookups that fail with other errors, e.g., connection errors, are retried
+// up to maxAttempts times in total, with the delay between attempts starting at baseBackoff and doubling after each attempt.
+// when client is not nil, e.g., a pooled client of the remote cluster, lookups are made with it and its connections are reused
+func RemoteBucketUUIDWithRetry(ctx context.Context, hostAddr, bucketName, username, password string, certificate []byte, sanInCertificate bool,
+	client *http.Client, maxAttempts int, baseBackoff time.Duration, logger *log.CommonLogger) (string, error) {
+	var bucketUUID string
+	err := retryRemoteBucketLookup(ctx, bucketName, maxAttempts, baseBackoff, logger, func() error {
+		bucketInfo := make(map[string]interface{})
+		err, statusCode := QueryRestApiWithAuthAndContext(ctx, hostAddr, base.BPath+bucketName, false, username, password, certificate, sanInCertificate, base.MethodGet, "", nil, 0, &bucketInfo, client, client != nil, logger)
+		if statusCode == http.StatusNotFound {
+			return NonExistentBucketError
+		}
+		if err != nil || statusCode != http.StatusOK {
+			return fmt.Errorf("Failed on calling host=%v, path=%v, err=%v, statusCode=%v", hostAddr, base.BPath+bucketName, err, statusCode)
+		}
+		bucketUUID, err = GetBucketUuidFromBucketInfo(bucketName, bucketInfo, logger)
+		return err
+	})
+	return bucketUUID, err
+}
+
+// same as GetBucketInfoWithContext, except that lookups that fail with errors other than NonExistentBucketError
+// are retried in the same way as in RemoteBucketUUIDWithRetry
+func GetBucketInfoWithRetry(ctx context.Context, hostAddr, bucketName, username, password string, certificate []byte, sanInCertificate bool,
+	client *http.Client, maxAttempts int, baseBackoff time.Duration, logger *log.CommonLogger) (map[string]interface{}, error) {
+	var bucketInfo map[string]interface{}
+	err := retryRemoteBucketLookup(ctx, bucketName, maxAttempts, baseBackoff, logger, func() error {
+		var err error
+		bucketInfo, err = getBucketInfo(ctx, hostAddr, bucketName, username, password, certificate, sanInCertificate, client, logger)
+		return err
+	})
+	return bucketInfo, err
+}
+
+// calls lookup until it succeeds, returns NonExistentBucketError, has been called maxAttempts times, or ctx is cancelled
+func retryRemoteBucketLookup(ctx context.Context, bucketName string, maxAttempts int, baseBackoff time.Duration, logger *log.CommonLogger, lookup func() error) error {
+	backoff := baseBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = lookup()
+		if err == nil || err == NonExistentBucketError || attempt >= maxAttempts {
+			return err
+		}
+		logger.Infof("Lookup of remote bucket %v failed. Retrying in %v. attempt=%v, err=%v\n", bucketName, backoff, attempt, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff = backoff * 2
+	}
+}
+
 func GetBucketUuidFromBucketInfo(bucketName string, bucketInfo map[string]interface{}, logger *log.CommonLogger) (string, error) {
 	bucketUUID := ""
 	bucketUUIDObj, ok := bucketInfo[base.BucketUUIDKey]
@@ -300,7 +423,22 @@ func QueryRestApi(baseURL string,
 	timeout time.Duration,
 	out interface{},
 	logger *log.CommonLogger) (error, int) {
-	return QueryRestApiWithAuth(baseURL, path, preservePathEncoding, "", "", nil, false, httpCommand, contentType, body, timeout, out, nil, false, logger)
+	return QueryRestApiWithContext(context.Background(), baseURL, path, preservePathEncoding, httpCommand, contentType, body, timeout, out, logger)
+}
+
+// same as QueryRestApi, except that the rest call is aborted when ctx is cancelled or its deadline is exceeded,
+// which may be earlier than timeout. as with QueryRestApi, timeout of 0 means base.DefaultHttpTimeout
+func QueryRestApiWithContext(ctx context.Context,
+	baseURL string,
+	path string,
+	preservePathEncoding bool,
+	httpCommand string,
+	contentType string,
+	body []byte,
+	timeout time.Duration,
+	out interface{},
+	logger *log.CommonLogger) (error, int) {
+	return QueryRestApiWithAuthAndContext(ctx, baseURL, path, preservePathEncoding, "", "", nil, false, httpCommand, contentType, body, timeout, out, nil, false, logger)
 }
 
 func EnforcePrefix(prefix string, str string) string {
@@ -320,6 +458,27 @@ func RemovePrefix(prefix string, str string) string {
 //if username and password passed in is "", assume it is local rest call,
 //then call cbauth to add authenticate information
 func QueryRestApiWithAuth(
+	baseURL string,
+	path string,
+	preservePathEncoding bool,
+	username string,
+	password string,
+	certificate []byte,
+	san_in_certificate bool,
+	httpCommand string,
+	contentType string,
+	body []byte,
+	timeout time.Duration,
+	out interface{},
+	client *http.Client,
+	keep_client_alive bool,
+	logger *log.CommonLogger) (error, int) {
+	return QueryRestApiWithAuthAndContext(context.Background(), baseURL, path, preservePathEncoding, username, password, certificate, san_in_certificate, httpCommand, contentType, body, timeout, out, client, keep_client_alive, logger)
+}
+
+// same as QueryRestApiWithAuth, except that the rest call is aborted when ctx is cancelled
+func QueryRestApiWithAuthAndContext(
+	ctx context.Context,
 	baseURL string,
 	path string,
 	preservePathEncoding bool,
@@ -340,7 +499,7 @@ func QueryRestApiWithAuth(
 		return err, 0
 	}
 
-	err, statusCode := doRestCall(req, timeout, out, http_client, logger)
+	err, statusCode := doRestCall(req.WithContext(ctx), timeout, out, http_client, logger)
 	cleanupAfterRestCall(keep_client_alive, err, http_client, logger)
 
 	return err, statusCode
@@ -475,7 +634,7 @@ func InvokeRestWithRetryWithAuth(baseURL string,
 			break
 		}
 
-		logger.Infof("Received error when making rest call. baseURL=%v, path=%v, ret_err=%v, statusCode=%v, num_retry=%v\n", baseURL, path, ret_err, statusCode, i)
+		logger.Infof("Received error when making rest call. baseURL=%v, path=%v, ret_err=%v, statusCode=%v, num_retry=%v\n", UrlForLog(baseURL), path, ret_err, statusCode, i)
 
 		//cleanup the idle connection if the error is serious network error
 		cleanupAfterRestCall(true, ret_err, http_client, logger)
@@ -517,6 +676,21 @@ func GetHttpClient(certificate []byte, san_in_certificate bool, ssl_con_str stri
 	return client, nil
 }
 
+// returns a http client that keeps up to poolSize idle connections per host, so that connections can be reused
+// across rest calls. it connects over https with certificate, in the same way as GetHttpClient, when certificate is not empty.
+// the client is safe for concurrent use as long as callers do not change its timeout
+func GetPooledHttpClient(certificate []byte, san_in_certificate bool, ssl_con_str string, poolSize int, logger *log.CommonLogger) (*http.Client, error) {
+	tr := &http.Transport{Dial: base.DialTCPWithTimeout, MaxIdleConnsPerHost: poolSize}
+	if len(certificate) != 0 {
+		tlsClient, err := GetHttpClient(certificate, san_in_certificate, ssl_con_str, logger)
+		if err != nil {
+			return nil, err
+		}
+		tr.TLSClientConfig = tlsClient.Transport.(*http.Transport).TLSClientConfig
+	}
+	return &http.Client{Transport: tr, Timeout: base.DefaultHttpTimeout}, nil
+}
+
 func maybeAddAuth(req *http.Request, username string, password string) {
 	if username != "" && password != "" {
 		req.Header.Set("Authorization", "Basic "+
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"errors"
 	"expvar"
 	"fmt"
@@ -10,9 +11,11 @@ import (
 	base "github.com/couchbase/goxdcr/base"
 	"github.com/couchbase/goxdcr/log"
 	"github.com/couchbase/goxdcr/simple_utils"
+	"net"
 	"net/url"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"unicode/utf8"
@@ -107,6 +110,25 @@ func LocalBucket(localConnectStr, bucketName string) (*couchbase.Bucket, error)
 	return bucket, err
 }
 
+// Get bucket in remote cluster using bucket password
+func RemoteBucket(hostAddr, bucketName, bucketPassword string) (*couchbase.Bucket, error) {
+	remoteURL := fmt.Sprintf("http://%s:%s@%s", url.QueryEscape(bucketName), url.QueryEscape(bucketPassword), hostAddr)
+	client, err := couchbase.Connect(remoteURL)
+	if err != nil {
+		return nil, NewEnhancedError(fmt.Sprintf("Error connecting to couchbase. url=%v", UrlForLog(remoteURL)), err)
+	}
+	pool, err := client.GetPool("default")
+	if err != nil {
+		return nil, err
+	}
+
+	bucket, err := pool.GetBucket(bucketName)
+	if err != nil {
+		return nil, NewEnhancedError(fmt.Sprintf("Error getting bucket, %v, from pool.", bucketName), err)
+	}
+	return bucket, nil
+}
+
 func UnwrapError(infos map[string]interface{}) (err error) {
 	if infos != nil && len(infos) > 0 {
 		err = infos["error"].(error)
@@ -124,6 +146,14 @@ func GetHostAddr(hostName string, port uint16) string {
 	return hostName + base.UrlPortNumberDelimiter + strconv.FormatInt(int64(port), base.ParseIntBase)
 }
 
+// validates that the address to bind a server to is either an ip address or localhost
+func ValidateBindAddress(bindAddr string) error {
+	if bindAddr == base.LocalHostNameStr || net.ParseIP(bindAddr) != nil {
+		return nil
+	}
+	return fmt.Errorf("Invalid bind address %q. It needs to be an IP address or %v", bindAddr, base.LocalHostNameStr)
+}
+
 // extract host name from hostAddr, which is in the form of hostName:port
 func GetHostName(hostAddr string) string {
 	return strings.Split(hostAddr, base.UrlPortNumberDelimiter)[0]
@@ -139,6 +169,47 @@ func GetPortNumber(hostAddr string) (uint16, error) {
 	}
 }
 
+// normalize the hostname of a remote cluster into the form of hostName:port, which is what
+// GetHostName and GetPortNumber expect.
+// http:// or https:// scheme and trailing slashes are removed, and default port is appended when port is not specified
+func NormalizeHostName(hostAddr string) (string, error) {
+	normalized := strings.TrimSpace(hostAddr)
+
+	if index := strings.Index(normalized, base.UrlSchemeDelimiter); index >= 0 {
+		scheme := strings.ToLower(normalized[:index])
+		if scheme != "http" && scheme != "https" {
+			return "", fmt.Errorf("Invalid hostname \"%v\". Scheme \"%v\" is not supported", hostAddr, scheme)
+		}
+		normalized = normalized[index+len(base.UrlSchemeDelimiter):]
+	}
+
+	normalized = strings.TrimRight(normalized, base.UrlDelimiter)
+	if len(normalized) == 0 {
+		return "", fmt.Errorf("Invalid hostname \"%v\". Hostname cannot be empty", hostAddr)
+	}
+	if strings.Contains(normalized, base.UrlDelimiter) {
+		return "", fmt.Errorf("Invalid hostname \"%v\". Hostname cannot contain a path", hostAddr)
+	}
+
+	parts := strings.Split(normalized, base.UrlPortNumberDelimiter)
+	switch len(parts) {
+	case 1:
+		normalized = GetHostAddr(normalized, base.DefaultAdminPort)
+	case 2:
+		if len(parts[0]) == 0 {
+			return "", fmt.Errorf("Invalid hostname \"%v\". Hostname cannot be empty", hostAddr)
+		}
+		port, err := strconv.ParseUint(parts[1], 10, 16)
+		if err != nil || port == 0 {
+			return "", fmt.Errorf("Invalid hostname \"%v\". Port \"%v\" is not a valid port number", hostAddr, parts[1])
+		}
+	default:
+		return "", fmt.Errorf("Invalid hostname \"%v\". Hostname should be in the form of host:port", hostAddr)
+	}
+
+	return normalized, nil
+}
+
 func GetMapFromExpvarMap(expvarMap *expvar.Map) map[string]interface{} {
 	regMap := make(map[string]interface{})
 
@@ -215,15 +286,7 @@ func EncodeMapIntoByteArray(data map[string]interface{}) ([]byte, error) {
 }
 
 func UrlForLog(urlStr string) string {
-	result, err := url.Parse(urlStr)
-	if err == nil {
-		if result.User != nil {
-			result.User = url.UserPassword(result.User.Username(), "xxxx")
-		}
-		return result.String()
-	} else {
-		return urlStr
-	}
+	return simple_utils.RedactConnectionStr(urlStr)
 }
 
 func GetMatchedKeys(expression string, keys []string) (map[string][][]int, error) {
@@ -321,15 +384,7 @@ func InvalidRuneIndexErrorMessage(key string, index int) string {
 }
 
 func LocalBucketUUID(local_connStr string, bucketName string) (string, error) {
-	local_default_pool, err := LocalPool(local_connStr)
-	if err != nil {
-		return "", err
-	}
-	bucket, ok := local_default_pool.BucketMap[bucketName]
-	if !ok {
-		return "", NonExistentBucketError
-	}
-	return bucket.UUID, nil
+	return LocalBucketUUIDWithContext(context.Background(), local_connStr, bucketName)
 }
 
 func ReplicationStatusNotFoundError(topic string) error {
@@ -346,7 +401,7 @@ func GetMemcachedConnection(serverAddr, bucketName string, logger *log.CommonLog
 		panic("serverAddr is empty")
 	}
 	username, password, err := cbauth.GetMemcachedServiceAuth(serverAddr)
-	logger.Debugf("memcached auth: username=%v, password=%v, err=%v\n", username, password, err)
+	logger.Debugf("memcached auth: username=%v, password=%v, err=%v\n", username, simple_utils.RedactedValue, err)
 	if err != nil {
 		return nil, err
 	}
@@ -507,3 +562,35 @@ func GetConflictResolutionTypeFromBucketInfo(bucketName string, bucketInfo map[s
 	}
 	return conflictResolutionType, nil
 }
+
+// returns the settings that differ between oldSettings and newSettings, with [old value, new value] as values.
+// a setting that exists in only one of the two maps is reported with nil as its value in the other map.
+// values, including nested maps and slices, are compared by deep equality, so the result does not depend
+// on map iteration order
+func DiffSettings(oldSettings, newSettings map[string]interface{}) map[string][2]interface{} {
+	diff := make(map[string][2]interface{})
+	for key, oldValue := range oldSettings {
+		newValue, ok := newSettings[key]
+		if !ok {
+			diff[key] = [2]interface{}{oldValue, nil}
+		} else if !reflect.DeepEqual(oldValue, newValue) {
+			diff[key] = [2]interface{}{oldValue, newValue}
+		}
+	}
+	for key, newValue := range newSettings {
+		if _, ok := oldSettings[key]; !ok {
+			diff[key] = [2]interface{}{nil, newValue}
+		}
+	}
+	return diff
+}
+
+// returns the keys of a settings diff in sorted order, for deterministic presentation of the diff
+func SortedSettingsDiffKeys(diff map[string][2]interface{}) []string {
+	keys := make([]string, 0, len(diff))
+	for key, _ := range diff {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,202 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	base "github.com/couchbase/goxdcr/base"
+	"github.com/couchbase/goxdcr/log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNormalizeHostName(t *testing.T) {
+	validInputs := map[string]string{
+		"host":                  "host:8091",
+		"host:9000":             "host:9000",
+		"127.0.0.1":             "127.0.0.1:8091",
+		" host:9000 ":           "host:9000",
+		"host/":                 "host:8091",
+		"host:9000/":            "host:9000",
+		"host:9000//":           "host:9000",
+		"http://host":           "host:8091",
+		"http://host:9000":      "host:9000",
+		"http://host:9000/":     "host:9000",
+		"HTTPS://host:18091":    "host:18091",
+		"https://10.1.2.3:9000": "10.1.2.3:9000",
+	}
+	for input, expected := range validInputs {
+		normalized, err := NormalizeHostName(input)
+		if err != nil {
+			t.Errorf("Unexpected error for hostname %q. err=%v", input, err)
+		} else if normalized != expected {
+			t.Errorf("Hostname %q was normalized to %q, expected %q", input, normalized, expected)
+		}
+	}
+
+	invalidInputs := []string{
+		"",
+		"   ",
+		"/",
+		"http://",
+		":9000",
+		"host:",
+		"host:abc",
+		"host:0",
+		"host:70000",
+		"host:9000:9001",
+		"host:9000/pools",
+		"couchbase://host",
+		"ftp://host:21",
+	}
+	for _, input := range invalidInputs {
+		normalized, err := NormalizeHostName(input)
+		if err == nil {
+			t.Errorf("Expected error for hostname %q, got %q", input, normalized)
+		}
+	}
+}
+
+func TestDiffSettings(t *testing.T) {
+	oldSettings := map[string]interface{}{
+		"unchanged":        1,
+		"changed":          "old",
+		"removed":          true,
+		"unchanged_nested": map[string]interface{}{"a": []int{1, 2}, "b": "x"},
+		"changed_nested":   map[string]interface{}{"a": []int{1, 2}},
+	}
+	newSettings := map[string]interface{}{
+		"unchanged":        1,
+		"changed":          "new",
+		"added":            10,
+		"unchanged_nested": map[string]interface{}{"b": "x", "a": []int{1, 2}},
+		"changed_nested":   map[string]interface{}{"a": []int{2, 1}},
+	}
+
+	diff := DiffSettings(oldSettings, newSettings)
+
+	expected := map[string][2]interface{}{
+		"changed":        {"old", "new"},
+		"removed":        {true, nil},
+		"added":          {nil, 10},
+		"changed_nested": {map[string]interface{}{"a": []int{1, 2}}, map[string]interface{}{"a": []int{2, 1}}},
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Unexpected diff %v, expected %v", diff, expected)
+	}
+
+	keys := SortedSettingsDiffKeys(diff)
+	expectedKeys := []string{"added", "changed", "changed_nested", "removed"}
+	if !reflect.DeepEqual(keys, expectedKeys) {
+		t.Errorf("Unexpected diff keys %v, expected %v", keys, expectedKeys)
+	}
+
+	if diff = DiffSettings(oldSettings, oldSettings); len(diff) != 0 {
+		t.Errorf("Expected no diff between identical settings, got %v", diff)
+	}
+
+	if diff = DiffSettings(nil, nil); len(diff) != 0 {
+		t.Errorf("Expected no diff between nil settings, got %v", diff)
+	}
+}
+
+func TestValidateBindAddress(t *testing.T) {
+	validInputs := []string{"127.0.0.1", "0.0.0.0", "10.1.2.3", "::1", "::", "fe80::1", "localhost"}
+	for _, input := range validInputs {
+		if err := ValidateBindAddress(input); err != nil {
+			t.Errorf("Unexpected error for bind address %q. err=%v", input, err)
+		}
+	}
+
+	invalidInputs := []string{"", "host", "127.0.0.1:9998", "[::1]", "256.1.2.3", " 127.0.0.1"}
+	for _, input := range invalidInputs {
+		if err := ValidateBindAddress(input); err == nil {
+			t.Errorf("Expected error for bind address %q", input)
+		}
+	}
+}
+
+func TestRetryRemoteBucketLookup(t *testing.T) {
+	logger := log.NewLogger("UtilsTest", log.DefaultLoggerContext)
+	transientErr := errors.New("connection refused")
+
+	// transient errors are retried until lookup succeeds
+	attempts := 0
+	err := retryRemoteBucketLookup(context.Background(), "bucket", 5, time.Millisecond, logger, func() error {
+		attempts++
+		if attempts < 3 {
+			return transientErr
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Errorf("lookup returned %v after %v attempts, expected success after 3 attempts", err, attempts)
+	}
+
+	// the bucket not existing is not retried
+	attempts = 0
+	err = retryRemoteBucketLookup(context.Background(), "bucket", 5, time.Millisecond, logger, func() error {
+		attempts++
+		return NonExistentBucketError
+	})
+	if err != NonExistentBucketError || attempts != 1 {
+		t.Errorf("lookup returned %v after %v attempts, expected %v after 1 attempt", err, attempts, NonExistentBucketError)
+	}
+
+	// the last error is returned after max attempts, and is never turned into NonExistentBucketError
+	attempts = 0
+	err = retryRemoteBucketLookup(context.Background(), "bucket", 4, time.Millisecond, logger, func() error {
+		attempts++
+		return transientErr
+	})
+	if err != transientErr || attempts != 4 {
+		t.Errorf("lookup returned %v after %v attempts, expected %v after 4 attempts", err, attempts, transientErr)
+	}
+
+	// no more retries once ctx is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts = 0
+	err = retryRemoteBucketLookup(ctx, "bucket", 5, time.Hour, logger, func() error {
+		attempts++
+		cancel()
+		return transientErr
+	})
+	if err != transientErr || attempts != 1 {
+		t.Errorf("lookup returned %v after %v attempts, expected %v after 1 attempt", err, attempts, transientErr)
+	}
+}
+
+func TestRemoteBucketUUIDWithContext(t *testing.T) {
+	logger := log.NewLogger("UtilsTest", log.DefaultLoggerContext)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case base.BPath + "bucket":
+			w.Write([]byte(`{"uuid":"bucketUUID"}`))
+		case base.BPath + "hung":
+			// a hung remote node, which never responds
+			<-r.Context().Done()
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	hostAddr := server.Listener.Addr().String()
+
+	bucketUUID, err := RemoteBucketUUIDWithContext(context.Background(), hostAddr, "bucket", "user", "password", nil, false, logger)
+	if err != nil || bucketUUID != "bucketUUID" {
+		t.Errorf("lookup returned (%v, %v), expected bucketUUID", bucketUUID, err)
+	}
+
+	// the rest call is aborted once the deadline of ctx is exceeded
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start_time := time.Now()
+	if _, err = RemoteBucketUUIDWithContext(ctx, hostAddr, "hung", "user", "password", nil, false, logger); err == nil {
+		t.Errorf("expected error looking up bucket on hung node")
+	}
+	if elapsed := time.Since(start_time); elapsed > 5*time.Second {
+		t.Errorf("lookup on hung node took %v after ctx deadline had been exceeded", elapsed)
+	}
+}